@@ -24,9 +24,9 @@
 //     config/crd/runtimeclass-adopted.yaml).
 //   - The pod's metadata.annotations MUST contain
 //     `katamaran.io/adopted-sandbox-id` pointing at the sandbox id
-//     the destination katamaran job wrote (defaults to
-//     `katamaran-dest`, the well-known sandbox id from the dest job
-//     template).
+//     the destination katamaran job wrote (the per-migration
+//     `katamaran-dest-<id>` the dest reported in KATAMARAN_DEST_READY;
+//     defaults to the legacy `katamaran-dest`).
 //   - katamaran-mgr's createAdoptionPod is responsible for stamping
 //     both fields on the adoption pod.
 //
@@ -70,8 +70,8 @@ const (
 
 	// adoptedSandboxAnnotation lets the controller pin which sandbox
 	// id (under adoptedCgroupRoot) this pod adopts. Defaults to
-	// `katamaran-dest` (the dest job template's well-known name) when
-	// absent.
+	// `katamaran-dest` (the dest's sandbox before per-migration ids)
+	// when absent.
 	adoptedSandboxAnnotation = "katamaran.io/adopted-sandbox-id"
 
 	defaultAdoptedSandboxID = "katamaran-dest"
@@ -0,0 +1,182 @@
+// katamaran-agent is the long-lived per-node migration agent. It serves
+// the Agent gRPC API (internal/agent/agentpb) over mutual TLS and runs
+// the source and destination sides of live migrations in-process, so
+// the orchestrator does not need a pair of privileged Jobs per
+// migration: no image pull, no module-loading init container, no pod
+// scheduling latency and nothing left for ttlSecondsAfterFinished.
+//
+// It runs from deploy/agent.yaml as a privileged, hostNetwork, hostPID
+// DaemonSet with the same host mounts the migration Jobs get. Controllers
+// started with --agent-tls-dir (katamaran-mgr) dial it on each node's
+// InternalIP and fall back to Jobs for whatever it cannot run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/maci0/katamaran/internal/agent"
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/buildinfo"
+	"github.com/maci0/katamaran/internal/logging"
+)
+
+// recoverUnaryInterceptor catches panics in gRPC handlers, logs them with a
+// stack trace, and returns Internal so the peer sees a clean error instead
+// of a torn TCP connection. A handler panic would otherwise kill every
+// migration the agent is running.
+func recoverUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("gRPC handler panic", "method", info.FullMethod, "panic", rec, "stack", string(debug.Stack()))
+			err = status.Errorf(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, `katamaran-agent — per-node migration agent for katamaran
+
+Usage:
+  katamaran-agent [flags]
+  katamaran-agent --version
+  katamaran-agent --help
+
+Flags:
+  --listen string        TCP listen address for the gRPC server (default ":%d")
+  --tls-dir string       Directory with tls.crt, tls.key and ca.crt; clients must present
+                         a katamaran-orchestrator certificate signed by ca.crt
+                         (default "/etc/katamaran/agent-tls")
+  --log-format string    Log output format: 'text' or 'json' (default "json")
+  --log-level string     Log level: 'debug', 'info', 'warn', or 'error' (default "info")
+
+Other:
+  -v, --version          Show version and exit
+  -h, --help             Show this help and exit
+
+Exit codes:
+  0   Clean shutdown (signal received)
+  1   Runtime error
+  2   Argument or configuration error
+
+Examples:
+  # Run with defaults (as deploy/agent.yaml does)
+  katamaran-agent
+
+  # Custom port and certificate directory, text logs
+  katamaran-agent --listen :9500 --tls-dir /tmp/agent-tls --log-format text
+`, agent.DefaultPort)
+}
+
+func main() {
+	fs := flag.NewFlagSet("katamaran-agent", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	listen := fs.String("listen", fmt.Sprintf(":%d", agent.DefaultPort), "TCP listen address for the gRPC server")
+	tlsDir := fs.String("tls-dir", "/etc/katamaran/agent-tls", "Directory with tls.crt, tls.key and ca.crt")
+	logFormat := fs.String("log-format", "json", "Log output format: 'text' or 'json'")
+	logLevel := fs.String("log-level", "info", "Log level: 'debug', 'info', 'warn', or 'error'")
+	showVersion := fs.Bool("version", false, "Show version and exit")
+	showVersionShort := fs.Bool("v", false, "")
+	helpFlag := fs.Bool("help", false, "")
+	helpFlagShort := fs.Bool("h", false, "")
+	fs.Usage = func() { printUsage(os.Stderr) }
+
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if *helpFlag || *helpFlagShort {
+		printUsage(os.Stdout)
+		return
+	}
+	if *showVersion || *showVersionShort {
+		fmt.Fprintf(os.Stdout, "katamaran-agent %s\n", buildinfo.Version)
+		return
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: unexpected arguments: %s\n\n", strings.Join(fs.Args(), " "))
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if *listen == "" {
+		fmt.Fprintf(os.Stderr, "Error: --listen must not be empty\n\n")
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if *tlsDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: --tls-dir must not be empty; the agent does not serve without mutual TLS\n\n")
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	*logFormat = strings.ToLower(*logFormat)
+	*logLevel = strings.ToLower(*logLevel)
+
+	if err := logging.SetupLogger(os.Stderr, *logFormat, *logLevel, "katamaran-agent"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	tlsCfg, err := agent.ServerTLS(*tlsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fail(fmt.Errorf("listen on %s: %w", *listen, err))
+	}
+
+	srv := agent.NewServer(nil)
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsCfg)),
+		grpc.UnaryInterceptor(recoverUnaryInterceptor),
+	)
+	agentpb.RegisterAgentServer(grpcServer, srv)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// On shutdown, cancel the running migrations first: their Watch
+	// streams then end with the exit event, which GracefulStop waits for.
+	go func() {
+		<-ctx.Done()
+		stop() // A second signal will now force exit.
+		slog.Info("Shutting down; cancelling running migrations")
+		srv.Close()
+		grpcServer.GracefulStop()
+	}()
+
+	slog.Info("katamaran-agent starting",
+		"version", buildinfo.Version,
+		"listen", *listen,
+		"tls_dir", *tlsDir,
+	)
+
+	if err := grpcServer.Serve(lis); err != nil {
+		fail(fmt.Errorf("gRPC server: %w", err))
+	}
+
+	slog.Info("katamaran-agent shut down")
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
@@ -29,6 +29,7 @@ import (
 	"syscall"
 	"time"
 
+	"google.golang.org/grpc/credentials"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
@@ -36,6 +37,7 @@ import (
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
+	"github.com/maci0/katamaran/internal/agent"
 	"github.com/maci0/katamaran/internal/buildinfo"
 	"github.com/maci0/katamaran/internal/controller"
 	"github.com/maci0/katamaran/internal/logging"
@@ -58,6 +60,14 @@ Flags:
   --disable-leader-election       Run reconciler without leader election (single-replica development only)
   --pod-wait-timeout duration     How long to wait for migration Job pods to appear (default 60s;
                                   overridden by KATAMARAN_POD_WAIT_TIMEOUT env or per-CR spec.podWaitTimeoutSeconds)
+  --job-templates string          ConfigMap ([namespace/]name, namespace defaulting to kube-system) with
+                                  Job template overrides, strategic merge patches and the Job policy;
+                                  read at startup (default "": embedded templates)
+  --agent-tls-dir string          Run migrations through the katamaran-agent DaemonSet (deploy/agent.yaml),
+                                  authenticating with the orchestrator client certificate (tls.crt,
+                                  tls.key and ca.crt) from this directory;
+                                  empty runs every migration in Jobs (default "")
+  --agent-port int                Port katamaran-agent listens on (default %d)
   --log-format string             Log output format: 'text' or 'json' (default "json")
   --log-level string              Log level: 'debug', 'info', 'warn', or 'error' (default "info")
 
@@ -82,7 +92,13 @@ Examples:
 
   # Custom probe/metrics listen address
   katamaran-mgr --addr 0.0.0.0:9091
-`)
+
+  # Customise the migration Jobs (docs/USAGE.md, "Customizing the migration Jobs")
+  katamaran-mgr --job-templates kube-system/katamaran-job-templates
+
+  # Run migrations on the node agents, falling back to Jobs
+  katamaran-mgr --agent-tls-dir /etc/katamaran/agent-tls
+`, agent.DefaultPort)
 }
 
 func main() {
@@ -96,6 +112,9 @@ func main() {
 	showVersion := fs.Bool("version", false, "Show version and exit")
 	showVersionShort := fs.Bool("v", false, "")
 	podWaitTimeout := fs.Duration("pod-wait-timeout", 60*time.Second, "How long to wait for migration Job pods to appear")
+	jobTemplates := fs.String("job-templates", "", "ConfigMap ([namespace/]name) with Job template overrides, patches and policy")
+	agentTLSDir := fs.String("agent-tls-dir", "", "Run migrations through katamaran-agent with the mTLS files in this directory")
+	agentPort := fs.Int("agent-port", agent.DefaultPort, "Port katamaran-agent listens on")
 	webhookAddr := fs.String("webhook-addr", ":9443", "HTTPS listen address for the validating admission webhook (TLS, in-process self-signed cert)")
 	webhookService := fs.String("webhook-service", "katamaran-mgr-webhook", "Name of the Kubernetes Service the apiserver dials to reach the webhook (used as TLS SAN)")
 	webhookNamespace := fs.String("webhook-namespace", "kube-system", "Namespace of the webhook Service (used as TLS SAN)")
@@ -131,6 +150,11 @@ func main() {
 		printUsage(os.Stderr)
 		os.Exit(2)
 	}
+	if *agentPort < 1 || *agentPort > 65535 {
+		fmt.Fprintf(os.Stderr, "Error: --agent-port must be in 1..65535, got %d\n\n", *agentPort)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
 	if !*skipLeaderElect {
 		if strings.TrimSpace(*leaderNamespace) == "" {
 			fmt.Fprintf(os.Stderr, "Error: --leader-namespace must not be empty\n\n")
@@ -184,6 +208,15 @@ func main() {
 		fail(fmt.Errorf("orchestrator unavailable: %w", err))
 	}
 	orchestrator.SetPodWaitTimeout(orch, *podWaitTimeout)
+	if *jobTemplates != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := orchestrator.SetJobTemplatesFromConfigMap(ctx, orch, *jobTemplates)
+		cancel()
+		if err != nil {
+			fail(err)
+		}
+		slog.Info("Using customised Job templates", "configmap", *jobTemplates, "job_namespace", orchestrator.JobNamespace(orch))
+	}
 
 	disc, derr := orchestrator.NewDiscoverer()
 	if derr != nil {
@@ -193,6 +226,18 @@ func main() {
 		slog.Warn("Discoverer unavailable, controller will not resolve SourceNode/DestIP", "error", derr)
 	}
 
+	if *agentTLSDir != "" {
+		tlsCfg, err := agent.ClientTLS(*agentTLSDir)
+		if err != nil {
+			fail(fmt.Errorf("agent TLS: %w", err))
+		}
+		if disc == nil {
+			fail(fmt.Errorf("--agent-tls-dir needs node discovery to find the agents: %w", derr))
+		}
+		orch = orchestrator.WithAgents(orch, agent.Dialer(credentials.NewTLS(tlsCfg), *agentPort, disc.LookupNodeInternalIP))
+		slog.Info("Migrations run through katamaran-agent where possible", "port", *agentPort)
+	}
+
 	rec := controller.NewReconciler(dyn, kube, orch, disc)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
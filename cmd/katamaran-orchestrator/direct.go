@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/maci0/katamaran/internal/agent"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// newDirect returns the direct orchestrator for the hosts of the
+// inventory at inventoryPath, and a function that ends its SSH runs.
+// It fills an empty req.DestIP with the destination host's migrationIP
+// and checks req against the inventory.
+func newDirect(inventoryPath, agentTLSDir string, req *orchestrator.Request) (orchestrator.Orchestrator, func(), error) {
+	if inventoryPath == "" {
+		return nil, nil, errors.New("--inventory is required with --backend direct")
+	}
+	inv, err := agent.LoadInventory(inventoryPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, ok := inv.Host(req.SourceNode)
+	if !ok {
+		return nil, nil, fmt.Errorf("source host %q is not in the inventory", req.SourceNode)
+	}
+	dest, ok := inv.Host(req.DestNode)
+	if !ok {
+		return nil, nil, fmt.Errorf("destination host %q is not in the inventory", req.DestNode)
+	}
+	if req.DestIP == "" {
+		req.DestIP = dest.MigrationIP
+	}
+	if err := orchestrator.ValidateDirect(*req); err != nil {
+		return nil, nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	var creds credentials.TransportCredentials
+	switch {
+	case agentTLSDir != "":
+		tlsCfg, err := agent.ClientTLS(agentTLSDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	case src.Agent != "" || dest.Agent != "":
+		return nil, nil, errors.New("--agent-tls-dir is required for hosts reached through katamaran-agent")
+	}
+	dial, closeHosts := inv.Dialer(creds)
+	return orchestrator.NewDirect(dial), closeHosts, nil
+}
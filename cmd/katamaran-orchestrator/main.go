@@ -5,6 +5,10 @@
 // PhaseFailed or runtime error, 2 on argument/decoding errors, 130 on
 // signal-induced shutdown.
 //
+// With --backend direct it needs no Kubernetes at all: the two sides run
+// on hosts listed in an --inventory file, over SSH or through their
+// katamaran-agent (see orchestrator.NewDirect).
+//
 // Intended for scripts and CI pipelines that want a structured (not
 // bash-tail) migration runner. The dashboard and the Migration CRD
 // reconciler call into the orchestrator package directly rather than
@@ -49,11 +53,21 @@ I/O:
   stdin    A single JSON-encoded orchestrator.Request object (required; max 1 MiB).
   stdout   Newline-delimited JSON status updates (one object per line) until a
            terminal phase is reached. Fields: id, phase, time, msg, err,
-           ram_transferred, ram_total, downtime_ms, applied_downtime_ms,
-           rtt_ms, auto_downtime.
+           ram_transferred, ram_total, downtime_ms, memory_saved_bytes, compression,
+           compression_ratio, applied_downtime_ms,
+           rtt_ms, auto_downtime, vm_stopped_at, vm_resumed_at, dest_node,
+           placement_score, placement_reasons, dest_sandbox_id, hooks, tuning.
   stderr   Diagnostic messages and errors.
 
 Flags:
+  --backend string       'native' (Kubernetes Jobs) or 'direct' (hosts of --inventory,
+                         no Kubernetes) (default "native")
+  --inventory string     JSON host inventory for --backend direct
+  --agent-tls-dir string Directory with the orchestrator client certificate (tls.crt,
+                         tls.key and ca.crt) for inventory hosts reached through
+                         katamaran-agent
+  --resume string        Re-attach to migration ID (or start it under that ID if no
+                         host has it) instead of starting a new one
   --kubeconfig string    Optional path to kubeconfig (out-of-cluster only)
   --log-format string    Log output format: 'text' or 'json' (default "text")
   --log-level string     Log level: 'debug', 'info', 'warn', or 'error' (default "info")
@@ -76,6 +90,13 @@ Example:
     "DestPod":{"Namespace":"default","Name":"kata-dest-shell"},
     "SharedStorage":true,"ReplayCmdline":true
   }' | katamaran-orchestrator
+
+  # Without Kubernetes, between two hosts of inventory.json
+  echo '{
+    "SourceNode":"host-a","DestNode":"host-b","VMIP":"10.0.0.50",
+    "SourceQMP":"/run/vm/qmp.sock","DestQMP":"/run/vm/qmp.sock",
+    "SharedStorage":true
+  }' | katamaran-orchestrator --backend direct --inventory inventory.json
 `)
 }
 
@@ -94,6 +115,10 @@ func isStdinTTY(f *os.File) bool {
 func main() {
 	fs := flag.NewFlagSet("katamaran-orchestrator", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
+	backend := fs.String("backend", "native", "'native' (Kubernetes Jobs) or 'direct' (hosts of --inventory)")
+	inventory := fs.String("inventory", "", "JSON host inventory for --backend direct")
+	agentTLSDir := fs.String("agent-tls-dir", "", "Directory with tls.crt, tls.key and ca.crt for katamaran-agent hosts")
+	resume := fs.String("resume", "", "Re-attach to migration ID instead of starting a new one")
 	kubeconfig := fs.String("kubeconfig", "", "Optional path to kubeconfig (out-of-cluster only)")
 	logFormat := fs.String("log-format", "text", "Log output format: 'text' or 'json'")
 	logLevel := fs.String("log-level", "info", "Log level: 'debug', 'info', 'warn', or 'error'")
@@ -119,6 +144,18 @@ func main() {
 		os.Exit(2)
 	}
 
+	*backend = strings.ToLower(*backend)
+	if *backend != "native" && *backend != "direct" {
+		fmt.Fprintf(os.Stderr, "Error: --backend must be 'native' or 'direct', got %q\n\n", *backend)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if *backend != "direct" && (*inventory != "" || *agentTLSDir != "") {
+		fmt.Fprintf(os.Stderr, "Error: --inventory and --agent-tls-dir require --backend direct\n\n")
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
 	*logFormat = strings.ToLower(*logFormat)
 	*logLevel = strings.ToLower(*logLevel)
 	if err := logging.SetupLogger(os.Stderr, *logFormat, *logLevel, "katamaran-orchestrator"); err != nil {
@@ -138,33 +175,52 @@ func main() {
 		printUsage(os.Stderr)
 		os.Exit(2)
 	}
-	if err := orchestrator.Validate(req); err != nil {
+
+	var o orchestrator.Orchestrator
+	closeHosts := func() {}
+	if *backend == "direct" {
+		o, closeHosts, err = newDirect(*inventory, *agentTLSDir, &req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+			printUsage(os.Stderr)
+			os.Exit(2)
+		}
+	} else if err := orchestrator.Validate(req); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: invalid request: %v\n\n", err)
 		printUsage(os.Stderr)
 		os.Exit(2)
 	}
+	// fail ends any SSH runs before exiting; os.Exit skips deferred calls.
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format, args...)
+		closeHosts()
+		os.Exit(1)
+	}
 
 	// Catch SIGINT/SIGTERM so a Ctrl-C cleanly stops the in-flight migration.
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	o, err := orchestrator.New()
-	if err != nil {
-		o, err = orchestrator.NewFromKubeconfig(*kubeconfig, "")
-	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: orchestrator init: %v\n", err)
-		os.Exit(1)
+	if o == nil {
+		o, err = orchestrator.New()
+		if err != nil {
+			o, err = orchestrator.NewFromKubeconfig(*kubeconfig, "")
+		}
+		if err != nil {
+			fail("Error: orchestrator init: %v\n", err)
+		}
 	}
-	id, err := o.Apply(ctx, req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: apply: %v\n", err)
-		os.Exit(1)
+	id := orchestrator.MigrationID(*resume)
+	if id != "" {
+		if _, err := o.Resume(ctx, id, req); err != nil {
+			fail("Error: resume: %v\n", err)
+		}
+	} else if id, err = o.Apply(ctx, req); err != nil {
+		fail("Error: apply: %v\n", err)
 	}
 	updates, err := o.Watch(ctx, id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: watch: %v\n", err)
-		os.Exit(1)
+		fail("Error: watch: %v\n", err)
 	}
 
 	enc := json.NewEncoder(os.Stdout)
@@ -177,8 +233,7 @@ func main() {
 	exit := 0
 	for u := range updates {
 		if err := enc.Encode(newStatusOutput(u)); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: write status update: %v\n", err)
-			os.Exit(1)
+			fail("Error: write status update: %v\n", err)
 		}
 		if u.Phase == orchestrator.PhaseFailed {
 			exit = 1
@@ -187,6 +242,7 @@ func main() {
 	// Signal-induced shutdown surfaces 130 even when the orchestrator
 	// emitted a final PhaseFailed update during teardown — otherwise a
 	// Ctrl-C looks indistinguishable from a real migration failure.
+	closeHosts()
 	if ctx.Err() != nil {
 		os.Exit(130)
 	}
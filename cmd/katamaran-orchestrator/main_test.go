@@ -1,8 +1,12 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
 )
 
 func TestReadRequestRejectsEmptyStdin(t *testing.T) {
@@ -48,3 +52,50 @@ func TestReadRequestRejectsOversizedJSON(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestNewDirect(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(`{"hosts": [
+		{"name": "host-a", "ssh": {"address": "10.0.0.10"}},
+		{"name": "host-b", "migrationIP": "10.0.0.11", "ssh": {"address": "10.0.0.11"}},
+		{"name": "host-c", "agent": "10.0.0.12"}
+	]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	request := func(dest string) orchestrator.Request {
+		return orchestrator.Request{
+			SourceNode: "host-a", DestNode: dest, VMIP: "10.0.0.50",
+			SourceQMP: "/run/vm/qmp.sock", DestQMP: "/run/vm/qmp.sock",
+		}
+	}
+
+	req := request("host-b")
+	o, closeHosts, err := newDirect(path, "", &req)
+	if err != nil {
+		t.Fatalf("newDirect: %v", err)
+	}
+	closeHosts()
+	if o == nil || req.DestIP != "10.0.0.11" {
+		t.Fatalf("DestIP = %q, want the destination's migrationIP", req.DestIP)
+	}
+
+	for _, tc := range []struct {
+		name, inventory, dest, want string
+	}{
+		{"no inventory", "", "host-b", "--inventory is required"},
+		{"unknown host", path, "host-x", `destination host "host-x" is not in the inventory`},
+		{"no DestIP", path, "host-c", "invalid request"},
+	} {
+		req := request(tc.dest)
+		if _, _, err := newDirect(tc.inventory, "", &req); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: newDirect error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	req = request("host-c")
+	req.DestIP = "10.0.0.12"
+	if _, _, err := newDirect(path, "", &req); err == nil || !strings.Contains(err.Error(), "--agent-tls-dir is required") {
+		t.Errorf("newDirect with an agent host and no TLS = %v", err)
+	}
+}
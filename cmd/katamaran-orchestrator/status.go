@@ -2,6 +2,9 @@ package main
 
 import "github.com/maci0/katamaran/internal/orchestrator"
 
+// statusTimeLayout is RFC 3339 in UTC with millisecond precision.
+const statusTimeLayout = "2006-01-02T15:04:05.000Z"
+
 type statusOutput struct {
 	ID                orchestrator.MigrationID `json:"id"`
 	Phase             orchestrator.StatusPhase `json:"phase"`
@@ -11,26 +14,114 @@ type statusOutput struct {
 	RAMTransferred    int64                    `json:"ram_transferred,omitempty"`
 	RAMTotal          int64                    `json:"ram_total,omitempty"`
 	DowntimeMS        int64                    `json:"downtime_ms,omitempty"`
+	MemorySavedBytes  int64                    `json:"memory_saved_bytes,omitempty"`
+	Compression       string                   `json:"compression,omitempty"`
+	CompressionRatio  float64                  `json:"compression_ratio,omitempty"`
 	AppliedDowntimeMS int64                    `json:"applied_downtime_ms,omitempty"`
 	RTTMS             int64                    `json:"rtt_ms,omitempty"`
 	AutoDowntime      bool                     `json:"auto_downtime,omitempty"`
+	VMStoppedAt       string                   `json:"vm_stopped_at,omitempty"`
+	VMResumedAt       string                   `json:"vm_resumed_at,omitempty"`
+	DestNode          string                   `json:"dest_node,omitempty"`
+	PlacementScore    int                      `json:"placement_score,omitempty"`
+	PlacementReasons  []string                 `json:"placement_reasons,omitempty"`
+	DestSandboxID     string                   `json:"dest_sandbox_id,omitempty"`
+	Cold              bool                     `json:"cold,omitempty"`
+	MigrationBlockers []string                 `json:"migration_blockers,omitempty"`
+	StorageVerify     *storageVerifyOutput     `json:"storage_verification,omitempty"`
+	VolumeHandoffs    []volumeHandoffOutput    `json:"volume_handoffs,omitempty"`
+	Hooks             []hookOutput             `json:"hooks,omitempty"`
+	Tuning            *tuningOutput            `json:"tuning,omitempty"`
+}
+
+type storageVerifyOutput struct {
+	DriveID    string `json:"drive_id"`
+	Mode       string `json:"mode"`
+	Result     string `json:"result"`
+	Extents    int    `json:"extents"`
+	Mismatched int    `json:"mismatched"`
+}
+
+type volumeHandoffOutput struct {
+	PVC        string `json:"pvc"`
+	PV         string `json:"pv"`
+	Driver     string `json:"driver"`
+	Strategy   string `json:"strategy"`
+	DestPVC    string `json:"dest_pvc,omitempty"`
+	Attachment string `json:"attachment,omitempty"`
+	State      string `json:"state"`
+}
+
+type hookOutput struct {
+	Phase      string `json:"phase"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Result     string `json:"result"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type tuningOutput struct {
+	Step               int    `json:"step"`
+	Param              string `json:"param"`
+	Old                int64  `json:"old"`
+	New                int64  `json:"new"`
+	ExpectedDowntimeMS int64  `json:"expected_downtime_ms"`
+	DirtyRateBps       int64  `json:"dirty_rate_bps"`
+	ThroughputMbps     int64  `json:"throughput_mbps"`
+	RAMRemaining       int64  `json:"ram_remaining"`
 }
 
 func newStatusOutput(u orchestrator.StatusUpdate) statusOutput {
 	out := statusOutput{
 		ID:                u.ID,
 		Phase:             u.Phase,
-		Time:              u.When.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Time:              u.When.UTC().Format(statusTimeLayout),
 		Msg:               u.Message,
 		RAMTransferred:    u.RAMTransferred,
 		RAMTotal:          u.RAMTotal,
 		DowntimeMS:        u.DowntimeMS,
+		MemorySavedBytes:  u.MemorySavedBytes,
+		Compression:       u.Compression,
+		CompressionRatio:  u.CompressionRatio,
 		AppliedDowntimeMS: u.AppliedDowntimeMS,
 		RTTMS:             u.RTTMS,
 		AutoDowntime:      u.AutoDowntime,
+		DestSandboxID:     u.DestSandboxID,
+		Cold:              u.Cold,
+		MigrationBlockers: u.MigrationBlockers,
 	}
 	if u.Error != nil {
 		out.Err = u.Error.Error()
 	}
+	if !u.VMStoppedAt.IsZero() {
+		out.VMStoppedAt = u.VMStoppedAt.UTC().Format(statusTimeLayout)
+	}
+	if !u.VMResumedAt.IsZero() {
+		out.VMResumedAt = u.VMResumedAt.UTC().Format(statusTimeLayout)
+	}
+	if sv := u.StorageVerification; sv != nil {
+		out.StorageVerify = &storageVerifyOutput{
+			DriveID:    sv.DriveID,
+			Mode:       sv.Mode,
+			Result:     sv.Result,
+			Extents:    sv.Extents,
+			Mismatched: sv.Mismatched,
+		}
+	}
+	for _, v := range u.VolumeHandoffs {
+		out.VolumeHandoffs = append(out.VolumeHandoffs, volumeHandoffOutput(v))
+	}
+	for _, h := range u.Hooks {
+		out.Hooks = append(out.Hooks, hookOutput(h))
+	}
+	if t := u.Tuning; t != nil {
+		out.Tuning = (*tuningOutput)(t)
+	}
+	if p := u.Placement; p != nil {
+		out.DestNode = p.Node
+		out.PlacementScore = p.Score
+		out.PlacementReasons = p.Reasons
+	}
 	return out
 }
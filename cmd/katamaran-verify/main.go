@@ -0,0 +1,340 @@
+// katamaran-verify is the command-line front end of the synthetic workload
+// verifier (internal/verify) used to check katamaran's zero-drop claim.
+//
+//	katamaran-verify serve      run the UDP/TCP echo server next to the workload
+//	katamaran-verify run        stream probes at a server and report gaps
+//	katamaran-verify correlate  re-evaluate a saved report against STOP/RESUME times
+//
+// Exit codes: 0 when the report passes (or serve shuts down cleanly), 1 when
+// it fails or on runtime error, 2 on argument errors. SIGINT/SIGTERM ends
+// `run`'s send phase early and still prints the report; `serve` exits 0.
+//
+// Example (scripts/e2e.sh --verify does the equivalent in-cluster):
+//
+//	katamaran-verify serve &                             # in the workload pod
+//	katamaran-verify run --target 10.244.1.7 --duration 90s --json > report.json
+//	katamaran-verify correlate --stopped-at 1718000000123 --resumed-at 1718000000161 < report.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/maci0/katamaran/internal/buildinfo"
+	"github.com/maci0/katamaran/internal/logging"
+	"github.com/maci0/katamaran/internal/verify"
+)
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, `katamaran-verify — Synthetic UDP/TCP workload verifier for zero-drop migrations
+
+Usage:
+  katamaran-verify serve [flags]
+  katamaran-verify run --target <ip> [flags]
+  katamaran-verify correlate [flags] < report.json
+  katamaran-verify --version
+  katamaran-verify --help
+
+Subcommands:
+  serve       Echo sequence-numbered probe frames over UDP and TCP until
+              SIGINT/SIGTERM. Run it inside the workload being migrated.
+  run         Stream probes at a serve instance, then print a report of lost,
+              duplicated, and reordered UDP datagrams, TCP resets, and the
+              longest echo stalls. Sends until --duration elapses or
+              SIGINT/SIGTERM arrives, then drains outstanding echoes.
+  correlate   Read a JSON report (from run --json) on stdin and mark which
+              gaps, stalls, and resets fell inside the STOP/RESUME window.
+
+serve flags:
+  --udp-listen <addr>    UDP listen address (default ":%[1]d")
+  --tcp-listen <addr>    TCP listen address (default ":%[2]d")
+
+run flags:
+  --target <host>        Address of the serve instance (required)
+  --udp-port <n>         UDP echo port (default %[1]d)
+  --tcp-port <n>         TCP echo port (default %[2]d)
+  --duration <d>         Send phase length; 0 runs until SIGINT/SIGTERM (default 0)
+  --interval <d>         Per-stream send interval (default %[3]s)
+  --drain <d>            Wait for outstanding echoes after sending (default %[4]s)
+  --allow-reorder        Count reordered UDP datagrams without failing the run
+
+run and correlate flags:
+  --stopped-at <t>       VM STOP time: unix milliseconds or RFC 3339
+  --resumed-at <t>       VM RESUME time: unix milliseconds or RFC 3339
+  --slack <d>            Widen the cutover window by this much on both sides (default %[5]s)
+  --json                 Print the report as JSON instead of text
+
+Other:
+  --log-format <fmt>     Log output format: 'text' or 'json' (default "text")
+  --log-level <lvl>      Log level: 'debug', 'info', 'warn', or 'error' (default "info")
+  -v, --version          Show version and exit
+  -h, --help             Show this help and exit
+
+STOP/RESUME times come from the KATAMARAN_VM_STOPPED / KATAMARAN_VM_RESUMED
+markers in the source and destination job logs, the Migration CR's
+.status.vmStoppedAt / .status.vmResumedAt, or katamaran-orchestrator's
+vm_stopped_at / vm_resumed_at fields.
+
+Exit codes:
+  0   Report passed (serve: clean shutdown)
+  1   Report failed, or runtime error
+  2   Argument error
+`, verify.DefaultUDPPort, verify.DefaultTCPPort, verify.DefaultInterval, verify.DefaultDrain, verify.DefaultSlack)
+}
+
+// reportFlags are shared by run and correlate.
+type reportFlags struct {
+	stoppedAt string
+	resumedAt string
+	slack     time.Duration
+	json      bool
+}
+
+func (rf *reportFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&rf.stoppedAt, "stopped-at", "", "VM STOP time (unix ms or RFC 3339)")
+	fs.StringVar(&rf.resumedAt, "resumed-at", "", "VM RESUME time (unix ms or RFC 3339)")
+	fs.DurationVar(&rf.slack, "slack", verify.DefaultSlack, "Cutover window slack")
+	fs.BoolVar(&rf.json, "json", false, "Print the report as JSON")
+}
+
+// parseTime accepts unix milliseconds (as printed by the
+// KATAMARAN_VM_* markers) or an RFC 3339 timestamp. Empty is the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if ms <= 0 {
+			return time.Time{}, fmt.Errorf("invalid unix milliseconds %q", s)
+		}
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want unix milliseconds or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// cutover parses the STOP/RESUME flags.
+func (rf *reportFlags) cutover() (stopped, resumed time.Time, err error) {
+	if stopped, err = parseTime(rf.stoppedAt); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("--stopped-at: %w", err)
+	}
+	if resumed, err = parseTime(rf.resumedAt); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("--resumed-at: %w", err)
+	}
+	if stopped.IsZero() && !resumed.IsZero() {
+		return time.Time{}, time.Time{}, errors.New("--resumed-at requires --stopped-at")
+	}
+	if rf.slack < 0 {
+		return time.Time{}, time.Time{}, errors.New("--slack must not be negative")
+	}
+	return stopped, resumed, nil
+}
+
+// emit correlates r when a STOP time was given, prints it, and returns
+// the exit code for its verdict.
+func (rf *reportFlags) emit(w io.Writer, r *verify.Report, stopped, resumed time.Time) int {
+	if !stopped.IsZero() {
+		r.Correlate(stopped, resumed, rf.slack)
+	}
+	if rf.json {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: write report: %v\n", err)
+			return 1
+		}
+	} else {
+		writeText(w, r)
+	}
+	if !r.Pass {
+		return 1
+	}
+	return 0
+}
+
+// writeText renders the human-readable report.
+func writeText(w io.Writer, r *verify.Report) {
+	fmt.Fprintln(w, r.Summary())
+	fmt.Fprintf(w, "  target %s, %s, every %dms\n", r.Target, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), r.IntervalMS)
+	fmt.Fprintf(w, "  udp: sent %d, received %d, lost %d, duplicates %d, reordered %d\n",
+		r.UDP.Sent, r.UDP.Received, r.UDP.Lost, r.UDP.Duplicates, r.UDP.Reordered)
+	for _, g := range r.UDP.Gaps {
+		fmt.Fprintf(w, "    gap seq %d-%d (%d) %s .. %s%s\n", g.FirstSeq, g.LastSeq, g.Count,
+			g.From.UTC().Format(time.RFC3339Nano), g.To.UTC().Format(time.RFC3339Nano), duringCutover(g.DuringCutover))
+	}
+	fmt.Fprintf(w, "  tcp: sent %d, received %d, out of order %d, connects %d\n",
+		r.TCP.Sent, r.TCP.Received, r.TCP.OutOfOrder, r.TCP.Connects)
+	for _, d := range r.TCP.Disconnects {
+		fmt.Fprintf(w, "    reset %s: %s%s\n", d.At.UTC().Format(time.RFC3339Nano), d.Error, duringCutover(d.DuringCutover))
+	}
+	if c := r.Cutover; c != nil {
+		fmt.Fprintf(w, "  cutover: stopped %s, window %dms (±%dms slack)\n", c.StoppedAt.UTC().Format(time.RFC3339Nano), c.WindowMS, c.SlackMS)
+	}
+	for _, f := range r.Failures {
+		fmt.Fprintf(w, "  FAIL %s\n", f)
+	}
+	for _, n := range r.Notes {
+		fmt.Fprintf(w, "  note: %s\n", n)
+	}
+}
+
+func duringCutover(b bool) string {
+	if b {
+		return " [during cutover]"
+	}
+	return ""
+}
+
+func runServe(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("katamaran-verify serve", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { printUsage(os.Stderr) }
+	udpListen := fs.String("udp-listen", fmt.Sprintf(":%d", verify.DefaultUDPPort), "UDP listen address")
+	tcpListen := fs.String("tcp-listen", fmt.Sprintf(":%d", verify.DefaultTCPPort), "TCP listen address")
+	if err := parseArgs(fs, args); err != nil {
+		return 2
+	}
+	srv, err := verify.Listen(*udpListen, *tcpListen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "katamaran-verify serving udp=%s tcp=%s\n", srv.UDPAddr(), srv.TCPAddr())
+	if err := srv.Serve(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runProbe(ctx context.Context, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("katamaran-verify run", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { printUsage(os.Stderr) }
+	var cfg verify.Config
+	fs.StringVar(&cfg.Target, "target", "", "Address of the serve instance")
+	fs.IntVar(&cfg.UDPPort, "udp-port", verify.DefaultUDPPort, "UDP echo port")
+	fs.IntVar(&cfg.TCPPort, "tcp-port", verify.DefaultTCPPort, "TCP echo port")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "Send phase length (0 = until signal)")
+	fs.DurationVar(&cfg.Interval, "interval", verify.DefaultInterval, "Per-stream send interval")
+	fs.DurationVar(&cfg.Drain, "drain", verify.DefaultDrain, "Drain wait after sending")
+	fs.BoolVar(&cfg.AllowReorder, "allow-reorder", false, "Tolerate reordered UDP datagrams")
+	var rf reportFlags
+	rf.register(fs)
+	if err := parseArgs(fs, args); err != nil {
+		return 2
+	}
+	stopped, resumed, err := rf.cutover()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		return 2
+	}
+	r, err := verify.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		return 2
+	}
+	return rf.emit(stdout, r, stopped, resumed)
+}
+
+func runCorrelate(args []string, stdin io.Reader, stdout io.Writer) int {
+	fs := flag.NewFlagSet("katamaran-verify correlate", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { printUsage(os.Stderr) }
+	var rf reportFlags
+	rf.register(fs)
+	if err := parseArgs(fs, args); err != nil {
+		return 2
+	}
+	stopped, resumed, err := rf.cutover()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		return 2
+	}
+	var r verify.Report
+	if err := json.NewDecoder(stdin).Decode(&r); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: decode report on stdin: %v\n", err)
+		return 2
+	}
+	return rf.emit(stdout, &r, stopped, resumed)
+}
+
+// parseArgs parses a subcommand's flags and rejects positional leftovers.
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: unexpected arguments: %s\n\n", strings.Join(fs.Args(), " "))
+		printUsage(os.Stderr)
+		return errors.New("unexpected arguments")
+	}
+	return nil
+}
+
+func main() {
+	fs := flag.NewFlagSet("katamaran-verify", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	logFormat := fs.String("log-format", "text", "Log output format: 'text' or 'json'")
+	logLevel := fs.String("log-level", "info", "Log level: 'debug', 'info', 'warn', or 'error'")
+	showVersion := fs.Bool("version", false, "Show version and exit")
+	showVersionShort := fs.Bool("v", false, "")
+	helpFlag := fs.Bool("help", false, "")
+	helpFlagShort := fs.Bool("h", false, "")
+	fs.Usage = func() { printUsage(os.Stderr) }
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if *helpFlag || *helpFlagShort {
+		printUsage(os.Stdout)
+		return
+	}
+	if *showVersion || *showVersionShort {
+		fmt.Fprintf(os.Stdout, "katamaran-verify %s\n", buildinfo.Version)
+		return
+	}
+	if err := logging.SetupLogger(os.Stderr, strings.ToLower(*logFormat), strings.ToLower(*logLevel), "katamaran-verify"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: subcommand required (serve, run, or correlate)\n\n")
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var code int
+	switch sub, args := fs.Arg(0), fs.Args()[1:]; sub {
+	case "serve":
+		code = runServe(ctx, args)
+	case "run":
+		code = runProbe(ctx, args, os.Stdout)
+	case "correlate":
+		code = runCorrelate(args, os.Stdin, os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown subcommand %q\n\n", sub)
+		printUsage(os.Stderr)
+		code = 2
+	}
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/verify"
+)
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+	got, err := parseTime("1700000000123")
+	if err != nil || !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("parseTime(unix ms) = %v, %v", got, err)
+	}
+	got, err = parseTime("2023-11-14T22:13:20.123Z")
+	if err != nil || !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("parseTime(RFC 3339) = %v, %v", got, err)
+	}
+	if got, err := parseTime(""); err != nil || !got.IsZero() {
+		t.Fatalf("parseTime(\"\") = %v, %v", got, err)
+	}
+	for _, bad := range []string{"0", "-1", "yesterday"} {
+		if _, err := parseTime(bad); err == nil {
+			t.Errorf("parseTime(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestRunCorrelate(t *testing.T) {
+	t.Parallel()
+	from := time.UnixMilli(1700000000100)
+	in := verify.Report{
+		Target: "10.0.0.7",
+		UDP: verify.UDPResult{
+			Sent: 100, Received: 98, Lost: 2,
+			Gaps: []verify.Gap{{FirstSeq: 10, LastSeq: 11, Count: 2, From: from, To: from.Add(10 * time.Millisecond)}},
+		},
+		TCP: verify.TCPResult{Sent: 100, Received: 100, Connects: 1},
+	}
+	raw, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	code := runCorrelate([]string{"--stopped-at", "1700000000090", "--resumed-at", "1700000000130", "--json"}, bytes.NewReader(raw), &out)
+	if code != 1 {
+		t.Fatalf("exit code = %d, want 1 (loss fails even during cutover)", code)
+	}
+	var got verify.Report
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("decode output: %v\n%s", err, out.String())
+	}
+	if got.Cutover == nil || got.Cutover.WindowMS != 40 || !got.UDP.Gaps[0].DuringCutover {
+		t.Fatalf("correlated report = %+v", got)
+	}
+
+	out.Reset()
+	if code := runCorrelate(nil, bytes.NewReader(raw), &out); code != 1 {
+		t.Fatalf("text exit code = %d, want 1", code)
+	}
+	if !strings.HasPrefix(out.String(), "FAIL:") || !strings.Contains(out.String(), "gap seq 10-11") {
+		t.Errorf("text output:\n%s", out.String())
+	}
+}
+
+func TestRunCorrelate_ArgumentErrors(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--resumed-at", "1700000000130"},
+		{"--stopped-at", "soon"},
+		{"--slack", "-1s"},
+		{"extra"},
+	} {
+		if code := runCorrelate(args, strings.NewReader("{}"), &bytes.Buffer{}); code != 2 {
+			t.Errorf("runCorrelate(%q) = %d, want 2", args, code)
+		}
+	}
+	if code := runCorrelate(nil, strings.NewReader("not json"), &bytes.Buffer{}); code != 2 {
+		t.Errorf("runCorrelate(bad stdin) = %d, want 2", code)
+	}
+}
@@ -136,6 +136,30 @@ func TestRun_NegativeMultifd(t *testing.T) {
 	}
 }
 
+func TestRun_PortOutOfRange(t *testing.T) {
+	for _, flag := range []string{"--migration-port", "--nbd-port"} {
+		var stdout, stderr bytes.Buffer
+		code := katamaran.Run(context.Background(), []string{"--mode", "dest", flag, "70000"}, &stdout, &stderr)
+		if code != 2 {
+			t.Errorf("%s 70000: expected exit code 2, got %d", flag, code)
+		}
+		if !strings.Contains(stderr.String(), flag+" must be between 0 and 65535") {
+			t.Errorf("%s 70000: expected range error, got: %s", flag, stderr.String())
+		}
+	}
+}
+
+func TestRun_DestInvalidSandboxID(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{"--mode", "dest", "--sandbox-id", "../escape", "--shared-storage"}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid sandbox ID") {
+		t.Fatalf("expected sandbox ID error, got: %s", stderr.String())
+	}
+}
+
 func TestRun_SourceNegativeAutoDowntimeFloor(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	code := katamaran.Run(context.Background(), []string{
@@ -153,6 +177,116 @@ func TestRun_SourceNegativeAutoDowntimeFloor(t *testing.T) {
 	}
 }
 
+func TestRun_SourceMaxDowntimeBelowDowntime(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source",
+		"--dest-ip", "10.0.0.1",
+		"--vm-ip", "10.0.0.2",
+		"--downtime", "100",
+		"--adaptive-tuning",
+		"--max-downtime", "50",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--max-downtime (50) must not be below --downtime (100)") {
+		t.Fatalf("expected max-downtime error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_DriveIDAutoWithOtherIDs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source",
+		"--dest-ip", "10.0.0.1",
+		"--vm-ip", "10.0.0.2",
+		"--drive-id", "auto,drive-virtio-disk1",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--drive-id auto cannot be combined") {
+		t.Fatalf("expected drive-id auto error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_DestDrivesFromJobRequiresAuto(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "dest",
+		"--drives-from-job", "kube-system/katamaran-source-abc",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--drives-from-job requires --drive-id auto") {
+		t.Fatalf("expected drives-from-job error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_SourceNegativeDestReadyTimeout(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source",
+		"--dest-ip", "10.0.0.1",
+		"--vm-ip", "10.0.0.2",
+		"--dest-ready-timeout", "-1s",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--dest-ready-timeout must be non-negative") {
+		t.Fatalf("expected dest-ready-timeout error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_SourceInvalidVerifyStorage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source",
+		"--dest-ip", "10.0.0.1",
+		"--vm-ip", "10.0.0.2",
+		"--verify-storage", "always",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), `invalid --verify-storage "always"`) {
+		t.Fatalf("expected verify-storage error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_SourceNegativeMirrorReconnectTimeout(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source",
+		"--dest-ip", "10.0.0.1",
+		"--vm-ip", "10.0.0.2",
+		"--mirror-reconnect-timeout", "-1s",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--mirror-reconnect-timeout must be non-negative") {
+		t.Fatalf("expected mirror-reconnect-timeout error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_ColdRequiresDestIP(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "cold",
+		"--vm-ip", "10.0.0.2",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--dest-ip is required") {
+		t.Fatalf("expected dest-ip error, got: %s", stderr.String())
+	}
+}
+
 func TestRun_SourceNegativeCNIConvergenceDelay(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	code := katamaran.Run(context.Background(), []string{
@@ -181,6 +315,49 @@ func TestRun_SourceMissingRequiredFlags(t *testing.T) {
 	}
 }
 
+func TestRun_ProbeRequiresPodFlags(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{"--mode", "probe", "--pod-name", "vm-a"}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "probe mode requires --pod-name and --pod-namespace") {
+		t.Fatalf("expected probe pod-flag error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_CheckpointAndRestoreRequireBundleDir(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--mode", "checkpoint", "--qmp", "/run/vc/vm/x/extra-monitor.sock"}, "checkpoint mode requires --out"},
+		{[]string{"--mode", "checkpoint", "--out", "/tmp/ckpt", "--pod-name", "vm-a"}, "--pod-name and --pod-namespace must be supplied together"},
+		{[]string{"--mode", "checkpoint", "--out", "/tmp/ckpt", "--qmp", "/x.sock", "--pod-name", "vm-a", "--pod-namespace", "default"}, "cannot be combined with --qmp"},
+		{[]string{"--mode", "restore", "--sandbox-id", "vm-a"}, "restore mode requires --in"},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := katamaran.Run(context.Background(), tc.args, &stdout, &stderr)
+		if code != 2 {
+			t.Fatalf("%q: exit code %d, want 2", tc.args, code)
+		}
+		if !strings.Contains(stderr.String(), tc.want) {
+			t.Fatalf("%q: expected %q, got: %s", tc.args, tc.want, stderr.String())
+		}
+	}
+}
+
+func TestRun_RestoreRejectsIncompleteBundle(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{"--mode", "restore", "--in", t.TempDir()}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "not a complete checkpoint") {
+		t.Fatalf("expected incomplete checkpoint error, got: %s", stderr.String())
+	}
+}
+
 func TestRun_SourcePodFlagsAccepted(t *testing.T) {
 	// Source mode with --pod-name/--pod-namespace should pass flag parsing and
 	// XOR validation, then fail later when migration tries to resolve the pod.
@@ -281,6 +458,62 @@ func TestRun_SourceInvalidTunnelMode(t *testing.T) {
 	}
 }
 
+func TestRun_InvalidHypervisor(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "dest", "--qmp", "/tmp/qmp.sock", "--hypervisor", "firecracker",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --hypervisor") {
+		t.Fatalf("expected hypervisor error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_InvalidHandoffKey(t *testing.T) {
+	t.Setenv("KATAMARAN_HANDOFF_KEY", "not-hex")
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "dest", "--qmp", "/tmp/qmp.sock",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "KATAMARAN_HANDOFF_KEY") {
+		t.Fatalf("expected hand-off key error, got: %s", stderr.String())
+	}
+}
+
+func TestRunEmbedded_HandoffKeyFromCaller(t *testing.T) {
+	// The environment is ignored: the caller's key is the one checked.
+	t.Setenv("KATAMARAN_HANDOFF_KEY", "")
+	var stdout, stderr bytes.Buffer
+	code := katamaran.RunEmbedded(context.Background(), []string{
+		"--mode", "dest", "--qmp", "/tmp/qmp.sock",
+	}, &stdout, &stderr, katamaran.Embedded{MigrationID: "m-1", HandoffKey: "not-hex"})
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "KATAMARAN_HANDOFF_KEY") {
+		t.Fatalf("expected hand-off key error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_InvalidHooks(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := katamaran.Run(context.Background(), []string{
+		"--mode", "source", "--dest-ip", "10.0.0.1", "--qmp", "/tmp/qmp.sock", "--vm-ip", "10.0.0.2",
+		"--pre-cutover-hooks", "not-base64!",
+	}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--pre-cutover-hooks") {
+		t.Fatalf("expected hooks error, got: %s", stderr.String())
+	}
+}
+
 func TestRun_SourceInvalidDowntime(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	code := katamaran.Run(context.Background(), []string{
@@ -68,6 +68,9 @@ export KATAMARAN_MIGRATION_ID="${KATAMARAN_MIGRATION_ID:-}"
 export JOB_SUFFIX="${JOB_SUFFIX:-default}"
 SOURCE_JOB_NAME="katamaran-source-${JOB_SUFFIX}"
 DEST_JOB_NAME="katamaran-dest-${JOB_SUFFIX}"
+# Both Job templates read the hand-off key from this Secret; this script
+# mints it fresh for every run, like the Native orchestrator does.
+HANDOFF_SECRET_NAME="katamaran-handoff-${JOB_SUFFIX}"
 # Track --replay-cmdline staging resources so the EXIT trap can clean them
 # up even on early-exit error paths (kubectl wait/cp failures otherwise leak
 # a privileged stager pod into kube-system).
@@ -344,7 +347,7 @@ if [[ "$REPLAY_CMDLINE" == "true" ]]; then
     fi
     CMDLINE_FILENAME="cmdline-${KATAMARAN_MIGRATION_ID}.txt"
     CMDLINE_PATH="${CMDLINE_HOST_DIR}/${CMDLINE_FILENAME}"
-    SRC_EXTRA_ARGS="$SRC_EXTRA_ARGS --emit-cmdline-to ${CMDLINE_PATH}"
+    SRC_EXTRA_ARGS="$SRC_EXTRA_ARGS --emit-cmdline-to ${CMDLINE_PATH} --dest-ready-from-job kube-system/${DEST_JOB_NAME}"
     DEST_EXTRA_ARGS="$DEST_EXTRA_ARGS --replay-cmdline ${CMDLINE_PATH}"
 fi
 
@@ -357,7 +360,7 @@ cleanup() {
         "${KUBECTL[@]}" -n kube-system delete pod "$STAGER_POD" --ignore-not-found --force --grace-period=0 >/dev/null 2>&1 || true
     fi
     if [[ -n "$LOCAL_CMDLINE_TMP" ]]; then
-        rm -f "$LOCAL_CMDLINE_TMP"
+        rm -f "$LOCAL_CMDLINE_TMP" "${LOCAL_CMDLINE_TMP}.devices.json"
     fi
     if [[ "${KATAMARAN_KEEP_JOBS:-}" == "true" ]]; then
         echo ">>> KATAMARAN_KEEP_JOBS set, keeping migration jobs."
@@ -366,6 +369,7 @@ cleanup() {
     if [[ "$MIG_SUCCESS" == "true" ]]; then
         echo ">>> Cleaning up migration jobs..."
         "${KUBECTL[@]}" -n kube-system delete job "${DEST_JOB_NAME}" "${SOURCE_JOB_NAME}" --ignore-not-found 2>/dev/null || true
+        "${KUBECTL[@]}" -n kube-system delete secret "${HANDOFF_SECRET_NAME}" --ignore-not-found 2>/dev/null || true
     else
         echo ">>> Migration failed; keeping jobs for forensic debugging."
     fi
@@ -389,6 +393,12 @@ dump_debug() {
 
 echo ">>> Preparing migration..."
 "${KUBECTL[@]}" -n kube-system delete job "${DEST_JOB_NAME}" "${SOURCE_JOB_NAME}" --ignore-not-found
+"${KUBECTL[@]}" -n kube-system delete secret "${HANDOFF_SECRET_NAME}" --ignore-not-found
+HANDOFF_KEY="$(od -An -tx1 -N32 /dev/urandom | tr -d ' \n')"
+"${KUBECTL[@]}" -n kube-system create secret generic "${HANDOFF_SECRET_NAME}" \
+    --from-literal=key="${HANDOFF_KEY}" >/dev/null
+"${KUBECTL[@]}" -n kube-system label secret "${HANDOFF_SECRET_NAME}" app.kubernetes.io/name=katamaran >/dev/null
+unset HANDOFF_KEY
 
 # Build dest-job EXTRA_ARGS once. In replay-cmdline mode we still set --tap
 # below so the dest can install the sch_plug qdisc on its own tap0_kata
@@ -477,6 +487,9 @@ ship_cmdline_to_dest() {
         echo "Error: cmdline file from source pod is empty." >&2
         exit 1
     fi
+    # The hot-plugged device plan sidecar is written before the
+    # KATAMARAN_CMDLINE_AT marker, so it is already there.
+    "${KUBECTL[@]}" -n kube-system cp "$src_pod:${CMDLINE_PATH}.devices.json" "${LOCAL_CMDLINE_TMP}.devices.json"
 
     echo ">>> Pre-staging cmdline file on dest node $DEST_NODE..."
     # Use a one-shot sandbox pod on the dest node to drop the file into the
@@ -525,6 +538,7 @@ spec:
 EOF
     "${KUBECTL[@]}" -n kube-system wait --for=condition=Ready "pod/${STAGER_POD}" --timeout=120s
     "${KUBECTL[@]}" -n kube-system cp "$LOCAL_CMDLINE_TMP" "${STAGER_POD}:${CMDLINE_PATH}"
+    "${KUBECTL[@]}" -n kube-system cp "${LOCAL_CMDLINE_TMP}.devices.json" "${STAGER_POD}:${CMDLINE_PATH}.devices.json"
     # Best-effort early teardown; the EXIT trap will reap on failure.
     "${KUBECTL[@]}" -n kube-system delete pod "$STAGER_POD" --ignore-not-found --force --grace-period=0 2>/dev/null || true
     STAGER_POD=""
@@ -534,9 +548,10 @@ EOF
 }
 
 if [[ "$REPLAY_CMDLINE" == "true" ]]; then
-    # Source-first ordering: source captures and emits cmdline, then waits a
-    # fixed replay window. We ship the file and start dest so QEMU's incoming
-    # listener is ready before the source issues the real migrate command.
+    # Source-first ordering: source captures and emits cmdline, then waits
+    # for the dest job's KATAMARAN_DEST_READY log line. We ship the file and
+    # start dest; the source issues the real migrate command once QEMU's
+    # incoming listener is up.
     deploy_source_job
     ship_cmdline_to_dest
     deploy_dest_job
@@ -0,0 +1,6 @@
+// Package agentpb contains the generated gRPC bindings for the
+// katamaran-agent Agent protocol.
+//
+// The surrounding agent package is the intended in-repository consumer.
+// Regenerate the .pb.go files from agent.proto when the protocol changes.
+package agentpb
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// DefaultPort is the port katamaran-agent listens on. The agent runs in
+// the host network namespace, so the port is taken on every node.
+const DefaultPort = 9447
+
+// connectTimeout bounds how long Dialer waits for an agent to answer
+// before the migration falls back to Jobs.
+const connectTimeout = 5 * time.Second
+
+// Dialer returns an orchestrator.AgentDialer that reaches the agent of a
+// node at the address resolve returns for it (the node's InternalIP in
+// the cluster) and port, authenticating with creds. An address that
+// carries its own port keeps it.
+func Dialer(creds credentials.TransportCredentials, port int, resolve func(ctx context.Context, node string) (string, error)) orchestrator.AgentDialer {
+	return func(ctx context.Context, node string) (orchestrator.NodeAgent, error) {
+		host, err := resolve(ctx, node)
+		if err != nil {
+			return nil, fmt.Errorf("resolve agent address of node %s: %w", node, err)
+		}
+		addr := host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			addr = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", addr, err)
+		}
+		if err := waitReady(ctx, conn); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("agent %s: %w", addr, err)
+		}
+		return NewClient(conn), nil
+	}
+}
+
+// waitReady connects conn and waits until it is usable, so an agent
+// that is not running is noticed before the migration commits to it.
+func waitReady(ctx context.Context, conn *grpc.ClientConn) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("not ready after %s (last state %s)", connectTimeout, state)
+		}
+	}
+}
+
+// Client is an orchestrator.NodeAgent backed by an Agent gRPC
+// connection.
+type Client struct {
+	conn *grpc.ClientConn
+	api  agentpb.AgentClient
+}
+
+// NewClient wraps conn, which Close closes.
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn, api: agentpb.NewAgentClient(conn)}
+}
+
+// PrepareDest implements orchestrator.NodeAgent.
+func (c *Client) PrepareDest(ctx context.Context, id orchestrator.MigrationID, args []string, handoffKey string) error {
+	_, err := c.api.PrepareDest(ctx, &agentpb.RunRequest{MigrationId: string(id), Args: args, HandoffKey: handoffKey})
+	return err
+}
+
+// StartSource implements orchestrator.NodeAgent.
+func (c *Client) StartSource(ctx context.Context, id orchestrator.MigrationID, args []string, handoffKey string) error {
+	_, err := c.api.StartSource(ctx, &agentpb.RunRequest{MigrationId: string(id), Args: args, HandoffKey: handoffKey})
+	return err
+}
+
+// Watch implements orchestrator.NodeAgent.
+func (c *Client) Watch(ctx context.Context, id orchestrator.MigrationID, from int) (<-chan orchestrator.AgentEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.api.Watch(ctx, &agentpb.WatchRequest{MigrationId: string(id), From: int64(from)})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The agent sends headers once it has found the run; a missing run
+	// ends the stream without them, with the error left for Recv.
+	if md, _ := stream.Header(); md == nil {
+		defer cancel()
+		if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+			if status.Code(err) == codes.NotFound {
+				return nil, fmt.Errorf("%w: %s", orchestrator.ErrUnknownID, status.Convert(err).Message())
+			}
+			return nil, err
+		}
+		return nil, errors.New("agent ended the watch stream without events")
+	}
+
+	ch := make(chan orchestrator.AgentEvent)
+	go func() {
+		defer cancel()
+		defer close(ch)
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- orchestrator.AgentEvent{Line: ev.GetLine(), Exited: ev.GetExited(), ExitCode: int(ev.GetExitCode())}:
+			case <-ctx.Done():
+				return
+			}
+			if ev.GetExited() {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Cancel implements orchestrator.NodeAgent.
+func (c *Client) Cancel(ctx context.Context, id orchestrator.MigrationID) error {
+	_, err := c.api.Cancel(ctx, &agentpb.CancelRequest{MigrationId: string(id)})
+	return err
+}
+
+// Close implements orchestrator.NodeAgent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// Inventory lists the hosts the direct orchestrator
+// (orchestrator.NewDirect) migrates between, and how to reach each:
+//
+//	{"hosts": [
+//	  {"name": "host-a", "migrationIP": "10.0.0.10",
+//	   "ssh": {"address": "10.0.0.10", "user": "root"}},
+//	  {"name": "host-b", "agent": "10.0.0.11"}
+//	]}
+type Inventory struct {
+	Hosts []Host `json:"hosts"`
+}
+
+// Host is one entry of an Inventory. Exactly one of SSH and Agent is set.
+type Host struct {
+	// Name is what Request.SourceNode and DestNode refer to.
+	Name string `json:"name"`
+	// MigrationIP is the address the source sends the migration stream
+	// to when the host is the destination; it fills an empty
+	// Request.DestIP.
+	MigrationIP string `json:"migrationIP,omitempty"`
+	// SSH runs katamaran on the host over SSH.
+	SSH *SSH `json:"ssh,omitempty"`
+	// Agent is the host[:port] of the host's katamaran-agent (port
+	// DefaultPort when omitted).
+	Agent string `json:"agent,omitempty"`
+}
+
+// LoadInventory reads and checks the JSON inventory at path.
+func LoadInventory(path string) (*Inventory, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read inventory: %w", err)
+	}
+	var inv Inventory
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&inv); err != nil {
+		return nil, fmt.Errorf("decode inventory %s: %w", path, err)
+	}
+	if err := inv.validate(); err != nil {
+		return nil, fmt.Errorf("inventory %s: %w", path, err)
+	}
+	return &inv, nil
+}
+
+func (inv *Inventory) validate() error {
+	if len(inv.Hosts) == 0 {
+		return errors.New("no hosts")
+	}
+	seen := map[string]bool{}
+	for i, h := range inv.Hosts {
+		if h.Name == "" {
+			return fmt.Errorf("host %d: name is required", i)
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("host %s: listed twice", h.Name)
+		}
+		seen[h.Name] = true
+		if (h.SSH == nil) == (h.Agent == "") {
+			return fmt.Errorf("host %s: exactly one of ssh and agent is required", h.Name)
+		}
+		if h.MigrationIP != "" && net.ParseIP(h.MigrationIP) == nil {
+			return fmt.Errorf("host %s: migrationIP %q is not an IP address", h.Name, h.MigrationIP)
+		}
+	}
+	return nil
+}
+
+// Host returns the host called name.
+func (inv *Inventory) Host(name string) (Host, bool) {
+	for _, h := range inv.Hosts {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return Host{}, false
+}
+
+// Dialer returns an orchestrator.AgentDialer for the hosts of inv.
+// Agent hosts are dialled with creds; SSH hosts get one NewSSHServer
+// each, shared by all migrations. The returned function cancels the SSH
+// runs and waits for them to end.
+func (inv *Inventory) Dialer(creds credentials.TransportCredentials) (orchestrator.AgentDialer, func()) {
+	var (
+		mu      sync.Mutex
+		servers = map[string]*Server{}
+	)
+	grpcDial := Dialer(creds, DefaultPort, func(_ context.Context, node string) (string, error) {
+		h, _ := inv.Host(node)
+		return h.Agent, nil
+	})
+	dial := func(ctx context.Context, node string) (orchestrator.NodeAgent, error) {
+		h, ok := inv.Host(node)
+		if !ok {
+			return nil, fmt.Errorf("host %s is not in the inventory", node)
+		}
+		if h.Agent != "" {
+			if creds == nil {
+				return nil, fmt.Errorf("host %s: no TLS credentials for its agent", node)
+			}
+			return grpcDial(ctx, node)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		s, ok := servers[node]
+		if !ok {
+			cfg := *h.SSH
+			if cfg.Address == "" {
+				cfg.Address = h.Name
+			}
+			s = NewSSHServer(cfg)
+			servers[node] = s
+		}
+		return Local(s), nil
+	}
+	closeAll := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Close()
+			}()
+		}
+		wg.Wait()
+	}
+	return dial, closeAll
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+func writeInventory(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadInventory(t *testing.T) {
+	t.Parallel()
+	inv, err := LoadInventory(writeInventory(t, `{"hosts": [
+		{"name": "a", "migrationIP": "10.0.0.10", "ssh": {"address": "10.0.0.10", "user": "root"}},
+		{"name": "b", "agent": "10.0.0.11:9500"}
+	]}`))
+	if err != nil {
+		t.Fatalf("LoadInventory: %v", err)
+	}
+	if h, ok := inv.Host("a"); !ok || h.SSH.User != "root" || h.MigrationIP != "10.0.0.10" {
+		t.Errorf("host a = %+v, %v", h, ok)
+	}
+	if _, ok := inv.Host("c"); ok {
+		t.Error("unknown host found")
+	}
+}
+
+func TestLoadInventory_Errors(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"empty", `{"hosts": []}`, "no hosts"},
+		{"unknown field", `{"hosts": [{"name": "a", "agent": "x", "port": 1}]}`, "unknown field"},
+		{"no name", `{"hosts": [{"agent": "x"}]}`, "name is required"},
+		{"duplicate", `{"hosts": [{"name": "a", "agent": "x"}, {"name": "a", "agent": "y"}]}`, "listed twice"},
+		{"no transport", `{"hosts": [{"name": "a"}]}`, "exactly one of ssh and agent"},
+		{"two transports", `{"hosts": [{"name": "a", "agent": "x", "ssh": {}}]}`, "exactly one of ssh and agent"},
+		{"bad IP", `{"hosts": [{"name": "a", "agent": "x", "migrationIP": "host-a"}]}`, "not an IP address"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := LoadInventory(writeInventory(t, tc.body))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("LoadInventory error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestInventory_Dialer(t *testing.T) {
+	t.Parallel()
+	inv := &Inventory{Hosts: []Host{
+		{Name: "a", SSH: &SSH{Command: []string{"sh", "-c"}, Katamaran: fakeKatamaran(t)}},
+		{Name: "b", Agent: "127.0.0.1"},
+	}}
+	dial, closeAll := inv.Dialer(nil)
+	ctx := context.Background()
+
+	a, err := dial(ctx, "a")
+	if err != nil {
+		t.Fatalf("dial a: %v", err)
+	}
+	if err := a.PrepareDest(ctx, "m1", destMode, ""); err != nil {
+		t.Fatalf("PrepareDest: %v", err)
+	}
+	_ = a.Close()
+
+	// A second connection reaches the same runs.
+	again, err := dial(ctx, "a")
+	if err != nil {
+		t.Fatalf("dial a again: %v", err)
+	}
+	ch, err := again.Watch(ctx, "m1", 0)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	closeAll()
+	if got := collect(t, ch); len(got) == 0 || !got[len(got)-1].Exited {
+		t.Fatalf("events after closeAll = %+v, want the run ended", got)
+	}
+
+	if _, err := dial(ctx, "b"); err == nil || !strings.Contains(err.Error(), "no TLS credentials") {
+		t.Errorf("dial of an agent host without credentials = %v", err)
+	}
+	if _, err := dial(ctx, "c"); err == nil {
+		t.Error("dial of an unknown host succeeded")
+	}
+	if _, err := again.Watch(ctx, "nope", 0); !errors.Is(err, orchestrator.ErrUnknownID) {
+		t.Errorf("Watch unknown = %v, want ErrUnknownID", err)
+	}
+}
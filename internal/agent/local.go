@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// local is an orchestrator.NodeAgent that calls a Server in the same
+// process, without gRPC in between.
+type local struct {
+	s *Server
+}
+
+// Local returns an orchestrator.NodeAgent backed by s directly. Its
+// Close leaves s running; the caller owns s.
+func Local(s *Server) orchestrator.NodeAgent {
+	return local{s: s}
+}
+
+// PrepareDest implements orchestrator.NodeAgent.
+func (l local) PrepareDest(ctx context.Context, id orchestrator.MigrationID, args []string, handoffKey string) error {
+	_, err := l.s.PrepareDest(ctx, &agentpb.RunRequest{MigrationId: string(id), Args: args, HandoffKey: handoffKey})
+	return err
+}
+
+// StartSource implements orchestrator.NodeAgent.
+func (l local) StartSource(ctx context.Context, id orchestrator.MigrationID, args []string, handoffKey string) error {
+	_, err := l.s.StartSource(ctx, &agentpb.RunRequest{MigrationId: string(id), Args: args, HandoffKey: handoffKey})
+	return err
+}
+
+// Watch implements orchestrator.NodeAgent.
+func (l local) Watch(ctx context.Context, id orchestrator.MigrationID, from int) (<-chan orchestrator.AgentEvent, error) {
+	if from < 0 {
+		return nil, errors.New("from must not be negative")
+	}
+	r, err := l.s.lookup(string(id))
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", orchestrator.ErrUnknownID, status.Convert(err).Message())
+	}
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan orchestrator.AgentEvent)
+	go func() {
+		defer close(ch)
+		_ = r.follow(ctx, from, func(ev *agentpb.WatchEvent) error {
+			select {
+			case ch <- orchestrator.AgentEvent{Line: ev.GetLine(), Exited: ev.GetExited(), ExitCode: int(ev.GetExitCode())}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ch, nil
+}
+
+// Cancel implements orchestrator.NodeAgent.
+func (l local) Cancel(ctx context.Context, id orchestrator.MigrationID) error {
+	_, err := l.s.Cancel(ctx, &agentpb.CancelRequest{MigrationId: string(id)})
+	return err
+}
+
+// Close implements orchestrator.NodeAgent.
+func (local) Close() error { return nil }
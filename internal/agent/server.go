@@ -0,0 +1,424 @@
+// Package agent implements katamaran-agent, the long-lived per-node
+// process that runs migrations in-process instead of in per-migration
+// Jobs, and the orchestrator's gRPC client for it.
+//
+// The agent serves the Agent service of agentpb over mutual TLS. Each
+// PrepareDest or StartSource call starts one `katamaran` run in a
+// goroutine with the arguments the Job would have been given; Watch
+// streams the run's KATAMARAN_* stdout markers and its exit code, the
+// same interface the Job-based orchestrator scrapes from pod logs.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/katamaran"
+)
+
+// RunFunc runs one `katamaran` invocation and returns its exit code.
+// katamaran.RunEmbedded in production.
+type RunFunc func(ctx context.Context, args []string, stdout, stderr io.Writer, e katamaran.Embedded) int
+
+const (
+	// destReadyMarker is the line prefix the destination logs once its
+	// NBD and migration listeners are up.
+	destReadyMarker = "KATAMARAN_DEST_READY "
+
+	// defaultReadyTimeout bounds how long PrepareDest waits for
+	// destReadyMarker. The destination may first wait for the QEMU of a
+	// freshly created sandbox.
+	defaultReadyTimeout = 5 * time.Minute
+
+	// defaultRetention is how long a finished run stays watchable, so an
+	// orchestrator whose stream broke near the end still reads the exit.
+	defaultRetention = 10 * time.Minute
+
+	// stderrTail is how much of a run's stderr is logged when it fails.
+	stderrTail = 4 << 10
+)
+
+// Server implements agentpb.AgentServer.
+type Server struct {
+	agentpb.UnimplementedAgentServer
+
+	runFn        RunFunc
+	readyTimeout time.Duration
+	retention    time.Duration
+	// remote is set when runs execute on another host, which then
+	// creates the QMP socket's directory itself.
+	remote bool
+
+	mu     sync.Mutex
+	runs   map[string]*run
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer returns a Server that runs migrations with fn, or with
+// katamaran.RunEmbedded when fn is nil.
+func NewServer(fn RunFunc) *Server {
+	if fn == nil {
+		fn = katamaran.RunEmbedded
+	}
+	return &Server{
+		runFn:        fn,
+		readyTimeout: defaultReadyTimeout,
+		retention:    defaultRetention,
+		runs:         map[string]*run{},
+	}
+}
+
+// run is one in-process `katamaran` invocation. Its stdout is kept line
+// by line for Watch; changed is closed and replaced whenever a line is
+// added or the run exits.
+type run struct {
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	lines    []string
+	partial  []byte
+	exited   bool
+	exitCode int
+	endedAt  time.Time
+	changed  chan struct{}
+}
+
+// Write implements io.Writer for the run's stdout.
+func (r *run) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, p...)
+	added := false
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.lines = append(r.lines, strings.TrimSuffix(string(r.partial[:i]), "\r"))
+		r.partial = r.partial[i+1:]
+		added = true
+	}
+	if added {
+		r.notifyLocked()
+	}
+	return len(p), nil
+}
+
+func (r *run) exit(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 {
+		r.lines = append(r.lines, string(r.partial))
+		r.partial = nil
+	}
+	r.exited, r.exitCode, r.endedAt = true, code, time.Now()
+	r.notifyLocked()
+}
+
+func (r *run) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// snapshot returns the lines from index from on, the exit state, and a
+// channel closed on the next change.
+func (r *run) snapshot(from int) (lines []string, exited bool, code int, changed <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Lines are only ever appended, so the sub-slice stays valid
+	// after the lock is released.
+	return r.lines[min(from, len(r.lines)):], r.exited, r.exitCode, r.changed
+}
+
+// follow calls send with each stdout line from index from on, then with
+// the exit event. It returns send's error, or ctx's when it ends first.
+func (r *run) follow(ctx context.Context, from int, send func(*agentpb.WatchEvent) error) error {
+	next := from
+	for {
+		lines, exited, code, changed := r.snapshot(next)
+		for _, l := range lines {
+			if err := send(&agentpb.WatchEvent{Line: l}); err != nil {
+				return err
+			}
+		}
+		next += len(lines)
+		if exited {
+			return send(&agentpb.WatchEvent{Exited: true, ExitCode: int32(code)})
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *run) done() (bool, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exited, r.endedAt
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = b.buf[over:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
+
+// checkMode rejects req unless its args select --mode side, so that the
+// API only starts migration sides and not other katamaran modes such as
+// probe.
+func checkMode(req *agentpb.RunRequest, side string) error {
+	modes := modeArgs(req.GetArgs())
+	if len(modes) == 0 || slices.ContainsFunc(modes, func(m string) bool { return m != side }) {
+		return status.Errorf(codes.InvalidArgument, "args must select --mode %s, got %q", side, modes)
+	}
+	return nil
+}
+
+// start launches the run described by req. side is "source" or "dest"
+// and only used in log entries.
+func (s *Server) start(req *agentpb.RunRequest, side string) (*run, error) {
+	id := req.GetMigrationId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "migration_id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, status.Error(codes.Unavailable, "agent shutting down")
+	}
+	s.reapLocked()
+	if old, ok := s.runs[id]; ok {
+		if exited, _ := old.done(); !exited {
+			return nil, status.Errorf(codes.AlreadyExists, "migration %s is already running on this node", id)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{cancel: cancel, changed: make(chan struct{})}
+	s.runs[id] = r
+	args := append([]string(nil), req.GetArgs()...)
+	e := katamaran.Embedded{MigrationID: id, HandoffKey: req.GetHandoffKey()}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		stderr := &tailBuffer{max: stderrTail}
+		code := s.invoke(ctx, args, r, stderr, e)
+		r.exit(code)
+		if code != 0 {
+			slog.Warn("Migration run failed", "migration_id", id, "side", side, "exit_code", code, "stderr", stderr.String())
+			return
+		}
+		slog.Info("Migration run finished", "migration_id", id, "side", side)
+	}()
+	slog.Info("Migration run started", "migration_id", id, "side", side)
+	return r, nil
+}
+
+// invoke calls the RunFunc, turning a panic into exit code 1 so that one
+// broken migration does not take down the others running on the node.
+func (s *Server) invoke(ctx context.Context, args []string, stdout, stderr io.Writer, e katamaran.Embedded) (code int) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("Migration run panicked", "migration_id", e.MigrationID, "panic", rec, "stack", string(debug.Stack()))
+			code = 1
+		}
+	}()
+	return s.runFn(ctx, args, stdout, stderr, e)
+}
+
+// reapLocked forgets runs that finished more than the retention period
+// ago. s.mu must be held.
+func (s *Server) reapLocked() {
+	cutoff := time.Now().Add(-s.retention)
+	for id, r := range s.runs {
+		if exited, at := r.done(); exited && at.Before(cutoff) {
+			delete(s.runs, id)
+		}
+	}
+}
+
+func (s *Server) lookup(id string) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reapLocked()
+	r, ok := s.runs[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no migration %s on this node", id)
+	}
+	return r, nil
+}
+
+// PrepareDest starts the destination side and returns once it logs
+// KATAMARAN_DEST_READY. The run is cancelled if it does not get there.
+func (s *Server) PrepareDest(ctx context.Context, req *agentpb.RunRequest) (*agentpb.RunResponse, error) {
+	if err := checkMode(req, "dest"); err != nil {
+		return nil, err
+	}
+	// The dest Job creates the QMP socket's directory before starting
+	// katamaran (`mkdir -p "$(dirname "${QMP_SOCKET}")"`); so does the agent.
+	if dir := qmpDir(req.GetArgs()); dir != "" && !s.remote {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, status.Errorf(codes.Internal, "create QMP socket directory: %v", err)
+		}
+	}
+	r, err := s.start(req, "dest")
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(s.readyTimeout)
+	defer timer.Stop()
+	next := 0
+	for {
+		lines, exited, code, changed := r.snapshot(next)
+		for _, l := range lines {
+			if strings.HasPrefix(l, destReadyMarker) {
+				return &agentpb.RunResponse{}, nil
+			}
+		}
+		next += len(lines)
+		if exited {
+			return nil, status.Errorf(codes.FailedPrecondition, "destination exited with code %d before it was ready", code)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			r.cancel()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+			r.cancel()
+			return nil, status.Errorf(codes.DeadlineExceeded, "destination not ready after %s", s.readyTimeout)
+		}
+	}
+}
+
+// StartSource starts the source side and returns immediately.
+func (s *Server) StartSource(_ context.Context, req *agentpb.RunRequest) (*agentpb.RunResponse, error) {
+	if err := checkMode(req, "source"); err != nil {
+		return nil, err
+	}
+	if _, err := s.start(req, "source"); err != nil {
+		return nil, err
+	}
+	return &agentpb.RunResponse{}, nil
+}
+
+// Watch streams the run's stdout lines from req.From on, then its exit.
+func (s *Server) Watch(req *agentpb.WatchRequest, stream agentpb.Agent_WatchServer) error {
+	if req.GetFrom() < 0 {
+		return status.Error(codes.InvalidArgument, "from must not be negative")
+	}
+	r, err := s.lookup(req.GetMigrationId())
+	if err != nil {
+		return err
+	}
+	// Send the headers now so the client can tell a known run that has
+	// nothing new yet from an unknown one without waiting for an event.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	err = r.follow(stream.Context(), int(req.GetFrom()), stream.Send)
+	if ctxErr := stream.Context().Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return err
+}
+
+// Cancel stops the run. Unknown and finished runs are not an error.
+func (s *Server) Cancel(_ context.Context, req *agentpb.CancelRequest) (*agentpb.CancelResponse, error) {
+	if r, err := s.lookup(req.GetMigrationId()); err == nil {
+		r.cancel()
+		slog.Info("Migration run cancelled", "migration_id", req.GetMigrationId())
+	}
+	return &agentpb.CancelResponse{}, nil
+}
+
+// Close cancels every run, waits for them to exit, and rejects new ones.
+// A cancelled run cleans up as `katamaran` does on SIGTERM.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, r := range s.runs {
+		r.cancel()
+	}
+	n := len(s.runs)
+	s.mu.Unlock()
+	if n > 0 {
+		slog.Info("Waiting for migration runs to stop", "runs", n)
+	}
+	s.wg.Wait()
+}
+
+// modeArgs returns the values of every --mode argument in args.
+func modeArgs(args []string) []string {
+	var modes []string
+	for i, a := range args {
+		switch {
+		case (a == "--mode" || a == "-mode") && i+1 < len(args):
+			modes = append(modes, args[i+1])
+		case strings.HasPrefix(a, "--mode="), strings.HasPrefix(a, "-mode="):
+			modes = append(modes, a[strings.Index(a, "=")+1:])
+		case a == "--mode" || a == "-mode":
+			// A trailing flag without a value; katamaran rejects it, but
+			// it must not pass as "no mode".
+			modes = append(modes, "")
+		}
+	}
+	return modes
+}
+
+// qmpDir returns the directory of the --qmp argument in args, or "".
+func qmpDir(args []string) string {
+	for i, a := range args {
+		var path string
+		switch {
+		case (a == "--qmp" || a == "-qmp") && i+1 < len(args):
+			path = args[i+1]
+		case strings.HasPrefix(a, "--qmp="), strings.HasPrefix(a, "-qmp="):
+			path = a[strings.Index(a, "=")+1:]
+		default:
+			continue
+		}
+		if dir := filepath.Dir(path); dir != "." && dir != "/" {
+			return dir
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/katamaran"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// serve starts srv on an in-memory listener and returns a Client for it.
+func serve(t *testing.T, srv *Server) *Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	agentpb.RegisterAgentServer(gs, srv)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(func() {
+		srv.Close()
+		gs.Stop()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c := NewClient(conn)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// collect reads events until the exit event or the timeout.
+func collect(t *testing.T, ch <-chan orchestrator.AgentEvent) []orchestrator.AgentEvent {
+	t.Helper()
+	var got []orchestrator.AgentEvent
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("watch did not finish; got %+v", got)
+		}
+	}
+}
+
+// Minimal args of each side; the server rejects runs without them.
+var (
+	sourceMode = []string{"--mode", "source"}
+	destMode   = []string{"--mode", "dest"}
+)
+
+// destRun is a RunFunc that behaves like a destination: it logs its
+// readiness, then waits for cancellation.
+func destRun(ctx context.Context, args []string, stdout, _ io.Writer, e katamaran.Embedded) int {
+	fmt.Fprintf(stdout, "KATAMARAN_ARGS %s key=%s\n", strings.Join(args, ","), e.HandoffKey)
+	// Markers may arrive in pieces.
+	fmt.Fprint(stdout, "KATAMARAN_DEST_READY sandbox_id= migration_port=4444")
+	fmt.Fprint(stdout, " nbd_port=10809\n")
+	<-ctx.Done()
+	return 1
+}
+
+func TestServer_PrepareDestWatchCancel(t *testing.T) {
+	t.Parallel()
+	c := serve(t, NewServer(destRun))
+	ctx := context.Background()
+
+	if err := c.PrepareDest(ctx, "m1", []string{"--mode", "dest"}, "k1"); err != nil {
+		t.Fatalf("PrepareDest: %v", err)
+	}
+	ch, err := c.Watch(ctx, "m1", 1)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := c.Cancel(ctx, "m1"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	got := collect(t, ch)
+	want := []orchestrator.AgentEvent{
+		{Line: "KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"},
+		{Exited: true, ExitCode: 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("events = %+v, want %+v", got, want)
+	}
+
+	// The finished run is still watchable from the start.
+	ch, err = c.Watch(ctx, "m1", 0)
+	if err != nil {
+		t.Fatalf("second Watch: %v", err)
+	}
+	if got := collect(t, ch); len(got) != 3 || got[0].Line != "KATAMARAN_ARGS --mode,dest key=k1" {
+		t.Fatalf("replayed events = %+v", got)
+	}
+}
+
+func TestServer_PrepareDestExitsBeforeReady(t *testing.T) {
+	t.Parallel()
+	c := serve(t, NewServer(func(context.Context, []string, io.Writer, io.Writer, katamaran.Embedded) int { return 2 }))
+
+	err := c.PrepareDest(context.Background(), "m1", destMode, "")
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "code 2") {
+		t.Fatalf("PrepareDest error = %v, want FailedPrecondition with the exit code", err)
+	}
+}
+
+func TestServer_PrepareDestTimeout(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(func(ctx context.Context, _ []string, _, _ io.Writer, _ katamaran.Embedded) int {
+		<-ctx.Done()
+		return 1
+	})
+	srv.readyTimeout = 50 * time.Millisecond
+	c := serve(t, srv)
+
+	if err := c.PrepareDest(context.Background(), "m1", destMode, ""); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("PrepareDest error = %v, want DeadlineExceeded", err)
+	}
+	ch, err := c.Watch(context.Background(), "m1", 0)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := collect(t, ch); len(got) != 1 || !got[0].Exited {
+		t.Fatalf("events = %+v, want the run cancelled", got)
+	}
+}
+
+func TestServer_RunErrors(t *testing.T) {
+	t.Parallel()
+	block := make(chan struct{})
+	defer close(block)
+	c := serve(t, NewServer(func(ctx context.Context, args []string, _, _ io.Writer, _ katamaran.Embedded) int {
+		if slices.Contains(args, "panic") {
+			panic("boom")
+		}
+		select {
+		case <-ctx.Done():
+		case <-block:
+		}
+		return 0
+	}))
+	ctx := context.Background()
+
+	if err := c.StartSource(ctx, "", sourceMode, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("StartSource without ID = %v, want InvalidArgument", err)
+	}
+	for _, args := range [][]string{
+		nil,
+		{"--mode", "dest"},
+		{"--mode", "probe"},
+		{"--mode=probe"},
+		{"--mode", "source", "-mode=probe"},
+		{"--mode", "source", "--mode"},
+	} {
+		if err := c.StartSource(ctx, "m0", args, ""); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("StartSource with %q = %v, want InvalidArgument", args, err)
+		}
+	}
+	if err := c.PrepareDest(ctx, "m0", sourceMode, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PrepareDest with --mode source = %v, want InvalidArgument", err)
+	}
+	if err := c.StartSource(ctx, "m1", []string{"--mode=source"}, ""); err != nil {
+		t.Fatalf("StartSource: %v", err)
+	}
+	if err := c.StartSource(ctx, "m1", sourceMode, ""); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("second StartSource = %v, want AlreadyExists", err)
+	}
+	if _, err := c.Watch(ctx, "nope", 0); !errors.Is(err, orchestrator.ErrUnknownID) {
+		t.Errorf("Watch unknown = %v, want ErrUnknownID", err)
+	}
+	if err := c.Cancel(ctx, "nope"); err != nil {
+		t.Errorf("Cancel unknown = %v, want nil", err)
+	}
+
+	if err := c.StartSource(ctx, "m2", []string{"--mode", "source", "panic"}, ""); err != nil {
+		t.Fatalf("StartSource: %v", err)
+	}
+	ch, err := c.Watch(ctx, "m2", 0)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := collect(t, ch); len(got) != 1 || got[0].ExitCode != 1 {
+		t.Fatalf("events of a panicking run = %+v, want exit code 1", got)
+	}
+}
+
+func TestServer_CloseCancelsRuns(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(destRun)
+	c := serve(t, srv)
+	ctx := context.Background()
+
+	if err := c.PrepareDest(ctx, "m1", destMode, ""); err != nil {
+		t.Fatalf("PrepareDest: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if err := c.StartSource(ctx, "m2", sourceMode, ""); status.Code(err) != codes.Unavailable {
+		t.Fatalf("StartSource after Close = %v, want Unavailable", err)
+	}
+}
+
+func TestQMPDir(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--mode", "dest", "--qmp", "/run/vc/vm/x/qmp.sock"}, "/run/vc/vm/x"},
+		{[]string{"--qmp=/tmp/q/qmp.sock"}, "/tmp/q"},
+		{[]string{"--qmp", "qmp.sock"}, ""},
+		{[]string{"--qmp"}, ""},
+		{nil, ""},
+	} {
+		if got := qmpDir(tc.args); got != tc.want {
+			t.Errorf("qmpDir(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/katamaran"
+)
+
+// sshStopGrace is how long a cancelled remote run gets to clean up (as
+// `katamaran` does on SIGTERM) before the ssh process is killed.
+const sshStopGrace = 30 * time.Second
+
+// SSH describes how to run `katamaran` on a host over SSH.
+type SSH struct {
+	// Address is the host to connect to.
+	Address string `json:"address,omitempty"`
+	// User, Port, IdentityFile and Options (extra `-o` settings) are
+	// passed to ssh when set.
+	User         string   `json:"user,omitempty"`
+	Port         int      `json:"port,omitempty"`
+	IdentityFile string   `json:"identityFile,omitempty"`
+	Options      []string `json:"options,omitempty"`
+	// Sudo runs katamaran with `sudo -n`, for a login that is not root.
+	Sudo bool `json:"sudo,omitempty"`
+	// Command replaces the ssh invocation: it is run with the remote
+	// shell command line appended as its last argument, e.g.
+	// ["docker", "exec", "-i", "node1", "sh", "-c"]. It must pass stdin
+	// through.
+	Command []string `json:"command,omitempty"`
+	// Katamaran is the path of the binary on the host (default
+	// "katamaran", looked up in the remote PATH).
+	Katamaran string `json:"katamaran,omitempty"`
+}
+
+// NewSSHServer returns a Server whose runs execute `katamaran` on the
+// host cfg describes. It implements the same API as the agent's, so the
+// orchestrator drives a host without katamaran-agent through Local.
+func NewSSHServer(cfg SSH) *Server {
+	s := NewServer(cfg.run)
+	s.remote = true
+	return s
+}
+
+// run is a RunFunc. Cancelling ctx closes the remote command's stdin,
+// on which the remote shell sends katamaran SIGTERM; the ssh process is
+// killed if the run has not ended after sshStopGrace.
+func (cfg SSH) run(ctx context.Context, args []string, stdout, stderr io.Writer, e katamaran.Embedded) int {
+	argv := cfg.argv(remoteScript(cfg, args, e))
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Fprintf(stderr, "ssh stdin: %v\n", err)
+		return 1
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(stderr, "start %s: %v\n", argv[0], err)
+		return 1
+	}
+	if e.HandoffKey != "" {
+		// The key travels on stdin rather than in the command line, which
+		// other users of the host can read.
+		if _, err := io.WriteString(stdin, e.HandoffKey+"\n"); err != nil {
+			slog.Warn("Cannot send the hand-off key to the remote run", "migration_id", e.MigrationID, "error", err)
+		}
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		_ = stdin.Close()
+		select {
+		case err = <-waited:
+		case <-time.After(sshStopGrace):
+			_ = cmd.Process.Kill()
+			err = <-waited
+		}
+	}
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	fmt.Fprintf(stderr, "%s: %v\n", argv[0], err)
+	return 1
+}
+
+// argv returns the command that runs script on the host.
+func (cfg SSH) argv(script string) []string {
+	if len(cfg.Command) > 0 {
+		return append(append([]string(nil), cfg.Command...), script)
+	}
+	argv := []string{"ssh", "-T", "-o", "BatchMode=yes"}
+	if cfg.Port != 0 {
+		argv = append(argv, "-p", strconv.Itoa(cfg.Port))
+	}
+	if cfg.IdentityFile != "" {
+		argv = append(argv, "-i", cfg.IdentityFile)
+	}
+	for _, o := range cfg.Options {
+		argv = append(argv, "-o", o)
+	}
+	target := cfg.Address
+	if cfg.User != "" {
+		target = cfg.User + "@" + target
+	}
+	return append(argv, target, "--", script)
+}
+
+// remoteScript returns the POSIX shell command line that runs katamaran
+// with args on the host. katamaran runs in the background so that the
+// shell can send it SIGTERM once stdin reaches EOF, i.e. once the
+// orchestrator cancels the run or goes away; an SSH session without a
+// terminal does not signal the remote command by itself.
+func remoteScript(cfg SSH, args []string, e katamaran.Embedded) string {
+	bin := cfg.Katamaran
+	if bin == "" {
+		bin = "katamaran"
+	}
+	sudo, mkdir := "", "mkdir -p "
+	if cfg.Sudo {
+		sudo = "sudo -n --preserve-env=KATAMARAN_MIGRATION_ID,KATAMARAN_HANDOFF_KEY "
+		mkdir = "sudo -n mkdir -p "
+	}
+	var b strings.Builder
+	b.WriteString("export KATAMARAN_MIGRATION_ID=" + shellQuote(e.MigrationID) + "; ")
+	if e.HandoffKey != "" {
+		b.WriteString("IFS= read -r KATAMARAN_HANDOFF_KEY; export KATAMARAN_HANDOFF_KEY; ")
+	}
+	// The dest Job creates the QMP socket's directory; so must we.
+	if dir := qmpDir(args); dir != "" {
+		b.WriteString(mkdir + shellQuote(dir) + " || exit 1; ")
+	}
+	// Asynchronous commands get /dev/null as stdin, so keep it on fd 3.
+	b.WriteString("exec 3<&0; ")
+	b.WriteString(sudo + shellQuote(bin))
+	for _, a := range args {
+		b.WriteString(" " + shellQuote(a))
+	}
+	b.WriteString(" & pid=$!; ")
+	b.WriteString("(cat >/dev/null; kill -TERM $pid) <&3 >/dev/null 2>&1 & watcher=$!; ")
+	b.WriteString("wait $pid; code=$?; kill $watcher 2>/dev/null; exit $code")
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
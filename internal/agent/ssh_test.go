@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/katamaran"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// fakeKatamaran writes a shell script that behaves like a destination
+// `katamaran`: it prints its arguments and environment, logs readiness,
+// and exits 3 on SIGTERM.
+func fakeKatamaran(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "katamaran")
+	script := `#!/bin/sh
+trap 'echo "KATAMARAN_TERM"; exit 3' TERM
+echo "KATAMARAN_ARGS $* id=${KATAMARAN_MIGRATION_ID} key=${KATAMARAN_HANDOFF_KEY}"
+echo "KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"
+while :; do sleep 0.05; done
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]string{
+		"":                    "''",
+		"--qmp":               "--qmp",
+		"/run/vc/vm/x/q.sock": "/run/vc/vm/x/q.sock",
+		"a b":                 "'a b'",
+		"it's":                `'it'\''s'`,
+		"$(reboot)":           "'$(reboot)'",
+	} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestSSH_Argv(t *testing.T) {
+	t.Parallel()
+	got := SSH{Address: "10.0.0.1", User: "core", Port: 2222, IdentityFile: "/k", Options: []string{"StrictHostKeyChecking=no"}}.argv("true")
+	want := []string{"ssh", "-T", "-o", "BatchMode=yes", "-p", "2222", "-i", "/k", "-o", "StrictHostKeyChecking=no", "core@10.0.0.1", "--", "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("argv = %q, want %q", got, want)
+	}
+	got = SSH{Command: []string{"docker", "exec", "-i", "n1", "sh", "-c"}}.argv("true")
+	want = []string{"docker", "exec", "-i", "n1", "sh", "-c", "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("argv with Command = %q, want %q", got, want)
+	}
+}
+
+func TestSSHServer_RunsAndCancels(t *testing.T) {
+	t.Parallel()
+	qmp := filepath.Join(t.TempDir(), "vm x", "qmp.sock")
+	srv := NewSSHServer(SSH{Command: []string{"sh", "-c"}, Katamaran: fakeKatamaran(t)})
+	t.Cleanup(srv.Close)
+	a := Local(srv)
+	ctx := context.Background()
+
+	if err := a.PrepareDest(ctx, "m1", []string{"--mode", "dest", "--qmp", qmp}, "k'1"); err != nil {
+		t.Fatalf("PrepareDest: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(qmp)); err != nil {
+		t.Errorf("QMP socket directory not created: %v", err)
+	}
+	ch, err := a.Watch(ctx, "m1", 0)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := a.Cancel(ctx, "m1"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	got := collect(t, ch)
+	if len(got) != 4 {
+		t.Fatalf("events = %+v, want args, ready, term and exit", got)
+	}
+	if want := "KATAMARAN_ARGS --mode dest --qmp " + qmp + " id=m1 key=k'1"; got[0].Line != want {
+		t.Errorf("first line = %q, want %q", got[0].Line, want)
+	}
+	if got[2].Line != "KATAMARAN_TERM" || !got[3].Exited || got[3].ExitCode != 3 {
+		t.Errorf("run did not end on SIGTERM: %+v", got[2:])
+	}
+}
+
+func TestRemoteScript_Sudo(t *testing.T) {
+	t.Parallel()
+	s := remoteScript(SSH{Sudo: true, Katamaran: "/opt/k"}, []string{"--qmp", "/run/q/qmp.sock"}, katamaran.Embedded{MigrationID: "m1"})
+	for _, want := range []string{
+		"export KATAMARAN_MIGRATION_ID=m1;",
+		"sudo -n mkdir -p /run/q ||",
+		"sudo -n --preserve-env=KATAMARAN_MIGRATION_ID,KATAMARAN_HANDOFF_KEY /opt/k --qmp /run/q/qmp.sock &",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("script %q lacks %q", s, want)
+		}
+	}
+	if strings.Contains(s, "read -r") {
+		t.Errorf("script %q reads a hand-off key that was not given", s)
+	}
+}
+
+// TestDirect_HandoffKeyReachesHosts drives a direct migration with the
+// destination over SSH and the source through katamaran-agent, and
+// checks that both runs get the same per-migration hand-off key.
+func TestDirect_HandoffKeyReachesHosts(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sshSrv := NewSSHServer(SSH{Command: []string{"sh", "-c"}, Katamaran: fakeKatamaran(t)})
+	t.Cleanup(sshSrv.Close)
+	srcKeys := make(chan string, 1)
+	agentClient := serve(t, NewServer(func(ctx context.Context, _ []string, stdout, _ io.Writer, e katamaran.Embedded) int {
+		srcKeys <- e.HandoffKey
+		fmt.Fprintln(stdout, "KATAMARAN_SOURCE_STARTED")
+		<-ctx.Done()
+		return 1
+	}))
+	hosts := map[string]orchestrator.NodeAgent{"host-a": agentClient, "host-b": Local(sshSrv)}
+	o := orchestrator.NewDirect(func(_ context.Context, node string) (orchestrator.NodeAgent, error) {
+		return nopCloser{hosts[node]}, nil
+	})
+
+	ctx := context.Background()
+	id, err := o.Apply(ctx, orchestrator.Request{
+		SourceNode: "host-a",
+		DestNode:   "host-b",
+		DestIP:     "10.0.0.20",
+		SourceQMP:  filepath.Join(dir, "src", "qmp.sock"),
+		DestQMP:    filepath.Join(dir, "dst", "qmp.sock"),
+		VMIP:       "10.244.1.5",
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	var srcKey string
+	select {
+	case srcKey = <-srcKeys:
+	case <-time.After(10 * time.Second):
+		t.Fatal("source run was not started")
+	}
+	ch, err := Local(sshSrv).Watch(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("Watch dest: %v", err)
+	}
+	first := <-ch
+	_, destKey, _ := strings.Cut(first.Line, " key=")
+	if len(srcKey) != 64 || destKey != srcKey {
+		t.Errorf("hand-off keys: source %q, destination line %q; want the same 64-hex-digit key", srcKey, first.Line)
+	}
+
+	updates, err := o.Watch(ctx, id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := o.Stop(ctx, id); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	timeout := time.After(10 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-updates:
+		case <-timeout:
+			t.Fatal("migration did not end after Stop")
+		}
+	}
+}
+
+// nopCloser keeps the orchestrator from closing an agent connection the
+// test owns.
+type nopCloser struct{ orchestrator.NodeAgent }
+
+func (nopCloser) Close() error { return nil }
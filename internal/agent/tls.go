@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ServerName is the name agent certificates must carry as a DNS SAN.
+// Agents are dialled by node IP, so clients verify this fixed name
+// instead of the address.
+const ServerName = "katamaran-agent"
+
+// The orchestrator's client certificate must name it, as its subject
+// common name ClientName or as the URI SAN ClientURI. Agents hold
+// server-only certificates from the same CA; the name check keeps any
+// other clientAuth certificate that CA issues from driving an agent.
+const (
+	ClientName = "katamaran-orchestrator"
+	ClientURI  = "urn:katamaran:orchestrator"
+)
+
+// Files of a TLS directory, as in a kubernetes.io/tls Secret that also
+// carries its CA (cert-manager writes all three).
+const (
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
+	caFile   = "ca.crt"
+)
+
+// ServerTLS returns the agent's TLS config from dir: it presents
+// tls.crt/tls.key and accepts only clients with a clientAuth
+// certificate for ClientName or ClientURI signed by ca.crt.
+func ServerTLS(dir string) (*tls.Config, error) {
+	cert, pool, err := loadTLSDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate: func(_ [][]byte, chains [][]*x509.Certificate) error {
+			if len(chains) == 0 || len(chains[0]) == 0 {
+				return errors.New("no verified client certificate")
+			}
+			return checkClientIdentity(chains[0][0])
+		},
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// checkClientIdentity reports whether cert names the orchestrator.
+func checkClientIdentity(cert *x509.Certificate) error {
+	if cert.Subject.CommonName == ClientName {
+		return nil
+	}
+	if slices.ContainsFunc(cert.URIs, func(u *url.URL) bool { return u.String() == ClientURI }) {
+		return nil
+	}
+	return fmt.Errorf("client certificate %q is not an orchestrator certificate: want CN %s or URI SAN %s", cert.Subject.CommonName, ClientName, ClientURI)
+}
+
+// ClientTLS returns the orchestrator's TLS config from dir: it presents
+// tls.crt/tls.key, its client certificate, and accepts only agents with
+// a certificate for ServerName signed by ca.crt.
+func ClientTLS(dir string) (*tls.Config, error) {
+	cert, pool, err := loadTLSDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadTLSDir(dir string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile))
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("load TLS key pair from %s: %w", dir, err)
+	}
+	caPath := filepath.Join(dir, caFile)
+	caBytes, err := os.ReadFile(caPath)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return tls.Certificate{}, nil, fmt.Errorf("CA file %s did not contain any PEM certificates", caPath)
+	}
+	return cert, pool, nil
+}
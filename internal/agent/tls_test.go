@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/maci0/katamaran/internal/agent/agentpb"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// testCA is a throwaway CA that issues certificates into TLS
+// directories.
+type testCA struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+	next int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "katamaran-agent-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, der: der, key: key, next: 2}
+}
+
+// writeDir issues a certificate for tmpl's subject, SANs and extended
+// key usages and writes it, its key and the CA to a new directory.
+func (ca *testCA) writeDir(t *testing.T, tmpl *x509.Certificate) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(ca.next)
+	ca.next++
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for name, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: ca.der},
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// agentDir and orchestratorDir write the two identities deploy/agent.yaml
+// describes: a server-only agent certificate and a client-only
+// orchestrator certificate.
+func (ca *testCA) agentDir(t *testing.T) string {
+	return ca.writeDir(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: ServerName},
+		DNSNames:    []string{ServerName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) orchestratorDir(t *testing.T) string {
+	return ca.writeDir(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: ClientName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func TestDialer_MutualTLS(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	serverTLS, err := ServerTLS(ca.agentDir(t))
+	if err != nil {
+		t.Fatalf("ServerTLS: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(destRun)
+	gs := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	agentpb.RegisterAgentServer(gs, srv)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(func() {
+		srv.Close()
+		gs.Stop()
+	})
+	port := lis.Addr().(*net.TCPAddr).Port
+	resolve := func(context.Context, string) (string, error) { return "127.0.0.1", nil }
+
+	clientTLS, err := ClientTLS(ca.orchestratorDir(t))
+	if err != nil {
+		t.Fatalf("ClientTLS: %v", err)
+	}
+	a, err := Dialer(credentials.NewTLS(clientTLS), port, resolve)(context.Background(), "n1")
+	if err != nil {
+		t.Fatalf("dial with the orchestrator certificate: %v", err)
+	}
+	defer a.Close()
+	if err := a.PrepareDest(context.Background(), "m1", destMode, ""); err != nil {
+		t.Fatalf("PrepareDest: %v", err)
+	}
+
+	// reaches reports whether a client presenting the certificate in dir
+	// gets a run started.
+	reaches := func(t *testing.T, dir, id string) bool {
+		t.Helper()
+		cfg, err := ClientTLS(dir)
+		if err != nil {
+			t.Fatalf("ClientTLS: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		b, err := Dialer(credentials.NewTLS(cfg), port, resolve)(ctx, "n1")
+		if err != nil {
+			return false
+		}
+		defer b.Close()
+		return b.StartSource(ctx, orchestrator.MigrationID(id), sourceMode, "") == nil
+	}
+	if !reaches(t, ca.writeDir(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mgr"},
+		URIs:        []*url.URL{{Scheme: "urn", Opaque: "katamaran:orchestrator"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}), "m2") {
+		t.Error("a client certificate with the orchestrator URI SAN was rejected")
+	}
+	for name, dir := range map[string]string{
+		"another CA": newTestCA(t).orchestratorDir(t),
+		// Server-only: one agent cannot drive another.
+		"an agent certificate": ca.agentDir(t),
+		// The CA's other client certificates do not name the orchestrator.
+		"another client identity": ca.writeDir(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: ServerName},
+			DNSNames:    []string{ServerName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}),
+	} {
+		if reaches(t, dir, "m3") {
+			t.Errorf("StartSource with %s succeeded", name)
+		}
+	}
+}
+
+func TestLoadTLSDir_Errors(t *testing.T) {
+	t.Parallel()
+	if _, err := ServerTLS(t.TempDir()); err == nil {
+		t.Error("ServerTLS of an empty directory succeeded")
+	}
+	dir := newTestCA(t).orchestratorDir(t)
+	if err := os.WriteFile(filepath.Join(dir, caFile), []byte("not pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClientTLS(dir); err == nil {
+		t.Error("ClientTLS with a bad CA file succeeded")
+	}
+}
@@ -25,12 +25,16 @@
 package controller
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"log/slog"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -57,6 +61,7 @@ var (
 	mFailed          = expvar.NewInt("katamaran_migrations_failed_total")
 	mRecovered       = expvar.NewInt("katamaran_migrations_recovered_total")
 	mResumed         = expvar.NewInt("katamaran_migrations_resumed_total")
+	mQueued          = expvar.NewInt("katamaran_migrations_queued_total")
 	mDeleted         = expvar.NewInt("katamaran_migrations_deleted_total")
 	mInflight        = expvar.NewInt("katamaran_migrations_inflight")
 	mReconcileErrors = expvar.NewInt("katamaran_migrations_reconcile_errors_total")
@@ -65,6 +70,10 @@ var (
 	mWorkerPanics    = expvar.NewInt("katamaran_migrations_worker_panics_total")
 )
 
+// queuedMessagePrefix starts .status.message of a Migration whose Apply
+// hit orchestrator.ErrConflict; dispatch retries it every tick.
+const queuedMessagePrefix = "queued: "
+
 // migrationProgress tracks per-migration progress for Prometheus export.
 // Keyed by migration ID. Entries are added on dispatch and removed when
 // the dispatch goroutine exits. The /metrics handler iterates this map
@@ -366,6 +375,18 @@ func (r *Reconciler) dispatch(ctx context.Context, key types.NamespacedName, obj
 	jobCtx, cancel := context.WithTimeout(ctx, r.StatusTimeout)
 	defer cancel()
 	id, err := r.Orchestrator.Apply(jobCtx, req)
+	if errors.Is(err, orchestrator.ErrConflict) {
+		// Another migration holds the source VM, the destination QEMU or
+		// every listener slot on the node. Leave the phase empty so the
+		// next tick retries once it finishes.
+		msg, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+		if !strings.HasPrefix(msg, queuedMessagePrefix) {
+			mQueued.Add(1)
+			slog.Info("Migration queued behind a conflicting migration", "migration", key, "reason", err)
+		}
+		_ = r.patchStatus(ctx, key, "", "", queuedMessagePrefix+err.Error(), "")
+		return
+	}
 	if err != nil {
 		slog.Error("Apply failed", "migration", key, "error", err)
 		r.patchFailedStatus(ctx, key, "", "Apply failed", err.Error())
@@ -383,12 +404,19 @@ func (r *Reconciler) dispatch(ctx context.Context, key types.NamespacedName, obj
 		r.patchFailedStatus(ctx, key, string(id), "Watch failed", err.Error())
 		return
 	}
-	var lastPhase string
+	var lastPhase, destSandbox string
 	for u := range updates {
 		errStr := ""
 		if u.Error != nil {
 			errStr = u.Error.Error()
 		}
+		if u.Placement != nil {
+			// The destination picker chose the node; adoption below needs it.
+			req.DestNode = u.Placement.Node
+		}
+		if u.DestSandboxID != "" {
+			destSandbox = u.DestSandboxID
+		}
 		_ = r.patchStatusUpdate(ctx, key, u, errStr)
 		lastPhase = string(u.Phase)
 		updateProgressMetrics(u)
@@ -463,7 +491,7 @@ func (r *Reconciler) dispatch(ctx context.Context, key types.NamespacedName, obj
 				// state or a sandbox persist.json before creating the pod.
 				slog.Info("Waiting for factory VMConfig before adoption", "migration", key, "migration_id", id, "delay", "5s")
 				time.Sleep(5 * time.Second)
-				if err := r.createAdoptionPod(adoptCtx, req, adoptName, destNode); err != nil {
+				if err := r.createAdoptionPod(adoptCtx, req, adoptName, destNode, destSandbox); err != nil {
 					slog.Warn("Failed to create adoption pod", "migration", key, "migration_id", id, "name", adoptName, "node", destNode, "error", err)
 				} else {
 					// Deliberately do NOT call r.pending.Clear(rsUID)
@@ -532,7 +560,7 @@ func (r *Reconciler) recover(ctx context.Context, key types.NamespacedName, obj
 			_ = r.patchStatus(ctx, key, id, string(orchestrator.PhaseFailed), "recovery timed out waiting for jobs", "")
 			return
 		}
-		jobs, err := r.Kube.BatchV1().Jobs(orchestrator.DefaultJobNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		jobs, err := r.Kube.BatchV1().Jobs(orchestrator.JobNamespace(r.Orchestrator)).List(ctx, metav1.ListOptions{LabelSelector: selector})
 		if err != nil {
 			slog.Error("recover: list jobs failed", "migration", key, "migration_id", id, "error", err)
 			continue
@@ -585,6 +613,11 @@ func (r *Reconciler) recover(ctx context.Context, key types.NamespacedName, obj
 				slog.Warn("recover: specToRequest failed; cannot resume", "migration", key, "error", sErr)
 				continue
 			}
+			if req.DestNode == "" {
+				// Auto-select: the destination picker recorded its choice
+				// in status.destNode on submit.
+				req.DestNode, _, _ = unstructured.NestedString(obj.Object, "status", "destNode")
+			}
 			created, rErr := r.Orchestrator.Resume(ctx, orchestrator.MigrationID(id), req)
 			switch {
 			case rErr != nil:
@@ -688,6 +721,16 @@ func (r *Reconciler) removeFinalizer(ctx context.Context, obj *unstructured.Unst
 	return r.patchFinalizers(ctx, obj, out)
 }
 
+// hasExecHook reports whether any hook of h is an exec hook.
+func hasExecHook(h orchestrator.MigrationHooks) bool {
+	for _, hook := range slices.Concat(h.PreCutover, h.PostResume) {
+		if hook.Exec != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // specToRequest extracts the .spec fields into an orchestrator.Request.
 func specToRequest(obj map[string]any) (orchestrator.Request, error) {
 	var req orchestrator.Request
@@ -714,6 +757,7 @@ func specToRequest(obj map[string]any) (orchestrator.Request, error) {
 	req.SharedStorage, _, _ = unstructured.NestedBool(obj, "spec", "sharedStorage")
 	req.ReplayCmdline, _, _ = unstructured.NestedBool(obj, "spec", "replayCmdline")
 	req.TunnelMode, _, _ = unstructured.NestedString(obj, "spec", "tunnelMode")
+	req.TapIface, _, _ = unstructured.NestedString(obj, "spec", "tapIface")
 	if dt, found, _ := unstructured.NestedInt64(obj, "spec", "downtimeMS"); found {
 		req.DowntimeMS = int(dt)
 	}
@@ -721,6 +765,22 @@ func specToRequest(obj map[string]any) (orchestrator.Request, error) {
 	if floor, found, _ := unstructured.NestedInt64(obj, "spec", "autoDowntimeFloorMS"); found {
 		req.AutoDowntimeFloorMS = int(floor)
 	}
+	req.VerifyStorage, _, _ = unstructured.NestedString(obj, "spec", "verifyStorage")
+	req.StorageHandoff, _, _ = unstructured.NestedBool(obj, "spec", "storageHandoff")
+	req.Cold, _, _ = unstructured.NestedBool(obj, "spec", "cold")
+	req.AllowColdFallback, _, _ = unstructured.NestedBool(obj, "spec", "allowColdFallback")
+	req.Balloon, _, _ = unstructured.NestedBool(obj, "spec", "balloon")
+	req.Compression, _, _ = unstructured.NestedString(obj, "spec", "compression")
+	if lvl, found, _ := unstructured.NestedInt64(obj, "spec", "compressionLevel"); found {
+		req.CompressionLevel = int(lvl)
+	}
+	req.AdaptiveTuning, _, _ = unstructured.NestedBool(obj, "spec", "adaptiveTuning")
+	if md, found, _ := unstructured.NestedInt64(obj, "spec", "maxDowntimeMS"); found {
+		req.MaxDowntimeMS = int(md)
+	}
+	if bw, found, _ := unstructured.NestedInt64(obj, "spec", "maxBandwidthMBps"); found {
+		req.MaxBandwidthMBps = int(bw)
+	}
 	if cni, found, _ := unstructured.NestedInt64(obj, "spec", "cniConvergenceDelaySeconds"); found {
 		req.CNIConvergenceDelaySeconds = int(cni)
 	}
@@ -730,6 +790,38 @@ func specToRequest(obj map[string]any) (orchestrator.Request, error) {
 	if pwt, found, _ := unstructured.NestedInt64(obj, "spec", "podWaitTimeoutSeconds"); found {
 		req.PodWaitTimeoutSeconds = int(pwt)
 	}
+	if hooks, found, _ := unstructured.NestedFieldNoCopy(obj, "spec", "hooks"); found {
+		// Round-trip through JSON: orchestrator.Hook carries the CRD's
+		// field names as JSON tags.
+		raw, err := json.Marshal(hooks)
+		if err == nil {
+			err = json.Unmarshal(raw, &req.Hooks)
+		}
+		if err != nil {
+			return req, fmt.Errorf("spec.hooks: %w", err)
+		}
+		// exec hooks run through the Jobs' service account, which may
+		// hold pods/exec wherever an admin granted it. Keep them to the
+		// Migration's own namespace so a Migration cannot reach the
+		// pods of namespaces its author has no access to.
+		if hasExecHook(req.Hooks) {
+			ns, _, _ := unstructured.NestedString(obj, "metadata", "namespace")
+			for _, p := range []*orchestrator.PodRef{req.SourcePod, req.DestPod} {
+				if p != nil && p.Namespace != ns {
+					return req, fmt.Errorf("spec.hooks: exec hooks need the pods in the Migration's namespace %q, not %q", ns, p.Namespace)
+				}
+			}
+		}
+	}
+	if overrides, found, _ := unstructured.NestedFieldNoCopy(obj, "spec", "jobOverrides"); found {
+		raw, err := json.Marshal(overrides)
+		if err == nil {
+			err = json.Unmarshal(raw, &req.JobOverrides)
+		}
+		if err != nil {
+			return req, fmt.Errorf("spec.jobOverrides: %w", err)
+		}
+	}
 	req.SourceCleanup, _, _ = unstructured.NestedString(obj, "spec", "sourceCleanup")
 	req.AdoptVM, _, _ = unstructured.NestedBool(obj, "spec", "adoptVM")
 	// SourceNode + DestIP are not in the CRD spec — Reconciler.dispatch
@@ -759,10 +851,14 @@ func (r *Reconciler) patchFailedStatus(ctx context.Context, key types.Namespaced
 
 func (r *Reconciler) patchStatusUpdate(ctx context.Context, key types.NamespacedName, u orchestrator.StatusUpdate, errStr string) error {
 	status := map[string]any{
-		"phase":   string(u.Phase),
 		"message": nil,
 		"error":   nil,
 	}
+	// An empty phase patches only the message (queued migrations stay
+	// undispatched).
+	if u.Phase != "" {
+		status["phase"] = string(u.Phase)
+	}
 	if u.ID != "" {
 		status["migrationID"] = string(u.ID)
 	}
@@ -779,6 +875,15 @@ func (r *Reconciler) patchStatusUpdate(ctx context.Context, key types.Namespaced
 	if u.DowntimeMS > 0 {
 		status["actualDowntimeMS"] = u.DowntimeMS
 	}
+	if u.MemorySavedBytes > 0 {
+		status["memorySavedBytes"] = u.MemorySavedBytes
+	}
+	if u.Compression != "" {
+		status["compression"] = u.Compression
+	}
+	if u.CompressionRatio > 0 {
+		status["compressionRatio"] = u.CompressionRatio
+	}
 	if u.AppliedDowntimeMS > 0 {
 		status["appliedDowntimeMS"] = u.AppliedDowntimeMS
 	}
@@ -788,6 +893,95 @@ func (r *Reconciler) patchStatusUpdate(ctx context.Context, key types.Namespaced
 	if u.AutoDowntime {
 		status["autoDowntime"] = true
 	}
+	// Millisecond precision matters here: verifiers line packet gaps up
+	// against these bounds.
+	if !u.VMStoppedAt.IsZero() {
+		status["vmStoppedAt"] = u.VMStoppedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if !u.VMResumedAt.IsZero() {
+		status["vmResumedAt"] = u.VMResumedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if u.DestSandboxID != "" {
+		status["destSandboxID"] = u.DestSandboxID
+	}
+	// Keyed by drive so the merge patch accumulates one entry per drive.
+	if sv := u.StorageVerification; sv != nil {
+		status["storageVerification"] = map[string]any{
+			sv.DriveID: map[string]any{
+				"mode":       sv.Mode,
+				"result":     sv.Result,
+				"extents":    sv.Extents,
+				"mismatched": sv.Mismatched,
+			},
+		}
+	}
+	if u.Cold {
+		status["cold"] = true
+	}
+	if len(u.MigrationBlockers) > 0 {
+		status["migrationBlockers"] = u.MigrationBlockers
+	}
+	// Keyed by source PVC, like storageVerification.
+	if len(u.VolumeHandoffs) > 0 {
+		handoffs := make(map[string]any, len(u.VolumeHandoffs))
+		for _, v := range u.VolumeHandoffs {
+			entry := map[string]any{
+				"pv":       v.PV,
+				"driver":   v.Driver,
+				"strategy": v.Strategy,
+				"state":    v.State,
+			}
+			if v.DestPVC != "" {
+				entry["destPVC"] = v.DestPVC
+			}
+			if v.Attachment != "" {
+				entry["attachment"] = v.Attachment
+			}
+			handoffs[v.PVC] = entry
+		}
+		status["volumeHandoffs"] = handoffs
+	}
+	// Keyed by phase, then hook name, so pre-cutover results stay when
+	// the post-resume ones arrive.
+	if len(u.Hooks) > 0 {
+		hooks := map[string]any{}
+		for _, h := range u.Hooks {
+			byName, _ := hooks[h.Phase].(map[string]any)
+			if byName == nil {
+				byName = map[string]any{}
+				hooks[h.Phase] = byName
+			}
+			entry := map[string]any{
+				"type":       h.Type,
+				"result":     h.Result,
+				"durationMS": h.DurationMS,
+			}
+			if h.Error != "" {
+				entry["error"] = h.Error
+			}
+			byName[h.Name] = entry
+		}
+		status["hooks"] = hooks
+	}
+	// Keyed by step, so the merge patch accumulates the history.
+	if t := u.Tuning; t != nil {
+		status["tuning"] = map[string]any{
+			strconv.Itoa(t.Step): map[string]any{
+				"param":              t.Param,
+				"old":                t.Old,
+				"new":                t.New,
+				"expectedDowntimeMS": t.ExpectedDowntimeMS,
+				"dirtyRateBps":       t.DirtyRateBps,
+				"throughputMbps":     t.ThroughputMbps,
+				"ramRemaining":       t.RAMRemaining,
+			},
+		}
+	}
+	if p := u.Placement; p != nil {
+		status["destNode"] = p.Node
+		status["placementScore"] = p.Score
+		status["placementReasons"] = p.Reasons
+	}
 	if u.Phase == orchestrator.PhaseSubmitted {
 		status["startedAt"] = time.Now().UTC().Format(time.RFC3339)
 	}
@@ -832,7 +1026,11 @@ func (r *Reconciler) patchStatusUpdate(ctx context.Context, key types.Namespaced
 // + the adopted pod and the RS will pick one to delete (RS does not
 // know which carries the migrated VM, so this is best-effort
 // pre-webhook).
-func (r *Reconciler) createAdoptionPod(ctx context.Context, req orchestrator.Request, name, destNode string) error {
+//
+// sandboxID is the sandbox the dest job landed the VM in (its
+// KATAMARAN_DEST_READY marker); empty falls back to the legacy fixed
+// "katamaran-dest".
+func (r *Reconciler) createAdoptionPod(ctx context.Context, req orchestrator.Request, name, destNode, sandboxID string) error {
 	labels := map[string]string{
 		"app.kubernetes.io/name":      "katamaran",
 		"app.kubernetes.io/component": "adopted-vm",
@@ -867,10 +1065,7 @@ func (r *Reconciler) createAdoptionPod(ctx context.Context, req orchestrator.Req
 			// The katamaran-adopted shim reads this annotation from the
 			// pod's OCI bundle config.json to pick which surviving QEMU
 			// (under /sys/fs/cgroup/katamaran-adopted/<id>/) to adopt.
-			// "katamaran-dest" is the fixed sandbox id the dest job
-			// template uses; if a future release parameterises that
-			// per-migration, plumb the actual id through here.
-			"katamaran.io/adopted-sandbox-id": "katamaran-dest",
+			"katamaran.io/adopted-sandbox-id": cmp.Or(sandboxID, "katamaran-dest"),
 		},
 	}
 	if len(ownerRefs) > 0 {
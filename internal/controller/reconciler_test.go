@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"sync"
 	"testing"
@@ -61,6 +62,7 @@ func TestSpecToRequest_AllFields(t *testing.T) {
 			"sharedStorage":   true,
 			"replayCmdline":   true,
 			"tunnelMode":      "ipip",
+			"tapIface":        "tap0_kata",
 			"downtimeMS":      int64(50),
 			"autoDowntime":    true,
 			"multifdChannels": int64(4),
@@ -73,7 +75,7 @@ func TestSpecToRequest_AllFields(t *testing.T) {
 	if !req.SharedStorage || !req.ReplayCmdline || !req.AutoDowntime {
 		t.Errorf("bool fields not threaded: %+v", req)
 	}
-	if req.DowntimeMS != 50 || req.MultifdChannels != 4 || req.TunnelMode != "ipip" {
+	if req.DowntimeMS != 50 || req.MultifdChannels != 4 || req.TunnelMode != "ipip" || req.TapIface != "tap0_kata" {
 		t.Errorf("numeric/string fields not threaded: %+v", req)
 	}
 	if req.DestPod == nil || req.DestPod.Name != "kata-dest" {
@@ -517,6 +519,43 @@ func TestReconciler_RecoverCallsResumeWhenSourceRunningDestMissing(t *testing.T)
 	t.Fatalf("recovery never called Resume; calls=%v", orch.calls)
 }
 
+func TestReconciler_RecoverResumeUsesPickedDestNode(t *testing.T) {
+	cr := newMigrationCR("m-resume-auto", []string{finalizerName}, false, map[string]any{
+		"phase":       "submitted",
+		"migrationID": "id-resume-auto",
+		"destNode":    "worker-c",
+	})
+	unstructured.RemoveNestedField(cr.Object, "spec", "destNode")
+	srcJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "katamaran-source-id-resume-auto",
+			Namespace: orchestrator.DefaultJobNamespace,
+			Labels: map[string]string{
+				orchestrator.MigrationIDLabel: "id-resume-auto",
+				"app.kubernetes.io/component": "source",
+			},
+		},
+	}
+	orch := &fakeOrch{resumeCreated: true}
+	rec, _, _ := newReconcilerWithCR(t, orch, cr, srcJob)
+	if err := rec.reconcileAll(context.Background()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(orch.callsFor("Resume")) > 0 {
+			orch.mu.Lock()
+			defer orch.mu.Unlock()
+			if orch.lastReq.DestNode != "worker-c" {
+				t.Fatalf("Resume DestNode = %q, want status.destNode worker-c", orch.lastReq.DestNode)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("recovery never called Resume; calls=%v", orch.calls)
+}
+
 func TestReconciler_RecoverFromMissingJobs(t *testing.T) {
 	cr := newMigrationCR("m4", []string{finalizerName}, false, map[string]any{
 		"phase":       "submitted",
@@ -595,9 +634,14 @@ func TestPatchStatusUpdate_PersistsProgressAndClearsStaleFields(t *testing.T) {
 	}
 
 	err = rec.patchStatusUpdate(context.Background(), types.NamespacedName{Namespace: "default", Name: "m5"}, orchestrator.StatusUpdate{
-		ID:         "id-m5",
-		Phase:      orchestrator.PhaseSucceeded,
-		DowntimeMS: 17,
+		ID:               "id-m5",
+		Phase:            orchestrator.PhaseSucceeded,
+		DowntimeMS:       17,
+		MemorySavedBytes: 1 << 30,
+		Compression:      "zstd",
+		CompressionRatio: 2.5,
+		VMStoppedAt:      time.UnixMilli(1700000000123),
+		VMResumedAt:      time.UnixMilli(1700000000160),
 	}, "")
 	if err != nil {
 		t.Fatalf("patchStatusUpdate succeeded: %v", err)
@@ -609,6 +653,179 @@ func TestPatchStatusUpdate_PersistsProgressAndClearsStaleFields(t *testing.T) {
 	if downtime, _, _ := unstructured.NestedInt64(got.Object, "status", "actualDowntimeMS"); downtime != 17 {
 		t.Fatalf("actualDowntimeMS = %d, want 17", downtime)
 	}
+	if saved, _, _ := unstructured.NestedInt64(got.Object, "status", "memorySavedBytes"); saved != 1<<30 {
+		t.Fatalf("memorySavedBytes = %d, want %d", saved, 1<<30)
+	}
+	if comp, _, _ := unstructured.NestedString(got.Object, "status", "compression"); comp != "zstd" {
+		t.Fatalf("compression = %q, want zstd", comp)
+	}
+	if ratio, _, _ := unstructured.NestedFloat64(got.Object, "status", "compressionRatio"); ratio != 2.5 {
+		t.Fatalf("compressionRatio = %v, want 2.5", ratio)
+	}
+	if stopped, _, _ := unstructured.NestedString(got.Object, "status", "vmStoppedAt"); stopped != "2023-11-14T22:13:20.123Z" {
+		t.Fatalf("vmStoppedAt = %q", stopped)
+	}
+	if resumed, _, _ := unstructured.NestedString(got.Object, "status", "vmResumedAt"); resumed != "2023-11-14T22:13:20.16Z" {
+		t.Fatalf("vmResumedAt = %q", resumed)
+	}
+}
+
+func TestPatchStatusUpdate_PersistsPlacement(t *testing.T) {
+	cr := newMigrationCR("m-place", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	err := rec.patchStatusUpdate(context.Background(), types.NamespacedName{Namespace: "default", Name: "m-place"}, orchestrator.StatusUpdate{
+		ID:    "id-place",
+		Phase: orchestrator.PhaseSubmitted,
+		Placement: &orchestrator.Placement{
+			Node:    "worker-c",
+			Score:   42,
+			Reasons: []string{"vm: 2.0Gi RAM", "memory: 14.0Gi free (+35)"},
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("patchStatusUpdate: %v", err)
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-place", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, _, _ := unstructured.NestedString(got.Object, "status", "destNode"); node != "worker-c" {
+		t.Fatalf("destNode = %q, want worker-c", node)
+	}
+	if score, _, _ := unstructured.NestedInt64(got.Object, "status", "placementScore"); score != 42 {
+		t.Fatalf("placementScore = %d, want 42", score)
+	}
+	if reasons, _, _ := unstructured.NestedStringSlice(got.Object, "status", "placementReasons"); len(reasons) != 2 {
+		t.Fatalf("placementReasons = %v, want 2 lines", reasons)
+	}
+}
+
+func TestReconciler_DispatchQueuesOnConflict(t *testing.T) {
+	cr := newMigrationCR("m-queued", []string{finalizerName}, false, nil)
+	orch := &fakeOrch{applyErr: fmt.Errorf("%w: pod:default/kata-demo is already being migrated by abc", orchestrator.ErrConflict)}
+	rec, dyn, _ := newReconcilerWithCR(t, orch, cr)
+	rec.Discoverer = &fakeDiscoverer{podNode: "worker-a", nodeIP: "10.0.0.20"}
+	key := types.NamespacedName{Namespace: "default", Name: "m-queued"}
+	before := mQueued.Value()
+
+	rec.dispatch(context.Background(), key, cr)
+
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-queued", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No phase: the next reconcile tick dispatches it again.
+	if phase, found, _ := unstructured.NestedString(got.Object, "status", "phase"); found {
+		t.Fatalf("queued migration has phase %q, want none", phase)
+	}
+	msg, _, _ := unstructured.NestedString(got.Object, "status", "message")
+	if !strings.HasPrefix(msg, queuedMessagePrefix) || !strings.Contains(msg, "already being migrated") {
+		t.Fatalf("message = %q, want queued reason", msg)
+	}
+	if mQueued.Value() != before+1 {
+		t.Fatalf("queued counter = %d, want %d", mQueued.Value(), before+1)
+	}
+
+	// Retrying while still queued does not count the migration twice.
+	rec.dispatch(context.Background(), key, got)
+	if mQueued.Value() != before+1 {
+		t.Fatalf("queued counter after retry = %d, want %d", mQueued.Value(), before+1)
+	}
+}
+
+func TestPatchStatusUpdate_PersistsDestSandboxID(t *testing.T) {
+	cr := newMigrationCR("m-sandbox", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	err := rec.patchStatusUpdate(context.Background(), types.NamespacedName{Namespace: "default", Name: "m-sandbox"}, orchestrator.StatusUpdate{
+		ID:            "id-sandbox",
+		Phase:         orchestrator.PhaseSucceeded,
+		DestSandboxID: "katamaran-dest-0123456789abcdef",
+	}, "")
+	if err != nil {
+		t.Fatalf("patchStatusUpdate: %v", err)
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-sandbox", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := unstructured.NestedString(got.Object, "status", "destSandboxID"); id != "katamaran-dest-0123456789abcdef" {
+		t.Fatalf("destSandboxID = %q", id)
+	}
+}
+
+func TestPatchStatusUpdate_AccumulatesStorageVerification(t *testing.T) {
+	cr := newMigrationCR("m-verify", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	key := types.NamespacedName{Namespace: "default", Name: "m-verify"}
+	for _, sv := range []orchestrator.StorageVerification{
+		{DriveID: "drive-virtio-disk0", Mode: "sample", Result: "ok", Extents: 64},
+		{DriveID: "drive-vol1", Mode: "sample", Result: "unsettled", Extents: 64, Mismatched: 1},
+	} {
+		if err := rec.patchStatusUpdate(context.Background(), key, orchestrator.StatusUpdate{
+			ID:                  "id-verify",
+			Phase:               orchestrator.PhaseTransferring,
+			StorageVerification: &sv,
+		}, ""); err != nil {
+			t.Fatalf("patchStatusUpdate: %v", err)
+		}
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-verify", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _ := unstructured.NestedString(got.Object, "status", "storageVerification", "drive-virtio-disk0", "result"); r != "ok" {
+		t.Errorf("drive-virtio-disk0 result = %q", r)
+	}
+	if r, _, _ := unstructured.NestedString(got.Object, "status", "storageVerification", "drive-vol1", "result"); r != "unsettled" {
+		t.Errorf("drive-vol1 result = %q", r)
+	}
+}
+
+func TestPatchStatusUpdate_VolumeHandoffs(t *testing.T) {
+	cr := newMigrationCR("m-handoff", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	key := types.NamespacedName{Namespace: "default", Name: "m-handoff"}
+	if err := rec.patchStatusUpdate(context.Background(), key, orchestrator.StatusUpdate{
+		ID:    "id-handoff",
+		Phase: orchestrator.PhaseSubmitted,
+		VolumeHandoffs: []orchestrator.VolumeHandoff{
+			{PVC: "data", PV: "pv-local", Driver: "topolvm.io", Strategy: orchestrator.HandoffProvision, DestPVC: "data-id-handoff", State: orchestrator.HandoffProvisioned},
+		},
+	}, ""); err != nil {
+		t.Fatalf("patchStatusUpdate: %v", err)
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-handoff", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _, _ := unstructured.NestedString(got.Object, "status", "volumeHandoffs", "data", "destPVC"); d != "data-id-handoff" {
+		t.Errorf("destPVC = %q", d)
+	}
+	if s, _, _ := unstructured.NestedString(got.Object, "status", "volumeHandoffs", "data", "state"); s != orchestrator.HandoffProvisioned {
+		t.Errorf("state = %q", s)
+	}
+}
+
+func TestCreateAdoptionPod_SandboxID(t *testing.T) {
+	cr := newMigrationCR("m-adopt", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	req := orchestrator.Request{SourcePod: &orchestrator.PodRef{Namespace: "default", Name: "kata-demo"}}
+	podGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	for name, tc := range map[string]struct{ sandbox, want string }{
+		"adopted-a": {"katamaran-dest-0123456789abcdef", "katamaran-dest-0123456789abcdef"},
+		"adopted-b": {"", "katamaran-dest"},
+	} {
+		if err := rec.createAdoptionPod(context.Background(), req, name, "worker-b", tc.sandbox); err != nil {
+			t.Fatalf("createAdoptionPod(%q): %v", tc.sandbox, err)
+		}
+		pod, err := dyn.Resource(podGVR).Namespace("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		if got := pod.GetAnnotations()["katamaran.io/adopted-sandbox-id"]; got != tc.want {
+			t.Fatalf("%s adopted-sandbox-id = %q, want %q", name, got, tc.want)
+		}
+	}
 }
 
 func TestSpecToRequest_AdoptVM(t *testing.T) {
@@ -643,3 +860,263 @@ func TestSpecToRequest_AdoptVM_DefaultFalse(t *testing.T) {
 		t.Fatal("expected AdoptVM=false by default")
 	}
 }
+
+func TestSpecToRequest_Cold(t *testing.T) {
+	obj := map[string]any{
+		"spec": map[string]any{
+			"sourcePod":         map[string]any{"namespace": "default", "name": "src"},
+			"image":             "test:latest",
+			"cold":              true,
+			"allowColdFallback": true,
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.Cold || !req.AllowColdFallback {
+		t.Fatalf("Cold = %v, AllowColdFallback = %v, want both true", req.Cold, req.AllowColdFallback)
+	}
+}
+
+func TestSpecToRequest_Balloon(t *testing.T) {
+	obj := map[string]any{
+		"spec": map[string]any{
+			"sourcePod": map[string]any{"namespace": "default", "name": "src"},
+			"image":     "test:latest",
+			"balloon":   true,
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.Balloon {
+		t.Fatal("Balloon = false, want true")
+	}
+}
+
+func TestSpecToRequest_Compression(t *testing.T) {
+	obj := map[string]any{
+		"spec": map[string]any{
+			"sourcePod":        map[string]any{"namespace": "default", "name": "src"},
+			"image":            "test:latest",
+			"compression":      "zstd",
+			"compressionLevel": int64(3),
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Compression != "zstd" || req.CompressionLevel != 3 {
+		t.Fatalf("compression = %q level %d, want zstd level 3", req.Compression, req.CompressionLevel)
+	}
+}
+
+func TestSpecToRequest_AdaptiveTuning(t *testing.T) {
+	obj := map[string]any{
+		"spec": map[string]any{
+			"sourcePod":        map[string]any{"namespace": "default", "name": "src"},
+			"image":            "test:latest",
+			"adaptiveTuning":   true,
+			"maxDowntimeMS":    int64(300),
+			"maxBandwidthMBps": int64(2048),
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.AdaptiveTuning || req.MaxDowntimeMS != 300 || req.MaxBandwidthMBps != 2048 {
+		t.Fatalf("tuning = %t/%d/%d, want true/300/2048", req.AdaptiveTuning, req.MaxDowntimeMS, req.MaxBandwidthMBps)
+	}
+}
+
+func TestSpecToRequest_Hooks(t *testing.T) {
+	obj := map[string]any{
+		"metadata": map[string]any{"namespace": "default"},
+		"spec": map[string]any{
+			"sourcePod": map[string]any{"namespace": "default", "name": "src"},
+			"image":     "test:latest",
+			"hooks": map[string]any{
+				"preCutover": []any{map[string]any{
+					"name":           "flush-wal",
+					"exec":           map[string]any{"container": "db", "command": []any{"pg_ctl", "checkpoint"}},
+					"timeoutSeconds": int64(60),
+				}},
+				"postResume": []any{map[string]any{
+					"name":          "register",
+					"http":          map[string]any{"port": int64(8080), "path": "/register", "method": "POST"},
+					"failurePolicy": "ignore",
+				}},
+			},
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre, post := req.Hooks.PreCutover, req.Hooks.PostResume
+	if len(pre) != 1 || pre[0].Name != "flush-wal" || pre[0].Exec == nil || pre[0].Exec.Container != "db" || pre[0].TimeoutSeconds != 60 {
+		t.Errorf("preCutover = %+v", pre)
+	}
+	if len(post) != 1 || post[0].HTTP == nil || post[0].HTTP.Port != 8080 || post[0].FailurePolicy != "ignore" {
+		t.Errorf("postResume = %+v", post)
+	}
+}
+
+func TestSpecToRequest_ExecHooksStayInNamespace(t *testing.T) {
+	exec := map[string]any{"preCutover": []any{map[string]any{
+		"name": "x",
+		"exec": map[string]any{"command": []any{"true"}},
+	}}}
+	http := map[string]any{"preCutover": []any{map[string]any{
+		"name": "x",
+		"http": map[string]any{"port": int64(8080)},
+	}}}
+	for _, tc := range []struct {
+		name    string
+		destPod map[string]any
+		hooks   map[string]any
+		wantErr bool
+	}{
+		{"exec in namespace", nil, exec, false},
+		{"http elsewhere", map[string]any{"namespace": "kube-system", "name": "dst"}, http, false},
+		{"exec with dest pod elsewhere", map[string]any{"namespace": "kube-system", "name": "dst"}, exec, true},
+	} {
+		spec := map[string]any{
+			"sourcePod": map[string]any{"namespace": "team-a", "name": "src"},
+			"image":     "test:latest",
+			"hooks":     tc.hooks,
+		}
+		if tc.destPod != nil {
+			spec["destPod"] = tc.destPod
+		}
+		obj := map[string]any{"metadata": map[string]any{"namespace": "team-a"}, "spec": spec}
+		if _, err := specToRequest(obj); (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	// A Migration in one namespace cannot exec into another's pods.
+	obj := map[string]any{
+		"metadata": map[string]any{"namespace": "team-a"},
+		"spec": map[string]any{
+			"sourcePod": map[string]any{"namespace": "kube-system", "name": "etcd-0"},
+			"image":     "test:latest",
+			"hooks":     exec,
+		},
+	}
+	if _, err := specToRequest(obj); err == nil || !strings.Contains(err.Error(), "exec hooks") {
+		t.Errorf("exec into another namespace: err = %v", err)
+	}
+}
+
+func TestSpecToRequest_JobOverrides(t *testing.T) {
+	obj := map[string]any{
+		"spec": map[string]any{
+			"sourcePod": map[string]any{"namespace": "default", "name": "src"},
+			"image":     "test:latest",
+			"jobOverrides": map[string]any{
+				"destResources": map[string]any{
+					"limits": map[string]any{"memory": "8Gi", "cpu": int64(2)},
+				},
+				"priorityClassName": "migrations",
+				"destNodeSelector":  map[string]any{"pool": "kata"},
+				"destTolerations":   []any{map[string]any{"key": "kata", "operator": "Exists", "effect": "NoSchedule"}},
+			},
+		},
+	}
+	req, err := specToRequest(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := req.JobOverrides
+	if o.PriorityClassName != "migrations" || o.DestNodeSelector["pool"] != "kata" || len(o.DestTolerations) != 1 || o.DestTolerations[0].Key != "kata" {
+		t.Errorf("jobOverrides = %+v", o)
+	}
+	if o.DestResources == nil || o.DestResources.Limits.Memory().String() != "8Gi" || o.DestResources.Limits.Cpu().String() != "2" {
+		t.Errorf("destResources = %+v", o.DestResources)
+	}
+	if o.SourceResources != nil {
+		t.Errorf("sourceResources = %+v, want nil", o.SourceResources)
+	}
+}
+
+func TestPatchStatusUpdate_Hooks(t *testing.T) {
+	cr := newMigrationCR("m-hooks", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	key := types.NamespacedName{Namespace: "default", Name: "m-hooks"}
+	for _, u := range []orchestrator.StatusUpdate{
+		{ID: "id-hooks", Phase: orchestrator.PhaseTransferring, Hooks: []orchestrator.HookResult{
+			{Phase: "preCutover", Name: "flush-wal", Type: "exec", Result: "ok", DurationMS: 1200},
+		}},
+		{ID: "id-hooks", Phase: orchestrator.PhaseSucceeded, Hooks: []orchestrator.HookResult{
+			{Phase: "postResume", Name: "register", Type: "http", Result: "ignored", DurationMS: 30000, Error: "context deadline exceeded"},
+		}},
+	} {
+		if err := rec.patchStatusUpdate(context.Background(), key, u, ""); err != nil {
+			t.Fatalf("patchStatusUpdate: %v", err)
+		}
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-hooks", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _ := unstructured.NestedString(got.Object, "status", "hooks", "preCutover", "flush-wal", "result"); r != "ok" {
+		t.Errorf("preCutover flush-wal result = %q, want ok", r)
+	}
+	if e, _, _ := unstructured.NestedString(got.Object, "status", "hooks", "postResume", "register", "error"); e != "context deadline exceeded" {
+		t.Errorf("postResume register error = %q", e)
+	}
+}
+
+func TestPatchStatusUpdate_AccumulatesTuning(t *testing.T) {
+	cr := newMigrationCR("m-tune", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	key := types.NamespacedName{Namespace: "default", Name: "m-tune"}
+	for _, adj := range []orchestrator.TuningAdjustment{
+		{Step: 1, Param: orchestrator.TuningParamBandwidth, Old: 1 << 30, New: 2 << 30, ExpectedDowntimeMS: 400},
+		{Step: 2, Param: orchestrator.TuningParamDowntime, Old: 25, New: 50, ExpectedDowntimeMS: 380},
+	} {
+		u := orchestrator.StatusUpdate{ID: "id-tune", Phase: orchestrator.PhaseTransferring, Tuning: &adj}
+		if err := rec.patchStatusUpdate(context.Background(), key, u, ""); err != nil {
+			t.Fatalf("patchStatusUpdate: %v", err)
+		}
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-tune", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _, _ := unstructured.NestedString(got.Object, "status", "tuning", "1", "param"); p != orchestrator.TuningParamBandwidth {
+		t.Errorf("tuning step 1 param = %q, want %s", p, orchestrator.TuningParamBandwidth)
+	}
+	if n, _, _ := unstructured.NestedInt64(got.Object, "status", "tuning", "2", "new"); n != 50 {
+		t.Errorf("tuning step 2 new = %d, want 50", n)
+	}
+}
+
+func TestPatchStatusUpdate_ColdFallback(t *testing.T) {
+	cr := newMigrationCR("m-cold", []string{finalizerName}, false, nil)
+	rec, dyn, _ := newReconcilerWithCR(t, &fakeOrch{}, cr)
+	key := types.NamespacedName{Namespace: "default", Name: "m-cold"}
+	if err := rec.patchStatusUpdate(context.Background(), key, orchestrator.StatusUpdate{
+		ID:                "id-cold",
+		Phase:             orchestrator.PhaseSubmitted,
+		Cold:              true,
+		MigrationBlockers: []string{"VFIO device doesn't support migration"},
+	}, ""); err != nil {
+		t.Fatalf("patchStatusUpdate: %v", err)
+	}
+	got, err := dyn.Resource(MigrationGVR).Namespace("default").Get(context.Background(), "m-cold", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cold, _, _ := unstructured.NestedBool(got.Object, "status", "cold"); !cold {
+		t.Error("status.cold not set")
+	}
+	if b, _, _ := unstructured.NestedStringSlice(got.Object, "status", "migrationBlockers"); len(b) != 1 {
+		t.Errorf("migrationBlockers = %v", b)
+	}
+}
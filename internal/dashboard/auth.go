@@ -0,0 +1,380 @@
+package dashboard
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Values accepted by --auth-mode.
+const (
+	authModeNone  = "none"
+	authModeToken = "token"
+	authModeOIDC  = "oidc"
+)
+
+// Migration CRD coordinates checked by SubjectAccessReview. Dashboard
+// migrations are authorized as if the caller were creating (or deleting)
+// a Migration CR in the source pod's namespace, so the same RBAC that
+// governs `kubectl apply -f migration.yaml` governs the dashboard.
+const (
+	migrationAPIGroup = "katamaran.io"
+	migrationResource = "migrations"
+)
+
+const (
+	// authCacheTTL bounds how long a successful token authentication is
+	// reused. The UI polls /api/status every second; without a cache each
+	// poll would cost a TokenReview (or JWT verification) round-trip.
+	// Short enough that a revoked token stops working promptly.
+	authCacheTTL = 30 * time.Second
+
+	// maxAuthCacheEntries caps the token cache. When full, expired entries
+	// are swept and, if that is not enough, the cache is reset.
+	maxAuthCacheEntries = 1024
+
+	// authReviewTimeout bounds each TokenReview / SubjectAccessReview call
+	// so a slow apiserver cannot stall HTTP handlers past their timeouts.
+	authReviewTimeout = 5 * time.Second
+)
+
+// anonymousUser is the identity recorded in audit logs for requests that
+// carry no credentials (auth disabled, or anonymous read access).
+const anonymousUser = "system:anonymous"
+
+var errUnauthenticated = errors.New("token not authenticated")
+
+const userInfoKey contextKey = "user_info"
+
+// userInfo is the authenticated identity of a dashboard caller, as
+// reported by TokenReview or extracted from OIDC ID token claims.
+type userInfo struct {
+	Username string
+	UID      string
+	Groups   []string
+	Extra    map[string][]string
+}
+
+// userFromContext returns the caller identity stored by authMiddleware,
+// or nil for unauthenticated requests.
+func userFromContext(ctx context.Context) *userInfo {
+	u, _ := ctx.Value(userInfoKey).(*userInfo)
+	return u
+}
+
+// usernameFromContext returns the caller's username for audit logs and
+// history entries, or anonymousUser when the request is unauthenticated.
+func usernameFromContext(ctx context.Context) string {
+	if u := userFromContext(ctx); u != nil {
+		return u.Username
+	}
+	return anonymousUser
+}
+
+// authenticator validates a bearer token and returns the caller identity.
+// Implementations return errUnauthenticated (possibly wrapped) for tokens
+// that are well-formed but rejected, and other errors for backend failures.
+type authenticator interface {
+	authenticate(ctx context.Context, token string) (*userInfo, error)
+}
+
+// authorizer decides whether user may perform verb on Migration CRs in
+// namespace. An empty namespace asks for cluster-wide permission.
+type authorizer interface {
+	authorize(ctx context.Context, user *userInfo, verb, namespace string) (allowed bool, reason string, err error)
+}
+
+// authConfig is the dashboard's authentication + authorization policy.
+// A nil *authConfig on App means auth is disabled (--auth-mode=none).
+type authConfig struct {
+	mode  string
+	authn authenticator
+	authz authorizer
+
+	// anonymousRead lets requests without credentials use the read-only
+	// endpoints (GET/HEAD). State-changing endpoints always require a
+	// token when auth is enabled.
+	anonymousRead bool
+}
+
+// tokenCache memoizes successful authentications keyed by the token's
+// SHA-256, so raw tokens are never retained in memory longer than the
+// request that carried them.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]tokenCacheEntry
+	now     func() time.Time
+}
+
+type tokenCacheEntry struct {
+	user    *userInfo
+	expires time.Time
+}
+
+func (c *tokenCache) get(token string) *userInfo {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if c.clock().After(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e.user
+}
+
+func (c *tokenCache) put(token string, u *userInfo, ttl time.Duration) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]tokenCacheEntry)
+	}
+	if len(c.entries) >= maxAuthCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxAuthCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = tokenCacheEntry{user: u, expires: now.Add(ttl)}
+}
+
+func (c *tokenCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// tokenReviewAuthenticator validates bearer tokens with the Kubernetes
+// TokenReview API, so any token the apiserver accepts (service-account
+// tokens, OIDC tokens configured on the apiserver, webhook tokens) works
+// against the dashboard too.
+type tokenReviewAuthenticator struct {
+	client kubernetes.Interface
+	cache  tokenCache
+}
+
+func newTokenReviewAuthenticator(client kubernetes.Interface) *tokenReviewAuthenticator {
+	return &tokenReviewAuthenticator{client: client}
+}
+
+func (t *tokenReviewAuthenticator) authenticate(ctx context.Context, token string) (*userInfo, error) {
+	if u := t.cache.get(token); u != nil {
+		return u, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, authReviewTimeout)
+	defer cancel()
+	tr, err := t.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("token review: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		if tr.Status.Error != "" {
+			return nil, fmt.Errorf("%w: %s", errUnauthenticated, tr.Status.Error)
+		}
+		return nil, errUnauthenticated
+	}
+	u := &userInfo{
+		Username: tr.Status.User.Username,
+		UID:      tr.Status.User.UID,
+		Groups:   tr.Status.User.Groups,
+	}
+	if len(tr.Status.User.Extra) > 0 {
+		u.Extra = make(map[string][]string, len(tr.Status.User.Extra))
+		for k, v := range tr.Status.User.Extra {
+			u.Extra[k] = []string(v)
+		}
+	}
+	t.cache.put(token, u, authCacheTTL)
+	return u, nil
+}
+
+// subjectAccessReviewer authorizes migration actions with the Kubernetes
+// SubjectAccessReview API against the Migration CRD.
+type subjectAccessReviewer struct {
+	client kubernetes.Interface
+}
+
+func (s *subjectAccessReviewer) authorize(ctx context.Context, user *userInfo, verb, namespace string) (bool, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, authReviewTimeout)
+	defer cancel()
+	spec := authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      verb,
+			Group:     migrationAPIGroup,
+			Resource:  migrationResource,
+		},
+	}
+	if len(user.Extra) > 0 {
+		spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for k, v := range user.Extra {
+			spec.Extra[k] = authorizationv1.ExtraValue(v)
+		}
+	}
+	sar, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("subject access review: %w", err)
+	}
+	if sar.Status.EvaluationError != "" {
+		slog.Debug("SubjectAccessReview evaluation error", "user", user.Username, "verb", verb, "namespace", namespace, "error", sar.Status.EvaluationError)
+	}
+	return sar.Status.Allowed && !sar.Status.Denied, sar.Status.Reason, nil
+}
+
+// isPublicPath reports whether path is served without credentials in
+// every auth mode: probes, metrics scraping, and the static UI shell
+// (which carries no data and must load so the user can supply a token).
+func isPublicPath(path string) bool {
+	return path == "/" || path == "/healthz" || path == "/readyz" || path == "/metrics"
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header. Returns "" when the header is absent or uses another scheme.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authMiddleware authenticates bearer tokens and stores the caller in the
+// request context. Requests without a token are let through only for
+// public paths and, when anonymousRead is set, for GET/HEAD requests.
+// Authorization of individual actions happens in the handlers, which know
+// the namespace being acted on.
+func (a *App) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.auth == nil || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqID := requestIDFromContext(r.Context())
+		token := bearerToken(r)
+		if token == "" {
+			readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+			if readOnly && a.auth.anonymousRead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			slog.Debug("Rejected unauthenticated request", "method", r.Method, "path", r.URL.Path, "request_id", reqID)
+			unauthorized(w, r, "Authentication required")
+			return
+		}
+		user, err := a.auth.authn.authenticate(r.Context(), token)
+		if err != nil {
+			dashboardAuthFailuresTotal.Add(1)
+			if errors.Is(err, errUnauthenticated) {
+				slog.Warn("Rejected invalid bearer token", "method", r.Method, "path", r.URL.Path, "error", err, "remote_addr", r.RemoteAddr, "request_id", reqID)
+				unauthorized(w, r, "Invalid bearer token")
+				return
+			}
+			slog.Error("Authentication backend error", "auth_mode", a.auth.mode, "error", err, "request_id", reqID)
+			jsonError(w, "Authentication unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userInfoKey, user)))
+	})
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="katamaran-dashboard"`)
+	if isAPIPath(r.URL.Path) {
+		jsonError(w, msg, http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// authorizeMigrationAction checks that the caller may perform verb on
+// Migration CRs in namespace and writes an audit record of the decision.
+// auditAttrs describe what is being acted on (source pod, dest node,
+// migration ID) and are included in the audit record verbatim. On denial
+// or authorizer failure it writes the HTTP error and returns false.
+func (a *App) authorizeMigrationAction(w http.ResponseWriter, r *http.Request, action, verb, namespace string, auditAttrs ...any) bool {
+	if a.auth == nil {
+		auditLog(r, action, "allow", "auth disabled", auditAttrs...)
+		return true
+	}
+	user := userFromContext(r.Context())
+	if user == nil {
+		// authMiddleware only lets tokenless requests through for reads,
+		// so this is reachable only if a route is misclassified.
+		auditLog(r, action, "deny", "unauthenticated", auditAttrs...)
+		unauthorized(w, r, "Authentication required")
+		return false
+	}
+	allowed, reason, err := a.auth.authz.authorize(r.Context(), user, verb, namespace)
+	if err != nil {
+		slog.Error("Authorization backend error", "action", action, "error", err, "request_id", requestIDFromContext(r.Context()))
+		auditLog(r, action, "error", err.Error(), auditAttrs...)
+		jsonError(w, "Authorization unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if !allowed {
+		dashboardAuthzDenialsTotal.Add(1)
+		if reason == "" {
+			reason = "no RBAC rule allows this action"
+		}
+		auditLog(r, action, "deny", reason, auditAttrs...)
+		scope := "cluster-wide"
+		if namespace != "" {
+			scope = "in namespace " + namespace
+		}
+		jsonError(w, fmt.Sprintf("User %q cannot %s %s.%s %s", user.Username, verb, migrationResource, migrationAPIGroup, scope), http.StatusForbidden)
+		return false
+	}
+	auditLog(r, action, "allow", reason, auditAttrs...)
+	return true
+}
+
+// auditLog writes one audit record for a migration action. Records share
+// the "Audit" message and audit=true attribute so log pipelines can route
+// them separately from operational logs.
+func auditLog(r *http.Request, action, decision, reason string, attrs ...any) {
+	user := userFromContext(r.Context())
+	fields := make([]any, 0, 16+len(attrs))
+	fields = append(fields, "audit", true, "action", action, "decision", decision)
+	if user != nil {
+		fields = append(fields, "user", user.Username, "groups", user.Groups)
+	} else {
+		fields = append(fields, "user", anonymousUser)
+	}
+	if reason != "" {
+		fields = append(fields, "reason", reason)
+	}
+	fields = append(fields, attrs...)
+	fields = append(fields, "remote_addr", r.RemoteAddr, "request_id", requestIDFromContext(r.Context()))
+	if decision == "allow" {
+		slog.Info("Audit", fields...)
+	} else {
+		slog.Warn("Audit", fields...)
+	}
+}
@@ -0,0 +1,475 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// stubAuthn maps tokens to users; unknown tokens are unauthenticated.
+type stubAuthn struct {
+	users map[string]*userInfo
+	err   error
+}
+
+func (s *stubAuthn) authenticate(_ context.Context, token string) (*userInfo, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if u, ok := s.users[token]; ok {
+		return u, nil
+	}
+	return nil, errUnauthenticated
+}
+
+// stubAuthz allows (user, verb, namespace) triples listed in allow.
+type stubAuthz struct {
+	allow map[string]bool
+	calls atomic.Int32
+}
+
+func (s *stubAuthz) authorize(_ context.Context, u *userInfo, verb, namespace string) (bool, string, error) {
+	s.calls.Add(1)
+	return s.allow[u.Username+"|"+verb+"|"+namespace], "", nil
+}
+
+func newAuthApp(anonymousRead bool, authz *stubAuthz) *App {
+	return &App{
+		orch: newFakeOrchestrator("slow"),
+		auth: &authConfig{
+			mode: authModeToken,
+			authn: &stubAuthn{users: map[string]*userInfo{
+				"alice-token": {Username: "alice", Groups: []string{"dev"}},
+				"bob-token":   {Username: "bob"},
+			}},
+			authz:         authz,
+			anonymousRead: anonymousRead,
+		},
+	}
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewClientset()
+	var calls atomic.Int32
+	cs.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls.Add(1)
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		out := tr.DeepCopy()
+		if tr.Spec.Token == "good" {
+			out.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User: authenticationv1.UserInfo{
+					Username: "system:serviceaccount:ops:deployer",
+					Groups:   []string{"system:serviceaccounts"},
+					Extra:    map[string]authenticationv1.ExtraValue{"scope": {"a"}},
+				},
+			}
+		} else {
+			out.Status = authenticationv1.TokenReviewStatus{Error: "token expired"}
+		}
+		return true, out, nil
+	})
+	a := newTokenReviewAuthenticator(cs)
+
+	u, err := a.authenticate(context.Background(), "good")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if u.Username != "system:serviceaccount:ops:deployer" || len(u.Groups) != 1 || u.Extra["scope"][0] != "a" {
+		t.Fatalf("unexpected user %+v", u)
+	}
+	if _, err := a.authenticate(context.Background(), "good"); err != nil {
+		t.Fatalf("cached authenticate: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected cached second lookup, got %d TokenReviews", calls.Load())
+	}
+
+	_, err = a.authenticate(context.Background(), "bad")
+	if !errors.Is(err, errUnauthenticated) || !strings.Contains(err.Error(), "token expired") {
+		t.Fatalf("expected errUnauthenticated with reason, got %v", err)
+	}
+}
+
+func TestTokenCache_Expires(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1000, 0)
+	c := tokenCache{now: func() time.Time { return now }}
+	c.put("tok", &userInfo{Username: "u"}, time.Second)
+	if c.get("tok") == nil {
+		t.Fatal("expected cache hit")
+	}
+	now = now.Add(2 * time.Second)
+	if c.get("tok") != nil {
+		t.Fatal("expected expired entry to miss")
+	}
+}
+
+func TestSubjectAccessReviewer_Attributes(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewClientset()
+	var got *authorizationv1.SubjectAccessReview
+	cs.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		got = action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		out := got.DeepCopy()
+		out.Status.Allowed = got.Spec.ResourceAttributes.Namespace == "team-a"
+		out.Status.Reason = "rbac"
+		return true, out, nil
+	})
+	s := &subjectAccessReviewer{client: cs}
+	user := &userInfo{Username: "alice", Groups: []string{"dev"}, Extra: map[string][]string{"k": {"v"}}}
+
+	allowed, reason, err := s.authorize(context.Background(), user, "create", "team-a")
+	if err != nil || !allowed || reason != "rbac" {
+		t.Fatalf("authorize = (%v, %q, %v), want allowed", allowed, reason, err)
+	}
+	ra := got.Spec.ResourceAttributes
+	if got.Spec.User != "alice" || got.Spec.Groups[0] != "dev" || got.Spec.Extra["k"][0] != "v" {
+		t.Fatalf("unexpected SAR subject: %+v", got.Spec)
+	}
+	if ra.Group != "katamaran.io" || ra.Resource != "migrations" || ra.Verb != "create" || ra.Namespace != "team-a" {
+		t.Fatalf("unexpected SAR resource attributes: %+v", ra)
+	}
+
+	allowed, _, err = s.authorize(context.Background(), user, "create", "team-b")
+	if err != nil || allowed {
+		t.Fatalf("authorize in team-b = (%v, %v), want denied", allowed, err)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		anonymousRead bool
+		method        string
+		path          string
+		token         string
+		want          int
+	}{
+		{"public healthz", false, http.MethodGet, "/healthz", "", http.StatusOK},
+		{"public index", false, http.MethodGet, "/", "", http.StatusOK},
+		{"anonymous read allowed", true, http.MethodGet, "/api/status", "", http.StatusOK},
+		{"anonymous read disabled", false, http.MethodGet, "/api/status", "", http.StatusUnauthorized},
+		{"anonymous write rejected", true, http.MethodPost, "/api/ping/stop", "", http.StatusUnauthorized},
+		{"bad token rejected on read", true, http.MethodGet, "/api/status", "nope", http.StatusUnauthorized},
+		{"valid token read", false, http.MethodGet, "/api/status", "alice-token", http.StatusOK},
+		{"valid token write", false, http.MethodPost, "/api/ping/stop", "alice-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			app := newAuthApp(tt.anonymousRead, &stubAuthz{})
+			h := app.authMiddleware(app.newMux(false))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_BackendError(t *testing.T) {
+	t.Parallel()
+	app := &App{auth: &authConfig{mode: authModeToken, authn: &stubAuthn{err: errors.New("apiserver down")}, authz: &stubAuthz{}}}
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	req.Header.Set("Authorization", "Bearer x")
+	w := httptest.NewRecorder()
+	app.authMiddleware(app.newMux(false)).ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+}
+
+func postMigrate(t *testing.T, h http.Handler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := validMigrateForm()
+	form.Del("source_node")
+	form.Del("qmp_source")
+	form.Del("qmp_dest")
+	form.Del("dest_ip")
+	form.Del("vm_ip")
+	form.Del("tap")
+	form.Set("source_pod_namespace", "team-a")
+	form.Set("source_pod_name", "kata-demo")
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleMigrate_AuthorizesInSourceNamespace(t *testing.T) {
+	t.Parallel()
+	authz := &stubAuthz{allow: map[string]bool{"alice|create|team-a": true, "alice|delete|team-a": true}}
+	app := newAuthApp(true, authz)
+	app.discoverer = &stubDiscoverer{
+		pods:  []orchestrator.PodInfo{{Namespace: "team-a", Name: "kata-demo", Node: "node1"}},
+		nodes: []orchestrator.NodeInfo{{Name: "node2", InternalIP: "10.0.0.2"}},
+	}
+	h := app.authMiddleware(app.newMux(false))
+
+	if w := postMigrate(t, h, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous migrate: status = %d, want 401", w.Code)
+	}
+	if w := postMigrate(t, h, "bob-token"); w.Code != http.StatusForbidden {
+		t.Fatalf("bob migrate: status = %d, want 403 (body %s)", w.Code, w.Body.String())
+	}
+	app.migrationMutex.Lock()
+	started := app.migrationsStarted
+	app.migrationMutex.Unlock()
+	if started != 0 {
+		t.Fatalf("denied request started a migration")
+	}
+
+	if w := postMigrate(t, h, "alice-token"); w.Code != http.StatusAccepted {
+		t.Fatalf("alice migrate: status = %d, want 202 (body %s)", w.Code, w.Body.String())
+	}
+
+	stop := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/migrate/stop", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := stop("bob-token"); code != http.StatusForbidden {
+		t.Fatalf("bob stop: status = %d, want 403", code)
+	}
+	app.migrationMutex.Lock()
+	stillRunning := app.isMigrating
+	app.migrationMutex.Unlock()
+	if !stillRunning {
+		t.Fatal("denied stop cancelled the migration")
+	}
+	if code := stop("alice-token"); code != http.StatusOK {
+		t.Fatalf("alice stop: status = %d, want 200", code)
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+
+	app.migrationMutex.Lock()
+	hist := app.migrationHistory
+	app.migrationMutex.Unlock()
+	if len(hist) != 1 || hist[0].RequestedBy != "alice" {
+		t.Fatalf("history = %+v, want one entry requested by alice", hist)
+	}
+}
+
+func TestHandleMigrate_AuthDisabledRecordsAnonymous(t *testing.T) {
+	t.Parallel()
+	app := &App{orch: dummyOrchestrator(t)}
+	form := validMigrateForm()
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.handleMigrate(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v", w.Code)
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+	if got := app.migrationHistory[0].RequestedBy; got != anonymousUser {
+		t.Fatalf("requested_by = %q, want %q", got, anonymousUser)
+	}
+}
+
+func TestRun_InvalidAuthFlags(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--auth-mode", "basic"}, "invalid --auth-mode"},
+		{[]string{"--auth-mode", "oidc", "--oidc-client-id", "x"}, "https:// --oidc-issuer-url"},
+		{[]string{"--auth-mode", "oidc", "--oidc-issuer-url", "https://issuer"}, "--oidc-client-id"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := Run(context.Background(), tt.args, &stdout, &stderr); code != 2 {
+			t.Fatalf("%v: exit code %d, want 2", tt.args, code)
+		}
+		if !strings.Contains(stderr.String(), tt.want) {
+			t.Fatalf("%v: expected %q in stderr, got: %s", tt.args, tt.want, stderr.String())
+		}
+	}
+}
+
+// fakeIssuer serves OIDC discovery and a JWKS over TLS and signs tokens.
+type fakeIssuer struct {
+	srv    *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := &fakeIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": fi.srv.URL, "jwks_uri": fi.srv.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	fi.srv = httptest.NewTLSServer(mux)
+	t.Cleanup(fi.srv.Close)
+	return fi
+}
+
+func (fi *fakeIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, fi.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, fi.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (fi *fakeIssuer) authenticator() *oidcAuthenticator {
+	a := newOIDCAuthenticator(fi.srv.URL, "katamaran", "email", defaultOIDCPrefix, "groups", defaultOIDCPrefix)
+	a.httpClient = fi.srv.Client()
+	return a
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	t.Parallel()
+	fi := newFakeIssuer(t)
+	now := time.Now().Unix()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": fi.srv.URL, "aud": []string{"other", "katamaran"}, "sub": "123",
+			"email": "alice@example.com", "email_verified": true,
+			"groups": []string{"ops", "dev"}, "exp": now + 300, "iat": now,
+		}
+	}
+
+	a := fi.authenticator()
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa1", "ES256": "ec1"}[alg]
+		u, err := a.authenticate(context.Background(), fi.sign(t, alg, kid, valid()))
+		if err != nil {
+			t.Fatalf("%s: authenticate: %v", alg, err)
+		}
+		if u.Username != "oidc:alice@example.com" || u.UID != "123" || !slices.Equal(u.Groups, []string{"oidc:ops", "oidc:dev"}) {
+			t.Fatalf("%s: unexpected user %+v", alg, u)
+		}
+	}
+
+	rejects := map[string]func(c map[string]any){
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "https://evil" },
+		"wrong audience": func(c map[string]any) { c["aud"] = "someone-else" },
+		"expired":        func(c map[string]any) { c["exp"] = now - 3600 },
+		"not yet valid":  func(c map[string]any) { c["nbf"] = now + 3600 },
+		"unverified":     func(c map[string]any) { c["email_verified"] = false },
+		"no username":    func(c map[string]any) { delete(c, "email") },
+	}
+	for name, mutate := range rejects {
+		c := valid()
+		mutate(c)
+		if _, err := fi.authenticator().authenticate(context.Background(), fi.sign(t, "RS256", "rsa1", c)); !errors.Is(err, errUnauthenticated) {
+			t.Fatalf("%s: expected errUnauthenticated, got %v", name, err)
+		}
+	}
+
+	// Prefixed IdP identities cannot pose as Kubernetes ones.
+	c := valid()
+	c["email"], c["groups"] = "system:admin", []string{"system:masters"}
+	u, err := a.authenticate(context.Background(), fi.sign(t, "RS256", "rsa1", c))
+	if err != nil || u.Username != "oidc:system:admin" || !slices.Equal(u.Groups, []string{"oidc:system:masters"}) {
+		t.Fatalf("prefixed system identity = %+v, %v", u, err)
+	}
+	// Without prefixes, reserved names are rejected outright.
+	for name, mutate := range map[string]func(c map[string]any){
+		"system username":        func(c map[string]any) { c["email"] = "system:admin" },
+		"service account":        func(c map[string]any) { c["email"] = "system:serviceaccount:kube-system:katamaran-mgr" },
+		"system:masters group":   func(c map[string]any) { c["groups"] = []string{"ops", "system:masters"} },
+		"system group as string": func(c map[string]any) { c["groups"] = "system:authenticated" },
+	} {
+		c := valid()
+		mutate(c)
+		bare := fi.authenticator()
+		bare.usernamePrefix, bare.groupsPrefix = "", ""
+		if _, err := bare.authenticate(context.Background(), fi.sign(t, "RS256", "rsa1", c)); !errors.Is(err, errUnauthenticated) {
+			t.Fatalf("%s: expected errUnauthenticated, got %v", name, err)
+		}
+	}
+
+	// Tampered payload: signature no longer matches.
+	tok := fi.sign(t, "RS256", "rsa1", valid())
+	parts := strings.Split(tok, ".")
+	c = valid()
+	c["email"] = "mallory@example.com"
+	body, _ := json.Marshal(c)
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2]
+	if _, err := fi.authenticator().authenticate(context.Background(), forged); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("tampered token: expected errUnauthenticated, got %v", err)
+	}
+
+	// alg=none must never be accepted.
+	hdr := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa1"}`))
+	if _, err := fi.authenticator().authenticate(context.Background(), hdr+"."+parts[1]+"."); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("alg none: expected errUnauthenticated, got %v", err)
+	}
+}
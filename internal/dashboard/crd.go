@@ -0,0 +1,497 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/maci0/katamaran/internal/controller"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// Migration backends selectable with --migration-mode.
+const (
+	// migrationModeCRD creates a Migration CR per request and renders its
+	// status. katamaran-mgr owns the migration (finalizers, leader
+	// election, restart recovery, source cleanup), so restarting the
+	// dashboard never orphans one.
+	migrationModeCRD = "crd"
+	// migrationModeDirect runs the orchestrator inside the dashboard
+	// process. Required for legacy node-mode requests.
+	migrationModeDirect = "direct"
+)
+
+// Metadata stamped on dashboard-created Migration CRs so a restarted
+// dashboard can find them again and map them back to its migration IDs.
+const (
+	managedByLabel            = "app.kubernetes.io/managed-by"
+	managedByDashboard        = "katamaran-dashboard"
+	dashboardMigrationIDLabel = "katamaran.io/dashboard-migration-id"
+	requestedByAnnotation     = "katamaran.io/requested-by"
+	migrationCRNamePrefix     = "dashboard-"
+)
+
+const (
+	// crAPITimeout bounds a single create/delete call against the
+	// Kubernetes API made on behalf of an HTTP request.
+	crAPITimeout = 10 * time.Second
+	// crWatchRetryInterval is the pause before re-establishing a failed
+	// Get or Watch on the Migration CR.
+	crWatchRetryInterval = 2 * time.Second
+	// crPickupWarnAfter is how long a CR may sit without a status phase
+	// before the log warns that katamaran-mgr does not seem to be running.
+	crPickupWarnAfter = 30 * time.Second
+)
+
+// crdUnsupportedFormKeys are pod-mode /api/migrate overrides the Migration
+// CRD has no spec field for. CRD mode rejects them rather than silently
+// dropping them.
+var crdUnsupportedFormKeys = []string{"qmp_source", "qmp_dest", "tap_netns", "vm_ip"}
+
+// crdMode reports whether migrations are submitted as Migration CRs.
+func (a *App) crdMode() bool {
+	return a.migrationMode == migrationModeCRD
+}
+
+// wireMigrationCRs connects the CRD-mode migration backend: the dynamic
+// client for Migration CRs, the Discoverer behind the pod pickers, and
+// the history/in-flight state recovered from existing CRs. A failed
+// recovery (e.g. CRD not yet installed) is logged, not fatal.
+func (a *App) wireMigrationCRs(ctx context.Context) error {
+	cfg, err := loadRestConfig()
+	if err != nil {
+		return err
+	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("dynamic client: %w", err)
+	}
+	kube, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("kubernetes client: %w", err)
+	}
+	a.crd = dyn
+	a.discoverer = orchestrator.NewDiscovererFromClient(kube)
+
+	resumeCtx, cancel := context.WithTimeout(ctx, crAPITimeout)
+	defer cancel()
+	if err := a.resumeMigrationCRs(resumeCtx); err != nil {
+		slog.Warn("Could not restore migrations from Migration CRs; is config/crd/migration.yaml applied?", "error", err)
+	}
+	return nil
+}
+
+// migrationCRName returns the Migration CR name for a dashboard migration ID.
+func migrationCRName(migrationID string) string {
+	return migrationCRNamePrefix + migrationID
+}
+
+// newMigrationObject renders req as a Migration CR in the source pod's
+// namespace. req must be in pod mode (SourcePod set).
+func newMigrationObject(req orchestrator.Request, migrationID, requestedBy string) *unstructured.Unstructured {
+	spec := map[string]any{
+		"sourcePod": map[string]any{
+			"namespace": req.SourcePod.Namespace,
+			"name":      req.SourcePod.Name,
+		},
+		"image":         req.Image,
+		"sharedStorage": req.SharedStorage,
+		"replayCmdline": req.ReplayCmdline,
+		"autoDowntime":  req.AutoDowntime,
+	}
+	if req.DestNode != "" {
+		spec["destNode"] = req.DestNode
+	}
+	if req.DestPod != nil {
+		spec["destPod"] = map[string]any{
+			"namespace": req.DestPod.Namespace,
+			"name":      req.DestPod.Name,
+		}
+	}
+	if req.TunnelMode != "" {
+		spec["tunnelMode"] = req.TunnelMode
+	}
+	if req.TapIface != "" {
+		spec["tapIface"] = req.TapIface
+	}
+	if req.DowntimeMS > 0 {
+		spec["downtimeMS"] = int64(req.DowntimeMS)
+	}
+	metadata := map[string]any{
+		"name":      migrationCRName(migrationID),
+		"namespace": req.SourcePod.Namespace,
+		"labels": map[string]any{
+			managedByLabel:            managedByDashboard,
+			dashboardMigrationIDLabel: migrationID,
+		},
+	}
+	if requestedBy != "" {
+		metadata["annotations"] = map[string]any{requestedByAnnotation: requestedBy}
+	}
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": controller.MigrationGVR.GroupVersion().String(),
+		"kind":       "Migration",
+		"metadata":   metadata,
+		"spec":       spec,
+	}}
+}
+
+// crStatus is the subset of a Migration's .status the dashboard renders.
+// It is comparable so the watcher can skip events that changed nothing
+// it displays (e.g. finalizer or metadata-only updates).
+type crStatus struct {
+	Phase             string
+	OrchestratorID    string
+	Message           string
+	Error             string
+	StartedAt         string
+	CompletedAt       string
+	RAMTransferred    int64
+	RAMTotal          int64
+	DowntimeMS        int64
+	AppliedDowntimeMS int64
+	RTTMS             int64
+	AutoDowntime      bool
+	VMStoppedAt       string
+	VMResumedAt       string
+}
+
+func crStatusFrom(obj *unstructured.Unstructured) crStatus {
+	str := func(field string) string {
+		v, _, _ := unstructured.NestedString(obj.Object, "status", field)
+		return v
+	}
+	num := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		return v
+	}
+	auto, _, _ := unstructured.NestedBool(obj.Object, "status", "autoDowntime")
+	return crStatus{
+		Phase:             str("phase"),
+		OrchestratorID:    str("migrationID"),
+		Message:           str("message"),
+		Error:             str("error"),
+		StartedAt:         str("startedAt"),
+		CompletedAt:       str("completedAt"),
+		RAMTransferred:    num("ramTransferred"),
+		RAMTotal:          num("ramTotal"),
+		DowntimeMS:        num("actualDowntimeMS"),
+		AppliedDowntimeMS: num("appliedDowntimeMS"),
+		RTTMS:             num("rttMS"),
+		AutoDowntime:      auto,
+		VMStoppedAt:       str("vmStoppedAt"),
+		VMResumedAt:       str("vmResumedAt"),
+	}
+}
+
+// update converts the status into the StatusUpdate shape the
+// migrationReporter renders.
+func (s crStatus) update() orchestrator.StatusUpdate {
+	u := orchestrator.StatusUpdate{
+		ID:                orchestrator.MigrationID(s.OrchestratorID),
+		Phase:             orchestrator.StatusPhase(s.Phase),
+		Message:           s.Message,
+		When:              time.Now(),
+		RAMTransferred:    s.RAMTransferred,
+		RAMTotal:          s.RAMTotal,
+		DowntimeMS:        s.DowntimeMS,
+		AppliedDowntimeMS: s.AppliedDowntimeMS,
+		RTTMS:             s.RTTMS,
+		AutoDowntime:      s.AutoDowntime,
+	}
+	if s.Error != "" {
+		u.Error = errors.New(s.Error)
+	}
+	// Unparseable timestamps are left zero; they only feed the
+	// verifier's cutover correlation.
+	u.VMStoppedAt, _ = time.Parse(time.RFC3339Nano, s.VMStoppedAt)
+	u.VMResumedAt, _ = time.Parse(time.RFC3339Nano, s.VMResumedAt)
+	return u
+}
+
+// createMigrationCR submits obj and returns the HTTP status and message
+// to report when the API rejects it.
+func (a *App) createMigrationCR(ctx context.Context, obj *unstructured.Unstructured) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, crAPITimeout)
+	defer cancel()
+	_, err := a.crd.Resource(controller.MigrationGVR).Namespace(obj.GetNamespace()).Create(ctx, obj, metav1.CreateOptions{})
+	switch {
+	case err == nil:
+		return 0, "", nil
+	case apierrors.IsNotFound(err):
+		return http.StatusServiceUnavailable, "Migration CRD not installed (apply config/crd/migration.yaml and config/crd/manager.yaml, or run with --migration-mode=direct)", err
+	default:
+		return http.StatusBadGateway, "Failed to create Migration CR", err
+	}
+}
+
+// deleteMigrationCR deletes the "namespace/name" Migration CR. The
+// controller's finalizer stops the underlying Jobs before the object
+// goes away. A CR that is already gone is not an error.
+func (a *App) deleteMigrationCR(ctx context.Context, cr string) error {
+	ns, name, _ := strings.Cut(cr, "/")
+	ctx, cancel := context.WithTimeout(ctx, crAPITimeout)
+	defer cancel()
+	err := a.crd.Resource(controller.MigrationGVR).Namespace(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete Migration %s: %w", cr, err)
+	}
+	return nil
+}
+
+// watchMigrationCR follows the Migration CR namespace/name until it
+// reaches a terminal phase or is deleted, rendering each status change
+// through the same reporter as the in-process orchestrator path. Watch
+// interruptions are bridged with a fresh Get, so no terminal status is
+// missed.
+func (a *App) watchMigrationCR(ctx context.Context, namespace, name, migrationID string, start time.Time) {
+	logger := slog.Default().With("migration_id", migrationID, "migration_cr", namespace+"/"+name)
+	defer a.endMigration(start)
+	defer a.recoverMigrationWorker(logger)
+
+	client := a.crd.Resource(controller.MigrationGVR).Namespace(namespace)
+	rep := newMigrationReporter(a, logger, migrationID, start)
+	var last crStatus
+	// observe renders obj's status if it changed and reports whether the
+	// migration reached a terminal phase.
+	observe := func(obj *unstructured.Unstructured) bool {
+		st := crStatusFrom(obj)
+		if st.Phase == "" || st == last {
+			return false
+		}
+		last = st
+		rep.update(st.OrchestratorID, st.update())
+		return orchestrator.StatusPhase(st.Phase).IsTerminal()
+	}
+	deleted := func() {
+		a.appendLog(">>> Migration " + namespace + "/" + name + " deleted")
+		rep.finishLost("Migration CR deleted before completion")
+	}
+	pickupWarn := time.NewTimer(crPickupWarnAfter)
+	defer pickupWarn.Stop()
+
+	for {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			deleted()
+			return
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			dashboardMigrationWatchErrorsTotal.Add(1)
+			logger.Warn("Get Migration CR failed; retrying", "error", err)
+			if !sleepCtx(ctx, crWatchRetryInterval) {
+				return
+			}
+			continue
+		}
+		if observe(obj) {
+			rep.finish()
+			return
+		}
+		w, err := client.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: obj.GetResourceVersion(),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			dashboardMigrationWatchErrorsTotal.Add(1)
+			logger.Warn("Watch Migration CR failed; retrying", "error", err)
+			if !sleepCtx(ctx, crWatchRetryInterval) {
+				return
+			}
+			continue
+		}
+	events:
+		for {
+			select {
+			case <-ctx.Done():
+				w.Stop()
+				return
+			case <-pickupWarn.C:
+				if last.Phase == "" {
+					logger.Warn("Migration CR not picked up by katamaran-mgr", "waited", crPickupWarnAfter)
+					a.appendLog(fmt.Sprintf("Warning: Migration CR has no status after %s; is katamaran-mgr running?", crPickupWarnAfter))
+				}
+			case ev, ok := <-w.ResultChan():
+				if !ok {
+					break events
+				}
+				switch ev.Type {
+				case watch.Added, watch.Modified, watch.Deleted:
+					obj, isObj := ev.Object.(*unstructured.Unstructured)
+					if !isObj || obj.GetName() != name {
+						continue
+					}
+					if ev.Type == watch.Deleted {
+						w.Stop()
+						if observe(obj) {
+							rep.finish()
+						} else {
+							deleted()
+						}
+						return
+					}
+					if observe(obj) {
+						w.Stop()
+						rep.finish()
+						return
+					}
+				case watch.Error:
+					dashboardMigrationWatchErrorsTotal.Add(1)
+					logger.Warn("Migration CR watch error; re-reading", "error", apierrors.FromObject(ev.Object))
+					break events
+				}
+			}
+		}
+		w.Stop()
+	}
+}
+
+// sleepCtx waits for d or until ctx is done, reporting whether the full
+// wait elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// resumeMigrationCRs rebuilds the history from dashboard-created
+// Migration CRs and reattaches to the newest one still in flight, so a
+// dashboard restart loses neither the history nor the progress view of a
+// running migration. Called once from Run before the server starts.
+func (a *App) resumeMigrationCRs(ctx context.Context) error {
+	list, err := a.crd.Resource(controller.MigrationGVR).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByDashboard,
+	})
+	if err != nil {
+		return fmt.Errorf("list dashboard Migration CRs: %w", err)
+	}
+	items := list.Items
+	slices.SortStableFunc(items, func(x, y unstructured.Unstructured) int {
+		return x.GetCreationTimestamp().Compare(y.GetCreationTimestamp().Time)
+	})
+
+	var history []MigrationHistoryEntry
+	var inflight *unstructured.Unstructured
+	for i := range items {
+		obj := &items[i]
+		st := crStatusFrom(obj)
+		if orchestrator.StatusPhase(st.Phase).IsTerminal() {
+			history = append(history, historyEntryFromCR(obj, st))
+			continue
+		}
+		if obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if inflight != nil {
+			slog.Warn("Multiple in-flight dashboard Migration CRs; tracking only the newest", "skipped", inflight.GetNamespace()+"/"+inflight.GetName())
+		}
+		inflight = obj
+	}
+	if len(history) > maxHistoryEntries {
+		history = slices.Delete(history, 0, len(history)-maxHistoryEntries)
+	}
+
+	a.migrationMutex.Lock()
+	a.migrationHistory = history
+	var namespace, name, migrationID string
+	var start time.Time
+	if inflight != nil {
+		namespace, name = inflight.GetNamespace(), inflight.GetName()
+		migrationID = dashboardMigrationID(inflight)
+		start = crStartTime(inflight, crStatusFrom(inflight))
+		a.isMigrating = true
+		a.migrationOutput = nil
+		a.migrationLogSeq++
+		a.logBufferWrapped = false
+		a.latestProgress = nil
+		a.migrationID = migrationID
+		a.migrationNamespace = namespace
+		a.migrationRequestedBy = inflight.GetAnnotations()[requestedByAnnotation]
+		a.migrationCR = namespace + "/" + name
+		a.migrationStart = start
+		a.migrationsStarted++
+		dashboardMigrationsActive.Add(1)
+		a.publishMigrationStateLocked()
+	}
+	a.migrationMutex.Unlock()
+
+	slog.Info("Restored migration history from Migration CRs", "entries", len(history), "in_flight", inflight != nil)
+	if inflight != nil {
+		slog.Info("Reattached to in-flight Migration CR", "migration_id", migrationID, "migration_cr", namespace+"/"+name)
+		a.appendLog(">>> Reattached to Migration " + namespace + "/" + name + " after dashboard restart")
+		go a.watchMigrationCR(a.lifecycleContext(), namespace, name, migrationID, start)
+	}
+	return nil
+}
+
+// dashboardMigrationID returns the dashboard migration ID of a
+// dashboard-created CR, falling back to its name suffix if the label
+// was removed.
+func dashboardMigrationID(obj *unstructured.Unstructured) string {
+	if id := obj.GetLabels()[dashboardMigrationIDLabel]; id != "" {
+		return id
+	}
+	return strings.TrimPrefix(obj.GetName(), migrationCRNamePrefix)
+}
+
+// crStartTime is when the controller started the migration, or when the
+// CR was created if it has not yet.
+func crStartTime(obj *unstructured.Unstructured, st crStatus) time.Time {
+	if t, err := time.Parse(time.RFC3339, st.StartedAt); err == nil {
+		return t
+	}
+	return obj.GetCreationTimestamp().Time
+}
+
+// historyEntryFromCR builds the history entry for a terminal Migration CR.
+func historyEntryFromCR(obj *unstructured.Unstructured, st crStatus) MigrationHistoryEntry {
+	start := crStartTime(obj, st)
+	entry := MigrationHistoryEntry{
+		MigrationID:    dashboardMigrationID(obj),
+		Result:         "success",
+		StartedAt:      start.UTC().Format(time.RFC3339),
+		CompletedAt:    st.CompletedAt,
+		RAMTransferred: st.RAMTransferred,
+		RAMTotal:       st.RAMTotal,
+		DowntimeMS:     st.DowntimeMS,
+		RequestedBy:    obj.GetAnnotations()[requestedByAnnotation],
+	}
+	if st.Phase == string(orchestrator.PhaseFailed) {
+		entry.Result = "error"
+		entry.Error = st.Error
+		if entry.Error == "" {
+			entry.Error = "migration failed"
+			if st.Message != "" {
+				entry.Error = st.Message
+			}
+		}
+	}
+	if done, err := time.Parse(time.RFC3339, st.CompletedAt); err == nil {
+		entry.DurationMS = done.Sub(start).Milliseconds()
+	}
+	return entry
+}
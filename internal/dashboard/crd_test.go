@@ -0,0 +1,330 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedyn "k8s.io/client-go/dynamic/fake"
+
+	"github.com/maci0/katamaran/internal/controller"
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+func newFakeMigrationClient(objs ...runtime.Object) *fakedyn.FakeDynamicClient {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "katamaran.io", Version: "v1alpha1", Kind: "Migration"}, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "katamaran.io", Version: "v1alpha1", Kind: "MigrationList"}, &unstructured.UnstructuredList{})
+	return fakedyn.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		controller.MigrationGVR: "MigrationList",
+	}, objs...)
+}
+
+func newCRDApp(dyn *fakedyn.FakeDynamicClient) *App {
+	return &App{
+		startTime:     time.Now(),
+		migrationMode: migrationModeCRD,
+		crd:           dyn,
+		discoverer: &stubDiscoverer{
+			pods:  []orchestrator.PodInfo{{Namespace: "team-a", Name: "kata-demo", Node: "node1"}},
+			nodes: []orchestrator.NodeInfo{{Name: "node2", InternalIP: "10.0.0.2"}},
+		},
+	}
+}
+
+func podMigrateForm() url.Values {
+	form := url.Values{}
+	form.Set("source_pod_namespace", "team-a")
+	form.Set("source_pod_name", "kata-demo")
+	form.Set("dest_node", "node2")
+	form.Set("image", "katamaran:dev")
+	form.Set("tap", "tap0_kata")
+	form.Set("tunnel_mode", "none")
+	form.Set("downtime", "40")
+	form.Set("shared_storage", "true")
+	return form
+}
+
+func postMigrateForm(t *testing.T, app *App, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.newMux(false).ServeHTTP(w, req)
+	return w
+}
+
+// waitForCRWatch blocks until the watcher has opened its Watch. The fake
+// client does not replay changes made before the Watch call, so status
+// patches must wait for it.
+func waitForCRWatch(t *testing.T, dyn *fakedyn.FakeDynamicClient) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, a := range dyn.Actions() {
+			if a.GetVerb() == "watch" {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Migration CR watch was not started")
+}
+
+func setCRStatus(t *testing.T, dyn *fakedyn.FakeDynamicClient, namespace, name string, status map[string]any) {
+	t.Helper()
+	client := dyn.Resource(controller.MigrationGVR).Namespace(namespace)
+	obj, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get Migration: %v", err)
+	}
+	if err := unstructured.SetNestedField(obj.Object, status, "status"); err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+	if _, err := client.Update(context.Background(), obj, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update Migration: %v", err)
+	}
+}
+
+func TestHandleMigrate_CRDModeCreatesAndWatchesMigration(t *testing.T) {
+	t.Parallel()
+	dyn := newFakeMigrationClient()
+	app := newCRDApp(dyn)
+
+	w := postMigrateForm(t, app, podMigrateForm())
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202 (body %s)", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	id := resp["migration_id"]
+	name := migrationCRName(id)
+	if resp["migration_cr"] != "team-a/"+name {
+		t.Fatalf("migration_cr = %q, want team-a/%s", resp["migration_cr"], name)
+	}
+
+	obj, err := dyn.Resource(controller.MigrationGVR).Namespace("team-a").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Migration CR not created: %v", err)
+	}
+	if got := obj.GetLabels()[dashboardMigrationIDLabel]; got != id {
+		t.Errorf("migration ID label = %q, want %q", got, id)
+	}
+	if got := obj.GetAnnotations()[requestedByAnnotation]; got != anonymousUser {
+		t.Errorf("requested-by annotation = %q, want %q", got, anonymousUser)
+	}
+	spec := map[string]any{}
+	for _, f := range []string{"destNode", "image", "tunnelMode", "tapIface"} {
+		spec[f], _, _ = unstructured.NestedString(obj.Object, "spec", f)
+	}
+	spec["sourcePod"], _, _ = unstructured.NestedString(obj.Object, "spec", "sourcePod", "name")
+	spec["downtimeMS"], _, _ = unstructured.NestedInt64(obj.Object, "spec", "downtimeMS")
+	spec["sharedStorage"], _, _ = unstructured.NestedBool(obj.Object, "spec", "sharedStorage")
+	want := map[string]any{
+		"sourcePod": "kata-demo", "destNode": "node2", "image": "katamaran:dev", "tunnelMode": "none",
+		"tapIface": "tap0_kata", "downtimeMS": int64(40), "sharedStorage": true,
+	}
+	for k, v := range want {
+		if spec[k] != v {
+			t.Errorf("spec.%s = %v, want %v", k, spec[k], v)
+		}
+	}
+
+	waitForCRWatch(t, dyn)
+	setCRStatus(t, dyn, "team-a", name, map[string]any{"phase": "transferring", "migrationID": "0123456789abcdef", "ramTransferred": int64(1 << 20), "ramTotal": int64(4 << 20)})
+	setCRStatus(t, dyn, "team-a", name, map[string]any{"phase": "succeeded", "migrationID": "0123456789abcdef", "ramTransferred": int64(4 << 20), "ramTotal": int64(4 << 20), "actualDowntimeMS": int64(31)})
+	waitMigrationDone(t, app, 5*time.Second)
+
+	app.migrationMutex.Lock()
+	defer app.migrationMutex.Unlock()
+	if app.lastMigrationResult != "success" {
+		t.Fatalf("result = %q (%s), want success", app.lastMigrationResult, app.lastMigrationError)
+	}
+	if app.migrationCR != "" {
+		t.Errorf("migrationCR = %q after completion, want cleared", app.migrationCR)
+	}
+	logs := strings.Join(app.migrationOutput, "\n")
+	for _, want := range []string{"Created Migration team-a/" + name, ">>> transferring: 25%", ">>> succeeded: 4.0 MB transferred, 31ms downtime"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+	if len(app.migrationHistory) != 1 || app.migrationHistory[0].DowntimeMS != 31 {
+		t.Errorf("history = %+v", app.migrationHistory)
+	}
+}
+
+func TestHandleMigrate_CRDModeRejectsUnsupportedRequests(t *testing.T) {
+	t.Parallel()
+	withQMP := podMigrateForm()
+	withQMP.Set("qmp_source", "/run/vc/vm/abc/qmp.sock")
+	tests := []struct {
+		name string
+		form url.Values
+		want string
+	}{
+		{"node mode", validMigrateForm(), "--migration-mode=direct"},
+		{"qmp override", withQMP, "qmp_source"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dyn := newFakeMigrationClient()
+			app := newCRDApp(dyn)
+			w := postMigrateForm(t, app, tt.form)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("status = %d body = %s, want 400 mentioning %q", w.Code, w.Body.String(), tt.want)
+			}
+			if len(dyn.Actions()) != 0 {
+				t.Errorf("rejected request touched the API: %v", dyn.Actions())
+			}
+		})
+	}
+}
+
+func TestHandleMigrateStop_CRDModeDeletesMigration(t *testing.T) {
+	t.Parallel()
+	dyn := newFakeMigrationClient()
+	app := newCRDApp(dyn)
+	w := postMigrateForm(t, app, podMigrateForm())
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("migrate status = %d (body %s)", w.Code, w.Body.String())
+	}
+	waitForCRWatch(t, dyn)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate/stop", nil)
+	rec := httptest.NewRecorder()
+	app.newMux(false).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"stopped":true`) {
+		t.Fatalf("stop status = %d body = %s", rec.Code, rec.Body.String())
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+
+	list, err := dyn.Resource(controller.MigrationGVR).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("Migration CR still present after stop: %d items", len(list.Items))
+	}
+	app.migrationMutex.Lock()
+	defer app.migrationMutex.Unlock()
+	if app.lastMigrationResult != "error" || !strings.Contains(app.lastMigrationError, "deleted") {
+		t.Errorf("result = %q / %q, want error mentioning deletion", app.lastMigrationResult, app.lastMigrationError)
+	}
+}
+
+func dashboardMigration(namespace, id string, created time.Time, status map[string]any) *unstructured.Unstructured {
+	obj := newMigrationObject(orchestrator.Request{
+		SourcePod: &orchestrator.PodRef{Namespace: namespace, Name: "kata-" + id},
+		DestNode:  "node2",
+		Image:     "katamaran:dev",
+	}, id, "alice")
+	obj.SetCreationTimestamp(metav1.NewTime(created))
+	if status != nil {
+		obj.Object["status"] = status
+	}
+	return obj
+}
+
+func TestResumeMigrationCRs_RestoresHistoryAndReattaches(t *testing.T) {
+	t.Parallel()
+	base := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	done := dashboardMigration("team-a", "aaaaaaaaaaaaaaaa", base, map[string]any{
+		"phase": "succeeded", "startedAt": base.Format(time.RFC3339), "completedAt": base.Add(40 * time.Second).Format(time.RFC3339),
+		"ramTotal": int64(1 << 30), "actualDowntimeMS": int64(22),
+	})
+	failed := dashboardMigration("team-b", "bbbbbbbbbbbbbbbb", base.Add(time.Minute), map[string]any{"phase": "failed", "message": "dest job failed"})
+	running := dashboardMigration("team-a", "cccccccccccccccc", base.Add(2*time.Minute), map[string]any{"phase": "transferring", "startedAt": base.Add(2 * time.Minute).Format(time.RFC3339)})
+	foreign := dashboardMigration("team-a", "dddddddddddddddd", base.Add(3*time.Minute), nil)
+	foreign.SetLabels(map[string]string{"app": "someone-else"})
+
+	dyn := newFakeMigrationClient(done, failed, running, foreign)
+	app := newCRDApp(dyn)
+	if err := app.resumeMigrationCRs(context.Background()); err != nil {
+		t.Fatalf("resumeMigrationCRs: %v", err)
+	}
+
+	app.migrationMutex.Lock()
+	hist := append([]MigrationHistoryEntry(nil), app.migrationHistory...)
+	migrating, id, cr, user := app.isMigrating, app.migrationID, app.migrationCR, app.migrationRequestedBy
+	app.migrationMutex.Unlock()
+	if len(hist) != 2 {
+		t.Fatalf("history = %+v, want 2 entries", hist)
+	}
+	if hist[0].MigrationID != "aaaaaaaaaaaaaaaa" || hist[0].Result != "success" || hist[0].DurationMS != 40000 || hist[0].DowntimeMS != 22 || hist[0].RequestedBy != "alice" {
+		t.Errorf("history[0] = %+v", hist[0])
+	}
+	if hist[1].Result != "error" || hist[1].Error != "dest job failed" {
+		t.Errorf("history[1] = %+v", hist[1])
+	}
+	if !migrating || id != "cccccccccccccccc" || cr != "team-a/dashboard-cccccccccccccccc" || user != "alice" {
+		t.Fatalf("in-flight state = migrating %v id %q cr %q user %q", migrating, id, cr, user)
+	}
+
+	waitForCRWatch(t, dyn)
+	setCRStatus(t, dyn, "team-a", "dashboard-cccccccccccccccc", map[string]any{"phase": "succeeded"})
+	waitMigrationDone(t, app, 5*time.Second)
+	app.migrationMutex.Lock()
+	defer app.migrationMutex.Unlock()
+	if app.lastMigrationResult != "success" || len(app.migrationHistory) != 3 {
+		t.Errorf("after reattach: result %q, %d history entries", app.lastMigrationResult, len(app.migrationHistory))
+	}
+}
+
+func TestResumeMigrationCRs_WatcherStopsOnShutdown(t *testing.T) {
+	t.Parallel()
+	base := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	running := dashboardMigration("team-a", "cccccccccccccccc", base, map[string]any{"phase": "transferring", "startedAt": base.Format(time.RFC3339)})
+	dyn := newFakeMigrationClient(running)
+	app := newCRDApp(dyn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app.lifecycle = ctx
+	if err := app.resumeMigrationCRs(context.Background()); err != nil {
+		t.Fatalf("resumeMigrationCRs: %v", err)
+	}
+	waitForCRWatch(t, dyn)
+
+	cancel()
+	waitMigrationDone(t, app, 5*time.Second)
+	app.migrationMutex.Lock()
+	result, entries := app.lastMigrationResult, len(app.migrationHistory)
+	app.migrationMutex.Unlock()
+	// Shutdown is not an outcome: nothing is recorded and the CR is left
+	// for the next dashboard to reattach to.
+	if result != "" || entries != 0 {
+		t.Errorf("after shutdown: result %q, %d history entries", result, entries)
+	}
+	if _, err := dyn.Resource(controller.MigrationGVR).Namespace("team-a").Get(context.Background(), "dashboard-cccccccccccccccc", metav1.GetOptions{}); err != nil {
+		t.Errorf("Migration CR should survive shutdown: %v", err)
+	}
+}
+
+func TestHandleReadyz_CRDMode(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		app  *App
+		want int
+	}{
+		{"client wired", newCRDApp(newFakeMigrationClient()), http.StatusOK},
+		{"client missing", &App{migrationMode: migrationModeCRD, orch: newFakeOrchestrator("ok")}, http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		tt.app.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
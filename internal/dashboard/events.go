@@ -0,0 +1,299 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// Event types carried on the /api/events Server-Sent Events stream. Each
+// frame's "event:" field is one of these; the "data:" field is the JSON
+// encoding of the matching *Event payload type below.
+const (
+	eventTypeLog       = "log"
+	eventTypeProgress  = "progress"
+	eventTypePing      = "ping"
+	eventTypeHistory   = "history"
+	eventTypeMigration = "migration"
+	eventTypeLoadgen   = "loadgen"
+)
+
+const (
+	// eventSubscriberBuffer is the per-subscriber frame queue depth. A
+	// subscriber that falls this far behind is disconnected rather than
+	// allowed to stall publishers; EventSource reconnects automatically
+	// and the UI resynchronises from /api/status cursors.
+	eventSubscriberBuffer = 256
+
+	// maxEventSubscribers caps concurrent /api/events streams so a
+	// misbehaving client cannot pin unbounded goroutines and buffers.
+	maxEventSubscribers = 64
+
+	// eventKeepaliveInterval is how often an SSE comment line is written
+	// on an idle stream. Keeps intermediaries (kubectl port-forward,
+	// ingress controllers) from reaping the connection as idle.
+	eventKeepaliveInterval = 15 * time.Second
+
+	// eventRetryMS is the reconnect delay advertised to EventSource
+	// clients via the SSE "retry:" field.
+	eventRetryMS = 3000
+)
+
+// LogEvent is the payload of a "log" event: one migration log line as
+// appended to the /api/status log buffer. Seq matches the logs_next
+// cursor semantics so clients can fall back to polling without gaps.
+type LogEvent struct {
+	Seq         int64  `json:"seq"`
+	MigrationID string `json:"migration_id,omitempty"`
+	Line        string `json:"line"`
+}
+
+// ProgressEvent is the payload of a "progress" event, emitted for every
+// orchestrator.StatusUpdate the migration worker receives.
+type ProgressEvent struct {
+	MigrationID       string `json:"migration_id"`
+	Phase             string `json:"phase"`
+	Message           string `json:"message,omitempty"`
+	Error             string `json:"error,omitempty"`
+	RAMTransferred    int64  `json:"ram_transferred,omitempty"`
+	RAMTotal          int64  `json:"ram_total,omitempty"`
+	DowntimeMS        int64  `json:"downtime_ms,omitempty"`
+	AppliedDowntimeMS int64  `json:"applied_downtime_ms,omitempty"`
+	RTTMS             int64  `json:"rtt_ms,omitempty"`
+	AutoDowntime      bool   `json:"auto_downtime,omitempty"`
+}
+
+// PingEvent is the payload of a "ping" event: one load generator sample.
+// Seq matches the pings_next cursor semantics of /api/status.
+type PingEvent struct {
+	Seq int64 `json:"seq"`
+	PingData
+}
+
+// MigrationStateEvent is the payload of a "migration" event, emitted when
+// a migration starts or ends. Field names match /api/status; LogsNext is
+// the log cursor at the time of the change, so a client that sees a new
+// MigrationID can adopt it without refetching the (empty) log buffer.
+type MigrationStateEvent struct {
+	Migrating               bool   `json:"migrating"`
+	MigrationID             string `json:"migration_id,omitempty"`
+	MigrationCR             string `json:"migration_cr,omitempty"`
+	MigrationElapsedSeconds int64  `json:"migration_elapsed_seconds,omitempty"`
+	LastMigrationResult     string `json:"last_migration_result,omitempty"`
+	LastMigrationError      string `json:"last_migration_error,omitempty"`
+	LogsNext                int64  `json:"logs_next"`
+}
+
+// LoadgenEvent is the payload of a "loadgen" event, emitted when a load
+// generator starts or stops. PingsNext is the ping cursor at the time of
+// the change; a start clears the sample buffer.
+type LoadgenEvent struct {
+	LoadgenRunning bool   `json:"loadgen_running"`
+	LoadgenType    string `json:"loadgen_type,omitempty"`
+	PingsNext      int64  `json:"pings_next"`
+}
+
+// publishMigrationStateLocked emits a "migration" event for the current
+// migration state. Callers must hold migrationMutex, which orders it
+// against the log events appendLog publishes under the same lock.
+func (a *App) publishMigrationStateLocked() {
+	ev := MigrationStateEvent{
+		Migrating:           a.isMigrating,
+		MigrationID:         a.migrationID,
+		MigrationCR:         a.migrationCR,
+		LastMigrationResult: a.lastMigrationResult,
+		LastMigrationError:  a.lastMigrationError,
+		LogsNext:            a.migrationLogSeq,
+	}
+	if a.isMigrating && !a.migrationStart.IsZero() {
+		ev.MigrationElapsedSeconds = int64(time.Since(a.migrationStart).Seconds())
+	}
+	a.events.publish(eventTypeMigration, ev)
+}
+
+// publishLoadgenStateLocked emits a "loadgen" event for the current load
+// generator state. Callers must hold loadgenMutex.
+func (a *App) publishLoadgenStateLocked() {
+	a.events.publish(eventTypeLoadgen, LoadgenEvent{
+		LoadgenRunning: a.loadgenRunning,
+		LoadgenType:    a.loadgenType,
+		PingsNext:      a.pingSeq,
+	})
+}
+
+func newProgressEvent(migrationID string, u orchestrator.StatusUpdate) ProgressEvent {
+	ev := ProgressEvent{
+		MigrationID:       migrationID,
+		Phase:             string(u.Phase),
+		Message:           u.Message,
+		RAMTransferred:    u.RAMTransferred,
+		RAMTotal:          u.RAMTotal,
+		DowntimeMS:        u.DowntimeMS,
+		AppliedDowntimeMS: u.AppliedDowntimeMS,
+		RTTMS:             u.RTTMS,
+		AutoDowntime:      u.AutoDowntime,
+	}
+	if u.Error != nil {
+		ev.Error = u.Error.Error()
+	}
+	return ev
+}
+
+// eventHub fans typed dashboard events out to /api/events subscribers.
+// The zero value is ready to use. Publishing never blocks: frames are
+// encoded once and queued on each subscriber's buffered channel, and a
+// subscriber whose queue is full is dropped (its channel closed) so one
+// slow browser tab cannot delay the migration worker or load generator.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan []byte]struct{}
+	closed bool
+}
+
+// subscribe registers a new subscriber. Returns false when the hub is at
+// maxEventSubscribers or has been shut down.
+func (h *eventHub) subscribe() (chan []byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || len(h.subs) >= maxEventSubscribers {
+		return nil, false
+	}
+	if h.subs == nil {
+		h.subs = make(map[chan []byte]struct{})
+	}
+	ch := make(chan []byte, eventSubscriberBuffer)
+	h.subs[ch] = struct{}{}
+	dashboardEventSubscribers.Add(1)
+	return ch, true
+}
+
+// unsubscribe removes ch and closes it. Safe to call after the hub has
+// already dropped ch for being slow or during shutdown.
+func (h *eventHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; !ok {
+		return
+	}
+	delete(h.subs, ch)
+	close(ch)
+	dashboardEventSubscribers.Add(-1)
+}
+
+// publish encodes v as an SSE frame of the given event type and queues
+// it for every subscriber.
+func (h *eventHub) publish(eventType string, v any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to encode dashboard event", "event", eventType, "error", err)
+		return
+	}
+	frame := make([]byte, 0, len(eventType)+len(data)+16)
+	frame = append(frame, "event: "...)
+	frame = append(frame, eventType...)
+	frame = append(frame, "\ndata: "...)
+	frame = append(frame, data...)
+	frame = append(frame, "\n\n"...)
+	for ch := range h.subs {
+		select {
+		case ch <- frame:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+			dashboardEventSubscribers.Add(-1)
+			dashboardEventSubscribersDroppedTotal.Add(1)
+			slog.Warn("Dropped slow event stream subscriber", "buffer", eventSubscriberBuffer)
+		}
+	}
+}
+
+// close disconnects every subscriber and rejects new ones. Registered as
+// an http.Server shutdown hook: Shutdown does not cancel in-flight
+// request contexts, so without this the long-lived SSE handlers would
+// hold graceful shutdown open until shutdownTimeout expires.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+		dashboardEventSubscribers.Add(-1)
+	}
+}
+
+// handleEvents streams typed dashboard events as Server-Sent Events.
+// The stream carries only deltas; clients take an initial snapshot from
+// /api/status (again after a reconnect or a cursor gap) and may keep
+// polling it as a fallback when the stream is unavailable.
+func (a *App) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		// The "GET /api/events" pattern also matches HEAD, which would
+		// open a stream that never writes a body.
+		handleAPIFallback(w, r)
+		return
+	}
+	reqID := requestIDFromContext(r.Context())
+	ch, ok := a.events.subscribe()
+	if !ok {
+		slog.Warn("Event stream rejected: subscriber limit reached", "max_subscribers", maxEventSubscribers, "request_id", reqID)
+		jsonError(w, "Too many event stream subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer a.events.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	// The server-wide WriteTimeout would cut every stream after
+	// httpWriteTimeout; clear it for this connection only.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("Failed to clear event stream write deadline", "error", err, "request_id", reqID)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(b []byte) bool {
+		if _, err := w.Write(b); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write([]byte("retry: " + strconv.Itoa(eventRetryMS) + "\n\n")) {
+		return
+	}
+	slog.Debug("Event stream opened", "request_id", reqID)
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !write(frame) {
+				return
+			}
+		case <-keepalive.C:
+			if !write([]byte(": keepalive\n\n")) {
+				return
+			}
+		}
+	}
+}
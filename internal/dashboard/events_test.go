@@ -0,0 +1,274 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+)
+
+// readSSEEvent reads frames from r until it finds one with an "event:"
+// field, skipping retry and keepalive comment frames.
+func readSSEEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var eventType, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read SSE stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && eventType != "":
+			return eventType, data
+		}
+	}
+}
+
+func waitForSubscribers(t *testing.T, h *eventHub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		got := len(h.subs)
+		h.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d event subscribers", n)
+}
+
+func TestHandleEvents_StreamsTypedEvents(t *testing.T) {
+	app := &App{}
+	srv := httptest.NewServer(requestLogger(app.newMux(false)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+	waitForSubscribers(t, &app.events, 1)
+
+	app.appendLog("hello")
+	app.addPing(1.5, "")
+	app.migrationID = "abc"
+	app.setMigrationResult("success", "")
+	app.events.publish(eventTypeProgress, newProgressEvent("abc", orchestrator.StatusUpdate{
+		Phase:          orchestrator.PhaseTransferring,
+		RAMTransferred: 10,
+		RAMTotal:       100,
+		Error:          errors.New("boom"),
+	}))
+
+	br := bufio.NewReader(resp.Body)
+
+	typ, data := readSSEEvent(t, br)
+	var logEv LogEvent
+	if typ != eventTypeLog || json.Unmarshal([]byte(data), &logEv) != nil || logEv.Line != "hello" || logEv.Seq != 1 {
+		t.Fatalf("unexpected log event %q %s", typ, data)
+	}
+	typ, data = readSSEEvent(t, br)
+	var pingEv PingEvent
+	if typ != eventTypePing || json.Unmarshal([]byte(data), &pingEv) != nil || pingEv.Latency != 1.5 || pingEv.Seq != 1 {
+		t.Fatalf("unexpected ping event %q %s", typ, data)
+	}
+	typ, data = readSSEEvent(t, br)
+	var hist MigrationHistoryEntry
+	if typ != eventTypeHistory || json.Unmarshal([]byte(data), &hist) != nil || hist.MigrationID != "abc" || hist.Result != "success" {
+		t.Fatalf("unexpected history event %q %s", typ, data)
+	}
+	typ, data = readSSEEvent(t, br)
+	var prog ProgressEvent
+	if typ != eventTypeProgress || json.Unmarshal([]byte(data), &prog) != nil || prog.Phase != "transferring" || prog.RAMTotal != 100 || prog.Error != "boom" {
+		t.Fatalf("unexpected progress event %q %s", typ, data)
+	}
+
+	cancel()
+	waitForSubscribers(t, &app.events, 0)
+}
+
+func TestHandleMigrate_PublishesProgressAndHistoryEvents(t *testing.T) {
+	t.Parallel()
+	app := &App{orch: dummyOrchestrator(t)}
+	ch, ok := app.events.subscribe()
+	if !ok {
+		t.Fatal("subscribe failed")
+	}
+	defer app.events.unsubscribe(ch)
+
+	form := validMigrateForm()
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.handleMigrate(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v", w.Code)
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+
+	var progress []string
+	var sawHistory bool
+	for len(ch) > 0 {
+		frame := string(<-ch)
+		typ, data, _ := strings.Cut(strings.TrimPrefix(frame, "event: "), "\ndata: ")
+		switch typ {
+		case eventTypeProgress:
+			var ev ProgressEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &ev); err != nil {
+				t.Fatalf("decode progress event: %v", err)
+			}
+			progress = append(progress, ev.Phase)
+		case eventTypeHistory:
+			sawHistory = true
+		}
+	}
+	if strings.Join(progress, ",") != "submitted,transferring,succeeded" {
+		t.Fatalf("progress phases = %v, want submitted,transferring,succeeded", progress)
+	}
+	if !sawHistory {
+		t.Fatal("expected a history event after the migration finished")
+	}
+}
+
+func TestStateEvents_MigrationAndLoadgen(t *testing.T) {
+	t.Parallel()
+	app := &App{orch: dummyOrchestrator(t)}
+	ch, ok := app.events.subscribe()
+	if !ok {
+		t.Fatal("subscribe failed")
+	}
+	defer app.events.unsubscribe(ch)
+
+	form := validMigrateForm()
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.handleMigrate(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v", w.Code)
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+
+	if _, ok := app.tryStartLoadgen(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/ping", nil), "ping"); !ok {
+		t.Fatal("tryStartLoadgen failed")
+	}
+	app.resetLoadgen()
+
+	var migration []MigrationStateEvent
+	var loadgen []LoadgenEvent
+	var lastLogSeq int64
+	for len(ch) > 0 {
+		frame := string(<-ch)
+		typ, data, _ := strings.Cut(strings.TrimPrefix(frame, "event: "), "\ndata: ")
+		data = strings.TrimSpace(data)
+		switch typ {
+		case eventTypeMigration:
+			var ev MigrationStateEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("decode migration event: %v", err)
+			}
+			migration = append(migration, ev)
+		case eventTypeLoadgen:
+			var ev LoadgenEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("decode loadgen event: %v", err)
+			}
+			loadgen = append(loadgen, ev)
+		case eventTypeLog:
+			var ev LogEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("decode log event: %v", err)
+			}
+			// The start event's cursor must line up with the first log
+			// line so clients can follow the stream without refetching.
+			if len(migration) == 1 && lastLogSeq == 0 && ev.Seq != migration[0].LogsNext+1 {
+				t.Fatalf("first log seq = %d, want %d", ev.Seq, migration[0].LogsNext+1)
+			}
+			lastLogSeq = ev.Seq
+		}
+	}
+	if len(migration) != 2 {
+		t.Fatalf("got %d migration events, want 2: %+v", len(migration), migration)
+	}
+	if start := migration[0]; !start.Migrating || start.MigrationID == "" {
+		t.Fatalf("unexpected start event %+v", start)
+	}
+	if end := migration[1]; end.Migrating || end.MigrationID != migration[0].MigrationID || end.LastMigrationResult != "success" || end.LogsNext != lastLogSeq {
+		t.Fatalf("unexpected end event %+v (last log seq %d)", end, lastLogSeq)
+	}
+	if len(loadgen) != 2 || !loadgen[0].LoadgenRunning || loadgen[0].LoadgenType != "ping" || loadgen[0].PingsNext != 1 || loadgen[1].LoadgenRunning {
+		t.Fatalf("unexpected loadgen events %+v", loadgen)
+	}
+}
+
+func TestEventHub_DropsSlowSubscriber(t *testing.T) {
+	t.Parallel()
+	var h eventHub
+	ch, ok := h.subscribe()
+	if !ok {
+		t.Fatal("subscribe failed")
+	}
+	for i := 0; i <= eventSubscriberBuffer; i++ {
+		h.publish(eventTypeLog, LogEvent{Seq: int64(i)})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventSubscriberBuffer {
+		t.Fatalf("expected %d buffered frames before drop, got %d", eventSubscriberBuffer, n)
+	}
+	// Unsubscribing an already-dropped channel must not double-close.
+	h.unsubscribe(ch)
+}
+
+func TestEventHub_SubscriberLimitAndClose(t *testing.T) {
+	t.Parallel()
+	var h eventHub
+	subs := make([]chan []byte, 0, maxEventSubscribers)
+	for i := 0; i < maxEventSubscribers; i++ {
+		ch, ok := h.subscribe()
+		if !ok {
+			t.Fatalf("subscribe %d failed", i)
+		}
+		subs = append(subs, ch)
+	}
+	if _, ok := h.subscribe(); ok {
+		t.Fatal("expected subscribe past maxEventSubscribers to fail")
+	}
+	h.close()
+	for _, ch := range subs {
+		if _, open := <-ch; open {
+			t.Fatal("expected subscriber channel closed after hub close")
+		}
+	}
+	if _, ok := h.subscribe(); ok {
+		t.Fatal("expected subscribe after close to fail")
+	}
+}
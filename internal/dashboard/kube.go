@@ -0,0 +1,26 @@
+package dashboard
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// loadRestConfig resolves Kubernetes client configuration the same way
+// Run wires the orchestrator: in-cluster service-account credentials
+// first, then the default kubeconfig loading rules (KUBECONFIG env /
+// ~/.kube/config) for developer-laptop runs.
+func loadRestConfig() (*rest.Config, error) {
+	cfg, err := rest.InClusterConfig()
+	if err == nil {
+		return cfg, nil
+	}
+	kcfg, kerr := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if kerr != nil {
+		return nil, fmt.Errorf("in-cluster config: %v; kubeconfig: %w", err, kerr)
+	}
+	return kcfg, nil
+}
@@ -71,6 +71,7 @@ func (a *App) tryStartLoadgen(w http.ResponseWriter, r *http.Request, loadgenTyp
 	a.pingSeq++
 	ctx, cancel := context.WithCancel(context.Background())
 	a.loadgenCancel = cancel
+	a.publishLoadgenStateLocked()
 	a.loadgenMutex.Unlock()
 	return ctx, true
 }
@@ -81,6 +82,7 @@ func (a *App) resetLoadgen() {
 	a.loadgenRunning = false
 	a.loadgenType = ""
 	a.loadgenCancel = nil
+	a.publishLoadgenStateLocked()
 	a.loadgenMutex.Unlock()
 }
 
@@ -192,11 +194,13 @@ func (a *App) addPing(lat float64, errStr string) {
 	a.loadgenMutex.Lock()
 	defer a.loadgenMutex.Unlock()
 	a.pingSeq++
-	a.pingLog = append(a.pingLog, PingData{
+	sample := PingData{
 		Time:    ts,
 		Latency: lat,
 		Error:   errStr,
-	})
+	}
+	a.pingLog = append(a.pingLog, sample)
+	a.events.publish(eventTypePing, PingEvent{Seq: a.pingSeq, PingData: sample})
 	if len(a.pingLog) > maxPingLines {
 		a.pingLog = slices.Delete(a.pingLog, 0, len(a.pingLog)-maxPingLines)
 	}
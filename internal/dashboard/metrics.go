@@ -34,6 +34,13 @@ var (
 	dashboardMigrationWatchErrorsTotal  = expvar.NewInt("dashboard_migration_watch_errors_total")
 	dashboardMigrationWatchLostTotal    = expvar.NewInt("dashboard_migration_watch_lost_total")
 	dashboardMigrationWorkerPanicsTotal = expvar.NewInt("dashboard_migration_worker_panics_total")
+	dashboardVerificationsByResult      = expvar.NewMap("dashboard_verifications_by_result")
+
+	dashboardAuthFailuresTotal = expvar.NewInt("dashboard_auth_failures_total")
+	dashboardAuthzDenialsTotal = expvar.NewInt("dashboard_authz_denials_total")
+
+	dashboardEventSubscribers             = expvar.NewInt("dashboard_event_subscribers")
+	dashboardEventSubscribersDroppedTotal = expvar.NewInt("dashboard_event_subscribers_dropped_total")
 )
 
 func recordHTTPRequest(status int, duration time.Duration) {
@@ -144,6 +151,11 @@ func serveDashboardMetrics(w http.ResponseWriter, _ *http.Request) {
 	writePromMetric(bw, "dashboard_migration_watch_errors_total", "Dashboard migrations where opening the orchestrator watch stream failed.", "counter", dashboardMigrationWatchErrorsTotal.String())
 	writePromMetric(bw, "dashboard_migration_watch_lost_total", "Dashboard migrations whose watch stream closed before a terminal status.", "counter", dashboardMigrationWatchLostTotal.String())
 	writePromMetric(bw, "dashboard_migration_worker_panics_total", "Recovered panics in the dashboard migration worker goroutine.", "counter", dashboardMigrationWorkerPanicsTotal.String())
+	writePromMapMetric(bw, "dashboard_verifications_total", "Completed synthetic workload verifications by result (pass, fail, or error).", "counter", "result", dashboardVerificationsByResult)
+	writePromMetric(bw, "dashboard_auth_failures_total", "Dashboard requests whose bearer token failed authentication or could not be verified.", "counter", dashboardAuthFailuresTotal.String())
+	writePromMetric(bw, "dashboard_authz_denials_total", "Dashboard migrate/stop requests denied by SubjectAccessReview.", "counter", dashboardAuthzDenialsTotal.String())
+	writePromMetric(bw, "dashboard_event_subscribers", "Open /api/events Server-Sent Events streams.", "gauge", dashboardEventSubscribers.String())
+	writePromMetric(bw, "dashboard_event_subscribers_dropped_total", "/api/events subscribers disconnected for falling behind the event buffer.", "counter", dashboardEventSubscribersDroppedTotal.String())
 }
 
 func writePromMetric(w io.Writer, name, help, kind, value string) {
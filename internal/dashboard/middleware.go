@@ -61,6 +61,13 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController so
+// streaming handlers (/api/events) can Flush and adjust write deadlines
+// through the logging wrapper.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	if !rw.wroteHeader {
 		rw.WriteHeader(http.StatusOK)
@@ -121,6 +128,13 @@ func requestLogger(next http.Handler) http.Handler {
 			return
 		}
 		duration := time.Since(start)
+		// /api/events is a long-lived SSE stream: its duration is the
+		// client's session length, not request latency, so keep it out of
+		// the latency metrics and slow-request warnings.
+		if r.URL.Path == "/api/events" && rw.status == http.StatusOK {
+			slog.Info("Event stream closed", "elapsed", duration.Round(time.Millisecond), "bytes_out", rw.bytesOut, "remote_addr", r.RemoteAddr, "request_id", reqID)
+			return
+		}
 		recordHTTPRequest(rw.status, duration)
 		slow := duration >= slowRequestThreshold
 		failed := rw.status >= http.StatusInternalServerError
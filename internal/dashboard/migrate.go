@@ -19,14 +19,14 @@ var migrateFormKeys = []string{
 	"source_node", "dest_node", "qmp_source", "qmp_dest", "tap", "tap_netns",
 	"dest_ip", "vm_ip", "image", "shared_storage", "downtime",
 	"source_pod_name", "source_pod_namespace", "dest_pod_name", "dest_pod_namespace",
-	"replay_cmdline", "tunnel_mode", "auto_downtime",
+	"replay_cmdline", "tunnel_mode", "auto_downtime", "verify", "verify_target",
 }
 
 var migrateFormKeySet = formFieldSet(migrateFormKeys...)
 
 // migrateBoolFormKeys is the subset of /api/migrate fields whose value, when
 // supplied, must be the literal string "true" or "false".
-var migrateBoolFormKeys = []string{"shared_storage", "replay_cmdline", "auto_downtime"}
+var migrateBoolFormKeys = []string{"shared_storage", "replay_cmdline", "auto_downtime", "verify"}
 
 // formToOrchestratorRequest reads the (already-validated) form fields and
 // builds an orchestrator.Request. resolvedSrcNode and resolvedDestIP are the
@@ -111,6 +111,22 @@ func (a *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 	// Discoverer; the legacy explicit-fields path stays unchanged for
 	// backward compat.
 	podMode := r.PostFormValue("source_pod_name") != "" || r.PostFormValue("source_pod_namespace") != ""
+	if a.crdMode() {
+		// A Migration CR names its source by pod; the controller resolves
+		// the node, QMP socket, and tap itself.
+		if !podMode {
+			slog.Warn("Migration request rejected: node mode unsupported with Migration CRs", "request_id", requestIDFromContext(r.Context()))
+			jsonError(w, "Node-mode migrations require --migration-mode=direct; select a source pod instead", http.StatusBadRequest)
+			return
+		}
+		for _, key := range crdUnsupportedFormKeys {
+			if r.PostFormValue(key) != "" {
+				slog.Warn("Migration request rejected: field unsupported with Migration CRs", "field", key, "request_id", requestIDFromContext(r.Context()))
+				jsonError(w, fmt.Sprintf("Field %s is not supported with --migration-mode=crd", key), http.StatusBadRequest)
+				return
+			}
+		}
+	}
 	required := []string{"image"}
 	if podMode {
 		required = append(required, "source_pod_namespace", "source_pod_name", "dest_node")
@@ -184,6 +200,21 @@ func (a *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var verifyTarget string
+	if r.PostFormValue("verify") == "true" {
+		var err error
+		verifyTarget, err = a.resolveVerifyTarget(r.Context(), r, podMode)
+		if err != nil {
+			slog.Warn("Migration request rejected: verification target unavailable", "error", err, "request_id", requestIDFromContext(r.Context()))
+			jsonError(w, "Verification target unavailable: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if r.PostFormValue("verify_target") != "" {
+		slog.Warn("Migration request rejected: verify_target without verify", "request_id", requestIDFromContext(r.Context()))
+		jsonError(w, "verify_target requires verify=true", http.StatusBadRequest)
+		return
+	}
+
 	// Build and validate the orchestrator request before touching migration
 	// state. Earlier handleMigrate flipped isMigrating + bumped counters
 	// before Validate, so a bad request polluted /api/status's
@@ -195,12 +226,32 @@ func (a *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "Invalid migration request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	if a.orch == nil {
+	if a.crdMode() && a.crd == nil {
+		slog.Error("Migration request rejected: Kubernetes API not configured", "request_id", requestIDFromContext(r.Context()))
+		jsonError(w, "Kubernetes API not configured (no in-cluster config or KUBECONFIG)", http.StatusServiceUnavailable)
+		return
+	}
+	if !a.crdMode() && a.orch == nil {
 		slog.Error("Migration request rejected: orchestrator not configured", "request_id", requestIDFromContext(r.Context()))
 		jsonError(w, "Orchestrator not configured (no in-cluster config or KUBECONFIG)", http.StatusServiceUnavailable)
 		return
 	}
 
+	// Authorize as "create migrations.katamaran.io" in the source pod's
+	// namespace. Legacy node-mode requests name no pod, so they need the
+	// permission cluster-wide. The ID is minted up front so the audit
+	// record ties the caller to the migration it started.
+	migrationID := generateID()
+	var authNamespace, sourcePod string
+	if req.SourcePod != nil {
+		authNamespace = req.SourcePod.Namespace
+		sourcePod = req.SourcePod.Namespace + "/" + req.SourcePod.Name
+	}
+	if !a.authorizeMigrationAction(w, r, "migrate", "create", authNamespace, "migration_id", migrationID, "source_pod", sourcePod, "source_node", req.SourceNode, "dest_node", req.DestNode, "image", req.Image) {
+		return
+	}
+	requestedBy := usernameFromContext(r.Context())
+
 	a.migrationMutex.Lock()
 	if a.isMigrating {
 		runningID := a.migrationID
@@ -217,19 +268,59 @@ func (a *App) handleMigrate(w http.ResponseWriter, r *http.Request) {
 	a.migrationLogSeq++
 	a.logBufferWrapped = false
 	a.latestProgress = nil
-	migrationID := generateID()
 	a.migrationID = migrationID
-	a.migrationStart = time.Now()
+	a.migrationNamespace = authNamespace
+	a.migrationRequestedBy = requestedBy
+	start := time.Now()
+	a.migrationStart = start
 	a.migrationsStarted++
 	dashboardMigrationsActive.Add(1)
-	// Use context.Background() so the migration process survives after
+	// Detach from r.Context() so the migration worker survives after
 	// the HTTP response is sent (r.Context() cancels on response write).
-	ctx, cancel := context.WithCancel(context.Background())
-	a.migrationCancel = cancel
+	// In direct mode only stop cancels the worker, since cancelling the
+	// orchestrator rolls the migration back. In CRD mode stop deletes
+	// the CR and the watcher exits when it sees the deletion; the
+	// watcher itself follows the server lifetime.
+	ctx := context.Background()
+	var cr string
+	if a.crdMode() {
+		ctx = a.lifecycleContext()
+		cr = authNamespace + "/" + migrationCRName(migrationID)
+		a.migrationCR = cr
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		a.migrationCancel = cancel
+	}
+	if verifyTarget != "" {
+		// Started before the migration so the report covers a
+		// pre-migration baseline too.
+		a.startVerifierLocked(migrationID, verifyTarget)
+	}
+	a.publishMigrationStateLocked()
 	a.migrationMutex.Unlock()
 
 	reqID := requestIDFromContext(r.Context())
-	slog.Info("Migration initiated", "migration_id", migrationID, "request_id", reqID, "remote_addr", r.RemoteAddr, "source_node", req.SourceNode, "dest_node", req.DestNode, "image", req.Image, "dest_ip", req.DestIP, "vm_ip", req.VMIP, "shared_storage", req.SharedStorage, "pod_mode", podMode, "replay_cmdline", req.ReplayCmdline)
+	slog.Info("Migration initiated", "migration_id", migrationID, "request_id", reqID, "user", requestedBy, "remote_addr", r.RemoteAddr, "source_node", req.SourceNode, "dest_node", req.DestNode, "image", req.Image, "dest_ip", req.DestIP, "vm_ip", req.VMIP, "shared_storage", req.SharedStorage, "pod_mode", podMode, "replay_cmdline", req.ReplayCmdline, "migration_cr", cr, "verify_target", verifyTarget)
+	if verifyTarget != "" {
+		a.appendLog(">>> Verifier streaming UDP/TCP echo traffic to " + verifyTarget)
+	}
+	if a.crdMode() {
+		obj := newMigrationObject(req, migrationID, requestedBy)
+		if status, msg, err := a.createMigrationCR(r.Context(), obj); err != nil {
+			dashboardMigrationApplyErrorsTotal.Add(1)
+			slog.Error("Migration CR create failed", "migration_id", migrationID, "migration_cr", cr, "request_id", reqID, "error", err)
+			a.appendLog("Error: create Migration " + cr + ": " + err.Error())
+			a.setMigrationResult("error", err.Error())
+			a.endMigration(start)
+			jsonError(w, msg, status)
+			return
+		}
+		a.appendLog(">>> Created Migration " + cr + ", waiting for katamaran-mgr…")
+		go a.watchMigrationCR(ctx, authNamespace, migrationCRName(migrationID), migrationID, start)
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "Migration started", "migration_id": migrationID, "migration_cr": cr})
+		return
+	}
 	go a.runOrchestrator(ctx, a.orch, req, migrationID, reqID)
 
 	writeJSON(w, http.StatusAccepted, map[string]string{"message": "Migration started", "migration_id": migrationID})
@@ -245,24 +336,8 @@ func (a *App) runOrchestrator(ctx context.Context, orch orchestrator.Orchestrato
 		logger = logger.With("request_id", requestID)
 	}
 	start := time.Now()
-	defer func() {
-		a.migrationMutex.Lock()
-		a.isMigrating = false
-		a.migrationCancel = nil
-		outcome := a.lastMigrationResult
-		a.migrationMutex.Unlock()
-		dashboardMigrationsActive.Add(-1)
-		recordMigrationDuration(time.Since(start), outcome)
-	}()
-	defer func() {
-		if rec := recover(); rec != nil {
-			dashboardMigrationWorkerPanicsTotal.Add(1)
-			msg := fmt.Sprintf("migration worker panic: %v", rec)
-			logger.Error("Migration worker panic", "panic", rec, "stack", string(debug.Stack()))
-			a.appendLog("Error: internal migration worker panic")
-			a.setMigrationResult("error", msg)
-		}
-	}()
+	defer a.endMigration(start)
+	defer a.recoverMigrationWorker(logger)
 
 	a.appendLog(">>> Submitting migration via Native orchestrator…")
 	id, err := orch.Apply(ctx, req)
@@ -282,104 +357,188 @@ func (a *App) runOrchestrator(ctx context.Context, orch orchestrator.Orchestrato
 		a.setMigrationResult("error", err.Error())
 		return
 	}
-	var terminal orchestrator.StatusPhase
-	var terminalErr error
-	phaseAt := map[orchestrator.StatusPhase]time.Time{}
-	var lastLoggedPhase orchestrator.StatusPhase
+	rep := newMigrationReporter(a, logger, migrationID, start)
 	for u := range updates {
-		if _, seen := phaseAt[u.Phase]; !seen {
-			phaseAt[u.Phase] = u.When
+		rep.update(string(id), u)
+	}
+	rep.finish()
+}
+
+// endMigration clears the in-flight migration state and records its
+// duration once the worker driving it exits.
+func (a *App) endMigration(start time.Time) {
+	a.migrationMutex.Lock()
+	a.isMigrating = false
+	a.migrationCancel = nil
+	a.migrationCR = ""
+	outcome := a.lastMigrationResult
+	a.publishMigrationStateLocked()
+	a.migrationMutex.Unlock()
+	// Normally already stopped by the reporter; this covers migrations
+	// that failed before any status update arrived.
+	a.finishVerifier("", time.Time{}, time.Time{})
+	dashboardMigrationsActive.Add(-1)
+	recordMigrationDuration(time.Since(start), outcome)
+}
+
+// recoverMigrationWorker turns a panic in a migration worker goroutine
+// into a failed migration instead of crashing the dashboard. Must be
+// deferred directly by the worker.
+func (a *App) recoverMigrationWorker(logger *slog.Logger) {
+	if rec := recover(); rec != nil {
+		dashboardMigrationWorkerPanicsTotal.Add(1)
+		msg := fmt.Sprintf("migration worker panic: %v", rec)
+		logger.Error("Migration worker panic", "panic", rec, "stack", string(debug.Stack()))
+		a.appendLog("Error: internal migration worker panic")
+		a.setMigrationResult("error", msg)
+	}
+}
+
+// migrationReporter turns a stream of StatusUpdates into dashboard log
+// lines, progress snapshots, /api/events progress events, and the final
+// migration result. Shared by the in-process orchestrator path and the
+// Migration CR watcher so both render identically.
+type migrationReporter struct {
+	a           *App
+	logger      *slog.Logger
+	migrationID string
+	start       time.Time
+
+	phaseAt         map[orchestrator.StatusPhase]time.Time
+	lastLoggedPhase orchestrator.StatusPhase
+	terminal        orchestrator.StatusPhase
+	terminalErr     error
+
+	// vmStoppedAt / vmResumedAt are the latest cutover bounds reported,
+	// handed to the verifier for gap correlation.
+	vmStoppedAt time.Time
+	vmResumedAt time.Time
+}
+
+func newMigrationReporter(a *App, logger *slog.Logger, migrationID string, start time.Time) *migrationReporter {
+	return &migrationReporter{
+		a:           a,
+		logger:      logger,
+		migrationID: migrationID,
+		start:       start,
+		phaseAt:     map[orchestrator.StatusPhase]time.Time{},
+	}
+}
+
+// update records one StatusUpdate. orchestratorID is the ID the backend
+// assigned to the migration, logged for correlation with its Jobs.
+func (p *migrationReporter) update(orchestratorID string, u orchestrator.StatusUpdate) {
+	a := p.a
+	if _, seen := p.phaseAt[u.Phase]; !seen {
+		p.phaseAt[u.Phase] = u.When
+	}
+	if !u.VMStoppedAt.IsZero() {
+		p.vmStoppedAt = u.VMStoppedAt
+	}
+	if !u.VMResumedAt.IsZero() {
+		p.vmResumedAt = u.VMResumedAt
+	}
+	if u.Phase != p.lastLoggedPhase || u.Phase.IsTerminal() || u.RAMTotal > 0 || u.Error != nil {
+		attrs := []any{"orchestrator_id", orchestratorID, "phase", u.Phase}
+		if u.Message != "" {
+			attrs = append(attrs, "message", u.Message)
 		}
-		if u.Phase != lastLoggedPhase || u.Phase.IsTerminal() || u.RAMTotal > 0 || u.Error != nil {
-			attrs := []any{"orchestrator_id", string(id), "phase", u.Phase}
-			if u.Message != "" {
-				attrs = append(attrs, "message", u.Message)
-			}
-			if u.RAMTotal > 0 {
-				attrs = append(attrs, "ram_transferred", u.RAMTransferred, "ram_total", u.RAMTotal)
-			}
-			if u.DowntimeMS > 0 {
-				attrs = append(attrs, "downtime_ms", u.DowntimeMS)
-			}
-			if u.Error != nil {
-				attrs = append(attrs, "error", u.Error)
-				logger.Warn("Migration phase update", attrs...)
-			} else {
-				logger.Info("Migration phase update", attrs...)
-			}
-			lastLoggedPhase = u.Phase
+		if u.RAMTotal > 0 {
+			attrs = append(attrs, "ram_transferred", u.RAMTransferred, "ram_total", u.RAMTotal)
 		}
-		if u.RAMTotal > 0 || u.Phase == orchestrator.PhaseSucceeded {
-			a.migrationMutex.Lock()
-			a.latestProgress = &MigrationProgress{
-				Phase:          string(u.Phase),
-				RAMTransferred: u.RAMTransferred,
-				RAMTotal:       u.RAMTotal,
-				DowntimeMS:     u.DowntimeMS,
-			}
-			a.migrationMutex.Unlock()
+		if u.DowntimeMS > 0 {
+			attrs = append(attrs, "downtime_ms", u.DowntimeMS)
 		}
-		line := ">>> " + string(u.Phase)
-		switch {
-		case u.AppliedDowntimeMS > 0 && u.RAMTotal == 0:
-			// Pre-cutover downtime-limit announcement (separate
-			// StatusUpdate emitted by tailProgress when it sees the
-			// KATAMARAN_DOWNTIME_LIMIT marker). Shows up between
-			// "submitted" and the first transferring%.
-			line += fmt.Sprintf(": downtime limit %dms", u.AppliedDowntimeMS)
+		if u.Error != nil {
+			attrs = append(attrs, "error", u.Error)
+			p.logger.Warn("Migration phase update", attrs...)
+		} else {
+			p.logger.Info("Migration phase update", attrs...)
+		}
+		p.lastLoggedPhase = u.Phase
+	}
+	if u.RAMTotal > 0 || u.Phase == orchestrator.PhaseSucceeded {
+		a.migrationMutex.Lock()
+		a.latestProgress = &MigrationProgress{
+			Phase:          string(u.Phase),
+			RAMTransferred: u.RAMTransferred,
+			RAMTotal:       u.RAMTotal,
+			DowntimeMS:     u.DowntimeMS,
+		}
+		a.migrationMutex.Unlock()
+	}
+	a.events.publish(eventTypeProgress, newProgressEvent(p.migrationID, u))
+	line := ">>> " + string(u.Phase)
+	switch {
+	case u.AppliedDowntimeMS > 0 && u.RAMTotal == 0:
+		// Pre-cutover downtime-limit announcement (separate
+		// StatusUpdate emitted by tailProgress when it sees the
+		// KATAMARAN_DOWNTIME_LIMIT marker). Shows up between
+		// "submitted" and the first transferring%.
+		line += fmt.Sprintf(": downtime limit %dms", u.AppliedDowntimeMS)
+		if u.AutoDowntime {
+			line += fmt.Sprintf(" (auto from %dms RTT)", u.RTTMS)
+		} else {
+			line += " (manual)"
+		}
+	case u.Phase == orchestrator.PhaseSucceeded && u.RAMTotal > 0:
+		line += fmt.Sprintf(": %s transferred", humanBytes(u.RAMTotal))
+		if u.DowntimeMS > 0 {
+			line += fmt.Sprintf(", %dms downtime", u.DowntimeMS)
+		}
+		if u.AppliedDowntimeMS > 0 {
 			if u.AutoDowntime {
-				line += fmt.Sprintf(" (auto from %dms RTT)", u.RTTMS)
+				line += fmt.Sprintf(" (limit %dms, auto)", u.AppliedDowntimeMS)
 			} else {
-				line += " (manual)"
-			}
-		case u.Phase == orchestrator.PhaseSucceeded && u.RAMTotal > 0:
-			line += fmt.Sprintf(": %s transferred", humanBytes(u.RAMTotal))
-			if u.DowntimeMS > 0 {
-				line += fmt.Sprintf(", %dms downtime", u.DowntimeMS)
+				line += fmt.Sprintf(" (limit %dms)", u.AppliedDowntimeMS)
 			}
-			if u.AppliedDowntimeMS > 0 {
-				if u.AutoDowntime {
-					line += fmt.Sprintf(" (limit %dms, auto)", u.AppliedDowntimeMS)
-				} else {
-					line += fmt.Sprintf(" (limit %dms)", u.AppliedDowntimeMS)
-				}
-			}
-			if breakdown := phaseBreakdown(start, phaseAt, u.When); breakdown != "" {
-				line += ", " + breakdown
-			}
-		case u.RAMTotal > 0:
-			pct := int((u.RAMTransferred * 100) / u.RAMTotal)
-			line += fmt.Sprintf(": %d%% (%s / %s)", pct, humanBytes(u.RAMTransferred), humanBytes(u.RAMTotal))
-		case u.Message != "":
-			line += ": " + u.Message
-		}
-		if u.Error != nil {
-			line += ": " + u.Error.Error()
 		}
-		a.appendLog(line)
-		if u.Phase.IsTerminal() {
-			terminal = u.Phase
-			terminalErr = u.Error
+		if breakdown := phaseBreakdown(p.start, p.phaseAt, u.When); breakdown != "" {
+			line += ", " + breakdown
 		}
+	case u.RAMTotal > 0:
+		pct := int((u.RAMTransferred * 100) / u.RAMTotal)
+		line += fmt.Sprintf(": %d%% (%s / %s)", pct, humanBytes(u.RAMTransferred), humanBytes(u.RAMTotal))
+	case u.Message != "":
+		line += ": " + u.Message
+	}
+	if u.Error != nil {
+		line += ": " + u.Error.Error()
+	}
+	a.appendLog(line)
+	if u.Phase.IsTerminal() {
+		p.terminal = u.Phase
+		p.terminalErr = u.Error
 	}
-	elapsed := time.Since(start).Round(time.Millisecond)
-	switch terminal {
+}
+
+// finish records the migration result from the last terminal update. A
+// stream that ended without one counts as a lost watch.
+func (p *migrationReporter) finish() {
+	p.finishLost("watch closed without terminal status")
+}
+
+// finishLost is finish with a caller-supplied error message for the
+// no-terminal-update case.
+func (p *migrationReporter) finishLost(lostMsg string) {
+	elapsed := time.Since(p.start).Round(time.Millisecond)
+	switch p.terminal {
 	case orchestrator.PhaseSucceeded:
-		a.setMigrationResult("success", "")
-		logger.Info("Migration finished", "outcome", "success", "elapsed", elapsed)
+		p.a.setMigrationResult("success", "")
+		p.logger.Info("Migration finished", "outcome", "success", "elapsed", elapsed)
 	case orchestrator.PhaseFailed:
 		msg := "migration failed"
-		if terminalErr != nil {
-			msg = terminalErr.Error()
+		if p.terminalErr != nil {
+			msg = p.terminalErr.Error()
 		}
-		a.setMigrationResult("error", msg)
-		logger.Error("Migration finished", "outcome", "error", "elapsed", elapsed, "error", msg)
+		p.a.setMigrationResult("error", msg)
+		p.logger.Error("Migration finished", "outcome", "error", "elapsed", elapsed, "error", msg)
 	default:
-		msg := "watch closed without terminal status"
 		dashboardMigrationWatchLostTotal.Add(1)
-		a.setMigrationResult("error", msg)
-		logger.Error("Migration finished", "outcome", "error", "elapsed", elapsed, "error", msg)
+		p.a.setMigrationResult("error", lostMsg)
+		p.logger.Error("Migration finished", "outcome", "error", "elapsed", elapsed, "error", lostMsg)
 	}
+	p.a.finishVerifier(p.migrationID, p.vmStoppedAt, p.vmResumedAt)
 }
 
 // phaseBreakdown formats the wall-clock split between phases for the
@@ -418,14 +577,39 @@ func humanBytes(n int64) string {
 // handleMigrateStop processes a request to cancel an ongoing migration.
 func (a *App) handleMigrateStop(w http.ResponseWriter, r *http.Request) {
 	a.migrationMutex.Lock()
-	wasRunning := a.migrationCancel != nil
+	running := a.isMigrating
 	migrationID := a.migrationID
-	if wasRunning {
+	namespace := a.migrationNamespace
+	a.migrationMutex.Unlock()
+
+	// Stopping is authorized as "delete migrations.katamaran.io" in the
+	// running migration's source namespace. Nothing to authorize when
+	// idle: the request is a no-op.
+	if running && !a.authorizeMigrationAction(w, r, "stop", "delete", namespace, "migration_id", migrationID) {
+		return
+	}
+
+	a.migrationMutex.Lock()
+	// Re-check under the lock: the authorized migration may have finished
+	// (and another started) while the SubjectAccessReview was in flight.
+	wasRunning := a.isMigrating && a.migrationID == migrationID
+	cr := a.migrationCR
+	if wasRunning && a.migrationCancel != nil {
 		a.migrationCancel()
 	}
 	a.migrationMutex.Unlock()
+	if wasRunning && cr != "" {
+		// CRD mode: deleting the CR makes katamaran-mgr stop the Jobs
+		// (via its finalizer); the watcher records the outcome once the
+		// CR is gone.
+		if err := a.deleteMigrationCR(r.Context(), cr); err != nil {
+			slog.Error("Migration stop failed", "migration_id", migrationID, "migration_cr", cr, "error", err, "request_id", requestIDFromContext(r.Context()))
+			jsonError(w, "Failed to delete Migration CR", http.StatusBadGateway)
+			return
+		}
+	}
 	if wasRunning {
-		slog.Info("Migration stop requested", "migration_id", migrationID, "remote_addr", r.RemoteAddr, "request_id", requestIDFromContext(r.Context()))
+		slog.Info("Migration stop requested", "migration_id", migrationID, "user", usernameFromContext(r.Context()), "remote_addr", r.RemoteAddr, "request_id", requestIDFromContext(r.Context()))
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"message": "Migration stop requested", "stopped": wasRunning, "migration_id": migrationID})
 }
@@ -451,6 +635,7 @@ func (a *App) setMigrationResult(result, errMsg string) {
 		StartedAt:   a.migrationStart.UTC().Format(time.RFC3339),
 		CompletedAt: now.Format(time.RFC3339),
 		DurationMS:  now.Sub(a.migrationStart).Milliseconds(),
+		RequestedBy: a.migrationRequestedBy,
 	}
 	if a.latestProgress != nil {
 		entry.RAMTransferred = a.latestProgress.RAMTransferred
@@ -458,6 +643,7 @@ func (a *App) setMigrationResult(result, errMsg string) {
 		entry.DowntimeMS = a.latestProgress.DowntimeMS
 	}
 	a.migrationHistory = append(a.migrationHistory, entry)
+	a.events.publish(eventTypeHistory, entry)
 	if len(a.migrationHistory) > maxHistoryEntries {
 		// slices.Delete zeroes the dropped slot before shrinking, freeing the
 		// dropped entry's MigrationID/Error string headers for GC. A bare
@@ -475,6 +661,7 @@ func (a *App) appendLog(msg string) {
 	defer a.migrationMutex.Unlock()
 	a.migrationLogSeq++
 	a.migrationOutput = append(a.migrationOutput, msg)
+	a.events.publish(eventTypeLog, LogEvent{Seq: a.migrationLogSeq, MigrationID: a.migrationID, Line: msg})
 	if len(a.migrationOutput) > maxLogLines {
 		a.migrationOutput = slices.Delete(a.migrationOutput, 0, len(a.migrationOutput)-maxLogLines)
 		if !a.logBufferWrapped {
@@ -0,0 +1,412 @@
+package dashboard
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.Hash.New
+	_ "crypto/sha512" // register SHA-384/512 for crypto.Hash.New
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcHTTPTimeout bounds discovery and JWKS fetches.
+	oidcHTTPTimeout = 10 * time.Second
+
+	// oidcJWKSMinRefresh rate-limits JWKS refetches triggered by tokens
+	// signed with an unknown key ID, so a flood of forged tokens cannot
+	// turn the dashboard into an amplifier against the issuer.
+	oidcJWKSMinRefresh = time.Minute
+
+	// oidcClockSkew is the leeway applied to exp / nbf / iat checks.
+	oidcClockSkew = 30 * time.Second
+
+	// maxOIDCResponseBytes caps discovery and JWKS response bodies.
+	maxOIDCResponseBytes = 1 << 20
+
+	// defaultOIDCPrefix is prepended to OIDC usernames and groups so an
+	// IdP identity never collides with a Kubernetes user, group or
+	// ServiceAccount.
+	defaultOIDCPrefix = "oidc:"
+
+	// reservedIdentityPrefix marks the Kubernetes-reserved users and
+	// groups (system:masters, system:serviceaccount:...) no IdP identity
+	// may take, whatever the configured prefixes.
+	reservedIdentityPrefix = "system:"
+)
+
+// oidcAuthenticator validates OIDC ID tokens presented as bearer tokens,
+// mirroring the kube-apiserver's --oidc-* flags: the token must be signed
+// by a key from the issuer's JWKS, carry the configured issuer, and list
+// the client ID in its audience. The username and groups come from the
+// configured claims, with the configured prefixes prepended like
+// --oidc-username-prefix / --oidc-groups-prefix.
+type oidcAuthenticator struct {
+	issuer         string
+	clientID       string
+	usernameClaim  string
+	usernamePrefix string
+	groupsClaim    string
+	groupsPrefix   string
+	httpClient     *http.Client
+	now            func() time.Time
+
+	mu          sync.Mutex
+	jwksURI     string
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+
+	cache tokenCache
+}
+
+func newOIDCAuthenticator(issuer, clientID, usernameClaim, usernamePrefix, groupsClaim, groupsPrefix string) *oidcAuthenticator {
+	return &oidcAuthenticator{
+		issuer:         strings.TrimSuffix(issuer, "/"),
+		clientID:       clientID,
+		usernameClaim:  usernameClaim,
+		usernamePrefix: usernamePrefix,
+		groupsClaim:    groupsClaim,
+		groupsPrefix:   groupsPrefix,
+		httpClient:     &http.Client{Timeout: oidcHTTPTimeout},
+	}
+}
+
+func (o *oidcAuthenticator) clock() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (o *oidcAuthenticator) authenticate(ctx context.Context, token string) (*userInfo, error) {
+	if u := o.cache.get(token); u != nil {
+		return u, nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", errUnauthenticated)
+	}
+	var hdr jwtHeader
+	if err := decodeJWTSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errUnauthenticated, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding: %v", errUnauthenticated, err)
+	}
+	key, err := o.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(hdr.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", errUnauthenticated, err)
+	}
+	u, exp, err := o.validateClaims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	ttl := min(authCacheTTL, exp.Sub(o.clock()))
+	if ttl > 0 {
+		o.cache.put(token, u, ttl)
+	}
+	return u, nil
+}
+
+func (o *oidcAuthenticator) validateClaims(claims map[string]any) (*userInfo, time.Time, error) {
+	now := o.clock()
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.issuer {
+		return nil, time.Time{}, fmt.Errorf("issuer %q does not match %q", iss, o.issuer)
+	}
+	if !audienceContains(claims["aud"], o.clientID) {
+		return nil, time.Time{}, fmt.Errorf("audience does not include client ID %q", o.clientID)
+	}
+	expF, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, time.Time{}, errors.New("missing exp claim")
+	}
+	exp := time.Unix(int64(expF), 0)
+	if now.After(exp.Add(oidcClockSkew)) {
+		return nil, time.Time{}, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, time.Time{}, errors.New("token not yet valid")
+	}
+	username, _ := claims[o.usernameClaim].(string)
+	if username == "" {
+		return nil, time.Time{}, fmt.Errorf("missing or non-string %q claim", o.usernameClaim)
+	}
+	// Same rule as kube-apiserver: an email username is only trusted when
+	// the issuer has not explicitly marked it unverified.
+	if o.usernameClaim == "email" {
+		if v, present := claims["email_verified"]; present && v != true {
+			return nil, time.Time{}, errors.New("email not verified")
+		}
+	}
+	u := &userInfo{Username: o.usernamePrefix + username}
+	if strings.HasPrefix(u.Username, reservedIdentityPrefix) {
+		return nil, time.Time{}, fmt.Errorf("username %q is reserved", u.Username)
+	}
+	if sub, ok := claims["sub"].(string); ok {
+		u.UID = sub
+	}
+	if o.groupsClaim != "" {
+		var groups []string
+		switch g := claims[o.groupsClaim].(type) {
+		case string:
+			groups = []string{g}
+		case []any:
+			for _, v := range g {
+				if s, ok := v.(string); ok {
+					groups = append(groups, s)
+				}
+			}
+		}
+		for _, g := range groups {
+			g = o.groupsPrefix + g
+			if strings.HasPrefix(g, reservedIdentityPrefix) {
+				return nil, time.Time{}, fmt.Errorf("group %q is reserved", g)
+			}
+			u.Groups = append(u.Groups, g)
+		}
+	}
+	return u, exp, nil
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the issuer's public key for kid, fetching discovery and
+// JWKS on first use and refetching JWKS (rate-limited) on a cache miss
+// so issuer key rotation is picked up without a restart.
+func (o *oidcAuthenticator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.lookupKeyLocked(kid); ok {
+		return k, nil
+	}
+	if !o.lastRefresh.IsZero() && o.clock().Sub(o.lastRefresh) < oidcJWKSMinRefresh {
+		return nil, fmt.Errorf("%w: unknown signing key %q", errUnauthenticated, kid)
+	}
+	if err := o.refreshLocked(ctx); err != nil {
+		return nil, err
+	}
+	if k, ok := o.lookupKeyLocked(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", errUnauthenticated, kid)
+}
+
+// lookupKeyLocked finds kid in the key set. Tokens without a kid are
+// accepted only when the issuer publishes exactly one key.
+func (o *oidcAuthenticator) lookupKeyLocked(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, k := range o.keys {
+			return k, true
+		}
+	}
+	k, ok := o.keys[kid]
+	return k, ok
+}
+
+func (o *oidcAuthenticator) refreshLocked(ctx context.Context) error {
+	o.lastRefresh = o.clock()
+	if o.jwksURI == "" {
+		var disc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.getJSON(ctx, o.issuer+"/.well-known/openid-configuration", &disc); err != nil {
+			return fmt.Errorf("oidc discovery: %w", err)
+		}
+		if strings.TrimSuffix(disc.Issuer, "/") != o.issuer {
+			return fmt.Errorf("oidc discovery: issuer %q does not match configured %q", disc.Issuer, o.issuer)
+		}
+		if disc.JWKSURI == "" {
+			return errors.New("oidc discovery: jwks_uri missing")
+		}
+		o.jwksURI = disc.JWKSURI
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, o.jwksURI, &set); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we do not support rather than failing the
+			// whole set; issuers commonly publish mixed key sets.
+			continue
+		}
+		keys[jwk.Kid] = k
+	}
+	if len(keys) == 0 {
+		return errors.New("oidc jwks: no usable signing keys")
+	}
+	o.keys = keys
+	return nil
+}
+
+func (o *oidcAuthenticator) getJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, oidcHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseBytes)).Decode(v)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		if !eInt.IsInt64() || eInt.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifyJWTSignature checks sig over signed with key using the JWS
+// algorithm alg. Only asymmetric algorithms are accepted: "none" and the
+// HMAC family would let anyone who knows the client ID mint tokens.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	}
+	return nil
+}
@@ -23,6 +23,9 @@ type fakeOrchestrator struct {
 	//   "fail"    — emit submitted + failed (with err), then close.
 	//   "slow"    — emit submitted, hold the channel open until Stop is
 	//               called or the test cleanup cancels the run.
+	//   "cutover" — like "success", but paced over ~300ms with VM
+	//               STOP/RESUME timestamps on the cutover and succeeded
+	//               updates.
 	behaviour string
 
 	// per-run state
@@ -82,6 +85,13 @@ func (f *fakeOrchestrator) Apply(ctx context.Context, req orchestrator.Request)
 		case "success":
 			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseTransferring, When: time.Now()}
 			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseSucceeded, When: time.Now()}
+		case "cutover":
+			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseTransferring, When: time.Now()}
+			time.Sleep(150 * time.Millisecond)
+			stopped := time.Now()
+			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseCutover, When: stopped, VMStoppedAt: stopped}
+			time.Sleep(150 * time.Millisecond)
+			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseSucceeded, When: time.Now(), VMStoppedAt: stopped, VMResumedAt: stopped.Add(30 * time.Millisecond)}
 		case "fail":
 			run.updates <- orchestrator.StatusUpdate{ID: id, Phase: orchestrator.PhaseFailed, When: time.Now(), Error: errors.New("synthetic failure")}
 		case "slow":
@@ -18,6 +18,8 @@ import (
 	"strings"
 	"time"
 
+	"k8s.io/client-go/kubernetes"
+
 	"github.com/maci0/katamaran/internal/buildinfo"
 	"github.com/maci0/katamaran/internal/logging"
 	"github.com/maci0/katamaran/internal/orchestrator"
@@ -73,6 +75,27 @@ Flags:
   --enable-debug         Enable /debug/pprof/ and /debug/vars endpoints
   --log-format string    Log output format: 'text' or 'json' (default "text")
   --log-level string     Log level: 'debug', 'info', 'warn', or 'error' (default "info")
+  --migration-mode string
+                         How migrations run: 'crd' creates a Migration CR for katamaran-mgr to
+                         reconcile and watches its status; 'direct' runs the orchestrator inside
+                         the dashboard (required for node-mode requests) (default "crd")
+  --job-templates string ConfigMap ([namespace/]name, namespace defaulting to kube-system) with Job
+                         template overrides, patches and policy for --migration-mode direct; with
+                         'crd', set it on katamaran-mgr instead (default "")
+
+Authentication:
+  --auth-mode string             API authentication: 'none', 'token' (bearer tokens checked with
+                                 Kubernetes TokenReview), or 'oidc' (OIDC ID tokens) (default "none").
+                                 With token/oidc, migrate and stop are authorized by SubjectAccessReview
+                                 on migrations.katamaran.io in the source pod's namespace
+  --anonymous-read               Allow unauthenticated GET/HEAD access to read-only endpoints when
+                                 --auth-mode is token or oidc (default true; set =false to require a token)
+  --oidc-issuer-url string       OIDC issuer URL (https; required with --auth-mode oidc)
+  --oidc-client-id string        OIDC client ID expected in the token audience (required with --auth-mode oidc)
+  --oidc-username-claim string   ID token claim used as the username (default "email")
+  --oidc-groups-claim string     ID token claim holding group names (default "groups")
+  --oidc-username-prefix string  Prefix for OIDC usernames in authorization checks (default "oidc:")
+  --oidc-groups-prefix string    Prefix for OIDC groups in authorization checks (default "oidc:")
 
 Other:
   -v, --version          Show version and exit
@@ -92,6 +115,12 @@ Examples:
 
   # Custom address and text logging
   katamaran-dashboard --addr 0.0.0.0:9090 --log-format text
+
+  # Drive the orchestrator in-process (no katamaran-mgr required)
+  katamaran-dashboard --migration-mode direct
+
+  # Require Kubernetes bearer tokens for every API call
+  katamaran-dashboard --auth-mode token --anonymous-read=false
 `)
 }
 
@@ -114,6 +143,16 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	enableDebug := fs.Bool("enable-debug", false, "Enable /debug/pprof/ and /debug/vars endpoints")
 	logLevel := fs.String("log-level", "info", "Log level: 'debug', 'info', 'warn', or 'error'")
 	logFormat := fs.String("log-format", "text", "Log output format: 'text' or 'json'")
+	migrationMode := fs.String("migration-mode", migrationModeCRD, "How migrations run: 'crd' or 'direct'")
+	jobTemplates := fs.String("job-templates", "", "ConfigMap ([namespace/]name) with Job template overrides, patches and policy (--migration-mode direct)")
+	authMode := fs.String("auth-mode", authModeNone, "API authentication: 'none', 'token', or 'oidc'")
+	anonymousRead := fs.Bool("anonymous-read", true, "Allow unauthenticated GET/HEAD access to read-only endpoints when auth is enabled")
+	oidcIssuerURL := fs.String("oidc-issuer-url", "", "OIDC issuer URL (required with --auth-mode oidc)")
+	oidcClientID := fs.String("oidc-client-id", "", "OIDC client ID expected in the token audience (required with --auth-mode oidc)")
+	oidcUsernameClaim := fs.String("oidc-username-claim", "email", "ID token claim used as the username")
+	oidcGroupsClaim := fs.String("oidc-groups-claim", "groups", "ID token claim holding group names")
+	oidcUsernamePrefix := fs.String("oidc-username-prefix", defaultOIDCPrefix, "Prefix for OIDC usernames in authorization checks")
+	oidcGroupsPrefix := fs.String("oidc-groups-prefix", defaultOIDCPrefix, "Prefix for OIDC groups in authorization checks")
 	showVersion := fs.Bool("version", false, "Show version and exit")
 	showVersionShort := fs.Bool("v", false, "")
 	helpFlag := fs.Bool("help", false, "")
@@ -149,6 +188,38 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	// Normalize enum flags for case-insensitive matching.
 	*logFormat = strings.ToLower(*logFormat)
 	*logLevel = strings.ToLower(*logLevel)
+	*authMode = strings.ToLower(*authMode)
+	*migrationMode = strings.ToLower(*migrationMode)
+
+	if *migrationMode != migrationModeCRD && *migrationMode != migrationModeDirect {
+		fmt.Fprintf(stderr, "Error: invalid --migration-mode %q (expected crd or direct)\n\n", *migrationMode)
+		printUsage(stderr)
+		return 2
+	}
+	if *jobTemplates != "" && *migrationMode != migrationModeDirect {
+		fmt.Fprintf(stderr, "Error: --job-templates requires --migration-mode direct (katamaran-mgr renders the Jobs in crd mode)\n\n")
+		printUsage(stderr)
+		return 2
+	}
+
+	switch *authMode {
+	case authModeNone, authModeToken:
+	case authModeOIDC:
+		if !strings.HasPrefix(*oidcIssuerURL, "https://") {
+			fmt.Fprintf(stderr, "Error: --auth-mode oidc requires an https:// --oidc-issuer-url\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		if *oidcClientID == "" || *oidcUsernameClaim == "" {
+			fmt.Fprintf(stderr, "Error: --auth-mode oidc requires --oidc-client-id and a non-empty --oidc-username-claim\n\n")
+			printUsage(stderr)
+			return 2
+		}
+	default:
+		fmt.Fprintf(stderr, "Error: invalid --auth-mode %q (expected none, token, or oidc)\n\n", *authMode)
+		printUsage(stderr)
+		return 2
+	}
 
 	if err := logging.SetupLogger(stderr, *logFormat, *logLevel, "dashboard"); err != nil {
 		fmt.Fprintf(stderr, "Error: %v\n\n", err)
@@ -163,42 +234,87 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 		return 2
 	}
 	if allowedImage == "" {
-		// /api/migrate has no built-in authentication; without an image
-		// allowlist any caller able to reach it can launch arbitrary
-		// privileged container images on cluster nodes via the rendered
-		// source/dest Jobs. Warn loudly so operators set the allowlist
-		// (or deploy the dashboard behind external auth).
+		// Without an image allowlist any caller permitted to reach
+		// /api/migrate (everyone, under --auth-mode=none) can launch
+		// arbitrary privileged container images on cluster nodes via the
+		// rendered source/dest Jobs. Warn loudly so operators set the
+		// allowlist.
 		slog.Warn("KATAMARAN_MIGRATION_IMAGE is unset: any image submitted to /api/migrate will be accepted; set this env var to pin migrations to a single trusted image")
 	}
 
-	app := &App{startTime: time.Now(), allowedImage: allowedImage}
+	app := &App{startTime: time.Now(), lifecycle: ctx, allowedImage: allowedImage, migrationMode: *migrationMode}
 
-	// The dashboard needs a Kubernetes connection: try in-cluster
-	// service-account creds first, then a kubeconfig-loaded client (handy
-	// when running on a developer laptop).
-	if nat, err := orchestrator.New(); err == nil {
-		app.orch = nat
-		if disc, derr := orchestrator.NewDiscoverer(); derr == nil {
-			app.discoverer = disc
+	if *authMode == authModeNone {
+		slog.Warn("--auth-mode=none: API requests are unauthenticated and migrate/stop are not authorized; deploy behind external auth or set --auth-mode token|oidc")
+	} else {
+		// Both modes authorize through SubjectAccessReview, so the
+		// Kubernetes API is required even for OIDC.
+		cfg, err := loadRestConfig()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: --auth-mode %s requires Kubernetes API access: %v\n", *authMode, err)
+			return 1
+		}
+		kube, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: --auth-mode %s: kubernetes client: %v\n", *authMode, err)
+			return 1
+		}
+		app.auth = &authConfig{
+			mode:          *authMode,
+			authz:         &subjectAccessReviewer{client: kube},
+			anonymousRead: *anonymousRead,
+		}
+		if *authMode == authModeToken {
+			app.auth.authn = newTokenReviewAuthenticator(kube)
 		} else {
-			slog.Warn("Discoverer unavailable (in-cluster): /api/pods and /api/nodes will return 503", "error", derr)
+			app.auth.authn = newOIDCAuthenticator(*oidcIssuerURL, *oidcClientID, *oidcUsernameClaim, *oidcUsernamePrefix, *oidcGroupsClaim, *oidcGroupsPrefix)
 		}
-		slog.Info("Migration: orchestrator using in-cluster client-go")
-	} else if nat, err2 := orchestrator.NewFromKubeconfig("", ""); err2 == nil {
-		app.orch = nat
-		if disc, derr := orchestrator.NewDiscovererFromKubeconfig("", ""); derr == nil {
-			app.discoverer = disc
+		slog.Info("API authentication enabled", "auth_mode", *authMode, "anonymous_read", *anonymousRead)
+	}
+
+	if *migrationMode == migrationModeCRD {
+		// Migrations are owned by katamaran-mgr; the dashboard only needs
+		// to create and watch Migration CRs and back the pod pickers.
+		if err := app.wireMigrationCRs(ctx); err != nil {
+			slog.Warn("Kubernetes API unreachable: migration handlers will return 503 until in-cluster config or KUBECONFIG is available", "error", err)
 		} else {
-			slog.Warn("Discoverer unavailable (kubeconfig): /api/pods and /api/nodes will return 503", "error", derr)
+			slog.Info("Migration: submitting Migration CRs to katamaran-mgr")
 		}
-		slog.Info("Migration: orchestrator using kubeconfig", "in_cluster_err", err)
 	} else {
-		// No Kubernetes API reachable. Keep the dashboard up so /healthz,
-		// the static UI, and the loadgen endpoints still work; migration
-		// + discovery handlers return 503 until app.orch is wired. Real
-		// deployments will hit one of the branches above; this is the
-		// fallback for unit tests + a developer-laptop dry run.
-		slog.Warn("Kubernetes API unreachable: migration handlers will return 503 until in-cluster config or KUBECONFIG is available", "in_cluster_err", err, "kubeconfig_err", err2)
+		// The dashboard needs a Kubernetes connection: try in-cluster
+		// service-account creds first, then a kubeconfig-loaded client (handy
+		// when running on a developer laptop).
+		if nat, err := orchestrator.New(); err == nil {
+			app.orch = nat
+			if disc, derr := orchestrator.NewDiscoverer(); derr == nil {
+				app.discoverer = disc
+			} else {
+				slog.Warn("Discoverer unavailable (in-cluster): /api/pods and /api/nodes will return 503", "error", derr)
+			}
+			slog.Info("Migration: orchestrator using in-cluster client-go")
+		} else if nat, err2 := orchestrator.NewFromKubeconfig("", ""); err2 == nil {
+			app.orch = nat
+			if disc, derr := orchestrator.NewDiscovererFromKubeconfig("", ""); derr == nil {
+				app.discoverer = disc
+			} else {
+				slog.Warn("Discoverer unavailable (kubeconfig): /api/pods and /api/nodes will return 503", "error", derr)
+			}
+			slog.Info("Migration: orchestrator using kubeconfig", "in_cluster_err", err)
+		} else {
+			// No Kubernetes API reachable. Keep the dashboard up so /healthz,
+			// the static UI, and the loadgen endpoints still work; migration
+			// + discovery handlers return 503 until app.orch is wired. Real
+			// deployments will hit one of the branches above; this is the
+			// fallback for unit tests + a developer-laptop dry run.
+			slog.Warn("Kubernetes API unreachable: migration handlers will return 503 until in-cluster config or KUBECONFIG is available", "in_cluster_err", err, "kubeconfig_err", err2)
+		}
+		if app.orch != nil && *jobTemplates != "" {
+			if err := orchestrator.SetJobTemplatesFromConfigMap(ctx, app.orch, *jobTemplates); err != nil {
+				fmt.Fprintf(stderr, "Error: --job-templates: %v\n", err)
+				return 1
+			}
+			slog.Info("Migration: using customised Job templates", "configmap", *jobTemplates, "job_namespace", orchestrator.JobNamespace(app.orch))
+		}
 	}
 
 	publishExpvars(app)
@@ -207,13 +323,14 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 
 	srv := &http.Server{
 		Addr:              *addr,
-		Handler:           requestLogger(recoverMiddleware(securityHeaders(csrfCheck(mux)))),
+		Handler:           requestLogger(recoverMiddleware(securityHeaders(csrfCheck(app.authMiddleware(mux))))),
 		ReadHeaderTimeout: httpReadHeaderTimeout,
 		ReadTimeout:       httpReadTimeout,
 		WriteTimeout:      httpWriteTimeout,
 		IdleTimeout:       httpIdleTimeout,
 		MaxHeaderBytes:    maxHeaderBytes,
 	}
+	srv.RegisterOnShutdown(app.events.close)
 
 	go func() {
 		<-ctx.Done()
@@ -250,6 +367,7 @@ func (a *App) newMux(enableDebug bool) *http.ServeMux {
 	mux.HandleFunc("GET /api/pods", a.handleListPods)
 	mux.HandleFunc("GET /api/nodes", a.handleListNodes)
 	mux.HandleFunc("GET /api/status", a.handleStatus)
+	mux.HandleFunc("GET /api/events", a.handleEvents)
 	mux.HandleFunc("GET /api/history", a.handleHistory)
 	mux.HandleFunc("POST /api/ping", a.handlePingStart)
 	mux.HandleFunc("POST /api/ping/stop", a.handleLoadgenStop)
@@ -268,6 +386,7 @@ var apiAllowedMethods = map[string]string{
 	"/api/migrate":      http.MethodPost,
 	"/api/migrate/stop": http.MethodPost,
 	"/api/status":       http.MethodGet + ", " + http.MethodHead,
+	"/api/events":       http.MethodGet,
 	"/api/pods":         http.MethodGet + ", " + http.MethodHead,
 	"/api/nodes":        http.MethodGet + ", " + http.MethodHead,
 	"/api/history":      http.MethodGet + ", " + http.MethodHead,
@@ -293,7 +412,7 @@ func handleAPIFallback(w http.ResponseWriter, r *http.Request) {
 // allocate a fresh []byte conversion on the hot path.
 var (
 	probeOKBody       = []byte("ok\n")
-	probeNotReadyBody = []byte("orchestrator not wired\n")
+	probeNotReadyBody = []byte("migration backend not wired\n")
 )
 
 // handleHealthz is a lightweight health check endpoint for Kubernetes probes.
@@ -307,17 +426,18 @@ func handleHealthz(w http.ResponseWriter, r *http.Request) {
 
 // handleReadyz is a readiness probe for Kubernetes. Unlike the lightweight
 // /healthz liveness check, it verifies the dashboard can actually serve
-// migration requests by confirming the orchestrator is wired.
+// migration requests by confirming its migration backend (the Migration
+// CR client in CRD mode, the orchestrator otherwise) is wired.
 func (a *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Cache-Control", "no-store")
-	if a.orch != nil {
+	if (a.crdMode() && a.crd != nil) || (!a.crdMode() && a.orch != nil) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(probeOKBody)
 		return
 	}
 	dashboardReadinessFailuresTotal.Add(1)
-	slog.Debug("Readiness check failed: orchestrator not wired")
+	slog.Debug("Readiness check failed: migration backend not wired", "migration_mode", a.migrationMode)
 	w.WriteHeader(http.StatusServiceUnavailable)
 	_, _ = w.Write(probeNotReadyBody)
 }
@@ -423,6 +543,7 @@ func (a *App) handleStatus(w http.ResponseWriter, r *http.Request) {
 	logsNext := a.migrationLogSeq
 	status := a.isMigrating
 	migrationID := a.migrationID
+	migrationCR := a.migrationCR
 	migrationStart := a.migrationStart
 	lastResult := a.lastMigrationResult
 	lastError := a.lastMigrationError
@@ -466,6 +587,7 @@ func (a *App) handleStatus(w http.ResponseWriter, r *http.Request) {
 		UptimeSeconds:           int64(time.Since(a.startTime).Seconds()),
 		Migrating:               status,
 		MigrationID:             migrationID,
+		MigrationCR:             migrationCR,
 		MigrationElapsedSeconds: elapsedSeconds,
 		MigrationProgress:       progress,
 		LastMigrationResult:     lastResult,
@@ -132,6 +132,30 @@ func TestRun_InvalidLogFormat(t *testing.T) {
 	}
 }
 
+func TestRun_InvalidMigrationMode(t *testing.T) {
+	t.Parallel()
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{"--migration-mode", "job"}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --migration-mode") {
+		t.Fatalf("expected migration mode error, got: %s", stderr.String())
+	}
+}
+
+func TestRun_JobTemplatesRequiresDirectMode(t *testing.T) {
+	t.Parallel()
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), []string{"--job-templates", "katamaran-job-templates"}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "--job-templates requires --migration-mode direct") {
+		t.Fatalf("expected job templates error, got: %s", stderr.String())
+	}
+}
+
 func TestRun_CaseInsensitiveLogFlags(t *testing.T) {
 	// Not parallel: SetupLogger calls slog.SetDefault.
 	origLogger := slog.Default()
@@ -417,6 +441,8 @@ func TestMux_MethodNotAllowed(t *testing.T) {
 		path   string
 	}{
 		{http.MethodPost, "/api/status"},
+		{http.MethodPost, "/api/events"},
+		{http.MethodHead, "/api/events"},
 		{http.MethodGet, "/api/ping"},
 		{http.MethodGet, "/api/httpgen"},
 		{http.MethodGet, "/api/migrate"},
@@ -5,7 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"k8s.io/client-go/dynamic"
+
 	"github.com/maci0/katamaran/internal/orchestrator"
+	"github.com/maci0/katamaran/internal/verify"
 )
 
 type PingData struct {
@@ -32,6 +35,12 @@ type MigrationHistoryEntry struct {
 	RAMTransferred int64  `json:"ram_transferred"`
 	RAMTotal       int64  `json:"ram_total"`
 	DowntimeMS     int64  `json:"downtime_ms"`
+	RequestedBy    string `json:"requested_by,omitempty"`
+
+	// Verification is the synthetic workload verifier's report for
+	// migrations started with verify=true. It is attached a few seconds
+	// after the entry is recorded, once the verifier's drain completes.
+	Verification *verify.Report `json:"verification,omitempty"`
 }
 
 const maxHistoryEntries = 100
@@ -41,6 +50,7 @@ type StatusResponse struct {
 	UptimeSeconds           int64                   `json:"uptime_seconds"`
 	Migrating               bool                    `json:"migrating"`
 	MigrationID             string                  `json:"migration_id,omitempty"`
+	MigrationCR             string                  `json:"migration_cr,omitempty"`
 	MigrationElapsedSeconds int64                   `json:"migration_elapsed_seconds,omitempty"`
 	MigrationProgress       *MigrationProgress      `json:"migration_progress,omitempty"`
 	LastMigrationResult     string                  `json:"last_migration_result,omitempty"`
@@ -62,18 +72,41 @@ type StatusResponse struct {
 type App struct {
 	allowedImage string
 
+	// auth is the authentication + authorization policy applied to API
+	// requests. Nil disables auth (--auth-mode=none): every caller is
+	// treated as system:anonymous and all actions are allowed, though
+	// migrate/stop are still audit-logged.
+	auth *authConfig
+
 	// orch is the orchestrator handleMigrate submits to. Set by the
 	// production main() to New() (or kubeconfig fallback). Tests
 	// inject a fakeOrchestrator. handleMigrate fails 503 if nil.
 	// readyz also returns 503 until orch is set.
 	orch orchestrator.Orchestrator
 
+	// migrationMode selects how handleMigrate starts migrations:
+	// migrationModeCRD creates a Migration CR through crd for
+	// katamaran-mgr to reconcile; anything else (including the zero
+	// value tests use) drives orch in-process.
+	migrationMode string
+
+	// crd is the dynamic client used to create, watch, and delete
+	// Migration CRs in CRD mode. Nil there means the Kubernetes API is
+	// unreachable; handleMigrate fails 503 and readyz reports not ready.
+	crd dynamic.Interface
+
 	// discoverer backs pod/node dropdowns and pod-mode request resolution.
 	// Nil means pod-picker endpoints and pod-mode request resolution are unavailable.
 	discoverer orchestrator.Discoverer
 
 	startTime time.Time
 
+	// lifecycle is the context Run was started with. Migration CR
+	// watchers run under it so they stop on shutdown instead of racing
+	// the process exit; the CR survives and is reattached on restart.
+	// Nil (tests) falls back to context.Background().
+	lifecycle context.Context
+
 	migrationOutput  []string
 	migrationLogSeq  int64
 	migrationMutex   sync.Mutex
@@ -83,6 +116,25 @@ type App struct {
 	migrationStart   time.Time // when the current migration began
 	migrationCancel  context.CancelFunc
 
+	// migrationNamespace is the source pod namespace of the current
+	// migration (empty for legacy node-mode requests); stop requests are
+	// authorized against it. migrationRequestedBy is the initiating
+	// user, recorded on the history entry.
+	migrationNamespace   string
+	migrationRequestedBy string
+
+	// migrationCR is the "namespace/name" of the Migration CR backing the
+	// current migration in CRD mode; stop requests delete it.
+	migrationCR string
+
+	// verifier is the synthetic workload verifier of the current
+	// migration, nil when it was started without verify=true or once
+	// the migration ended. verifyUDPPort / verifyTCPPort override the
+	// echo ports it targets (zero uses the verify package defaults).
+	verifier      *verifierRun
+	verifyUDPPort int
+	verifyTCPPort int
+
 	lastMigrationResult string // "success", "error", or "" (no migration run yet)
 	lastMigrationError  string // error message from the last failed migration
 
@@ -105,4 +157,18 @@ type App struct {
 	loadgenRunning bool
 	loadgenType    string // "ping" or "http"; empty when not running
 	loadgenCancel  context.CancelFunc
+
+	// events fans log, progress, ping, history, and state deltas out to
+	// /api/events subscribers. Publishing is non-blocking, so it is safe
+	// to call with migrationMutex or loadgenMutex held.
+	events eventHub
+}
+
+// lifecycleContext returns the server lifetime context, or
+// context.Background() for an App built without Run.
+func (a *App) lifecycleContext() context.Context {
+	if a.lifecycle != nil {
+		return a.lifecycle
+	}
+	return context.Background()
 }
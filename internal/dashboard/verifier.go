@@ -0,0 +1,148 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/maci0/katamaran/internal/verify"
+)
+
+// verifierRun is the synthetic workload verifier riding along with the
+// current migration (form field verify=true). It streams UDP and TCP
+// echo traffic at the workload from the moment the migration is claimed
+// until the migration ends; the resulting report is attached to the
+// migration's history entry.
+type verifierRun struct {
+	migrationID string
+	target      string
+	cancel      context.CancelFunc
+	done        chan *verify.Report // receives the report, or nil if Run failed
+}
+
+// resolveVerifyTarget picks the address the verifier probes: the explicit
+// verify_target form value (SSRF-checked like load generator targets),
+// else the source pod's IP in pod mode, else the vm_ip form field. The
+// workload must be running `katamaran-verify serve`.
+func (a *App) resolveVerifyTarget(ctx context.Context, r *http.Request, podMode bool) (string, error) {
+	if t := r.PostFormValue("verify_target"); t != "" {
+		ip, ok := resolvedTargetIP(t)
+		if !ok {
+			return "", errors.New("verify_target must resolve to a non-loopback, non-link-local address")
+		}
+		return ip, nil
+	}
+	if !podMode {
+		return r.PostFormValue("vm_ip"), nil
+	}
+	ns, name := r.PostFormValue("source_pod_namespace"), r.PostFormValue("source_pod_name")
+	pods, err := a.discoverer.ListKataPods(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list kata pods: %w", err)
+	}
+	for _, p := range pods {
+		if p.Namespace == ns && p.Name == name {
+			if p.PodIP == "" {
+				return "", errors.New("source pod has no IP yet")
+			}
+			return p.PodIP, nil
+		}
+	}
+	return "", errors.New("source pod not found among kata pods")
+}
+
+// startVerifierLocked launches the verifier for migrationID. Caller must
+// hold migrationMutex.
+func (a *App) startVerifierLocked(migrationID, target string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	v := &verifierRun{migrationID: migrationID, target: target, cancel: cancel, done: make(chan *verify.Report, 1)}
+	a.verifier = v
+	cfg := verify.Config{Target: target, UDPPort: a.verifyUDPPort, TCPPort: a.verifyTCPPort}
+	go func() {
+		var rep *verify.Report
+		defer func() {
+			if rec := recover(); rec != nil {
+				slog.Error("Verifier panic", "migration_id", migrationID, "panic", rec, "stack", string(debug.Stack()))
+				rep = nil
+			}
+			v.done <- rep
+		}()
+		var err error
+		rep, err = verify.Run(ctx, cfg)
+		if err != nil {
+			slog.Error("Verifier failed to start", "migration_id", migrationID, "target", target, "error", err)
+		}
+	}()
+}
+
+// finishVerifier ends the send phase of migrationID's verifier, if one is
+// running, and attaches its report (correlated with the cutover window
+// when stoppedAt is known) to the history entry once the drain completes.
+// Pass an empty migrationID to stop whichever verifier is running.
+func (a *App) finishVerifier(migrationID string, stoppedAt, resumedAt time.Time) {
+	a.migrationMutex.Lock()
+	v := a.verifier
+	if v == nil || (migrationID != "" && v.migrationID != migrationID) {
+		a.migrationMutex.Unlock()
+		return
+	}
+	a.verifier = nil
+	a.migrationMutex.Unlock()
+	v.cancel()
+	go a.collectVerification(v, stoppedAt, resumedAt)
+}
+
+func (a *App) collectVerification(v *verifierRun, stoppedAt, resumedAt time.Time) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("Verification collector panic", "migration_id", v.migrationID, "panic", rec, "stack", string(debug.Stack()))
+		}
+	}()
+	rep := <-v.done
+	if rep == nil {
+		dashboardVerificationsByResult.Add("error", 1)
+		return
+	}
+	rep.Correlate(stoppedAt, resumedAt, verify.DefaultSlack)
+	result := "pass"
+	if !rep.Pass {
+		result = "fail"
+	}
+	dashboardVerificationsByResult.Add(result, 1)
+	attrs := []any{"migration_id", v.migrationID, "target", v.target, "pass", rep.Pass,
+		"udp_sent", rep.UDP.Sent, "udp_lost", rep.UDP.Lost, "udp_max_stall_ms", rep.UDP.MaxStall.DurationMS,
+		"tcp_resets", len(rep.TCP.Disconnects), "tcp_max_stall_ms", rep.TCP.MaxStall.DurationMS}
+	if rep.Pass {
+		slog.Info("Verification finished", attrs...)
+	} else {
+		slog.Warn("Verification finished", append(attrs, "failures", rep.Failures)...)
+	}
+	a.attachVerification(v.migrationID, rep)
+}
+
+// attachVerification stores rep on migrationID's history entry and
+// republishes the entry. The report lines also go to the log pane while
+// that migration's output is still the one displayed.
+func (a *App) attachVerification(migrationID string, rep *verify.Report) {
+	a.migrationMutex.Lock()
+	current := a.migrationID == migrationID && !a.isMigrating
+	for i := len(a.migrationHistory) - 1; i >= 0; i-- {
+		if a.migrationHistory[i].MigrationID == migrationID {
+			a.migrationHistory[i].Verification = rep
+			a.events.publish(eventTypeHistory, a.migrationHistory[i])
+			break
+		}
+	}
+	a.migrationMutex.Unlock()
+	if !current {
+		return
+	}
+	a.appendLog(">>> verify: " + rep.Summary())
+	for _, f := range rep.Failures {
+		a.appendLog(">>> verify: " + f)
+	}
+}
@@ -0,0 +1,120 @@
+package dashboard
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/orchestrator"
+	"github.com/maci0/katamaran/internal/verify"
+)
+
+// newVerifyApp runs a loopback echo server and returns an App whose
+// verifier targets its ports.
+func newVerifyApp(t *testing.T, orch orchestrator.Orchestrator) *App {
+	t.Helper()
+	srv, err := verify.Listen("127.0.0.1:0", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("verify.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return &App{
+		orch: orch,
+		discoverer: &stubDiscoverer{
+			pods:  []orchestrator.PodInfo{{Namespace: "default", Name: "vm-a", Node: "src-node", PodIP: "127.0.0.1"}},
+			nodes: []orchestrator.NodeInfo{{Name: "dst-node", InternalIP: "192.168.1.20"}},
+		},
+		verifyUDPPort: srv.UDPAddr().(*net.UDPAddr).Port,
+		verifyTCPPort: srv.TCPAddr().(*net.TCPAddr).Port,
+	}
+}
+
+func postVerifyMigrate(app *App, extra url.Values) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("source_pod_namespace", "default")
+	form.Set("source_pod_name", "vm-a")
+	form.Set("dest_node", "dst-node")
+	form.Set("image", "katamaran:dev")
+	for k, v := range extra {
+		form[k] = v
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/migrate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.handleMigrate(w, req)
+	return w
+}
+
+func TestHandleMigrate_VerifyAttachesReportToHistory(t *testing.T) {
+	app := newVerifyApp(t, newFakeOrchestrator("cutover"))
+	w := postVerifyMigrate(app, url.Values{"verify": {"true"}})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	waitMigrationDone(t, app, 5*time.Second)
+
+	var rep *verify.Report
+	deadline := time.Now().Add(10 * time.Second)
+	for rep == nil && time.Now().Before(deadline) {
+		app.migrationMutex.Lock()
+		if len(app.migrationHistory) == 1 {
+			rep = app.migrationHistory[0].Verification
+		}
+		app.migrationMutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if rep == nil {
+		t.Fatal("verification report was not attached to the history entry")
+	}
+	if !rep.Pass || rep.UDP.Sent == 0 || rep.TCP.Connects == 0 {
+		t.Fatalf("report = %+v, want a passing run with traffic", rep)
+	}
+	if rep.Cutover == nil || rep.Cutover.WindowMS != 30 {
+		t.Errorf("Cutover = %+v, want the fake's 30ms STOP/RESUME window", rep.Cutover)
+	}
+	app.migrationMutex.Lock()
+	logs := strings.Join(app.migrationOutput, "\n")
+	app.migrationMutex.Unlock()
+	for _, want := range []string{">>> Verifier streaming UDP/TCP echo traffic to 127.0.0.1", ">>> verify: PASS"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestHandleMigrate_VerifyRejectsUnusableTargets(t *testing.T) {
+	app := newVerifyApp(t, dummyOrchestrator(t))
+	cases := map[string]url.Values{
+		"target without verify": {"verify_target": {"10.0.0.9"}},
+		"loopback target":       {"verify": {"true"}, "verify_target": {"127.0.0.1"}},
+		"non-boolean verify":    {"verify": {"yes"}},
+	}
+	for name, extra := range cases {
+		if w := postVerifyMigrate(app, extra); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+	app.discoverer.(*stubDiscoverer).pods[0].PodIP = ""
+	if w := postVerifyMigrate(app, url.Values{"verify": {"true"}}); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no IP") {
+		t.Errorf("pod without IP: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	app.migrationMutex.Lock()
+	defer app.migrationMutex.Unlock()
+	if app.isMigrating || app.verifier != nil {
+		t.Error("rejected request left migration or verifier state behind")
+	}
+}
@@ -29,6 +29,7 @@ import (
 // translates this into the GrpcVM proto that Kata's shim expects.
 type MigrationState struct {
 	ID              string          `json:"id"`
+	MigrationID     string          `json:"migration_id,omitempty"`
 	QEMUPid         int             `json:"qemu_pid"`
 	QMPSocket       string          `json:"qmp_socket"`
 	VsockCID        uint32          `json:"vsock_cid"`
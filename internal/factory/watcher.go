@@ -116,7 +116,7 @@ func (w *Watcher) scan() {
 			continue
 		}
 
-		slog.Info("Discovered migration metadata", "path", metaPath, "id", state.ID)
+		slog.Info("Discovered migration metadata", "path", metaPath, "id", state.ID, "migration_id", state.MigrationID)
 		w.seen[metaPath] = struct{}{}
 		if len(state.VMConfig) > 0 {
 			w.server.SetConfig(state.VMConfig, state.AgentConfig)
@@ -13,6 +13,7 @@ import (
 	"log/slog"
 	"net/netip"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/maci0/katamaran/internal/buildinfo"
@@ -25,28 +26,70 @@ type role string
 const (
 	roleSource role = "source"
 	roleDest   role = "dest"
+	roleProbe  role = "probe"
+	// roleCold is source mode migrating the VM cold (see RunSource).
+	roleCold role = "cold"
+	// roleCheckpoint and roleRestore save a VM to a bundle directory and
+	// bring it back from one (see RunCheckpoint and RunRestore).
+	roleCheckpoint role = "checkpoint"
+	roleRestore    role = "restore"
 )
 
 // sourceOnlyFlags and destOnlyFlags identify flags that are only meaningful
 // in one mode, used to warn users when flags are provided for the wrong mode.
 var (
 	sourceOnlyFlags = map[string]bool{
-		"dest-ip":                true,
-		"vm-ip":                  true,
+		"dest-ip":                  true,
+		"vm-ip":                    true,
+		"tunnel-mode":              true,
+		"downtime":                 true,
+		"auto-downtime":            true,
+		"auto-downtime-floor-ms":   true,
+		"cni-convergence-delay":    true,
+		"emit-cmdline-to":          true,
+		"dest-ready-from-job":      true,
+		"dest-ready-timeout":       true,
+		"verify-storage":           true,
+		"mirror-retries":           true,
+		"mirror-reconnect-timeout": true,
+		"pre-cutover-hooks":        true,
+		"compression-level":        true,
+		"adaptive-tuning":          true,
+		"max-downtime":             true,
+		"max-bandwidth":            true,
+	}
+	// liveOnlyFlags tune the live cutover, which cold mode does not have.
+	liveOnlyFlags = map[string]bool{
 		"tunnel-mode":            true,
 		"downtime":               true,
 		"auto-downtime":          true,
 		"auto-downtime-floor-ms": true,
 		"cni-convergence-delay":  true,
-		"emit-cmdline-to":        true,
+		"balloon":                true,
+		"compression":            true,
+		"compression-level":      true,
+		"adaptive-tuning":        true,
+		"max-downtime":           true,
+		"max-bandwidth":          true,
 	}
 	destOnlyFlags = map[string]bool{
 		"tap":                     true,
 		"tap-netns":               true,
 		"replay-cmdline":          true,
 		"replay-cmdline-from-pod": true,
+		"allow-unsigned-handoff":  true,
 		"dest-pod-name":           true,
 		"dest-pod-namespace":      true,
+		"sandbox-id":              true,
+		"drives-from-job":         true,
+		"cold-from-job":           true,
+		"post-resume-hooks":       true,
+		"compression-from-job":    true,
+	}
+	// bundleFlags name the checkpoint bundle directory of one mode each.
+	bundleFlags = map[string]role{
+		"out": roleCheckpoint,
+		"in":  roleRestore,
 	}
 )
 
@@ -54,20 +97,33 @@ func printUsage(w io.Writer) {
 	_, _ = fmt.Fprintf(w, `katamaran — Zero-packet-drop live migration for Kata Containers
 
 Usage:
-  katamaran --mode <source|dest> [flags]
+  katamaran --mode <source|cold|dest> [flags]
+  katamaran --mode probe --pod-name <name> --pod-namespace <ns> [flags]
+  katamaran --mode checkpoint --out <dir> [flags]
+  katamaran --mode restore --in <dir> [flags]
   katamaran --version
   katamaran --help
 
 Common flags:
-  --mode string            Migration role: 'source' or 'dest' (required)
+  --mode string            Migration role: 'source', 'cold', 'dest', 'probe', 'checkpoint', or 'restore' (required)
   --qmp string             Path to QEMU QMP unix socket (default "/run/vc/vm/extra-monitor.sock")
-  --drive-id string        QEMU block device ID(s), comma-separated for multi-disk (default "drive-virtio-disk0")
+  --drive-id string        QEMU block device ID(s), comma-separated for multi-disk, or 'auto' to
+                           discover writable local drives via query-block (default "drive-virtio-disk0")
   --shared-storage         Skip NBD drive-mirror (use with shared storage)
+  --hypervisor string      VMM of the VM: 'auto', 'qemu', or 'cloud-hypervisor'; with cloud-hypervisor, --qmp
+                           is the VMM's API socket (default "auto": detected from the socket)
   --multifd-channels int   Parallel TCP channels for RAM migration, 0 to disable (default 4)
+  --migration-port int     Destination RAM migration listener port, 0 for the default (default 4444)
+  --nbd-port int           Destination NBD listener port, 0 for the default (default 10809)
+  --balloon                Shrink the guest with its virtio-balloon before the RAM transfer (source)
+                           and deflate it once the VM resumes (dest); no-op without a balloon device
+  --compression string     RAM stream compression: 'none', 'zstd', 'zlib' (both need multifd), 'xbzrle'
+                           (turns multifd off), or 'auto' (source picks from the measured link) (default "none")
   --log-format string      Log output format: 'text' or 'json' (default "text")
   --log-level string       Log level: 'debug', 'info', 'warn', or 'error' (default "info")
 
-Source mode flags:
+Source mode flags (also used by cold mode, which pauses the VM, copies its disks
+and its state (or, with migration blockers, none: the dest boots the disks)):
   --dest-ip string         Destination node IP address (required)
   --vm-ip string           VM pod IP for traffic redirection (required unless using pod mode)
   --pod-name string        Source pod name (alternative to --qmp/--vm-ip)
@@ -77,9 +133,25 @@ Source mode flags:
   --auto-downtime          Auto-calculate downtime based on RTT (overrides --downtime)
   --auto-downtime-floor-ms int
                            Lower bound + overhead for auto-downtime in ms (0 uses compiled-in 25ms; ignored without --auto-downtime)
+  --adaptive-tuning        Instead of throttling vCPUs (auto-converge), raise --max-bandwidth and then the
+                           downtime limit stepwise while the transfer does not converge
+  --max-downtime int       Downtime ceiling in ms for --adaptive-tuning (0 uses compiled-in 500ms)
+  --max-bandwidth int      RAM transfer bandwidth cap in MiB/s (0 for none); --adaptive-tuning lifts it first
   --cni-convergence-delay duration
                            Post-cutover wait keeping the IP tunnel alive while the CNI rebinds the pod (0 uses compiled-in 5s)
   --emit-cmdline-to string Capture source QEMU /proc/<pid>/cmdline to this path before migration
+  --dest-ready-from-job string
+                           Wait for the destination Job ('<namespace>/<name>') to log KATAMARAN_DEST_READY before migrating (requires pods list and pods/log get on the SA)
+  --dest-ready-timeout duration
+                           How long to wait for --dest-ready-from-job (default 5m)
+  --verify-storage string  Compare mirrored drives with the destination before migrating: 'off', 'sample' or 'full' (default "off")
+  --mirror-retries int     Resumes/restarts per drive after storage mirror target errors (0 uses compiled-in 5; negative disables)
+  --mirror-reconnect-timeout duration
+                           How long each mirror recovery waits for the destination NBD server (0 uses compiled-in 2m)
+  --pre-cutover-hooks string
+                           Hooks to run before the VM's state moves: a base64url JSON list of
+                           {name, http|exec|guestExec, timeoutSeconds, failurePolicy} (see docs/USAGE.md)
+  --compression-level int  zstd (1-20) or zlib (1-9) compression level (0 uses QEMU's default)
 
 Destination mode flags:
   --tap string             Tap interface name for tc sch_plug buffering
@@ -92,6 +164,33 @@ Destination mode flags:
   --replay-cmdline string  Spawn QEMU on dest by replaying captured source cmdline (with -incoming defer)
   --replay-cmdline-from-pod string
                            Fetch source QEMU cmdline from the named source pod's log ('<namespace>/<name>') instead of a hostPath file (requires pods/log get on the SA)
+  --allow-unsigned-handoff Accept unsigned source pod-log payloads when KATAMARAN_HANDOFF_KEY is unset (manual runs only)
+  --sandbox-id string      Sandbox directory name for the replayed QEMU under /run/vc/vm (default "katamaran-dest")
+  --drives-from-job string With --drive-id auto, export the drives listed in the source Job's ('<namespace>/<name>')
+                           KATAMARAN_DRIVES marker instead of discovering them locally (requires pods list and pods/log get on the SA)
+  --cold-from-job string   Receive a cold migration from the source Job ('<namespace>/<name>'): follow its
+                           KATAMARAN_COLD_PLAN and resume the VM on KATAMARAN_COLD_DONE (requires pods list and pods/log get on the SA)
+  --post-resume-hooks string
+                           Hooks to run once the VM runs on this node, in the --pre-cutover-hooks format
+  --compression-from-job string
+                           With --compression auto, use the compression the source Job ('<namespace>/<name>') chose
+                           in its KATAMARAN_COMPRESSION marker (requires pods list and pods/log get on the SA)
+
+Probe mode flags:
+  --pod-name string        Pod whose VM to profile (required)
+  --pod-namespace string   Pod namespace (required)
+                           Prints the QEMU cmdline and, without --shared-storage,
+                           the --drive-id sizes as one KATAMARAN_VM_PROFILE line.
+                           Read-only: the VM and its network are not touched.
+
+Checkpoint mode flags (pauses the VM, saves it with --drive-id images to a bundle, resumes it):
+  --out string             New or empty bundle directory to write (required)
+  --pod-name string        Pod whose VM to checkpoint (alternative to --qmp)
+  --pod-namespace string   Pod namespace (required with --pod-name)
+
+Restore mode flags (starts the VM of a bundle on this node, where its devices must exist):
+  --in string              Bundle directory written by checkpoint mode (required)
+  --sandbox-id string      Sandbox directory name for the restored QEMU under /run/vc/vm (default "katamaran-dest")
 
 Other:
   -v, --version            Show version and exit
@@ -121,16 +220,51 @@ Examples:
   # Source in pod mode (resolve QMP and VM IP from a Kubernetes pod)
   katamaran --mode source --dest-ip 10.0.0.2 \
     --pod-name kata-demo --pod-namespace default
+
+  # Cold migration of a VM that cannot live-migrate (e.g. VFIO passthrough)
+  katamaran --mode cold --dest-ip 10.0.0.2 \
+    --pod-name kata-gpu --pod-namespace default
+
+  # Checkpoint a VM, then restore it later
+  katamaran --mode checkpoint --pod-name kata-demo --pod-namespace default \
+    --drive-id auto --out /var/lib/katamaran/kata-demo
+  katamaran --mode restore --in /var/lib/katamaran/kata-demo --sandbox-id kata-demo-restored
 `)
 }
 
 // Run contains all CLI logic: flag parsing, validation, and migration execution.
 // It is separate from cmd/katamaran so validation paths can be tested without os.Exit.
 func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	return run(ctx, args, stdout, stderr, Embedded{
+		MigrationID: os.Getenv("KATAMARAN_MIGRATION_ID"),
+		HandoffKey:  os.Getenv(migration.HandoffKeyEnv),
+	}, false)
+}
+
+// Embedded holds what RunEmbedded takes from its caller instead of the
+// process environment.
+type Embedded struct {
+	// MigrationID replaces KATAMARAN_MIGRATION_ID.
+	MigrationID string
+	// HandoffKey replaces KATAMARAN_HANDOFF_KEY.
+	HandoffKey string
+}
+
+// RunEmbedded is Run for a long-lived process that runs migrations in
+// its own address space (katamaran-agent). It leaves the process-wide
+// logger alone, so --log-format and --log-level are ignored and log
+// entries carry no migration_id, and it reads the migration ID and
+// hand-off key from e rather than the environment. The KATAMARAN_*
+// markers go to stdout, which must be safe for concurrent use.
+func RunEmbedded(ctx context.Context, args []string, stdout, stderr io.Writer, e Embedded) int {
+	return run(ctx, args, stdout, stderr, e, true)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, e Embedded, embedded bool) int {
 	fs := flag.NewFlagSet("katamaran", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 
-	modeFlag := fs.String("mode", "", "Migration role: 'source' or 'dest'")
+	modeFlag := fs.String("mode", "", "Migration role: 'source', 'cold', 'dest', 'probe', 'checkpoint', or 'restore'")
 	qmpSocket := fs.String("qmp", "/run/vc/vm/extra-monitor.sock", "Path to QEMU QMP unix socket")
 	tapIface := fs.String("tap", "", "Tap interface name for tc sch_plug buffering")
 	tapNetns := fs.String("tap-netns", "", "Network namespace path for tap interface")
@@ -138,12 +272,22 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	vmIP := fs.String("vm-ip", "", "VM pod IP for traffic redirection")
 	driveID := fs.String("drive-id", "drive-virtio-disk0", "QEMU block device ID(s), comma-separated for multi-disk")
 	sharedStorage := fs.Bool("shared-storage", false, "Skip NBD drive-mirror (use with shared storage)")
+	hypervisor := fs.String("hypervisor", migration.HypervisorAuto, "VMM of the VM: 'auto', 'qemu', or 'cloud-hypervisor' (source and dest modes)")
 	tunnelMode := fs.String("tunnel-mode", "ipip", "Tunnel mode: 'ipip', 'gre', or 'none'")
 	downtimeLimit := fs.Int("downtime", 25, "Max allowed downtime in milliseconds (1-60000)")
 	autoDowntime := fs.Bool("auto-downtime", false, "Auto-calculate downtime based on RTT (overrides --downtime)")
 	autoDowntimeFloor := fs.Int("auto-downtime-floor-ms", 0, "Lower bound + overhead for the auto-calculated downtime (0 uses the compiled-in default of 25ms). Ignored without --auto-downtime")
+	adaptiveTuning := fs.Bool("adaptive-tuning", false, "Source mode: raise the bandwidth cap and downtime limit while the transfer does not converge, instead of throttling vCPUs")
+	maxDowntime := fs.Int("max-downtime", 0, "Source mode: downtime ceiling in milliseconds for --adaptive-tuning (0 uses the default of 500)")
+	maxBandwidthMBps := fs.Int("max-bandwidth", 0, "Source mode: RAM transfer bandwidth cap in MiB/s (0 for none)")
 	cniConvergenceDelay := fs.Duration("cni-convergence-delay", 0, "Post-cutover wait that keeps the IP tunnel alive while the CNI propagates the pod's new node binding (0 uses the compiled-in default of 5s)")
 	multifdChannels := fs.Int("multifd-channels", migration.DefaultMultifdChannels, "Parallel TCP channels for RAM migration (0 to disable)")
+	migrationPort := fs.Int("migration-port", 0, "Destination RAM migration listener port (0 uses the default 4444)")
+	nbdPort := fs.Int("nbd-port", 0, "Destination NBD listener port (0 uses the default 10809)")
+	balloon := fs.Bool("balloon", false, "Inflate the guest's virtio-balloon before the RAM transfer (source) and deflate it after resume (dest)")
+	compression := fs.String("compression", migration.CompressionNone, "RAM stream compression: 'none', 'zstd', 'zlib', 'xbzrle', or 'auto'")
+	compressionLevel := fs.Int("compression-level", 0, "Source mode: zstd (1-20) or zlib (1-9) compression level (0 uses QEMU's default)")
+	compressionFromJob := fs.String("compression-from-job", "", "Dest mode: with --compression auto, use the compression the source Job (`<namespace>/<name>`) logs in its KATAMARAN_COMPRESSION marker")
 	logFormat := fs.String("log-format", "text", "Log output format: 'text' or 'json'")
 	logLevel := fs.String("log-level", "info", "Log level: 'debug', 'info', 'warn', or 'error'")
 	podName := fs.String("pod-name", "", "Source pod name (alternative to --qmp/--vm-ip)")
@@ -151,8 +295,21 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	destPodName := fs.String("dest-pod-name", "", "Destination pod name (alternative to --qmp)")
 	destPodNS := fs.String("dest-pod-namespace", "", "Destination pod namespace (required with --dest-pod-name)")
 	emitCmdlineTo := fs.String("emit-cmdline-to", "", "Source mode: capture /proc/<qemu_pid>/cmdline to this path before migration")
+	destReadyFrom := fs.String("dest-ready-from-job", "", "Source mode: wait for the destination Job (`<namespace>/<name>`) to log KATAMARAN_DEST_READY before migrating")
+	destReadyTimeout := fs.Duration("dest-ready-timeout", 0, "Source mode: how long to wait for --dest-ready-from-job (0 uses the default of 5m)")
+	mirrorRetries := fs.Int("mirror-retries", 0, "Source mode: resumes/restarts per drive after storage mirror target errors (0 uses the default of 5; negative disables)")
+	mirrorReconnectTimeout := fs.Duration("mirror-reconnect-timeout", 0, "Source mode: how long each mirror recovery waits for the destination NBD server (0 uses the default of 2m)")
+	verifyStorage := fs.String("verify-storage", migration.VerifyStorageOff, "Source mode: compare mirrored drives with the destination's exports before migrating: 'off', 'sample' or 'full'")
 	replayCmdline := fs.String("replay-cmdline", "", "Dest mode: spawn QEMU by replaying the source cmdline at this path with -incoming defer")
 	replayCmdlineFromPod := fs.String("replay-cmdline-from-pod", "", "Dest mode: fetch the source QEMU cmdline from the named source pod's log (`<namespace>/<name>`) instead of a hostPath file. Requires pods/log get on the SA")
+	allowUnsignedHandoff := fs.Bool("allow-unsigned-handoff", false, "Dest mode: accept unsigned source pod-log payloads when KATAMARAN_HANDOFF_KEY is unset")
+	drivesFromJob := fs.String("drives-from-job", "", "Dest mode: with --drive-id auto, export the drives in the source Job's (`<namespace>/<name>`) KATAMARAN_DRIVES marker")
+	coldFromJob := fs.String("cold-from-job", "", "Dest mode: receive a cold migration from the source Job (`<namespace>/<name>`), resuming the VM once it reports KATAMARAN_COLD_DONE")
+	preCutoverHooks := fs.String("pre-cutover-hooks", "", "Source mode: hooks to run before the VM's state moves (base64url JSON list)")
+	postResumeHooks := fs.String("post-resume-hooks", "", "Dest mode: hooks to run once the VM runs on this node (base64url JSON list)")
+	sandboxID := fs.String("sandbox-id", "", "Dest mode: sandbox directory name for the replayed QEMU (default \"katamaran-dest\")")
+	outDir := fs.String("out", "", "Checkpoint mode: new or empty directory to write the checkpoint bundle to")
+	inDir := fs.String("in", "", "Restore mode: checkpoint bundle directory to restore")
 	showVersion := fs.Bool("version", false, "Show version and exit")
 	showVersionShort := fs.Bool("v", false, "")
 	helpFlag := fs.Bool("help", false, "")
@@ -187,31 +344,56 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 	*logFormat = strings.ToLower(*logFormat)
 	*logLevel = strings.ToLower(*logLevel)
 	*tunnelMode = strings.ToLower(*tunnelMode)
+	*hypervisor = strings.ToLower(*hypervisor)
+	*compression = strings.ToLower(*compression)
 
 	mode := role(*modeFlag)
 
 	// Validate mode before any side effects (logger setup, warnings).
 	switch mode {
-	case roleSource, roleDest:
+	case roleSource, roleCold, roleDest, roleProbe, roleCheckpoint, roleRestore:
 		// valid
 	case "":
-		_, _ = fmt.Fprintf(stderr, "Error: --mode is required (valid: source, dest)\n\n")
+		_, _ = fmt.Fprintf(stderr, "Error: --mode is required (valid: source, cold, dest, probe, checkpoint, restore)\n\n")
 		printUsage(stderr)
 		return 2
 	default:
-		_, _ = fmt.Fprintf(stderr, "Error: invalid --mode %q (valid: source, dest)\n\n", *modeFlag)
+		_, _ = fmt.Fprintf(stderr, "Error: invalid --mode %q (valid: source, cold, dest, probe, checkpoint, restore)\n\n", *modeFlag)
 		printUsage(stderr)
 		return 2
 	}
-	if err := logging.SetupLogger(stderr, *logFormat, *logLevel, "katamaran"); err != nil {
-		_, _ = fmt.Fprintf(stderr, "Error: %v\n\n", err)
-		printUsage(stderr)
+	// Cold mode takes the source flags.
+	sourceSide := mode == roleSource || mode == roleCold
+	if !embedded {
+		if err := logging.SetupLogger(stderr, *logFormat, *logLevel, "katamaran"); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error: %v\n\n", err)
+			printUsage(stderr)
+			return 2
+		}
+		// Propagate migration ID from the dashboard's environment variable
+		// into all log entries for cross-component correlation.
+		if e.MigrationID != "" {
+			slog.SetDefault(slog.Default().With("migration_id", e.MigrationID))
+		}
+	}
+	ctx = migration.WithMarkerOutput(ctx, stdout)
+	// The hand-off key comes from the environment (or the agent's RPC)
+	// only: a flag would show up in the Job spec and in ps.
+	handoffKey, keyErr := migration.ParseHandoffKey(e.HandoffKey)
+	if keyErr != nil {
+		_, _ = fmt.Fprintf(stderr, "Error: %v\n\n", keyErr)
 		return 2
 	}
-	// Propagate migration ID from the dashboard's environment variable
-	// into all log entries for cross-component correlation.
-	if mid := os.Getenv("KATAMARAN_MIGRATION_ID"); mid != "" {
-		slog.SetDefault(slog.Default().With("migration_id", mid))
+
+	preHooks, err := migration.ParseHooks(*preCutoverHooks)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error: invalid --pre-cutover-hooks: %v\n\n", err)
+		return 2
+	}
+	postHooks, err := migration.ParseHooks(*postResumeHooks)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error: invalid --post-resume-hooks: %v\n\n", err)
+		return 2
 	}
 
 	if *multifdChannels < 0 {
@@ -219,12 +401,80 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 		printUsage(stderr)
 		return 2
 	}
-	if mode == roleSource && *autoDowntimeFloor < 0 {
+	if mode == roleSource || mode == roleDest && *coldFromJob == "" {
+		level := *compressionLevel
+		if mode == roleDest {
+			level = 0 // source only
+		}
+		if err := migration.CheckCompression(*compression, level, *multifdChannels); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error: --compression: %v\n\n", err)
+			printUsage(stderr)
+			return 2
+		}
+	}
+	if mode == roleDest && *coldFromJob == "" && *compression == migration.CompressionAuto && *compressionFromJob == "" {
+		_, _ = fmt.Fprintf(stderr, "Error: --compression auto requires --compression-from-job in dest mode\n\n")
+		printUsage(stderr)
+		return 2
+	}
+	for _, p := range []struct {
+		name string
+		val  int
+	}{{"migration-port", *migrationPort}, {"nbd-port", *nbdPort}} {
+		if p.val < 0 || p.val > 65535 {
+			_, _ = fmt.Fprintf(stderr, "Error: --%s must be between 0 and 65535, got %d\n\n", p.name, p.val)
+			printUsage(stderr)
+			return 2
+		}
+	}
+	switch *hypervisor {
+	case migration.HypervisorAuto, migration.HypervisorQEMU, migration.HypervisorCloudHypervisor:
+	default:
+		_, _ = fmt.Fprintf(stderr, "Error: invalid --hypervisor %q (valid: auto, qemu, cloud-hypervisor)\n\n", *hypervisor)
+		printUsage(stderr)
+		return 2
+	}
+	if ids := strings.Split(*driveID, ","); len(ids) > 1 && slices.Contains(ids, migration.DriveIDsAuto) {
+		_, _ = fmt.Fprintf(stderr, "Error: --drive-id %s cannot be combined with other drive IDs\n\n", migration.DriveIDsAuto)
+		printUsage(stderr)
+		return 2
+	}
+	if mode == roleDest && *drivesFromJob != "" && *driveID != migration.DriveIDsAuto {
+		_, _ = fmt.Fprintf(stderr, "Error: --drives-from-job requires --drive-id %s\n\n", migration.DriveIDsAuto)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *autoDowntimeFloor < 0 {
 		_, _ = fmt.Fprintf(stderr, "Error: --auto-downtime-floor-ms must be non-negative, got %d\n\n", *autoDowntimeFloor)
 		printUsage(stderr)
 		return 2
 	}
-	if mode == roleSource && *cniConvergenceDelay < 0 {
+	if sourceSide && (*maxDowntime < 0 || *maxDowntime > 60000) {
+		_, _ = fmt.Fprintf(stderr, "Error: --max-downtime must be between 0 and 60000, got %d\n\n", *maxDowntime)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *maxDowntime > 0 && !*autoDowntime && *maxDowntime < *downtimeLimit {
+		_, _ = fmt.Fprintf(stderr, "Error: --max-downtime (%d) must not be below --downtime (%d)\n\n", *maxDowntime, *downtimeLimit)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *maxBandwidthMBps < 0 {
+		_, _ = fmt.Fprintf(stderr, "Error: --max-bandwidth must be non-negative, got %d\n\n", *maxBandwidthMBps)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *destReadyTimeout < 0 {
+		_, _ = fmt.Fprintf(stderr, "Error: --dest-ready-timeout must be non-negative, got %s\n\n", *destReadyTimeout)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *mirrorReconnectTimeout < 0 {
+		_, _ = fmt.Fprintf(stderr, "Error: --mirror-reconnect-timeout must be non-negative, got %s\n\n", *mirrorReconnectTimeout)
+		printUsage(stderr)
+		return 2
+	}
+	if sourceSide && *cniConvergenceDelay < 0 {
 		_, _ = fmt.Fprintf(stderr, "Error: --cni-convergence-delay must be non-negative, got %s\n\n", *cniConvergenceDelay)
 		printUsage(stderr)
 		return 2
@@ -235,19 +485,94 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 		if mode == roleDest && sourceOnlyFlags[f.Name] {
 			slog.Warn("Flag ignored in dest mode", "flag", f.Name)
 		}
-		if mode == roleSource && destOnlyFlags[f.Name] {
-			slog.Warn("Flag ignored in source mode", "flag", f.Name)
+		if sourceSide && destOnlyFlags[f.Name] || mode == roleCold && liveOnlyFlags[f.Name] {
+			slog.Warn("Flag ignored in "+string(mode)+" mode", "flag", f.Name)
+		}
+		if (mode == roleProbe || mode == roleCheckpoint) && (sourceOnlyFlags[f.Name] || destOnlyFlags[f.Name]) ||
+			mode == roleRestore && (sourceOnlyFlags[f.Name] || destOnlyFlags[f.Name] && f.Name != "sandbox-id") {
+			slog.Warn("Flag ignored in "+string(mode)+" mode", "flag", f.Name)
+		}
+		if m, ok := bundleFlags[f.Name]; ok && m != mode {
+			slog.Warn("Flag ignored in "+string(mode)+" mode", "flag", f.Name)
+		}
+		// Probe, checkpoint and restore drive QEMU only, and balloon
+		// reduction and compression are for live migrations.
+		if (f.Name == "hypervisor" || f.Name == "balloon" || f.Name == "compression") && (mode == roleProbe || mode == roleCheckpoint || mode == roleRestore) {
+			slog.Warn("Flag ignored in "+string(mode)+" mode", "flag", f.Name)
+		}
+		if mode == roleDest && *coldFromJob != "" && (f.Name == "compression" || f.Name == "compression-from-job") {
+			slog.Warn("Flag ignored for a cold migration", "flag", f.Name)
 		}
 	})
-	if mode == roleSource && *autoDowntime && seenFlags["downtime"] {
+	if sourceSide && *autoDowntime && seenFlags["downtime"] {
 		slog.Warn("--auto-downtime overrides --downtime; explicit --downtime value will be ignored")
 	}
-	if mode == roleSource && seenFlags["auto-downtime-floor-ms"] && !*autoDowntime {
+	if sourceSide && seenFlags["auto-downtime-floor-ms"] && !*autoDowntime {
 		slog.Warn("--auto-downtime-floor-ms is ignored without --auto-downtime")
 	}
+	if sourceSide && seenFlags["max-downtime"] && !*adaptiveTuning {
+		slog.Warn("--max-downtime is ignored without --adaptive-tuning")
+	}
+	if sourceSide && *sharedStorage && *verifyStorage != migration.VerifyStorageOff {
+		slog.Warn("--verify-storage is ignored with --shared-storage")
+	}
 
-	var err error
 	switch mode {
+	case roleProbe:
+		if *podName == "" || *podNS == "" {
+			_, _ = fmt.Fprintf(stderr, "Error: probe mode requires --pod-name and --pod-namespace\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		qmpOverride := ""
+		if seenFlags["qmp"] {
+			qmpOverride = *qmpSocket
+		}
+		slog.Info("katamaran starting", "version", buildinfo.Version, "mode", string(mode), "pid", os.Getpid())
+		err = migration.RunProbe(ctx, migration.ProbeConfig{
+			PodName:       *podName,
+			PodNamespace:  *podNS,
+			QMPSocket:     qmpOverride,
+			DriveIDs:      strings.Split(*driveID, ","),
+			SharedStorage: *sharedStorage,
+		})
+	case roleCheckpoint:
+		if *outDir == "" {
+			_, _ = fmt.Fprintf(stderr, "Error: checkpoint mode requires --out\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		if seenFlags["pod-name"] != seenFlags["pod-namespace"] {
+			_, _ = fmt.Fprintf(stderr, "Error: --pod-name and --pod-namespace must be supplied together\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		if seenFlags["pod-name"] && seenFlags["qmp"] {
+			_, _ = fmt.Fprintf(stderr, "Error: --pod-name/--pod-namespace cannot be combined with --qmp\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		slog.Info("katamaran starting", "version", buildinfo.Version, "mode", string(mode), "pid", os.Getpid())
+		err = migration.RunCheckpoint(ctx, migration.CheckpointConfig{
+			QMPSocket:     *qmpSocket,
+			PodName:       *podName,
+			PodNamespace:  *podNS,
+			OutDir:        *outDir,
+			DriveIDs:      strings.Split(*driveID, ","),
+			SharedStorage: *sharedStorage,
+		})
+	case roleRestore:
+		if *inDir == "" {
+			_, _ = fmt.Fprintf(stderr, "Error: restore mode requires --in\n\n")
+			printUsage(stderr)
+			return 2
+		}
+		slog.Info("katamaran starting", "version", buildinfo.Version, "mode", string(mode), "pid", os.Getpid())
+		err = migration.RunRestore(ctx, migration.RestoreConfig{
+			InDir:       *inDir,
+			SandboxID:   *sandboxID,
+			MigrationID: e.MigrationID,
+		})
 	case roleDest:
 		// Validate that --dest-pod-name and --dest-pod-namespace come together.
 		// Unlike source, no XOR check is needed: --qmp has a sensible default
@@ -285,8 +610,21 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 			ReplayCmdlineFile:    *replayCmdline,
 			ReplayCmdlineFromPod: *replayCmdlineFromPod,
 			SourcePodRef:         sourcePodRef,
+			HandoffKey:           handoffKey,
+			AllowUnsignedHandoff: *allowUnsignedHandoff,
+			SandboxID:            *sandboxID,
+			DrivesFromJob:        *drivesFromJob,
+			ColdFromJob:          *coldFromJob,
+			Hypervisor:           *hypervisor,
+			MigrationPort:        *migrationPort,
+			NBDPort:              *nbdPort,
+			MigrationID:          e.MigrationID,
+			PostResumeHooks:      postHooks,
+			Balloon:              *balloon,
+			Compression:          *compression,
+			CompressionFromJob:   *compressionFromJob,
 		})
-	case roleSource:
+	case roleSource, roleCold:
 		if *destIP == "" {
 			_, _ = fmt.Fprintf(stderr, "Error: --dest-ip is required\n\n")
 			printUsage(stderr)
@@ -350,6 +688,13 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 			printUsage(stderr)
 			return 2
 		}
+		switch *verifyStorage {
+		case migration.VerifyStorageOff, migration.VerifyStorageSample, migration.VerifyStorageFull:
+		default:
+			_, _ = fmt.Fprintf(stderr, "Error: invalid --verify-storage %q (valid: off, sample, full)\n\n", *verifyStorage)
+			printUsage(stderr)
+			return 2
+		}
 		if *downtimeLimit < 1 || *downtimeLimit > 60000 {
 			_, _ = fmt.Fprintf(stderr, "Error: --downtime must be between 1 and 60000, got %d\n\n", *downtimeLimit)
 			printUsage(stderr)
@@ -358,20 +703,37 @@ func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
 
 		slog.Info("katamaran starting", "version", buildinfo.Version, "mode", string(mode), "pid", os.Getpid())
 		err = migration.RunSource(ctx, migration.SourceConfig{
-			QMPSocket:           *qmpSocket,
-			DestIP:              parsedDest,
-			VMIP:                parsedVM,
-			DriveIDs:            strings.Split(*driveID, ","),
-			SharedStorage:       *sharedStorage,
-			TunnelMode:          tm,
-			DowntimeLimitMS:     *downtimeLimit,
-			AutoDowntime:        *autoDowntime,
-			AutoDowntimeFloorMS: *autoDowntimeFloor,
-			CNIConvergenceDelay: *cniConvergenceDelay,
-			MultifdChannels:     *multifdChannels,
-			PodName:             *podName,
-			PodNamespace:        *podNS,
-			EmitCmdlineTo:       *emitCmdlineTo,
+			QMPSocket:              *qmpSocket,
+			DestIP:                 parsedDest,
+			VMIP:                   parsedVM,
+			DriveIDs:               strings.Split(*driveID, ","),
+			SharedStorage:          *sharedStorage,
+			TunnelMode:             tm,
+			DowntimeLimitMS:        *downtimeLimit,
+			AutoDowntime:           *autoDowntime,
+			AutoDowntimeFloorMS:    *autoDowntimeFloor,
+			CNIConvergenceDelay:    *cniConvergenceDelay,
+			MultifdChannels:        *multifdChannels,
+			PodName:                *podName,
+			PodNamespace:           *podNS,
+			EmitCmdlineTo:          *emitCmdlineTo,
+			HandoffKey:             handoffKey,
+			MigrationPort:          *migrationPort,
+			NBDPort:                *nbdPort,
+			DestReadyFrom:          *destReadyFrom,
+			DestReadyTimeout:       *destReadyTimeout,
+			VerifyStorage:          *verifyStorage,
+			MirrorRetries:          *mirrorRetries,
+			MirrorReconnectTimeout: *mirrorReconnectTimeout,
+			Cold:                   mode == roleCold,
+			Hypervisor:             *hypervisor,
+			PreCutoverHooks:        preHooks,
+			Balloon:                *balloon,
+			Compression:            *compression,
+			CompressionLevel:       *compressionLevel,
+			AdaptiveTuning:         *adaptiveTuning,
+			MaxDowntimeMS:          *maxDowntime,
+			MaxBandwidthMBps:       *maxBandwidthMBps,
 		})
 	}
 
@@ -0,0 +1,304 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Balloon reduction (--balloon) shrinks what the RAM transfer has to move.
+// Before migrating, the source inflates the virtio-balloon toward the
+// memory the guest actually uses, so the pages the guest hands back are
+// sent as zero pages; after the VM resumes, the destination deflates the
+// balloon back to the VM's full size. A VM without a balloon device
+// migrates as before. Free page hinting (the device's free-page-hint=on)
+// additionally lets QEMU skip the guest's free pages during the first
+// pass; it is a device property fixed when the VM starts, so it is only
+// reported here.
+const (
+	// balloonMinHeadroom is the least memory left to the guest on top
+	// of what it uses; the headroom is a tenth of its memory when that
+	// is more.
+	balloonMinHeadroom = 256 << 20
+	// balloonMinReclaim is the least worth inflating the balloon for.
+	balloonMinReclaim = 64 << 20
+	// balloonTolerance is how close to its target the balloon counts as
+	// inflated: the guest driver moves pages in batches.
+	balloonTolerance = 1 << 20
+	// balloonStatsUnset is what QEMU reports for a statistic the guest
+	// has not provided.
+	balloonStatsUnset = math.MaxUint64
+)
+
+// Balloon timing; variables so tests can shorten them.
+var (
+	balloonPollInterval   = 250 * time.Millisecond
+	balloonStatsTimeout   = 10 * time.Second
+	balloonStallTimeout   = 5 * time.Second
+	balloonInflateTimeout = time.Minute
+)
+
+// balloonGuestStats is the balloon device's guest-stats property.
+type balloonGuestStats struct {
+	Stats      map[string]uint64 `json:"stats"`
+	LastUpdate int64             `json:"last-update"`
+}
+
+// findBalloon returns the QOM path of the VM's virtio-balloon device, or
+// "" when it has none.
+func findBalloon(ctx context.Context, client *qmp.Client) (string, error) {
+	for _, parent := range []string{peripheralPath, "/machine/peripheral-anon"} {
+		raw, err := client.Execute(ctx, "qom-list", qmp.QOMListArgs{Path: parent})
+		if err != nil {
+			if parent == peripheralPath {
+				return "", fmt.Errorf("qom-list %s: %w", parent, err)
+			}
+			continue
+		}
+		var children []qmp.ObjectPropertyInfo
+		if err := json.Unmarshal(raw, &children); err != nil {
+			return "", fmt.Errorf("decode qom-list %s: %w", parent, err)
+		}
+		for _, c := range children {
+			if strings.HasPrefix(qomChildType(c.Type), "virtio-balloon") {
+				return parent + "/" + c.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func qomGet(ctx context.Context, client *qmp.Client, path, property string, out any) error {
+	raw, err := client.Execute(ctx, "qom-get", qmp.QOMGetArgs{Path: path, Property: property})
+	if err != nil {
+		return fmt.Errorf("qom-get %s %s: %w", path, property, err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decode qom-get %s %s: %w", path, property, err)
+	}
+	return nil
+}
+
+// balloonFreePageHint reports whether the balloon device at path has free
+// page hinting on. The PCI and CCW proxies keep the property on their
+// virtio-backend child.
+func balloonFreePageHint(ctx context.Context, client *qmp.Client, path string) bool {
+	for _, p := range []string{path + "/virtio-backend", path} {
+		var on bool
+		if qomGet(ctx, client, p, "free-page-hint", &on) == nil {
+			return on
+		}
+	}
+	return false
+}
+
+func queryBalloon(ctx context.Context, client *qmp.Client) (int64, error) {
+	raw, err := client.Execute(ctx, "query-balloon", nil)
+	if err != nil {
+		return 0, fmt.Errorf("query-balloon: %w", err)
+	}
+	var info qmp.BalloonInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return 0, fmt.Errorf("decode query-balloon: %w", err)
+	}
+	return info.Actual, nil
+}
+
+// guestAvailableMemory returns how much memory the guest could give up
+// without swapping, from the balloon driver's statistics. Statistics
+// polling is switched on for the read when nobody enabled it.
+func guestAvailableMemory(ctx context.Context, client *qmp.Client, path string) (int64, error) {
+	var interval int64
+	if err := qomGet(ctx, client, path, "guest-stats-polling-interval", &interval); err != nil {
+		return 0, err
+	}
+	if interval == 0 {
+		if _, err := client.Execute(ctx, "qom-set", qmp.QOMSetArgs{Path: path, Property: "guest-stats-polling-interval", Value: 1}); err != nil {
+			return 0, fmt.Errorf("enable balloon statistics: %w", err)
+		}
+		defer func() {
+			cctx, cancel := cleanupCtx(ctx)
+			defer cancel()
+			if _, err := client.Execute(cctx, "qom-set", qmp.QOMSetArgs{Path: path, Property: "guest-stats-polling-interval", Value: 0}); err != nil {
+				slog.Warn("Failed to disable balloon statistics", "error", err)
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(balloonStatsTimeout)
+	for {
+		var gs balloonGuestStats
+		if err := qomGet(ctx, client, path, "guest-stats", &gs); err != nil {
+			return 0, err
+		}
+		if gs.LastUpdate > 0 {
+			if avail, ok := availableFromStats(gs.Stats); ok {
+				return avail, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("guest reported no memory statistics within %s", balloonStatsTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(balloonPollInterval):
+		}
+	}
+}
+
+// availableFromStats prefers the guest's own estimate (MemAvailable on
+// Linux) and falls back to free memory plus page cache.
+func availableFromStats(stats map[string]uint64) (int64, bool) {
+	stat := func(name string) (int64, bool) {
+		v, ok := stats[name]
+		if !ok || v == balloonStatsUnset || v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	if v, ok := stat("stat-available-memory"); ok {
+		return v, true
+	}
+	free, ok := stat("stat-free-memory")
+	if !ok {
+		return 0, false
+	}
+	caches, _ := stat("stat-disk-caches")
+	return free + caches, true
+}
+
+// shrinkGuestMemory inflates the balloon toward the guest's usage plus
+// headroom and returns the bytes taken from the guest. Without a balloon
+// device, guest statistics or anything worth reclaiming it returns zero.
+// An inflation that stalls or times out keeps what it got.
+func shrinkGuestMemory(ctx context.Context, client *qmp.Client) (int64, error) {
+	path, err := findBalloon(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	if path == "" {
+		slog.Info("No virtio-balloon device; migrating the VM's full memory")
+		return 0, nil
+	}
+	if balloonFreePageHint(ctx, client, path) {
+		slog.Info("Free page hinting enabled; QEMU skips the guest's free pages", "device", path)
+	} else {
+		slog.Info("Free page hinting disabled (virtio-balloon free-page-hint=on); free pages are sent too", "device", path)
+	}
+
+	before, err := queryBalloon(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	available, err := guestAvailableMemory(ctx, client, path)
+	if err != nil {
+		return 0, err
+	}
+	headroom := max(int64(balloonMinHeadroom), before/10)
+	reclaim := (available - headroom) &^ (1<<20 - 1)
+	if reclaim < balloonMinReclaim {
+		slog.Info("Guest has little memory to spare; not inflating the balloon",
+			"actual", before, "available", available, "headroom", headroom)
+		return 0, nil
+	}
+	target := before - reclaim
+	slog.Info("Inflating balloon", "actual", before, "available", available, "target", target)
+	if _, err := client.Execute(ctx, "balloon", qmp.BalloonArgs{Value: target}); err != nil {
+		return 0, fmt.Errorf("balloon: %w", err)
+	}
+	after, err := waitBalloon(ctx, client, before, target)
+	saved := max(before-after, 0)
+	if saved == 0 {
+		// The guest driver did not react: do not leave a target behind
+		// that it may act on mid-transfer.
+		cctx, cancel := cleanupCtx(ctx)
+		defer cancel()
+		if _, rerr := client.Execute(cctx, "balloon", qmp.BalloonArgs{Value: before}); rerr != nil {
+			slog.Warn("Failed to reset balloon target", "error", rerr)
+		}
+	}
+	if err != nil {
+		return saved, err
+	}
+	slog.Info("Balloon inflated", "before", before, "after", after, "saved", saved)
+	return saved, nil
+}
+
+// waitBalloon polls query-balloon until the guest has reached target,
+// made no progress for balloonStallTimeout, or balloonInflateTimeout
+// passed, and returns the last size seen.
+func waitBalloon(ctx context.Context, client *qmp.Client, before, target int64) (int64, error) {
+	start := time.Now()
+	last, lastProgress := before, start
+	for {
+		actual, err := queryBalloon(ctx, client)
+		if err != nil {
+			return last, err
+		}
+		if actual <= target+balloonTolerance {
+			return actual, nil
+		}
+		if actual < last {
+			last, lastProgress = actual, time.Now()
+		}
+		switch {
+		case time.Since(lastProgress) > balloonStallTimeout:
+			slog.Warn("Balloon inflation stalled; migrating with what the guest gave up", "actual", last, "target", target)
+			return last, nil
+		case time.Since(start) > balloonInflateTimeout:
+			slog.Warn("Balloon inflation timed out; migrating with what the guest gave up", "actual", last, "target", target)
+			return last, nil
+		}
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-time.After(balloonPollInterval):
+		}
+	}
+}
+
+// restoreGuestMemory deflates the balloon back to the VM's full memory,
+// hot-plugged DIMMs included. It does not wait for the guest.
+func restoreGuestMemory(ctx context.Context, client *qmp.Client) error {
+	path, err := findBalloon(ctx, client)
+	if err != nil || path == "" {
+		return err
+	}
+	actual, err := queryBalloon(ctx, client)
+	if err != nil {
+		return err
+	}
+	full, err := vmMemorySize(ctx, client)
+	if err != nil {
+		return err
+	}
+	if actual >= full {
+		return nil
+	}
+	slog.Info("Deflating balloon", "actual", actual, "target", full)
+	if _, err := client.Execute(ctx, "balloon", qmp.BalloonArgs{Value: full}); err != nil {
+		return fmt.Errorf("balloon: %w", err)
+	}
+	return nil
+}
+
+// vmMemorySize returns the VM's full memory: its boot memory plus any
+// hot-plugged DIMMs.
+func vmMemorySize(ctx context.Context, client *qmp.Client) (int64, error) {
+	raw, err := client.Execute(ctx, "query-memory-size-summary", nil)
+	if err != nil {
+		return 0, fmt.Errorf("query-memory-size-summary: %w", err)
+	}
+	var sum qmp.MemorySizeSummary
+	if err := json.Unmarshal(raw, &sum); err != nil {
+		return 0, fmt.Errorf("decode query-memory-size-summary: %w", err)
+	}
+	return sum.BaseMemory + sum.PluggedMemory, nil
+}
@@ -0,0 +1,197 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+const gib = 1 << 30
+
+// fakeBalloonQMP answers the balloon commands for a 4 GiB guest with
+// 3 GiB available. The balloon shrinks the guest to its target over
+// two query-balloon polls when responsive is set.
+func fakeBalloonQMP(t *testing.T, responsive bool) (*qmp.Client, *qmpRecorder) {
+	t.Helper()
+	var actual, target atomic.Int64
+	actual.Store(4 * gib)
+	target.Store(4 * gib)
+	var statsReads atomic.Int32
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "qom-list":
+			var args qmp.QOMListArgs
+			decodeRecordedArgs(t, cmd, &args)
+			if args.Path == peripheralPath {
+				return `{"return":[{"name":"type","type":"string"},{"name":"balloon0","type":"child<virtio-balloon-pci>"}]}`
+			}
+			return `{"return":[]}`
+		case "qom-get":
+			var args qmp.QOMGetArgs
+			decodeRecordedArgs(t, cmd, &args)
+			switch args.Property {
+			case "free-page-hint":
+				return `{"return":true}`
+			case "guest-stats-polling-interval":
+				return `{"return":0}`
+			case "guest-stats":
+				if statsReads.Add(1) == 1 {
+					return `{"return":{"stats":{"stat-available-memory":18446744073709551615},"last-update":0}}`
+				}
+				return `{"return":{"stats":{"stat-available-memory":3221225472,"stat-free-memory":1073741824},"last-update":1700000000}}`
+			}
+		case "balloon":
+			var args qmp.BalloonArgs
+			decodeRecordedArgs(t, cmd, &args)
+			target.Store(args.Value)
+		case "query-balloon":
+			cur := actual.Load()
+			if responsive && cur > target.Load() {
+				actual.Store(max(target.Load(), cur-1*gib))
+			} else if responsive {
+				actual.Store(target.Load())
+			}
+			return `{"return":{"actual":` + strconv.FormatInt(cur, 10) + `}}`
+		case "query-memory-size-summary":
+			return `{"return":{"base-memory":4294967296}}`
+		}
+		return `{"return":{}}`
+	})
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("qmp.NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, rec
+}
+
+func TestShrinkGuestMemory(t *testing.T) {
+	t.Parallel()
+	client, rec := fakeBalloonQMP(t, true)
+
+	saved, err := shrinkGuestMemory(context.Background(), client)
+	if err != nil {
+		t.Fatalf("shrinkGuestMemory: %v", err)
+	}
+	// 3 GiB available minus a headroom of a tenth of 4 GiB, MiB-aligned.
+	wantTarget := int64(4*gib - (3*gib-4*gib/10)&^(1<<20-1))
+	if want := 4*gib - wantTarget; saved != want {
+		t.Errorf("saved = %d, want %d", saved, want)
+	}
+	commands := rec.Commands()
+	var args qmp.BalloonArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "balloon"), &args)
+	if args.Value != wantTarget {
+		t.Errorf("balloon target = %d, want %d", args.Value, wantTarget)
+	}
+	// Statistics polling is switched on for the read and off again.
+	var sets []any
+	for _, c := range commands {
+		if c.Execute == "qom-set" {
+			var s qmp.QOMSetArgs
+			decodeRecordedArgs(t, c, &s)
+			sets = append(sets, s.Value)
+		}
+	}
+	if len(sets) != 2 || sets[0] != float64(1) || sets[1] != float64(0) {
+		t.Errorf("guest-stats-polling-interval sets = %v, want [1 0]", sets)
+	}
+}
+
+func TestShrinkGuestMemory_NoBalloon(t *testing.T) {
+	t.Parallel()
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		if cmd.Execute == "qom-list" {
+			return `{"return":[{"name":"virtio-disk0","type":"child<virtio-blk-pci>"}]}`
+		}
+		return `{"return":{}}`
+	})
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("qmp.NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	saved, err := shrinkGuestMemory(context.Background(), client)
+	if err != nil || saved != 0 {
+		t.Fatalf("shrinkGuestMemory = %d, %v; want 0, nil", saved, err)
+	}
+	for _, c := range rec.Commands() {
+		if c.Execute == "balloon" {
+			t.Fatal("balloon issued without a balloon device")
+		}
+	}
+}
+
+func TestShrinkGuestMemory_UnresponsiveGuestResetsTarget(t *testing.T) {
+	// Not parallel: shortens the package's balloon timeouts.
+	defer func(p, s time.Duration) { balloonPollInterval, balloonStallTimeout = p, s }(balloonPollInterval, balloonStallTimeout)
+	balloonPollInterval, balloonStallTimeout = 10*time.Millisecond, 50*time.Millisecond
+	client, rec := fakeBalloonQMP(t, false)
+
+	saved, err := shrinkGuestMemory(context.Background(), client)
+	if err != nil || saved != 0 {
+		t.Fatalf("shrinkGuestMemory = %d, %v; want 0, nil", saved, err)
+	}
+	var targets []int64
+	for _, c := range rec.Commands() {
+		if c.Execute == "balloon" {
+			var args qmp.BalloonArgs
+			decodeRecordedArgs(t, c, &args)
+			targets = append(targets, args.Value)
+		}
+	}
+	if len(targets) != 2 || targets[1] != 4*gib {
+		t.Fatalf("balloon targets = %v, want a reset to %d", targets, int64(4*gib))
+	}
+}
+
+func TestRestoreGuestMemory(t *testing.T) {
+	t.Parallel()
+	client, rec := fakeBalloonQMP(t, true)
+	if _, err := client.Execute(context.Background(), "balloon", qmp.BalloonArgs{Value: 2 * gib}); err != nil {
+		t.Fatalf("balloon: %v", err)
+	}
+	if _, err := queryBalloon(context.Background(), client); err != nil {
+		t.Fatalf("query-balloon: %v", err)
+	}
+
+	if err := restoreGuestMemory(context.Background(), client); err != nil {
+		t.Fatalf("restoreGuestMemory: %v", err)
+	}
+	commands := rec.Commands()
+	var last qmp.BalloonArgs
+	for _, c := range commands {
+		if c.Execute == "balloon" {
+			decodeRecordedArgs(t, c, &last)
+		}
+	}
+	if last.Value != 4*gib {
+		t.Errorf("deflate target = %d, want %d", last.Value, int64(4*gib))
+	}
+}
+
+func TestAvailableFromStats(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name  string
+		stats map[string]uint64
+		want  int64
+		ok    bool
+	}{
+		{"available", map[string]uint64{"stat-available-memory": 100, "stat-free-memory": 10}, 100, true},
+		{"free plus caches", map[string]uint64{"stat-available-memory": balloonStatsUnset, "stat-free-memory": 10, "stat-disk-caches": 5}, 15, true},
+		{"free only", map[string]uint64{"stat-free-memory": 10, "stat-disk-caches": balloonStatsUnset}, 10, true},
+		{"none", map[string]uint64{"stat-free-memory": balloonStatsUnset}, 0, false},
+	} {
+		got, ok := availableFromStats(tc.stats)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: availableFromStats = %d, %t; want %d, %t", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
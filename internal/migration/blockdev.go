@@ -0,0 +1,145 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// nbdTargetNodePrefix names the NBD client node the source adds per drive
+// as its blockdev-mirror target.
+const nbdTargetNodePrefix = "katamaran-nbd-"
+
+// nbdReconnectDelay is the reconnect-delay, in seconds, of the source's
+// NBD client nodes.
+const nbdReconnectDelay = 10
+
+// blockTarget is how one configured drive is mirrored and exported.
+type blockTarget struct {
+	ID   string // configured drive ID; also the NBD export name
+	Node string // root node name of a -blockdev drive; empty for legacy -drive
+}
+
+// resolveBlockTargets maps drive IDs onto the block graph. A drive with a
+// BlockBackend name (-drive) keeps the legacy drive-mirror / nbd-server-add
+// path; one only reachable by node-name or qdev ID (-blockdev, no backend
+// name) is migrated with blockdev-mirror / block-export-add on its root
+// node. When query-block is unavailable or unparseable every drive is
+// treated as legacy, which is what older QEMUs need anyway.
+func resolveBlockTargets(ctx context.Context, client *qmp.Client, ids []string) []blockTarget {
+	targets := make([]blockTarget, len(ids))
+	for i, id := range ids {
+		targets[i] = blockTarget{ID: id}
+	}
+	raw, err := client.Execute(ctx, "query-block", nil)
+	if err != nil {
+		slog.Debug("query-block failed; using legacy drive-mirror for all drives", "error", err)
+		return targets
+	}
+	var blocks []qmp.BlockInfo
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		slog.Debug("query-block response not decodable; using legacy drive-mirror for all drives", "error", err)
+		return targets
+	}
+	for i, t := range targets {
+		targets[i].Node = rootNodeFor(blocks, t.ID)
+		if targets[i].Node != "" {
+			slog.Info("Drive is a -blockdev node; using blockdev-mirror", "drive_id", t.ID, "node_name", targets[i].Node)
+		}
+	}
+	return targets
+}
+
+// rootNodeFor returns the root node name backing id, or "" when id is a
+// BlockBackend name (or unknown, left for drive-mirror to report).
+func rootNodeFor(blocks []qmp.BlockInfo, id string) string {
+	for _, b := range blocks {
+		if b.Device == id {
+			return ""
+		}
+	}
+	for _, b := range blocks {
+		if b.Device != "" || b.Inserted == nil || b.Inserted.NodeName == "" {
+			continue
+		}
+		if b.Inserted.NodeName == id || qdevID(b.Qdev) == id {
+			return b.Inserted.NodeName
+		}
+	}
+	return ""
+}
+
+// qdevID returns the device ID of a qdev path such as
+// /machine/peripheral/vol1/virtio-backend, or qdev itself when it is not
+// a peripheral path.
+func qdevID(qdev string) string {
+	rest, ok := strings.CutPrefix(qdev, "/machine/peripheral/")
+	if !ok {
+		return qdev
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
+
+// isCommandNotFound reports whether err is QMP's CommandNotFound error.
+func isCommandNotFound(err error) bool {
+	var qerr *qmp.Error
+	return errors.As(err, &qerr) && qerr.Class == "CommandNotFound"
+}
+
+// addNBDClientNode adds an NBD client node named node for export on the
+// destination at destIP:port. The client reconnects on its own when the
+// connection drops; I/O waits up to nbdReconnectDelay for it before
+// failing, which pauses a mirror running with on-target-error=stop.
+func addNBDClientNode(ctx context.Context, client *qmp.Client, node string, destIP netip.Addr, port, export string) error {
+	if _, err := client.Execute(ctx, "blockdev-add", qmp.BlockdevAddNBDArgs{
+		Driver:         "nbd",
+		NodeName:       node,
+		Server:         qmp.InetSocketAddress{Type: "inet", Host: destIP.Unmap().String(), Port: port},
+		Export:         export,
+		ReconnectDelay: nbdReconnectDelay,
+	}); err != nil {
+		return fmt.Errorf("adding NBD client node %s: %w", node, err)
+	}
+	return nil
+}
+
+// deleteBlockNodes removes nodes with blockdev-del, best effort.
+func deleteBlockNodes(ctx context.Context, client *qmp.Client, nodes ...string) {
+	for _, n := range nodes {
+		if _, err := client.Execute(ctx, "blockdev-del", qmp.BlockdevDelArgs{NodeName: n}); err != nil {
+			slog.Warn("Failed to delete NBD target node", "node_name", n, "error", err)
+		}
+	}
+}
+
+// addNBDExport exports t on the running NBD server under the name t.ID,
+// with block-export-add for node-name drives and nbd-server-add otherwise
+// or when the QEMU predates block-export-add.
+func addNBDExport(ctx context.Context, client *qmp.Client, t blockTarget, writable bool) error {
+	if t.Node != "" {
+		_, err := client.Execute(ctx, "block-export-add", qmp.BlockExportAddArgs{
+			Type:     "nbd",
+			ID:       "export-" + t.ID,
+			NodeName: t.Node,
+			Name:     t.ID,
+			Writable: writable,
+		})
+		if !isCommandNotFound(err) {
+			return err
+		}
+		slog.Info("block-export-add not supported; falling back to nbd-server-add", "drive_id", t.ID)
+	}
+	args := qmp.NBDServerAddArgs{Device: t.ID, Writable: writable}
+	if t.Node != "" {
+		args = qmp.NBDServerAddArgs{Device: t.Node, Name: t.ID, Writable: writable}
+	}
+	_, err := client.Execute(ctx, "nbd-server-add", args)
+	return err
+}
@@ -0,0 +1,115 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// blockdevQMP serves a mixed block graph: a legacy -drive disk, a
+// -blockdev volume addressed by node-name and one addressed by qdev ID.
+// block-export-add is answered with CommandNotFound when noExportAdd is
+// set, as on QEMU < 5.2.
+func blockdevQMP(t *testing.T, noExportAdd bool) (*qmp.Client, *qmpRecorder) {
+	t.Helper()
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-block":
+			return `{"return":[` +
+				`{"device":"drive-virtio-disk0","inserted":{"file":"/var/lib/kata/disk0.img","drv":"raw"}},` +
+				`{"device":"","inserted":{"file":"/var/lib/kata/vol1.img","node-name":"drive-vol1","drv":"raw"}},` +
+				`{"device":"","qdev":"/machine/peripheral/vol2/virtio-backend","inserted":{"file":"/var/lib/kata/vol2.qcow2","node-name":"#block512","drv":"qcow2"}}` +
+				`]}`
+		case "block-export-add":
+			if noExportAdd {
+				return `{"error":{"class":"CommandNotFound","desc":"The command block-export-add has not been found"}}`
+			}
+		}
+		return `{"return":{}}`
+	})
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, rec
+}
+
+func TestResolveBlockTargets(t *testing.T) {
+	t.Parallel()
+	client, _ := blockdevQMP(t, false)
+
+	got := resolveBlockTargets(context.Background(), client, []string{"drive-virtio-disk0", "drive-vol1", "vol2"})
+	want := []blockTarget{
+		{ID: "drive-virtio-disk0"},
+		{ID: "drive-vol1", Node: "drive-vol1"},
+		{ID: "vol2", Node: "#block512"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets = %+v, want %+v", got, want)
+	}
+}
+
+func TestAddNBDExport(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name        string
+		noExportAdd bool
+		target      blockTarget
+		wantCmds    []string
+		wantAdd     *qmp.NBDServerAddArgs
+	}{
+		{
+			name:     "legacy drive",
+			target:   blockTarget{ID: "drive-virtio-disk0"},
+			wantCmds: []string{"nbd-server-add"},
+			wantAdd:  &qmp.NBDServerAddArgs{Device: "drive-virtio-disk0", Writable: true},
+		},
+		{
+			name:     "node-name drive",
+			target:   blockTarget{ID: "vol2", Node: "#block512"},
+			wantCmds: []string{"block-export-add"},
+		},
+		{
+			name:        "node-name drive on old QEMU",
+			noExportAdd: true,
+			target:      blockTarget{ID: "vol2", Node: "#block512"},
+			wantCmds:    []string{"block-export-add", "nbd-server-add"},
+			wantAdd:     &qmp.NBDServerAddArgs{Device: "#block512", Name: "vol2", Writable: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client, rec := blockdevQMP(t, tc.noExportAdd)
+			if err := addNBDExport(context.Background(), client, tc.target, true); err != nil {
+				t.Fatalf("addNBDExport: %v", err)
+			}
+			commands := rec.Commands()
+			var got []string
+			for _, c := range commands {
+				got = append(got, c.Execute)
+			}
+			if !reflect.DeepEqual(got, tc.wantCmds) {
+				t.Fatalf("commands = %v, want %v", got, tc.wantCmds)
+			}
+			if tc.wantAdd != nil {
+				var add qmp.NBDServerAddArgs
+				decodeRecordedArgs(t, findRecordedCommand(t, commands, "nbd-server-add"), &add)
+				if add != *tc.wantAdd {
+					t.Errorf("nbd-server-add = %+v, want %+v", add, *tc.wantAdd)
+				}
+			}
+			if tc.target.Node != "" && !tc.noExportAdd {
+				var exp qmp.BlockExportAddArgs
+				decodeRecordedArgs(t, findRecordedCommand(t, commands, "block-export-add"), &exp)
+				want := qmp.BlockExportAddArgs{Type: "nbd", ID: "export-vol2", NodeName: "#block512", Name: "vol2", Writable: true}
+				if exp != want {
+					t.Errorf("block-export-add = %+v, want %+v", exp, want)
+				}
+			}
+		})
+	}
+}
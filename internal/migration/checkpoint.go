@@ -0,0 +1,476 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// A checkpoint bundle is a directory holding everything RunRestore needs
+// to bring a VM back, on this node or another:
+//
+//	manifest.json          checkpointManifest, written last
+//	cmdline                QEMU argv, one arg per line (as --emit-cmdline-to)
+//	cmdline.devices.json   hot-plugged device replay plan
+//	vmconfig.json          Kata VMConfig from persist.json, when found
+//	state                  RAM and device state (migrate to file:)
+//	disks/<drive-id>.raw   raw image of each drive
+//
+// A directory without manifest.json is an incomplete checkpoint.
+const (
+	checkpointManifestFile = "manifest.json"
+	checkpointCmdlineFile  = "cmdline"
+	checkpointVMConfigFile = "vmconfig.json"
+	checkpointStateFile    = "state"
+	checkpointDisksDir     = "disks"
+
+	// checkpointVersion is bumped on incompatible bundle layout changes.
+	checkpointVersion = 1
+
+	// checkpointNodePrefix names the blockdev-backup target node of a
+	// -blockdev drive.
+	checkpointNodePrefix = "katamaran-checkpoint-"
+)
+
+// checkpointManifest describes a bundle. Paths are relative to the bundle
+// directory.
+type checkpointManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Sandbox   string    `json:"sandbox,omitempty"`
+	Cmdline   string    `json:"cmdline"`
+	Devices   string    `json:"devices,omitempty"`
+	VMConfig  string    `json:"vm_config,omitempty"`
+	State     string    `json:"state"`
+	RAMTotal  int64     `json:"ram_total"`
+	// SharedStorage bundles carry no disk images: the VM is restored
+	// against the disks it had at checkpoint time.
+	SharedStorage bool              `json:"shared_storage"`
+	Drives        []checkpointDrive `json:"drives"`
+}
+
+// checkpointDrive is one drive image in a bundle.
+type checkpointDrive struct {
+	ID string `json:"id"`
+	// Node is the root node name of a -blockdev drive.
+	Node string `json:"node,omitempty"`
+	// Source is the image the drive had at checkpoint time; the restored
+	// QEMU's cmdline and device plan are rewritten from it to a copy of
+	// Image.
+	Source      string `json:"source"`
+	Image       string `json:"image"`
+	Format      string `json:"format"`
+	VirtualSize int64  `json:"virtual_size"`
+}
+
+// RunCheckpoint writes a checkpoint bundle of a running VM to cfg.OutDir.
+// It captures the QEMU cmdline, the hot-plugged device plan and the Kata
+// VMConfig, then pauses the VM, backs up its drives (drive-backup, or
+// blockdev-backup for -blockdev drives), saves RAM and device state with
+// migrate to a file: URI, and resumes the VM. The pause covers the disk
+// and state copy, so the bundle is consistent. It prints
+//
+//	KATAMARAN_CHECKPOINT dir=<dir> drives=<n> ram_total=<bytes> paused_ms=<ms>
+//
+// A failed checkpoint resumes the VM and removes the bundle directory; a
+// failure to resume after a complete checkpoint keeps the bundle.
+func RunCheckpoint(ctx context.Context, cfg CheckpointConfig) (retErr error) {
+	if cfg.OutDir == "" {
+		return errors.New("checkpoint requires an output directory")
+	}
+	outDir, err := filepath.Abs(cfg.OutDir)
+	if err != nil {
+		return fmt.Errorf("resolve output directory: %w", err)
+	}
+	var pid int
+	var sandbox string
+	if cfg.PodName != "" {
+		ip, err := lookupPodIP(ctx, cfg.PodNamespace, cfg.PodName)
+		if err != nil {
+			return fmt.Errorf("lookup pod IP: %w", err)
+		}
+		res, err := resolveSandbox(sandboxRoot, procImpl, ip)
+		if err != nil {
+			return fmt.Errorf("resolve sandbox: %w", err)
+		}
+		if cfg.QMPSocket == "" || cfg.QMPSocket == "/run/vc/vm/extra-monitor.sock" {
+			cfg.QMPSocket = filepath.Join(sandboxRoot, res.Sandbox, "extra-monitor.sock")
+		}
+		pid, sandbox = res.PID, res.Sandbox
+	} else {
+		if pid, err = qemuPIDForSocket(cfg.QMPSocket); err != nil {
+			return err
+		}
+		sandbox = filepath.Base(filepath.Dir(cfg.QMPSocket))
+	}
+	if !cfg.SharedStorage && !isAutoDriveIDs(cfg.DriveIDs) {
+		if err := validateDriveIDs(cfg.DriveIDs); err != nil {
+			return fmt.Errorf("validating drive IDs: %w", err)
+		}
+	}
+
+	if err := createBundleDir(outDir); err != nil {
+		return err
+	}
+	complete := false
+	defer func() {
+		if retErr != nil && !complete {
+			if err := os.RemoveAll(outDir); err != nil {
+				slog.Warn("Failed to remove incomplete checkpoint", "dir", outDir, "error", err)
+			}
+		}
+	}()
+	start := time.Now()
+	slog.Info("Starting checkpoint", "qmp_socket", cfg.QMPSocket, "qemu_pid", pid, "dir", outDir)
+
+	manifest := checkpointManifest{
+		Version:       checkpointVersion,
+		Sandbox:       sandbox,
+		Cmdline:       checkpointCmdlineFile,
+		State:         checkpointStateFile,
+		SharedStorage: cfg.SharedStorage,
+		Drives:        []checkpointDrive{},
+	}
+	cmdlinePath := filepath.Join(outDir, checkpointCmdlineFile)
+	if err := captureSourceCmdline(pid, cmdlinePath); err != nil {
+		return fmt.Errorf("capture QEMU cmdline: %w", err)
+	}
+	args, err := readCmdlineFile(cmdlinePath)
+	if err != nil {
+		return err
+	}
+	if vmCfg, _, _ := sandboxVMConfig(pid); vmCfg != nil {
+		if err := os.WriteFile(filepath.Join(outDir, checkpointVMConfigFile), vmCfg, 0o600); err != nil {
+			return fmt.Errorf("write VMConfig: %w", err)
+		}
+		manifest.VMConfig = checkpointVMConfigFile
+	}
+
+	client, err := qmp.NewClient(ctx, cfg.QMPSocket)
+	if err != nil {
+		return fmt.Errorf("connecting to QMP: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close QMP client", "error", err)
+		}
+	}()
+	inv, err := captureDeviceInventory(ctx, client)
+	if err != nil {
+		return fmt.Errorf("capture device inventory: %w", err)
+	}
+	steps, err := planDeviceReplay(inv, args)
+	if err != nil {
+		return fmt.Errorf("plan device replay: %w", err)
+	}
+	if len(steps) > 0 {
+		if err := writeJSONFile(devicePlanPath(cmdlinePath), steps); err != nil {
+			return err
+		}
+		manifest.Devices = filepath.Base(devicePlanPath(cmdlinePath))
+	}
+
+	driveIDs := cfg.DriveIDs
+	if cfg.SharedStorage {
+		driveIDs = nil
+	} else if isAutoDriveIDs(driveIDs) {
+		drives, err := discoverDrives(ctx, client)
+		if err != nil {
+			return fmt.Errorf("discovering drives: %w", err)
+		}
+		driveIDs = drivesOf(drives, driveLocal)
+	}
+
+	if _, err := client.Execute(ctx, "stop", nil); err != nil {
+		return fmt.Errorf("pausing VM: %w", err)
+	}
+	stoppedAt := time.Now()
+	slog.Info("VM paused for checkpoint")
+	defer func() {
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		if _, err := client.Execute(cctx, "cont", nil); err != nil {
+			slog.Error("Failed to resume VM after checkpoint", "error", err)
+			retErr = errors.Join(retErr, fmt.Errorf("resuming VM: %w", err))
+			return
+		}
+		slog.Info("VM resumed", "paused", time.Since(stoppedAt).Round(time.Millisecond))
+	}()
+
+	if len(driveIDs) > 0 {
+		if err := os.Mkdir(filepath.Join(outDir, checkpointDisksDir), 0o700); err != nil {
+			return fmt.Errorf("create disks directory: %w", err)
+		}
+		if manifest.Drives, err = backupDrives(ctx, client, outDir, driveIDs); err != nil {
+			return err
+		}
+	}
+
+	info, err := saveVMState(ctx, client, filepath.Join(outDir, checkpointStateFile))
+	if err != nil {
+		return err
+	}
+	paused := time.Since(stoppedAt)
+	manifest.RAMTotal = info.RAM.Total
+	manifest.CreatedAt = stoppedAt.UTC()
+	if err := writeJSONFile(filepath.Join(outDir, checkpointManifestFile), manifest); err != nil {
+		return err
+	}
+	complete = true
+	markerf(ctx, "KATAMARAN_CHECKPOINT dir=%s drives=%d ram_total=%d paused_ms=%d\n",
+		outDir, len(manifest.Drives), manifest.RAMTotal, paused.Milliseconds())
+	slog.Info("Checkpoint written", "dir", outDir, "drives", len(manifest.Drives),
+		"paused", paused.Round(time.Millisecond), "elapsed", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// qemuPIDForSocket returns the PID of the QEMU behind a sandbox QMP
+// socket: from the pid file QEMU writes next to it, else by looking up
+// the sandbox directory's QEMU process.
+func qemuPIDForSocket(socket string) (int, error) {
+	pidPath := filepath.Join(filepath.Dir(socket), "pid")
+	if raw, err := os.ReadFile(pidPath); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil && pid > 0 {
+			return pid, nil
+		}
+	}
+	pid, err := procImpl.PIDForSandbox(filepath.Base(filepath.Dir(socket)))
+	if err != nil {
+		return 0, fmt.Errorf("find QEMU PID for %s (no usable %s): %w", socket, pidPath, err)
+	}
+	return pid, nil
+}
+
+// createBundleDir creates dir, or accepts an existing empty one.
+func createBundleDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("create checkpoint directory: %w", err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("read checkpoint directory: %w", err)
+	case len(entries) > 0:
+		return fmt.Errorf("checkpoint directory %s is not empty", dir)
+	}
+	return nil
+}
+
+// backupDrives copies each drive of the paused VM into the bundle's disks
+// directory as a raw image. Legacy -drive backends use drive-backup,
+// which creates the image; -blockdev drives get a pre-sized file added as
+// a node and filled with blockdev-backup.
+func backupDrives(ctx context.Context, client *qmp.Client, outDir string, ids []string) ([]checkpointDrive, error) {
+	raw, err := client.Execute(ctx, "query-block", nil)
+	if err != nil {
+		return nil, fmt.Errorf("query-block: %w", err)
+	}
+	var blocks []qmp.BlockInfo
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("decode query-block: %w", err)
+	}
+	drives := make([]checkpointDrive, 0, len(ids))
+	for _, t := range resolveBlockTargets(ctx, client, ids) {
+		in := insertedMedium(blocks, t)
+		if in == nil {
+			return nil, fmt.Errorf("drive %q not found in query-block", t.ID)
+		}
+		d := checkpointDrive{
+			ID:          t.ID,
+			Node:        t.Node,
+			Source:      in.File,
+			Image:       filepath.Join(checkpointDisksDir, t.ID+".raw"),
+			Format:      "raw",
+			VirtualSize: in.Image.VirtualSize,
+		}
+		if err := backupDrive(ctx, client, t, filepath.Join(outDir, d.Image), d.VirtualSize); err != nil {
+			return nil, err
+		}
+		slog.Info("Drive backed up", "drive_id", t.ID, "bytes", d.VirtualSize)
+		drives = append(drives, d)
+	}
+	return drives, nil
+}
+
+// insertedMedium returns the medium of t from query-block, or nil.
+func insertedMedium(blocks []qmp.BlockInfo, t blockTarget) *qmp.BlockDeviceInfo {
+	for _, b := range blocks {
+		if b.Inserted == nil {
+			continue
+		}
+		if t.Node == "" && b.Device == t.ID || t.Node != "" && b.Inserted.NodeName == t.Node {
+			return b.Inserted
+		}
+	}
+	return nil
+}
+
+// backupDrive runs one full backup job of t into path and waits for it.
+func backupDrive(ctx context.Context, client *qmp.Client, t blockTarget, path string, size int64) error {
+	jobID := "checkpoint-" + t.ID
+	if t.Node == "" {
+		if _, err := client.Execute(ctx, "drive-backup", qmp.DriveBackupArgs{
+			JobID:  jobID,
+			Device: t.ID,
+			Target: path,
+			Format: "raw",
+			Sync:   "full",
+			Mode:   "absolute-paths",
+		}); err != nil {
+			return fmt.Errorf("starting backup of %s: %w", t.ID, err)
+		}
+	} else {
+		if err := createSparseFile(path, size); err != nil {
+			return err
+		}
+		node := checkpointNodePrefix + t.ID
+		if _, err := client.Execute(ctx, "blockdev-add", qmp.BlockdevAddFileArgs{
+			Driver:   "raw",
+			NodeName: node,
+			File:     qmp.BlockdevFileNode{Driver: "file", Filename: path},
+		}); err != nil {
+			return fmt.Errorf("adding backup target node for %s: %w", t.ID, err)
+		}
+		defer func() {
+			cctx, ccancel := cleanupCtx(ctx)
+			defer ccancel()
+			deleteBlockNodes(cctx, client, node)
+		}()
+		if _, err := client.Execute(ctx, "blockdev-backup", qmp.BlockdevBackupArgs{
+			JobID:  jobID,
+			Device: t.Node,
+			Target: node,
+			Sync:   "full",
+		}); err != nil {
+			return fmt.Errorf("starting backup of %s: %w", t.ID, err)
+		}
+	}
+	if err := waitForBackupJob(ctx, client, jobID); err != nil {
+		return fmt.Errorf("backup of %s: %w", t.ID, err)
+	}
+	if _, err := client.Execute(ctx, "block-job-dismiss", qmp.BlockJobDismissArgs{ID: jobID}); err != nil {
+		slog.Warn("Failed to dismiss backup job", "job_id", jobID, "error", err)
+	}
+	return nil
+}
+
+// saveVMState migrates the paused VM's state into path and returns the
+// final query-migrate. Multifd is turned off: QEMU only combines it with
+// file: migration in the mapped-ram format, which restore does not set.
+func saveVMState(ctx context.Context, client *qmp.Client, path string) (qmp.MigrateInfo, error) {
+	if _, err := client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
+		Capabilities: []qmp.MigrationCapability{{Capability: "multifd", State: false}},
+	}); err != nil {
+		return qmp.MigrateInfo{}, fmt.Errorf("setting migration capabilities: %w", err)
+	}
+	uri := "file:" + path
+	if _, err := client.Execute(ctx, "migrate", qmp.MigrateArgs{URI: uri}); err != nil {
+		return qmp.MigrateInfo{}, fmt.Errorf("saving VM state to %s: %w", path, err)
+	}
+	info, err := waitForFileMigration(ctx, client)
+	if err != nil {
+		return info, fmt.Errorf("saving VM state: %w", err)
+	}
+	return info, nil
+}
+
+// waitForFileMigration polls query-migrate until a migration to or from
+// a file ends. Unlike waitForMigrationComplete it never takes a stalled
+// monitor for success: no shim tears this QEMU down.
+func waitForFileMigration(ctx context.Context, client *qmp.Client) (qmp.MigrateInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, migrationTimeout)
+	defer cancel()
+	ticker := time.NewTicker(migrationPollInterval)
+	defer ticker.Stop()
+	for {
+		raw, err := client.Execute(ctx, "query-migrate", nil)
+		if err != nil {
+			return qmp.MigrateInfo{}, fmt.Errorf("query-migrate: %w", err)
+		}
+		var info qmp.MigrateInfo
+		if err := json.Unmarshal(raw, &info); err != nil {
+			return info, fmt.Errorf("unmarshaling migration status: %w", err)
+		}
+		if terminal, err := migrationTerminalError(info.Status, info.ErrorDesc); terminal {
+			return info, err
+		}
+		select {
+		case <-ctx.Done():
+			return info, fmt.Errorf("migration: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// createSparseFile creates path as a sparse file of size bytes.
+func createSparseFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("size %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile writes v to path as indented JSON.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// readCheckpointManifest loads and checks the manifest of the bundle in
+// dir.
+func readCheckpointManifest(dir string) (checkpointManifest, error) {
+	var m checkpointManifest
+	data, err := os.ReadFile(filepath.Join(dir, checkpointManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, fmt.Errorf("%s is not a complete checkpoint (no %s)", dir, checkpointManifestFile)
+	}
+	if err != nil {
+		return m, fmt.Errorf("read checkpoint manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("decode checkpoint manifest: %w", err)
+	}
+	if m.Version != checkpointVersion {
+		return m, fmt.Errorf("checkpoint version %d is not supported (want %d)", m.Version, checkpointVersion)
+	}
+	paths := []string{m.Cmdline, m.State}
+	for _, rel := range []string{m.Devices, m.VMConfig} {
+		if rel != "" {
+			paths = append(paths, rel)
+		}
+	}
+	for _, d := range m.Drives {
+		paths = append(paths, d.Image)
+	}
+	for _, rel := range paths {
+		if !filepath.IsLocal(rel) {
+			return m, fmt.Errorf("checkpoint manifest path %q leaves the bundle", rel)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// checkpointQMP answers the queries RunCheckpoint makes for a VM with one
+// legacy drive. migrateStatus is the query-migrate status of the state
+// save.
+func checkpointQMP(t *testing.T, migrateStatus string) (string, *qmpRecorder) {
+	t.Helper()
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-pci", "query-hotpluggable-cpus", "query-memory-devices", "qom-list":
+			return `{"return":[]}`
+		case "query-block":
+			return `{"return":[{"device":"drive-virtio-disk0","inserted":{"file":"/dev/vdb","image":{"virtual-size":4096}}}]}`
+		case "query-block-jobs":
+			return `{"return":[{"device":"checkpoint-drive-virtio-disk0","len":4096,"offset":4096,"status":"concluded","type":"backup"}]}`
+		case "query-migrate":
+			return `{"return":{"status":"` + migrateStatus + `","ram":{"total":2048}}}`
+		}
+		return `{"return":{}}`
+	})
+	if err := os.WriteFile(filepath.Join(filepath.Dir(sock), "pid"), []byte("4242\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return sock, rec
+}
+
+func stubQEMUCmdline(t *testing.T) {
+	t.Helper()
+	orig := readQEMUCmdline
+	t.Cleanup(func() { readQEMUCmdline = orig })
+	readQEMUCmdline = func(int) ([]byte, error) {
+		return []byte("qemu-system-x86_64\x00-m\x002048M\x00-drive\x00file=/dev/vdb,if=none,id=drive-virtio-disk0\x00"), nil
+	}
+}
+
+func TestRunCheckpoint_WritesBundleAndResumesVM(t *testing.T) {
+	stubQEMUCmdline(t)
+	sock, rec := checkpointQMP(t, "completed")
+	out := filepath.Join(t.TempDir(), "ckpt")
+
+	err := RunCheckpoint(context.Background(), CheckpointConfig{
+		QMPSocket: sock,
+		OutDir:    out,
+		DriveIDs:  []string{"drive-virtio-disk0"},
+	})
+	if err != nil {
+		t.Fatalf("RunCheckpoint: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{
+		"stop", "drive-backup", "query-block-jobs", "block-job-dismiss",
+		"migrate-set-capabilities", "migrate", "query-migrate", "cont",
+	})
+	var backup qmp.DriveBackupArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "drive-backup"), &backup)
+	if want := filepath.Join(out, "disks", "drive-virtio-disk0.raw"); backup.Target != want || backup.Sync != "full" {
+		t.Errorf("drive-backup = %+v, want full backup to %s", backup, want)
+	}
+	var migrate qmp.MigrateArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate"), &migrate)
+	if want := "file:" + filepath.Join(out, "state"); migrate.URI != want {
+		t.Errorf("migrate uri = %q, want %q", migrate.URI, want)
+	}
+
+	m, err := readCheckpointManifest(out)
+	if err != nil {
+		t.Fatalf("readCheckpointManifest: %v", err)
+	}
+	if m.RAMTotal != 2048 || len(m.Drives) != 1 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if d := m.Drives[0]; d.Source != "/dev/vdb" || d.VirtualSize != 4096 || d.Image != "disks/drive-virtio-disk0.raw" {
+		t.Errorf("drive = %+v", d)
+	}
+	args, err := readCmdlineFile(filepath.Join(out, m.Cmdline))
+	if err != nil || len(args) != 5 || args[0] != "qemu-system-x86_64" {
+		t.Errorf("captured cmdline = %q, %v", args, err)
+	}
+}
+
+func TestRunCheckpoint_FailureRemovesBundleAndResumesVM(t *testing.T) {
+	stubQEMUCmdline(t)
+	sock, rec := checkpointQMP(t, "failed")
+	out := filepath.Join(t.TempDir(), "ckpt")
+
+	err := RunCheckpoint(context.Background(), CheckpointConfig{QMPSocket: sock, OutDir: out, SharedStorage: true})
+	if err == nil || !strings.Contains(err.Error(), "saving VM state") {
+		t.Fatalf("err = %v, want state save failure", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("incomplete bundle left behind: %v", err)
+	}
+	assertRecordedSubsequence(t, rec.Commands(), []string{"stop", "migrate", "cont"})
+}
+
+func TestRunCheckpoint_RefusesNonEmptyDirectory(t *testing.T) {
+	sandbox, out := t.TempDir(), t.TempDir()
+	for _, f := range []string{filepath.Join(sandbox, "pid"), filepath.Join(out, "keep")} {
+		if err := os.WriteFile(f, []byte("4242\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := RunCheckpoint(context.Background(), CheckpointConfig{QMPSocket: filepath.Join(sandbox, "qmp.sock"), OutDir: out, SharedStorage: true})
+	if err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("err = %v, want non-empty directory error", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "keep")); err != nil {
+		t.Errorf("existing file removed: %v", err)
+	}
+}
+
+func TestReadCheckpointManifestRejectsEscapingPaths(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	m := checkpointManifest{
+		Version: checkpointVersion,
+		Cmdline: checkpointCmdlineFile,
+		State:   checkpointStateFile,
+		Drives:  []checkpointDrive{{ID: "d0", Image: "../../etc/shadow"}},
+	}
+	if err := writeJSONFile(filepath.Join(dir, checkpointManifestFile), m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpointManifest(dir); err == nil || !strings.Contains(err.Error(), "leaves the bundle") {
+		t.Fatalf("err = %v, want path rejection", err)
+	}
+
+	m.Drives, m.Version = nil, checkpointVersion+1
+	if err := writeJSONFile(filepath.Join(dir, checkpointManifestFile), m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpointManifest(dir); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("err = %v, want version rejection", err)
+	}
+}
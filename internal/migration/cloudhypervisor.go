@@ -0,0 +1,213 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// chRequestTimeout bounds the Cloud Hypervisor API calls that return
+// immediately. The migration calls are bounded by their context only.
+const chRequestTimeout = 30 * time.Second
+
+// chHypervisor implements Hypervisor for Cloud Hypervisor, driving its HTTP
+// API on its unix socket: vm.send-migration on the source,
+// vm.receive-migration on the destination. Both requests are answered
+// only once the migration has ended, so they run in the background and
+// their result is the migration's.
+//
+// Cloud Hypervisor has no storage migration, no migration progress or
+// cancel endpoint, no pause event and no announce command; the
+// corresponding methods say so or fall back as documented.
+type chHypervisor struct {
+	http *http.Client
+
+	// result delivers the outcome of the in-flight migration request.
+	result  chan error
+	err     error
+	done    bool
+	started time.Time
+	ended   time.Time
+}
+
+func newCHHypervisor(socket string) *chHypervisor {
+	return &chHypervisor{http: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}}
+}
+
+// chVMInfo is the part of the vm.info response katamaran reads.
+type chVMInfo struct {
+	State string `json:"state"`
+}
+
+// call sends one API request to endpoint (e.g. "vm.info"), with body as
+// JSON when non-nil. A non-nil out receives the decoded response.
+func (c *chHypervisor) call(ctx context.Context, method, endpoint string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode %s request: %w", endpoint, err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost/api/v1/"+endpoint, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s: read response: %w", endpoint, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s: %s", endpoint, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%s: decode response: %w", endpoint, err)
+		}
+	}
+	return nil
+}
+
+func (c *chHypervisor) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, chRequestTimeout)
+	defer cancel()
+	return c.call(ctx, http.MethodGet, "vmm.ping", nil, nil)
+}
+
+// start runs the migration request in the background.
+func (c *chHypervisor) start(ctx context.Context, endpoint string, body any) {
+	c.result = make(chan error, 1)
+	c.started = time.Now()
+	go func() { c.result <- c.call(ctx, http.MethodPut, endpoint, body, nil) }()
+}
+
+// wait returns the outcome of the migration request once it has one.
+func (c *chHypervisor) wait(ctx context.Context) error {
+	if c.result == nil {
+		return errors.New("no migration in progress")
+	}
+	if !c.done {
+		select {
+		case c.err = <-c.result:
+			c.done, c.ended = true, time.Now()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.err
+}
+
+func (c *chHypervisor) SyncStorage(context.Context, SourceConfig) error {
+	return errors.New("cloud-hypervisor migrates no storage: use shared storage")
+}
+
+func (c *chHypervisor) StopStorageSync(context.Context) {}
+
+func (c *chHypervisor) StartMigration(ctx context.Context, cfg SourceConfig, downtimeLimitMS int) error {
+	if cfg.MultifdChannels > 0 {
+		slog.Info("Cloud Hypervisor migrates over a single connection; ignoring multifd channels", "channels", cfg.MultifdChannels)
+	}
+	slog.Debug("Cloud Hypervisor has no downtime limit; ignoring it", "downtime_ms", downtimeLimitMS)
+	url := fmt.Sprintf("tcp:%s:%s", formatQEMUHost(cfg.DestIP), portOr(cfg.MigrationPort, ramMigrationPort))
+	c.start(ctx, "vm.send-migration", map[string]any{"destination_url": url, "local": false})
+	return nil
+}
+
+// WaitPaused waits for the whole send: the API reports no pause, and
+// answers vm.send-migration only once the VM has been stopped and its
+// last state sent.
+func (c *chHypervisor) WaitPaused(ctx context.Context) error {
+	return c.wait(ctx)
+}
+
+func (c *chHypervisor) WaitComplete(ctx context.Context) error {
+	return c.wait(ctx)
+}
+
+// Progress reports the status and elapsed time; Cloud Hypervisor exposes
+// no transfer counters.
+func (c *chHypervisor) Progress(context.Context) (MigrationStats, error) {
+	if c.result == nil {
+		return MigrationStats{}, errors.New("no migration in progress")
+	}
+	stats := MigrationStats{Status: "active"}
+	end := time.Now()
+	if c.done {
+		stats.Status, end = "completed", c.ended
+		if c.err != nil {
+			stats.Status = "failed"
+		}
+	}
+	stats.TotalTimeMS = end.Sub(c.started).Milliseconds()
+	return stats, nil
+}
+
+func (c *chHypervisor) Cancel(context.Context) error {
+	return fmt.Errorf("cloud-hypervisor cannot cancel a migration: %w", errors.ErrUnsupported)
+}
+
+func (c *chHypervisor) Receive(ctx context.Context, cfg DestConfig, port string) error {
+	url := fmt.Sprintf("tcp:[::]:%s", port)
+	slog.Info("Opening incoming migration listener", "uri", url)
+	c.start(ctx, "vm.receive-migration", map[string]any{"receiver_url": url})
+	return nil
+}
+
+// WaitResumed waits for vm.receive-migration to finish and resumes the
+// received VM if it is still paused.
+func (c *chHypervisor) WaitResumed(ctx context.Context) error {
+	if err := c.wait(ctx); err != nil {
+		return fmt.Errorf("receiving migration: %w", err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, chRequestTimeout)
+	defer cancel()
+	var info chVMInfo
+	if err := c.call(cctx, http.MethodGet, "vm.info", nil, &info); err != nil {
+		return err
+	}
+	if info.State == "Paused" {
+		if err := c.call(cctx, http.MethodPut, "vm.resume", nil, nil); err != nil {
+			return fmt.Errorf("resuming received VM: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *chHypervisor) Announce(context.Context) error {
+	return fmt.Errorf("cloud-hypervisor cannot announce guest MACs: %w", errors.ErrUnsupported)
+}
+
+func (c *chHypervisor) ShrinkMemory(context.Context) (int64, error) {
+	return 0, fmt.Errorf("cloud-hypervisor balloon reduction is not implemented: %w", errors.ErrUnsupported)
+}
+
+func (c *chHypervisor) RestoreMemory(context.Context) error {
+	return fmt.Errorf("cloud-hypervisor balloon reduction is not implemented: %w", errors.ErrUnsupported)
+}
+
+func (c *chHypervisor) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
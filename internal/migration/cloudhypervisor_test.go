@@ -0,0 +1,209 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type chRequest struct {
+	Method, Endpoint string
+	Body             map[string]any
+}
+
+// fakeCH is an in-process Cloud Hypervisor API server on a unix socket.
+type fakeCH struct {
+	socket string
+
+	mu       sync.Mutex
+	requests []chRequest
+	state    string
+	// fail maps an endpoint to the error body it answers with (HTTP 500).
+	fail map[string]string
+}
+
+func startFakeCH(t *testing.T, state string) *fakeCH {
+	t.Helper()
+	f := &fakeCH{socket: filepath.Join(t.TempDir(), clhAPISocket), state: state, fail: map[string]string{}}
+	l, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(f.serve)}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return f
+}
+
+func (f *fakeCH) serve(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	req := chRequest{Method: r.Method, Endpoint: endpoint}
+	if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+		_ = json.Unmarshal(data, &req.Body)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if msg, ok := f.fail[endpoint]; ok {
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	switch endpoint {
+	case "vmm.ping":
+		_, _ = w.Write([]byte(`{"version":"v40.0"}`))
+	case "vm.info":
+		_, _ = w.Write([]byte(`{"state":"` + f.state + `"}`))
+	case "vm.resume":
+		f.state = "Running"
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeCH) Requests() []chRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]chRequest(nil), f.requests...)
+}
+
+func (f *fakeCH) endpoints() []string {
+	var out []string
+	for _, r := range f.Requests() {
+		out = append(out, r.Method+" "+r.Endpoint)
+	}
+	return out
+}
+
+func TestCHHypervisor_SendMigration(t *testing.T) {
+	t.Parallel()
+	f := startFakeCH(t, "Running")
+	ctx := context.Background()
+	hv, err := openHypervisor(ctx, HypervisorCloudHypervisor, f.socket)
+	if err != nil {
+		t.Fatalf("openHypervisor: %v", err)
+	}
+	defer hv.Close()
+
+	cfg := SourceConfig{DestIP: testDestIP, MigrationPort: 5555, MultifdChannels: 4}
+	if err := hv.StartMigration(ctx, cfg, 25); err != nil {
+		t.Fatalf("StartMigration: %v", err)
+	}
+	if err := hv.WaitPaused(ctx); err != nil {
+		t.Fatalf("WaitPaused: %v", err)
+	}
+	if err := hv.WaitComplete(ctx); err != nil {
+		t.Fatalf("WaitComplete: %v", err)
+	}
+	stats, err := hv.Progress(ctx)
+	if err != nil || stats.Status != "completed" {
+		t.Fatalf("Progress = %+v, %v", stats, err)
+	}
+	reqs := f.Requests()
+	last := reqs[len(reqs)-1]
+	if last.Method != http.MethodPut || last.Endpoint != "vm.send-migration" {
+		t.Fatalf("requests = %v", f.endpoints())
+	}
+	if last.Body["destination_url"] != "tcp:10.0.0.1:5555" || last.Body["local"] != false {
+		t.Errorf("send-migration body = %v", last.Body)
+	}
+	if err := hv.Cancel(ctx); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Cancel = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestCHHypervisor_SendMigrationError(t *testing.T) {
+	t.Parallel()
+	f := startFakeCH(t, "Running")
+	f.fail["vm.send-migration"] = "Error sending migration: connection refused"
+	ctx := context.Background()
+	hv, err := openHypervisor(ctx, HypervisorCloudHypervisor, f.socket)
+	if err != nil {
+		t.Fatalf("openHypervisor: %v", err)
+	}
+	defer hv.Close()
+
+	if err := hv.StartMigration(ctx, SourceConfig{DestIP: testDestIP}, 25); err != nil {
+		t.Fatalf("StartMigration: %v", err)
+	}
+	err = hv.WaitPaused(ctx)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("WaitPaused = %v, want API error", err)
+	}
+	if stats, _ := hv.Progress(ctx); stats.Status != "failed" {
+		t.Errorf("status = %q, want failed", stats.Status)
+	}
+}
+
+func TestCHHypervisor_ReceiveResumesPausedVM(t *testing.T) {
+	t.Parallel()
+	f := startFakeCH(t, "Paused")
+	ctx := context.Background()
+	hv, err := openHypervisor(ctx, HypervisorCloudHypervisor, f.socket)
+	if err != nil {
+		t.Fatalf("openHypervisor: %v", err)
+	}
+	defer hv.Close()
+
+	if err := hv.Receive(ctx, DestConfig{}, "4444"); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if err := hv.WaitResumed(ctx); err != nil {
+		t.Fatalf("WaitResumed: %v", err)
+	}
+	want := []string{"GET vmm.ping", "PUT vm.receive-migration", "GET vm.info", "PUT vm.resume"}
+	if got := f.endpoints(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+	if url := f.Requests()[1].Body["receiver_url"]; url != "tcp:[::]:4444" {
+		t.Errorf("receiver_url = %v", url)
+	}
+	if err := hv.Announce(ctx); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Announce = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestRunSource_CloudHypervisor(t *testing.T) {
+	t.Parallel()
+	f := startFakeCH(t, "Running")
+	err := RunSource(context.Background(), SourceConfig{
+		QMPSocket: f.socket, DestIP: testDestIP, VMIP: testVMIP,
+		SharedStorage: true, TunnelMode: TunnelModeNone, DowntimeLimitMS: 25,
+	})
+	if err != nil {
+		t.Fatalf("RunSource: %v", err)
+	}
+	if got := f.endpoints(); len(got) != 2 || got[1] != "PUT vm.send-migration" {
+		t.Fatalf("requests = %v", got)
+	}
+
+	err = RunSource(context.Background(), SourceConfig{
+		QMPSocket: f.socket, DestIP: testDestIP, VMIP: testVMIP,
+		DriveIDs: []string{"drive-virtio-disk0"}, TunnelMode: TunnelModeNone, DowntimeLimitMS: 25,
+	})
+	if err == nil || !strings.Contains(err.Error(), "use shared storage") {
+		t.Fatalf("RunSource without shared storage = %v", err)
+	}
+}
+
+func TestRunDestination_CloudHypervisor(t *testing.T) {
+	t.Parallel()
+	f := startFakeCH(t, "Running")
+	err := RunDestination(context.Background(), DestConfig{
+		QMPSocket: f.socket, SharedStorage: true, Hypervisor: HypervisorCloudHypervisor,
+	})
+	if err != nil {
+		t.Fatalf("RunDestination: %v", err)
+	}
+	want := []string{"GET vmm.ping", "PUT vm.receive-migration", "GET vm.info"}
+	if got := f.endpoints(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"log/slog"
@@ -45,6 +44,7 @@ const (
 	cmdlineMarker     = "KATAMARAN_CMDLINE_B64="
 	vmConfigMarker    = "KATAMARAN_VMCONFIG_B64="
 	agentConfigMarker = "KATAMARAN_AGENTCONFIG_B64="
+	devicesMarker     = "KATAMARAN_DEVICES_B64="
 )
 
 // podLogClient bundles the apiserver pod-log fetch parameters: the
@@ -66,31 +66,24 @@ func newPodLogClient(ref string) (*podLogClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	host, port, err := resolveAPIServerHostPort()
+	hc, base, token, err := newAPIServerClient()
 	if err != nil {
 		return nil, err
 	}
-	tokenBytes, err := os.ReadFile(tokenPath)
-	if err != nil {
-		return nil, fmt.Errorf("read service account token: %w", err)
-	}
-	token := strings.TrimSpace(string(tokenBytes))
-	caBytes, err := os.ReadFile(caPath)
+	return &podLogClient{client: hc, endpoint: podLogEndpoint(base, ns, pod), token: token, ns: ns, pod: pod}, nil
+}
+
+// newAPIServerClient returns an HTTP client trusting the in-cluster CA,
+// the apiserver base URL (https://host:port) and the service account
+// bearer token.
+func newAPIServerClient() (*http.Client, string, string, error) {
+	base, token, tlsCfg, err := apiServerCredentials()
 	if err != nil {
-		return nil, fmt.Errorf("read service account CA: %w", err)
-	}
-	pool := x509.NewCertPool()
-	if !pool.AppendCertsFromPEM(caBytes) {
-		return nil, fmt.Errorf("CA file %s did not contain any PEM certificates", caPath)
+		return nil, "", "", err
 	}
 	hc := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    pool,
-				MinVersion: tls.VersionTLS12,
-			},
-		},
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg},
 		// Refuse to follow redirects: the apiserver pod-log endpoint does
 		// not redirect on the happy path, and a redirect away from the
 		// in-cluster apiserver could divert the bearer token to an
@@ -99,12 +92,42 @@ func newPodLogClient(ref string) (*podLogClient, error) {
 			return http.ErrUseLastResponse
 		},
 	}
+	return hc, base, token, nil
+}
+
+// apiServerCredentials returns the apiserver base URL, the service account
+// bearer token and a TLS config trusting the in-cluster CA.
+func apiServerCredentials() (string, string, *tls.Config, error) {
+	host, port, err := resolveAPIServerHostPort()
+	if err != nil {
+		return "", "", nil, err
+	}
+	tokenBytes, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("read service account token: %w", err)
+	}
+	token := strings.TrimSpace(string(tokenBytes))
+	caBytes, err := os.ReadFile(caPath)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return "", "", nil, fmt.Errorf("CA file %s did not contain any PEM certificates", caPath)
+	}
+	tlsCfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return "https://" + net.JoinHostPort(host, port), token, tlsCfg, nil
+}
+
+// podLogEndpoint is the apiserver URL of the katamaran container's log.
+func podLogEndpoint(base, ns, pod string) string {
 	q := url.Values{}
 	q.Set("container", "katamaran")
 	q.Set("limitBytes", fmt.Sprint(maxPodLogScanBytes))
-	endpoint := fmt.Sprintf("https://%s/api/v1/namespaces/%s/pods/%s/log?%s",
-		net.JoinHostPort(host, port), url.PathEscape(ns), url.PathEscape(pod), q.Encode())
-	return &podLogClient{client: hc, endpoint: endpoint, token: token, ns: ns, pod: pod}, nil
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log?%s", base, url.PathEscape(ns), url.PathEscape(pod), q.Encode())
 }
 
 // fetchCmdlineFromPodLog retrieves the source QEMU cmdline that the
@@ -127,8 +150,9 @@ func newPodLogClient(ref string) (*podLogClient, error) {
 //     (5 min default), which always outlives a single migration.
 //
 // The fetch retries up to a 5-minute deadline because the source pod
-// may not have started by the time the dest is up.
-func fetchCmdlineFromPodLog(ctx context.Context, ref string) (string, error) {
+// may not have started by the time the dest is up. With key set the marker
+// must carry a valid MAC (see openMarker); a bad one fails immediately.
+func fetchCmdlineFromPodLog(ctx context.Context, ref string, key []byte) (string, error) {
 	pc, err := newPodLogClient(ref)
 	if err != nil {
 		return "", err
@@ -151,13 +175,10 @@ func fetchCmdlineFromPodLog(ctx context.Context, ref string) (string, error) {
 		markers, bytesScanned, err := scanPodLogMarkers(deadline, pc.client, pc.endpoint, pc.token, cmdlineMarker)
 		if err != nil {
 			logPodLogFetchRetry("pod-log fetch attempt failed", attempt, "error", err)
-		} else if b64 := markers[cmdlineMarker]; b64 != "" {
-			if len(b64) > maxMarkerB64Size {
-				return "", fmt.Errorf("KATAMARAN_CMDLINE_B64 marker too large: %d bytes (max %d)", len(b64), maxMarkerB64Size)
-			}
-			decoded, derr := base64.StdEncoding.DecodeString(b64)
+		} else if value := markers[cmdlineMarker]; value != "" {
+			decoded, derr := openMarker(key, cmdlineMarker, value)
 			if derr != nil {
-				return "", fmt.Errorf("decode KATAMARAN_CMDLINE_B64: %w", derr)
+				return "", derr
 			}
 			slog.Info("Decoded source QEMU cmdline from pod log", "attempt", attempt, "bytes", len(decoded))
 			return writeCmdlineTempFile(decoded)
@@ -195,8 +216,9 @@ func logPodLogMarkerMissing(attempt int, bytesScanned int64) {
 
 // fetchVMConfigFromPodLog retrieves the VMConfig emitted by the source
 // binary as KATAMARAN_VMCONFIG_B64 and KATAMARAN_AGENTCONFIG_B64 markers.
-// Returns nil slices if not found (best-effort, non-fatal).
-func fetchVMConfigFromPodLog(ctx context.Context, ref string) (vmConfig, agentConfig []byte) {
+// Returns nil slices if not found (best-effort, non-fatal). A marker that
+// fails the MAC check under key is dropped like a missing one.
+func fetchVMConfigFromPodLog(ctx context.Context, ref string, key []byte) (vmConfig, agentConfig []byte) {
 	pc, err := newPodLogClient(ref)
 	if err != nil {
 		slog.Warn("Cannot build pod-log client for VMConfig fetch", "ref", ref, "error", err)
@@ -212,22 +234,19 @@ func fetchVMConfigFromPodLog(ctx context.Context, ref string) (vmConfig, agentCo
 		}
 	}
 
-	decode := func(name, b64 string) []byte {
-		if b64 == "" {
-			return nil
-		}
-		if len(b64) > maxMarkerB64Size {
-			slog.Warn(name+" marker exceeds max size; ignoring", "size", len(b64), "max", maxMarkerB64Size)
+	decode := func(name, marker string) []byte {
+		value := markers[marker]
+		if value == "" {
 			return nil
 		}
-		decoded, err := base64.StdEncoding.DecodeString(b64)
+		decoded, err := openMarker(key, marker, value)
 		if err != nil {
-			slog.Warn("Failed to decode "+name+" marker from pod log", "ref", ref, "error", err)
+			slog.Warn("Rejected "+name+" marker from pod log", "ref", ref, "error", err)
 			return nil
 		}
 		return decoded
 	}
-	return decode("VMConfig", markers[vmConfigMarker]), decode("AgentConfig", markers[agentConfigMarker])
+	return decode("VMConfig", vmConfigMarker), decode("AgentConfig", agentConfigMarker)
 }
 
 // parsePodRef splits "<ns>/<name>" into its components and rejects
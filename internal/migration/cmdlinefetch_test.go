@@ -91,7 +91,7 @@ func TestFetchCmdlineFromPodLogWritesDecodedCmdline(t *testing.T) {
 		_, _ = fmt.Fprintf(w, "noise\nKATAMARAN_CMDLINE_B64=%s\n", base64.StdEncoding.EncodeToString(cmdline))
 	})
 
-	path, err := fetchCmdlineFromPodLog(context.Background(), "myns/mypod")
+	path, err := fetchCmdlineFromPodLog(context.Background(), "myns/mypod", nil)
 	if err != nil {
 		t.Fatalf("fetchCmdlineFromPodLog: %v", err)
 	}
@@ -122,7 +122,7 @@ func TestFetchVMConfigFromPodLogReadsMarkers(t *testing.T) {
 		_, _ = fmt.Fprintf(w, "KATAMARAN_VMCONFIG_B64=%s\n", base64.StdEncoding.EncodeToString(vmConfig))
 	})
 
-	gotVMConfig, gotAgentConfig := fetchVMConfigFromPodLog(context.Background(), "myns/mypod")
+	gotVMConfig, gotAgentConfig := fetchVMConfigFromPodLog(context.Background(), "myns/mypod", nil)
 	if !bytes.Equal(gotVMConfig, vmConfig) {
 		t.Fatalf("VMConfig = %s, want %s", gotVMConfig, vmConfig)
 	}
@@ -131,6 +131,59 @@ func TestFetchVMConfigFromPodLogReadsMarkers(t *testing.T) {
 	}
 }
 
+func TestFetchFromPodLogVerifiesHandoffMAC(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, handoffKeyMinBytes)
+	cmdline := []byte("/opt/kata/bin/qemu-system-x86_64\n-name\nsandbox-demo\n")
+	vmConfig := []byte(`{"HypervisorType":"qemu"}`)
+	var log string
+	setupAPIServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, log)
+	})
+
+	log = formatMarker(key, vmConfigMarker, vmConfig) + "\n" + formatMarker(key, cmdlineMarker, cmdline) + "\n"
+	path, err := fetchCmdlineFromPodLog(context.Background(), "myns/mypod", key)
+	if err != nil {
+		t.Fatalf("signed cmdline: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(path) })
+	if got, _ := fetchVMConfigFromPodLog(context.Background(), "myns/mypod", key); !bytes.Equal(got, vmConfig) {
+		t.Fatalf("signed VMConfig = %s, want %s", got, vmConfig)
+	}
+
+	// Same MAC, different payload.
+	tampered := formatMarker(key, cmdlineMarker, cmdline)
+	tampered = cmdlineMarker + base64.StdEncoding.EncodeToString([]byte("/bin/sh\n-c\n")) + tampered[strings.Index(tampered, handoffMACField):]
+	for name, line := range map[string]string{
+		"unsigned":    formatMarker(nil, cmdlineMarker, cmdline),
+		"tampered":    tampered,
+		"other key":   formatMarker(bytes.Repeat([]byte{8}, handoffKeyMinBytes), cmdlineMarker, cmdline),
+		"other label": cmdlineMarker + strings.TrimPrefix(formatMarker(key, devicesMarker, cmdline), devicesMarker),
+	} {
+		log = line + "\n" + formatMarker(nil, vmConfigMarker, vmConfig) + "\n"
+		if _, err := fetchCmdlineFromPodLog(context.Background(), "myns/mypod", key); err == nil {
+			t.Errorf("%s cmdline accepted", name)
+		}
+	}
+	if got, _ := fetchVMConfigFromPodLog(context.Background(), "myns/mypod", key); got != nil {
+		t.Errorf("unsigned VMConfig accepted: %s", got)
+	}
+}
+
+func TestParseHandoffKey(t *testing.T) {
+	t.Parallel()
+	if key, err := ParseHandoffKey(""); key != nil || err != nil {
+		t.Errorf("empty = %v, %v", key, err)
+	}
+	if key, err := ParseHandoffKey(strings.Repeat("ab", handoffKeyMinBytes) + "\n"); len(key) != handoffKeyMinBytes || err != nil {
+		t.Errorf("valid = %v, %v", key, err)
+	}
+	for _, s := range []string{"zz", strings.Repeat("ab", handoffKeyMinBytes-1)} {
+		if _, err := ParseHandoffKey(s); err == nil {
+			t.Errorf("ParseHandoffKey(%q) accepted", s)
+		}
+	}
+}
+
 func assertPodLogRequest(t *testing.T, r *http.Request, ns, pod string) {
 	t.Helper()
 
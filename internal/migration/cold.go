@@ -0,0 +1,246 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Cold migration (--mode cold) trades the live path's millisecond blackout
+// for one that is long but predictable, and works for VMs QEMU refuses to
+// live-migrate. The source pauses the VM, copies its disks over NBD while
+// nothing writes to them, and then either streams the paused VM's state
+// to the destination (ColdStateStream) or, when QEMU reports migration
+// blockers such as a VFIO device without migration support, sends no
+// state at all and the destination boots the copied disks
+// (ColdStateBoot). Either way the destination (--cold-from-job) runs the
+// VM only once the source has printed KATAMARAN_COLD_DONE.
+const (
+	ColdStateStream = "stream"
+	ColdStateBoot   = "boot"
+
+	// coldPlanMarker is printed by the source before it waits for the
+	// destination, so a destination spawning its own QEMU knows whether
+	// to expect an incoming migration:
+	//
+	//	KATAMARAN_COLD_PLAN state=<stream|boot> blockers=<n>
+	coldPlanMarker = "KATAMARAN_COLD_PLAN "
+
+	// coldDoneMarker is printed by the source once the disks (and, for
+	// ColdStateStream, the VM state) are on the destination.
+	coldDoneMarker = "KATAMARAN_COLD_DONE "
+)
+
+// queryMigrationBlockers returns the reasons QEMU would refuse a
+// migration of client's VM, from query-migrate's blocked-reasons.
+func queryMigrationBlockers(ctx context.Context, client *qmp.Client) ([]string, error) {
+	raw, err := client.Execute(ctx, "query-migrate", nil)
+	if err != nil {
+		return nil, fmt.Errorf("query-migrate: %w", err)
+	}
+	var info qmp.MigrateInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("decode query-migrate: %w", err)
+	}
+	return info.BlockedReasons, nil
+}
+
+// planColdMigration picks the cold migration state transfer for the VM
+// behind socket and prints the KATAMARAN_COLD_PLAN marker.
+func planColdMigration(ctx context.Context, socket string) (string, error) {
+	client, err := qmp.NewClient(ctx, socket)
+	if err != nil {
+		return "", fmt.Errorf("connecting to source QMP: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+	blockers, err := queryMigrationBlockers(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("checking migration blockers: %w", err)
+	}
+	state := ColdStateStream
+	if len(blockers) > 0 {
+		state = ColdStateBoot
+		slog.Warn("VM state cannot be migrated; the destination will boot the copied disks",
+			"blockers", strings.Join(blockers, "; "))
+	}
+	markerf(ctx, coldPlanMarker+"state=%s blockers=%d\n", state, len(blockers))
+	return state, nil
+}
+
+// runCold performs the source side of a cold migration planned by
+// planColdMigration. On failure the source VM is resumed, so a failed
+// cold migration costs only the pause.
+func runCold(ctx context.Context, cfg SourceConfig, state string) (retErr error) {
+	start := time.Now()
+	slog.Info("Starting cold migration",
+		"qmp_socket", cfg.QMPSocket,
+		"dest_ip", cfg.DestIP,
+		"state", state,
+		"shared_storage", cfg.SharedStorage,
+	)
+
+	client, err := qmp.NewClient(ctx, cfg.QMPSocket)
+	if err != nil {
+		return fmt.Errorf("connecting to source QMP: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close QMP client", "error", err)
+		}
+	}()
+
+	if _, err := client.Execute(ctx, "stop", nil); err != nil {
+		return fmt.Errorf("pausing VM: %w", err)
+	}
+	stoppedAt := time.Now()
+	markerf(ctx, "KATAMARAN_VM_STOPPED at_unix_ms=%d\n", stoppedAt.UnixMilli())
+	slog.Info("VM paused")
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		if _, err := client.Execute(cctx, "cont", nil); err != nil {
+			slog.Error("Failed to resume source VM after cold migration failure", "error", err)
+		} else {
+			slog.Info("Source VM resumed")
+		}
+	}()
+
+	if !cfg.SharedStorage {
+		if err := copyDisksCold(ctx, client, cfg); err != nil {
+			return err
+		}
+	} else {
+		slog.Info("Shared storage mode: skipping disk copy")
+	}
+
+	var ramTransferred, ramTotal int64
+	if state == ColdStateStream {
+		info, err := streamColdState(ctx, client, cfg)
+		if err != nil {
+			return err
+		}
+		ramTransferred, ramTotal = info.RAM.Transferred, info.RAM.Total
+	}
+
+	downtime := time.Since(stoppedAt)
+	markerf(ctx, "KATAMARAN_RESULT downtime_ms=%d total_time_ms=%d ram_transferred=%d ram_total=%d\n",
+		downtime.Milliseconds(), time.Since(start).Milliseconds(), ramTransferred, ramTotal)
+	markerf(ctx, coldDoneMarker+"state=%s\n", state)
+	slog.Info("Cold migration succeeded", "state", state, "downtime", downtime.Round(time.Millisecond))
+	return nil
+}
+
+// copyDisksCold mirrors the paused VM's drives to the destination and
+// completes the mirrors once they are ready. With no guest writes, a
+// ready mirror is an exact copy.
+func copyDisksCold(ctx context.Context, client *qmp.Client, cfg SourceConfig) error {
+	mirrors := newMirrorSet(client, cfg)
+	defer func() {
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		mirrors.teardown(cctx)
+	}()
+	targets := resolveBlockTargets(ctx, client, cfg.DriveIDs)
+	for _, t := range targets {
+		if err := mirrors.start(ctx, t); err != nil {
+			return err
+		}
+	}
+	copyStart := time.Now()
+	slog.Info("Copying disks", "drives", len(targets))
+	if err := mirrors.wait(ctx); err != nil {
+		return fmt.Errorf("disk copy failed after %s: %w", time.Since(copyStart).Round(time.Millisecond), err)
+	}
+	if cfg.VerifyStorage != "" && cfg.VerifyStorage != VerifyStorageOff && len(targets) > 0 {
+		if err := verifyMirroredStorage(ctx, client, cfg, targets); err != nil {
+			return fmt.Errorf("storage verification failed: %w", err)
+		}
+	}
+	mirrors.teardown(ctx)
+	slog.Info("Disks copied", "drives", len(targets), "elapsed", time.Since(copyStart).Round(time.Millisecond))
+	return nil
+}
+
+// streamColdState migrates the paused VM's state to the destination's
+// incoming listener. The destination ends up paused as well; it resumes
+// on KATAMARAN_COLD_DONE.
+func streamColdState(ctx context.Context, client *qmp.Client, cfg SourceConfig) (qmp.MigrateInfo, error) {
+	var info qmp.MigrateInfo
+	if _, err := client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
+		Capabilities: migrationCapabilities(cfg.MultifdChannels, CompressionNone),
+	}); err != nil {
+		return info, fmt.Errorf("setting migration capabilities: %w", err)
+	}
+	if _, err := client.Execute(ctx, "migrate-set-parameters", qmp.MigrateSetParametersArgs{
+		MaxBandwidth:    maxBandwidth,
+		MultifdChannels: int64(cfg.MultifdChannels),
+	}); err != nil {
+		return info, fmt.Errorf("setting migration parameters: %w", err)
+	}
+	uri := fmt.Sprintf("tcp:%s:%s", formatQEMUHost(cfg.DestIP), portOr(cfg.MigrationPort, ramMigrationPort))
+	if _, err := client.Execute(ctx, "migrate", qmp.MigrateArgs{URI: uri}); err != nil {
+		return info, fmt.Errorf("starting state migration to %s: %w", uri, err)
+	}
+	slog.Info("Streaming VM state", "uri", uri)
+	if err := waitForMigrationComplete(ctx, client); err != nil {
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		if _, cancelErr := client.Execute(cctx, "migrate-cancel", nil); cancelErr != nil {
+			slog.Warn("Failed to cancel migration", "error", cancelErr)
+		}
+		return info, err
+	}
+	raw, err := client.Execute(ctx, "query-migrate", nil)
+	if err != nil {
+		slog.Warn("Failed to capture migration metrics", "error", err)
+		return info, nil
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		slog.Warn("Failed to parse migration metrics", "error", err)
+	}
+	return info, nil
+}
+
+// readColdPlan waits for the source's KATAMARAN_COLD_PLAN marker and
+// records whether the destination boots instead of receiving state. A
+// cold boot needs a QEMU this binary spawns: a running kata sandbox
+// cannot be restarted paused.
+func readColdPlan(ctx context.Context, cfg *DestConfig) error {
+	fields, err := waitForJobMarker(ctx, cfg.ColdFromJob, coldPlanMarker, jobPeer{role: "source", event: "it published its cold migration plan"}, 0)
+	if err != nil {
+		return fmt.Errorf("waiting for cold migration plan: %w", err)
+	}
+	switch state := fields["state"]; state {
+	case ColdStateStream:
+	case ColdStateBoot:
+		if cfg.ReplayCmdlineFile == "" {
+			return fmt.Errorf("source VM has migration blockers; a cold boot requires --replay-cmdline or --replay-cmdline-from-pod")
+		}
+		cfg.coldBoot = true
+	default:
+		return fmt.Errorf("%s marker has unknown state %q", strings.TrimSpace(coldPlanMarker), state)
+	}
+	slog.Info("Cold migration plan received", "source_job", cfg.ColdFromJob, "state", fields["state"], "blockers", fields["blockers"])
+	return nil
+}
+
+// waitColdDone waits for the source's KATAMARAN_COLD_DONE marker and
+// resumes the destination VM.
+func waitColdDone(ctx context.Context, client *qmp.Client, sourceJob string) error {
+	slog.Info("Waiting for the source to finish the cold migration", "source_job", sourceJob)
+	if _, err := waitForJobMarker(ctx, sourceJob, coldDoneMarker, jobPeer{role: "source", event: "it finished the cold migration"}, migrationTimeout+storageSyncTimeout); err != nil {
+		return fmt.Errorf("waiting for cold migration: %w", err)
+	}
+	if _, err := client.Execute(ctx, "cont", nil); err != nil {
+		return fmt.Errorf("resuming VM: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+func TestPlanColdMigration(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name, resp, want string
+	}{
+		{"migratable", `{"return":{}}`, ColdStateStream},
+		{"vfio", `{"return":{"blocked-reasons":["VFIO device doesn't support migration"]}}`, ColdStateBoot},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			sock, _ := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+				if cmd.Execute == "query-migrate" {
+					return tc.resp
+				}
+				return `{"return":{}}`
+			})
+			state, err := planColdMigration(context.Background(), sock)
+			if err != nil {
+				t.Fatalf("planColdMigration: %v", err)
+			}
+			if state != tc.want {
+				t.Fatalf("state = %q, want %q", state, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunCold_StreamsStateAfterDiskCopy(t *testing.T) {
+	t.Parallel()
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-block-jobs":
+			return `{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":1000,"ready":true,"status":"ready","type":"mirror"}]}`
+		case "query-migrate":
+			return `{"return":{"status":"completed","ram":{"total":2048,"transferred":1024,"remaining":0}}}`
+		}
+		return `{"return":{}}`
+	})
+	cfg := mirrorDest(t)
+	cfg.QMPSocket = sock
+	cfg.DriveIDs = []string{"drive-virtio-disk0"}
+	cfg.MultifdChannels = 2
+
+	if err := runCold(context.Background(), cfg, ColdStateStream); err != nil {
+		t.Fatalf("runCold: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{
+		"stop", "drive-mirror", "query-block-jobs", "block-job-cancel",
+		"migrate-set-capabilities", "migrate-set-parameters", "migrate", "query-migrate",
+	})
+	var migrate qmp.MigrateArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate"), &migrate)
+	if migrate.URI != "tcp:127.0.0.1:4444" {
+		t.Errorf("migrate uri = %q", migrate.URI)
+	}
+	for _, c := range commands {
+		if c.Execute == "cont" {
+			t.Fatal("source VM resumed after a successful cold migration")
+		}
+	}
+}
+
+func TestRunCold_BootSendsNoStateAndResumesSourceOnFailure(t *testing.T) {
+	t.Parallel()
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		if cmd.Execute == "query-block-jobs" {
+			return `{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":10,"status":"concluded","type":"mirror","error":"Connection reset by peer"}]}`
+		}
+		return `{"return":{}}`
+	})
+	cfg := mirrorDest(t)
+	cfg.QMPSocket = sock
+	cfg.DriveIDs = []string{"drive-virtio-disk0"}
+	cfg.MirrorRetries = -1
+
+	err := runCold(context.Background(), cfg, ColdStateBoot)
+	if err == nil || !strings.Contains(err.Error(), "disk copy failed") {
+		t.Fatalf("err = %v, want disk copy failure", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{"stop", "drive-mirror", "cont"})
+	for _, c := range commands {
+		if c.Execute == "migrate" {
+			t.Fatal("cold boot migrated VM state")
+		}
+	}
+}
+
+func TestReadColdPlan(t *testing.T) {
+	destReadyServer(t, func() string { return "Running" }, func() string {
+		return "KATAMARAN_COLD_PLAN state=boot blockers=1\n"
+	})
+	// The fake apiserver serves one Job's pods; its name does not matter here.
+	ref := "kube-system/katamaran-dest-abc"
+
+	cfg := DestConfig{ColdFromJob: ref}
+	if err := readColdPlan(context.Background(), &cfg); err == nil || !strings.Contains(err.Error(), "requires --replay-cmdline") {
+		t.Fatalf("readColdPlan without replay: err = %v, want replay required", err)
+	}
+	cfg = DestConfig{ColdFromJob: ref, ReplayCmdlineFile: "/tmp/cmdline"}
+	if err := readColdPlan(context.Background(), &cfg); err != nil {
+		t.Fatalf("readColdPlan: %v", err)
+	}
+	if !cfg.coldBoot {
+		t.Fatal("coldBoot = false after a boot plan")
+	}
+}
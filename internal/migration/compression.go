@@ -0,0 +1,289 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Migration stream compression methods for --compression.
+const (
+	CompressionNone = "none"
+	// CompressionZstd and CompressionZlib compress each multifd packet;
+	// they need multifd channels.
+	CompressionZstd = "zstd"
+	CompressionZlib = "zlib"
+	// CompressionXBZRLE sends re-dirtied pages as deltas against a cache
+	// of their previous contents. QEMU does not combine it with multifd,
+	// so it turns multifd off.
+	CompressionXBZRLE = "xbzrle"
+	// CompressionAuto has the source pick one of the above from the
+	// measured link; see chooseCompression.
+	CompressionAuto = "auto"
+)
+
+const (
+	// compressionMarker is printed by the source before it waits for the
+	// destination, so a destination started with --compression auto
+	// configures the method the source chose:
+	//
+	//	KATAMARAN_COMPRESSION method=<m> level=<n> rtt_us=<n> bandwidth_mbps=<n>
+	compressionMarker = "KATAMARAN_COMPRESSION "
+
+	// maxZstdLevel and maxZlibLevel are QEMU's multifd-zstd-level and
+	// multifd-zlib-level bounds.
+	maxZstdLevel = 20
+	maxZlibLevel = 9
+
+	// autoCompressionFastMbps is the link speed from which auto sends
+	// uncompressed: the wire outruns the compressors. Below
+	// autoCompressionZstdLightMbps auto spends more CPU on zstd.
+	autoCompressionFastMbps      = 10_000
+	autoCompressionZstdLightMbps = 1_000
+
+	// autoCompressionLANRTT is the round trip below which auto, with the
+	// bandwidth unknown, assumes a fast local link.
+	autoCompressionLANRTT = time.Millisecond
+
+	// xbzrleMinCache and xbzrleMaxCache bound the XBZRLE cache, which is
+	// sized to an eighth of the VM's memory. QEMU's default is the
+	// minimum.
+	xbzrleMinCache = 64 << 20
+	xbzrleMaxCache = 1 << 30
+
+	// bandwidthProbePackets echoes of bandwidthProbeSize bytes make up
+	// the bandwidth probe's packet train.
+	bandwidthProbePackets = 32
+	bandwidthProbeSize    = 1400
+)
+
+// measureBandwidthFunc is swapped by tests; production measures with
+// ICMP like measureRTTFunc.
+var measureBandwidthFunc = measureBandwidth
+
+// CheckCompression validates a --compression method and
+// --compression-level for a migration with multifdChannels channels.
+// A zero level uses QEMU's default.
+func CheckCompression(method string, level, multifdChannels int) error {
+	maxLevel := 0
+	switch method {
+	case "", CompressionNone, CompressionXBZRLE, CompressionAuto:
+	case CompressionZstd:
+		maxLevel = maxZstdLevel
+	case CompressionZlib:
+		maxLevel = maxZlibLevel
+	default:
+		return fmt.Errorf("invalid compression %q (valid: none, zstd, zlib, xbzrle, auto)", method)
+	}
+	if level != 0 && maxLevel == 0 {
+		return fmt.Errorf("a compression level applies to zstd and zlib only, not %q", method)
+	}
+	if level < 0 || level > maxLevel {
+		return fmt.Errorf("%s compression level must be between 0 and %d, got %d", method, maxLevel, level)
+	}
+	if maxLevel > 0 && multifdChannels == 0 {
+		return fmt.Errorf("%s compression needs multifd channels", method)
+	}
+	return nil
+}
+
+// xbzrleDisablesMultifd turns multifd off for XBZRLE, on both sides so
+// their capabilities still match.
+func xbzrleDisablesMultifd(method string, multifdChannels *int) {
+	if method == CompressionXBZRLE && *multifdChannels > 0 {
+		slog.Info("XBZRLE does not work with multifd; migrating over a single channel", "multifd_channels", *multifdChannels)
+		*multifdChannels = 0
+	}
+}
+
+// planCompression replaces CompressionAuto in cfg with the method for
+// the link to the destination and prints the KATAMARAN_COMPRESSION
+// marker. A failed measurement counts as unknown.
+func planCompression(ctx context.Context, cfg *SourceConfig) {
+	rtt, err := measureRTTFunc(cfg.DestIP)
+	if err != nil {
+		slog.Warn("Failed to measure RTT for compression auto-selection", "error", err)
+		rtt = 0
+	}
+	bps, err := measureBandwidthFunc(cfg.DestIP)
+	if err != nil {
+		slog.Warn("Failed to measure bandwidth for compression auto-selection", "error", err)
+		bps = 0
+	}
+	mbps := bps / 1_000_000
+	cfg.Compression, cfg.CompressionLevel = chooseCompression(rtt, mbps, cfg.MultifdChannels)
+	slog.Info("Auto-selected stream compression", "compression", cfg.Compression, "level", cfg.CompressionLevel,
+		"rtt", rtt, "bandwidth_mbps", mbps)
+	markerf(ctx, compressionMarker+"method=%s level=%d rtt_us=%d bandwidth_mbps=%d\n",
+		cfg.Compression, cfg.CompressionLevel, rtt.Microseconds(), mbps)
+}
+
+// chooseCompression picks the compression for a link with round trip
+// rtt and bandwidth mbps (zero when unknown):
+//
+//   - none on links of autoCompressionFastMbps and up, where
+//     compressing only costs CPU, and when nothing suggests a slow link
+//     (bandwidth unknown and a LAN or unknown round trip);
+//   - zstd with multifd: level 1, or 3 below autoCompressionZstdLightMbps;
+//   - xbzrle without multifd, which at least shrinks re-sent pages.
+func chooseCompression(rtt time.Duration, mbps int64, multifdChannels int) (string, int) {
+	if mbps >= autoCompressionFastMbps || mbps == 0 && rtt < autoCompressionLANRTT {
+		return CompressionNone, 0
+	}
+	if multifdChannels == 0 {
+		return CompressionXBZRLE, 0
+	}
+	if mbps > 0 && mbps < autoCompressionZstdLightMbps {
+		return CompressionZstd, 3
+	}
+	return CompressionZstd, 1
+}
+
+// readCompressionPlan waits for the KATAMARAN_COMPRESSION marker of the
+// source Job cfg.CompressionFromJob and sets cfg.Compression from it.
+func readCompressionPlan(ctx context.Context, cfg *DestConfig) error {
+	fields, err := waitForJobMarker(ctx, cfg.CompressionFromJob, compressionMarker, jobPeer{role: "source", event: "it chose the stream compression"}, 0)
+	if err != nil {
+		return fmt.Errorf("waiting for compression plan: %w", err)
+	}
+	switch method := fields["method"]; method {
+	case CompressionNone, CompressionZstd, CompressionZlib, CompressionXBZRLE:
+		cfg.Compression = method
+	default:
+		return fmt.Errorf("%s marker has unknown method %q", strings.TrimSpace(compressionMarker), method)
+	}
+	slog.Info("Compression plan received", "source_job", cfg.CompressionFromJob, "compression", cfg.Compression,
+		"rtt_us", fields["rtt_us"], "bandwidth_mbps", fields["bandwidth_mbps"])
+	return nil
+}
+
+// setCompressionParams adds the sender's parameters for method to p.
+func setCompressionParams(ctx context.Context, client *qmp.Client, p *qmp.MigrateSetParametersArgs, method string, level int) {
+	switch method {
+	case CompressionZstd:
+		p.MultifdCompression = method
+		p.MultifdZstdLevel = int64(level)
+	case CompressionZlib:
+		p.MultifdCompression = method
+		p.MultifdZlibLevel = int64(level)
+	case CompressionXBZRLE:
+		ram, err := vmMemorySize(ctx, client)
+		if err != nil {
+			slog.Warn("Cannot size the XBZRLE cache; using QEMU's default", "error", err)
+			return
+		}
+		p.XBZRLECacheSize = xbzrleCacheSize(ram)
+	}
+}
+
+// xbzrleCacheSize returns the XBZRLE cache for a VM with ram bytes of
+// memory: an eighth of it, rounded down to a power of two and clamped to
+// [xbzrleMinCache, xbzrleMaxCache].
+func xbzrleCacheSize(ram int64) int64 {
+	if ram/8 < xbzrleMinCache {
+		return xbzrleMinCache
+	}
+	size := int64(1) << (bits.Len64(uint64(ram/8)) - 1)
+	return min(size, xbzrleMaxCache)
+}
+
+// compressionRatio returns how many bytes of guest pages each byte on
+// the wire carried: the pages sent in full or as XBZRLE deltas over the
+// RAM bytes transferred. Zero before anything was sent.
+func compressionRatio(info qmp.MigrateInfo) float64 {
+	pageSize := info.RAM.PageSize
+	if pageSize <= 0 {
+		pageSize = 4096
+	}
+	raw := info.RAM.NormalBytes
+	if raw == 0 {
+		raw = info.RAM.Normal * pageSize
+	}
+	if info.XBZRLECache != nil {
+		raw += info.XBZRLECache.Pages * pageSize
+	}
+	if raw <= 0 || info.RAM.Transferred <= 0 {
+		return 0
+	}
+	return float64(raw) / float64(info.RAM.Transferred)
+}
+
+// measureBandwidth estimates the bandwidth in bits per second to destIP
+// from a train of back-to-back ICMP echoes: the slowest link on the path
+// spaces the replies by the time it takes to send one. A rough figure,
+// good enough to tell a LAN from a WAN link.
+func measureBandwidth(destIP netip.Addr) (int64, error) {
+	conn, icmpType, err := listenICMP(destIP)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	dst := &net.IPAddr{IP: net.IP(destIP.AsSlice())}
+	// A different ID from measureRTT's, so late RTT replies do not count.
+	id := (os.Getpid() + 1) & 0xffff
+	payload := make([]byte, bandwidthProbeSize)
+	for seq := 1; seq <= bandwidthProbePackets; seq++ {
+		msg := icmp.Message{Type: icmpType, Body: &icmp.Echo{ID: id, Seq: seq, Data: payload}}
+		buf, err := msg.Marshal(nil)
+		if err != nil {
+			return 0, fmt.Errorf("marshalling ICMP echo %d: %w", seq, err)
+		}
+		if _, err := conn.WriteTo(buf, dst); err != nil {
+			return 0, fmt.Errorf("bandwidth probe send %d: %w", seq, err)
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(rttDialTimeout)); err != nil {
+		return 0, fmt.Errorf("bandwidth probe set deadline: %w", err)
+	}
+
+	var first, last time.Time
+	received := 0
+	reply := make([]byte, bandwidthProbeSize+512)
+	for received < bandwidthProbePackets {
+		n, _, err := conn.ReadFrom(reply)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break // lost replies: estimate from those that came back
+			}
+			return 0, fmt.Errorf("bandwidth probe recv: %w", err)
+		}
+		now := time.Now()
+		parsed, err := icmp.ParseMessage(icmpProto(destIP), reply[:n])
+		if err != nil {
+			continue
+		}
+		if echo, ok := parsed.Body.(*icmp.Echo); !ok || echo.ID != id || len(echo.Data) != bandwidthProbeSize {
+			continue
+		}
+		if received == 0 {
+			first = now
+		}
+		last = now
+		received++
+	}
+	if received < 2 {
+		return 0, fmt.Errorf("bandwidth probe: %d of %d replies, too few to measure", received, bandwidthProbePackets)
+	}
+	spread := last.Sub(first)
+	if spread <= 0 {
+		return 0, fmt.Errorf("bandwidth probe: %d replies arrived at once, too fast to measure", received)
+	}
+	// Each echo reply also carries its IP and ICMP headers.
+	bitsPerReply := int64(bandwidthProbeSize+48) * 8
+	bps := int64(float64(bitsPerReply*int64(received-1)) / spread.Seconds())
+	slog.Debug("Bandwidth probe complete", "replies", received, "spread", spread, "bps", bps)
+	return bps, nil
+}
@@ -0,0 +1,211 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+func TestCheckCompression(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		method  string
+		level   int
+		multifd int
+		wantErr string
+	}{
+		{"", 0, 0, ""},
+		{CompressionNone, 0, 4, ""},
+		{CompressionZstd, 0, 4, ""},
+		{CompressionZstd, 20, 4, ""},
+		{CompressionZlib, 9, 1, ""},
+		{CompressionXBZRLE, 0, 4, ""},
+		{CompressionAuto, 0, 0, ""},
+		{"lz4", 0, 4, "invalid compression"},
+		{CompressionZstd, 21, 4, "between 0 and 20"},
+		{CompressionZlib, 10, 4, "between 0 and 9"},
+		{CompressionZlib, -1, 4, "between 0 and 9"},
+		{CompressionAuto, 3, 4, "zstd and zlib only"},
+		{CompressionZstd, 0, 0, "needs multifd"},
+	} {
+		err := CheckCompression(tc.method, tc.level, tc.multifd)
+		if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("CheckCompression(%q, %d, %d) = %v, want %q", tc.method, tc.level, tc.multifd, err, tc.wantErr)
+		}
+	}
+}
+
+func TestChooseCompression(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name       string
+		rtt        time.Duration
+		mbps       int64
+		multifd    int
+		wantMethod string
+		wantLevel  int
+	}{
+		{"fast link", 20 * time.Millisecond, 25_000, 4, CompressionNone, 0},
+		{"LAN, bandwidth unknown", 200 * time.Microsecond, 0, 4, CompressionNone, 0},
+		{"nothing measured", 0, 0, 4, CompressionNone, 0},
+		{"gigabit", 2 * time.Millisecond, 1_000, 4, CompressionZstd, 1},
+		{"slow link", 30 * time.Millisecond, 200, 4, CompressionZstd, 3},
+		{"WAN, bandwidth unknown", 40 * time.Millisecond, 0, 4, CompressionZstd, 1},
+		{"slow link without multifd", 30 * time.Millisecond, 200, 0, CompressionXBZRLE, 0},
+	} {
+		method, level := chooseCompression(tc.rtt, tc.mbps, tc.multifd)
+		if method != tc.wantMethod || level != tc.wantLevel {
+			t.Errorf("%s: chooseCompression = %s/%d, want %s/%d", tc.name, method, level, tc.wantMethod, tc.wantLevel)
+		}
+	}
+}
+
+func TestXBZRLECacheSize(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct{ ram, want int64 }{
+		{256 << 20, xbzrleMinCache},
+		{4 * gib, 512 << 20},
+		{6 * gib, 512 << 20},
+		{64 * gib, xbzrleMaxCache},
+	} {
+		if got := xbzrleCacheSize(tc.ram); got != tc.want {
+			t.Errorf("xbzrleCacheSize(%d) = %d, want %d", tc.ram, got, tc.want)
+		}
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	t.Parallel()
+	var info qmp.MigrateInfo
+	if got := compressionRatio(info); got != 0 {
+		t.Fatalf("ratio before transfer = %v, want 0", got)
+	}
+	info.RAM.Transferred = 1000 * 4096
+	info.RAM.Normal = 3000
+	info.RAM.PageSize = 4096
+	info.XBZRLECache = &qmp.XBZRLECacheInfo{Pages: 1000}
+	if got := compressionRatio(info); got != 4 {
+		t.Fatalf("ratio = %v, want 4", got)
+	}
+}
+
+// stubLinkProbes makes the compression auto-selection see a link with
+// rtt and mbps. Not parallel-safe.
+func stubLinkProbes(t *testing.T, rtt time.Duration, mbps int64) {
+	t.Helper()
+	origRTT, origBW := measureRTTFunc, measureBandwidthFunc
+	measureRTTFunc = func(netip.Addr) (time.Duration, error) { return rtt, nil }
+	measureBandwidthFunc = func(netip.Addr) (int64, error) { return mbps * 1_000_000, nil }
+	t.Cleanup(func() { measureRTTFunc, measureBandwidthFunc = origRTT, origBW })
+}
+
+func compressionSourceQMP(t *testing.T) (string, *qmpRecorder) {
+	t.Helper()
+	return startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "migrate":
+			return `{"return":{}}` + "\n" + `{"event":"STOP"}`
+		case "query-migrate":
+			return `{"return":{"status":"completed","downtime":10,"total-time":500,"ram":{"transferred":1048576,"normal":512,"page-size":4096}}}`
+		case "query-memory-size-summary":
+			return `{"return":{"base-memory":4294967296}}`
+		default:
+			return `{"return":{}}`
+		}
+	})
+}
+
+func TestRunSource_CompressionAutoPicksXBZRLE(t *testing.T) {
+	// Not parallel: stubs the link probes.
+	stubLinkProbes(t, 30*time.Millisecond, 200)
+	sock, rec := compressionSourceQMP(t)
+
+	err := RunSource(context.Background(), SourceConfig{
+		QMPSocket: sock, DestIP: testDestIP, VMIP: testVMIP, SharedStorage: true,
+		TunnelMode: TunnelModeNone, DowntimeLimitMS: 25, Compression: CompressionAuto,
+	})
+	if err != nil {
+		t.Fatalf("RunSource: %v", err)
+	}
+	commands := rec.Commands()
+	var caps qmp.MigrateSetCapabilitiesArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate-set-capabilities"), &caps)
+	if got := caps.Capabilities[len(caps.Capabilities)-1]; got != (qmp.MigrationCapability{Capability: "xbzrle", State: true}) {
+		t.Fatalf("capabilities = %+v, want xbzrle on", caps.Capabilities)
+	}
+	var params qmp.MigrateSetParametersArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate-set-parameters"), &params)
+	if params.XBZRLECacheSize != 512<<20 || params.MultifdCompression != "" {
+		t.Fatalf("parameters = %+v, want a 512 MiB XBZRLE cache", params)
+	}
+}
+
+func TestRunSource_ZstdParameters(t *testing.T) {
+	t.Parallel()
+	sock, rec := compressionSourceQMP(t)
+
+	err := RunSource(context.Background(), SourceConfig{
+		QMPSocket: sock, DestIP: testDestIP, VMIP: testVMIP, SharedStorage: true,
+		TunnelMode: TunnelModeNone, DowntimeLimitMS: 25, MultifdChannels: 4,
+		Compression: CompressionZstd, CompressionLevel: 5,
+	})
+	if err != nil {
+		t.Fatalf("RunSource: %v", err)
+	}
+	var params qmp.MigrateSetParametersArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, rec.Commands(), "migrate-set-parameters"), &params)
+	if params.MultifdChannels != 4 || params.MultifdCompression != CompressionZstd || params.MultifdZstdLevel != 5 {
+		t.Fatalf("parameters = %+v, want zstd level 5 over 4 channels", params)
+	}
+}
+
+func TestRunSource_XBZRLETurnsMultifdOff(t *testing.T) {
+	t.Parallel()
+	sock, rec := compressionSourceQMP(t)
+
+	err := RunSource(context.Background(), SourceConfig{
+		QMPSocket: sock, DestIP: testDestIP, VMIP: testVMIP, SharedStorage: true,
+		TunnelMode: TunnelModeNone, DowntimeLimitMS: 25, MultifdChannels: 4,
+		Compression: CompressionXBZRLE,
+	})
+	if err != nil {
+		t.Fatalf("RunSource: %v", err)
+	}
+	var caps qmp.MigrateSetCapabilitiesArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, rec.Commands(), "migrate-set-capabilities"), &caps)
+	for _, c := range caps.Capabilities {
+		if c.Capability == "multifd" {
+			t.Fatalf("capabilities = %+v, want multifd off with xbzrle", caps.Capabilities)
+		}
+	}
+}
+
+func TestRunDestination_CompressionAuto(t *testing.T) {
+	destReadyServer(t, func() string { return "Running" }, func() string {
+		return "KATAMARAN_COMPRESSION method=zstd level=1 rtt_us=2000 bandwidth_mbps=1000\n"
+	})
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		if cmd.Execute == "migrate-incoming" {
+			return `{"return":{}}` + "\n" + `{"event":"RESUME"}`
+		}
+		return `{"return":{}}`
+	})
+
+	err := RunDestination(context.Background(), DestConfig{
+		QMPSocket: sock, SharedStorage: true, MultifdChannels: 4,
+		Compression: CompressionAuto, CompressionFromJob: "kube-system/katamaran-dest-abc",
+	})
+	if err != nil {
+		t.Fatalf("RunDestination: %v", err)
+	}
+	var params qmp.MigrateSetParametersArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, rec.Commands(), "migrate-set-parameters"), &params)
+	if params.MultifdCompression != CompressionZstd || params.MultifdZstdLevel != 0 {
+		t.Fatalf("destination parameters = %+v, want multifd-compression zstd only", params)
+	}
+}
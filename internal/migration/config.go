@@ -5,16 +5,21 @@ package migration
 
 import (
 	"context"
+	"encoding/json"
 	"net/netip"
+	"strconv"
 	"time"
 )
 
 // Migration tuning constants.
 const (
-	// nbdPort is the TCP port used for NBD storage mirroring.
+	// nbdPort is the default TCP port used for NBD storage mirroring.
+	// Overridden per migration by SourceConfig/DestConfig.NBDPort so
+	// several migrations can land on one destination node at once.
 	nbdPort = "10809"
 
-	// ramMigrationPort is the TCP port used for QEMU RAM migration.
+	// ramMigrationPort is the default TCP port used for QEMU RAM
+	// migration. Overridden per migration by MigrationPort.
 	ramMigrationPort = "4444"
 
 	// maxBandwidth is the maximum migration bandwidth in bytes/second (10 GB/s).
@@ -68,6 +73,16 @@ const (
 	// this window, the drive-mirror command likely failed silently.
 	jobAppearTimeout = 30 * time.Second
 
+	// defaultMirrorRetries is how many times each drive's mirror is resumed
+	// or restarted after target errors when SourceConfig.MirrorRetries is
+	// zero.
+	defaultMirrorRetries = 5
+
+	// defaultMirrorReconnectTimeout is how long mirror recovery waits for
+	// the destination's NBD server to accept connections again when
+	// SourceConfig.MirrorReconnectTimeout is zero.
+	defaultMirrorReconnectTimeout = 2 * time.Minute
+
 	// DefaultMultifdChannels is the number of parallel TCP connections used
 	// for RAM migration. Multifd distributes page transfer across channels,
 	// improving throughput when per-connection bandwidth is limited (e.g.
@@ -132,6 +147,87 @@ type SourceConfig struct {
 	// KATAMARAN_CMDLINE_B64= (base64 payload, scraped from the pod log by
 	// the Native orchestrator).
 	EmitCmdlineTo string
+	// HandoffKey, when non-nil, signs the cmdline, device plan and
+	// VMConfig markers with HMAC-SHA256 so the destination can tell them
+	// from lines anything else wrote to the pod log. From HandoffKeyEnv.
+	HandoffKey []byte
+	// MigrationPort and NBDPort are the destination's RAM migration and
+	// NBD listener ports. Zero uses ramMigrationPort / nbdPort. Must
+	// match the destination's DestConfig.
+	MigrationPort int
+	NBDPort       int
+	// DestReadyFrom, when non-empty, names the destination Job
+	// ("<namespace>/<job>") whose pod log the source polls through the
+	// apiserver for KATAMARAN_DEST_READY before starting drive-mirror and
+	// migrate. Used in replay-cmdline mode, where the dest Job starts
+	// after the source. DestReadyTimeout bounds the wait (zero uses 5m).
+	DestReadyFrom    string
+	DestReadyTimeout time.Duration
+	// VerifyStorage is VerifyStorageSample or VerifyStorageFull to compare
+	// the mirrored drives against the destination's exports before RAM
+	// migration starts, aborting on a mismatch. Empty or VerifyStorageOff
+	// skips verification.
+	VerifyStorage string
+	// MirrorRetries bounds how many times each drive's mirror is resumed
+	// after a target error or restarted after failing, during the storage
+	// phase. Zero uses defaultMirrorRetries; negative disables recovery.
+	// MirrorReconnectTimeout is how long each recovery waits for the
+	// destination's NBD server to come back (zero uses
+	// defaultMirrorReconnectTimeout).
+	MirrorRetries          int
+	MirrorReconnectTimeout time.Duration
+	// Cold pauses the VM before copying its disks and migrating its state
+	// (--mode cold), and sends no state when QEMU reports migration
+	// blockers; the destination then boots the copied disks.
+	Cold bool
+	// Hypervisor is HypervisorQEMU, HypervisorCloudHypervisor or
+	// HypervisorAuto (the default when empty). For Cloud Hypervisor,
+	// QMPSocket is the VMM's API socket.
+	Hypervisor string
+	// PreCutoverHooks run in order after the storage is in sync and
+	// right before the VM's state starts moving (before the VM is paused
+	// in cold mode), so the workload can quiesce. An aborting failure
+	// fails the migration with the VM still on the source.
+	PreCutoverHooks []Hook
+	// Balloon inflates the VM's virtio-balloon toward the guest's memory
+	// usage before the RAM transfer, so it moves less memory; a failed
+	// migration deflates it again. Best-effort: a VM without a balloon
+	// device, or whose guest does not respond, migrates in full. Live
+	// mode only.
+	Balloon bool
+	// Compression is the RAM stream's compression, one of the
+	// Compression* methods ("" is CompressionNone); CompressionLevel
+	// tunes zstd and zlib (zero uses QEMU's default). CompressionAuto
+	// picks from the measured link and prints the KATAMARAN_COMPRESSION
+	// marker for the destination. Live mode only.
+	Compression      string
+	CompressionLevel int
+	// AdaptiveTuning replaces auto-converge's vCPU throttling with a
+	// controller that watches query-migrate and, when the transfer stops
+	// converging, lifts MaxBandwidthMBps and then raises the downtime
+	// limit stepwise up to MaxDowntimeMS (zero uses
+	// defaultMaxDowntimeMS). Each step prints a KATAMARAN_TUNING marker.
+	// Live mode only.
+	AdaptiveTuning bool
+	MaxDowntimeMS  int
+	// MaxBandwidthMBps caps the RAM transfer in MiB/s; zero leaves it at
+	// maxBandwidth.
+	MaxBandwidthMBps int
+}
+
+// ProbeConfig holds all parameters for RunProbe.
+type ProbeConfig struct {
+	// PodName and PodNamespace identify the kata pod whose VM is
+	// profiled. Required: the probe resolves the sandbox the same way
+	// RunSource does in pod mode.
+	PodName      string
+	PodNamespace string
+	// QMPSocket overrides the resolved sandbox's extra-monitor socket.
+	QMPSocket string
+	// DriveIDs are the drives a non-shared-storage migration would
+	// mirror; their sizes are summed into the profile's disk_bytes.
+	DriveIDs      []string
+	SharedStorage bool
 }
 
 // DestConfig holds all parameters for RunDestination.
@@ -165,19 +261,114 @@ type DestConfig struct {
 	// normally set exactly one.
 	ReplayCmdlineFromPod string
 	// QEMUBinary, when non-empty, overrides the QEMU binary path used for
-	// cmdline replay. Defaults to /opt/kata/bin/qemu-system-x86_64; the
+	// cmdline replay. Defaults to the Kata QEMU for the host architecture
+	// (/opt/kata/bin/qemu-system-x86_64 or qemu-system-aarch64); the
 	// captured cmdline's argv[0] is intentionally not used (it is
 	// attacker-controlled in the source pod). Mostly a test seam.
 	QEMUBinary string
 	// SandboxID, when non-empty, names the synthetic dest sandbox directory
 	// created under /run/vc/vm/<id>/ for cmdline replay. Defaults to
 	// "katamaran-dest". Also used to substitute the source sandbox path in
-	// the captured cmdline.
+	// the captured cmdline. The orchestrator passes a per-migration ID
+	// (--sandbox-id) so concurrent replays on one node don't share a
+	// directory.
 	SandboxID string
 	// SourcePodRef, when non-empty, is "<namespace>/<name>" of the source
 	// pod. Used to fetch VMConfig from the source pod's log markers for
 	// factory adoption. Set from --pod-name/--pod-namespace on the dest.
 	SourcePodRef string
+	// HandoffKey, when non-nil, is the key the source signed its cmdline,
+	// device plan and VMConfig markers with; unsigned or tampered markers
+	// are rejected. From HandoffKeyEnv. Required whenever
+	// ReplayCmdlineFromPod or SourcePodRef is set, unless
+	// AllowUnsignedHandoff is.
+	HandoffKey []byte
+	// AllowUnsignedHandoff lets a dest without HandoffKey accept unsigned
+	// pod-log payloads (manual runs against an unkeyed source). Logged at
+	// Warn.
+	AllowUnsignedHandoff bool
+	// MigrationPort and NBDPort are the ports the incoming migration and
+	// NBD server listen on. Zero uses ramMigrationPort / nbdPort. The
+	// orchestrator allocates distinct ports per migration so concurrent
+	// inbound migrations don't collide on the node's host network.
+	MigrationPort int
+	NBDPort       int
+	// DrivesFromJob, when non-empty with DriveIDs set to DriveIDsAuto,
+	// names the source Job ("<namespace>/<job>") whose KATAMARAN_DRIVES
+	// marker lists the drives to export over NBD. Without it the dest
+	// discovers the drives of its own QEMU.
+	DrivesFromJob string
+	// MigrationID is the orchestrator's correlation ID
+	// (KATAMARAN_MIGRATION_ID), recorded in migration-meta.json so the
+	// factory and adoption can tie the sandbox back to its migration.
+	MigrationID string
+	// ColdFromJob, when non-empty, names the source Job
+	// ("<namespace>/<job>") of a cold migration. The destination reads
+	// its KATAMARAN_COLD_PLAN marker before spawning QEMU (paused with -S
+	// instead of -incoming when no state is coming) and resumes the VM
+	// once the source prints KATAMARAN_COLD_DONE.
+	ColdFromJob string
+	// Hypervisor is as in SourceConfig. The Cloud Hypervisor destination
+	// must be a VMM with no VM yet, started with --api-socket QMPSocket.
+	Hypervisor string
+	// PostResumeHooks run in order once the VM runs on the destination
+	// and its network is flushed and announced. An aborting failure fails
+	// the migration; the VM stays on the destination.
+	PostResumeHooks []Hook
+	// Balloon deflates the VM's balloon back to its full memory once the
+	// VM runs, undoing the source's SourceConfig.Balloon. Best-effort.
+	Balloon bool
+	// Compression must match the source's SourceConfig.Compression.
+	// CompressionAuto takes it from the KATAMARAN_COMPRESSION marker of
+	// the source Job CompressionFromJob ("<namespace>/<job>").
+	Compression        string
+	CompressionFromJob string
+
+	// coldBoot is set from the plan: the replayed QEMU boots the copied
+	// disks instead of waiting for an incoming migration.
+	coldBoot bool
+	// vmConfig and agentConfig, set by RunRestore from the checkpoint
+	// bundle, take precedence over persist.json in migration-meta.json.
+	vmConfig    json.RawMessage
+	agentConfig json.RawMessage
+}
+
+// CheckpointConfig holds all parameters for RunCheckpoint.
+type CheckpointConfig struct {
+	// QMPSocket is the VM's QMP socket. The QEMU PID, needed for the
+	// cmdline, is read from the pid file next to it.
+	QMPSocket string
+	// PodName and PodNamespace, when set, resolve the sandbox (QMP socket
+	// and QEMU PID) the way RunSource does in pod mode.
+	PodName      string
+	PodNamespace string
+	// OutDir is the bundle directory. It must not exist or be empty.
+	OutDir string
+	// DriveIDs are the drives whose images go into the bundle, or
+	// DriveIDsAuto for every writable local drive. SharedStorage skips
+	// the disk images: the bundle then restores against the same disks.
+	DriveIDs      []string
+	SharedStorage bool
+}
+
+// RestoreConfig holds all parameters for RunRestore.
+type RestoreConfig struct {
+	// InDir is a bundle written by RunCheckpoint.
+	InDir string
+	// SandboxID and QEMUBinary are as in DestConfig: the restored QEMU is
+	// spawned through the same cmdline replay.
+	SandboxID  string
+	QEMUBinary string
+	// MigrationID is recorded in migration-meta.json.
+	MigrationID string
+}
+
+// portOr returns port as a string, or def when port is zero.
+func portOr(port int, def string) string {
+	if port == 0 {
+		return def
+	}
+	return strconv.Itoa(port)
 }
 
 // cleanupCtx returns a context with cleanupTimeout that is independent of the
@@ -203,6 +203,20 @@ func TestValidateTapNetns(t *testing.T) {
 	}
 }
 
+func TestValidateSandboxID(t *testing.T) {
+	t.Parallel()
+	for _, id := range []string{"katamaran-dest", "katamaran-dest-0123456789abcdef", "a.b_c"} {
+		if err := validateSandboxID(id); err != nil {
+			t.Errorf("expected %q to be valid, got: %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "../escape", "a/b", ".hidden", "id with space", strings.Repeat("a", 65)} {
+		if err := validateSandboxID(id); err == nil {
+			t.Errorf("expected %q to be invalid", id)
+		}
+	}
+}
+
 func TestValidateDriveID(t *testing.T) {
 	t.Parallel()
 	valid := []string{"drive-virtio-disk0", "drive0", "virtio-blk-pci0", "a", "mirror-drive-virtio-disk0"}
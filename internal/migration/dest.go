@@ -1,6 +1,7 @@
 package migration
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -37,14 +38,20 @@ const destDefaultQMPSocket = "/run/vc/vm/katamaran-dest/qmp.sock"
 //  1. Installs a tc sch_plug qdisc on the tap interface in pass-through mode
 //     (sch_plug defaults to buffering, so we immediately release_indefinite;
 //     skipped if tapIface is empty or the interface does not exist)
-//  2. Configures multifd capabilities (if enabled) and opens an incoming
-//     migration listener via QMP migrate-incoming
+//  2. Configures multifd and stream compression (if enabled; auto reads
+//     the source's choice) and opens an incoming migration listener via
+//     QMP migrate-incoming
 //  3. Starts an NBD server for storage mirroring (unless shared-storage mode)
 //  4. Plugs the network queue to catch in-flight packets (skipped if no qdisc installed)
 //  5. Waits for the RESUME event (unconditional)
 //  6. Flushes all buffered packets via release_indefinite (skipped if no qdisc installed)
 //  7. Stops the NBD server (unless shared-storage mode)
 //  8. Sends Gratuitous ARP via QEMU announce-self (correct guest MAC)
+//  9. Deflates the memory balloon the source inflated (cfg.Balloon)
+//  10. Runs the post-resume hooks (cfg.PostResumeHooks)
+//
+// The VM steps go through the Hypervisor for cfg.Hypervisor. Cloud
+// Hypervisor receives with vm.receive-migration and sends no GARP.
 func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 	if cfg.DestPodName != "" {
 		ip, err := lookupPodIP(ctx, cfg.DestPodNamespace, cfg.DestPodName)
@@ -63,6 +70,15 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 		}
 	}
 
+	hypervisor, socket, err := resolveHypervisor(cfg.Hypervisor, cfg.QMPSocket)
+	if err != nil {
+		return err
+	}
+	cfg.Hypervisor, cfg.QMPSocket = hypervisor, socket
+	if err := checkDestHypervisor(cfg.Hypervisor, cfg); err != nil {
+		return err
+	}
+
 	// If a captured source cmdline is supplied, spawn the destination QEMU
 	// ourselves with -incoming defer before connecting to QMP. This bypasses
 	// Kata's sandbox lifecycle (which kills VMs that don't connect via vsock
@@ -80,12 +96,42 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 	//   --replay-cmdline <path>: file-based mode, kept for manual
 	//   testing where the file is staged out-of-band (e.g.
 	//   deploy/migrate.sh's kubectl-cp shuffle).
+	if cfg.SandboxID != "" {
+		if err := validateSandboxID(cfg.SandboxID); err != nil {
+			return err
+		}
+	}
+	//
+	// Either way the source's hot-plugged device plan travels with the
+	// cmdline (KATAMARAN_DEVICES_B64, or the file's .devices.json sidecar)
+	// and is replayed once QMP is connected, before migrate-incoming.
+	var devicePlan []deviceReplayStep
+	if ref := cmp.Or(cfg.ReplayCmdlineFromPod, cfg.SourcePodRef); ref != "" {
+		if err := checkPodLogHandoff(cfg.HandoffKey, cfg.AllowUnsignedHandoff, ref); err != nil {
+			return err
+		}
+	}
 	if cfg.ReplayCmdlineFromPod != "" {
-		path, err := fetchCmdlineFromPodLog(ctx, cfg.ReplayCmdlineFromPod)
+		path, err := fetchCmdlineFromPodLog(ctx, cfg.ReplayCmdlineFromPod, cfg.HandoffKey)
 		if err != nil {
 			return fmt.Errorf("replay-cmdline-from-pod: %w", err)
 		}
 		cfg.ReplayCmdlineFile = path
+		if devicePlan, err = fetchDevicePlanFromPodLog(ctx, cfg.ReplayCmdlineFromPod, cfg.HandoffKey); err != nil {
+			return fmt.Errorf("replay-cmdline-from-pod: device plan: %w", err)
+		}
+	} else if cfg.ReplayCmdlineFile != "" {
+		var err error
+		if devicePlan, err = readDevicePlanFile(devicePlanPath(cfg.ReplayCmdlineFile)); err != nil {
+			return err
+		}
+	}
+	// A cold migration's plan decides how the replayed QEMU starts:
+	// waiting for the incoming state, or paused to boot the copied disks.
+	if cfg.ColdFromJob != "" {
+		if err := readColdPlan(ctx, &cfg); err != nil {
+			return err
+		}
 	}
 	if cfg.ReplayCmdlineFile != "" {
 		if err := spawnReplayedQEMU(ctx, &cfg); err != nil {
@@ -96,6 +142,22 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 	if cfg.MultifdChannels < 0 {
 		return fmt.Errorf("multifd channels must be non-negative, got %d", cfg.MultifdChannels)
 	}
+	if cfg.Compression == "" || cfg.ColdFromJob != "" {
+		// A cold migration's state moves uncompressed.
+		cfg.Compression = CompressionNone
+	}
+	if err := CheckCompression(cfg.Compression, 0, cfg.MultifdChannels); err != nil {
+		return err
+	}
+	if cfg.Compression == CompressionAuto {
+		if cfg.CompressionFromJob == "" {
+			return errors.New("compression auto requires the source Job to read the chosen method from")
+		}
+		if err := readCompressionPlan(ctx, &cfg); err != nil {
+			return err
+		}
+	}
+	xbzrleDisablesMultifd(cfg.Compression, &cfg.MultifdChannels)
 	if cfg.TapIface != "" {
 		if err := validateTapIface(cfg.TapIface); err != nil {
 			return fmt.Errorf("validating tap interface: %w", err)
@@ -106,7 +168,7 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 			return fmt.Errorf("validating tap netns: %w", err)
 		}
 	}
-	if !cfg.SharedStorage {
+	if !cfg.SharedStorage && !isAutoDriveIDs(cfg.DriveIDs) {
 		if err := validateDriveIDs(cfg.DriveIDs); err != nil {
 			return fmt.Errorf("validating drive IDs: %w", err)
 		}
@@ -125,6 +187,7 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 		"tap_netns", cfg.TapNetns,
 		"shared_storage", cfg.SharedStorage,
 		"multifd_channels", cfg.MultifdChannels,
+		"compression", cfg.Compression,
 		"drive_ids", cfg.DriveIDs,
 	)
 
@@ -176,50 +239,56 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 		}
 	}()
 
-	client, err := qmp.NewClient(ctx, cfg.QMPSocket)
+	hv, err := openHypervisor(ctx, cfg.Hypervisor, cfg.QMPSocket)
 	if err != nil {
-		return fmt.Errorf("connecting to destination QMP: %w", err)
+		return fmt.Errorf("connecting to destination %s: %w", cfg.Hypervisor, err)
 	}
 	defer func() {
-		if err := client.Close(); err != nil {
-			slog.Warn("Failed to close QMP client", "error", err)
+		if err := hv.Close(); err != nil {
+			slog.Warn("Failed to close hypervisor client", "error", err)
 		}
 	}()
-
-	// Step 2: Configure migration capabilities and open incoming listener.
-	// Capabilities must match the source's; otherwise the migration handshake
-	// fails with "Failed to peek at channel" or similar magic-mismatch errors.
-	caps := []qmp.MigrationCapability{
-		{Capability: "auto-converge", State: true},
-	}
-	if cfg.MultifdChannels > 0 {
-		caps = append(caps, qmp.MigrationCapability{Capability: "multifd", State: true})
-	}
-	if _, err = client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
-		Capabilities: caps,
-	}); err != nil {
-		return fmt.Errorf("setting destination migration capabilities: %w", err)
+	// Device replay, drive discovery, NBD and cold migration are QMP
+	// only; checkDestHypervisor keeps them off for other hypervisors.
+	var client *qmp.Client
+	if q, ok := hv.(*qemuHypervisor); ok {
+		client = q.client
+	}
+
+	// Recreate the source's hot-plugged devices so the device model
+	// matches the incoming migration stream.
+	if len(devicePlan) > 0 {
+		slog.Info("Replaying hot-plugged devices", "steps", len(devicePlan))
+		if err := replayDevices(ctx, client, devicePlan, &cfg); err != nil {
+			return fmt.Errorf("replaying source devices: %w", err)
+		}
 	}
-	if cfg.MultifdChannels > 0 {
-		if _, err = client.Execute(ctx, "migrate-set-parameters", qmp.MigrateSetParametersArgs{
-			MultifdChannels: int64(cfg.MultifdChannels),
-		}); err != nil {
-			return fmt.Errorf("setting destination migration parameters: %w", err)
+
+	if !cfg.SharedStorage && isAutoDriveIDs(cfg.DriveIDs) {
+		ids, err := resolveDestDrives(ctx, client, &cfg)
+		if err != nil {
+			return fmt.Errorf("resolving drives: %w", err)
+		}
+		slog.Info("Resolved drives to export", "drive_ids", ids)
+		cfg.DriveIDs = ids
+		if len(ids) == 0 {
+			slog.Info("No writable local drives to receive; skipping NBD server")
+			cfg.SharedStorage = true
 		}
-		slog.Info("Multifd enabled on destination", "channels", cfg.MultifdChannels)
 	}
 
-	// Starting QEMU with -incoming is incompatible with Kata's sandbox lifecycle
-	// (Kata kills the QEMU because kata-agent never connects via vsock in
-	// incoming mode), so we use a QMP command on the already-running instance.
-	incomingURI := fmt.Sprintf("tcp:[::]:%s", ramMigrationPort)
-	slog.Info("Opening incoming migration listener", "uri", incomingURI)
-	if _, err = client.Execute(ctx, "migrate-incoming", qmp.MigrateArgs{URI: incomingURI}); err != nil {
-		return fmt.Errorf("configuring incoming migration listener: %w", err)
+	// Step 2: Open the incoming migration listener. A cold boot receives
+	// no VM state, so it opens no listener.
+	migrationPort := portOr(cfg.MigrationPort, ramMigrationPort)
+	if cfg.coldBoot {
+		migrationPort = "0"
+		slog.Info("Cold boot: skipping incoming migration listener")
+	} else if err := hv.Receive(ctx, cfg, migrationPort); err != nil {
+		return err
 	}
-	slog.Info("Incoming migration listener ready", "uri", incomingURI)
 
 	nbdStarted := false
+	destNBDPort := portOr(cfg.NBDPort, nbdPort)
 	if !cfg.SharedStorage {
 		// Step 3: Start NBD server to receive storage mirroring from the source.
 		slog.Info("Starting NBD server for storage migration", "drives", len(cfg.DriveIDs))
@@ -232,7 +301,7 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 				Type: "inet",
 				Data: qmp.NBDServerAddrData{
 					Host: "::",
-					Port: nbdPort,
+					Port: destNBDPort,
 				},
 			},
 		}); err != nil {
@@ -250,19 +319,23 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 			}
 		}()
 
-		for _, driveID := range cfg.DriveIDs {
-			if _, err = client.Execute(ctx, "nbd-server-add", qmp.NBDServerAddArgs{
-				Device:   driveID,
-				Writable: true,
-			}); err != nil {
-				return fmt.Errorf("adding NBD export for drive %q: %w", driveID, err)
+		for _, t := range resolveBlockTargets(ctx, client, cfg.DriveIDs) {
+			if err = addNBDExport(ctx, client, t, true); err != nil {
+				return fmt.Errorf("adding NBD export for drive %q: %w", t.ID, err)
 			}
-			slog.Info("NBD export added", "drive_id", driveID)
+			slog.Info("NBD export added", "drive_id", t.ID, "node_name", t.Node)
 		}
-		slog.Info("NBD server listening", "addr", "[::]", "port", nbdPort, "exports", len(cfg.DriveIDs))
+		slog.Info("NBD server listening", "addr", "[::]", "port", destNBDPort, "exports", len(cfg.DriveIDs))
 	} else {
 		slog.Info("Shared storage mode: skipping NBD server setup")
+		destNBDPort = "0"
 	}
+	// Listener marker: reports the sandbox this migration landed in and
+	// the ports actually listening. The source waits for it before
+	// migrating (replay-cmdline mode), and the orchestrator hands the real
+	// sandbox ID to VM adoption.
+	markerf(ctx, destReadyMarker+"sandbox_id=%s migration_port=%s nbd_port=%s\n",
+		filepath.Base(filepath.Dir(cfg.QMPSocket)), migrationPort, destNBDPort)
 
 	// Step 4: Plug the network queue to begin catching in-flight packets.
 	//
@@ -276,11 +349,21 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 		slog.Info("Network queue plugged. Buffering in-flight packets", "tap_iface", tapIface)
 	}
 
-	// Step 5: Wait for the destination VM to resume.
-	slog.Info("Waiting for QEMU RESUME event")
-	if err = client.WaitForEvent(ctx, "RESUME", eventWaitTimeout); err != nil {
-		return fmt.Errorf("waiting for RESUME event: %w", err)
+	// Step 5: Wait for the destination VM to resume. A cold migration's
+	// VM arrives paused (or was never started) and is resumed here once
+	// the source has finished copying.
+	if cfg.ColdFromJob != "" {
+		if err := waitColdDone(ctx, client, cfg.ColdFromJob); err != nil {
+			return err
+		}
+	}
+	slog.Info("Waiting for the VM to resume")
+	if err := hv.WaitResumed(ctx); err != nil {
+		return err
 	}
+	// Cutover-end marker, the dest-side counterpart of
+	// KATAMARAN_VM_STOPPED.
+	markerf(ctx, "KATAMARAN_VM_RESUMED at_unix_ms=%d\n", time.Now().UnixMilli())
 	if qdiscInstalled {
 		slog.Info("VM resumed. Flushing buffered packets")
 	} else {
@@ -314,27 +397,37 @@ func RunDestination(ctx context.Context, cfg DestConfig) (retErr error) {
 		}
 	}
 
-	// Step 8: Broadcast Gratuitous ARP via QEMU's announce-self command.
-	// Unlike host-side arping (which sends the host tap MAC), announce-self
-	// emits GARP/RARP from the guest's actual MAC address on all NICs,
-	// ensuring switches learn the correct port-to-MAC binding.
+	// Step 8: Broadcast Gratuitous ARP from the guest's NICs.
 	// With OVN-based CNIs (OVN-Kubernetes, Kube-OVN), OVN handles port-chassis rebinding automatically.
 	// For other CNIs (Cilium, Calico, Flannel), GARP accelerates convergence.
-	slog.Info("Broadcasting Gratuitous ARP via QEMU announce-self")
+	slog.Info("Broadcasting Gratuitous ARP", "hypervisor", cfg.Hypervisor)
 	garpCtx, garpCancel := cleanupCtx(ctx)
 	defer garpCancel()
-	if _, err := client.Execute(garpCtx, "announce-self", qmp.AnnounceSelfArgs{
-		Initial: garpInitialMS,
-		Max:     garpMaxMS,
-		Rounds:  garpRounds,
-		Step:    garpStepMS,
-	}); err != nil {
-		return fmt.Errorf("GARP announce-self failed: %w", err)
+	if err := hv.Announce(garpCtx); errors.Is(err, errors.ErrUnsupported) {
+		slog.Warn("Skipping Gratuitous ARP; the CNI or guest traffic must refresh the VM's MAC binding", "reason", err)
+	} else if err != nil {
+		return err
+	} else {
+		slog.Info("GARP announce-self scheduled", "rounds", garpRounds)
+	}
+
+	if cfg.Balloon {
+		if err := hv.RestoreMemory(ctx); errors.Is(err, errors.ErrUnsupported) {
+			slog.Info("Skipping balloon deflation", "reason", err)
+		} else if err != nil {
+			slog.Warn("Failed to deflate balloon; the guest keeps the memory it gave up on the source", "error", err)
+		}
+	}
+
+	if err := runHooks(ctx, HookPhasePostResume, cfg.PostResumeHooks, destHookTarget(cfg)); err != nil {
+		return err
 	}
-	slog.Info("GARP announce-self scheduled", "rounds", garpRounds)
 
 	slog.Info("Destination setup complete", "elapsed", time.Since(destStart).Round(time.Millisecond))
 
+	if client == nil {
+		return nil
+	}
 	// Best-effort: write migration-meta.json so the factory server can
 	// adopt this VM. Failures are logged but never fail the migration.
 	writeMigrationMeta(ctx, cfg, client)
@@ -480,6 +573,7 @@ var adoptedCgroupRoot = "/sys/fs/cgroup/katamaran-adopted"
 func writeMigrationMeta(ctx context.Context, cfg DestConfig, client *qmp.Client) {
 	type migrationMeta struct {
 		ID              string          `json:"id"`
+		MigrationID     string          `json:"migration_id,omitempty"`
 		QEMUPid         int             `json:"qemu_pid"`
 		QMPSocket       string          `json:"qmp_socket"`
 		VsockCID        uint32          `json:"vsock_cid"`
@@ -494,8 +588,9 @@ func writeMigrationMeta(ctx context.Context, cfg DestConfig, client *qmp.Client)
 
 	sandboxID := filepath.Base(filepath.Dir(cfg.QMPSocket))
 	meta := migrationMeta{
-		ID:        sandboxID,
-		QMPSocket: cfg.QMPSocket,
+		ID:          sandboxID,
+		MigrationID: cfg.MigrationID,
+		QMPSocket:   cfg.QMPSocket,
 	}
 
 	// Read QEMU PID from the pid file next to the QMP socket. A missing or
@@ -518,11 +613,16 @@ func writeMigrationMeta(ctx context.Context, cfg DestConfig, client *qmp.Client)
 		slog.Info("Dest VM status after migration", "status", string(raw))
 	}
 
-	// Try to load VMConfig from any sandbox persist.json on this node.
+	// A restored checkpoint carries its own VMConfig. Otherwise try to
+	// load it from any sandbox persist.json on this node.
 	// We scan all sandboxes, not just ours — VMConfig is the same across
 	// all Kata pods on the same node (same Kata version + config).
 	persistBytes, persistPath := findAnyPersistJSON()
-	if persistBytes != nil {
+	if len(cfg.vmConfig) > 0 {
+		meta.VMConfig = cfg.vmConfig
+		meta.AgentConfig = cfg.agentConfig
+		slog.Info("Using VMConfig from checkpoint bundle")
+	} else if persistBytes != nil {
 		var persist struct {
 			HypervisorState json.RawMessage `json:"HypervisorState"`
 			Config          struct {
@@ -555,7 +655,7 @@ func writeMigrationMeta(ctx context.Context, cfg DestConfig, client *qmp.Client)
 			srcRef = cfg.SourcePodRef
 		}
 		if srcRef != "" {
-			vmCfg, agentCfg := fetchVMConfigFromPodLog(ctx, srcRef)
+			vmCfg, agentCfg := fetchVMConfigFromPodLog(ctx, srcRef, cfg.HandoffKey)
 			if len(vmCfg) > 0 {
 				meta.VMConfig = vmCfg
 				meta.AgentConfig = agentCfg
@@ -2,6 +2,7 @@ package migration
 
 import (
 	"context"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
@@ -43,6 +44,24 @@ func TestRunDestination_Failures(t *testing.T) {
 	}
 }
 
+func TestRunDestination_RequiresHandoffKey(t *testing.T) {
+	t.Parallel()
+	for name, cfg := range map[string]DestConfig{
+		"replay from pod":   {ReplayCmdlineFromPod: "myns/mypod"},
+		"VMConfig from pod": {SourcePodRef: "myns/mypod"},
+	} {
+		cfg.QMPSocket = "/nonexistent/qmp.sock"
+		cfg.DriveIDs = []string{"drive-virtio-disk0"}
+		if err := RunDestination(context.Background(), cfg); !errors.Is(err, errNoHandoffKey) {
+			t.Errorf("%s: err = %v, want %v", name, err, errNoHandoffKey)
+		}
+		cfg.AllowUnsignedHandoff = true
+		if err := RunDestination(context.Background(), cfg); errors.Is(err, errNoHandoffKey) {
+			t.Errorf("%s: opt-out still rejected: %v", name, err)
+		}
+	}
+}
+
 func TestRunDestination_ContextCancelled(t *testing.T) {
 	t.Parallel()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -473,6 +492,40 @@ func TestRunDestination_NonShared_CommandArguments(t *testing.T) {
 	}
 }
 
+func TestRunDestination_PerMigrationPorts(t *testing.T) {
+	t.Parallel()
+
+	sock, rec := startRecordingQMP(t, func(conn net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "nbd-server-add":
+			return `{"return":{}}` + "\n" + `{"event":"RESUME"}`
+		default:
+			return `{"return":{}}`
+		}
+	})
+
+	err := RunDestination(context.Background(), DestConfig{
+		QMPSocket:     sock,
+		DriveIDs:      []string{"drive-virtio-disk0"},
+		MigrationPort: 4447,
+		NBDPort:       10812,
+	})
+	if err != nil {
+		t.Fatalf("RunDestination: %v", err)
+	}
+	commands := rec.Commands()
+	var incoming qmp.MigrateArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate-incoming"), &incoming)
+	if incoming.URI != "tcp:[::]:4447" {
+		t.Fatalf("migrate-incoming URI = %q, want tcp:[::]:4447", incoming.URI)
+	}
+	var start qmp.NBDServerStartArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "nbd-server-start"), &start)
+	if start.Addr.Data.Port != "10812" {
+		t.Fatalf("nbd-server-start port = %q, want 10812", start.Addr.Data.Port)
+	}
+}
+
 // TestSurviveContainerExit_HappyPath simulates the cgroup re-parent
 // against a tmpdir-rooted fake cgroup tree. Locks the contract that
 // surviveContainerExit reads <qmpDir>/pid, creates the per-sandbox
@@ -0,0 +1,203 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// destReadyMarker is printed by RunDestination once migrate-incoming and
+// the NBD exports are listening. In replay-cmdline mode the source waits
+// for it (waitForDestReady) before issuing drive-mirror / migrate.
+const destReadyMarker = "KATAMARAN_DEST_READY "
+
+// defaultDestReadyTimeout bounds waitForDestReady when SourceConfig leaves
+// DestReadyTimeout zero. Covers dest pod scheduling, image pull, the
+// cmdline fetch from our own log and the replayed QEMU spawn on a cold
+// node, which took up to ~40s in live e2e.
+const defaultDestReadyTimeout = 5 * time.Minute
+
+// destReadyPollInterval is how often waitForDestReady re-reads the dest
+// pod. Var-not-const so tests can collapse it.
+var destReadyPollInterval = 2 * time.Second
+
+// jobPodList is the minimal shape decoded from the apiserver pod list.
+type jobPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// waitForDestReady polls the pods of the destination Job ref
+// (<namespace>/<job>) through the in-cluster apiserver until one of them
+// logs KATAMARAN_DEST_READY, and returns the marker's key=value fields.
+//
+// This replaces a fixed sleep: the source cannot probe the dest's
+// migration port directly because QEMU's incoming listener dies on a
+// connection that closes without sending the migration stream. It fails
+// fast when a dest pod terminates before becoming ready and when timeout
+// elapses.
+func waitForDestReady(ctx context.Context, ref string, timeout time.Duration) (map[string]string, error) {
+	if timeout <= 0 {
+		timeout = defaultDestReadyTimeout
+	}
+	slog.Info("Waiting for destination listener", "dest_job", ref, "timeout", timeout)
+	start := time.Now()
+	fields, err := waitForJobMarker(ctx, ref, destReadyMarker, jobPeer{role: "destination", event: "its listener became ready"}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Destination listener ready", "dest_job", ref, "elapsed", time.Since(start).Round(time.Millisecond),
+		"sandbox_id", fields["sandbox_id"], "migration_port", fields["migration_port"], "nbd_port", fields["nbd_port"])
+	return fields, nil
+}
+
+// jobPeer describes the other side of a migration for waitForJobMarker's
+// errors: role is "source" or "destination", event what it never did.
+type jobPeer struct {
+	role, event string
+}
+
+// waitForJobMarker polls the pods of Job ref (<namespace>/<job>) until one
+// of them logs marker and returns the marker's key=value fields. It fails
+// fast when a pod terminates first and after timeout (zero uses
+// defaultDestReadyTimeout).
+func waitForJobMarker(ctx context.Context, ref, marker string, peer jobPeer, timeout time.Duration) (map[string]string, error) {
+	ns, job, err := parsePodRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s job ref: %w", peer.role, err)
+	}
+	client, base, token, err := newAPIServerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer client.CloseIdleConnections()
+	if timeout <= 0 {
+		timeout = defaultDestReadyTimeout
+	}
+	deadline, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	q := url.Values{}
+	q.Set("labelSelector", "batch.kubernetes.io/job-name="+job)
+	listURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?%s", base, url.PathEscape(ns), q.Encode())
+
+	for attempt := 1; ; attempt++ {
+		fields, err := checkJobMarker(deadline, client, base, listURL, token, ns, marker, peer)
+		var exited *peerExitedError
+		switch {
+		case errors.As(err, &exited):
+			return nil, err
+		case err != nil && !errors.Is(err, errMarkerNotYet) && deadline.Err() == nil:
+			logPodLogFetchRetry(peer.role+" marker check failed", attempt, "job", ref, "marker", strings.TrimSpace(marker), "error", err)
+		case fields != nil:
+			return fields, nil
+		}
+		select {
+		case <-deadline.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%s job %s did not report %s within %s", peer.role, ref, strings.TrimSpace(marker), timeout)
+		case <-time.After(destReadyPollInterval):
+		}
+	}
+}
+
+// errMarkerNotYet means the Job's pod is up but has not printed the marker.
+var errMarkerNotYet = errors.New("marker not printed yet")
+
+// peerExitedError reports a Job pod that terminated without printing the
+// awaited marker; waiting longer cannot help.
+type peerExitedError struct {
+	pod, phase string
+	peer       jobPeer
+}
+
+func (e *peerExitedError) Error() string {
+	return fmt.Sprintf("%s pod %s %s before %s", e.peer.role, e.pod, strings.ToLower(e.phase), e.peer.event)
+}
+
+// checkJobMarker does one pass over the Job's pods.
+func checkJobMarker(ctx context.Context, client *http.Client, base, listURL, token, ns, marker string, peer jobPeer) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("apiserver returned %d listing %s pods", resp.StatusCode, peer.role)
+	}
+	var pods jobPodList
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("decode %s pod list: %w", peer.role, err)
+	}
+	for _, p := range pods.Items {
+		switch p.Status.Phase {
+		case "Failed", "Succeeded":
+			// Both sides only exit after a migration; before one they
+			// failed. A cold source exits right after its last marker, so
+			// the log of an exited pod still counts.
+			if markers, _, err := scanPodLogMarkers(ctx, client, podLogEndpoint(base, ns, p.Metadata.Name), token, marker); err == nil {
+				if v, ok := markers[marker]; ok {
+					return parseMarkerFields(v), nil
+				}
+			}
+			return nil, &peerExitedError{pod: p.Metadata.Name, phase: p.Status.Phase, peer: peer}
+		case "Running":
+			markers, _, err := scanPodLogMarkers(ctx, client, podLogEndpoint(base, ns, p.Metadata.Name), token, marker)
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := markers[marker]; ok {
+				return parseMarkerFields(v), nil
+			}
+		}
+	}
+	return nil, errMarkerNotYet
+}
+
+// parseMarkerFields parses space-separated key=value pairs.
+func parseMarkerFields(s string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Fields(s) {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// checkDestPorts rejects a ready destination listening on other ports
+// than the source would dial — a mismatched --migration-port/--nbd-port
+// would otherwise surface as a connection refused mid-migration.
+func checkDestPorts(cfg SourceConfig, fields map[string]string) error {
+	if got, want := fields["migration_port"], portOr(cfg.MigrationPort, ramMigrationPort); got != "" && got != want {
+		return fmt.Errorf("destination listens for RAM migration on port %s, source is configured for %s", got, want)
+	}
+	if cfg.SharedStorage {
+		return nil
+	}
+	if got, want := fields["nbd_port"], portOr(cfg.NBDPort, nbdPort); got != "" && got != want {
+		return fmt.Errorf("destination NBD server listens on port %s, source is configured for %s", got, want)
+	}
+	return nil
+}
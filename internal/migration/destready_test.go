@@ -0,0 +1,93 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// destReadyServer fakes the apiserver for one dest Job pod. phase and
+// logBody are consulted per request so tests can flip them mid-wait.
+func destReadyServer(t *testing.T, phase func() string, logBody func() string) {
+	t.Helper()
+	prev := destReadyPollInterval
+	destReadyPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() { destReadyPollInterval = prev })
+	setupAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/kube-system/pods":
+			if got := r.URL.Query().Get("labelSelector"); got != "batch.kubernetes.io/job-name=katamaran-dest-abc" {
+				t.Errorf("labelSelector = %q", got)
+			}
+			_, _ = fmt.Fprintf(w, `{"items":[{"metadata":{"name":"katamaran-dest-abc-x1"},"status":{"phase":%q}}]}`, phase())
+		case "/api/v1/namespaces/kube-system/pods/katamaran-dest-abc-x1/log":
+			assertPodLogRequest(t, r, "kube-system", "katamaran-dest-abc-x1")
+			_, _ = fmt.Fprint(w, logBody())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestWaitForDestReady_ReturnsMarkerFields(t *testing.T) {
+	var polls atomic.Int32
+	destReadyServer(t,
+		func() string {
+			if polls.Add(1) < 2 {
+				return "Pending"
+			}
+			return "Running"
+		},
+		func() string {
+			if polls.Load() < 3 {
+				return "time=... msg=\"Opening incoming migration listener\"\n"
+			}
+			return "noise\\nKATAMARAN_DEST_READY sandbox_id=katamaran-dest-abc migration_port=4445 nbd_port=10810\n"
+		})
+
+	fields, err := waitForDestReady(context.Background(), "kube-system/katamaran-dest-abc", time.Second)
+	if err != nil {
+		t.Fatalf("waitForDestReady: %v", err)
+	}
+	if fields["sandbox_id"] != "katamaran-dest-abc" || fields["migration_port"] != "4445" || fields["nbd_port"] != "10810" {
+		t.Fatalf("fields = %v", fields)
+	}
+	if err := checkDestPorts(SourceConfig{MigrationPort: 4445, NBDPort: 10810}, fields); err != nil {
+		t.Fatalf("checkDestPorts: %v", err)
+	}
+	if err := checkDestPorts(SourceConfig{}, fields); err == nil || !strings.Contains(err.Error(), "port 4445") {
+		t.Fatalf("checkDestPorts with default ports: err = %v, want mismatch", err)
+	}
+}
+
+func TestWaitForDestReady_FailsFastWhenDestExits(t *testing.T) {
+	destReadyServer(t, func() string { return "Failed" }, func() string { return "" })
+
+	start := time.Now()
+	_, err := waitForDestReady(context.Background(), "kube-system/katamaran-dest-abc", time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "katamaran-dest-abc-x1 failed before its listener became ready") {
+		t.Fatalf("err = %v, want dest exited error", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("waitForDestReady did not fail fast (%s)", time.Since(start))
+	}
+}
+
+func TestWaitForDestReady_Timeout(t *testing.T) {
+	destReadyServer(t, func() string { return "Running" }, func() string { return "still starting\n" })
+
+	_, err := waitForDestReady(context.Background(), "kube-system/katamaran-dest-abc", 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not report KATAMARAN_DEST_READY within 50ms") {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
+
+func TestWaitForDestReady_InvalidRef(t *testing.T) {
+	if _, err := waitForDestReady(context.Background(), "no-slash", time.Second); err == nil || !strings.Contains(err.Error(), "invalid destination job ref") {
+		t.Fatalf("err = %v, want invalid ref", err)
+	}
+}
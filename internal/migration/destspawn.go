@@ -5,12 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"runtime/debug"
 	"strings"
 	"syscall"
@@ -31,12 +33,20 @@ var (
 	// root for the production /run path.
 	kataSharedSandboxRoot = "/run/kata-containers/shared/sandboxes"
 
-	// destReplayDefaultQEMU is the QEMU binary path used to spawn the
-	// destination QEMU when DestConfig.QEMUBinary is empty. The captured
-	// cmdline's argv[0] is intentionally NOT used: a compromised source pod
-	// could write an arbitrary path there and exec a non-QEMU binary on the
-	// dest node (see spawnReplayedQEMU).
-	destReplayDefaultQEMU = "/opt/kata/bin/qemu-system-x86_64"
+	// destReplayQEMUBinaries maps a host GOARCH to the Kata QEMU binary used
+	// to spawn the destination QEMU when DestConfig.QEMUBinary is empty. It
+	// doubles as the allowlist of architectures cmdline replay supports. The
+	// captured cmdline's argv[0] is intentionally NOT used: a compromised
+	// source pod could write an arbitrary path there and exec a non-QEMU
+	// binary on the dest node (see spawnReplayedQEMU).
+	destReplayQEMUBinaries = map[string]string{
+		"amd64": "/opt/kata/bin/qemu-system-x86_64",
+		"arm64": "/opt/kata/bin/qemu-system-aarch64",
+	}
+
+	// hostArch is the architecture of the node the destination runs on.
+	// Test seam: tests replace it to exercise the other architecture.
+	hostArch = runtime.GOARCH
 
 	// destReplayVirtiofsd is the virtiofsd binary path Kata installs.
 	destReplayVirtiofsd = "/opt/kata/libexec/virtiofsd"
@@ -52,9 +62,10 @@ var (
 	// UNIX socket before QEMU connects.
 	destReplayVirtiofsdSettleDelay = 2 * time.Second
 
-	// destReplaySleep is the fixed wait the source uses (in replay mode) for
-	// the dest QEMU to be up, instead of TCP-probing. See source.go for why
-	// the probe is incompatible with QEMU's migration peek. Sized to cover:
+	// destReplaySleep is the fixed wait the source falls back to (in replay
+	// mode without SourceConfig.DestReadyFrom) for the dest QEMU to be up,
+	// instead of TCP-probing. See source.go for why the probe is
+	// incompatible with QEMU's migration peek. Sized to cover:
 	// dest pod scheduling + image pull + virtiofsd start + QEMU spawn +
 	// QMP set-capabilities + migrate-incoming open. Empirically ~10-15s on
 	// a warm cluster, but can stretch to 30-40s when the dest node is
@@ -66,7 +77,8 @@ var (
 )
 
 // memPathRegex matches `mem-path=<path>` clauses inside a memory-backend-file
-// argument. Used to locate the nvdimm image path in the captured source cmdline.
+// argument. Used to locate the nvdimm (x86) or virtio-pmem (arm64) image
+// path in the captured source cmdline.
 // We reject any match where the path lives under /dev/shm because that's the
 // guest RAM backing file (also a memory-backend-file), not the nvdimm image.
 var memPathRegex = regexp.MustCompile(`mem-path=([^,]+)`)
@@ -92,6 +104,72 @@ var nvdimmPathAllowedPrefixes = []string{
 // transferred nvdimm pages.
 var readonlyRegex = regexp.MustCompile(`,readonly=(?:on|true)`)
 
+// driveFileRegex matches the `file=<path>` clause of a -drive argument.
+var driveFileRegex = regexp.MustCompile(`(?:^|,)file=([^,]+)`)
+
+// keepsReadonly reports whether a -drive argument must stay read-only on
+// the destination. On arm64 Kata disables the nvdimm rootfs and attaches
+// the image as a read-only virtio-blk drive, and the firmware as a pflash
+// drive. Neither has contents in the migration stream: both sides map the
+// same node-local file. virtio-blk also advertises VIRTIO_BLK_F_RO, which
+// the guest acknowledged on the source; a writable dest drive would drop
+// the feature bit and QEMU rejects the incoming device state. Only Kata's
+// own image roots qualify, so the rule cannot be used to pin an arbitrary
+// drive read-only.
+func keepsReadonly(drive string) bool {
+	if strings.Contains(drive, "if=pflash") {
+		return true
+	}
+	m := driveFileRegex.FindStringSubmatch(drive)
+	return m != nil && isAllowedNvdimmPath(m[1])
+}
+
+// cmdlineArch returns the GOARCH a captured cmdline was built for, from its
+// machine type: q35/pc/microvm are x86, virt is the arm64 layout. argv[0]
+// decides when there is no -machine. Returns "" when neither says.
+func cmdlineArch(args []string) string {
+	for i := 1; i+1 < len(args); i++ {
+		if args[i] != "-machine" && args[i] != "-M" {
+			continue
+		}
+		machine, _, _ := strings.Cut(args[i+1], ",")
+		machine = strings.TrimPrefix(machine, "type=")
+		switch {
+		case machine == "q35", machine == "microvm", strings.HasPrefix(machine, "pc"):
+			return "amd64"
+		case strings.HasPrefix(machine, "virt"):
+			return "arm64"
+		}
+	}
+	if len(args) > 0 {
+		switch filepath.Base(args[0]) {
+		case "qemu-system-x86_64":
+			return "amd64"
+		case "qemu-system-aarch64":
+			return "arm64"
+		}
+	}
+	return ""
+}
+
+// replayQEMUBinary returns the QEMU binary to spawn on this host: the
+// configured override when absolute, else the allowlisted Kata binary for
+// hostArch.
+func replayQEMUBinary(configured string) (string, error) {
+	def, ok := destReplayQEMUBinaries[hostArch]
+	if !ok {
+		return "", fmt.Errorf("cmdline replay is not supported on %s hosts", hostArch)
+	}
+	if configured == "" {
+		return def, nil
+	}
+	if !filepath.IsAbs(configured) {
+		slog.Warn("configured QEMU binary is not absolute, falling back to default", "configured", configured, "fallback", def)
+		return def, nil
+	}
+	return configured, nil
+}
+
 // extractNvdimmPath finds the nvdimm image path in the captured cmdline.
 // It returns the first mem-path= value that is not under /dev/shm and that
 // lives under a known Kata image root (see nvdimmPathAllowedPrefixes).
@@ -145,8 +223,11 @@ func isAllowedNvdimmPath(p string) bool {
 // Substitutions performed:
 //   - srcSandboxDir → dstSandboxDir (typically /run/vc/vm/<src> → /run/vc/vm/<dst>)
 //   - sandbox-<srcID> → sandbox-<dstID> (kata-agent / virtiofs share-dir paths)
+//   - the source's shared dir under kataSharedSandboxRoot → the dest's
+//     (the 9p -fsdev path)
 //   - srcNvdimmPath → dstNvdimmPath (writable copy)
-//   - strip ,readonly=on / ,readonly=true on the nvdimm backend
+//   - strip ,readonly=on / ,readonly=true on the nvdimm/pmem backend, but
+//     not on Kata image or firmware drives (see keepsReadonly)
 //   - drop existing -daemonize and -incoming <arg>
 //   - append -incoming defer (QEMU runs in the foreground; see body for why)
 //
@@ -163,10 +244,12 @@ func transformCmdline(args []string, srcSandboxDir, dstSandboxDir, srcSandboxID,
 	skipNext := false
 	// Pre-compose the sandbox-id replacement keys once; transformCmdline runs
 	// against potentially hundreds of argv entries.
-	var srcSandboxKey, dstSandboxKey string
+	var srcSandboxKey, dstSandboxKey, srcSharedKey, dstSharedKey string
 	if srcSandboxID != "" && dstSandboxID != "" {
 		srcSandboxKey = "sandbox-" + srcSandboxID
 		dstSandboxKey = "sandbox-" + dstSandboxID
+		srcSharedKey = filepath.Join(kataSharedSandboxRoot, srcSandboxID) + "/"
+		dstSharedKey = filepath.Join(kataSharedSandboxRoot, dstSandboxID) + "/"
 	}
 	for i := 1; i < len(args); i++ {
 		a := args[i]
@@ -232,10 +315,13 @@ func transformCmdline(args []string, srcSandboxDir, dstSandboxDir, srcSandboxID,
 		if srcSandboxKey != "" && strings.Contains(a, srcSandboxKey) {
 			a = strings.ReplaceAll(a, srcSandboxKey, dstSandboxKey)
 		}
+		if srcSharedKey != "" && strings.Contains(a, srcSharedKey) {
+			a = strings.ReplaceAll(a, srcSharedKey, dstSharedKey)
+		}
 		if srcNvdimmPath != "" && dstNvdimmPath != "" && strings.Contains(a, srcNvdimmPath) {
 			a = strings.ReplaceAll(a, srcNvdimmPath, dstNvdimmPath)
 		}
-		if strings.Contains(a, "readonly=") {
+		if strings.Contains(a, "readonly=") && (args[i-1] != "-drive" || !keepsReadonly(a)) {
 			a = readonlyRegex.ReplaceAllString(a, "")
 		}
 		out = append(out, a)
@@ -248,6 +334,23 @@ func transformCmdline(args []string, srcSandboxDir, dstSandboxDir, srcSandboxID,
 	return binary, out, nil
 }
 
+// destReplayDefaultTap is the host tap transformCmdline attaches the
+// replayed QEMU's tap netdev to.
+const destReplayDefaultTap = "tap0_kata"
+
+// replayTapName returns the host tap for a replay sandbox. The dest Job
+// runs under hostNetwork, so concurrent replays on one node each need
+// their own tap; the default sandbox keeps tap0_kata. Hashed to stay
+// within IFNAMSIZ.
+func replayTapName(sandboxID string) string {
+	if sandboxID == destReplayDefaultSandbox {
+		return destReplayDefaultTap
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sandboxID))
+	return fmt.Sprintf("tapkm%08x", h.Sum32())
+}
+
 // stripFDKeys removes fd, fds, vhostfd, and vhostfds key=value pairs from a
 // comma-separated QEMU arg value. Used when respawning a captured cmdline:
 // fd= references point at file descriptors inherited from the kata-shim
@@ -309,10 +412,9 @@ func parseCmdlineBytes(data []byte) []string {
 // does not exist. The caller (RunSource) emits the
 // KATAMARAN_CMDLINE_AT / KATAMARAN_CMDLINE_B64 markers on success.
 func captureSourceCmdline(qemuPID int, outPath string) error {
-	src := fmt.Sprintf("/proc/%d/cmdline", qemuPID)
-	raw, err := os.ReadFile(src)
+	raw, err := readQEMUCmdline(qemuPID)
 	if err != nil {
-		return fmt.Errorf("read %s: %w", src, err)
+		return fmt.Errorf("read /proc/%d/cmdline: %w", qemuPID, err)
 	}
 	args := parseCmdlineBytes(raw)
 	if len(args) == 0 {
@@ -393,6 +495,14 @@ func spawnReplayedQEMU(ctx context.Context, cfg *DestConfig) error {
 	if len(args) < 2 {
 		return fmt.Errorf("cmdline file %s has too few args (%d), need argv[0] + at least one flag", cfg.ReplayCmdlineFile, len(args))
 	}
+	if err := validateReplayArgs(args); err != nil {
+		return err
+	}
+	// A q35 cmdline cannot boot on an arm64 node (or virt on x86); fail
+	// before copying images and starting virtiofsd.
+	if arch := cmdlineArch(args); arch != "" && arch != hostArch {
+		return fmt.Errorf("captured cmdline is for %s, destination host is %s", arch, hostArch)
+	}
 
 	dstSandboxID := cfg.SandboxID
 	if dstSandboxID == "" {
@@ -437,9 +547,15 @@ func spawnReplayedQEMU(ctx context.Context, cfg *DestConfig) error {
 	// Pre-create the tap interface QEMU expects. Without this, QEMU's
 	// `-netdev tap,ifname=tap0_kata,script=no` falls back to running an
 	// ifup script and fails. Idempotent: ignore "exists" errors.
-	if err := setupTapIface(ctx, "tap0_kata"); err != nil {
+	tapName := replayTapName(dstSandboxID)
+	if err := setupTapIface(ctx, tapName); err != nil {
 		return fmt.Errorf("setup tap iface: %w", err)
 	}
+	// The sch_plug buffering targets this migration's tap, not another
+	// replay's tap0_kata on the same host network.
+	if cfg.TapIface == destReplayDefaultTap {
+		cfg.TapIface = tapName
+	}
 
 	// Start virtiofsd. e2e.sh:518 nohups the daemon; we use exec.Cmd with
 	// detached stdio + Setpgid so the process survives our exit if needed.
@@ -452,17 +568,25 @@ func spawnReplayedQEMU(ctx context.Context, cfg *DestConfig) error {
 	if err != nil {
 		return fmt.Errorf("transform cmdline: %w", err)
 	}
+	if cfg.coldBoot {
+		// Nothing is incoming: start with the vCPUs stopped instead, so
+		// the guest boots only after the disks are copied.
+		qemuArgs = append(qemuArgs[:len(qemuArgs)-2], "-S")
+	}
+	if tapName != destReplayDefaultTap {
+		for i := 1; i < len(qemuArgs); i++ {
+			if qemuArgs[i-1] == "-netdev" {
+				qemuArgs[i] = strings.Replace(qemuArgs[i], "ifname="+destReplayDefaultTap, "ifname="+tapName, 1)
+			}
+		}
+	}
 	// Do not trust argv[0] from the captured cmdline as the binary to exec:
 	// a compromised source pod could write an arbitrary path there and use
 	// cmdline replay as a vector to exec a non-QEMU binary on the dest node.
 	// Pin to the configured override or the bundled Kata QEMU path.
-	binary := cfg.QEMUBinary
-	if binary == "" {
-		binary = destReplayDefaultQEMU
-	}
-	if !filepath.IsAbs(binary) {
-		slog.Warn("configured QEMU binary is not absolute, falling back to default", "configured", binary, "fallback", destReplayDefaultQEMU)
-		binary = destReplayDefaultQEMU
+	binary, err := replayQEMUBinary(cfg.QEMUBinary)
+	if err != nil {
+		return err
 	}
 
 	slog.Info("Spawning destination QEMU via cmdline replay",
@@ -179,6 +179,7 @@ func TestTransformCmdline_PathSubstitutions(t *testing.T) {
 		"/opt/kata/bin/qemu-system-x86_64",
 		"-qmp", "unix:" + srcDir + "/qmp.sock,server=on,wait=off",
 		"-chardev", "socket,id=charfs,path=" + srcDir + "/vhost-fs.sock",
+		"-fsdev", "local,id=extra-9p-kataShared,path=/run/kata-containers/shared/sandboxes/SRC-UUID/shared,security_model=none",
 		// Tokens that match `sandbox-<id>` in the cmdline (e.g. -name)
 		// must be remapped to the dest sandbox id.
 		"-name", "sandbox-SRC-UUID",
@@ -194,6 +195,9 @@ func TestTransformCmdline_PathSubstitutions(t *testing.T) {
 	if !strings.Contains(joined, "/run/vc/vm/DST-UUID/vhost-fs.sock") {
 		t.Fatalf("expected dst vhost-fs socket path, got: %s", joined)
 	}
+	if !strings.Contains(joined, "path=/run/kata-containers/shared/sandboxes/DST-UUID/shared,") {
+		t.Fatalf("expected dst shared dir, got: %s", joined)
+	}
 	if !strings.Contains(joined, "sandbox-DST-UUID") {
 		t.Fatalf("expected sandbox-DST-UUID, got: %s", joined)
 	}
@@ -297,6 +301,168 @@ func TestTransformCmdline_StripsInheritedFDs(t *testing.T) {
 	}
 }
 
+// arm64Cmdline is a captured Kata cmdline on an arm64 node: virt machine
+// with a host GIC, firmware in pflash, and the rootfs image as a read-only
+// virtio-blk drive (Kata disables the nvdimm rootfs on arm64).
+var arm64Cmdline = []string{
+	"/opt/kata/bin/qemu-system-aarch64",
+	"-name", "sandbox-SRC-UUID",
+	"-uuid", "5a9c1a4e-3c4f-4b51-9a51-0d7c0e2c7d11",
+	"-machine", "virt,usb=off,accel=kvm,gic-version=host",
+	"-cpu", "host,pmu=off",
+	"-qmp", "unix:fd=3,server=on,wait=off",
+	"-qmp", "unix:/run/vc/vm/SRC-UUID/extra-monitor.sock,server=on,wait=off",
+	"-m", "2048M,slots=10,maxmem=8G",
+	"-drive", "if=pflash,format=raw,readonly=on,file=/opt/kata/share/kata-containers/AAVMF_CODE.fd",
+	"-device", "pcie-root-port,port=0x10,chassis=1,id=rp0,bus=pcie.0,addr=0x2",
+	"-device", "virtio-blk-pci,disable-modern=false,drive=image-8d2f,config-wce=off,share-rw=on,serial=image-8d2f",
+	"-drive", "id=image-8d2f,file=/opt/kata/share/kata-containers/kata-ubuntu-noble.image,aio=threads,format=raw,if=none,readonly=on",
+	"-device", "vhost-vsock-pci,disable-modern=false,vhostfd=4,id=vsock-1,guest-cid=1",
+	"-chardev", "socket,id=char-fs,path=/run/vc/vm/SRC-UUID/vhost-fs.sock",
+	"-device", "vhost-user-fs-pci,chardev=char-fs,tag=kataShared,queue-size=1024",
+	"-netdev", "tap,id=network-0,vhost=on,vhostfds=5,fds=6",
+	"-object", "memory-backend-file,id=dimm1,size=2048M,mem-path=/dev/shm,share=on",
+	"-numa", "node,memdev=dimm1",
+	"-kernel", "/opt/kata/share/kata-containers/vmlinux.container",
+	"-append", "console=hvc0 root=/dev/vda1 rootflags=data=ordered,errors=remount-ro ro rootfstype=ext4",
+	"-daemonize",
+}
+
+// arm64PmemCmdline is the same VM with the rootfs on virtio-pmem instead.
+var arm64PmemCmdline = []string{
+	"/opt/kata/bin/qemu-system-aarch64",
+	"-machine", "virt,usb=off,accel=kvm,gic-version=3,its=on",
+	"-object", "memory-backend-file,id=dimm1,size=2048M,mem-path=/dev/shm,share=on",
+	"-object", "memory-backend-file,id=mem0,mem-path=/opt/kata/share/kata-containers/kata-ubuntu-noble.image,size=268435456,readonly=on",
+	"-device", "virtio-pmem-pci,memdev=mem0,id=nv0",
+}
+
+func TestTransformCmdline_Arm64VirtioBlkRootfs(t *testing.T) {
+	t.Parallel()
+	if got := extractNvdimmPath(arm64Cmdline); got != "" {
+		t.Fatalf("extractNvdimmPath = %q, want none (rootfs is virtio-blk)", got)
+	}
+	_, out, err := transformCmdline(arm64Cmdline, "/run/vc/vm/SRC-UUID", "/run/vc/vm/DST-UUID", "SRC-UUID", "DST-UUID", "", "")
+	if err != nil {
+		t.Fatalf("transformCmdline: %v", err)
+	}
+	want := map[string]bool{
+		// GIC and CPU model pass through: the GIC state is part of the
+		// migration stream and must be created the same way.
+		"virt,usb=off,accel=kvm,gic-version=host": false,
+		"host,pmu=off": false,
+		// Kata image and firmware drives keep readonly=on.
+		"id=image-8d2f,file=/opt/kata/share/kata-containers/kata-ubuntu-noble.image,aio=threads,format=raw,if=none,readonly=on": false,
+		"if=pflash,format=raw,readonly=on,file=/opt/kata/share/kata-containers/AAVMF_CODE.fd":                                   false,
+		"unix:/run/vc/vm/DST-UUID/extra-monitor.sock,server=on,wait=off":                                                        false,
+		"socket,id=char-fs,path=/run/vc/vm/DST-UUID/vhost-fs.sock":                                                              false,
+		"vhost-vsock-pci,disable-modern=false,id=vsock-1,guest-cid=1":                                                           false,
+		"tap,id=network-0,vhost=on,ifname=tap0_kata,script=no,downscript=no":                                                    false,
+	}
+	for _, a := range out {
+		if _, ok := want[a]; ok {
+			want[a] = true
+		}
+	}
+	for a, seen := range want {
+		if !seen {
+			t.Errorf("missing %q in transformed args: %v", a, out)
+		}
+	}
+	joined := strings.Join(out, " ")
+	if strings.Contains(joined, "SRC-UUID") || strings.Contains(joined, "-daemonize") || strings.Contains(joined, "fd=3") {
+		t.Errorf("source-only args survived: %v", out)
+	}
+}
+
+func TestTransformCmdline_Arm64VirtioPmemRootfs(t *testing.T) {
+	t.Parallel()
+	src := "/opt/kata/share/kata-containers/kata-ubuntu-noble.image"
+	if got := extractNvdimmPath(arm64PmemCmdline); got != src {
+		t.Fatalf("extractNvdimmPath = %q, want %q", got, src)
+	}
+	_, out, err := transformCmdline(arm64PmemCmdline, "", "", "", "", src, "/tmp/kata-dst-nvdimm-1.img")
+	if err != nil {
+		t.Fatalf("transformCmdline: %v", err)
+	}
+	if !slices.Contains(out, "memory-backend-file,id=mem0,mem-path=/tmp/kata-dst-nvdimm-1.img,size=268435456") {
+		t.Errorf("pmem backend not rewritten to a writable copy: %v", out)
+	}
+	if !slices.Contains(out, "virt,usb=off,accel=kvm,gic-version=3,its=on") {
+		t.Errorf("machine options changed: %v", out)
+	}
+}
+
+func TestKeepsReadonly(t *testing.T) {
+	t.Parallel()
+	for drive, want := range map[string]bool{
+		"if=pflash,format=raw,readonly=on,file=/usr/share/AAVMF/AAVMF_CODE.fd":         true,
+		"id=image-1,file=/opt/kata/share/kata-containers/kata.img,if=none,readonly=on": true,
+		"id=image-1,file=/opt/kata/../../etc/shadow,if=none,readonly=on":               false,
+		"file=/img,readonly=true,if=none":                                              false,
+	} {
+		if got := keepsReadonly(drive); got != want {
+			t.Errorf("keepsReadonly(%q) = %v, want %v", drive, got, want)
+		}
+	}
+}
+
+func TestCmdlineArch(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{arm64Cmdline, "arm64"},
+		{[]string{"/opt/kata/bin/qemu-system-x86_64", "-machine", "q35,accel=kvm,nvdimm=on"}, "amd64"},
+		{[]string{"qemu", "-M", "type=pc-i440fx-8.2"}, "amd64"},
+		{[]string{"/opt/kata/bin/qemu-system-aarch64", "-name", "vm"}, "arm64"},
+		{[]string{"/usr/bin/qemu-kvm", "-name", "vm"}, ""},
+	} {
+		if got := cmdlineArch(tc.args); got != tc.want {
+			t.Errorf("cmdlineArch(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestReplayQEMUBinary(t *testing.T) {
+	// Not parallel: swaps hostArch.
+	prev := hostArch
+	t.Cleanup(func() { hostArch = prev })
+
+	hostArch = "arm64"
+	if got, err := replayQEMUBinary(""); err != nil || got != "/opt/kata/bin/qemu-system-aarch64" {
+		t.Errorf("arm64 default = %q, %v", got, err)
+	}
+	if got, _ := replayQEMUBinary("qemu-system-aarch64"); got != "/opt/kata/bin/qemu-system-aarch64" {
+		t.Errorf("relative override = %q, want default", got)
+	}
+	hostArch = "amd64"
+	if got, err := replayQEMUBinary("/usr/local/bin/qemu"); err != nil || got != "/usr/local/bin/qemu" {
+		t.Errorf("override = %q, %v", got, err)
+	}
+	hostArch = "riscv64"
+	if _, err := replayQEMUBinary(""); err == nil {
+		t.Error("unsupported arch accepted")
+	}
+}
+
+func TestSpawnReplayedQEMU_ArchMismatch(t *testing.T) {
+	// Not parallel: swaps hostArch.
+	prev := hostArch
+	t.Cleanup(func() { hostArch = prev })
+	hostArch = "amd64"
+
+	tmp := filepath.Join(t.TempDir(), "cmdline")
+	if err := os.WriteFile(tmp, []byte(strings.Join(arm64Cmdline, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := spawnReplayedQEMU(context.Background(), &DestConfig{ReplayCmdlineFile: tmp})
+	if err == nil || !strings.Contains(err.Error(), "cmdline is for arm64") {
+		t.Fatalf("err = %v, want arch mismatch", err)
+	}
+}
+
 func TestReadCmdlineFile(t *testing.T) {
 	t.Parallel()
 	tmpDir := t.TempDir()
@@ -387,6 +553,7 @@ func TestSpawnReplayedQEMU_HappyPath_StubbedSpawn(t *testing.T) {
 		"-name", "sandbox-src-uuid",
 		"-qmp", "unix:" + srcSandboxDir + "/qmp.sock,server=on,wait=off",
 		"-object", "memory-backend-file,id=nvdimm,mem-path=" + srcNvdimm + ",size=512M,readonly=on",
+		"-netdev", "tap,id=network-0,fds=3:4",
 		"-incoming", "tcp:[::]:4444",
 		"-daemonize",
 	}, "\n") + "\n"
@@ -404,6 +571,12 @@ func TestSpawnReplayedQEMU_HappyPath_StubbedSpawn(t *testing.T) {
 	kataSharedSandboxRoot = filepath.Join(tmpDir, "kata-shared")
 	t.Cleanup(func() { kataSharedSandboxRoot = prevShared })
 
+	// The captured cmdline is x86; pin the host so the arch check passes
+	// on arm64 runners too.
+	prevArch := hostArch
+	hostArch = "amd64"
+	t.Cleanup(func() { hostArch = prevArch })
+
 	// extractNvdimmPath rejects mem-paths outside known Kata roots (defense
 	// against a compromised source pod injecting an arbitrary file path).
 	// The synthetic source nvdimm here lives under tmpDir, so widen the
@@ -447,7 +620,11 @@ func TestSpawnReplayedQEMU_HappyPath_StubbedSpawn(t *testing.T) {
 
 	// Stub setupTapIface so the test does not need CAP_NET_ADMIN.
 	prevTap := setupTapIface
-	setupTapIface = func(_ context.Context, _ string) error { return nil }
+	var tapCreated string
+	setupTapIface = func(_ context.Context, name string) error {
+		tapCreated = name
+		return nil
+	}
 	t.Cleanup(func() { setupTapIface = prevTap })
 
 	// Speed up waitForSocket.
@@ -524,6 +701,18 @@ func TestSpawnReplayedQEMU_HappyPath_StubbedSpawn(t *testing.T) {
 	if !strings.Contains(joined, dstSandboxID) {
 		t.Fatalf("expected dst sandbox id %q in qemu args, got: %s", dstSandboxID, joined)
 	}
+	// A non-default sandbox gets its own host tap so concurrent replays on
+	// one hostNetwork node don't share tap0_kata.
+	wantTap := replayTapName(dstSandboxID)
+	if wantTap == "tap0_kata" || len(wantTap) > 15 {
+		t.Fatalf("replayTapName(%q) = %q, want a unique name within IFNAMSIZ", dstSandboxID, wantTap)
+	}
+	if tapCreated != wantTap {
+		t.Fatalf("setupTapIface called with %q, want %q", tapCreated, wantTap)
+	}
+	if !strings.Contains(joined, "ifname="+wantTap) || strings.Contains(joined, "ifname=tap0_kata") {
+		t.Fatalf("expected netdev ifname=%s in qemu args, got: %s", wantTap, joined)
+	}
 }
 
 // createFakeSocket binds a UNIX socket at path so os.Stat reports it as a
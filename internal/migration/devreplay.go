@@ -0,0 +1,579 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Device replay. Kata hot-plugs container block devices, vCPUs, memory and
+// sometimes NICs via QMP after boot, so they are missing from the argv
+// captured by captureSourceCmdline. A replayed dest QEMU started from that
+// argv alone has a different device model than the source and the
+// migration stream is rejected (or the devices silently vanish). The
+// source therefore also captures a device inventory over QMP and turns it
+// into a replay plan: the object-add / blockdev-add / netdev_add /
+// device_add calls that recreate every hot-plugged device. The dest runs
+// the plan against its -incoming defer QEMU before migrate-incoming.
+
+// peripheralPath is the QOM container holding every device created with an
+// id, whether from -device or device_add.
+const peripheralPath = "/machine/peripheral"
+
+// deviceReplayStep is one QMP command in a device replay plan.
+type deviceReplayStep struct {
+	Execute   string          `json:"execute"`
+	Arguments json.RawMessage `json:"arguments"`
+	// Size is the source image's virtual size for blockdev-add steps; the
+	// dest uses it to create a blank target when the image path does not
+	// exist locally.
+	Size int64 `json:"size,omitempty"`
+}
+
+// deviceInventory is the raw QMP view of the source's devices.
+type deviceInventory struct {
+	PCI         []qmp.PCIInfo
+	CPUs        []qmp.HotpluggableCPU
+	Memory      []qmp.MemoryDeviceInfo
+	Block       []qmp.BlockInfo
+	Peripherals []qmp.ObjectPropertyInfo // qom-list /machine/peripheral
+	Objects     []qmp.ObjectPropertyInfo // qom-list /objects
+	// Props holds qom-get results keyed by "<qom path>#<property>".
+	Props map[string]json.RawMessage
+}
+
+// captureDeviceInventory runs the inventory queries against the source
+// QEMU. Properties device_add/object-add need but the query-* commands do
+// not report (NIC netdev and mac, file-backed memory paths) are read with
+// qom-get.
+func captureDeviceInventory(ctx context.Context, client *qmp.Client) (*deviceInventory, error) {
+	inv := &deviceInventory{Props: map[string]json.RawMessage{}}
+	for _, q := range []struct {
+		cmd  string
+		args qmp.Args
+		dst  any
+	}{
+		{"query-pci", nil, &inv.PCI},
+		{"query-hotpluggable-cpus", nil, &inv.CPUs},
+		{"query-memory-devices", nil, &inv.Memory},
+		{"query-block", nil, &inv.Block},
+		{"qom-list", qmp.QOMListArgs{Path: peripheralPath}, &inv.Peripherals},
+		{"qom-list", qmp.QOMListArgs{Path: "/objects"}, &inv.Objects},
+	} {
+		raw, err := client.Execute(ctx, q.cmd, q.args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.cmd, err)
+		}
+		if err := json.Unmarshal(raw, q.dst); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", q.cmd, err)
+		}
+	}
+
+	var gets []qmp.QOMGetArgs
+	for _, p := range inv.Peripherals {
+		if strings.Contains(qomChildType(p.Type), "-net-") {
+			dev := peripheralPath + "/" + p.Name
+			gets = append(gets, qmp.QOMGetArgs{Path: dev, Property: "netdev"}, qmp.QOMGetArgs{Path: dev, Property: "mac"})
+		}
+	}
+	for _, o := range inv.Objects {
+		if qomChildType(o.Type) == "memory-backend-file" {
+			obj := "/objects/" + o.Name
+			gets = append(gets, qmp.QOMGetArgs{Path: obj, Property: "mem-path"}, qmp.QOMGetArgs{Path: obj, Property: "share"})
+		}
+	}
+	for _, g := range gets {
+		raw, err := client.Execute(ctx, "qom-get", g)
+		if err != nil {
+			return nil, fmt.Errorf("qom-get %s %s: %w", g.Path, g.Property, err)
+		}
+		inv.Props[g.Path+"#"+g.Property] = raw
+	}
+	return inv, nil
+}
+
+// qomChildType extracts T from a qom-list "child<T>" type.
+func qomChildType(t string) string {
+	if s, ok := strings.CutPrefix(t, "child<"); ok {
+		return strings.TrimSuffix(s, ">")
+	}
+	return ""
+}
+
+// cmdlineIDs collects the ids the captured argv already creates: -device
+// ids, -object / -netdev ids and -blockdev node-names / -drive ids. These
+// exist on the replayed dest QEMU and must not be recreated.
+func cmdlineIDs(args []string) (devices, backends map[string]bool) {
+	devices, backends = map[string]bool{}, map[string]bool{}
+	for i := 1; i < len(args); i++ {
+		var dst map[string]bool
+		key := "id"
+		switch args[i-1] {
+		case "-device":
+			dst = devices
+		case "-object", "-netdev", "-drive":
+			dst = backends
+		case "-blockdev":
+			dst, key = backends, "node-name"
+		default:
+			continue
+		}
+		for opt := range strings.SplitSeq(args[i], ",") {
+			if k, v, ok := strings.Cut(opt, "="); ok && k == key {
+				dst[v] = true
+			}
+		}
+	}
+	return devices, backends
+}
+
+// pciRootBus names the root PCI bus of the captured machine type: pci.0 on
+// the i440fx "pc" machine, pcie.0 on q35 and virt.
+func pciRootBus(args []string) string {
+	for i := 1; i < len(args); i++ {
+		if args[i-1] != "-machine" && args[i-1] != "-M" {
+			continue
+		}
+		machine, _, _ := strings.Cut(args[i], ",")
+		machine = strings.TrimPrefix(machine, "type=")
+		if machine == "pc" || strings.HasPrefix(machine, "pc-i440fx") {
+			return "pci.0"
+		}
+	}
+	return "pcie.0"
+}
+
+// pciLocation is where device_add must place a PCI device.
+type pciLocation struct{ bus, addr string }
+
+// pciLocations maps qdev ids to their bus and slot from query-pci. Devices
+// behind a bridge or root port sit on the bus named after that port's id.
+func pciLocations(buses []qmp.PCIInfo, rootBus string) map[string]pciLocation {
+	out := map[string]pciLocation{}
+	var walk func(bus string, devs []qmp.PCIDeviceInfo)
+	walk = func(bus string, devs []qmp.PCIDeviceInfo) {
+		for _, d := range devs {
+			if d.QdevID != "" {
+				out[d.QdevID] = pciLocation{bus: bus, addr: fmt.Sprintf("0x%x.0x%x", d.Slot, d.Function)}
+			}
+			if d.PCIBridge != nil && d.QdevID != "" {
+				walk(d.QdevID, d.PCIBridge.Devices)
+			}
+		}
+	}
+	for _, b := range buses {
+		if b.Bus == 0 {
+			walk(rootBus, b.Devices)
+		}
+	}
+	return out
+}
+
+// planDeviceReplay turns the inventory into the ordered QMP calls that
+// recreate, on a QEMU started from args, every device the source gained
+// after boot. Backends (memory objects, block nodes, netdevs) precede the
+// device_add that references them.
+func planDeviceReplay(inv *deviceInventory, args []string) ([]deviceReplayStep, error) {
+	cmdDevices, cmdBackends := cmdlineIDs(args)
+	locs := pciLocations(inv.PCI, pciRootBus(args))
+	objTypes := map[string]string{}
+	for _, o := range inv.Objects {
+		objTypes[o.Name] = qomChildType(o.Type)
+	}
+
+	var steps []deviceReplayStep
+	add := func(execute string, a map[string]any, size int64) error {
+		raw, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("encode %s arguments: %w", execute, err)
+		}
+		steps = append(steps, deviceReplayStep{Execute: execute, Arguments: raw, Size: size})
+		return nil
+	}
+	prop := func(qomPath, name string, dst any) bool {
+		raw, ok := inv.Props[qomPath+"#"+name]
+		return ok && json.Unmarshal(raw, dst) == nil
+	}
+
+	peripherals := slices.Clone(inv.Peripherals)
+	slices.SortFunc(peripherals, func(a, b qmp.ObjectPropertyInfo) int { return cmp.Compare(a.Name, b.Name) })
+	for _, p := range peripherals {
+		driver := qomChildType(p.Type)
+		if driver == "" || cmdDevices[p.Name] {
+			continue
+		}
+		id, devPath := p.Name, peripheralPath+"/"+p.Name
+		dev := map[string]any{"driver": driver, "id": id}
+		if loc, ok := locs[id]; ok {
+			dev["bus"], dev["addr"] = loc.bus, loc.addr
+		}
+
+		for _, c := range inv.CPUs {
+			if c.QOMPath == devPath {
+				for k, v := range c.Props {
+					dev[k] = v
+				}
+			}
+		}
+
+		for _, m := range inv.Memory {
+			if m.Data.ID != id {
+				continue
+			}
+			memdev := path.Base(m.Data.Memdev)
+			if !cmdBackends[memdev] {
+				qomType := objTypes[memdev]
+				if qomType == "" {
+					return nil, fmt.Errorf("memory device %s: backend %s not found under /objects", id, m.Data.Memdev)
+				}
+				obj := map[string]any{"qom-type": qomType, "id": memdev, "size": m.Data.Size}
+				var memPath string
+				var share bool
+				if prop(m.Data.Memdev, "mem-path", &memPath) {
+					obj["mem-path"] = memPath
+				}
+				if prop(m.Data.Memdev, "share", &share) {
+					obj["share"] = share
+				}
+				if err := add("object-add", obj, 0); err != nil {
+					return nil, err
+				}
+				cmdBackends[memdev] = true
+			}
+			dev["memdev"] = memdev
+			if m.Type == "dimm" || m.Type == "nvdimm" {
+				dev["node"] = m.Data.Node
+			}
+		}
+
+		for _, b := range inv.Block {
+			if b.Inserted == nil || (b.Qdev != id && b.Qdev != devPath && !strings.HasPrefix(b.Qdev, devPath+"/")) {
+				continue
+			}
+			node := cmp.Or(b.Inserted.NodeName, b.Device)
+			if !cmdBackends[node] {
+				if err := add("blockdev-add", blockdevAddArgs(node, b.Inserted), b.Inserted.Image.VirtualSize); err != nil {
+					return nil, err
+				}
+				cmdBackends[node] = true
+			}
+			dev["drive"] = node
+		}
+
+		var netdev, mac string
+		if prop(devPath, "netdev", &netdev) && netdev != "" {
+			if !cmdBackends[netdev] {
+				// The source tap was handed to QEMU as an fd; the dest
+				// creates its own tap and fills in ifname.
+				if err := add("netdev_add", map[string]any{"type": "tap", "id": netdev, "script": "no", "downscript": "no"}, 0); err != nil {
+					return nil, err
+				}
+				cmdBackends[netdev] = true
+			}
+			dev["netdev"] = netdev
+			if prop(devPath, "mac", &mac) && mac != "" {
+				dev["mac"] = mac
+			}
+		}
+
+		if err := add("device_add", dev, 0); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// blockdevAddArgs rebuilds a blockdev-add for an inserted medium: a format
+// node over a file / host_device protocol node, or the protocol node alone
+// when drv already is one.
+func blockdevAddArgs(node string, in *qmp.BlockDeviceInfo) map[string]any {
+	protocol := map[string]any{"driver": protocolDriver(in.File), "filename": in.File}
+	drv := cmp.Or(in.Drv, "raw")
+	if drv == "file" || drv == "host_device" {
+		protocol["node-name"] = node
+		protocol["read-only"] = in.RO
+		return protocol
+	}
+	return map[string]any{"driver": drv, "node-name": node, "read-only": in.RO, "file": protocol}
+}
+
+func protocolDriver(filename string) string {
+	if strings.HasPrefix(filename, "/dev/") {
+		return "host_device"
+	}
+	return "file"
+}
+
+// captureDevicePlan connects to the source QMP socket, captures the
+// device inventory and returns the replay plan for the captured argv.
+func captureDevicePlan(ctx context.Context, qmpSocket string, args []string) ([]deviceReplayStep, error) {
+	client, err := qmp.NewClient(ctx, qmpSocket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to source QMP: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close QMP client", "error", err)
+		}
+	}()
+	inv, err := captureDeviceInventory(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return planDeviceReplay(inv, args)
+}
+
+// emitDevicePlan captures the replay plan for the cmdline already written
+// to cmdlinePath, stores it in the devicePlanPath sidecar for file-based
+// replay and prints it as a KATAMARAN_DEVICES_B64 marker for pod-log
+// replay, signed when key is set.
+func emitDevicePlan(ctx context.Context, qmpSocket, cmdlinePath string, key []byte) error {
+	args, err := readCmdlineFile(cmdlinePath)
+	if err != nil {
+		return err
+	}
+	steps, err := captureDevicePlan(ctx, qmpSocket, args)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(steps)
+	if err != nil {
+		return fmt.Errorf("encode device plan: %w", err)
+	}
+	if err := os.WriteFile(devicePlanPath(cmdlinePath), data, 0o600); err != nil {
+		return fmt.Errorf("write device plan: %w", err)
+	}
+	markerln(ctx, formatMarker(key, devicesMarker, data))
+	slog.Info("Captured hot-plugged device plan", "steps", len(steps), "path", devicePlanPath(cmdlinePath))
+	return nil
+}
+
+// devicePlanPath is the sidecar file holding the replay plan next to a
+// captured cmdline file, for file-based replay.
+func devicePlanPath(cmdlinePath string) string { return cmdlinePath + ".devices.json" }
+
+// readDevicePlanFile loads the sidecar plan; a missing file means the
+// source predates device replay (or had nothing to replay) and yields nil.
+func readDevicePlanFile(path string) ([]deviceReplayStep, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read device plan %s: %w", path, err)
+	}
+	var steps []deviceReplayStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("decode device plan %s: %w", path, err)
+	}
+	return steps, nil
+}
+
+// fetchDevicePlanFromPodLog reads the KATAMARAN_DEVICES_B64 marker from
+// the source pod's log. The source prints it before the cmdline marker, so
+// one scan after fetchCmdlineFromPodLog succeeded is enough; no marker
+// yields a nil plan. With key set the marker must carry a valid MAC.
+func fetchDevicePlanFromPodLog(ctx context.Context, ref string, key []byte) ([]deviceReplayStep, error) {
+	pc, err := newPodLogClient(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.client.CloseIdleConnections()
+	markers, _, err := scanPodLogMarkers(ctx, pc.client, pc.endpoint, pc.token, devicesMarker)
+	if err != nil {
+		return nil, fmt.Errorf("fetch source pod log: %w", err)
+	}
+	value := markers[devicesMarker]
+	if value == "" {
+		return nil, nil
+	}
+	data, err := openMarker(key, devicesMarker, value)
+	if err != nil {
+		return nil, err
+	}
+	var steps []deviceReplayStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("decode device plan: %w", err)
+	}
+	return steps, nil
+}
+
+// replayDevices runs the plan against the dest QEMU before
+// migrate-incoming. Two steps are adapted to the dest node: netdev_add
+// taps get a tap created here (replayTapName of sandbox+netdev), and a
+// blockdev-add whose image path is missing on this node gets a blank
+// image of the source's virtual size in the dest sandbox dir, which the
+// NBD drive-mirror then fills. The plan arrives from the source pod, so
+// only the commands this plan format produces are accepted, and their
+// arguments pass the checks the replayed cmdline does (checkDeviceStep).
+func replayDevices(ctx context.Context, client *qmp.Client, steps []deviceReplayStep, cfg *DestConfig) error {
+	sandboxID := cmp.Or(cfg.SandboxID, destReplayDefaultSandbox)
+	scope := replayScope{sandboxDir: filepath.Join(sandboxRoot, sandboxID)}
+	for i, step := range steps {
+		var a map[string]any
+		if err := json.Unmarshal(step.Arguments, &a); err != nil {
+			return fmt.Errorf("device plan step %d (%s): %w", i, step.Execute, err)
+		}
+		switch step.Execute {
+		case "device_add", "object-add":
+		case "netdev_add":
+			if a["type"] == "tap" && a["ifname"] == nil {
+				tap := replayTapName(sandboxID + "/" + fmt.Sprint(a["id"]))
+				if err := setupTapIface(ctx, tap); err != nil {
+					return fmt.Errorf("setup tap for netdev %v: %w", a["id"], err)
+				}
+				a["ifname"] = tap
+			}
+		case "blockdev-add":
+			if err := localizeBlockdev(a, step.Size, sandboxID, cfg); err != nil {
+				return fmt.Errorf("device plan step %d (blockdev-add %v): %w", i, a["node-name"], err)
+			}
+		default:
+			return fmt.Errorf("device plan step %d: unsupported command %q", i, step.Execute)
+		}
+		if err := scope.checkDeviceStep(step.Execute, a); err != nil {
+			return fmt.Errorf("device plan step %d (%s %v): %w", i, step.Execute, cmp.Or(a["id"], a["node-name"]), err)
+		}
+		raw, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("device plan step %d (%s): %w", i, step.Execute, err)
+		}
+		if _, err := client.Execute(ctx, step.Execute, qmp.RawArgs(raw)); err != nil {
+			return fmt.Errorf("replaying %s %v: %w", step.Execute, cmp.Or(a["id"], a["node-name"]), err)
+		}
+		slog.Info("Replayed hot-plugged device", "command", step.Execute, "id", cmp.Or(a["id"], a["node-name"]))
+	}
+	return nil
+}
+
+// replayPlanObjectTypes are the object-add types a device plan may
+// create: the backends of hot-plugged memory.
+var replayPlanObjectTypes = map[string]bool{
+	"memory-backend-ram":  true,
+	"memory-backend-file": true,
+}
+
+// checkDeviceStep vets the arguments of a replayed plan step, after
+// replayDevices adapted them to this node, as validateReplayArgs does the
+// equivalent cmdline options: memory backends on checkReplayMemPath
+// paths, block nodes of replayDriveDrivers on sandbox or Kata image
+// files, taps without host scripts and devices without host files.
+func (s replayScope) checkDeviceStep(execute string, a map[string]any) error {
+	switch execute {
+	case "object-add":
+		kind, _ := a["qom-type"].(string)
+		if !replayPlanObjectTypes[kind] {
+			return fmt.Errorf("object type %q is not allowed", kind)
+		}
+		if kind == "memory-backend-file" {
+			p, _ := a["mem-path"].(string)
+			return checkReplayMemPath(p)
+		}
+	case "blockdev-add":
+		return s.checkBlockdevArgs(a)
+	case "netdev_add":
+		if a["type"] != "tap" {
+			return fmt.Errorf("netdev type %v is not allowed", a["type"])
+		}
+		for _, key := range []string{"script", "downscript"} {
+			if a[key] != "no" {
+				return fmt.Errorf("tap %s=%v is not allowed", key, a[key])
+			}
+		}
+		if _, ok := a["helper"]; ok {
+			return errors.New("tap helper is not allowed")
+		}
+	case "device_add":
+		if a["driver"] == "loader" {
+			return errors.New(`device "loader" is not allowed`)
+		}
+		if rom, ok := a["romfile"]; ok {
+			return fmt.Errorf("romfile=%v is not allowed", rom)
+		}
+	}
+	return nil
+}
+
+// checkBlockdevArgs applies checkBlockKeys to blockdev-add arguments:
+// every driver, nested ones included, must be in replayDriveDrivers and
+// every filename must pass checkImagePath.
+func (s replayScope) checkBlockdevArgs(a map[string]any) error {
+	for key, v := range a {
+		switch v := v.(type) {
+		case map[string]any:
+			if err := s.checkBlockdevArgs(v); err != nil {
+				return err
+			}
+		case []any:
+			for _, e := range v {
+				if m, ok := e.(map[string]any); ok {
+					if err := s.checkBlockdevArgs(m); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		switch key {
+		case "driver":
+			if d, _ := v.(string); !replayDriveDrivers[d] {
+				return fmt.Errorf("block driver %v is not allowed", v)
+			}
+		case "filename":
+			p, _ := v.(string)
+			if err := s.checkImagePath(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// localizeBlockdev points a replayed blockdev-add at a blank local image
+// when the source's image path does not exist on this node.
+func localizeBlockdev(a map[string]any, size int64, sandboxID string, cfg *DestConfig) error {
+	protocol := a
+	if f, ok := a["file"].(map[string]any); ok {
+		protocol = f
+	}
+	filename, _ := protocol["filename"].(string)
+	node, _ := a["node-name"].(string)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	if cfg.SharedStorage {
+		return fmt.Errorf("image %s missing on destination with shared storage", filename)
+	}
+	if size <= 0 {
+		return fmt.Errorf("image %s missing on destination and source size unknown", filename)
+	}
+	if err := validateDriveID(node); err != nil {
+		return err
+	}
+	local := filepath.Join(sandboxRoot, sandboxID, "hotplug-"+node+".img")
+	f, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create blank image: %w", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("size blank image: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close blank image: %w", err)
+	}
+	protocol["driver"], protocol["filename"] = "file", local
+	if !isAutoDriveIDs(cfg.DriveIDs) && !slices.Contains(cfg.DriveIDs, node) {
+		slog.Warn("Hot-plugged drive is not in --drive-id; its contents will not be mirrored", "node", node, "image", local)
+	}
+	return nil
+}
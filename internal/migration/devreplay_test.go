@@ -0,0 +1,343 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// hotplugCmdline is a captured kata argv: q35, one root port and a
+// cmdline virtio-blk that must not be replayed.
+var hotplugCmdline = []string{
+	"/opt/kata/bin/qemu-system-x86_64",
+	"-machine", "q35,accel=kvm",
+	"-device", "pcie-root-port,id=rp0,bus=pcie.0,chassis=0",
+	"-blockdev", "driver=raw,node-name=image-root,file.driver=file,file.filename=/opt/kata/share/kata.img",
+	"-device", "virtio-blk-pci,id=image-root-dev,drive=image-root",
+	"-object", "memory-backend-file,id=dimm1,size=2048M,mem-path=/dev/shm,share=on",
+}
+
+// hotplugInventory is the source QEMU after kata hot-plugged a vCPU, a
+// DIMM, a container block device behind rp0 and a second NIC.
+func hotplugInventory() *deviceInventory {
+	return &deviceInventory{
+		PCI: []qmp.PCIInfo{{Bus: 0, Devices: []qmp.PCIDeviceInfo{
+			{Slot: 2, QdevID: "image-root-dev"},
+			{Slot: 3, QdevID: "net1"},
+			{Slot: 4, QdevID: "rp0", PCIBridge: &qmp.PCIBridgeInfo{Devices: []qmp.PCIDeviceInfo{
+				{Bus: 1, Slot: 0, QdevID: "virtio-drive-abc"},
+			}}},
+		}}},
+		CPUs: []qmp.HotpluggableCPU{
+			{Type: "host-x86_64-cpu", Props: map[string]int64{"socket-id": 0, "core-id": 0, "thread-id": 0}, QOMPath: "/machine/unattached/device[0]"},
+			{Type: "host-x86_64-cpu", Props: map[string]int64{"socket-id": 1, "core-id": 0, "thread-id": 0}, QOMPath: "/machine/peripheral/cpu-1"},
+			{Type: "host-x86_64-cpu", Props: map[string]int64{"socket-id": 2, "core-id": 0, "thread-id": 0}},
+		},
+		Memory: []qmp.MemoryDeviceInfo{{Type: "dimm", Data: qmp.MemoryDeviceData{ID: "dimm-mem2", Memdev: "/objects/mem2", Size: 1 << 30, Hotplugged: true}}},
+		Block: []qmp.BlockInfo{
+			{Qdev: "/machine/peripheral/image-root-dev/virtio-backend", Inserted: &qmp.BlockDeviceInfo{File: "/opt/kata/share/kata.img", NodeName: "image-root", Drv: "raw"}},
+			{Qdev: "/machine/peripheral/virtio-drive-abc/virtio-backend", Inserted: &qmp.BlockDeviceInfo{
+				File: "/dev/dm-3", NodeName: "drive-abc", Drv: "raw", Image: qmp.BlockImageInfo{VirtualSize: 10 << 20},
+			}},
+		},
+		Peripherals: []qmp.ObjectPropertyInfo{
+			{Name: "virtio-drive-abc", Type: "child<virtio-blk-pci>"},
+			{Name: "image-root-dev", Type: "child<virtio-blk-pci>"},
+			{Name: "rp0", Type: "child<pcie-root-port>"},
+			{Name: "cpu-1", Type: "child<host-x86_64-cpu>"},
+			{Name: "dimm-mem2", Type: "child<pc-dimm>"},
+			{Name: "net1", Type: "child<virtio-net-pci>"},
+			{Name: "type", Type: "string"},
+		},
+		Objects: []qmp.ObjectPropertyInfo{
+			{Name: "dimm1", Type: "child<memory-backend-file>"},
+			{Name: "mem2", Type: "child<memory-backend-file>"},
+		},
+		Props: map[string]json.RawMessage{
+			"/objects/mem2#mem-path":          json.RawMessage(`"/dev/shm"`),
+			"/objects/mem2#share":             json.RawMessage(`true`),
+			"/machine/peripheral/net1#netdev": json.RawMessage(`"hostnet1"`),
+			"/machine/peripheral/net1#mac":    json.RawMessage(`"02:00:00:00:00:01"`),
+		},
+	}
+}
+
+func stepArgs(t *testing.T, step deviceReplayStep) map[string]any {
+	t.Helper()
+	var a map[string]any
+	if err := json.Unmarshal(step.Arguments, &a); err != nil {
+		t.Fatalf("decode %s arguments: %v", step.Execute, err)
+	}
+	return a
+}
+
+func TestPlanDeviceReplay(t *testing.T) {
+	t.Parallel()
+	steps, err := planDeviceReplay(hotplugInventory(), hotplugCmdline)
+	if err != nil {
+		t.Fatalf("planDeviceReplay: %v", err)
+	}
+
+	want := []struct {
+		execute string
+		args    map[string]any
+	}{
+		{"device_add", map[string]any{"driver": "host-x86_64-cpu", "id": "cpu-1", "socket-id": float64(1), "core-id": float64(0), "thread-id": float64(0)}},
+		{"object-add", map[string]any{"qom-type": "memory-backend-file", "id": "mem2", "size": float64(1 << 30), "mem-path": "/dev/shm", "share": true}},
+		{"device_add", map[string]any{"driver": "pc-dimm", "id": "dimm-mem2", "memdev": "mem2", "node": float64(0)}},
+		{"netdev_add", map[string]any{"type": "tap", "id": "hostnet1", "script": "no", "downscript": "no"}},
+		{"device_add", map[string]any{"driver": "virtio-net-pci", "id": "net1", "bus": "pcie.0", "addr": "0x3.0x0", "netdev": "hostnet1", "mac": "02:00:00:00:00:01"}},
+		{"blockdev-add", map[string]any{"driver": "raw", "node-name": "drive-abc", "read-only": false, "file": map[string]any{"driver": "host_device", "filename": "/dev/dm-3"}}},
+		{"device_add", map[string]any{"driver": "virtio-blk-pci", "id": "virtio-drive-abc", "bus": "rp0", "addr": "0x0.0x0", "drive": "drive-abc"}},
+	}
+	if len(steps) != len(want) {
+		names := make([]string, len(steps))
+		for i, s := range steps {
+			names[i] = s.Execute + " " + string(s.Arguments)
+		}
+		t.Fatalf("got %d steps, want %d:\n%s", len(steps), len(want), strings.Join(names, "\n"))
+	}
+	for i, w := range want {
+		if steps[i].Execute != w.execute {
+			t.Fatalf("step %d execute = %q, want %q", i, steps[i].Execute, w.execute)
+		}
+		if got := stepArgs(t, steps[i]); !reflect.DeepEqual(got, w.args) {
+			t.Fatalf("step %d (%s) args:\n  got:  %v\n  want: %v", i, w.execute, got, w.args)
+		}
+	}
+	if steps[5].Size != 10<<20 {
+		t.Fatalf("blockdev-add size = %d, want %d", steps[5].Size, 10<<20)
+	}
+}
+
+func TestPlanDeviceReplay_NothingHotplugged(t *testing.T) {
+	t.Parallel()
+	inv := hotplugInventory()
+	inv.Peripherals = inv.Peripherals[1:3] // image-root-dev and rp0, both on the cmdline
+	steps, err := planDeviceReplay(inv, hotplugCmdline)
+	if err != nil {
+		t.Fatalf("planDeviceReplay: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Fatalf("steps = %v, want none", steps)
+	}
+}
+
+func TestPlanDeviceReplay_MissingMemoryBackend(t *testing.T) {
+	t.Parallel()
+	inv := hotplugInventory()
+	inv.Objects = inv.Objects[:1]
+	if _, err := planDeviceReplay(inv, hotplugCmdline); err == nil || !strings.Contains(err.Error(), "/objects/mem2") {
+		t.Fatalf("err = %v, want missing backend error", err)
+	}
+}
+
+func TestPCIRootBus(t *testing.T) {
+	t.Parallel()
+	for args, want := range map[string]string{
+		"-machine q35,accel=kvm":         "pcie.0",
+		"-machine pc,accel=kvm":          "pci.0",
+		"-M pc-i440fx-8.2":               "pci.0",
+		"-machine type=pc":               "pci.0",
+		"-machine virt,gic-version=host": "pcie.0",
+	} {
+		if got := pciRootBus(append([]string{"qemu"}, strings.Fields(args)...)); got != want {
+			t.Errorf("pciRootBus(%q) = %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestCaptureDevicePlan(t *testing.T) {
+	t.Parallel()
+	inv := hotplugInventory()
+	encode := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return `{"return":` + string(b) + `}`
+	}
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-pci":
+			return encode(inv.PCI)
+		case "query-hotpluggable-cpus":
+			return encode(inv.CPUs)
+		case "query-memory-devices":
+			return encode(inv.Memory)
+		case "query-block":
+			return encode(inv.Block)
+		case "qom-list":
+			var a qmp.QOMListArgs
+			_ = json.Unmarshal(cmd.Arguments, &a)
+			if a.Path == "/objects" {
+				return encode(inv.Objects)
+			}
+			return encode(inv.Peripherals)
+		case "qom-get":
+			var a qmp.QOMGetArgs
+			_ = json.Unmarshal(cmd.Arguments, &a)
+			if v, ok := inv.Props[a.Path+"#"+a.Property]; ok {
+				return `{"return":` + string(v) + `}`
+			}
+			return `{"return":false}`
+		}
+		return `{"error":{"class":"CommandNotFound","desc":"unexpected"}}`
+	})
+
+	steps, err := captureDevicePlan(context.Background(), sock, hotplugCmdline)
+	if err != nil {
+		t.Fatalf("captureDevicePlan: %v", err)
+	}
+	if len(steps) != 7 {
+		t.Fatalf("got %d steps, want 7", len(steps))
+	}
+	assertRecordedSubsequence(t, rec.Commands(), []string{
+		"query-pci", "query-hotpluggable-cpus", "query-memory-devices", "query-block", "qom-list", "qom-list", "qom-get",
+	})
+}
+
+func TestReplayDevices(t *testing.T) {
+	prevRoot, prevTap := sandboxRoot, setupTapIface
+	sandboxRoot = t.TempDir()
+	var taps []string
+	setupTapIface = func(_ context.Context, name string) error {
+		taps = append(taps, name)
+		return nil
+	}
+	t.Cleanup(func() { sandboxRoot, setupTapIface = prevRoot, prevTap })
+	if err := os.MkdirAll(filepath.Join(sandboxRoot, "katamaran-dest-m1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := planDeviceReplay(hotplugInventory(), hotplugCmdline)
+	if err != nil {
+		t.Fatalf("planDeviceReplay: %v", err)
+	}
+	sock, rec := startRecordingQMP(t, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	cfg := &DestConfig{SandboxID: "katamaran-dest-m1", DriveIDs: []string{"drive-abc"}}
+	if err := replayDevices(context.Background(), client, steps, cfg); err != nil {
+		t.Fatalf("replayDevices: %v", err)
+	}
+
+	cmds := rec.Commands()
+	if len(cmds) != len(steps) {
+		t.Fatalf("recorded %d commands, want %d", len(cmds), len(steps))
+	}
+	wantTap := replayTapName("katamaran-dest-m1/hostnet1")
+	if len(taps) != 1 || taps[0] != wantTap {
+		t.Fatalf("taps = %v, want [%s]", taps, wantTap)
+	}
+	var netdev map[string]any
+	decodeRecordedArgs(t, findRecordedCommand(t, cmds, "netdev_add"), &netdev)
+	if netdev["ifname"] != wantTap {
+		t.Fatalf("netdev_add ifname = %v, want %s", netdev["ifname"], wantTap)
+	}
+
+	// /dev/dm-3 does not exist here, so the drive is backed by a blank
+	// local image of the source's size.
+	var blk struct {
+		File struct {
+			Driver   string `json:"driver"`
+			Filename string `json:"filename"`
+		} `json:"file"`
+	}
+	decodeRecordedArgs(t, findRecordedCommand(t, cmds, "blockdev-add"), &blk)
+	wantImg := filepath.Join(sandboxRoot, "katamaran-dest-m1", "hotplug-drive-abc.img")
+	if blk.File.Driver != "file" || blk.File.Filename != wantImg {
+		t.Fatalf("blockdev-add file = %+v, want file %s", blk.File, wantImg)
+	}
+	if fi, err := os.Stat(wantImg); err != nil || fi.Size() != 10<<20 {
+		t.Fatalf("blank image: %v, %v", fi, err)
+	}
+}
+
+func TestReplayDevices_RejectsUnexpectedCommand(t *testing.T) {
+	t.Parallel()
+	sock, rec := startRecordingQMP(t, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	steps := []deviceReplayStep{{Execute: "human-monitor-command", Arguments: json.RawMessage(`{"command-line":"quit"}`)}}
+	if err := replayDevices(context.Background(), client, steps, &DestConfig{}); err == nil || !strings.Contains(err.Error(), "unsupported command") {
+		t.Fatalf("err = %v, want unsupported command", err)
+	}
+	if n := len(rec.Commands()); n != 0 {
+		t.Fatalf("sent %d commands, want 0", n)
+	}
+}
+
+func TestReplayDevices_RejectsUnsafeArguments(t *testing.T) {
+	prevRoot := sandboxRoot
+	sandboxRoot = t.TempDir()
+	t.Cleanup(func() { sandboxRoot = prevRoot })
+	img := filepath.Join(sandboxRoot, destReplayDefaultSandbox, "disk.img")
+	if err := os.MkdirAll(filepath.Dir(img), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(img, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		execute, args, want string
+	}{
+		{"object-add", `{"qom-type":"filter-dump","id":"f","netdev":"n0","file":"/tmp/pcap"}`, "object type"},
+		{"object-add", `{"qom-type":"memory-backend-file","id":"m","size":4096,"mem-path":"/etc/shadow"}`, "Kata image roots"},
+		{"object-add", `{"qom-type":"memory-backend-file","id":"m","size":4096,"mem-path":"/dev/shm/../../etc/shadow"}`, "clean"},
+		{"blockdev-add", `{"driver":"qcow2","node-name":"n","file":{"driver":"file","filename":"` + img + `"},"backing":{"driver":"nbd","server":{"type":"inet","host":"10.0.0.9","port":"10809"}}}`, "block driver"},
+		{"blockdev-add", `{"driver":"raw","node-name":"n","file":{"driver":"http","filename":"` + img + `"}}`, "block driver"},
+		{"blockdev-add", `{"driver":"file","node-name":"n","filename":"/etc/passwd"}`, "outside the sandbox dir"},
+		{"netdev_add", `{"type":"tap","id":"n0","script":"/tmp/x","downscript":"no"}`, "script"},
+		{"netdev_add", `{"type":"bridge","id":"n0","br":"br0"}`, "netdev type"},
+		{"device_add", `{"driver":"loader","file":"/etc/shadow","addr":4096}`, "loader"},
+		{"device_add", `{"driver":"virtio-net-pci","id":"net9","romfile":"/etc/shadow"}`, "romfile"},
+	} {
+		sock, rec := startRecordingQMP(t, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+		client, err := qmp.NewClient(context.Background(), sock)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		steps := []deviceReplayStep{{Execute: tc.execute, Arguments: json.RawMessage(tc.args)}}
+		err = replayDevices(context.Background(), client, steps, &DestConfig{})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %s: err = %v, want %q", tc.execute, tc.args, err, tc.want)
+		}
+		if n := len(rec.Commands()); n != 0 {
+			t.Errorf("%s %s: sent %d commands, want 0", tc.execute, tc.args, n)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestReadDevicePlanFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	if steps, err := readDevicePlanFile(filepath.Join(dir, "missing.devices.json")); err != nil || steps != nil {
+		t.Fatalf("missing file: steps=%v err=%v, want nil/nil", steps, err)
+	}
+	p := devicePlanPath(filepath.Join(dir, "cmdline"))
+	if err := os.WriteFile(p, []byte(`[{"execute":"device_add","arguments":{"driver":"pc-dimm","id":"d"}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := readDevicePlanFile(p)
+	if err != nil || len(steps) != 1 || steps[0].Execute != "device_add" {
+		t.Fatalf("steps=%v err=%v", steps, err)
+	}
+}
@@ -0,0 +1,240 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"syscall"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// DriveIDsAuto, given as the only --drive-id, discovers the drives to
+// mirror from query-block instead of taking a hand-written list. A missed
+// ID in a hand-written list silently leaves that disk behind.
+const DriveIDsAuto = "auto"
+
+// drivesMarker is printed by the source in auto mode with the discovered
+// drives: KATAMARAN_DRIVES local=<ids> shared=<ids> readonly=<ids>. The
+// dest reads it (--drives-from-job) to add matching NBD exports.
+const drivesMarker = "KATAMARAN_DRIVES "
+
+// isAutoDriveIDs reports whether ids asks for drive discovery.
+func isAutoDriveIDs(ids []string) bool {
+	return len(ids) == 1 && ids[0] == DriveIDsAuto
+}
+
+// driveClass is how a discovered drive is migrated.
+type driveClass string
+
+const (
+	// driveLocal is node-local writable storage that must be mirrored.
+	driveLocal driveClass = "local"
+	// driveShared is reachable from the destination as-is (Ceph RBD, a
+	// file on an NFS/CephFS/GlusterFS mount).
+	driveShared driveClass = "shared"
+	// driveReadOnly is never written by the guest (the kata rootfs image).
+	driveReadOnly driveClass = "readonly"
+)
+
+// discoveredDrive is one block device found by discoverDrives.
+type discoveredDrive struct {
+	ID    string // device name, or root node-name for -blockdev drives
+	File  string
+	Size  int64
+	Class driveClass
+}
+
+// sharedProtocols are QEMU block protocol drivers whose data does not live
+// on the source node.
+var sharedProtocols = map[string]bool{
+	"rbd": true, "nfs": true, "gluster": true, "iscsi": true, "nvme-of": true, "ssh": true,
+}
+
+// sharedFSMagic are statfs f_type values of network / cluster filesystems.
+var sharedFSMagic = map[int64]string{
+	0x6969:     "nfs",
+	0x00c36400: "ceph",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x01161970: "gfs2",
+	0x7461636f: "ocfs2",
+}
+
+// statfsType returns the filesystem magic of path. Package-level var so
+// tests can fake mounts.
+var statfsType = func(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Type), nil
+}
+
+// discoverDrives classifies every block device with a medium via
+// query-block, using query-named-block-nodes to see the protocol driver
+// under format nodes (raw over rbd reports drv=raw in query-block). A file
+// whose mount cannot be inspected from here is classified local: mirroring
+// a shared disk costs time, skipping a local one loses data.
+func discoverDrives(ctx context.Context, client *qmp.Client) ([]discoveredDrive, error) {
+	raw, err := client.Execute(ctx, "query-block", nil)
+	if err != nil {
+		return nil, fmt.Errorf("query-block: %w", err)
+	}
+	var blocks []qmp.BlockInfo
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("decode query-block: %w", err)
+	}
+	raw, err = client.Execute(ctx, "query-named-block-nodes", nil)
+	if err != nil {
+		return nil, fmt.Errorf("query-named-block-nodes: %w", err)
+	}
+	var nodes []qmp.BlockDeviceInfo
+	if err := json.Unmarshal(raw, &nodes); err != nil {
+		return nil, fmt.Errorf("decode query-named-block-nodes: %w", err)
+	}
+
+	var drives []discoveredDrive
+	for _, b := range blocks {
+		in := b.Inserted
+		if in == nil {
+			continue // empty removable drive
+		}
+		d := discoveredDrive{ID: cmp.Or(b.Device, in.NodeName), File: in.File, Size: in.Image.VirtualSize, Class: driveLocal}
+		switch {
+		case d.ID == "":
+			continue
+		case in.RO:
+			d.Class = driveReadOnly
+		case isSharedBlock(in, nodes):
+			d.Class = driveShared
+		}
+		drives = append(drives, d)
+	}
+	return drives, nil
+}
+
+// isSharedBlock reports whether the medium lives on shared storage.
+func isSharedBlock(in *qmp.BlockDeviceInfo, nodes []qmp.BlockDeviceInfo) bool {
+	if sharedProtocols[in.Drv] {
+		return true
+	}
+	for _, n := range nodes {
+		if n.File == in.File && sharedProtocols[n.Drv] {
+			return true
+		}
+	}
+	for proto := range sharedProtocols {
+		if strings.HasPrefix(in.File, proto+":") || strings.HasPrefix(in.File, proto+"://") {
+			return true
+		}
+	}
+	if strings.HasPrefix(in.File, "json:") {
+		return false // protocol options we cannot statfs; drivers checked above
+	}
+	magic, err := statfsType(in.File)
+	if err != nil {
+		slog.Debug("statfs on drive image failed; treating as local", "file", in.File, "error", err)
+		return false
+	}
+	_, shared := sharedFSMagic[magic]
+	return shared
+}
+
+// drivesOf returns the IDs of drives in class.
+func drivesOf(drives []discoveredDrive, class driveClass) []string {
+	ids := []string{}
+	for _, d := range drives {
+		if d.Class == class {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids
+}
+
+// resolveAutoDrives discovers the source's drives and returns the IDs to
+// mirror. It refuses to continue when a writable local drive would be left
+// behind, i.e. with sharedStorage set.
+func resolveAutoDrives(ctx context.Context, qmpSocket string, sharedStorage bool) ([]string, []discoveredDrive, error) {
+	client, err := qmp.NewClient(ctx, qmpSocket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to source QMP: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close QMP client", "error", err)
+		}
+	}()
+	drives, err := discoverDrives(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range drives {
+		slog.Info("Discovered drive", "drive_id", d.ID, "class", string(d.Class), "file", d.File, "size", d.Size)
+	}
+	local := drivesOf(drives, driveLocal)
+	if sharedStorage && len(local) > 0 {
+		return nil, nil, fmt.Errorf("--shared-storage would leave writable local drives behind: %s", strings.Join(local, ","))
+	}
+	if err := validateDriveIDsIfAny(local); err != nil {
+		return nil, nil, err
+	}
+	return local, drives, nil
+}
+
+// validateDriveIDsIfAny is validateDriveIDs for lists that may be empty.
+func validateDriveIDsIfAny(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return validateDriveIDs(ids)
+}
+
+// formatDrivesMarker renders the KATAMARAN_DRIVES line for drives.
+func formatDrivesMarker(drives []discoveredDrive) string {
+	return fmt.Sprintf("%slocal=%s shared=%s readonly=%s", drivesMarker,
+		strings.Join(drivesOf(drives, driveLocal), ","),
+		strings.Join(drivesOf(drives, driveShared), ","),
+		strings.Join(drivesOf(drives, driveReadOnly), ","))
+}
+
+// parseDrivesMarker returns the local drive IDs from KATAMARAN_DRIVES
+// fields, validated since they arrive from another pod's log.
+func parseDrivesMarker(fields map[string]string) ([]string, error) {
+	v, ok := fields["local"]
+	if !ok {
+		return nil, fmt.Errorf("%s marker has no local= field", strings.TrimSpace(drivesMarker))
+	}
+	var ids []string
+	if v != "" {
+		ids = strings.Split(v, ",")
+	}
+	if err := validateDriveIDsIfAny(ids); err != nil {
+		return nil, fmt.Errorf("%s marker: %w", strings.TrimSpace(drivesMarker), err)
+	}
+	return ids, nil
+}
+
+// resolveDestDrives returns the drives the destination exports over NBD
+// in auto mode: the source's published list when cfg.DrivesFromJob names
+// the source Job, otherwise the local drives of the destination QEMU,
+// whose device model matches the source's.
+func resolveDestDrives(ctx context.Context, client *qmp.Client, cfg *DestConfig) ([]string, error) {
+	if cfg.DrivesFromJob != "" {
+		slog.Info("Waiting for source drive list", "source_job", cfg.DrivesFromJob)
+		fields, err := waitForJobMarker(ctx, cfg.DrivesFromJob, drivesMarker, jobPeer{role: "source", event: "it published its drive list"}, 0)
+		if err != nil {
+			return nil, err
+		}
+		return parseDrivesMarker(fields)
+	}
+	slog.Info("No --drives-from-job; discovering drives on the destination QEMU")
+	drives, err := discoverDrives(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return drivesOf(drives, driveLocal), nil
+}
@@ -0,0 +1,153 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// driveQMP serves a kata source with a local root disk, an NFS-backed
+// volume, a Ceph RBD volume (raw over rbd), the read-only rootfs image
+// and an empty cdrom.
+func driveQMP(t *testing.T) string {
+	t.Helper()
+	// QMP is line-delimited; the responses below are indented for reading.
+	oneLine := strings.NewReplacer("\n", "", "\t", "").Replace
+	sock, _ := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-block":
+			return oneLine(`{"return":[
+				{"device":"drive-virtio-disk0","inserted":{"file":"/var/lib/kata/disk0.img","drv":"raw","image":{"virtual-size":1048576}}},
+				{"device":"","qdev":"/machine/peripheral/vol1/virtio-backend","inserted":{"file":"/mnt/nfs/vol1.img","node-name":"drive-vol1","drv":"raw","image":{"virtual-size":2097152}}},
+				{"device":"","inserted":{"file":"json:{\"driver\":\"raw\",\"file\":{\"driver\":\"rbd\",\"pool\":\"kube\",\"image\":\"vol2\"}}","node-name":"drive-vol2","drv":"raw"}},
+				{"device":"","inserted":{"file":"/opt/kata/share/kata-containers.img","node-name":"image-root","drv":"raw","ro":true}},
+				{"device":"ide0-cd0"}
+			]}`)
+		case "query-named-block-nodes":
+			return oneLine(`{"return":[
+				{"node-name":"drive-vol2","drv":"raw","file":"json:{\"driver\":\"raw\",\"file\":{\"driver\":\"rbd\",\"pool\":\"kube\",\"image\":\"vol2\"}}"},
+				{"node-name":"#block123","drv":"rbd","file":"json:{\"driver\":\"raw\",\"file\":{\"driver\":\"rbd\",\"pool\":\"kube\",\"image\":\"vol2\"}}"}
+			]}`)
+		}
+		return `{"error":{"class":"CommandNotFound","desc":"unexpected"}}`
+	})
+	return sock
+}
+
+func stubStatfs(t *testing.T) {
+	t.Helper()
+	prev := statfsType
+	statfsType = func(path string) (int64, error) {
+		if strings.HasPrefix(path, "/mnt/nfs/") {
+			return 0x6969, nil
+		}
+		return 0xef53, nil // ext4
+	}
+	t.Cleanup(func() { statfsType = prev })
+}
+
+func TestDiscoverDrives_Classifies(t *testing.T) {
+	stubStatfs(t)
+	client, err := qmp.NewClient(context.Background(), driveQMP(t))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	drives, err := discoverDrives(context.Background(), client)
+	if err != nil {
+		t.Fatalf("discoverDrives: %v", err)
+	}
+	got := map[string]driveClass{}
+	for _, d := range drives {
+		got[d.ID] = d.Class
+	}
+	want := map[string]driveClass{
+		"drive-virtio-disk0": driveLocal,
+		"drive-vol1":         driveShared,
+		"drive-vol2":         driveShared,
+		"image-root":         driveReadOnly,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("classes = %v, want %v", got, want)
+	}
+	if m := formatDrivesMarker(drives); m != "KATAMARAN_DRIVES local=drive-virtio-disk0 shared=drive-vol1,drive-vol2 readonly=image-root" {
+		t.Fatalf("marker = %q", m)
+	}
+}
+
+func TestResolveAutoDrives(t *testing.T) {
+	stubStatfs(t)
+
+	local, _, err := resolveAutoDrives(context.Background(), driveQMP(t), false)
+	if err != nil {
+		t.Fatalf("resolveAutoDrives: %v", err)
+	}
+	if !reflect.DeepEqual(local, []string{"drive-virtio-disk0"}) {
+		t.Fatalf("local = %v", local)
+	}
+
+	_, _, err = resolveAutoDrives(context.Background(), driveQMP(t), true)
+	if err == nil || !strings.Contains(err.Error(), "would leave writable local drives behind: drive-virtio-disk0") {
+		t.Fatalf("shared storage with a local disk: err = %v", err)
+	}
+}
+
+func TestParseDrivesMarker(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		line    string
+		want    []string
+		wantErr string
+	}{
+		{line: "local=drive-a,drive-b shared= readonly=image-root", want: []string{"drive-a", "drive-b"}},
+		{line: "local= shared=drive-c readonly=", want: nil},
+		{line: "shared=drive-c", wantErr: "no local= field"},
+		{line: "local=drive-a,drive-a", wantErr: "duplicate drive ID"},
+	} {
+		got, err := parseDrivesMarker(parseMarkerFields(tc.line))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%q: err = %v, want %q", tc.line, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, %v; want %v", tc.line, got, err, tc.want)
+		}
+	}
+}
+
+func TestResolveDestDrives_FromSourceJob(t *testing.T) {
+	prev := destReadyPollInterval
+	destReadyPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() { destReadyPollInterval = prev })
+	setupAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/kube-system/pods":
+			if got := r.URL.Query().Get("labelSelector"); got != "batch.kubernetes.io/job-name=katamaran-source-abc" {
+				t.Errorf("labelSelector = %q", got)
+			}
+			_, _ = fmt.Fprint(w, `{"items":[{"metadata":{"name":"katamaran-source-abc-x1"},"status":{"phase":"Running"}}]}`)
+		case "/api/v1/namespaces/kube-system/pods/katamaran-source-abc-x1/log":
+			_, _ = fmt.Fprint(w, "KATAMARAN_DRIVES local=drive-virtio-disk0,drive-vol3 shared= readonly=image-root\n")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	ids, err := resolveDestDrives(context.Background(), nil, &DestConfig{DrivesFromJob: "kube-system/katamaran-source-abc"})
+	if err != nil {
+		t.Fatalf("resolveDestDrives: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"drive-virtio-disk0", "drive-vol3"}) {
+		t.Fatalf("ids = %v", ids)
+	}
+}
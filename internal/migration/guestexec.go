@@ -0,0 +1,152 @@
+package migration
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// guestExecPollInterval is how often runGuestExecHook asks the agent
+// whether the command has exited.
+var guestExecPollInterval = 200 * time.Millisecond
+
+// guestAgentResponse is one qemu-guest-agent reply.
+type guestAgentResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// guestExecStatus is the guest-exec-status reply.
+type guestExecStatus struct {
+	Exited   bool   `json:"exited"`
+	ExitCode *int   `json:"exitcode"`
+	Signal   *int   `json:"signal"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+}
+
+// guestAgent is a minimal qemu-guest-agent client. Unlike QMP the agent
+// sends no greeting and negotiates no capabilities; guest-sync discards
+// whatever an earlier client left in the channel.
+type guestAgent struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+func dialGuestAgent(ctx context.Context, socket string) (*guestAgent, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect to guest agent: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	ga := &guestAgent{conn: conn, dec: json.NewDecoder(conn)}
+	if err := ga.sync(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ga, nil
+}
+
+// sync issues guest-sync with a random ID and skips replies until the one
+// echoing it.
+func (ga *guestAgent) sync() error {
+	id := rand.Int64N(1 << 31)
+	if err := json.NewEncoder(ga.conn).Encode(map[string]any{"execute": "guest-sync", "arguments": map[string]any{"id": id}}); err != nil {
+		return fmt.Errorf("guest-sync: %w", err)
+	}
+	for {
+		var resp guestAgentResponse
+		if err := ga.dec.Decode(&resp); err != nil {
+			return fmt.Errorf("guest-sync: %w", err)
+		}
+		var got int64
+		if json.Unmarshal(resp.Return, &got) == nil && got == id {
+			return nil
+		}
+	}
+}
+
+func (ga *guestAgent) execute(cmd string, args any, out any) error {
+	if err := json.NewEncoder(ga.conn).Encode(map[string]any{"execute": cmd, "arguments": args}); err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	var resp guestAgentResponse
+	if err := ga.dec.Decode(&resp); err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s: %s", cmd, resp.Error.Class, resp.Error.Desc)
+	}
+	if err := json.Unmarshal(resp.Return, out); err != nil {
+		return fmt.Errorf("%s: decode reply: %w", cmd, err)
+	}
+	return nil
+}
+
+func (ga *guestAgent) Close() error { return ga.conn.Close() }
+
+// runGuestExecHook runs command in the guest through the agent at socket
+// and waits for it to exit.
+func runGuestExecHook(ctx context.Context, command []string, socket string) error {
+	ga, err := dialGuestAgent(ctx, socket)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ga.Close() }()
+	var started struct {
+		PID int `json:"pid"`
+	}
+	args := map[string]any{"path": command[0], "arg": command[1:], "capture-output": true}
+	if err := ga.execute("guest-exec", args, &started); err != nil {
+		return err
+	}
+	for {
+		var st guestExecStatus
+		if err := ga.execute("guest-exec-status", map[string]any{"pid": started.PID}, &st); err != nil {
+			return err
+		}
+		if st.Exited {
+			return guestExecResult(st)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("guest command %s (pid %d) still running: %w", command[0], started.PID, ctx.Err())
+		case <-time.After(guestExecPollInterval):
+		}
+	}
+}
+
+// guestExecResult turns an exited guest-exec-status into the hook's
+// result, with the command's output on failure.
+func guestExecResult(st guestExecStatus) error {
+	var msg string
+	switch {
+	case st.Signal != nil:
+		msg = fmt.Sprintf("guest command killed by signal %d", *st.Signal)
+	case st.ExitCode != nil && *st.ExitCode != 0:
+		msg = fmt.Sprintf("guest command exited with status %d", *st.ExitCode)
+	default:
+		return nil
+	}
+	var output []byte
+	for _, data := range []string{st.OutData, st.ErrData} {
+		if b, err := base64.StdEncoding.DecodeString(data); err == nil {
+			output = append(output, b...)
+		}
+	}
+	if out := truncateOutput(output); out != "" {
+		msg += ": " + out
+	}
+	return errors.New(msg)
+}
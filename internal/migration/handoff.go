@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// HandoffKeyEnv names the environment variable carrying the per-migration
+// hand-off key. The orchestrator (and deploy/migrate.sh) mints the key into
+// a Secret and both Jobs read it from there. A dest that fetches payloads
+// from a pod log refuses to run without it unless AllowUnsignedHandoff is
+// set.
+const HandoffKeyEnv = "KATAMARAN_HANDOFF_KEY"
+
+// handoffKeyMinBytes is the shortest hand-off key accepted: HMAC-SHA256
+// gains nothing from keys longer than its block, and loses strength below
+// its output size.
+const handoffKeyMinBytes = 32
+
+// handoffMACField separates a marker payload from its MAC on the marker
+// line: `KATAMARAN_CMDLINE_B64=<base64> mac=<hex>`.
+const handoffMACField = " mac="
+
+// errUnsignedHandoff reports a marker without a MAC while a hand-off key
+// is configured.
+var errUnsignedHandoff = errors.New("payload is not signed")
+
+// errNoHandoffKey reports a dest about to trust pod-log payloads without a
+// hand-off key to verify them.
+var errNoHandoffKey = errors.New(HandoffKeyEnv + " is not set; pass --allow-unsigned-handoff to accept unsigned payloads")
+
+// ParseHandoffKey decodes the hex-encoded hand-off key. An empty string
+// yields a nil key: the source then emits unsigned payloads, and the dest
+// only accepts them with AllowUnsignedHandoff.
+func ParseHandoffKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not hex: %w", HandoffKeyEnv, err)
+	}
+	if len(key) < handoffKeyMinBytes {
+		return nil, fmt.Errorf("%s is %d bytes, need at least %d", HandoffKeyEnv, len(key), handoffKeyMinBytes)
+	}
+	return key, nil
+}
+
+// handoffMAC returns the hex HMAC-SHA256 of payload under key. The marker
+// name is part of the input so a signed payload cannot be replayed under
+// another marker (a device plan passed off as a cmdline).
+func handoffMAC(key []byte, marker string, payload []byte) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(marker))
+	m.Write([]byte{0})
+	m.Write(payload)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// formatMarker renders the stdout marker line for payload: base64, plus
+// its MAC when key is set.
+func formatMarker(key []byte, marker string, payload []byte) string {
+	line := marker + base64.StdEncoding.EncodeToString(payload)
+	if key != nil {
+		line += handoffMACField + handoffMAC(key, marker, payload)
+	}
+	return line
+}
+
+// checkPodLogHandoff gates a dest that will read payloads from the source
+// pod's log at ref: a missing key is an error unless allowUnsigned opts
+// out, which is logged so the downgrade never goes unnoticed.
+func checkPodLogHandoff(key []byte, allowUnsigned bool, ref string) error {
+	if key != nil {
+		return nil
+	}
+	if !allowUnsigned {
+		return fmt.Errorf("hand-off from pod %s: %w", ref, errNoHandoffKey)
+	}
+	slog.Warn("Accepting unsigned hand-off payloads from the source pod log", "ref", ref, "env", HandoffKeyEnv)
+	return nil
+}
+
+// openMarker decodes a marker value scraped from a pod log (everything
+// after marker on its line) and, when key is set, verifies its MAC.
+// Unsigned or tampered payloads are rejected; without a key (only reached
+// past checkPodLogHandoff's opt-out) the MAC, if any, is ignored.
+func openMarker(key []byte, marker, value string) ([]byte, error) {
+	name := strings.TrimSuffix(marker, "=")
+	b64, mac, signed := strings.Cut(value, handoffMACField)
+	if len(b64) > maxMarkerB64Size {
+		return nil, fmt.Errorf("%s marker too large: %d bytes (max %d)", name, len(b64), maxMarkerB64Size)
+	}
+	payload, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	if key == nil {
+		if signed {
+			slog.Debug("Ignoring hand-off MAC: no hand-off key configured", "marker", name)
+		}
+		return payload, nil
+	}
+	if !signed {
+		return nil, fmt.Errorf("%s: %w", name, errUnsignedHandoff)
+	}
+	if !hmac.Equal([]byte(strings.TrimSpace(mac)), []byte(handoffMAC(key, marker, payload))) {
+		return nil, fmt.Errorf("%s: MAC mismatch, payload was tampered with or signed with another key", name)
+	}
+	return payload, nil
+}
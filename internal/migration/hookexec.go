@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// execProtocol is the apiserver's streaming exec subprotocol: every
+// binary frame starts with its channel number, and the error channel
+// carries a metav1.Status when the command ends.
+const execProtocol = "v4.channel.k8s.io"
+
+// Exec stream channels.
+const (
+	execChanStdout = 1
+	execChanStderr = 2
+	execChanError  = 3
+)
+
+// execStatus is the part of the metav1.Status on the error channel we use.
+type execStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// runExecHook runs the hook's command in pod ("<namespace>/<name>")
+// through the apiserver's pods/exec subresource, over a WebSocket as
+// kubectl does. Needs pods/exec on the Job's service account.
+func runExecHook(ctx context.Context, h *ExecHook, pod string) error {
+	ns, name, err := parsePodRef(pod)
+	if err != nil {
+		return err
+	}
+	base, token, tlsCfg, err := apiServerCredentials()
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	for _, arg := range h.Command {
+		q.Add("command", arg)
+	}
+	if h.Container != "" {
+		q.Set("container", h.Container)
+	}
+	q.Set("stdout", "true")
+	q.Set("stderr", "true")
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/exec?%s",
+		strings.Replace(base, "https://", "wss://", 1), url.PathEscape(ns), url.PathEscape(name), q.Encode())
+	cfg, err := websocket.NewConfig(endpoint, base)
+	if err != nil {
+		return fmt.Errorf("exec config: %w", err)
+	}
+	cfg.Protocol = []string{execProtocol}
+	cfg.TlsConfig = tlsCfg
+	cfg.Header.Set("Authorization", "Bearer "+token)
+	ws, err := cfg.DialContext(ctx)
+	if err != nil {
+		return fmt.Errorf("exec in pod %s: %w", pod, err)
+	}
+	defer func() { _ = ws.Close() }()
+	// Unblock Receive when the hook's timeout fires.
+	stop := context.AfterFunc(ctx, func() { _ = ws.Close() })
+	defer stop()
+
+	var output bytes.Buffer
+	for {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("exec in pod %s: stream closed without a status", pod)
+			}
+			return fmt.Errorf("exec in pod %s: %w", pod, err)
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		switch frame[0] {
+		case execChanStdout, execChanStderr:
+			if output.Len() < maxHookOutput {
+				output.Write(frame[1:])
+			}
+		case execChanError:
+			if len(frame) == 1 {
+				continue
+			}
+			var st execStatus
+			if err := json.Unmarshal(frame[1:], &st); err != nil {
+				return fmt.Errorf("exec in pod %s: decode status: %w", pod, err)
+			}
+			if st.Status == "Success" {
+				return nil
+			}
+			if out := truncateOutput(output.Bytes()); out != "" {
+				return fmt.Errorf("%s: %s", st.Message, out)
+			}
+			return errors.New(st.Message)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook phases: preCutover runs on the source right before the VM's state
+// starts moving, postResume on the destination once the VM runs there.
+const (
+	HookPhasePreCutover = "preCutover"
+	HookPhasePostResume = "postResume"
+)
+
+// Hook failure policies. Abort fails the migration (before the cutover,
+// the VM keeps running on the source); ignore records the failure and
+// carries on.
+const (
+	HookFailureAbort  = "abort"
+	HookFailureIgnore = "ignore"
+)
+
+// Hook results, as printed in the KATAMARAN_HOOK marker.
+const (
+	hookResultOK      = "ok"
+	hookResultFailed  = "failed"
+	hookResultIgnored = "ignored"
+)
+
+const (
+	// defaultHookTimeout bounds a hook without TimeoutSeconds.
+	defaultHookTimeout = 30 * time.Second
+	// maxHookTimeoutSeconds caps TimeoutSeconds: a hook stalls the
+	// migration (and, after the cutover, the post-resume steps) while it
+	// runs.
+	maxHookTimeoutSeconds = 600
+	// defaultGuestAgentSocket is the qemu-guest-agent chardev socket
+	// looked up in the VM's sandbox directory.
+	defaultGuestAgentSocket = "qga.sock"
+	// maxHookOutput bounds the hook output kept for error messages.
+	maxHookOutput = 4096
+)
+
+// hookMarker is printed once per hook run:
+// KATAMARAN_HOOK phase=<phase> name=<name> type=<type> result=ok|failed|ignored
+// duration_ms=<n> [error=<quoted>]. error comes last and is a Go-quoted
+// string, so it may contain spaces. The orchestrator surfaces it as a
+// StatusUpdate.
+const hookMarker = "KATAMARAN_HOOK "
+
+// hookNameRe matches a DNS-1123 label; hook names key the Migration's
+// status and appear unquoted in the marker.
+var hookNameRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Hook is one pre-cutover or post-resume action. Exactly one of HTTP,
+// Exec and GuestExec is set. The JSON shape is spec.hooks.<phase>[] of
+// the Migration CRD.
+type Hook struct {
+	Name      string         `json:"name"`
+	HTTP      *HTTPHook      `json:"http,omitempty"`
+	Exec      *ExecHook      `json:"exec,omitempty"`
+	GuestExec *GuestExecHook `json:"guestExec,omitempty"`
+	// TimeoutSeconds bounds the hook; zero uses defaultHookTimeout.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// FailurePolicy is HookFailureAbort (the default when empty) or
+	// HookFailureIgnore.
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+// HTTPHook calls http://<pod IP>:<Port><Path>; any 2xx response is
+// success.
+type HTTPHook struct {
+	Port int    `json:"port"`
+	Path string `json:"path,omitempty"`
+	// Method is GET (the default) or POST.
+	Method string `json:"method,omitempty"`
+}
+
+// ExecHook runs Command in a container of the pod through the
+// apiserver's exec subresource; exit status 0 is success.
+type ExecHook struct {
+	// Container defaults to the pod's only (or first) container.
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// GuestExecHook runs Command inside the guest through qemu-guest-agent's
+// guest-exec; exit status 0 is success.
+type GuestExecHook struct {
+	Command []string `json:"command"`
+	// Socket is the file name of the agent's chardev socket in the VM's
+	// sandbox directory. Defaults to qga.sock.
+	Socket string `json:"socket,omitempty"`
+}
+
+// kind returns the hook's handler type, as used in the marker.
+func (h Hook) kind() string {
+	switch {
+	case h.HTTP != nil:
+		return "http"
+	case h.Exec != nil:
+		return "exec"
+	default:
+		return "guestExec"
+	}
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return defaultHookTimeout
+}
+
+func (h Hook) validate() error {
+	if !hookNameRe.MatchString(h.Name) || len(h.Name) > 63 {
+		return fmt.Errorf("hook name %q must be a DNS-1123 label", h.Name)
+	}
+	handlers := 0
+	for _, set := range []bool{h.HTTP != nil, h.Exec != nil, h.GuestExec != nil} {
+		if set {
+			handlers++
+		}
+	}
+	if handlers != 1 {
+		return fmt.Errorf("hook %s: exactly one of http, exec and guestExec must be set", h.Name)
+	}
+	if h.TimeoutSeconds < 0 || h.TimeoutSeconds > maxHookTimeoutSeconds {
+		return fmt.Errorf("hook %s: timeoutSeconds must be between 0 and %d, got %d", h.Name, maxHookTimeoutSeconds, h.TimeoutSeconds)
+	}
+	switch h.FailurePolicy {
+	case "", HookFailureAbort, HookFailureIgnore:
+	default:
+		return fmt.Errorf("hook %s: invalid failurePolicy %q (valid: abort, ignore)", h.Name, h.FailurePolicy)
+	}
+	switch {
+	case h.HTTP != nil:
+		if h.HTTP.Port < 1 || h.HTTP.Port > 65535 {
+			return fmt.Errorf("hook %s: http port must be between 1 and 65535, got %d", h.Name, h.HTTP.Port)
+		}
+		if h.HTTP.Path != "" && !strings.HasPrefix(h.HTTP.Path, "/") {
+			return fmt.Errorf("hook %s: http path %q must start with /", h.Name, h.HTTP.Path)
+		}
+		switch h.HTTP.Method {
+		case "", http.MethodGet, http.MethodPost:
+		default:
+			return fmt.Errorf("hook %s: invalid http method %q (valid: GET, POST)", h.Name, h.HTTP.Method)
+		}
+	case h.Exec != nil:
+		if len(h.Exec.Command) == 0 {
+			return fmt.Errorf("hook %s: exec command is required", h.Name)
+		}
+	case h.GuestExec != nil:
+		if len(h.GuestExec.Command) == 0 {
+			return fmt.Errorf("hook %s: guestExec command is required", h.Name)
+		}
+		if s := h.GuestExec.Socket; s != "" && (s != filepath.Base(s) || s == "." || s == "..") {
+			return fmt.Errorf("hook %s: guestExec socket %q must be a file name", h.Name, s)
+		}
+	}
+	return nil
+}
+
+// ParseHooks decodes the --pre-cutover-hooks / --post-resume-hooks value:
+// a JSON list of hooks, base64url-encoded so it passes through the Job's
+// shell command untouched. An empty string yields no hooks.
+func ParseHooks(s string) ([]Hook, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode hooks: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var hooks []Hook
+	if err := dec.Decode(&hooks); err != nil {
+		return nil, fmt.Errorf("parse hooks: %w", err)
+	}
+	seen := make(map[string]bool, len(hooks))
+	for _, h := range hooks {
+		if err := h.validate(); err != nil {
+			return nil, err
+		}
+		if seen[h.Name] {
+			return nil, fmt.Errorf("duplicate hook name %q", h.Name)
+		}
+		seen[h.Name] = true
+	}
+	return hooks, nil
+}
+
+// hookTarget is what a phase's hooks act on.
+type hookTarget struct {
+	// ip is the VM's pod IP for http hooks. When invalid it is looked up
+	// from ipFrom ("<namespace>/<name>") on first use.
+	ip     netip.Addr
+	ipFrom string
+	// execPod is the "<namespace>/<name>" exec hooks run in.
+	execPod string
+	// sandboxDir holds the VM's qemu-guest-agent socket.
+	sandboxDir string
+}
+
+// runHooks runs hooks in order, printing a KATAMARAN_HOOK marker for each.
+// The first failing hook with the abort policy stops the run and its
+// error is returned.
+func runHooks(ctx context.Context, phase string, hooks []Hook, t *hookTarget) error {
+	for _, h := range hooks {
+		slog.Info("Running migration hook", "phase", phase, "hook", h.Name, "type", h.kind(), "timeout", h.timeout())
+		hctx, cancel := context.WithTimeout(ctx, h.timeout())
+		start := time.Now()
+		err := runHook(hctx, h, t)
+		cancel()
+		elapsed := time.Since(start)
+		result := hookResultOK
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			// Cancelled from above: not the hook's failure.
+			return ctx.Err()
+		case h.FailurePolicy == HookFailureIgnore:
+			result = hookResultIgnored
+		default:
+			result = hookResultFailed
+		}
+		line := fmt.Sprintf("%sphase=%s name=%s type=%s result=%s duration_ms=%d",
+			hookMarker, phase, h.Name, h.kind(), result, elapsed.Milliseconds())
+		if err != nil {
+			line += " error=" + strconv.Quote(err.Error())
+		}
+		markerln(ctx, line)
+		switch result {
+		case hookResultOK:
+			slog.Info("Migration hook succeeded", "phase", phase, "hook", h.Name, "elapsed", elapsed.Round(time.Millisecond))
+		case hookResultIgnored:
+			slog.Warn("Migration hook failed; ignoring per its failure policy", "phase", phase, "hook", h.Name, "error", err)
+		default:
+			return fmt.Errorf("%s hook %s: %w", phase, h.Name, err)
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, h Hook, t *hookTarget) error {
+	switch {
+	case h.HTTP != nil:
+		ip, err := t.podIP(ctx)
+		if err != nil {
+			return err
+		}
+		return runHTTPHook(ctx, h.HTTP, ip)
+	case h.Exec != nil:
+		if t.execPod == "" {
+			return errors.New("exec hooks need a pod (--pod-name/--pod-namespace)")
+		}
+		return runExecHook(ctx, h.Exec, t.execPod)
+	default:
+		socket := h.GuestExec.Socket
+		if socket == "" {
+			socket = defaultGuestAgentSocket
+		}
+		return runGuestExecHook(ctx, h.GuestExec.Command, filepath.Join(t.sandboxDir, socket))
+	}
+}
+
+func (t *hookTarget) podIP(ctx context.Context) (netip.Addr, error) {
+	if t.ip.IsValid() {
+		return t.ip, nil
+	}
+	if t.ipFrom == "" {
+		return netip.Addr{}, errors.New("http hooks need the VM's pod IP (--pod-name/--pod-namespace)")
+	}
+	ns, name, err := parsePodRef(t.ipFrom)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	s, err := lookupPodIP(ctx, ns, name)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("lookup pod IP: %w", err)
+	}
+	if t.ip, err = netip.ParseAddr(s); err != nil {
+		return netip.Addr{}, fmt.Errorf("parse pod IP %q: %w", s, err)
+	}
+	return t.ip, nil
+}
+
+// runHTTPHook calls the hook's endpoint on the VM. Redirects are not
+// followed: the hook addresses this VM only.
+func runHTTPHook(ctx context.Context, h *HTTPHook, ip netip.Addr) error {
+	method := h.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	endpoint := "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(h.Port)) + h.Path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, endpoint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s returned %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sourceHookTarget is the preCutover target: the source pod, its VM and
+// sandbox.
+func sourceHookTarget(cfg SourceConfig) *hookTarget {
+	t := &hookTarget{ip: cfg.VMIP, sandboxDir: filepath.Dir(cfg.QMPSocket)}
+	if cfg.PodName != "" {
+		t.execPod = cfg.PodNamespace + "/" + cfg.PodName
+	}
+	return t
+}
+
+// destHookTarget is the postResume target: the migrated VM, which keeps
+// the source pod's IP, and the destination pod when one was named (the
+// source pod otherwise) for exec hooks.
+func destHookTarget(cfg DestConfig) *hookTarget {
+	t := &hookTarget{ipFrom: cfg.SourcePodRef, execPod: cfg.SourcePodRef, sandboxDir: filepath.Dir(cfg.QMPSocket)}
+	if cfg.DestPodName != "" {
+		t.execPod = cfg.DestPodNamespace + "/" + cfg.DestPodName
+	}
+	return t
+}
+
+// truncateOutput shortens hook output for an error message.
+func truncateOutput(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > maxHookOutput {
+		s = s[:maxHookOutput] + "..."
+	}
+	return s
+}
@@ -0,0 +1,321 @@
+package migration
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func encodeHooks(t *testing.T, hooks any) string {
+	t.Helper()
+	raw, err := json.Marshal(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// captureStdout runs fn with os.Stdout redirected and returns what it
+// printed.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	defer func() { os.Stdout = orig }()
+	fn()
+	_ = w.Close()
+	return <-done
+}
+
+func TestParseHooks(t *testing.T) {
+	hooks, err := ParseHooks(encodeHooks(t, []map[string]any{
+		{"name": "flush-wal", "exec": map[string]any{"container": "db", "command": []string{"pg_ctl", "checkpoint"}}, "timeoutSeconds": 60},
+		{"name": "drain", "http": map[string]any{"port": 8080, "path": "/quiesce", "method": "POST"}, "failurePolicy": "ignore"},
+		{"name": "sync-clock", "guestExec": map[string]any{"command": []string{"/usr/sbin/hwclock", "-s"}}},
+	}))
+	if err != nil {
+		t.Fatalf("ParseHooks: %v", err)
+	}
+	if len(hooks) != 3 {
+		t.Fatalf("got %d hooks, want 3", len(hooks))
+	}
+	if h := hooks[0]; h.kind() != "exec" || h.timeout() != time.Minute || h.Exec.Container != "db" {
+		t.Errorf("hooks[0] = %+v", h)
+	}
+	if h := hooks[1]; h.kind() != "http" || h.timeout() != defaultHookTimeout || h.FailurePolicy != HookFailureIgnore {
+		t.Errorf("hooks[1] = %+v", h)
+	}
+	if h := hooks[2]; h.kind() != "guestExec" || !slices.Equal(h.GuestExec.Command, []string{"/usr/sbin/hwclock", "-s"}) {
+		t.Errorf("hooks[2] = %+v", h)
+	}
+
+	if hooks, err := ParseHooks(""); err != nil || hooks != nil {
+		t.Errorf(`ParseHooks("") = %v, %v; want nil, nil`, hooks, err)
+	}
+}
+
+func TestParseHooksRejects(t *testing.T) {
+	exec := map[string]any{"command": []string{"true"}}
+	for name, hooks := range map[string][]map[string]any{
+		"no handler":       {{"name": "a"}},
+		"two handlers":     {{"name": "a", "exec": exec, "guestExec": exec}},
+		"bad name":         {{"name": "Flush_WAL", "exec": exec}},
+		"duplicate name":   {{"name": "a", "exec": exec}, {"name": "a", "exec": exec}},
+		"bad policy":       {{"name": "a", "exec": exec, "failurePolicy": "retry"}},
+		"timeout too long": {{"name": "a", "exec": exec, "timeoutSeconds": 3600}},
+		"empty command":    {{"name": "a", "exec": map[string]any{"command": []string{}}}},
+		"bad port":         {{"name": "a", "http": map[string]any{"port": 0}}},
+		"relative path":    {{"name": "a", "http": map[string]any{"port": 80, "path": "quiesce"}}},
+		"bad method":       {{"name": "a", "http": map[string]any{"port": 80, "method": "DELETE"}}},
+		"socket path":      {{"name": "a", "guestExec": map[string]any{"command": []string{"true"}, "socket": "../qga.sock"}}},
+		"unknown field":    {{"name": "a", "exec": exec, "retries": 3}},
+	} {
+		if _, err := ParseHooks(encodeHooks(t, hooks)); err == nil {
+			t.Errorf("%s: ParseHooks accepted %v", name, hooks)
+		}
+	}
+	if _, err := ParseHooks("not base64!"); err == nil {
+		t.Error("ParseHooks accepted a malformed value")
+	}
+}
+
+func TestRunHooksHTTPAndFailurePolicy(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/broken" {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	addr := netip.MustParseAddrPort(strings.TrimPrefix(srv.URL, "http://"))
+	target := &hookTarget{ip: addr.Addr()}
+	port := int(addr.Port())
+
+	hooks := []Hook{
+		{Name: "quiesce", HTTP: &HTTPHook{Port: port, Path: "/quiesce", Method: http.MethodPost}},
+		{Name: "optional", HTTP: &HTTPHook{Port: port, Path: "/broken"}, FailurePolicy: HookFailureIgnore},
+		{Name: "required", HTTP: &HTTPHook{Port: port, Path: "/broken"}},
+		{Name: "never", HTTP: &HTTPHook{Port: port, Path: "/never"}},
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = runHooks(context.Background(), HookPhasePreCutover, hooks, target)
+	})
+	if err == nil || !strings.Contains(err.Error(), "preCutover hook required") || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("runHooks error = %v, want the required hook's failure", err)
+	}
+	if want := []string{"POST /quiesce", "GET /broken", "GET /broken"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("markers = %q, want 3 lines", out)
+	}
+	for i, want := range []string{
+		"KATAMARAN_HOOK phase=preCutover name=quiesce type=http result=ok ",
+		"KATAMARAN_HOOK phase=preCutover name=optional type=http result=ignored ",
+		"KATAMARAN_HOOK phase=preCutover name=required type=http result=failed ",
+	} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("marker %d = %q, want prefix %q", i, lines[i], want)
+		}
+	}
+	_, quoted, ok := strings.Cut(lines[2], " error=")
+	if msg, uerr := strconv.Unquote(quoted); !ok || uerr != nil || !strings.Contains(msg, "503") {
+		t.Errorf("marker error = %q, want a quoted 503 message", quoted)
+	}
+}
+
+func TestRunHooksTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+	addr := netip.MustParseAddrPort(strings.TrimPrefix(srv.URL, "http://"))
+
+	hook := Hook{Name: "slow", HTTP: &HTTPHook{Port: int(addr.Port())}, TimeoutSeconds: 1}
+	start := time.Now()
+	var err error
+	captureStdout(t, func() {
+		err = runHooks(context.Background(), HookPhasePostResume, []Hook{hook}, &hookTarget{ip: addr.Addr()})
+	})
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("runHooks error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook ran %s past its 1s timeout", elapsed)
+	}
+}
+
+func TestRunHooksNeedsTarget(t *testing.T) {
+	hooks := []Hook{
+		{Name: "web", HTTP: &HTTPHook{Port: 80}, FailurePolicy: HookFailureIgnore},
+		{Name: "shell", Exec: &ExecHook{Command: []string{"true"}}},
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = runHooks(context.Background(), HookPhasePostResume, hooks, &hookTarget{})
+	})
+	if err == nil || !strings.Contains(err.Error(), "need a pod") {
+		t.Fatalf("runHooks error = %v, want missing pod", err)
+	}
+	if !strings.Contains(out, "name=web type=http result=ignored") {
+		t.Errorf("markers = %q, want the http hook ignored", out)
+	}
+}
+
+func TestRunExecHook(t *testing.T) {
+	var gotQuery string
+	ws := websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			if !slices.Contains(cfg.Protocol, execProtocol) {
+				return websocket.ErrBadWebSocketProtocol
+			}
+			cfg.Protocol = []string{execProtocol}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			r := conn.Request()
+			gotQuery = r.URL.RawQuery
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				return
+			}
+			_ = websocket.Message.Send(conn, []byte{execChanStdout})
+			_ = websocket.Message.Send(conn, append([]byte{execChanStderr}, "WAL busy"...))
+			status := `{"status":"Failure","message":"command terminated with non-zero exit code: exit status 1"}`
+			if r.URL.Query()["command"][0] == "true" {
+				status = `{"status":"Success"}`
+			}
+			_ = websocket.Message.Send(conn, append([]byte{execChanError}, status...))
+		},
+	}
+	setupAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/db-0/exec" {
+			http.NotFound(w, r)
+			return
+		}
+		ws.ServeHTTP(w, r)
+	})
+
+	err := runExecHook(context.Background(), &ExecHook{Container: "db", Command: []string{"true"}}, "default/db-0")
+	if err != nil {
+		t.Fatalf("runExecHook: %v", err)
+	}
+	if want := "command=true&container=db&stderr=true&stdout=true"; gotQuery != want {
+		t.Errorf("query = %q, want %q", gotQuery, want)
+	}
+
+	err = runExecHook(context.Background(), &ExecHook{Command: []string{"pg_ctl", "checkpoint"}}, "default/db-0")
+	if err == nil || !strings.Contains(err.Error(), "non-zero exit code") || !strings.Contains(err.Error(), "WAL busy") {
+		t.Errorf("runExecHook error = %v, want the exit status and output", err)
+	}
+}
+
+// serveGuestAgent answers guest-sync, guest-exec and guest-exec-status on
+// a unix socket; the command exits with exitCode on the second status
+// poll.
+func serveGuestAgent(t *testing.T, socket string, exitCode int, gotCmd chan<- []string) {
+	t.Helper()
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		// A stale reply from an earlier client, which guest-sync skips.
+		_, _ = io.WriteString(conn, `{"return": {"pid": 7}}`+"\n")
+		enc := json.NewEncoder(conn)
+		sc := bufio.NewScanner(conn)
+		polls := 0
+		for sc.Scan() {
+			var req struct {
+				Execute   string         `json:"execute"`
+				Arguments map[string]any `json:"arguments"`
+			}
+			if json.Unmarshal(sc.Bytes(), &req) != nil {
+				return
+			}
+			switch req.Execute {
+			case "guest-sync":
+				_ = enc.Encode(map[string]any{"return": req.Arguments["id"]})
+			case "guest-exec":
+				cmd := []string{req.Arguments["path"].(string)}
+				for _, a := range req.Arguments["arg"].([]any) {
+					cmd = append(cmd, a.(string))
+				}
+				gotCmd <- cmd
+				_ = enc.Encode(map[string]any{"return": map[string]any{"pid": 42}})
+			case "guest-exec-status":
+				polls++
+				st := map[string]any{"exited": polls > 1}
+				if polls > 1 {
+					st["exitcode"] = exitCode
+					st["err-data"] = base64.StdEncoding.EncodeToString([]byte("clock unsynced"))
+				}
+				_ = enc.Encode(map[string]any{"return": st})
+			}
+		}
+	}()
+}
+
+func TestRunGuestExecHook(t *testing.T) {
+	orig := guestExecPollInterval
+	guestExecPollInterval = time.Millisecond
+	t.Cleanup(func() { guestExecPollInterval = orig })
+
+	for _, tc := range []struct {
+		exitCode int
+		wantErr  string
+	}{
+		{0, ""},
+		{3, "exited with status 3: clock unsynced"},
+	} {
+		dir := t.TempDir()
+		gotCmd := make(chan []string, 1)
+		serveGuestAgent(t, filepath.Join(dir, defaultGuestAgentSocket), tc.exitCode, gotCmd)
+		hook := Hook{Name: "sync-clock", GuestExec: &GuestExecHook{Command: []string{"/usr/sbin/hwclock", "-s"}}}
+		var err error
+		captureStdout(t, func() {
+			err = runHooks(context.Background(), HookPhasePostResume, []Hook{hook}, &hookTarget{sandboxDir: dir})
+		})
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("exit %d: runHooks: %v", tc.exitCode, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("exit %d: runHooks error = %v, want %q", tc.exitCode, err, tc.wantErr)
+		}
+		if cmd := <-gotCmd; !slices.Equal(cmd, []string{"/usr/sbin/hwclock", "-s"}) {
+			t.Errorf("guest-exec command = %v", cmd)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Hypervisor names accepted by SourceConfig.Hypervisor and
+// DestConfig.Hypervisor (--hypervisor).
+const (
+	// HypervisorAuto picks the hypervisor from the control socket: Cloud
+	// Hypervisor when it is (or, for a sandbox's QMP socket that does not
+	// exist, sits next to) a clh-api.sock, QEMU otherwise.
+	HypervisorAuto            = "auto"
+	HypervisorQEMU            = "qemu"
+	HypervisorCloudHypervisor = "cloud-hypervisor"
+
+	// clhAPISocket is the Cloud Hypervisor API socket Kata creates in the
+	// sandbox directory.
+	clhAPISocket = "clh-api.sock"
+	// qemuMonitorSocket is the sandbox QMP socket pod mode resolves to.
+	qemuMonitorSocket = "extra-monitor.sock"
+)
+
+// Hypervisor is the VMM side of a live migration: what RunSource and
+// RunDestination ask of the VM itself. qemuHypervisor implements it over
+// QMP, chHypervisor over the Cloud Hypervisor HTTP API. Traffic
+// redirection (tunnel, sch_plug) is the caller's and the same for both.
+type Hypervisor interface {
+	// SyncStorage copies the source's cfg.DriveIDs to the destination and
+	// returns once they are in sync. Guest writes keep being mirrored
+	// until StopStorageSync.
+	SyncStorage(ctx context.Context, cfg SourceConfig) error
+	// StopStorageSync ends the storage copy and releases its resources.
+	// It is safe to call more than once, and without SyncStorage.
+	StopStorageSync(ctx context.Context)
+	// StartMigration starts the RAM transfer to cfg's destination.
+	StartMigration(ctx context.Context, cfg SourceConfig, downtimeLimitMS int) error
+	// WaitPaused returns once the source VM has paused for the cutover,
+	// or with the error that ended the transfer before it did.
+	WaitPaused(ctx context.Context) error
+	// WaitComplete returns once the transfer has ended, with its error.
+	WaitComplete(ctx context.Context) error
+	// Progress reports the transfer's current statistics.
+	Progress(ctx context.Context) (MigrationStats, error)
+	// Cancel aborts a running transfer.
+	Cancel(ctx context.Context) error
+
+	// Receive makes the destination VMM listen for the incoming
+	// migration on port.
+	Receive(ctx context.Context, cfg DestConfig, port string) error
+	// WaitResumed returns once the incoming VM runs.
+	WaitResumed(ctx context.Context) error
+	// Announce has the guest's NICs announce their MACs (gratuitous
+	// ARP), so switches learn the VM's new port.
+	Announce(ctx context.Context) error
+
+	// ShrinkMemory inflates the guest's memory balloon toward what the
+	// guest uses and returns the bytes taken; zero without a balloon.
+	ShrinkMemory(ctx context.Context) (int64, error)
+	// RestoreMemory deflates the balloon back to the VM's full memory.
+	RestoreMemory(ctx context.Context) error
+
+	Close() error
+}
+
+// MigrationStats are a migration's transfer statistics. Fields a
+// hypervisor does not report are zero.
+type MigrationStats struct {
+	Status         string
+	DowntimeMS     int64
+	TotalTimeMS    int64
+	SetupTimeMS    int64
+	RAMTransferred int64
+	RAMTotal       int64
+	RAMRemaining   int64
+	// CompressionRatio is guest page bytes per byte sent; see
+	// compressionRatio.
+	CompressionRatio float64
+}
+
+// resolveHypervisor returns the hypervisor and control socket to use for
+// name (HypervisorAuto when empty) and socket. A sandbox QMP socket
+// (extra-monitor.sock) stands in for the sandbox: for Cloud Hypervisor it
+// is replaced by the clh-api.sock next to it.
+func resolveHypervisor(name, socket string) (string, string, error) {
+	sibling := filepath.Join(filepath.Dir(socket), clhAPISocket)
+	switch name {
+	case HypervisorQEMU:
+		return name, socket, nil
+	case HypervisorCloudHypervisor:
+		if filepath.Base(socket) == qemuMonitorSocket {
+			socket = sibling
+		}
+		return name, socket, nil
+	case "", HypervisorAuto:
+		if filepath.Base(socket) == clhAPISocket {
+			return HypervisorCloudHypervisor, socket, nil
+		}
+		if filepath.Base(socket) == qemuMonitorSocket && !socketExists(socket) && socketExists(sibling) {
+			slog.Info("Detected Cloud Hypervisor sandbox", "api_socket", sibling)
+			return HypervisorCloudHypervisor, sibling, nil
+		}
+		return HypervisorQEMU, socket, nil
+	}
+	return "", "", fmt.Errorf("invalid hypervisor %q (valid: %s, %s, %s)", name, HypervisorAuto, HypervisorQEMU, HypervisorCloudHypervisor)
+}
+
+func socketExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().Type() == os.ModeSocket
+}
+
+// openHypervisor connects to the control socket of a resolved hypervisor.
+func openHypervisor(ctx context.Context, name, socket string) (Hypervisor, error) {
+	if name == HypervisorCloudHypervisor {
+		ch := newCHHypervisor(socket)
+		if err := ch.ping(ctx); err != nil {
+			return nil, fmt.Errorf("connecting to Cloud Hypervisor API: %w", err)
+		}
+		return ch, nil
+	}
+	client, err := qmp.NewClient(ctx, socket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to QMP: %w", err)
+	}
+	return &qemuHypervisor{client: client}, nil
+}
+
+// checkSourceHypervisor rejects source features hypervisor name lacks.
+// Cloud Hypervisor has no storage migration, no QMP for cmdline replay
+// and device inventory, and no pre-copy stop for cold migration.
+func checkSourceHypervisor(name string, cfg SourceConfig) error {
+	if name != HypervisorCloudHypervisor {
+		return nil
+	}
+	switch {
+	case !cfg.SharedStorage:
+		return errors.New("cloud-hypervisor migrates no storage: use shared storage")
+	case cfg.EmitCmdlineTo != "":
+		return errors.New("cloud-hypervisor does not support cmdline replay")
+	case cfg.Cold:
+		return errors.New("cloud-hypervisor does not support cold migration")
+	}
+	return nil
+}
+
+// checkDestHypervisor is checkSourceHypervisor for the destination.
+func checkDestHypervisor(name string, cfg DestConfig) error {
+	if name != HypervisorCloudHypervisor {
+		return nil
+	}
+	switch {
+	case !cfg.SharedStorage:
+		return errors.New("cloud-hypervisor migrates no storage: use shared storage")
+	case cfg.ReplayCmdlineFile != "" || cfg.ReplayCmdlineFromPod != "":
+		return errors.New("cloud-hypervisor does not support cmdline replay")
+	case cfg.ColdFromJob != "":
+		return errors.New("cloud-hypervisor does not support cold migration")
+	}
+	return nil
+}
+
+// qemuHypervisor is the QMP Hypervisor: drive-mirror or blockdev-mirror
+// over NBD for storage, migrate for RAM, STOP and RESUME events for the
+// cutover, announce-self for the gratuitous ARP.
+type qemuHypervisor struct {
+	client  *qmp.Client
+	mirrors *mirrorSet
+	tuner   *migrationTuner // nil without SourceConfig.AdaptiveTuning
+}
+
+func (q *qemuHypervisor) SyncStorage(ctx context.Context, cfg SourceConfig) error {
+	q.mirrors = newMirrorSet(q.client, cfg)
+	targets := resolveBlockTargets(ctx, q.client, cfg.DriveIDs)
+	for _, t := range targets {
+		if err := q.mirrors.start(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Waiting for storage mirrors to synchronize", "drives", len(targets))
+	storageSyncStart := time.Now()
+	if err := q.mirrors.wait(ctx); err != nil {
+		return fmt.Errorf("storage sync failed after %s: %w", time.Since(storageSyncStart).Round(time.Millisecond), err)
+	}
+	slog.Info("All storage mirrors synchronized", "drives", len(targets), "elapsed", time.Since(storageSyncStart).Round(time.Millisecond))
+
+	if cfg.VerifyStorage != "" && cfg.VerifyStorage != VerifyStorageOff && len(targets) > 0 {
+		slog.Info("Verifying mirrored storage", "mode", cfg.VerifyStorage, "drives", len(targets))
+		verifyStart := time.Now()
+		if err := verifyMirroredStorage(ctx, q.client, cfg, targets); err != nil {
+			return fmt.Errorf("storage verification failed after %s: %w", time.Since(verifyStart).Round(time.Millisecond), err)
+		}
+		slog.Info("Storage verification complete", "elapsed", time.Since(verifyStart).Round(time.Millisecond))
+	}
+	return nil
+}
+
+func (q *qemuHypervisor) StopStorageSync(ctx context.Context) {
+	if q.mirrors != nil {
+		q.mirrors.teardown(ctx)
+	}
+}
+
+func (q *qemuHypervisor) StartMigration(ctx context.Context, cfg SourceConfig, downtimeLimitMS int) error {
+	// Enable auto-converge: if the guest's dirty page rate exceeds the
+	// transfer rate, QEMU will throttle guest vCPUs to ensure migration converges.
+	// Without this, migration could run indefinitely on write-heavy workloads.
+	// The adaptive tuner replaces it for guests that cannot take the
+	// throttling, trading it for a longer pause.
+	caps := migrationCapabilities(cfg.MultifdChannels, cfg.Compression)
+	if cfg.AdaptiveTuning {
+		for i := range caps {
+			if caps[i].Capability == "auto-converge" {
+				caps[i].State = false
+			}
+		}
+		q.tuner = newMigrationTuner(cfg, downtimeLimitMS)
+	}
+	if _, err := q.client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
+		Capabilities: caps,
+	}); err != nil {
+		return fmt.Errorf("setting migration capabilities: %w", err)
+	}
+	params := qmp.MigrateSetParametersArgs{
+		DowntimeLimit:   int64(downtimeLimitMS),
+		MaxBandwidth:    transferBandwidth(cfg),
+		MultifdChannels: int64(cfg.MultifdChannels),
+	}
+	setCompressionParams(ctx, q.client, &params, cfg.Compression, cfg.CompressionLevel)
+	if _, err := q.client.Execute(ctx, "migrate-set-parameters", params); err != nil {
+		return fmt.Errorf("setting migration parameters: %w", err)
+	}
+
+	uri := fmt.Sprintf("tcp:%s:%s", formatQEMUHost(cfg.DestIP), portOr(cfg.MigrationPort, ramMigrationPort))
+	if _, err := q.client.Execute(ctx, "migrate", qmp.MigrateArgs{URI: uri}); err != nil {
+		return fmt.Errorf("starting RAM migration to %s: %w", uri, err)
+	}
+	return nil
+}
+
+// WaitPaused waits for the STOP event. It polls migration status in the
+// same loop rather than using a separate goroutine for WaitForEvent vs
+// query-migrate. This prevents QMP socket data races and ensures we
+// detect silent migration failures.
+func (q *qemuHypervisor) WaitPaused(ctx context.Context) error {
+	var lastLoggedStatus qmp.MigrateStatus
+	var lastLoggedRemaining int64
+	var queryErrors int
+	for {
+		err := q.client.WaitForEvent(ctx, "STOP", migrationPollInterval)
+		if err == nil {
+			return nil
+		}
+
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return fmt.Errorf("unexpected error waiting for STOP event: %w", err)
+		}
+		// Check if the background migration process failed.
+		raw, qerr := q.client.Execute(ctx, "query-migrate", nil)
+		if qerr != nil {
+			queryErrors++
+			logTransientQueryError(ctx, "Transient query-migrate error during STOP polling", qerr, queryErrors)
+			continue
+		}
+		var info qmp.MigrateInfo
+		if err := json.Unmarshal(raw, &info); err != nil {
+			queryErrors++
+			logTransientQueryError(ctx, "Failed to parse query-migrate response", err, queryErrors)
+			continue
+		}
+		queryErrors = 0
+		// Log only on status change or significant progress (remaining bytes halved).
+		statusChanged := info.Status != lastLoggedStatus
+		remainingChanged := lastLoggedRemaining > 0 && info.RAM.Remaining <= lastLoggedRemaining/2
+		if statusChanged || remainingChanged {
+			var pct float64
+			if info.RAM.Total > 0 {
+				pct = float64(info.RAM.Transferred) / float64(info.RAM.Total) * 100
+			}
+			slog.Info("Migration progress", "status", info.Status, "progress_pct", pct, "ram_transferred", info.RAM.Transferred, "ram_total", info.RAM.Total, "ram_remaining", info.RAM.Remaining)
+			lastLoggedStatus = info.Status
+			lastLoggedRemaining = info.RAM.Remaining
+		}
+		if q.tuner != nil {
+			q.tuner.tune(ctx, q.client, info)
+		}
+		if terminal, termErr := migrationTerminalError(info.Status, info.ErrorDesc); terminal {
+			if termErr != nil {
+				return fmt.Errorf("during STOP polling: %w", termErr)
+			}
+			slog.Warn("Migration completed without explicit STOP event", "status", info.Status)
+			return nil
+		}
+	}
+}
+
+func (q *qemuHypervisor) WaitComplete(ctx context.Context) error {
+	return waitForMigrationComplete(ctx, q.client)
+}
+
+func (q *qemuHypervisor) Progress(ctx context.Context) (MigrationStats, error) {
+	raw, err := q.client.Execute(ctx, "query-migrate", nil)
+	if err != nil {
+		return MigrationStats{}, err
+	}
+	var info qmp.MigrateInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return MigrationStats{}, fmt.Errorf("unmarshaling migration status: %w", err)
+	}
+	return MigrationStats{
+		Status:           string(info.Status),
+		DowntimeMS:       info.Downtime,
+		TotalTimeMS:      info.TotalTime,
+		SetupTimeMS:      info.SetupTime,
+		RAMTransferred:   info.RAM.Transferred,
+		RAMTotal:         info.RAM.Total,
+		RAMRemaining:     info.RAM.Remaining,
+		CompressionRatio: compressionRatio(info),
+	}, nil
+}
+
+func (q *qemuHypervisor) Cancel(ctx context.Context) error {
+	_, err := q.client.Execute(ctx, "migrate-cancel", nil)
+	return err
+}
+
+// Receive configures the destination's capabilities, which must match
+// the source's; otherwise the migration handshake fails with "Failed to
+// peek at channel" or similar magic-mismatch errors. Then it opens the
+// incoming listener with migrate-incoming on the already-running QEMU.
+func (q *qemuHypervisor) Receive(ctx context.Context, cfg DestConfig, port string) error {
+	if _, err := q.client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
+		Capabilities: migrationCapabilities(cfg.MultifdChannels, cfg.Compression),
+	}); err != nil {
+		return fmt.Errorf("setting destination migration capabilities: %w", err)
+	}
+	if cfg.MultifdChannels > 0 {
+		params := qmp.MigrateSetParametersArgs{MultifdChannels: int64(cfg.MultifdChannels)}
+		if cfg.Compression == CompressionZstd || cfg.Compression == CompressionZlib {
+			params.MultifdCompression = cfg.Compression
+		}
+		if _, err := q.client.Execute(ctx, "migrate-set-parameters", params); err != nil {
+			return fmt.Errorf("setting destination migration parameters: %w", err)
+		}
+		slog.Info("Multifd enabled on destination", "channels", cfg.MultifdChannels, "compression", params.MultifdCompression)
+	}
+
+	// Starting QEMU with -incoming is incompatible with Kata's sandbox lifecycle
+	// (Kata kills the QEMU because kata-agent never connects via vsock in
+	// incoming mode), so we use a QMP command on the already-running instance.
+	incomingURI := fmt.Sprintf("tcp:[::]:%s", port)
+	slog.Info("Opening incoming migration listener", "uri", incomingURI)
+	if _, err := q.client.Execute(ctx, "migrate-incoming", qmp.MigrateArgs{URI: incomingURI}); err != nil {
+		return fmt.Errorf("configuring incoming migration listener: %w", err)
+	}
+	slog.Info("Incoming migration listener ready", "uri", incomingURI)
+	return nil
+}
+
+func (q *qemuHypervisor) WaitResumed(ctx context.Context) error {
+	if err := q.client.WaitForEvent(ctx, "RESUME", eventWaitTimeout); err != nil {
+		return fmt.Errorf("waiting for RESUME event: %w", err)
+	}
+	return nil
+}
+
+// Announce uses announce-self. Unlike host-side arping (which sends the
+// host tap MAC), it emits GARP/RARP from the guest's actual MAC address
+// on all NICs, ensuring switches learn the correct port-to-MAC binding.
+func (q *qemuHypervisor) Announce(ctx context.Context) error {
+	if _, err := q.client.Execute(ctx, "announce-self", qmp.AnnounceSelfArgs{
+		Initial: garpInitialMS,
+		Max:     garpMaxMS,
+		Rounds:  garpRounds,
+		Step:    garpStepMS,
+	}); err != nil {
+		return fmt.Errorf("GARP announce-self failed: %w", err)
+	}
+	return nil
+}
+
+// ShrinkMemory uses the virtio-balloon device (see balloon.go).
+func (q *qemuHypervisor) ShrinkMemory(ctx context.Context) (int64, error) {
+	return shrinkGuestMemory(ctx, q.client)
+}
+
+func (q *qemuHypervisor) RestoreMemory(ctx context.Context) error {
+	return restoreGuestMemory(ctx, q.client)
+}
+
+func (q *qemuHypervisor) Close() error {
+	return q.client.Close()
+}
@@ -0,0 +1,56 @@
+package migration
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveHypervisor(t *testing.T) {
+	t.Parallel()
+	chSandbox := t.TempDir()
+	l, err := net.Listen("unix", filepath.Join(chSandbox, clhAPISocket))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	chMonitor := filepath.Join(chSandbox, qemuMonitorSocket)
+	qemuMonitor := filepath.Join(t.TempDir(), qemuMonitorSocket)
+
+	for _, tc := range []struct {
+		name, hv, socket   string
+		wantHV, wantSocket string
+		wantErr            bool
+	}{
+		{"auto qemu", "", qemuMonitor, HypervisorQEMU, qemuMonitor, false},
+		{"auto api socket", HypervisorAuto, "/run/vc/vm/x/clh-api.sock", HypervisorCloudHypervisor, "/run/vc/vm/x/clh-api.sock", false},
+		{"auto ch sandbox", HypervisorAuto, chMonitor, HypervisorCloudHypervisor, filepath.Join(chSandbox, clhAPISocket), false},
+		{"explicit ch", HypervisorCloudHypervisor, "/run/vc/vm/x/extra-monitor.sock", HypervisorCloudHypervisor, "/run/vc/vm/x/clh-api.sock", false},
+		{"explicit qemu", HypervisorQEMU, chMonitor, HypervisorQEMU, chMonitor, false},
+		{"unknown", "firecracker", qemuMonitor, "", "", true},
+	} {
+		hv, socket, err := resolveHypervisor(tc.hv, tc.socket)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: err = %v", tc.name, err)
+		}
+		if hv != tc.wantHV || socket != tc.wantSocket {
+			t.Errorf("%s: got %q %q, want %q %q", tc.name, hv, socket, tc.wantHV, tc.wantSocket)
+		}
+	}
+}
+
+func TestCheckHypervisorSupport(t *testing.T) {
+	t.Parallel()
+	if err := checkSourceHypervisor(HypervisorCloudHypervisor, SourceConfig{SharedStorage: true, Cold: true}); err == nil {
+		t.Error("cold migration accepted for cloud-hypervisor")
+	}
+	if err := checkSourceHypervisor(HypervisorQEMU, SourceConfig{Cold: true}); err != nil {
+		t.Errorf("qemu: %v", err)
+	}
+	if err := checkDestHypervisor(HypervisorCloudHypervisor, DestConfig{SharedStorage: true, ReplayCmdlineFile: "/tmp/c"}); err == nil {
+		t.Error("cmdline replay accepted for cloud-hypervisor")
+	}
+	if err := checkDestHypervisor(HypervisorCloudHypervisor, DestConfig{SharedStorage: true}); err != nil {
+		t.Errorf("shared storage: %v", err)
+	}
+}
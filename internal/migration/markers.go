@@ -0,0 +1,38 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// markerOutputKey is the context key of WithMarkerOutput.
+type markerOutputKey struct{}
+
+// WithMarkerOutput returns a copy of ctx under which the Run* functions
+// print their KATAMARAN_* stdout markers to w instead of os.Stdout. A
+// process running several migrations at once (katamaran-agent) gives
+// each its own marker stream this way. w must be safe for concurrent
+// use; each marker is written with a single Write call.
+func WithMarkerOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, markerOutputKey{}, w)
+}
+
+func markerOutput(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(markerOutputKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
+
+// markerf prints a marker line formatted like fmt.Printf to ctx's marker
+// output. A failed write is ignored, as it was for fmt.Printf.
+func markerf(ctx context.Context, format string, args ...any) {
+	_, _ = fmt.Fprintf(markerOutput(ctx), format, args...)
+}
+
+// markerln prints line and a newline to ctx's marker output.
+func markerln(ctx context.Context, line string) {
+	_, _ = io.WriteString(markerOutput(ctx), line+"\n")
+}
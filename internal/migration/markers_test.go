@@ -0,0 +1,54 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writers.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWithMarkerOutput(t *testing.T) {
+	sock, _ := startRecordingQMP(t, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("qmp.NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var markers lockedBuffer
+	ctx := WithMarkerOutput(context.Background(), &markers)
+	stdout := captureStdout(t, func() {
+		tuner := newMigrationTuner(SourceConfig{}, 25)
+		for range tuneStallPolls + 1 {
+			tuner.tune(ctx, client, stalledInfo())
+		}
+	})
+	if stdout != "" {
+		t.Errorf("stdout = %q, want nothing", stdout)
+	}
+	if got := markers.String(); !strings.HasPrefix(got, tuningMarker+"step=1 ") || !strings.HasSuffix(got, "\n") {
+		t.Fatalf("marker output = %q, want one %s line", got, strings.TrimSpace(tuningMarker))
+	}
+}
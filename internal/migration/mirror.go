@@ -0,0 +1,377 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+const (
+	// mirrorBitmap tracks guest writes since a mirror first reached ready,
+	// so a mirror that has to be recreated only re-copies those clusters.
+	mirrorBitmap = "katamaran-mirror"
+	// resyncNodePrefix names the NBD client node a bitmap resync writes
+	// through; the mirror holds its own target node exclusively.
+	resyncNodePrefix = "katamaran-resync-"
+)
+
+// mirrorReconnectPoll is how often recovery re-dials the destination's NBD
+// port while waiting for it to come back. Package-level var so tests can
+// shorten it.
+var mirrorReconnectPoll = time.Second
+
+// mirrorJob is the mirror of one drive.
+type mirrorJob struct {
+	target  blockTarget
+	jobID   string
+	nbdNode string // blockdev-mirror target node; empty for drive-mirror
+	// everReady is set once the mirror first reached ready; from then on
+	// mirrorBitmap holds every write the destination may be missing.
+	everReady bool
+	bitmap    bool // mirrorBitmap exists on the source drive
+	retries   int  // resumes and restarts left
+	restarts  int
+	resync    string // running or failed resync backup job
+	resyncs   []string
+}
+
+// mirrorSet runs the storage mirrors of one migration and recovers them
+// from target errors. Mirrors run with on-target-error=stop, so a dropped
+// NBD connection pauses the job instead of failing it: recovery waits for
+// the destination to accept connections again and resumes the job. A job
+// that is gone anyway is recreated, and when it had already synchronized
+// once only the clusters written since are copied again.
+type mirrorSet struct {
+	client           *qmp.Client
+	destIP           netip.Addr
+	port             string
+	retries          int
+	reconnectTimeout time.Duration
+	jobs             []*mirrorJob
+}
+
+// newMirrorSet returns a mirrorSet for cfg's destination and retry budget.
+func newMirrorSet(client *qmp.Client, cfg SourceConfig) *mirrorSet {
+	retries := cmp.Or(cfg.MirrorRetries, defaultMirrorRetries)
+	if retries < 0 {
+		retries = 0
+	}
+	return &mirrorSet{
+		client:           client,
+		destIP:           cfg.DestIP,
+		port:             portOr(cfg.NBDPort, nbdPort),
+		retries:          retries,
+		reconnectTimeout: cmp.Or(cfg.MirrorReconnectTimeout, defaultMirrorReconnectTimeout),
+	}
+}
+
+// start begins a full mirror of t.
+func (m *mirrorSet) start(ctx context.Context, t blockTarget) error {
+	j := &mirrorJob{target: t, jobID: "mirror-" + t.ID, retries: m.retries}
+	m.jobs = append(m.jobs, j)
+	if m.retries > 0 {
+		if _, err := m.client.Execute(ctx, "block-dirty-bitmap-add", qmp.BlockDirtyBitmapArgs{Node: cmp.Or(t.Node, t.ID), Name: mirrorBitmap}); err != nil {
+			slog.Warn("Failed to add mirror dirty bitmap; a recreated mirror will copy the whole drive", "drive_id", t.ID, "error", err)
+		} else {
+			j.bitmap = true
+		}
+	}
+	return m.startJob(ctx, j, "full")
+}
+
+// startJob starts j's mirror job with the given sync mode, adding a fresh
+// NBD client node for -blockdev drives.
+func (m *mirrorSet) startJob(ctx context.Context, j *mirrorJob, sync string) error {
+	t := j.target
+	if t.Node == "" {
+		targetNBD := fmt.Sprintf("nbd:%s:%s:exportname=%s", formatQEMUHost(m.destIP), m.port, t.ID)
+		slog.Info("Initiating storage mirror (drive-mirror)", "target", targetNBD, "drive_id", t.ID, "sync", sync)
+		if _, err := m.client.Execute(ctx, "drive-mirror", qmp.DriveMirrorArgs{
+			Device:        t.ID,
+			Target:        targetNBD,
+			Sync:          sync,
+			Mode:          "existing",
+			JobID:         j.jobID,
+			OnTargetError: "stop",
+		}); err != nil {
+			slog.Error("Drive-mirror failed", "target", targetNBD, "drive_id", t.ID, "error", err)
+			return fmt.Errorf("starting drive-mirror for %s: %w", t.ID, err)
+		}
+		return nil
+	}
+
+	node := nbdTargetNodePrefix + t.ID
+	if j.restarts > 0 {
+		node += "-" + strconv.Itoa(j.restarts)
+	}
+	slog.Info("Initiating storage mirror (blockdev-mirror)", "drive_id", t.ID, "node_name", t.Node, "target_node", node, "sync", sync)
+	if err := addNBDClientNode(ctx, m.client, node, m.destIP, m.port, t.ID); err != nil {
+		return err
+	}
+	if _, err := m.client.Execute(ctx, "blockdev-mirror", qmp.BlockdevMirrorArgs{
+		JobID:         j.jobID,
+		Device:        t.Node,
+		Target:        node,
+		Sync:          sync,
+		OnTargetError: "stop",
+	}); err != nil {
+		deleteBlockNodes(ctx, m.client, node)
+		slog.Error("Blockdev-mirror failed", "drive_id", t.ID, "node_name", t.Node, "error", err)
+		return fmt.Errorf("starting blockdev-mirror for %s: %w", t.ID, err)
+	}
+	j.nbdNode = node
+	return nil
+}
+
+// jobIDs returns the mirror job IDs in start order.
+func (m *mirrorSet) jobIDs() []string {
+	ids := make([]string, len(m.jobs))
+	for i, j := range m.jobs {
+		ids[i] = j.jobID
+	}
+	return ids
+}
+
+func (m *mirrorSet) job(jobID string) *mirrorJob {
+	for _, j := range m.jobs {
+		if j.jobID == jobID {
+			return j
+		}
+	}
+	return nil
+}
+
+// wait blocks until every mirror is ready, recovering from target errors.
+func (m *mirrorSet) wait(ctx context.Context) error {
+	return syncMirrors(ctx, m.client, m, m.jobIDs())
+}
+
+// ready records that jobID reached ready. The first time, the mirror
+// bitmap is cleared: everything written before is on the destination.
+func (m *mirrorSet) ready(ctx context.Context, jobID string) {
+	j := m.job(jobID)
+	if j == nil || j.everReady {
+		return
+	}
+	j.everReady = true
+	if !j.bitmap {
+		return
+	}
+	if _, err := m.client.Execute(ctx, "block-dirty-bitmap-clear", qmp.BlockDirtyBitmapArgs{Node: cmp.Or(j.target.Node, j.target.ID), Name: mirrorBitmap}); err != nil {
+		slog.Warn("Failed to clear mirror dirty bitmap; a recreated mirror will copy the whole drive", "drive_id", j.target.ID, "error", err)
+		j.bitmap = false
+	}
+}
+
+// failed reports the job IDs that raised BLOCK_JOB_ERROR since the last
+// call.
+func (m *mirrorSet) failed() map[string]bool {
+	ids := make(map[string]bool)
+	for _, data := range m.client.TakeEvents("BLOCK_JOB_ERROR") {
+		var ev qmp.BlockJobErrorEvent
+		if err := json.Unmarshal(data, &ev); err == nil {
+			ids[ev.Device] = true
+		}
+	}
+	return ids
+}
+
+// resume resumes a mirror paused by a target error once the destination
+// accepts connections again.
+func (m *mirrorSet) resume(ctx context.Context, jobID string) error {
+	j, err := m.spendRetry(jobID, "paused on a target error")
+	if err != nil {
+		return err
+	}
+	if err := m.waitForTarget(ctx); err != nil {
+		return fmt.Errorf("block mirror job %q paused: %w", jobID, err)
+	}
+	slog.Info("Resuming storage mirror after target error", "job_id", jobID, "retries_left", j.retries)
+	if _, err := m.client.Execute(ctx, "block-job-resume", qmp.BlockJobResumeArgs{Device: jobID}); err != nil {
+		return fmt.Errorf("resuming block mirror job %q: %w", jobID, err)
+	}
+	return nil
+}
+
+// restart recreates a mirror job that failed or disappeared. A mirror that
+// never synchronized starts over with sync=full. One that did restarts
+// with sync=none, so it forwards new writes, while a blockdev-backup copies
+// the clusters in the mirror bitmap. The backup's copy-before-write lands a
+// cluster's old data on the destination before the guest's write completes,
+// and the mirror copies the new data only after, so the two cannot race.
+func (m *mirrorSet) restart(ctx context.Context, jobID, reason string) error {
+	j, err := m.spendRetry(jobID, reason)
+	if err != nil {
+		return err
+	}
+	if err := m.waitForTarget(ctx); err != nil {
+		return fmt.Errorf("block mirror job %q %s: %w", jobID, reason, err)
+	}
+	// A concluded job that was not auto-dismissed blocks its ID.
+	_, _ = m.client.Execute(ctx, "block-job-dismiss", qmp.BlockJobDismissArgs{ID: jobID})
+	if j.nbdNode != "" {
+		deleteBlockNodes(ctx, m.client, j.nbdNode)
+		j.nbdNode = ""
+	}
+	j.restarts++
+
+	if !j.everReady || !j.bitmap {
+		slog.Warn("Restarting storage mirror with a full copy", "job_id", jobID, "reason", reason, "retries_left", j.retries)
+		return m.startJob(ctx, j, "full")
+	}
+	slog.Warn("Restarting storage mirror incrementally from the dirty bitmap", "job_id", jobID, "reason", reason, "retries_left", j.retries)
+	if err := m.startJob(ctx, j, "none"); err != nil {
+		return err
+	}
+	return m.resyncBitmap(ctx, j)
+}
+
+// resyncBitmap copies the clusters in j's mirror bitmap to the destination
+// and waits for the copy to finish.
+func (m *mirrorSet) resyncBitmap(ctx context.Context, j *mirrorJob) error {
+	t := j.target
+	node := resyncNodePrefix + t.ID + "-" + strconv.Itoa(j.restarts)
+	if err := addNBDClientNode(ctx, m.client, node, m.destIP, m.port, t.ID); err != nil {
+		return err
+	}
+	j.resyncs = append(j.resyncs, node)
+	backupID := "resync-" + t.ID
+	// bitmap-mode "never" keeps the bitmap intact, so a resync that fails
+	// too can be repeated.
+	if _, err := m.client.Execute(ctx, "blockdev-backup", qmp.BlockdevBackupArgs{
+		JobID:      backupID,
+		Device:     cmp.Or(t.Node, t.ID),
+		Target:     node,
+		Sync:       "bitmap",
+		Bitmap:     mirrorBitmap,
+		BitmapMode: "never",
+	}); err != nil {
+		return fmt.Errorf("starting bitmap resync of %s: %w", t.ID, err)
+	}
+	j.resync = backupID
+	if err := waitForBackupJob(ctx, m.client, backupID); err != nil {
+		return fmt.Errorf("bitmap resync of %s: %w", t.ID, err)
+	}
+	if _, err := m.client.Execute(ctx, "block-job-dismiss", qmp.BlockJobDismissArgs{ID: backupID}); err != nil {
+		slog.Warn("Failed to dismiss resync job", "job_id", backupID, "error", err)
+	}
+	j.resync = ""
+	deleteBlockNodes(ctx, m.client, node)
+	j.resyncs = j.resyncs[:len(j.resyncs)-1]
+	slog.Info("Bitmap resync complete", "drive_id", t.ID)
+	return nil
+}
+
+// waitForBackupJob polls query-block-jobs until the backup job jobID
+// concludes. The job must not be auto-dismissed.
+func waitForBackupJob(ctx context.Context, client *qmp.Client, jobID string) error {
+	ticker := time.NewTicker(storagePollInterval)
+	defer ticker.Stop()
+	for {
+		raw, err := client.Execute(ctx, "query-block-jobs", nil)
+		if err != nil {
+			return fmt.Errorf("querying block jobs: %w", err)
+		}
+		var jobs []qmp.BlockJobInfo
+		if err := json.Unmarshal(raw, &jobs); err != nil {
+			return fmt.Errorf("unmarshaling block jobs: %w", err)
+		}
+		found := false
+		for _, job := range jobs {
+			if job.Device != jobID {
+				continue
+			}
+			found = true
+			if job.Status == qmp.BlockJobStatusConcluded {
+				if job.Error != "" {
+					return fmt.Errorf("job %q failed: %s", jobID, job.Error)
+				}
+				return nil
+			}
+		}
+		if !found {
+			return fmt.Errorf("job %q disappeared", jobID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// spendRetry takes one retry from jobID's budget.
+func (m *mirrorSet) spendRetry(jobID, reason string) (*mirrorJob, error) {
+	j := m.job(jobID)
+	if j == nil {
+		return nil, fmt.Errorf("block mirror job %q %s", jobID, reason)
+	}
+	if j.retries <= 0 {
+		return nil, fmt.Errorf("block mirror job %q %s; no retries left (--mirror-retries=%d)", jobID, reason, m.retries)
+	}
+	j.retries--
+	return j, nil
+}
+
+// waitForTarget blocks until the destination's NBD port accepts a TCP
+// connection, for at most reconnectTimeout.
+func (m *mirrorSet) waitForTarget(ctx context.Context) error {
+	addr := net.JoinHostPort(m.destIP.Unmap().String(), m.port)
+	deadline := time.Now().Add(m.reconnectTimeout)
+	for {
+		d := net.Dialer{Timeout: mirrorReconnectPoll}
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("destination NBD server %s unreachable for %s: %w", addr, m.reconnectTimeout, err)
+		}
+		slog.Debug("Waiting for destination NBD server", "addr", addr, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(mirrorReconnectPoll):
+		}
+	}
+}
+
+// teardown cancels all mirror and resync jobs, then deletes the NBD client
+// nodes and mirror bitmaps. Safe to call more than once.
+func (m *mirrorSet) teardown(ctx context.Context) {
+	for _, j := range m.jobs {
+		ids := []string{j.jobID}
+		if j.resync != "" {
+			ids = append(ids, j.resync)
+		}
+		for _, id := range ids {
+			if _, err := m.client.Execute(ctx, "block-job-cancel", qmp.BlockJobCancelArgs{
+				Device: id,
+				Force:  true,
+			}); err != nil {
+				slog.Warn("Failed to cancel block job", "job_id", id, "error", err)
+			}
+		}
+	}
+	for _, j := range m.jobs {
+		if j.nbdNode != "" {
+			deleteBlockNodes(ctx, m.client, j.nbdNode)
+		}
+		deleteBlockNodes(ctx, m.client, j.resyncs...)
+		if j.bitmap {
+			if _, err := m.client.Execute(ctx, "block-dirty-bitmap-remove", qmp.BlockDirtyBitmapArgs{Node: cmp.Or(j.target.Node, j.target.ID), Name: mirrorBitmap}); err != nil {
+				slog.Warn("Failed to remove mirror dirty bitmap", "drive_id", j.target.ID, "error", err)
+			}
+		}
+	}
+	m.jobs = nil
+}
@@ -0,0 +1,204 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// mirrorDest listens where the destination NBD server would, so recovery's
+// reachability check passes.
+func mirrorDest(t *testing.T) SourceConfig {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	port, _ := strconv.Atoi(strings.TrimPrefix(l.Addr().String(), "127.0.0.1:"))
+	return SourceConfig{DestIP: netip.MustParseAddr("127.0.0.1"), NBDPort: port}
+}
+
+func newTestMirrorSet(t *testing.T, cfg SourceConfig, respond func(net.Conn, recordedQMPCommand) string) (*mirrorSet, *qmpRecorder) {
+	t.Helper()
+	sock, rec := startRecordingQMP(t, respond)
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return newMirrorSet(client, cfg), rec
+}
+
+func TestMirrorSet_StartBlockdevCommandArguments(t *testing.T) {
+	t.Parallel()
+	cfg := SourceConfig{DestIP: netip.MustParseAddr("fd00::2"), NBDPort: 10809}
+	m, rec := newTestMirrorSet(t, cfg, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+
+	if err := m.start(context.Background(), blockTarget{ID: "drive-vol1", Node: "drive-vol1"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{"block-dirty-bitmap-add", "blockdev-add", "blockdev-mirror"})
+
+	var bitmap qmp.BlockDirtyBitmapArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "block-dirty-bitmap-add"), &bitmap)
+	if bitmap != (qmp.BlockDirtyBitmapArgs{Node: "drive-vol1", Name: mirrorBitmap}) {
+		t.Errorf("block-dirty-bitmap-add = %+v", bitmap)
+	}
+	var add qmp.BlockdevAddNBDArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "blockdev-add"), &add)
+	wantAdd := qmp.BlockdevAddNBDArgs{
+		Driver:         "nbd",
+		NodeName:       "katamaran-nbd-drive-vol1",
+		Server:         qmp.InetSocketAddress{Type: "inet", Host: "fd00::2", Port: "10809"},
+		Export:         "drive-vol1",
+		ReconnectDelay: nbdReconnectDelay,
+	}
+	if add != wantAdd {
+		t.Errorf("blockdev-add = %+v, want %+v", add, wantAdd)
+	}
+	var mirror qmp.BlockdevMirrorArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "blockdev-mirror"), &mirror)
+	wantMirror := qmp.BlockdevMirrorArgs{JobID: "mirror-drive-vol1", Device: "drive-vol1", Target: "katamaran-nbd-drive-vol1", Sync: "full", OnTargetError: "stop"}
+	if mirror != wantMirror {
+		t.Errorf("blockdev-mirror = %+v, want %+v", mirror, wantMirror)
+	}
+}
+
+func TestMirrorSet_ResumesAfterTargetError(t *testing.T) {
+	t.Parallel()
+	var resumed atomic.Bool
+	m, rec := newTestMirrorSet(t, mirrorDest(t), func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-block-jobs":
+			if resumed.Load() {
+				return `{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":1000,"ready":true,"status":"ready","type":"mirror"}]}`
+			}
+			return `{"event":"BLOCK_JOB_ERROR","data":{"device":"mirror-drive-virtio-disk0","operation":"write","action":"stop"}}` + "\n" +
+				`{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":400,"status":"paused","paused":true,"io-status":"failed","type":"mirror"}]}`
+		case "block-job-resume":
+			resumed.Store(true)
+		}
+		return `{"return":{}}`
+	})
+
+	ctx := context.Background()
+	if err := m.start(ctx, blockTarget{ID: "drive-virtio-disk0"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := m.wait(ctx); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{"drive-mirror", "query-block-jobs", "block-job-resume", "query-block-jobs", "block-dirty-bitmap-clear"})
+	var mirror qmp.DriveMirrorArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "drive-mirror"), &mirror)
+	if mirror.OnTargetError != "stop" {
+		t.Errorf("drive-mirror on-target-error = %q, want stop", mirror.OnTargetError)
+	}
+	if j := m.job("mirror-drive-virtio-disk0"); j.retries != defaultMirrorRetries-1 {
+		t.Errorf("retries left = %d, want %d", j.retries, defaultMirrorRetries-1)
+	}
+}
+
+func TestMirrorSet_IncrementalRestart(t *testing.T) {
+	t.Parallel()
+	var polls atomic.Int32
+	var backup atomic.Bool
+	m, rec := newTestMirrorSet(t, mirrorDest(t), func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "query-block-jobs":
+			if backup.Load() {
+				backup.Store(false)
+				return `{"return":[{"device":"resync-vol1","len":4096,"offset":4096,"status":"concluded","type":"backup"}]}`
+			}
+			switch polls.Add(1) {
+			case 1: // vol1 synchronized, disk0 still copying.
+				return `{"return":[{"device":"mirror-vol1","len":1000,"offset":1000,"ready":true,"status":"ready","type":"mirror"},` +
+					`{"device":"mirror-drive-virtio-disk0","len":1000,"offset":10,"status":"running","type":"mirror"}]}`
+			case 2: // vol1's mirror failed outright.
+				return `{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":500,"status":"running","type":"mirror"}]}`
+			}
+			return `{"return":[{"device":"mirror-vol1","len":1000,"offset":1000,"ready":true,"status":"ready","type":"mirror"},` +
+				`{"device":"mirror-drive-virtio-disk0","len":1000,"offset":1000,"ready":true,"status":"ready","type":"mirror"}]}`
+		case "blockdev-backup":
+			backup.Store(true)
+		}
+		return `{"return":{}}`
+	})
+
+	ctx := context.Background()
+	for _, bt := range []blockTarget{{ID: "vol1", Node: "drive-vol1"}, {ID: "drive-virtio-disk0"}} {
+		if err := m.start(ctx, bt); err != nil {
+			t.Fatalf("start %s: %v", bt.ID, err)
+		}
+	}
+	if err := m.wait(ctx); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{
+		"block-dirty-bitmap-clear", "blockdev-del", "blockdev-add", "blockdev-mirror", "blockdev-add", "blockdev-backup",
+		"block-job-dismiss", "blockdev-del",
+	})
+
+	var mirrors []qmp.BlockdevMirrorArgs
+	var backupArgs qmp.BlockdevBackupArgs
+	for _, c := range commands {
+		switch c.Execute {
+		case "blockdev-mirror":
+			var a qmp.BlockdevMirrorArgs
+			decodeRecordedArgs(t, c, &a)
+			mirrors = append(mirrors, a)
+		case "blockdev-backup":
+			decodeRecordedArgs(t, c, &backupArgs)
+		}
+	}
+	if len(mirrors) != 2 || mirrors[1].Sync != "none" || mirrors[1].Target != "katamaran-nbd-vol1-1" {
+		t.Fatalf("blockdev-mirror calls = %+v, want a sync=none restart onto a fresh node", mirrors)
+	}
+	want := qmp.BlockdevBackupArgs{JobID: "resync-vol1", Device: "drive-vol1", Target: "katamaran-resync-vol1-1", Sync: "bitmap", Bitmap: mirrorBitmap, BitmapMode: "never"}
+	if backupArgs != want {
+		t.Errorf("blockdev-backup = %+v, want %+v", backupArgs, want)
+	}
+
+	m.teardown(ctx)
+	assertRecordedSubsequence(t, rec.Commands(), []string{"block-job-cancel", "block-job-cancel", "blockdev-del", "block-dirty-bitmap-remove"})
+}
+
+func TestMirrorSet_RetriesExhausted(t *testing.T) {
+	t.Parallel()
+	cfg := mirrorDest(t)
+	cfg.MirrorRetries = -1
+	m, _ := newTestMirrorSet(t, cfg, func(_ net.Conn, cmd recordedQMPCommand) string {
+		if cmd.Execute == "query-block-jobs" {
+			return `{"return":[{"device":"mirror-drive-virtio-disk0","len":1000,"offset":10,"status":"concluded","type":"mirror","error":"Connection reset by peer"}]}`
+		}
+		return `{"return":{}}`
+	})
+
+	ctx := context.Background()
+	if err := m.start(ctx, blockTarget{ID: "drive-virtio-disk0"}); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	err := m.wait(ctx)
+	if err == nil || !strings.Contains(err.Error(), "no retries left") || !strings.Contains(err.Error(), "Connection reset by peer") {
+		t.Fatalf("err = %v, want retries exhausted", err)
+	}
+}
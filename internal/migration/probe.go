@@ -0,0 +1,155 @@
+package migration
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// readQEMUCmdline returns the raw /proc/<pid>/cmdline of the source QEMU.
+// Var-not-func so tests can stub procfs.
+var readQEMUCmdline = func(pid int) ([]byte, error) {
+	return os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+}
+
+// RunProbe profiles a kata pod's VM without touching it, for destination
+// node scoring. It resolves the pod's sandbox like RunSource does in pod
+// mode, reads the QEMU command line, and (unless SharedStorage) sums the
+// virtual sizes of DriveIDs via query-block. The result is printed as a
+// single stdout marker the orchestrator scrapes from the probe Job's log:
+//
+//	KATAMARAN_VM_PROFILE cmdline_b64=<base64> [disk_bytes=<n> drives=<k>]
+//	  [blockers=<n> [blockers_b64=<base64>]]
+//
+// blockers counts the reasons QEMU would refuse a live migration
+// (query-migrate blocked-reasons, newline-joined in blockers_b64); the
+// orchestrator falls back to a cold migration on them.
+//
+// Unlike RunSource it removes no tc filters and issues no QMP command
+// that changes VM state. A disk-size or blocker query failure is logged
+// and its fields are omitted; a cmdline read failure is fatal.
+func RunProbe(ctx context.Context, cfg ProbeConfig) error {
+	if cfg.PodName == "" || cfg.PodNamespace == "" {
+		return fmt.Errorf("probe requires a pod name and namespace")
+	}
+	ip, err := lookupPodIP(ctx, cfg.PodNamespace, cfg.PodName)
+	if err != nil {
+		return fmt.Errorf("lookup pod IP: %w", err)
+	}
+	res, err := resolveSandbox(sandboxRoot, procImpl, ip)
+	if err != nil {
+		return fmt.Errorf("resolve sandbox: %w", err)
+	}
+	raw, err := readQEMUCmdline(res.PID)
+	if err != nil {
+		return fmt.Errorf("read QEMU cmdline: %w", err)
+	}
+	args := parseCmdlineBytes(raw)
+	if len(args) == 0 {
+		return fmt.Errorf("QEMU cmdline for pid %d is empty", res.PID)
+	}
+	marker := "KATAMARAN_VM_PROFILE cmdline_b64=" + base64.StdEncoding.EncodeToString([]byte(strings.Join(args, "\n")+"\n"))
+
+	socket := cfg.QMPSocket
+	if socket == "" {
+		socket = filepath.Join(sandboxRoot, res.Sandbox, "extra-monitor.sock")
+	}
+	if !cfg.SharedStorage {
+		total, found, err := queryDriveSizes(ctx, socket, cfg.DriveIDs)
+		if err != nil {
+			slog.Warn("Disk size query failed; profile omits disk_bytes", "qmp", socket, "error", err)
+		} else {
+			marker += fmt.Sprintf(" disk_bytes=%d drives=%d", total, found)
+		}
+	}
+	if blockers, err := probeMigrationBlockers(ctx, socket); err != nil {
+		slog.Warn("Migration blocker query failed; profile omits blockers", "qmp", socket, "error", err)
+	} else {
+		marker += fmt.Sprintf(" blockers=%d", len(blockers))
+		if len(blockers) > 0 {
+			marker += " blockers_b64=" + base64.StdEncoding.EncodeToString([]byte(strings.Join(blockers, "\n")))
+		}
+	}
+	markerln(ctx, marker)
+	slog.Info("VM profile emitted", "pod", cfg.PodNamespace+"/"+cfg.PodName, "qemu_pid", res.PID, "sandbox", res.Sandbox)
+	return nil
+}
+
+// probeMigrationBlockers returns query-migrate's blocked-reasons for the
+// VM behind socket.
+func probeMigrationBlockers(ctx context.Context, socket string) ([]string, error) {
+	client, err := qmp.NewClient(ctx, socket)
+	if err != nil {
+		return nil, fmt.Errorf("connect QMP: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+	return queryMigrationBlockers(ctx, client)
+}
+
+// queryDriveSizes sums the virtual sizes query-block reports for driveIDs
+// and returns how many of them were found. With --drive-id auto it sums
+// the writable local drives discoverDrives finds.
+func queryDriveSizes(ctx context.Context, socket string, driveIDs []string) (int64, int, error) {
+	client, err := qmp.NewClient(ctx, socket)
+	if err != nil {
+		return 0, 0, fmt.Errorf("connect QMP: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+	if isAutoDriveIDs(driveIDs) {
+		drives, err := discoverDrives(ctx, client)
+		if err != nil {
+			return 0, 0, err
+		}
+		var total int64
+		found := 0
+		for _, d := range drives {
+			if d.Class == driveLocal {
+				total += d.Size
+				found++
+			}
+		}
+		return total, found, nil
+	}
+	raw, err := client.Execute(ctx, "query-block", nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query-block: %w", err)
+	}
+	total, found, err := sumDriveSizes(raw, driveIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	if found < len(driveIDs) {
+		slog.Warn("Some drives were not reported by query-block", "wanted", len(driveIDs), "found", found)
+	}
+	return total, found, nil
+}
+
+// sumDriveSizes decodes a query-block response and sums the image virtual
+// sizes of the devices named in driveIDs.
+func sumDriveSizes(raw json.RawMessage, driveIDs []string) (int64, int, error) {
+	var blocks []qmp.BlockInfo
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return 0, 0, fmt.Errorf("decode query-block: %w", err)
+	}
+	want := make(map[string]bool, len(driveIDs))
+	for _, id := range driveIDs {
+		want[id] = true
+	}
+	var total int64
+	found := 0
+	for _, b := range blocks {
+		if !want[b.Device] || b.Inserted == nil {
+			continue
+		}
+		total += b.Inserted.Image.VirtualSize
+		found++
+	}
+	return total, found, nil
+}
@@ -0,0 +1,74 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSumDriveSizes(t *testing.T) {
+	raw := json.RawMessage(`[
+		{"device":"drive-virtio-disk0","inserted":{"file":"/var/lib/a.img","image":{"filename":"/var/lib/a.img","virtual-size":10737418240}}},
+		{"device":"drive-virtio-disk1","inserted":{"file":"/var/lib/b.img","image":{"filename":"/var/lib/b.img","virtual-size":1073741824}}},
+		{"device":"drive-cdrom0"},
+		{"device":"drive-other","inserted":{"image":{"virtual-size":99}}}
+	]`)
+	total, found, err := sumDriveSizes(raw, []string{"drive-virtio-disk0", "drive-virtio-disk1", "drive-cdrom0", "drive-missing"})
+	if err != nil {
+		t.Fatalf("sumDriveSizes: %v", err)
+	}
+	if total != 11811160064 || found != 2 {
+		t.Fatalf("sumDriveSizes = %d bytes over %d drives, want 11811160064 over 2", total, found)
+	}
+	if _, _, err := sumDriveSizes(json.RawMessage(`{}`), nil); err == nil {
+		t.Fatal("sumDriveSizes accepted a non-array response")
+	}
+}
+
+func TestRunProbe_RequiresPod(t *testing.T) {
+	if err := RunProbe(context.Background(), ProbeConfig{PodName: "vm-a"}); err == nil {
+		t.Fatal("RunProbe without a namespace succeeded")
+	}
+}
+
+func TestRunProbe_ResolvesSandboxAndReadsCmdline(t *testing.T) {
+	const (
+		sandboxUUID = "11111111-2222-3333-4444-555555555555"
+		fakeQEMUPID = 4242
+		podIP       = "10.244.1.7"
+	)
+	origLookup, origProc, origRoot, origRead := lookupPodIP, procImpl, sandboxRoot, readQEMUCmdline
+	t.Cleanup(func() {
+		lookupPodIP, procImpl, sandboxRoot, readQEMUCmdline = origLookup, origProc, origRoot, origRead
+	})
+	lookupPodIP = func(_ context.Context, _, _ string) (string, error) { return podIP, nil }
+	procImpl = fakeProcFS{
+		pids:       map[string]int{sandboxUUID: fakeQEMUPID},
+		netnsByPID: map[int][]string{fakeQEMUPID: {podIP}},
+	}
+	sandboxRoot = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sandboxRoot, sandboxUUID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var readPID int
+	readQEMUCmdline = func(pid int) ([]byte, error) {
+		readPID = pid
+		return []byte("qemu-system-x86_64\x00-m\x002048M\x00"), nil
+	}
+	if err := RunProbe(context.Background(), ProbeConfig{PodName: "vm-a", PodNamespace: "default", SharedStorage: true}); err != nil {
+		t.Fatalf("RunProbe: %v", err)
+	}
+	if readPID != fakeQEMUPID {
+		t.Fatalf("read cmdline of pid %d, want %d", readPID, fakeQEMUPID)
+	}
+
+	readQEMUCmdline = func(int) ([]byte, error) { return nil, errors.New("no such process") }
+	err := RunProbe(context.Background(), ProbeConfig{PodName: "vm-a", PodNamespace: "default", SharedStorage: true})
+	if err == nil || !strings.Contains(err.Error(), "read QEMU cmdline") {
+		t.Fatalf("RunProbe with unreadable cmdline = %v, want read error", err)
+	}
+}
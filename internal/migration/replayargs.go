@@ -0,0 +1,490 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// replayOption describes one QEMU option a replayed cmdline may carry.
+// takesValue options consume the next argv entry; check, when set,
+// vets that value, and checkIn does for values that name files of the
+// source sandbox.
+type replayOption struct {
+	takesValue bool
+	check      func(string) error
+	checkIn    func(replayScope, string) error
+}
+
+// replayScope is where a replayed cmdline may point QEMU at files of
+// its own sandbox: the source sandbox dir and its shared dir, both of
+// which transformCmdline maps to the destination's.
+type replayScope struct {
+	sandboxDir string
+	sharedDir  string
+}
+
+// replayOptions is the schema a captured cmdline must fit before the
+// destination execs it: the options Kata's QEMU driver emits, with the
+// values that name host files or spawn host processes narrowed down. The
+// cmdline comes from a source pod we do not fully trust, so anything else
+// (-plugin, -add-fd, bare disk images, bridge helpers, ifup scripts,
+// loader devices, chardev files) is refused rather than passed to QEMU.
+var replayOptions = map[string]replayOption{
+	"-name":           {takesValue: true},
+	"-uuid":           {takesValue: true},
+	"-machine":        {takesValue: true, check: checkReplayMachine},
+	"-M":              {takesValue: true, check: checkReplayMachine},
+	"-accel":          {takesValue: true},
+	"-cpu":            {takesValue: true},
+	"-smp":            {takesValue: true},
+	"-m":              {takesValue: true},
+	"-numa":           {takesValue: true},
+	"-overcommit":     {takesValue: true},
+	"-rtc":            {takesValue: true},
+	"-global":         {takesValue: true, check: checkReplayGlobal},
+	"-vga":            {takesValue: true},
+	"-display":        {takesValue: true, check: checkReplayDisplay},
+	"-serial":         {takesValue: true, check: checkNoneOrChardev},
+	"-parallel":       {takesValue: true, check: checkNoneOrChardev},
+	"-boot":           {takesValue: true},
+	"-sandbox":        {takesValue: true},
+	"-msg":            {takesValue: true},
+	"-smbios":         {takesValue: true, check: checkNoHostFile},
+	"-fw_cfg":         {takesValue: true, check: checkNoHostFile},
+	"-append":         {takesValue: true},
+	"-qmp":            {takesValue: true, checkIn: replayScope.checkMonitor},
+	"-monitor":        {takesValue: true, checkIn: replayScope.checkMonitor},
+	"-object":         {takesValue: true, check: checkReplayObject},
+	"-chardev":        {takesValue: true, checkIn: replayScope.checkChardev},
+	"-netdev":         {takesValue: true, check: checkReplayNetdev},
+	"-device":         {takesValue: true, check: checkReplayDevice},
+	"-drive":          {takesValue: true, checkIn: replayScope.checkDrive},
+	"-blockdev":       {takesValue: true, checkIn: replayScope.checkBlockdev},
+	"-fsdev":          {takesValue: true, checkIn: replayScope.checkFsdev},
+	"-kernel":         {takesValue: true, check: checkKataImagePath},
+	"-initrd":         {takesValue: true, check: checkKataImagePath},
+	"-bios":           {takesValue: true, check: checkReplayFirmware},
+	"-mem-path":       {takesValue: true, check: checkReplayMemPath},
+	"-pidfile":        {takesValue: true, checkIn: replayScope.checkSandboxPath},
+	"-D":              {takesValue: true, checkIn: replayScope.checkSandboxPath},
+	"-incoming":       {takesValue: true},
+	"-nodefaults":     {},
+	"-nographic":      {},
+	"-no-user-config": {},
+	"-no-reboot":      {},
+	"-no-shutdown":    {},
+	"-daemonize":      {},
+	"-enable-kvm":     {},
+	"-mem-prealloc":   {},
+	"-S":              {},
+}
+
+// replayObjectTypes are the -object types Kata creates.
+var replayObjectTypes = map[string]bool{
+	"memory-backend-file":  true,
+	"memory-backend-ram":   true,
+	"memory-backend-memfd": true,
+	"iothread":             true,
+	"rng-random":           true,
+	"rng-builtin":          true,
+	"thread-context":       true,
+	"sev-guest":            true,
+	"sev-snp-guest":        true,
+	"tdx-guest":            true,
+}
+
+// validateReplayArgs checks a captured cmdline (argv[0] included, and
+// ignored: the binary is pinned by spawnReplayedQEMU) against
+// replayOptions. Sandbox files must be in the sandbox dir
+// findSrcSandboxDir picks, the one transformCmdline remaps.
+func validateReplayArgs(args []string) error {
+	var scope replayScope
+	if dir, id := findSrcSandboxDir(args, sandboxRoot); dir != "" {
+		if id == "." || id == ".." {
+			return fmt.Errorf("replay cmdline: sandbox dir %q is not a sandbox", dir)
+		}
+		scope = replayScope{sandboxDir: dir, sharedDir: filepath.Join(kataSharedSandboxRoot, id, "shared")}
+	}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("replay cmdline: unexpected positional argument %q", a)
+		}
+		name := a
+		if strings.HasPrefix(a, "--") {
+			// QEMU accepts --opt for -opt.
+			name = a[1:]
+		}
+		opt, ok := replayOptions[name]
+		if !ok {
+			return fmt.Errorf("replay cmdline: option %s is not allowed", a)
+		}
+		if !opt.takesValue {
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("replay cmdline: option %s has no value", a)
+		}
+		i++
+		var err error
+		switch {
+		case opt.check != nil:
+			err = opt.check(args[i])
+		case opt.checkIn != nil:
+			err = opt.checkIn(scope, args[i])
+		}
+		if err != nil {
+			return fmt.Errorf("replay cmdline: %s %s: %w", a, args[i], err)
+		}
+	}
+	return nil
+}
+
+// parseOpts splits a QEMU option value into its keys. A leading field
+// without '=' is the value of implied (the option's type key, "" for
+// none), later bare fields are flags, and ",," is an escaped comma. A
+// key may appear only once: QEMU honours the last of several, which
+// would let a vetted first value hide the one QEMU uses. The JSON
+// spelling some options also accept is refused.
+func parseOpts(v, implied string) (map[string]string, error) {
+	if strings.HasPrefix(v, "{") {
+		return nil, errors.New("JSON option syntax is not allowed")
+	}
+	var fields []string
+	var cur strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch {
+		case v[i] == ',' && i+1 < len(v) && v[i+1] == ',':
+			cur.WriteByte(',')
+			i++
+		case v[i] == ',':
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(v[i])
+		}
+	}
+	fields = append(fields, cur.String())
+
+	opts := map[string]string{}
+	for i, f := range fields {
+		key, val, ok := strings.Cut(f, "=")
+		switch {
+		case ok:
+		case i == 0 && implied != "":
+			key, val = implied, f
+		default:
+			val = "on"
+		}
+		if _, dup := opts[key]; dup {
+			return nil, fmt.Errorf("%s is set more than once", key)
+		}
+		opts[key] = val
+	}
+	return opts, nil
+}
+
+func checkReplayObject(v string) error {
+	opts, err := parseOpts(v, "qom-type")
+	if err != nil {
+		return err
+	}
+	kind := opts["qom-type"]
+	if !replayObjectTypes[kind] {
+		return fmt.Errorf("object type %q is not allowed", kind)
+	}
+	switch kind {
+	case "memory-backend-file":
+		return checkReplayMemPath(opts["mem-path"])
+	case "rng-random":
+		if f := opts["filename"]; f != "" && f != "/dev/urandom" && f != "/dev/random" {
+			return fmt.Errorf("rng filename %q is not a random device", f)
+		}
+	}
+	return nil
+}
+
+// checkReplayMemPath allows guest RAM under /dev/shm or hugetlbfs, and
+// image files under the Kata roots; transformCmdline makes mem-path
+// backends writable, so any other file would become guest-writable.
+// The path must be clean, or /dev/shm/../.. would reach any file.
+func checkReplayMemPath(p string) error {
+	if filepath.Clean(p) != p {
+		return fmt.Errorf("mem-path %q is not a clean path", p)
+	}
+	for _, pref := range []string{"/dev/shm", "/dev/hugepages", "/hugepages"} {
+		if p == pref || strings.HasPrefix(p, pref+"/") {
+			return nil
+		}
+	}
+	return checkKataImagePath(p)
+}
+
+func checkKataImagePath(p string) error {
+	if !isAllowedNvdimmPath(p) {
+		return fmt.Errorf("path %q is outside the Kata image roots", p)
+	}
+	return nil
+}
+
+// checkReplayFirmware also allows the distro firmware directories
+// (OVMF, AAVMF, SeaBIOS) under /usr/share.
+func checkReplayFirmware(p string) error {
+	if filepath.IsAbs(p) && filepath.Clean(p) == p && strings.HasPrefix(p, "/usr/share/") {
+		return nil
+	}
+	return checkKataImagePath(p)
+}
+
+// checkSandboxPath keeps files QEMU opens (sockets, pidfile, debug log)
+// inside the source sandbox dir, which transformCmdline maps to the dest
+// one.
+func (s replayScope) checkSandboxPath(p string) error {
+	if s.sandboxDir == "" || filepath.Clean(p) != p || !strings.HasPrefix(p, s.sandboxDir+"/") {
+		return fmt.Errorf("path %q is outside the sandbox dir", p)
+	}
+	return nil
+}
+
+// checkImagePath allows drive images in the sandbox dir and under the
+// Kata image roots; /dev nodes and other host files are refused.
+func (s replayScope) checkImagePath(p string) error {
+	if !filepath.IsAbs(p) {
+		return fmt.Errorf("drive file %q is not an absolute path", p)
+	}
+	if filepath.Clean(p) != p {
+		return fmt.Errorf("drive file %q is not a clean path", p)
+	}
+	if s.checkSandboxPath(p) == nil || checkKataImagePath(p) == nil {
+		return nil
+	}
+	return fmt.Errorf("drive file %q is outside the sandbox dir and the Kata image roots", p)
+}
+
+// checkMonitor allows unix socket monitors in the sandbox dir (or on an
+// inherited fd, which transformCmdline drops) and chardev ones.
+func (s replayScope) checkMonitor(v string) error {
+	if v == "none" || strings.HasPrefix(v, "chardev:") {
+		return nil
+	}
+	sock, ok := strings.CutPrefix(v, "unix:")
+	if !ok {
+		return errors.New("monitor must be a unix socket or chardev")
+	}
+	sock, _, _ = strings.Cut(sock, ",")
+	if strings.HasPrefix(sock, "fd=") {
+		return nil
+	}
+	return s.checkSandboxPath(strings.TrimPrefix(sock, "path="))
+}
+
+func checkNoneOrChardev(v string) error {
+	if v == "none" || strings.HasPrefix(v, "chardev:") {
+		return nil
+	}
+	return errors.New("must be none or chardev:<id>")
+}
+
+// checkChardev allows unix sockets in the sandbox dir, null and pty
+// chardevs; a socket elsewhere would hand the guest a host service such
+// as the containerd socket. Logfiles are kept in the sandbox dir too.
+func (s replayScope) checkChardev(v string) error {
+	opts, err := parseOpts(v, "backend")
+	if err != nil {
+		return err
+	}
+	switch kind := opts["backend"]; kind {
+	case "socket":
+		for _, key := range []string{"host", "port", "fd"} {
+			if _, ok := opts[key]; ok {
+				return fmt.Errorf("socket %s= is not allowed, only unix socket paths", key)
+			}
+		}
+		if err := s.checkSandboxPath(opts["path"]); err != nil {
+			return err
+		}
+	case "null", "pty":
+	default:
+		return fmt.Errorf("chardev backend %q is not allowed", kind)
+	}
+	if p, ok := opts["logfile"]; ok {
+		return s.checkSandboxPath(p)
+	}
+	return nil
+}
+
+// checkReplayNetdev allows tap and vhost-user netdevs. A tap may not run
+// host scripts: transformCmdline adds script=no only when none is set.
+func checkReplayNetdev(v string) error {
+	opts, err := parseOpts(v, "type")
+	if err != nil {
+		return err
+	}
+	switch kind := opts["type"]; kind {
+	case "tap":
+		for _, key := range []string{"script", "downscript"} {
+			if s := opts[key]; s != "" && s != "no" {
+				return fmt.Errorf("tap %s=%s is not allowed", key, s)
+			}
+		}
+		if _, ok := opts["helper"]; ok {
+			return errors.New("tap helper is not allowed")
+		}
+		return nil
+	case "vhost-user":
+		return nil
+	default:
+		return fmt.Errorf("netdev type %q is not allowed", kind)
+	}
+}
+
+// checkReplayDevice refuses devices that read host files into the guest.
+func checkReplayDevice(v string) error {
+	opts, err := parseOpts(v, "driver")
+	if err != nil {
+		return err
+	}
+	if kind := opts["driver"]; kind == "loader" {
+		return fmt.Errorf("device %q is not allowed", kind)
+	}
+	if rom, ok := opts["romfile"]; ok {
+		return fmt.Errorf("romfile=%s is not allowed", rom)
+	}
+	return nil
+}
+
+// replayDriveDrivers are the block drivers a replayed drive may use:
+// local files and their formats, no network protocols.
+var replayDriveDrivers = map[string]bool{
+	"file": true, "host_device": true, "host_cdrom": true, "raw": true, "qcow2": true,
+}
+
+// checkReplayGlobal refuses romfile defaults, in both the
+// driver.prop=value and the driver=,property=,value= spelling.
+func checkReplayGlobal(v string) error {
+	if strings.Contains(v, "romfile") {
+		return errors.New("romfile is not allowed")
+	}
+	return nil
+}
+
+// checkBlockKeys vets the driver and file keys of a block option, at any
+// nesting level (file.driver=, backing.file.filename=, ...): drivers must
+// be replayDriveDrivers, so no protocol reaches beyond the node, and
+// image paths must pass checkImagePath. fileIsPath says whether file= is
+// a filename (-drive) rather than a node reference (-blockdev).
+func (s replayScope) checkBlockKeys(opts map[string]string, fileIsPath bool) error {
+	for key, val := range opts {
+		leaf := key[strings.LastIndex(key, ".")+1:]
+		switch {
+		case leaf == "driver" || key == "format":
+			if !replayDriveDrivers[val] {
+				return fmt.Errorf("block driver %q is not allowed", val)
+			}
+		case leaf == "filename" || (fileIsPath && leaf == "file"):
+			if err := s.checkImagePath(val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDrive requires a -drive image to be a local file of the sandbox
+// or the Kata image roots, opened with an explicit format: a probed
+// format would let a guest-written image header name a backing file.
+// Firmware pflash drives may also come from the distro firmware dirs
+// when read-only.
+func (s replayScope) checkDrive(v string) error {
+	opts, err := parseOpts(v, "")
+	if err != nil {
+		return err
+	}
+	file, hasFile := opts["file"]
+	if hasFile && opts["format"] == "" && opts["driver"] == "" {
+		return fmt.Errorf("drive file %q has no format", file)
+	}
+	if hasFile && opts["if"] == "pflash" && opts["readonly"] == "on" && checkReplayFirmware(file) == nil {
+		delete(opts, "file")
+	}
+	return s.checkBlockKeys(opts, true)
+}
+
+func (s replayScope) checkBlockdev(v string) error {
+	opts, err := parseOpts(v, "")
+	if err != nil {
+		return err
+	}
+	if opts["driver"] == "" {
+		return errors.New("blockdev has no driver")
+	}
+	return s.checkBlockKeys(opts, false)
+}
+
+// checkFsdev allows the local fsdev of Kata's shared dir only; any other
+// path would export that host directory (/ included) to the guest.
+func (s replayScope) checkFsdev(v string) error {
+	opts, err := parseOpts(v, "fsdriver")
+	if err != nil {
+		return err
+	}
+	if kind := opts["fsdriver"]; kind != "local" {
+		return fmt.Errorf("fsdev driver %q is not allowed", kind)
+	}
+	p := opts["path"]
+	if s.sharedDir == "" || filepath.Clean(p) != p || (p != s.sharedDir && !strings.HasPrefix(p, s.sharedDir+"/")) {
+		return fmt.Errorf("fsdev path %q is outside the sandbox's shared dir", p)
+	}
+	return nil
+}
+
+// checkNoHostFile refuses fw_cfg and smbios entries read from a host
+// file (file=) instead of given inline.
+func checkNoHostFile(v string) error {
+	opts, err := parseOpts(v, "")
+	if err != nil {
+		return err
+	}
+	if _, ok := opts["file"]; ok {
+		return errors.New("file entries are not allowed")
+	}
+	return nil
+}
+
+// checkReplayMachine vets the -machine properties naming host files:
+// dumpdtb writes one, the rest load one into the guest.
+func checkReplayMachine(v string) error {
+	opts, err := parseOpts(v, "type")
+	if err != nil {
+		return err
+	}
+	if _, ok := opts["dumpdtb"]; ok {
+		return errors.New("dumpdtb is not allowed")
+	}
+	for _, key := range []string{"kernel", "initrd", "dtb"} {
+		if p, ok := opts[key]; ok {
+			if err := checkKataImagePath(p); err != nil {
+				return err
+			}
+		}
+	}
+	if p, ok := opts["firmware"]; ok {
+		return checkReplayFirmware(p)
+	}
+	return nil
+}
+
+// checkReplayDisplay allows headless displays only; vnc and spice open
+// listeners on the dest node.
+func checkReplayDisplay(v string) error {
+	opts, err := parseOpts(v, "type")
+	if err != nil {
+		return err
+	}
+	if kind := opts["type"]; kind != "none" && kind != "egl-headless" {
+		return fmt.Errorf("display %q is not allowed", kind)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateReplayArgs_AcceptsKataCmdlines(t *testing.T) {
+	t.Parallel()
+	x86 := []string{
+		"/opt/kata/bin/qemu-system-x86_64",
+		"-name", "sandbox-abc",
+		"-uuid", "0b0f1f7c-1f35-4a8e-9c1a-9a5f3e0b7d2a",
+		"-machine", "q35,accel=kvm,nvdimm=on",
+		"-cpu", "host,pmu=off",
+		"-qmp", "unix:fd=3,server=on,wait=off",
+		"-m", "2048M,slots=10,maxmem=8G",
+		"-device", "pci-bridge,bus=pcie.0,id=pci-bridge-0,chassis_nr=1,shpc=off,addr=2",
+		"-device", "virtio-serial-pci,disable-modern=true,id=serial0",
+		"-device", "virtconsole,chardev=charconsole0,id=console0",
+		"-chardev", "socket,id=charconsole0,path=/run/vc/vm/abc/console.sock,server=on,wait=off",
+		"-device", "nvdimm,id=nv0,memdev=mem0,unarmed=on",
+		"-object", "memory-backend-file,id=mem0,mem-path=/opt/kata/share/kata-containers/kata-ubuntu-noble.image,size=268435456,readonly=on",
+		"-object", "rng-random,id=rng0,filename=/dev/urandom",
+		"-netdev", "tap,id=network-0,vhost=on,vhostfds=3,fds=4",
+		"-rtc", "base=utc,driftfix=slew,clock=host",
+		"-global", "kvm-pit.lost_tick_policy=discard",
+		"-vga", "none",
+		"-no-user-config",
+		"-nodefaults",
+		"-nographic",
+		"--no-reboot",
+		"-object", "memory-backend-file,id=dimm1,size=2048M,mem-path=/dev/shm,share=on",
+		"-numa", "node,memdev=dimm1",
+		"-kernel", "/opt/kata/share/kata-containers/vmlinux.container",
+		"-append", "tsc=reliable no_timer_check rcupdate.rcu_expedited=1",
+		"-pidfile", "/run/vc/vm/abc/pid",
+		"-fsdev", "local,id=extra-9p-kataShared,path=/run/kata-containers/shared/sandboxes/abc/shared,security_model=none,multidevs=remap",
+		"-device", "virtio-9p-pci,disable-modern=false,fsdev=extra-9p-kataShared,mount_tag=kataShared",
+		"-smp", "1,cores=1,threads=1,sockets=4,maxcpus=4",
+		"-daemonize",
+	}
+	for name, args := range map[string][]string{"x86": x86, "arm64": arm64Cmdline, "arm64 pmem": arm64PmemCmdline} {
+		if err := validateReplayArgs(args); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestValidateReplayArgs_Rejects(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-plugin", "file=/tmp/evil.so"}, "not allowed"},
+		{[]string{"-add-fd", "fd=3,set=1"}, "not allowed"},
+		{[]string{"/etc/shadow"}, "positional"},
+		{[]string{"-name"}, "no value"},
+		{[]string{"-netdev", "tap,id=n0,script=/tmp/x"}, "script"},
+		{[]string{"-netdev", "bridge,id=n0,br=br0"}, "netdev type"},
+		{[]string{"-chardev", "file,id=c0,path=/etc/cron.d/x"}, "backend"},
+		{[]string{"-object", "memory-backend-file,id=m,mem-path=/etc/passwd,size=4k"}, "Kata image roots"},
+		{[]string{"-object", "filter-dump,id=f,netdev=n0,file=/tmp/pcap"}, "object type"},
+		{[]string{"-device", "loader,file=/etc/shadow,addr=0x1000"}, "loader"},
+		{[]string{"-device", "virtio-net-pci,romfile=/etc/shadow"}, "romfile"},
+		{[]string{"-global", "virtio-net-pci.romfile=/etc/shadow"}, "romfile"},
+		{[]string{"-drive", "file=nbd://10.0.0.9:10809/x,format=raw,if=none"}, "absolute"},
+		{[]string{"-drive", "file=/dev/sda,format=raw,if=none"}, "outside the sandbox dir"},
+		{[]string{"-drive", "file=/opt/kata/share/x.img,,/../../../etc/shadow,format=raw"}, "clean"},
+		{[]string{"-drive", "file=/opt/kata/share/x.img,if=none"}, "no format"},
+		{[]string{"-drive", "file.driver=nbd,file.host=10.0.0.9,format=raw,if=none"}, "block driver"},
+		{[]string{"-drive", "format=raw,file=/opt/kata/share/x.img,file=/dev/sda"}, "more than once"},
+		{[]string{"-blockdev", "driver=nbd,node-name=n,server.type=inet,server.host=10.0.0.9"}, "block driver"},
+		{[]string{"-blockdev", "driver=raw,node-name=n,file.driver=http,file.url=http://10.0.0.9/x"}, "block driver"},
+		{[]string{"-blockdev", "driver=file,node-name=n,filename=/etc/shadow"}, "outside the sandbox dir"},
+		{[]string{"-blockdev", `{"driver":"nbd","node-name":"n"}`}, "JSON"},
+		{[]string{"-fsdev", "local,id=fs0,path=/,security_model=none"}, "shared dir"},
+		{[]string{"-fsdev", "local,id=fs0,path=/run/kata-containers/shared/sandboxes/abc/shared/../../..,security_model=none", "-pidfile", "/run/vc/vm/abc/pid"}, "shared dir"},
+		{[]string{"-chardev", "socket,id=c0,path=/run/containerd/containerd.sock"}, "outside the sandbox dir"},
+		{[]string{"-chardev", "socket,id=c0,host=10.0.0.9,port=22"}, "host="},
+		{[]string{"-chardev", "pty,id=c0,logfile=/etc/cron.d/x"}, "outside the sandbox dir"},
+		{[]string{"-chardev", "socket,id=c0,path=/run/vc/vm/abc/console.sock", "-pidfile", "/run/vc/vm/other/pid"}, "outside the sandbox dir"},
+		{[]string{"-qmp", "unix:/run/containerd/containerd.sock"}, "outside the sandbox dir"},
+		{[]string{"-object", "memory-backend-file,id=m,mem-path=/dev/shm/../../etc/shadow,size=4k"}, "clean"},
+		{[]string{"-device", `{"driver":"loader","file":"/etc/shadow"}`}, "JSON"},
+		{[]string{"-kernel", "/root/.ssh/id_rsa"}, "Kata image roots"},
+		{[]string{"-machine", "virt,dumpdtb=/etc/x"}, "dumpdtb"},
+		{[]string{"-fw_cfg", "name=opt/x,file=/etc/shadow"}, "file entries"},
+		{[]string{"-display", "vnc=:0"}, "display"},
+		{[]string{"-pidfile", "/etc/kata.pid"}, "outside"},
+	} {
+		args := append([]string{"/opt/kata/bin/qemu-system-x86_64"}, tc.args...)
+		err := validateReplayArgs(args)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("validateReplayArgs(%q) = %v, want error containing %q", tc.args, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// restoreDisksDir holds the restored VM's copies of the bundle's drive
+// images, under its sandbox directory. The bundle itself stays untouched,
+// so it can be restored again.
+const restoreDisksDir = "restore"
+
+// RunRestore brings back the VM of a checkpoint bundle written by
+// RunCheckpoint. It copies the bundle's drive images into the sandbox
+// directory, rewrites the captured cmdline and device plan to use the
+// copies, spawns QEMU through the same cmdline replay as a replay-mode
+// destination (-incoming defer), replays the hot-plugged devices, loads
+// the saved state with migrate-incoming from a file: URI, and resumes the
+// VM. It prints
+//
+//	KATAMARAN_RESTORED sandbox_id=<id> qmp=<socket> drives=<n>
+//
+// and leaves QEMU running, re-parented like a migrated destination VM.
+func RunRestore(ctx context.Context, cfg RestoreConfig) error {
+	if cfg.InDir == "" {
+		return errors.New("restore requires a checkpoint directory")
+	}
+	inDir, err := filepath.Abs(cfg.InDir)
+	if err != nil {
+		return fmt.Errorf("resolve checkpoint directory: %w", err)
+	}
+	manifest, err := readCheckpointManifest(inDir)
+	if err != nil {
+		return err
+	}
+	sandboxID := cmp.Or(cfg.SandboxID, destReplayDefaultSandbox)
+	if err := validateSandboxID(sandboxID); err != nil {
+		return err
+	}
+	start := time.Now()
+	slog.Info("Restoring checkpoint", "dir", inDir, "created_at", manifest.CreatedAt, "sandbox_id", sandboxID)
+
+	dcfg := DestConfig{
+		SandboxID:   sandboxID,
+		QEMUBinary:  cfg.QEMUBinary,
+		MigrationID: cfg.MigrationID,
+	}
+	if manifest.VMConfig != "" {
+		vmCfg, err := os.ReadFile(filepath.Join(inDir, manifest.VMConfig))
+		if err != nil {
+			return fmt.Errorf("read VMConfig: %w", err)
+		}
+		var parsed struct {
+			AgentConfig json.RawMessage `json:"AgentConfig"`
+		}
+		if err := json.Unmarshal(vmCfg, &parsed); err != nil {
+			return fmt.Errorf("decode VMConfig: %w", err)
+		}
+		dcfg.vmConfig, dcfg.agentConfig = vmCfg, parsed.AgentConfig
+	}
+
+	restoreDir := filepath.Join(sandboxRoot, sandboxID, restoreDisksDir)
+	if err := os.MkdirAll(restoreDir, 0o700); err != nil {
+		return fmt.Errorf("create restore directory: %w", err)
+	}
+	images := map[string]string{}
+	for _, d := range manifest.Drives {
+		dst := filepath.Join(restoreDir, d.ID+".raw")
+		if err := copySparseFile(filepath.Join(inDir, d.Image), dst); err != nil {
+			return fmt.Errorf("copy image of drive %s: %w", d.ID, err)
+		}
+		images[d.Source] = dst
+		slog.Info("Drive image restored", "drive_id", d.ID, "path", dst)
+	}
+
+	args, err := readCmdlineFile(filepath.Join(inDir, manifest.Cmdline))
+	if err != nil {
+		return err
+	}
+	var plan []deviceReplayStep
+	if manifest.Devices != "" {
+		if plan, err = readDevicePlanFile(filepath.Join(inDir, manifest.Devices)); err != nil {
+			return err
+		}
+	}
+	if plan, err = rewritePlanImages(plan, images); err != nil {
+		return err
+	}
+	dcfg.ReplayCmdlineFile = filepath.Join(restoreDir, checkpointCmdlineFile)
+	if err := os.WriteFile(dcfg.ReplayCmdlineFile, []byte(strings.Join(rewriteCmdlineImages(args, images), "\n")+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write restore cmdline: %w", err)
+	}
+	if err := spawnReplayedQEMU(ctx, &dcfg); err != nil {
+		return fmt.Errorf("spawn QEMU: %w", err)
+	}
+
+	client, err := qmp.NewClient(ctx, dcfg.QMPSocket)
+	if err != nil {
+		return fmt.Errorf("connecting to restored QMP: %w", err)
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			slog.Warn("Failed to close QMP client", "error", err)
+		}
+	}()
+	if len(plan) > 0 {
+		slog.Info("Replaying hot-plugged devices", "steps", len(plan))
+		if err := replayDevices(ctx, client, plan, &dcfg); err != nil {
+			return fmt.Errorf("replaying devices: %w", err)
+		}
+	}
+	uri := "file:" + filepath.Join(inDir, manifest.State)
+	if _, err := client.Execute(ctx, "migrate-incoming", qmp.MigrateArgs{URI: uri}); err != nil {
+		return fmt.Errorf("loading VM state from %s: %w", uri, err)
+	}
+	if _, err := waitForFileMigration(ctx, client); err != nil {
+		return fmt.Errorf("loading VM state: %w", err)
+	}
+	// The checkpointed VM was paused when its state was saved, so the
+	// loaded VM stays paused until told otherwise.
+	if _, err := client.Execute(ctx, "cont", nil); err != nil {
+		return fmt.Errorf("resuming VM: %w", err)
+	}
+	markerf(ctx, "KATAMARAN_RESTORED sandbox_id=%s qmp=%s drives=%d\n", sandboxID, dcfg.QMPSocket, len(manifest.Drives))
+	slog.Info("Checkpoint restored", "sandbox_id", sandboxID, "elapsed", time.Since(start).Round(time.Millisecond))
+
+	garpCtx, garpCancel := cleanupCtx(ctx)
+	defer garpCancel()
+	if _, err := client.Execute(garpCtx, "announce-self", qmp.AnnounceSelfArgs{
+		Initial: garpInitialMS,
+		Max:     garpMaxMS,
+		Rounds:  garpRounds,
+		Step:    garpStepMS,
+	}); err != nil {
+		slog.Warn("GARP announce-self failed", "error", err)
+	}
+	writeMigrationMeta(ctx, dcfg, client)
+	surviveContainerExit(dcfg.QMPSocket)
+	return nil
+}
+
+// rewriteCmdlineImages points the drive images in args at their restored
+// copies. Restored images are regular files, so a host_device protocol
+// in the same argument becomes file.
+func rewriteCmdlineImages(args []string, images map[string]string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		for src, dst := range images {
+			if replaced, ok := replacePath(a, src, dst); ok {
+				a = strings.ReplaceAll(replaced, "host_device", "file")
+			}
+		}
+		out[i] = a
+	}
+	return out
+}
+
+// replacePath replaces path in s where it appears whole: followed by the
+// end of s, a comma or a quote, so /dev/vdb does not match /dev/vdb1.
+func replacePath(s, path, repl string) (string, bool) {
+	if path == "" {
+		return s, false
+	}
+	var b strings.Builder
+	found := false
+	for {
+		i := strings.Index(s, path)
+		if i < 0 {
+			break
+		}
+		end := i + len(path)
+		if end == len(s) || s[end] == ',' || s[end] == '"' {
+			b.WriteString(s[:i])
+			b.WriteString(repl)
+			found = true
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String(), found
+}
+
+// rewritePlanImages points the blockdev-add steps of plan at the restored
+// image copies.
+func rewritePlanImages(plan []deviceReplayStep, images map[string]string) ([]deviceReplayStep, error) {
+	for i, step := range plan {
+		if step.Execute != "blockdev-add" {
+			continue
+		}
+		var args map[string]any
+		if err := json.Unmarshal(step.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("decode device plan step %d: %w", i, err)
+		}
+		if !rewriteNodeImages(args, images) {
+			continue
+		}
+		raw, err := json.Marshal(args)
+		if err != nil {
+			return nil, fmt.Errorf("encode device plan step %d: %w", i, err)
+		}
+		plan[i].Arguments = raw
+	}
+	return plan, nil
+}
+
+// rewriteNodeImages rewrites the filename of node, or of the protocol
+// node under it, when it is a restored image.
+func rewriteNodeImages(node map[string]any, images map[string]string) bool {
+	if file, ok := node["file"].(map[string]any); ok {
+		return rewriteNodeImages(file, images)
+	}
+	name, _ := node["filename"].(string)
+	dst, ok := images[name]
+	if !ok {
+		return false
+	}
+	node["filename"] = dst
+	if node["driver"] == "host_device" {
+		node["driver"] = "file"
+	}
+	return true
+}
+
+// copySparseFile copies src to a new file dst, leaving holes where src
+// has all-zero blocks.
+func copySparseFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 1<<20)
+	var size int64
+	for {
+		n, rerr := io.ReadFull(in, buf)
+		if n > 0 {
+			var werr error
+			if isZero(buf[:n]) {
+				_, werr = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, werr = out.Write(buf[:n])
+			}
+			if werr != nil {
+				_ = out.Close()
+				return werr
+			}
+			size += int64(n)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			_ = out.Close()
+			return rerr
+		}
+	}
+	if err := out.Truncate(size); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
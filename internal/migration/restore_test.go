@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReplacePath(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		s, want string
+		found   bool
+	}{
+		{"file=/dev/vdb,if=none", "file=/r/d0.raw,if=none", true},
+		{"/dev/vdb", "/r/d0.raw", true},
+		{`{"filename":"/dev/vdb"}`, `{"filename":"/r/d0.raw"}`, true},
+		{"file=/dev/vdb1,if=none", "file=/dev/vdb1,if=none", false},
+		{"file=/dev/vdb1,backing=/dev/vdb", "file=/dev/vdb1,backing=/r/d0.raw", true},
+	} {
+		got, found := replacePath(tc.s, "/dev/vdb", "/r/d0.raw")
+		if got != tc.want || found != tc.found {
+			t.Errorf("replacePath(%q) = %q, %v; want %q, %v", tc.s, got, found, tc.want, tc.found)
+		}
+	}
+}
+
+func TestRewriteCmdlineImages(t *testing.T) {
+	t.Parallel()
+	args := []string{
+		"qemu-system-x86_64",
+		`-blockdev`, `{"driver":"host_device","filename":"/dev/vdb","node-name":"disk0"}`,
+		"-drive", "file=/dev/vdc,if=none,id=drive1",
+		"-drive", "file=/dev/vdd,if=none,id=drive2",
+	}
+	got := rewriteCmdlineImages(args, map[string]string{"/dev/vdb": "/r/a.raw", "/dev/vdc": "/r/b.raw"})
+	want := []string{
+		"qemu-system-x86_64",
+		`-blockdev`, `{"driver":"file","filename":"/r/a.raw","node-name":"disk0"}`,
+		"-drive", "file=/r/b.raw,if=none,id=drive1",
+		"-drive", "file=/dev/vdd,if=none,id=drive2",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("rewriteCmdlineImages =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRewritePlanImages(t *testing.T) {
+	t.Parallel()
+	plan := []deviceReplayStep{
+		{Execute: "blockdev-add", Arguments: json.RawMessage(`{"driver":"raw","node-name":"n0","file":{"driver":"host_device","filename":"/dev/vdb"}}`)},
+		{Execute: "blockdev-add", Arguments: json.RawMessage(`{"driver":"file","node-name":"n1","filename":"/other.img"}`)},
+		{Execute: "device_add", Arguments: json.RawMessage(`{"driver":"virtio-blk-pci","drive":"n0"}`)},
+	}
+	got, err := rewritePlanImages(plan, map[string]string{"/dev/vdb": "/r/a.raw"})
+	if err != nil {
+		t.Fatalf("rewritePlanImages: %v", err)
+	}
+	var node struct {
+		File struct{ Driver, Filename string } `json:"file"`
+	}
+	if err := json.Unmarshal(got[0].Arguments, &node); err != nil {
+		t.Fatal(err)
+	}
+	if node.File.Driver != "file" || node.File.Filename != "/r/a.raw" {
+		t.Errorf("rewritten node = %s", got[0].Arguments)
+	}
+	if string(got[1].Arguments) != `{"driver":"file","node-name":"n1","filename":"/other.img"}` {
+		t.Errorf("unrelated node rewritten: %s", got[1].Arguments)
+	}
+}
+
+func TestCopySparseFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	data := make([]byte, 3<<20+17)
+	copy(data[1<<20:], "payload")
+	data[len(data)-1] = 1
+	if err := os.WriteFile(src, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "dst")
+	if err := copySparseFile(src, dst); err != nil {
+		t.Fatalf("copySparseFile: %v", err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("copy differs: %d bytes, want %d", len(got), len(data))
+	}
+
+	// A trailing zero block must still count towards the size.
+	if err := os.WriteFile(src, make([]byte, 5000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := copySparseFile(src, dst); err != nil {
+		t.Fatalf("copySparseFile: %v", err)
+	}
+	if fi, err := os.Stat(dst); err != nil || fi.Size() != 5000 {
+		t.Fatalf("zero copy = %v, %v; want 5000 bytes", fi, err)
+	}
+}
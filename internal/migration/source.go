@@ -2,7 +2,6 @@ package migration
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -41,17 +40,36 @@ var (
 // The IP tunnel is torn down inline after migration completes.
 //
 // Sequentially it:
-//   - Starts a drive-mirror job to synchronize storage via NBD (unless shared-storage mode)
-//   - Waits for drive-mirror to reach "ready" (full sync)
-//   - Configures migration capabilities (auto-converge, multifd) and parameters
+//   - With --compression auto, measures the link to the destination and
+//     publishes the chosen compression (KATAMARAN_COMPRESSION)
+//   - Starts a drive-mirror (or blockdev-mirror for -blockdev node names) job
+//     per drive to synchronize storage via NBD (unless shared-storage mode)
+//   - Waits for the mirrors to reach "ready" (full sync), resuming or
+//     restarting mirrors that hit target errors (--mirror-retries)
+//   - Optionally verifies extent hashes against the destination (--verify-storage)
+//   - Optionally inflates the memory balloon toward the guest's usage (--balloon),
+//     deflating it again if the migration fails
+//   - Configures migration capabilities (auto-converge unless tuning adaptively,
+//     multifd, xbzrle) and
+//     parameters, including the stream compression
 //   - Optionally measures RTT for auto-downtime calculation
+//   - Runs the pre-cutover hooks (cfg.PreCutoverHooks)
 //   - Starts RAM migration via QMP migrate command
 //   - Polls for the STOP event (VM pause), checking for migration failures
+//     and, with --adaptive-tuning, raising the bandwidth cap and downtime
+//     limit when the transfer stalls (see tuning.go)
 //   - Creates an IP tunnel to forward in-flight traffic to the destination
 //   - Waits for migration to complete (query-migrate polling)
 //   - If migration failed, cancels it via QMP migrate-cancel
-//   - Cancels the drive-mirror block job (disarms the deferred cleanup)
+//   - Cancels the mirror block jobs and deletes NBD target nodes and bitmaps (disarms the deferred cleanup)
 //   - Tears down the IP tunnel after a CNI convergence delay (immediately on failure)
+//
+// With cfg.Cold the VM is instead paused up front and migrated cold (see
+// cold.go): disks copied, state streamed or left behind, no tunnel.
+//
+// The VM steps go through the Hypervisor for cfg.Hypervisor; the list
+// above is the QEMU one. Cloud Hypervisor needs shared storage and has
+// no pause event, so its tunnel is only up after the transfer.
 func RunSource(ctx context.Context, cfg SourceConfig) error {
 	var resolvedQEMUPID int
 	if cfg.PodName != "" {
@@ -88,6 +106,15 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 		}
 	}
 
+	hypervisor, socket, err := resolveHypervisor(cfg.Hypervisor, cfg.QMPSocket)
+	if err != nil {
+		return err
+	}
+	cfg.Hypervisor, cfg.QMPSocket = hypervisor, socket
+	if err := checkSourceHypervisor(cfg.Hypervisor, cfg); err != nil {
+		return err
+	}
+
 	// Capture the QEMU cmdline for the dest job to replay with -incoming defer.
 	// Done after pod resolution (when the QEMU PID is known) and before any
 	// QMP work. File-based replay paths stage this file on the dest node; the
@@ -100,9 +127,15 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 		if err := captureSourceCmdline(resolvedQEMUPID, cfg.EmitCmdlineTo); err != nil {
 			return fmt.Errorf("capture source QEMU cmdline: %w", err)
 		}
+		// Hot-plugged devices are not in the argv; ship the replay plan
+		// alongside it. Printed before the cmdline markers so a dest that
+		// has found KATAMARAN_CMDLINE_B64 also finds KATAMARAN_DEVICES_B64.
+		if err := emitDevicePlan(ctx, cfg.QMPSocket, cfg.EmitCmdlineTo, cfg.HandoffKey); err != nil {
+			return fmt.Errorf("capture source device inventory: %w", err)
+		}
 		// Marker line consumed by deploy/migrate.sh — print on stdout so it
 		// survives log re-formatting (slog writes to stderr in this binary).
-		fmt.Printf("KATAMARAN_CMDLINE_AT=%s\n", cfg.EmitCmdlineTo)
+		markerf(ctx, "KATAMARAN_CMDLINE_AT=%s\n", cfg.EmitCmdlineTo)
 		// Also emit the cmdline file's contents as a single base64 line on
 		// stdout. The dest binary scrapes the source pod's log via the
 		// apiserver (--replay-cmdline-from-pod), avoiding a separate file
@@ -110,7 +143,7 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 		if cmdlineBytes, err := os.ReadFile(cfg.EmitCmdlineTo); err != nil {
 			slog.Warn("Failed to read captured cmdline for KATAMARAN_CMDLINE_B64; in-pod-log replay will fail", "error", err, "path", cfg.EmitCmdlineTo)
 		} else {
-			fmt.Printf("KATAMARAN_CMDLINE_B64=%s\n", base64.StdEncoding.EncodeToString(cmdlineBytes))
+			markerln(ctx, formatMarker(cfg.HandoffKey, cmdlineMarker, cmdlineBytes))
 		}
 		slog.Info("Captured source QEMU cmdline", "path", cfg.EmitCmdlineTo, "qemu_pid", resolvedQEMUPID)
 	}
@@ -120,7 +153,22 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 	// Done regardless of cmdline replay mode — any migration benefits
 	// from having VMConfig available for adoption.
 	if resolvedQEMUPID != 0 {
-		emitVMConfig(resolvedQEMUPID)
+		emitVMConfig(ctx, resolvedQEMUPID, cfg.HandoffKey)
+	}
+
+	// --drive-id auto: discover the drives now and publish them, before
+	// waiting for the dest, which needs the list to open its NBD exports.
+	if isAutoDriveIDs(cfg.DriveIDs) && cfg.Hypervisor == HypervisorQEMU {
+		local, drives, err := resolveAutoDrives(ctx, cfg.QMPSocket, cfg.SharedStorage)
+		if err != nil {
+			return fmt.Errorf("discovering drives: %w", err)
+		}
+		markerln(ctx, formatDrivesMarker(drives))
+		cfg.DriveIDs = local
+		if len(local) == 0 && !cfg.SharedStorage {
+			slog.Info("No writable local drives found; skipping drive-mirror")
+			cfg.SharedStorage = true
+		}
 	}
 
 	cfg.DestIP = cfg.DestIP.Unmap()
@@ -143,6 +191,21 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 	if cfg.MultifdChannels < 0 {
 		return fmt.Errorf("multifd channels must be non-negative, got %d", cfg.MultifdChannels)
 	}
+	if cfg.Cold {
+		// A cold migration's state moves uncompressed.
+		cfg.Compression, cfg.CompressionLevel = CompressionNone, 0
+	} else if cfg.Compression == "" {
+		cfg.Compression = CompressionNone
+	}
+	if cfg.MaxDowntimeMS < 0 || cfg.MaxDowntimeMS > 60000 {
+		return fmt.Errorf("max downtime must be between 0 and 60000 ms, got %d", cfg.MaxDowntimeMS)
+	}
+	if cfg.MaxBandwidthMBps < 0 {
+		return fmt.Errorf("max bandwidth must be non-negative, got %d", cfg.MaxBandwidthMBps)
+	}
+	if err := CheckCompression(cfg.Compression, cfg.CompressionLevel, cfg.MultifdChannels); err != nil {
+		return err
+	}
 	if !cfg.SharedStorage {
 		if err := validateDriveIDs(cfg.DriveIDs); err != nil {
 			return fmt.Errorf("validating drive IDs: %w", err)
@@ -152,16 +215,47 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 	ctx, cancel := context.WithTimeout(ctx, migrationTimeout+storageSyncTimeout)
 	defer cancel()
 
+	// Cold mode decides before the destination starts whether it sends
+	// the VM state: a replaying destination spawns its QEMU accordingly.
+	var coldState string
+	if cfg.Cold {
+		var err error
+		if coldState, err = planColdMigration(ctx, cfg.QMPSocket); err != nil {
+			return err
+		}
+	}
+
+	// Like the cold plan, auto compression is settled before waiting for
+	// the destination: a destination with --compression auto configures
+	// its side from our marker before it reports ready.
+	if cfg.Compression == CompressionAuto {
+		planCompression(ctx, &cfg)
+	}
+	xbzrleDisablesMultifd(cfg.Compression, &cfg.MultifdChannels)
+
 	// In replay-cmdline mode the dest job starts AFTER us (the orchestrator
 	// needs our captured cmdline to spawn dest QEMU), so the first migrate
 	// connection we make would otherwise race the dest pod's startup.
 	// We can't fast-fail-probe dest:4444: dest QEMU's incoming-migration
 	// listener peeks for the migration magic on every connection, sees EOF
 	// from a probe, and dies with "Failed to peek at channel". Instead,
-	// sleep a fixed conservative window and let the QMP `migrate` command
-	// be the first connection.
-	if cfg.EmitCmdlineTo != "" {
-		slog.Info("Waiting (sleep) for dest QEMU to come up",
+	// wait for the dest's KATAMARAN_DEST_READY marker, or — when no dest
+	// Job was named — sleep a fixed conservative window, and let the QMP
+	// `migrate` command be the first connection.
+	if cfg.DestReadyFrom != "" {
+		fields, err := waitForDestReady(ctx, cfg.DestReadyFrom, cfg.DestReadyTimeout)
+		if err != nil {
+			return fmt.Errorf("waiting for destination: %w", err)
+		}
+		if coldState == ColdStateBoot {
+			// Nothing connects to the migration listener.
+			delete(fields, "migration_port")
+		}
+		if err := checkDestPorts(cfg, fields); err != nil {
+			return err
+		}
+	} else if cfg.EmitCmdlineTo != "" {
+		slog.Warn("Waiting (sleep) for dest QEMU to come up; pass --dest-ready-from-job to start as soon as it is ready",
 			"sleep", destReplaySleep)
 		select {
 		case <-time.After(destReplaySleep):
@@ -170,6 +264,13 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 		}
 	}
 
+	if cfg.Cold {
+		if err := runHooks(ctx, HookPhasePreCutover, cfg.PreCutoverHooks, sourceHookTarget(cfg)); err != nil {
+			return err
+		}
+		return runCold(ctx, cfg, coldState)
+	}
+
 	migrationStart := time.Now()
 
 	slog.Info("Starting live migration",
@@ -179,82 +280,75 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 		"tunnel_mode", string(cfg.TunnelMode),
 		"shared_storage", cfg.SharedStorage,
 		"multifd_channels", cfg.MultifdChannels,
+		"compression", cfg.Compression,
 		"downtime_limit_ms", cfg.DowntimeLimitMS,
 		"auto_downtime", cfg.AutoDowntime,
+		"adaptive_tuning", cfg.AdaptiveTuning,
 	)
 
-	client, err := qmp.NewClient(ctx, cfg.QMPSocket)
+	hv, err := openHypervisor(ctx, cfg.Hypervisor, cfg.QMPSocket)
 	if err != nil {
-		return fmt.Errorf("connecting to source QMP: %w", err)
+		return fmt.Errorf("connecting to source %s: %w", cfg.Hypervisor, err)
 	}
 	defer func() {
-		if err := client.Close(); err != nil {
-			slog.Warn("Failed to close QMP client", "error", err)
+		if err := hv.Close(); err != nil {
+			slog.Warn("Failed to close hypervisor client", "error", err)
 		}
 	}()
+	if _, ok := hv.(*chHypervisor); ok && cfg.Compression != CompressionNone {
+		slog.Info("Cloud Hypervisor does not compress the migration stream; ignoring compression", "compression", cfg.Compression)
+		cfg.Compression = CompressionNone
+	}
+	if _, ok := hv.(*chHypervisor); ok && cfg.AdaptiveTuning {
+		slog.Info("Cloud Hypervisor has no migration parameters to tune; ignoring adaptive tuning")
+		cfg.AdaptiveTuning = false
+	}
 
-	var mirrorJobIDs []string
 	downtimeLimitMS := cfg.DowntimeLimitMS
 
 	if !cfg.SharedStorage {
-		for _, driveID := range cfg.DriveIDs {
-			jobID := "mirror-" + driveID
-			targetNBD := fmt.Sprintf("nbd:%s:%s:exportname=%s", formatQEMUHost(cfg.DestIP), nbdPort, driveID)
-			slog.Info("Initiating storage mirror (drive-mirror)", "target", targetNBD, "drive_id", driveID)
-			if _, err = client.Execute(ctx, "drive-mirror", qmp.DriveMirrorArgs{
-				Device: driveID,
-				Target: targetNBD,
-				Sync:   "full",
-				Mode:   "existing",
-				JobID:  jobID,
-			}); err != nil {
-				slog.Error("Drive-mirror failed", "target", targetNBD, "drive_id", driveID, "error", err)
-				return fmt.Errorf("starting drive-mirror for %s: %w", driveID, err)
-			}
-			mirrorJobIDs = append(mirrorJobIDs, jobID)
+		defer func() {
+			cctx, ccancel := cleanupCtx(ctx)
+			defer ccancel()
+			hv.StopStorageSync(cctx)
+		}()
+		if err := hv.SyncStorage(ctx, cfg); err != nil {
+			return err
 		}
+	} else {
+		slog.Info("Shared storage mode: skipping drive-mirror")
+	}
 
-		defer func() {
-			if len(mirrorJobIDs) > 0 {
+	// Shrink the guest last before the transfer, so it runs with less
+	// memory for as short a time as possible.
+	var memorySaved int64
+	migrated := false
+	if cfg.Balloon {
+		saved, err := hv.ShrinkMemory(ctx)
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Info("Skipping balloon reduction", "reason", err)
+		} else if err != nil {
+			slog.Warn("Balloon reduction failed; migrating the VM's full memory", "error", err)
+		}
+		if saved > 0 {
+			memorySaved = saved
+			defer func() {
+				if migrated {
+					return
+				}
 				cctx, ccancel := cleanupCtx(ctx)
 				defer ccancel()
-				for _, jid := range mirrorJobIDs {
-					if _, cancelErr := client.Execute(cctx, "block-job-cancel", qmp.BlockJobCancelArgs{
-						Device: jid,
-						Force:  true,
-					}); cancelErr != nil {
-						slog.Warn("Deferred block job cancel failed", "job_id", jid, "error", cancelErr)
-					}
+				if err := hv.RestoreMemory(cctx); err != nil {
+					slog.Warn("Failed to deflate balloon after failed migration", "error", err)
 				}
-			}
-		}()
-
-		slog.Info("Waiting for storage mirrors to synchronize", "drives", len(mirrorJobIDs))
-		storageSyncStart := time.Now()
-		if err = waitForStorageSync(ctx, client, mirrorJobIDs...); err != nil {
-			return fmt.Errorf("storage sync failed after %s: %w", time.Since(storageSyncStart).Round(time.Millisecond), err)
+			}()
 		}
-		slog.Info("All storage mirrors synchronized", "drives", len(mirrorJobIDs), "elapsed", time.Since(storageSyncStart).Round(time.Millisecond))
-	} else {
-		slog.Info("Shared storage mode: skipping drive-mirror")
 	}
 
 	slog.Info("Configuring RAM migration")
-	// Always enable auto-converge: if the guest's dirty page rate exceeds the
-	// transfer rate, QEMU will throttle guest vCPUs to ensure migration converges.
-	// Without this, migration could run indefinitely on write-heavy workloads.
-	caps := []qmp.MigrationCapability{
-		{Capability: "auto-converge", State: true},
-	}
 	if cfg.MultifdChannels > 0 {
-		caps = append(caps, qmp.MigrationCapability{Capability: "multifd", State: true})
 		slog.Info("Multifd enabled", "channels", cfg.MultifdChannels)
 	}
-	if _, err = client.Execute(ctx, "migrate-set-capabilities", qmp.MigrateSetCapabilitiesArgs{
-		Capabilities: caps,
-	}); err != nil {
-		return fmt.Errorf("setting migration capabilities: %w", err)
-	}
 
 	var rttMS int64
 	if cfg.AutoDowntime {
@@ -276,78 +370,26 @@ func RunSource(ctx context.Context, cfg SourceConfig) error {
 	// source actually programmed into QEMU (post auto-calc, post fallback)
 	// so the orchestrator can stamp it on the StatusUpdate / Migration CR
 	// before the cutover even starts.
-	fmt.Printf("KATAMARAN_DOWNTIME_LIMIT applied_ms=%d rtt_ms=%d auto=%t\n",
+	markerf(ctx, "KATAMARAN_DOWNTIME_LIMIT applied_ms=%d rtt_ms=%d auto=%t\n",
 		downtimeLimitMS, rttMS, cfg.AutoDowntime)
 
-	if _, err = client.Execute(ctx, "migrate-set-parameters", qmp.MigrateSetParametersArgs{
-		DowntimeLimit:   int64(downtimeLimitMS),
-		MaxBandwidth:    maxBandwidth,
-		MultifdChannels: int64(cfg.MultifdChannels),
-	}); err != nil {
-		return fmt.Errorf("setting migration parameters: %w", err)
+	// Quiesce hooks run last, with the disks in sync: the workload stays
+	// quiesced only for the RAM transfer.
+	if err := runHooks(ctx, HookPhasePreCutover, cfg.PreCutoverHooks, sourceHookTarget(cfg)); err != nil {
+		return err
 	}
-
-	uri := fmt.Sprintf("tcp:%s:%s", formatQEMUHost(cfg.DestIP), ramMigrationPort)
-	if _, err = client.Execute(ctx, "migrate", qmp.MigrateArgs{URI: uri}); err != nil {
-		return fmt.Errorf("starting RAM migration to %s: %w", uri, err)
+	if err := hv.StartMigration(ctx, cfg, downtimeLimitMS); err != nil {
+		return err
 	}
-	slog.Info("RAM migration started. Waiting for VM to pause (STOP event)")
-
-	// Wait for the STOP event (downtime window begins).
-	// We poll migration status sequentially in the same loop rather than using a
-	// separate goroutine for WaitForEvent vs query-migrate. This prevents QMP
-	// socket data races and ensures we detect silent migration failures.
-	var lastLoggedStatus qmp.MigrateStatus
-	var lastLoggedRemaining int64
-	var queryErrors int
-stopLoop:
-	for {
-		err = client.WaitForEvent(ctx, "STOP", migrationPollInterval)
-		if err == nil {
-			break // Success: VM stopped.
-		}
-
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			// Check if the background migration process failed.
-			raw, qerr := client.Execute(ctx, "query-migrate", nil)
-			if qerr != nil {
-				queryErrors++
-				logTransientQueryError(ctx, "Transient query-migrate error during STOP polling", qerr, queryErrors)
-				continue
-			}
-			var info qmp.MigrateInfo
-			if err := json.Unmarshal(raw, &info); err != nil {
-				queryErrors++
-				logTransientQueryError(ctx, "Failed to parse query-migrate response", err, queryErrors)
-				continue
-			}
-			queryErrors = 0
-			// Log only on status change or significant progress (remaining bytes halved).
-			statusChanged := info.Status != lastLoggedStatus
-			remainingChanged := lastLoggedRemaining > 0 && info.RAM.Remaining <= lastLoggedRemaining/2
-			if statusChanged || remainingChanged {
-				var pct float64
-				if info.RAM.Total > 0 {
-					pct = float64(info.RAM.Transferred) / float64(info.RAM.Total) * 100
-				}
-				slog.Info("Migration progress", "status", info.Status, "progress_pct", pct, "ram_transferred", info.RAM.Transferred, "ram_total", info.RAM.Total, "ram_remaining", info.RAM.Remaining)
-				lastLoggedStatus = info.Status
-				lastLoggedRemaining = info.RAM.Remaining
-			}
-			if terminal, termErr := migrationTerminalError(info.Status, info.ErrorDesc); terminal {
-				if termErr != nil {
-					return fmt.Errorf("during STOP polling: %w", termErr)
-				}
-				slog.Warn("Migration completed without explicit STOP event", "status", info.Status)
-				break stopLoop
-			}
-			continue
-		}
-		return fmt.Errorf("unexpected error waiting for STOP event: %w", err)
+	slog.Info("RAM migration started. Waiting for VM to pause")
+	if err := hv.WaitPaused(ctx); err != nil {
+		return err
 	}
 
 	slog.Info("VM paused. Redirecting in-flight packets to destination")
+	// Cutover-start marker: the orchestrator scrapes it so workload
+	// verifiers can line packet gaps up against the VM blackout.
+	markerf(ctx, "KATAMARAN_VM_STOPPED at_unix_ms=%d\n", time.Now().UnixMilli())
 
 	tunnelCreated := false
 	var tunnelName string
@@ -364,50 +406,37 @@ stopLoop:
 	}
 	slog.Info("Waiting for migration to complete")
 
-	migrationErr := waitForMigrationComplete(ctx, client)
+	migrationErr := hv.WaitComplete(ctx)
 
 	if migrationErr == nil {
-		// Capture actual migration metrics from QEMU.
-		raw, qerr := client.Execute(ctx, "query-migrate", nil)
-		if qerr != nil {
-			slog.Warn("Failed to capture migration metrics", "error", qerr)
+		migrated = true
+		// Capture actual migration metrics from the hypervisor.
+		if stats, err := hv.Progress(ctx); err != nil {
+			slog.Warn("Failed to capture migration metrics", "error", err)
 		} else {
-			var info qmp.MigrateInfo
-			if err := json.Unmarshal(raw, &info); err != nil {
-				slog.Warn("Failed to parse migration metrics", "error", err)
-			} else {
-				slog.Info("Migration completed", "actual_downtime_ms", info.Downtime, "total_time_ms", info.TotalTime, "setup_time_ms", info.SetupTime, "ram_transferred", info.RAM.Transferred, "ram_total", info.RAM.Total)
-				// Stable, parser-friendly final-result marker the orchestrator
-				// scrapes from pod logs to populate StatusUpdate.DowntimeMS in
-				// the PhaseSucceeded event.
-				fmt.Printf("KATAMARAN_RESULT downtime_ms=%d total_time_ms=%d ram_transferred=%d ram_total=%d\n",
-					info.Downtime, info.TotalTime, info.RAM.Transferred, info.RAM.Total)
-			}
+			slog.Info("Migration completed", "actual_downtime_ms", stats.DowntimeMS, "total_time_ms", stats.TotalTimeMS, "setup_time_ms", stats.SetupTimeMS, "ram_transferred", stats.RAMTransferred, "ram_total", stats.RAMTotal, "compression_ratio", stats.CompressionRatio)
+			// Stable, parser-friendly final-result marker the orchestrator
+			// scrapes from pod logs to populate StatusUpdate.DowntimeMS in
+			// the PhaseSucceeded event.
+			markerf(ctx, "KATAMARAN_RESULT downtime_ms=%d total_time_ms=%d ram_transferred=%d ram_total=%d memory_saved=%d compression=%s compression_ratio=%.2f\n",
+				stats.DowntimeMS, stats.TotalTimeMS, stats.RAMTransferred, stats.RAMTotal, memorySaved, cfg.Compression, stats.CompressionRatio)
 		}
 	}
 
 	if migrationErr != nil {
 		cctx, ccancel := cleanupCtx(ctx)
 		defer ccancel()
-		if _, cancelErr := client.Execute(cctx, "migrate-cancel", nil); cancelErr != nil {
+		if cancelErr := hv.Cancel(cctx); cancelErr != nil {
 			slog.Warn("Failed to cancel migration", "error", cancelErr)
 		} else {
-			slog.Info("Migration cancelled via QMP")
+			slog.Info("Migration cancelled")
 		}
 	}
 
 	if !cfg.SharedStorage {
 		cctx, ccancel := cleanupCtx(ctx)
 		defer ccancel()
-		for _, jid := range mirrorJobIDs {
-			if _, err := client.Execute(cctx, "block-job-cancel", qmp.BlockJobCancelArgs{
-				Device: jid,
-				Force:  true,
-			}); err != nil {
-				slog.Warn("Failed to cancel block job", "job_id", jid, "error", err)
-			}
-		}
-		mirrorJobIDs = nil
+		hv.StopStorageSync(cctx)
 		slog.Info("Storage mirrors cancelled")
 	}
 
@@ -441,6 +470,21 @@ stopLoop:
 	return nil
 }
 
+// migrationCapabilities returns the capabilities both sides of a RAM
+// migration set; they must match or the handshake fails.
+func migrationCapabilities(multifdChannels int, compression string) []qmp.MigrationCapability {
+	caps := []qmp.MigrationCapability{
+		{Capability: "auto-converge", State: true},
+	}
+	if multifdChannels > 0 {
+		caps = append(caps, qmp.MigrationCapability{Capability: "multifd", State: true})
+	}
+	if compression == CompressionXBZRLE {
+		caps = append(caps, qmp.MigrationCapability{Capability: "xbzrle", State: true})
+	}
+	return caps
+}
+
 var measureRTTFunc = measureRTT
 
 // measureRTT estimates network round-trip time to the destination by sending
@@ -459,17 +503,9 @@ func measureRTT(destIP netip.Addr) (time.Duration, error) {
 	// loop (i--) can spin forever on a busy network where most replies do
 	// not match our id+seq.
 	const maxUnrelatedReplies = samples * 5
-	network := "ip4:icmp"
-	listenAddr := "0.0.0.0"
-	var icmpType icmp.Type = ipv4.ICMPTypeEcho
-	if destIP.Is6() {
-		network = "ip6:ipv6-icmp"
-		listenAddr = "::"
-		icmpType = ipv6.ICMPTypeEchoRequest
-	}
-	conn, err := icmp.ListenPacket(network, listenAddr)
+	conn, icmpType, err := listenICMP(destIP)
 	if err != nil {
-		return 0, fmt.Errorf("opening ICMP socket (does the pod have CAP_NET_RAW?): %w", err)
+		return 0, err
 	}
 	defer func() { _ = conn.Close() }()
 
@@ -506,11 +542,7 @@ func measureRTT(destIP netip.Addr) (time.Duration, error) {
 		rtt := time.Since(start)
 		// Sanity-check the reply: ignore unrelated ICMP traffic and require an
 		// echo-reply matching our id+seq before counting the sample.
-		proto := 1 // ICMPv4
-		if destIP.Is6() {
-			proto = 58 // ICMPv6
-		}
-		parsed, perr := icmp.ParseMessage(proto, reply[:n])
+		parsed, perr := icmp.ParseMessage(icmpProto(destIP), reply[:n])
 		if perr != nil {
 			return 0, fmt.Errorf("RTT sample %d/%d parse failed: %w", i+1, samples, perr)
 		}
@@ -533,6 +565,33 @@ func measureRTT(destIP netip.Addr) (time.Duration, error) {
 	return best, nil
 }
 
+// listenICMP opens a raw ICMP socket for echoes to destIP's address
+// family and returns it with the echo request type to send.
+func listenICMP(destIP netip.Addr) (*icmp.PacketConn, icmp.Type, error) {
+	network := "ip4:icmp"
+	listenAddr := "0.0.0.0"
+	var icmpType icmp.Type = ipv4.ICMPTypeEcho
+	if destIP.Is6() {
+		network = "ip6:ipv6-icmp"
+		listenAddr = "::"
+		icmpType = ipv6.ICMPTypeEchoRequest
+	}
+	conn, err := icmp.ListenPacket(network, listenAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening ICMP socket (does the pod have CAP_NET_RAW?): %w", err)
+	}
+	return conn, icmpType, nil
+}
+
+// icmpProto is the IP protocol number icmp.ParseMessage needs for
+// replies from destIP.
+func icmpProto(destIP netip.Addr) int {
+	if destIP.Is6() {
+		return 58 // ICMPv6
+	}
+	return 1 // ICMPv4
+}
+
 // logTransientQueryError logs at Debug for the first few consecutive
 // query-migrate failures and escalates to Warn at >= 10 in a row, so a
 // flapping QMP socket surfaces in production logs without spamming on
@@ -568,21 +627,32 @@ func migrationTerminalError(status qmp.MigrateStatus, errorDesc string) (bool, e
 // disappears, never appears within jobAppearTimeout, or reaches a terminal
 // failure state.
 func waitForStorageSync(ctx context.Context, client *qmp.Client, jobIDs ...string) error {
+	return syncMirrors(ctx, client, nil, jobIDs)
+}
+
+// syncMirrors is waitForStorageSync with optional recovery: when m is
+// non-nil, a job paused on a target error is resumed and a job that
+// disappeared or failed is restarted, within m's retry budget, instead of
+// failing the sync.
+func syncMirrors(ctx context.Context, client *qmp.Client, m *mirrorSet, jobIDs []string) error {
 	ctx, cancel := context.WithTimeout(ctx, storageSyncTimeout)
 	defer cancel()
 
 	type jobState struct {
 		seen          bool
 		ready         bool
+		appearBy      time.Time
 		lastLoggedPct float64
 		lastOffset    int64
 		lastLogTime   time.Time
 	}
 	state := make(map[string]*jobState, len(jobIDs))
 	for _, id := range jobIDs {
-		state[id] = &jobState{lastLoggedPct: -1}
+		state[id] = &jobState{lastLoggedPct: -1, appearBy: time.Now().Add(jobAppearTimeout)}
+	}
+	restarted := func(js *jobState) {
+		*js = jobState{lastLoggedPct: -1, appearBy: time.Now().Add(jobAppearTimeout)}
 	}
-	appearDeadline := time.Now().Add(jobAppearTimeout)
 	ticker := time.NewTicker(storagePollInterval)
 	defer ticker.Stop()
 
@@ -606,27 +676,68 @@ func waitForStorageSync(ctx context.Context, client *qmp.Client, jobIDs ...strin
 		for i := range jobs {
 			jobsByID[jobs[i].Device] = &jobs[i]
 		}
+		var failed map[string]bool
+		if m != nil {
+			failed = m.failed()
+		}
 
 		allReady := true
 		for jobID, js := range state {
-			if js.ready {
+			job := jobsByID[jobID]
+			// A ready mirror can still hit a target error while the
+			// others catch up.
+			if m != nil && job != nil && (failed[jobID] || (job.Paused && job.IOStatus != "" && job.IOStatus != "ok")) &&
+				job.Status != qmp.BlockJobStatusConcluded && job.Status != qmp.BlockJobStatusNull {
+				if err := m.resume(ctx, jobID); err != nil {
+					return err
+				}
+				allReady = false
+				continue
+			}
+			gone := job == nil || job.Status == qmp.BlockJobStatusConcluded || job.Status == qmp.BlockJobStatusNull
+			if js.ready && (m == nil || !gone) {
 				continue
 			}
-			job := jobsByID[jobID]
 			if job == nil {
 				if js.seen {
+					if m != nil {
+						if err := m.restart(ctx, jobID, "disappeared"); err != nil {
+							return err
+						}
+						restarted(js)
+						allReady = false
+						continue
+					}
 					return fmt.Errorf("block mirror job %q disappeared", jobID)
 				}
-				if time.Now().After(appearDeadline) {
+				if time.Now().After(js.appearBy) {
 					return fmt.Errorf("block mirror job %q did not appear", jobID)
 				}
 				allReady = false
 				continue
 			}
 			js.seen = true
+			if gone {
+				if m != nil {
+					reason := fmt.Sprintf("failed (status=%s)", job.Status)
+					if job.Error != "" {
+						reason += ": " + job.Error
+					}
+					if err := m.restart(ctx, jobID, reason); err != nil {
+						return err
+					}
+					restarted(js)
+					allReady = false
+					continue
+				}
+				return fmt.Errorf("block mirror job %q failed (status=%s)", jobID, job.Status)
+			}
 			if job.Ready {
 				js.ready = true
 				slog.Info("Storage mirror ready", "job_id", jobID)
+				if m != nil {
+					m.ready(ctx, jobID)
+				}
 				continue
 			}
 			allReady = false
@@ -647,9 +758,6 @@ func waitForStorageSync(ctx context.Context, client *qmp.Client, jobIDs ...strin
 					js.lastLogTime = time.Now()
 				}
 			}
-			if job.Status == qmp.BlockJobStatusConcluded || job.Status == qmp.BlockJobStatusNull {
-				return fmt.Errorf("block mirror job %q failed (status=%s)", jobID, job.Status)
-			}
 		}
 		if allReady {
 			return nil
@@ -740,7 +848,7 @@ func waitForMigrationComplete(ctx context.Context, client *qmp.Client) error {
 				// Stable, parser-friendly progress marker the orchestrator
 				// scrapes from pod logs to surface RAM transfer progress
 				// without depending on slog's text/json layout.
-				fmt.Printf("KATAMARAN_PROGRESS status=%s ram_transferred=%d ram_total=%d ram_remaining=%d\n",
+				markerf(ctx, "KATAMARAN_PROGRESS status=%s ram_transferred=%d ram_total=%d ram_remaining=%d\n",
 					info.Status, info.RAM.Transferred, info.RAM.Total, info.RAM.Remaining)
 				prevStatus = info.Status
 				lastLoggedRemaining = info.RAM.Remaining
@@ -760,13 +868,28 @@ func waitForMigrationComplete(ctx context.Context, client *qmp.Client) error {
 // emitVMConfig reads the source sandbox's persist.json and emits the
 // VMConfig as a base64-encoded stdout marker. The dest binary scrapes
 // this from the source pod's log to populate migration-meta.json so the
-// factory can serve it to the Kata shim for VM adoption.
-func emitVMConfig(qemuPID int) {
+// factory can serve it to the Kata shim for VM adoption. Both markers are
+// signed when key is set.
+func emitVMConfig(ctx context.Context, qemuPID int, key []byte) {
+	vmCfg, agentCfg, sandbox := sandboxVMConfig(qemuPID)
+	if vmCfg == nil {
+		return
+	}
+	markerln(ctx, formatMarker(key, vmConfigMarker, vmCfg))
+	markerln(ctx, formatMarker(key, agentConfigMarker, agentCfg))
+	slog.Info("Emitted VMConfig for factory adoption", "sandbox", sandbox, "size", len(vmCfg))
+}
+
+// sandboxVMConfig finds the sandbox persist.json whose hypervisor PID is
+// qemuPID and returns its VMConfig (hypervisor type and config plus agent
+// config), the agent config alone, and the sandbox name. vmCfg is nil when
+// no sandbox matches.
+func sandboxVMConfig(qemuPID int) (vmCfg, agentCfg json.RawMessage, sandbox string) {
 	sbsDir := "/run/vc/sbs"
 	entries, err := os.ReadDir(sbsDir)
 	if err != nil {
-		slog.Info("Cannot read sandbox dir for VMConfig emission", "dir", sbsDir, "error", err)
-		return
+		slog.Info("Cannot read sandbox dir for VMConfig", "dir", sbsDir, "error", err)
+		return nil, nil, ""
 	}
 	for _, e := range entries {
 		if !e.IsDir() {
@@ -776,7 +899,7 @@ func emitVMConfig(qemuPID int) {
 		raw, err := os.ReadFile(persistPath)
 		if err != nil {
 			if !os.IsNotExist(err) {
-				slog.Warn("emitVMConfig: read sandbox persist.json failed", "path", persistPath, "error", err)
+				slog.Warn("sandboxVMConfig: read sandbox persist.json failed", "path", persistPath, "error", err)
 			}
 			continue
 		}
@@ -791,22 +914,19 @@ func emitVMConfig(qemuPID int) {
 			} `json:"Config"`
 		}
 		if err := json.Unmarshal(raw, &persist); err != nil {
-			slog.Warn("Failed to parse Kata persist.json for VMConfig emission", "path", persistPath, "error", err)
+			slog.Warn("Failed to parse Kata persist.json for VMConfig", "path", persistPath, "error", err)
 			continue
 		}
 		if persist.HypervisorState.Pid != qemuPID {
 			continue
 		}
-		vmCfg, _ := json.Marshal(map[string]any{
+		vmCfg, _ = json.Marshal(map[string]any{
 			"HypervisorType":   persist.Config.HypervisorType,
 			"HypervisorConfig": json.RawMessage(persist.Config.HypervisorConfig),
 			"AgentConfig":      json.RawMessage(persist.Config.KataAgentConfig),
 		})
-		agentCfg := persist.Config.KataAgentConfig
-		fmt.Printf("KATAMARAN_VMCONFIG_B64=%s\n", base64.StdEncoding.EncodeToString(vmCfg))
-		fmt.Printf("KATAMARAN_AGENTCONFIG_B64=%s\n", base64.StdEncoding.EncodeToString(agentCfg))
-		slog.Info("Emitted VMConfig for factory adoption", "sandbox", e.Name(), "size", len(vmCfg))
-		return
+		return vmCfg, persist.Config.KataAgentConfig, e.Name()
 	}
-	slog.Info("No matching sandbox found for VMConfig emission", "qemu_pid", qemuPID)
+	slog.Info("No matching sandbox found for VMConfig", "qemu_pid", qemuPID)
+	return nil, nil, ""
 }
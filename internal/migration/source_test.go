@@ -580,7 +580,7 @@ func TestRunSource_NonShared_CommandArguments(t *testing.T) {
 	if mirror.Target != "nbd:10.0.0.1:10809:exportname=drive-virtio-disk0" {
 		t.Fatalf("drive-mirror target = %q", mirror.Target)
 	}
-	if mirror.Sync != "full" || mirror.Mode != "existing" || mirror.JobID != "mirror-drive-virtio-disk0" {
+	if mirror.Sync != "full" || mirror.Mode != "existing" || mirror.JobID != "mirror-drive-virtio-disk0" || mirror.OnTargetError != "stop" {
 		t.Fatalf("unexpected drive-mirror args: %+v", mirror)
 	}
 
@@ -0,0 +1,155 @@
+package migration
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+const (
+	// tuningMarker is printed by the source for each adjustment the
+	// adaptive tuner makes, so the orchestrator can record the history:
+	//
+	//	KATAMARAN_TUNING step=<n> param=max_bandwidth|downtime_limit_ms old=<n> new=<n>
+	//	  expected_downtime_ms=<n> dirty_rate_bps=<n> throughput_mbps=<n> remaining=<n>
+	tuningMarker = "KATAMARAN_TUNING "
+
+	// Tuned parameters in the KATAMARAN_TUNING marker. max_bandwidth is
+	// in bytes per second.
+	tuneParamBandwidth = "max_bandwidth"
+	tuneParamDowntime  = "downtime_limit_ms"
+
+	// defaultMaxDowntimeMS is the downtime ceiling when
+	// SourceConfig.MaxDowntimeMS is zero.
+	defaultMaxDowntimeMS = 500
+
+	// tuneStallPolls is how many consecutive query-migrate polls without
+	// progress make a stall. A poll makes progress when the remaining RAM
+	// falls below tuneProgressRatio of the lowest seen so far.
+	tuneStallPolls    = 5
+	tuneProgressRatio = 0.9
+
+	// tuneMinDowntimeStepMS is the smallest downtime raise; otherwise
+	// each step adds half the current limit.
+	tuneMinDowntimeStepMS = 25
+)
+
+// migrationTuner is the adaptive tuner (SourceConfig.AdaptiveTuning). It
+// is fed every query-migrate poll of the pre-copy phase and answers a
+// stall with one adjustment: first it doubles a capped bandwidth up to
+// maxBandwidth, then it raises the downtime limit up to the ceiling. The
+// guest is never throttled, so a guest that dirties memory faster than
+// the link carries it even at the ceiling does not converge.
+type migrationTuner struct {
+	downtimeMS, ceilingMS int64
+	bandwidth             int64 // bytes per second
+	step                  int
+	stalled               int
+	lowestRemaining       int64
+	warnedCeiling         bool
+}
+
+// tuningAdjustment is one change the tuner asks for.
+type tuningAdjustment struct {
+	param    string
+	old, new int64
+}
+
+func newMigrationTuner(cfg SourceConfig, downtimeLimitMS int) *migrationTuner {
+	ceiling := int64(cfg.MaxDowntimeMS)
+	if ceiling <= 0 {
+		ceiling = defaultMaxDowntimeMS
+	}
+	// An auto-calculated limit may start above the ceiling; never lower it.
+	ceiling = max(ceiling, int64(downtimeLimitMS))
+	return &migrationTuner{
+		downtimeMS: int64(downtimeLimitMS),
+		ceilingMS:  ceiling,
+		bandwidth:  transferBandwidth(cfg),
+	}
+}
+
+// transferBandwidth returns the max-bandwidth the source starts the RAM
+// transfer with, in bytes per second.
+func transferBandwidth(cfg SourceConfig) int64 {
+	if cfg.MaxBandwidthMBps > 0 {
+		return min(int64(cfg.MaxBandwidthMBps)<<20, maxBandwidth)
+	}
+	return maxBandwidth
+}
+
+// observe records one query-migrate poll and returns the adjustment to
+// make, if any. Only polls after the first pass over guest memory count:
+// during it the remaining RAM falls anyway and QEMU has no dirty rate.
+func (t *migrationTuner) observe(info qmp.MigrateInfo) (tuningAdjustment, bool) {
+	if info.Status != qmp.MigrateStatusActive || info.RAM.DirtySyncCount < 2 {
+		return tuningAdjustment{}, false
+	}
+	remaining := info.RAM.Remaining
+	if t.lowestRemaining == 0 || float64(remaining) < float64(t.lowestRemaining)*tuneProgressRatio {
+		t.lowestRemaining = remaining
+		t.stalled = 0
+		return tuningAdjustment{}, false
+	}
+	if info.ExpectedDowntime > 0 && info.ExpectedDowntime <= t.downtimeMS {
+		// QEMU switches over on its next iteration.
+		t.stalled = 0
+		return tuningAdjustment{}, false
+	}
+	if t.stalled++; t.stalled < tuneStallPolls {
+		return tuningAdjustment{}, false
+	}
+	// Each adjustment gets a full window to show its effect.
+	t.stalled = 0
+	t.lowestRemaining = remaining
+
+	switch {
+	case t.bandwidth < maxBandwidth:
+		adj := tuningAdjustment{param: tuneParamBandwidth, old: t.bandwidth, new: min(t.bandwidth*2, maxBandwidth)}
+		t.bandwidth = adj.new
+		return adj, true
+	case t.downtimeMS < t.ceilingMS:
+		next := max(t.downtimeMS*3/2, t.downtimeMS+tuneMinDowntimeStepMS)
+		adj := tuningAdjustment{param: tuneParamDowntime, old: t.downtimeMS, new: min(next, t.ceilingMS)}
+		t.downtimeMS = adj.new
+		return adj, true
+	}
+	if !t.warnedCeiling {
+		t.warnedCeiling = true
+		slog.Warn("Migration is not converging at the downtime ceiling; the guest dirties memory faster than the link carries it",
+			"downtime_limit_ms", t.downtimeMS, "expected_downtime_ms", info.ExpectedDowntime)
+	}
+	return tuningAdjustment{}, false
+}
+
+// tune feeds info to the tuner and applies its adjustment with
+// migrate-set-parameters, which takes effect on QEMU's next iteration.
+// A failed adjustment is logged and the transfer goes on as it was.
+func (t *migrationTuner) tune(ctx context.Context, client *qmp.Client, info qmp.MigrateInfo) {
+	adj, ok := t.observe(info)
+	if !ok {
+		return
+	}
+	var params qmp.MigrateSetParametersArgs
+	if adj.param == tuneParamBandwidth {
+		params.MaxBandwidth = adj.new
+	} else {
+		params.DowntimeLimit = adj.new
+	}
+	if _, err := client.Execute(ctx, "migrate-set-parameters", params); err != nil {
+		slog.Warn("Failed to apply migration tuning", "param", adj.param, "value", adj.new, "error", err)
+		return
+	}
+	t.step++
+	pageSize := info.RAM.PageSize
+	if pageSize <= 0 {
+		pageSize = 4096
+	}
+	dirtyRate := info.RAM.DirtyPagesRate * pageSize
+	slog.Info("Adjusted migration parameters", "step", t.step, "param", adj.param, "old", adj.old, "new", adj.new,
+		"expected_downtime_ms", info.ExpectedDowntime, "dirty_rate_bps", dirtyRate, "throughput_mbps", info.RAM.Mbps,
+		"ram_remaining", info.RAM.Remaining)
+	markerf(ctx, tuningMarker+"step=%d param=%s old=%d new=%d expected_downtime_ms=%d dirty_rate_bps=%d throughput_mbps=%.0f remaining=%d\n",
+		t.step, adj.param, adj.old, adj.new, info.ExpectedDowntime, dirtyRate, info.RAM.Mbps, info.RAM.Remaining)
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// stalledInfo is a query-migrate answer past the first memory pass with
+// remaining RAM stuck at 1 GiB and a 400 ms expected downtime.
+func stalledInfo() qmp.MigrateInfo {
+	var info qmp.MigrateInfo
+	info.Status = qmp.MigrateStatusActive
+	info.RAM.DirtySyncCount = 3
+	info.RAM.Remaining = 1 << 30
+	info.RAM.DirtyPagesRate = 100_000
+	info.RAM.Mbps = 8000
+	info.ExpectedDowntime = 400
+	return info
+}
+
+// observeStall feeds the tuner one stall window: a poll that sets the
+// baseline (or, after an adjustment, the first poll of the new window)
+// and the polls that make the stall.
+func observeStall(t *testing.T, tuner *migrationTuner) (tuningAdjustment, bool) {
+	t.Helper()
+	for range tuneStallPolls - 1 {
+		if adj, ok := tuner.observe(stalledInfo()); ok {
+			t.Fatalf("adjusted %+v before the stall window ended", adj)
+		}
+	}
+	return tuner.observe(stalledInfo())
+}
+
+func TestMigrationTuner_BandwidthThenDowntime(t *testing.T) {
+	t.Parallel()
+	tuner := newMigrationTuner(SourceConfig{MaxDowntimeMS: 60, MaxBandwidthMBps: 4096}, 25)
+	if _, ok := tuner.observe(stalledInfo()); ok {
+		t.Fatal("adjusted on the baseline poll")
+	}
+
+	var got []tuningAdjustment
+	for range 5 {
+		if adj, ok := observeStall(t, tuner); ok {
+			got = append(got, adj)
+		}
+	}
+	want := []tuningAdjustment{
+		{param: tuneParamBandwidth, old: 4096 << 20, new: 8192 << 20},
+		{param: tuneParamBandwidth, old: 8192 << 20, new: maxBandwidth},
+		{param: tuneParamDowntime, old: 25, new: 50},
+		{param: tuneParamDowntime, old: 50, new: 60},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("adjustments = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("adjustment %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMigrationTuner_NoAdjustment(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		mutate func(*qmp.MigrateInfo, int)
+	}{
+		{"first pass", func(info *qmp.MigrateInfo, _ int) { info.RAM.DirtySyncCount = 1 }},
+		{"converging", func(info *qmp.MigrateInfo, i int) { info.RAM.Remaining >>= i }},
+		{"about to switch over", func(info *qmp.MigrateInfo, _ int) { info.ExpectedDowntime = 20 }},
+		{"not active", func(info *qmp.MigrateInfo, _ int) { info.Status = qmp.MigrateStatusCompleted }},
+	} {
+		tuner := newMigrationTuner(SourceConfig{}, 25)
+		for i := range 3 * tuneStallPolls {
+			info := stalledInfo()
+			tc.mutate(&info, i)
+			if adj, ok := tuner.observe(info); ok {
+				t.Errorf("%s: adjusted %+v", tc.name, adj)
+				break
+			}
+		}
+	}
+}
+
+func TestMigrationTuner_CeilingKeepsAutoDowntime(t *testing.T) {
+	t.Parallel()
+	// An auto-calculated limit above the ceiling is the ceiling.
+	tuner := newMigrationTuner(SourceConfig{MaxDowntimeMS: 100}, 150)
+	tuner.observe(stalledInfo())
+	if adj, ok := observeStall(t, tuner); ok {
+		t.Fatalf("adjusted %+v at the ceiling", adj)
+	}
+}
+
+func TestMigrationTuner_TuneAppliesParameters(t *testing.T) {
+	t.Parallel()
+	sock, rec := startRecordingQMP(t, func(net.Conn, recordedQMPCommand) string { return `{"return":{}}` })
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("qmp.NewClient: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	tuner := newMigrationTuner(SourceConfig{}, 25)
+	for range tuneStallPolls + 1 {
+		tuner.tune(context.Background(), client, stalledInfo())
+	}
+	var params qmp.MigrateSetParametersArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, rec.Commands(), "migrate-set-parameters"), &params)
+	if params != (qmp.MigrateSetParametersArgs{DowntimeLimit: 50}) {
+		t.Fatalf("parameters = %+v, want only downtime-limit 50", params)
+	}
+	if tuner.step != 1 {
+		t.Fatalf("step = %d, want 1", tuner.step)
+	}
+}
+
+func TestRunSource_AdaptiveTuningDisablesAutoConverge(t *testing.T) {
+	t.Parallel()
+	sock, rec := compressionSourceQMP(t)
+
+	err := RunSource(context.Background(), SourceConfig{
+		QMPSocket: sock, DestIP: testDestIP, VMIP: testVMIP, SharedStorage: true,
+		TunnelMode: TunnelModeNone, DowntimeLimitMS: 25, AdaptiveTuning: true, MaxBandwidthMBps: 1024,
+	})
+	if err != nil {
+		t.Fatalf("RunSource: %v", err)
+	}
+	commands := rec.Commands()
+	var caps qmp.MigrateSetCapabilitiesArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate-set-capabilities"), &caps)
+	for _, c := range caps.Capabilities {
+		if c.Capability == "auto-converge" && c.State {
+			t.Fatalf("capabilities = %+v, want auto-converge off", caps.Capabilities)
+		}
+	}
+	var params qmp.MigrateSetParametersArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "migrate-set-parameters"), &params)
+	if params.MaxBandwidth != 1024<<20 {
+		t.Fatalf("max-bandwidth = %d, want %d", params.MaxBandwidth, 1024<<20)
+	}
+}
@@ -65,3 +65,17 @@ func validateDriveIDs(ids []string) error {
 	}
 	return nil
 }
+
+// validSandboxID matches the synthetic dest sandbox directory names the
+// orchestrator allocates (e.g. "katamaran-dest-0123456789abcdef"). The
+// ID becomes a path component under /run/vc/vm and /run/kata-containers,
+// so no slashes or leading dots.
+var validSandboxID = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-]{0,63}$`)
+
+// validateSandboxID checks that id is a safe sandbox directory name.
+func validateSandboxID(id string) error {
+	if !validSandboxID.MatchString(id) {
+		return fmt.Errorf("invalid sandbox ID: %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maci0/katamaran/internal/nbd"
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// Storage verification modes for --verify-storage.
+const (
+	VerifyStorageOff    = "off"
+	VerifyStorageSample = "sample"
+	VerifyStorageFull   = "full"
+)
+
+// storageVerifyMarker is printed once per verified drive:
+// KATAMARAN_STORAGE_VERIFY drive_id=<id> mode=<mode> extents=<n>
+// mismatched=<n> result=ok|mismatch|unsettled. The orchestrator surfaces
+// it as a StatusUpdate.
+const storageVerifyMarker = "KATAMARAN_STORAGE_VERIFY "
+
+// Verification results.
+const (
+	verifyOK        = "ok"
+	verifyMismatch  = "mismatch"
+	verifyUnsettled = "unsettled"
+)
+
+const (
+	// verifyExtentSize is the unit hashed and compared.
+	verifyExtentSize = 1 << 20
+	// verifySamples is how many extents sample mode compares per drive.
+	verifySamples = 64
+	// verifyRounds bounds re-checks of mismatched extents while the guest
+	// keeps writing.
+	verifyRounds = 3
+	// verifyBitmap is the dirty bitmap that tells a mismatch caused by a
+	// guest write the mirror has not copied yet from real corruption.
+	verifyBitmap = "katamaran-verify"
+	// verifySocketName is the source-side NBD socket, created next to the
+	// QMP socket so QEMU can reach it regardless of its network namespace.
+	verifySocketName = "katamaran-verify-nbd.sock"
+)
+
+// verifySettle is how long a round waits for the mirror to copy fresh
+// guest writes before re-checking. Package-level var so tests can shorten it.
+var verifySettle = 200 * time.Millisecond
+
+// driveVerification is the outcome of verifying one drive.
+type driveVerification struct {
+	DriveID    string
+	Extents    int
+	Mismatched int
+	Result     string
+}
+
+// verifyMirroredStorage compares the source drives against the
+// destination's NBD exports once the mirrors are ready and before RAM
+// migration starts, so a mismatch aborts before the VM is paused. The
+// source drives are exported read-only on a temporary NBD server bound to
+// a Unix socket in the sandbox dir; the destination exports are the
+// writable ones the mirror targets. A mismatch is only reported as such
+// when it persists through a round in which the guest wrote nothing;
+// persistent mismatches on a busy guest are reported as unsettled and do
+// not abort.
+func verifyMirroredStorage(ctx context.Context, client *qmp.Client, cfg SourceConfig, targets []blockTarget) error {
+	sock := filepath.Join(filepath.Dir(cfg.QMPSocket), verifySocketName)
+	_ = os.Remove(sock) // stale socket from an earlier run
+	if _, err := client.Execute(ctx, "nbd-server-start", qmp.NBDServerStartArgs{
+		Addr: qmp.NBDServerAddr{Type: "unix", Data: qmp.NBDServerAddrData{Path: sock}},
+	}); err != nil {
+		return fmt.Errorf("starting source NBD server for verification: %w", err)
+	}
+	defer func() {
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		if _, err := client.Execute(cctx, "nbd-server-stop", nil); err != nil {
+			slog.Warn("Failed to stop verification NBD server", "error", err)
+		}
+	}()
+
+	destAddr := net.JoinHostPort(cfg.DestIP.Unmap().String(), portOr(cfg.NBDPort, nbdPort))
+	var mismatched []string
+	for _, t := range targets {
+		if err := addNBDExport(ctx, client, t, false); err != nil {
+			return fmt.Errorf("exporting source drive %s for verification: %w", t.ID, err)
+		}
+		v, err := verifyDrive(ctx, client, t, sock, destAddr, cfg.VerifyStorage)
+		if err != nil {
+			return fmt.Errorf("verifying drive %s: %w", t.ID, err)
+		}
+		markerf(ctx, storageVerifyMarker+"drive_id=%s mode=%s extents=%d mismatched=%d result=%s\n",
+			v.DriveID, cfg.VerifyStorage, v.Extents, v.Mismatched, v.Result)
+		switch v.Result {
+		case verifyMismatch:
+			slog.Error("Storage verification mismatch", "drive_id", v.DriveID, "extents", v.Extents, "mismatched", v.Mismatched)
+			mismatched = append(mismatched, v.DriveID)
+		case verifyUnsettled:
+			slog.Warn("Storage verification unsettled: guest kept writing to mismatched extents", "drive_id", v.DriveID, "extents", v.Extents, "mismatched", v.Mismatched)
+		default:
+			slog.Info("Storage verification passed", "drive_id", v.DriveID, "extents", v.Extents)
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("storage verification mismatch on %s", strings.Join(mismatched, ","))
+	}
+	return nil
+}
+
+// verifyDrive hashes extents of one drive on both sides.
+func verifyDrive(ctx context.Context, client *qmp.Client, t blockTarget, srcSock, destAddr, mode string) (driveVerification, error) {
+	v := driveVerification{DriveID: t.ID}
+	src, err := nbd.Dial(ctx, "unix", srcSock, t.ID)
+	if err != nil {
+		return v, err
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := nbd.Dial(ctx, "tcp", destAddr, t.ID)
+	if err != nil {
+		return v, err
+	}
+	defer func() { _ = dst.Close() }()
+	if dst.Size() < src.Size() {
+		return v, fmt.Errorf("destination export is %d bytes, source is %d", dst.Size(), src.Size())
+	}
+
+	bitmap := qmp.BlockDirtyBitmapArgs{Node: cmp.Or(t.Node, t.ID), Name: verifyBitmap}
+	if _, err := client.Execute(ctx, "block-dirty-bitmap-add", bitmap); err != nil {
+		return v, fmt.Errorf("adding dirty bitmap: %w", err)
+	}
+	defer func() {
+		cctx, ccancel := cleanupCtx(ctx)
+		defer ccancel()
+		if _, err := client.Execute(cctx, "block-dirty-bitmap-remove", bitmap); err != nil {
+			slog.Warn("Failed to remove verification dirty bitmap", "drive_id", t.ID, "error", err)
+		}
+	}()
+
+	offsets := verifyOffsets(src.Size(), mode)
+	v.Extents = len(offsets)
+	buf := make([]byte, 2*verifyExtentSize)
+	for round := 1; ; round++ {
+		if _, err := client.Execute(ctx, "block-dirty-bitmap-clear", bitmap); err != nil {
+			return v, fmt.Errorf("clearing dirty bitmap: %w", err)
+		}
+		var diff []int64
+		for _, off := range offsets {
+			if err := ctx.Err(); err != nil {
+				return v, err
+			}
+			n := min(verifyExtentSize, src.Size()-off)
+			same, err := extentsMatch(src, dst, off, buf[:n], buf[verifyExtentSize:verifyExtentSize+n])
+			if err != nil {
+				return v, err
+			}
+			if !same {
+				diff = append(diff, off)
+			}
+		}
+		v.Mismatched = len(diff)
+		if len(diff) == 0 {
+			v.Result = verifyOK
+			return v, nil
+		}
+		dirty, err := bitmapDirtyBytes(ctx, client, t)
+		if err != nil {
+			return v, err
+		}
+		// The mirror copies writes asynchronously, so a first-round
+		// mismatch may be a write from just before the bitmap was cleared.
+		// Only a mismatch that survives a settle period with no guest
+		// writes at all is corruption.
+		if dirty == 0 && round > 1 {
+			v.Result = verifyMismatch
+			return v, nil
+		}
+		if round == verifyRounds {
+			v.Result = verifyUnsettled
+			return v, nil
+		}
+		slog.Debug("Mismatched extents while the guest is writing; re-checking", "drive_id", t.ID, "mismatched", len(diff), "dirty_bytes", dirty, "round", round)
+		offsets = diff
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case <-time.After(verifySettle):
+		}
+	}
+}
+
+// extentsMatch reads the extent at off from both sides and compares
+// their SHA-256 hashes.
+func extentsMatch(src, dst io.ReaderAt, off int64, sbuf, dbuf []byte) (bool, error) {
+	if _, err := src.ReadAt(sbuf, off); err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("reading source at %d: %w", off, err)
+	}
+	if _, err := dst.ReadAt(dbuf, off); err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("reading destination at %d: %w", off, err)
+	}
+	return sha256.Sum256(sbuf) == sha256.Sum256(dbuf), nil
+}
+
+// verifyOffsets returns the extent offsets to compare for a disk of size
+// bytes: every extent in full mode, otherwise verifySamples extents at
+// random positions within evenly sized strides, always including the
+// first and last extent.
+func verifyOffsets(size int64, mode string) []int64 {
+	extents := (size + verifyExtentSize - 1) / verifyExtentSize
+	if mode == VerifyStorageFull || extents <= verifySamples {
+		offsets := make([]int64, extents)
+		for i := range offsets {
+			offsets[i] = int64(i) * verifyExtentSize
+		}
+		return offsets
+	}
+	offsets := make([]int64, 0, verifySamples)
+	stride := extents / verifySamples
+	for i := range int64(verifySamples) {
+		offsets = append(offsets, (i*stride+rand.Int64N(stride))*verifyExtentSize)
+	}
+	offsets[0] = 0
+	offsets[len(offsets)-1] = (extents - 1) * verifyExtentSize
+	return offsets
+}
+
+// bitmapDirtyBytes returns the verification bitmap's dirty byte count.
+func bitmapDirtyBytes(ctx context.Context, client *qmp.Client, t blockTarget) (int64, error) {
+	raw, err := client.Execute(ctx, "query-block", nil)
+	if err != nil {
+		return 0, fmt.Errorf("query-block: %w", err)
+	}
+	var blocks []qmp.BlockInfo
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return 0, fmt.Errorf("decode query-block: %w", err)
+	}
+	for _, b := range blocks {
+		in := b.Inserted
+		if in == nil || (b.Device != t.ID && (t.Node == "" || in.NodeName != t.Node)) {
+			continue
+		}
+		for _, bm := range in.DirtyBitmaps {
+			if bm.Name == verifyBitmap {
+				return bm.Count, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("dirty bitmap %s not found on drive %s", verifyBitmap, t.ID)
+}
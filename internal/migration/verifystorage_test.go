@@ -0,0 +1,127 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maci0/katamaran/internal/nbdtest"
+	"github.com/maci0/katamaran/internal/qmp"
+)
+
+// verifyFixture serves the source drive over the QMP-started Unix NBD
+// server and the destination copy over TCP. dirtyBytes is what query-block
+// reports for the verification bitmap.
+func verifyFixture(t *testing.T, src, dst []byte, dirtyBytes int64) (*qmp.Client, *qmpRecorder, SourceConfig) {
+	t.Helper()
+	prev := verifySettle
+	verifySettle = time.Millisecond
+	t.Cleanup(func() { verifySettle = prev })
+
+	destAddr, _ := nbdtest.Start(t, "tcp", "", map[string][]byte{"drive-virtio-disk0": dst})
+	host, port, _ := net.SplitHostPort(destAddr)
+	nbdPort, _ := strconv.Atoi(port)
+
+	sock, rec := startRecordingQMP(t, func(_ net.Conn, cmd recordedQMPCommand) string {
+		switch cmd.Execute {
+		case "nbd-server-start":
+			var args qmp.NBDServerStartArgs
+			decodeRecordedArgs(t, cmd, &args)
+			nbdtest.Start(t, "unix", args.Addr.Data.Path, map[string][]byte{"drive-virtio-disk0": src})
+		case "query-block":
+			return fmt.Sprintf(`{"return":[{"device":"drive-virtio-disk0","inserted":{"file":"/var/lib/kata/disk0.img","drv":"raw",`+
+				`"dirty-bitmaps":[{"name":"katamaran-verify","count":%d}]}}]}`, dirtyBytes)
+		}
+		return `{"return":{}}`
+	})
+	client, err := qmp.NewClient(context.Background(), sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	cfg := SourceConfig{
+		QMPSocket:     sock,
+		DestIP:        netip.MustParseAddr(host),
+		NBDPort:       nbdPort,
+		VerifyStorage: VerifyStorageFull,
+	}
+	return client, rec, cfg
+}
+
+func testDisk() []byte {
+	disk := make([]byte, 3*verifyExtentSize+4096)
+	for i := range disk {
+		disk[i] = byte(i % 251)
+	}
+	return disk
+}
+
+func TestVerifyMirroredStorage_Match(t *testing.T) {
+	disk := testDisk()
+	client, rec, cfg := verifyFixture(t, disk, disk, 0)
+
+	if err := verifyMirroredStorage(context.Background(), client, cfg, []blockTarget{{ID: "drive-virtio-disk0"}}); err != nil {
+		t.Fatalf("verifyMirroredStorage: %v", err)
+	}
+	commands := rec.Commands()
+	assertRecordedSubsequence(t, commands, []string{
+		"nbd-server-start", "nbd-server-add", "block-dirty-bitmap-add", "block-dirty-bitmap-clear",
+		"block-dirty-bitmap-remove", "nbd-server-stop",
+	})
+	var add qmp.NBDServerAddArgs
+	decodeRecordedArgs(t, findRecordedCommand(t, commands, "nbd-server-add"), &add)
+	if add != (qmp.NBDServerAddArgs{Device: "drive-virtio-disk0"}) {
+		t.Errorf("nbd-server-add = %+v, want a read-only export of drive-virtio-disk0", add)
+	}
+}
+
+func TestVerifyMirroredStorage_MismatchAborts(t *testing.T) {
+	disk := testDisk()
+	corrupt := append([]byte(nil), disk...)
+	corrupt[2*verifyExtentSize+17] ^= 0xff
+	client, _, cfg := verifyFixture(t, disk, corrupt, 0)
+
+	err := verifyMirroredStorage(context.Background(), client, cfg, []blockTarget{{ID: "drive-virtio-disk0"}})
+	if err == nil || !strings.Contains(err.Error(), "storage verification mismatch on drive-virtio-disk0") {
+		t.Fatalf("err = %v, want mismatch", err)
+	}
+}
+
+func TestVerifyMirroredStorage_BusyGuestIsUnsettled(t *testing.T) {
+	disk := testDisk()
+	corrupt := append([]byte(nil), disk...)
+	corrupt[0] ^= 0xff
+	client, _, cfg := verifyFixture(t, disk, corrupt, 65536)
+
+	// Every round sees guest writes, so the mismatch cannot be told apart
+	// from mirror lag: reported, but not fatal.
+	if err := verifyMirroredStorage(context.Background(), client, cfg, []blockTarget{{ID: "drive-virtio-disk0"}}); err != nil {
+		t.Fatalf("verifyMirroredStorage: %v", err)
+	}
+}
+
+func TestVerifyOffsets(t *testing.T) {
+	t.Parallel()
+	const size = 1000*verifyExtentSize + 5
+	full := verifyOffsets(size, VerifyStorageFull)
+	if len(full) != 1001 || full[1000] != 1000*verifyExtentSize {
+		t.Fatalf("full: %d offsets, last %d", len(full), full[len(full)-1])
+	}
+	sample := verifyOffsets(size, VerifyStorageSample)
+	if len(sample) != verifySamples || sample[0] != 0 || sample[len(sample)-1] != 1000*verifyExtentSize {
+		t.Fatalf("sample: %d offsets, first %d, last %d", len(sample), sample[0], sample[len(sample)-1])
+	}
+	for i := 1; i < len(sample); i++ {
+		if sample[i] <= sample[i-1] || sample[i]%verifyExtentSize != 0 {
+			t.Fatalf("sample offsets not increasing extent boundaries: %v", sample)
+		}
+	}
+	if small := verifyOffsets(3*verifyExtentSize, VerifyStorageSample); len(small) != 3 {
+		t.Fatalf("small disk sample: %v", small)
+	}
+}
@@ -0,0 +1,229 @@
+// Package nbd implements a minimal read-only client for the Network Block
+// Device protocol, enough to read extents of a QEMU NBD export.
+//
+// Only the fixed newstyle handshake with NBD_OPT_GO and simple replies are
+// supported, which every QEMU since 2.10 offers. The client is NOT safe for
+// concurrent use.
+package nbd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Protocol constants, from the NBD protocol specification.
+const (
+	nbdMagic         uint64 = 0x4e42444d41474943 // "NBDMAGIC"
+	optMagic         uint64 = 0x49484156454f5054 // "IHAVEOPT"
+	optReplyMagic    uint64 = 0x0003e889045565a9
+	requestMagic     uint32 = 0x25609513
+	simpleReplyMagic uint32 = 0x67446698
+
+	flagFixedNewstyle uint16 = 1 << 0
+	flagNoZeroes      uint16 = 1 << 1
+
+	optGo uint32 = 7
+
+	repAck    uint32 = 1
+	repInfo   uint32 = 3
+	repErrBit uint32 = 1 << 31
+
+	infoExport uint16 = 0
+
+	cmdRead uint16 = 0
+	cmdDisc uint16 = 2
+)
+
+const (
+	// dialTimeout bounds connect plus handshake.
+	dialTimeout = 10 * time.Second
+	// ioTimeout bounds a single read request round trip.
+	ioTimeout = 30 * time.Second
+	// maxRequest is the largest read sent in one request. QEMU refuses
+	// requests above 32 MiB.
+	maxRequest = 4 << 20
+	// maxOptReply caps option reply payloads; real ones are tiny.
+	maxOptReply = 64 << 10
+)
+
+// Client is a connection to one NBD export.
+type Client struct {
+	conn   net.Conn
+	size   int64
+	handle uint64
+}
+
+// Dial connects to an NBD server at addr on network ("tcp" or "unix") and
+// opens export.
+func Dial(ctx context.Context, network, addr, export string) (*Client, error) {
+	dctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(dctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing NBD server %s: %w", addr, err)
+	}
+	deadline, _ := dctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("setting handshake deadline: %w", err)
+	}
+	c := &Client{conn: conn}
+	if err := c.handshake(export); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("NBD handshake with %s (export %q): %w", addr, export, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("clearing handshake deadline: %w", err)
+	}
+	return c, nil
+}
+
+// handshake runs the fixed newstyle negotiation and NBD_OPT_GO.
+func (c *Client) handshake(export string) error {
+	var hdr struct {
+		Magic    uint64
+		OptMagic uint64
+		Flags    uint16
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &hdr); err != nil {
+		return fmt.Errorf("reading greeting: %w", err)
+	}
+	if hdr.Magic != nbdMagic || hdr.OptMagic != optMagic {
+		return errors.New("server does not speak newstyle NBD")
+	}
+	if hdr.Flags&flagFixedNewstyle == 0 {
+		return errors.New("server does not support fixed newstyle negotiation")
+	}
+	if err := binary.Write(c.conn, binary.BigEndian, uint32(hdr.Flags&(flagFixedNewstyle|flagNoZeroes))); err != nil {
+		return fmt.Errorf("sending client flags: %w", err)
+	}
+
+	// NBD_OPT_GO: export name length, name, zero information requests.
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(export)))
+	data = append(data, export...)
+	data = binary.BigEndian.AppendUint16(data, 0)
+	opt := binary.BigEndian.AppendUint64(nil, optMagic)
+	opt = binary.BigEndian.AppendUint32(opt, optGo)
+	opt = binary.BigEndian.AppendUint32(opt, uint32(len(data)))
+	if _, err := c.conn.Write(append(opt, data...)); err != nil {
+		return fmt.Errorf("sending NBD_OPT_GO: %w", err)
+	}
+
+	for {
+		var rep struct {
+			Magic  uint64
+			Option uint32
+			Type   uint32
+			Length uint32
+		}
+		if err := binary.Read(c.conn, binary.BigEndian, &rep); err != nil {
+			return fmt.Errorf("reading option reply: %w", err)
+		}
+		if rep.Magic != optReplyMagic {
+			return fmt.Errorf("bad option reply magic %#x", rep.Magic)
+		}
+		if rep.Length > maxOptReply {
+			return fmt.Errorf("option reply of %d bytes too large", rep.Length)
+		}
+		payload := make([]byte, rep.Length)
+		if _, err := io.ReadFull(c.conn, payload); err != nil {
+			return fmt.Errorf("reading option reply payload: %w", err)
+		}
+		switch {
+		case rep.Type&repErrBit != 0:
+			return fmt.Errorf("export refused (reply %#x): %s", rep.Type, payload)
+		case rep.Type == repInfo:
+			if len(payload) >= 12 && binary.BigEndian.Uint16(payload) == infoExport {
+				c.size = int64(binary.BigEndian.Uint64(payload[2:]))
+			}
+		case rep.Type == repAck:
+			return nil
+		}
+	}
+}
+
+// Size returns the export size in bytes.
+func (c *Client) Size() int64 { return c.size }
+
+// ReadAt reads len(p) bytes at off, implementing io.ReaderAt. Reads past
+// the end of the export return io.EOF after the available bytes.
+func (c *Client) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("nbd: negative offset %d", off)
+	}
+	var eof error
+	if rest := c.size - off; int64(len(p)) > rest {
+		p = p[:max(rest, 0)]
+		eof = io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		chunk := p[n:min(len(p), n+maxRequest)]
+		if err := c.read(chunk, off+int64(n)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, eof
+}
+
+// read issues one NBD_CMD_READ into p.
+func (c *Client) read(p []byte, off int64) error {
+	if err := c.conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return fmt.Errorf("setting read deadline: %w", err)
+	}
+	c.handle++
+	if err := c.request(cmdRead, uint64(off), uint32(len(p))); err != nil {
+		return err
+	}
+	var rep struct {
+		Magic  uint32
+		Error  uint32
+		Handle uint64
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &rep); err != nil {
+		return fmt.Errorf("reading reply at offset %d: %w", off, err)
+	}
+	if rep.Magic != simpleReplyMagic {
+		return fmt.Errorf("bad reply magic %#x at offset %d", rep.Magic, off)
+	}
+	if rep.Handle != c.handle {
+		return fmt.Errorf("reply handle %d, want %d", rep.Handle, c.handle)
+	}
+	if rep.Error != 0 {
+		return fmt.Errorf("read of %d bytes at offset %d failed: errno %d", len(p), off, rep.Error)
+	}
+	if _, err := io.ReadFull(c.conn, p); err != nil {
+		return fmt.Errorf("reading %d bytes at offset %d: %w", len(p), off, err)
+	}
+	return nil
+}
+
+// request sends a transmission request header.
+func (c *Client) request(cmd uint16, off uint64, length uint32) error {
+	req := binary.BigEndian.AppendUint32(nil, requestMagic)
+	req = binary.BigEndian.AppendUint16(req, 0) // command flags
+	req = binary.BigEndian.AppendUint16(req, cmd)
+	req = binary.BigEndian.AppendUint64(req, c.handle)
+	req = binary.BigEndian.AppendUint64(req, off)
+	req = binary.BigEndian.AppendUint32(req, length)
+	if _, err := c.conn.Write(req); err != nil {
+		return fmt.Errorf("sending NBD request: %w", err)
+	}
+	return nil
+}
+
+// Close sends NBD_CMD_DISC and closes the connection.
+func (c *Client) Close() error {
+	_ = c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.handle++
+	_ = c.request(cmdDisc, 0, 0) // best effort; the server may be gone
+	return c.conn.Close()
+}
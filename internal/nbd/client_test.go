@@ -0,0 +1,59 @@
+package nbd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/maci0/katamaran/internal/nbdtest"
+)
+
+func TestClient_ReadAt(t *testing.T) {
+	t.Parallel()
+	disk := make([]byte, 3*maxRequest+123)
+	for i := range disk {
+		disk[i] = byte(i * 7)
+	}
+	addr, _ := nbdtest.Start(t, "unix", "", map[string][]byte{"drive-virtio-disk0": disk})
+
+	c, err := Dial(context.Background(), "unix", addr, "drive-virtio-disk0")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	if c.Size() != int64(len(disk)) {
+		t.Fatalf("Size = %d, want %d", c.Size(), len(disk))
+	}
+
+	// Spans several requests.
+	buf := make([]byte, 2*maxRequest+10)
+	if n, err := c.ReadAt(buf, 100); err != nil || n != len(buf) {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, disk[100:100+len(buf)]) {
+		t.Fatal("ReadAt returned wrong data")
+	}
+
+	// Short read at the end of the export.
+	tail := make([]byte, 200)
+	n, err := c.ReadAt(tail, int64(len(disk)-50))
+	if n != 50 || !errors.Is(err, io.EOF) {
+		t.Fatalf("tail ReadAt = %d, %v; want 50, EOF", n, err)
+	}
+	if !bytes.Equal(tail[:50], disk[len(disk)-50:]) {
+		t.Fatal("tail ReadAt returned wrong data")
+	}
+}
+
+func TestDial_UnknownExport(t *testing.T) {
+	t.Parallel()
+	addr, _ := nbdtest.Start(t, "tcp", "", map[string][]byte{"a": make([]byte, 512)})
+
+	_, err := Dial(context.Background(), "tcp", addr, "missing")
+	if err == nil || !strings.Contains(err.Error(), "export refused") {
+		t.Fatalf("err = %v, want export refused", err)
+	}
+}
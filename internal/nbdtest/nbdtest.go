@@ -0,0 +1,143 @@
+// Package nbdtest provides a fake in-memory NBD server for tests.
+//
+// Used by the internal/nbd and internal/migration test suites. It speaks
+// just enough of the protocol for internal/nbd: fixed newstyle, NBD_OPT_GO
+// and simple-reply reads.
+package nbdtest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Server serves exports until the test ends. Exports may be modified
+// between reads through Set.
+type Server struct {
+	mu      sync.Mutex
+	exports map[string][]byte
+}
+
+// Set replaces the contents of export name.
+func (s *Server) Set(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exports[name] = data
+}
+
+func (s *Server) export(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.exports[name]
+	return data, ok
+}
+
+// Start listens on addr, a Unix socket path ("unix") or TCP address
+// ("tcp"), and serves exports to any number of connections. An empty addr
+// picks a fresh socket path or loopback port. It returns the listen
+// address.
+func Start(t *testing.T, network, addr string, exports map[string][]byte) (string, *Server) {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+		if network == "unix" {
+			addr = filepath.Join(t.TempDir(), "nbd.sock")
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	s := &Server{exports: map[string][]byte{}}
+	for name, data := range exports {
+		s.exports[name] = data
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return l.Addr().String(), s
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	be := binary.BigEndian
+	hello := be.AppendUint64(nil, 0x4e42444d41474943)
+	hello = be.AppendUint64(hello, 0x49484156454f5054)
+	hello = be.AppendUint16(hello, 3) // fixed newstyle, no zeroes
+	if _, err := conn.Write(hello); err != nil {
+		return
+	}
+	var clientFlags uint32
+	if binary.Read(conn, be, &clientFlags) != nil {
+		return
+	}
+
+	var opt struct {
+		Magic  uint64
+		Option uint32
+		Length uint32
+	}
+	if binary.Read(conn, be, &opt) != nil {
+		return
+	}
+	data := make([]byte, opt.Length)
+	if _, err := io.ReadFull(conn, data); err != nil || len(data) < 4 {
+		return
+	}
+	name := string(data[4 : 4+be.Uint32(data)])
+	reply := func(typ uint32, payload []byte) {
+		r := be.AppendUint64(nil, 0x0003e889045565a9)
+		r = be.AppendUint32(r, opt.Option)
+		r = be.AppendUint32(r, typ)
+		r = be.AppendUint32(r, uint32(len(payload)))
+		_, _ = conn.Write(append(r, payload...))
+	}
+	export, ok := s.export(name)
+	if !ok {
+		reply(1<<31|6, []byte("unknown export")) // NBD_REP_ERR_UNKNOWN
+		return
+	}
+	info := be.AppendUint16(nil, 0)
+	info = be.AppendUint64(info, uint64(len(export)))
+	info = be.AppendUint16(info, 1) // NBD_FLAG_HAS_FLAGS
+	reply(3, info)
+	reply(1, nil)
+
+	for {
+		var req struct {
+			Magic  uint32
+			Flags  uint16
+			Type   uint16
+			Handle uint64
+			Offset uint64
+			Length uint32
+		}
+		if binary.Read(conn, be, &req) != nil || req.Type != 0 {
+			return // NBD_CMD_DISC or a broken client
+		}
+		export, _ := s.export(name)
+		r := be.AppendUint32(nil, 0x67446698)
+		end := req.Offset + uint64(req.Length)
+		if end > uint64(len(export)) {
+			r = be.AppendUint32(r, 22) // EINVAL
+			r = be.AppendUint64(r, req.Handle)
+		} else {
+			r = be.AppendUint32(r, 0)
+			r = be.AppendUint64(r, req.Handle)
+			r = append(r, export[req.Offset:end]...)
+		}
+		if _, err := conn.Write(r); err != nil {
+			return
+		}
+	}
+}
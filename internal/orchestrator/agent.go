@@ -0,0 +1,419 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeAgent is a connection to the katamaran-agent of one node
+// (cmd/katamaran-agent). The agent is a long-lived privileged process
+// that runs `katamaran` migrations in-process, so a migration through
+// it needs no Job: no image pull, no module-loading init container, no
+// pod scheduling and no ttlSecondsAfterFinished clean-up. internal/agent
+// implements NodeAgent over gRPC with mutual TLS.
+//
+// A run is keyed by its MigrationID; args are the katamaran CLI
+// arguments the Job would have run it with.
+type NodeAgent interface {
+	// PrepareDest starts the destination side of migration id and
+	// returns once it logs KATAMARAN_DEST_READY, i.e. its listeners are
+	// up, or fails if the run exits first.
+	PrepareDest(ctx context.Context, id MigrationID, args []string, handoffKey string) error
+
+	// StartSource starts the source side of migration id.
+	StartSource(ctx context.Context, id MigrationID, args []string, handoffKey string) error
+
+	// Watch streams the KATAMARAN_* marker lines of run id, starting
+	// with line number from (0 is the first), then its exit. The channel
+	// is closed after the exit event, or early when the stream breaks or
+	// ctx ends. ErrUnknownID if the agent has no such run.
+	Watch(ctx context.Context, id MigrationID, from int) (<-chan AgentEvent, error)
+
+	// Cancel stops run id. Unknown and finished runs are not an error.
+	Cancel(ctx context.Context, id MigrationID) error
+
+	// Close releases the connection.
+	Close() error
+}
+
+// AgentEvent is one event of a NodeAgent.Watch stream: a marker line, or
+// the run's exit with the code `katamaran` would have exited with.
+type AgentEvent struct {
+	Line     string
+	Exited   bool
+	ExitCode int
+}
+
+// AgentDialer connects to the agent of a node. It returns an error when
+// the agent does not answer, and the migration then runs in Jobs.
+type AgentDialer func(ctx context.Context, node string) (NodeAgent, error)
+
+// agentOrchestrator runs migrations through the node agents and hands
+// whatever they cannot run to the Job-based native orchestrator:
+//
+//   - requests whose two sides talk through the source pod's log (cold
+//     migration, --compression auto, ReplayCmdline) or that probe the VM
+//     in a Job first (AllowColdFallback);
+//   - auto-select requests the picker cannot place, which need
+//     kube-scheduler to place the dest Job;
+//   - requests for a node whose agent does not answer.
+//
+// Destination slots and storage handoffs are shared with the native
+// orchestrator. A run's slot is held in memory for its lifetime, so
+// unlike a Job's annotations it is invisible to other orchestrator
+// processes, and a controller restart cannot recover an agent run.
+type agentOrchestrator struct {
+	native *native
+	dial   AgentDialer
+
+	mu       sync.Mutex
+	inflight map[MigrationID]*nativeRun
+}
+
+// WithAgents returns an Orchestrator that runs migrations on the node
+// agents dial reaches, falling back to o's Jobs. o must come from New,
+// NewFromKubeconfig or NewFromClient; anything else is returned as is.
+func WithAgents(o Orchestrator, dial AgentDialer) Orchestrator {
+	n, ok := o.(*native)
+	if !ok || dial == nil {
+		return o
+	}
+	return &agentOrchestrator{native: n, dial: dial, inflight: map[MigrationID]*nativeRun{}}
+}
+
+// jobsOnly returns why req needs migration Jobs, or "" when the agents
+// can run it.
+func jobsOnly(req Request) string {
+	switch {
+	case req.Cold:
+		return "cold migration"
+	case req.AllowColdFallback:
+		return "cold fallback preflight"
+	case req.ReplayCmdline:
+		return "cmdline replay"
+	case req.Compression == "auto":
+		return "compression auto"
+	}
+	return ""
+}
+
+// Apply starts the destination run, waits for its listeners, then starts
+// the source run. Status updates start immediately in a goroutine.
+func (a *agentOrchestrator) Apply(ctx context.Context, req Request) (MigrationID, error) {
+	if err := Validate(req); err != nil {
+		return "", err
+	}
+	if reason := jobsOnly(req); reason != "" {
+		slog.Info("Migration runs in Jobs; the node agents do not support it", "reason", reason)
+		return a.native.Apply(ctx, req)
+	}
+
+	id := newID()
+	var placement *Placement
+	if req.DestNode == "" {
+		p, err := a.native.placeDestNode(ctx, id, req, nil)
+		if err != nil {
+			return "", fmt.Errorf("place destination: %w", err)
+		}
+		if p == nil {
+			// Only kube-scheduler can place it, and it places Jobs.
+			slog.Info("Migration runs in Jobs; no destination node was picked", "migration_id", id)
+			return a.native.Apply(ctx, req)
+		}
+		placement = p
+		req.DestNode = p.Node
+		req.DestIP = p.InternalIP
+	}
+
+	src, err := a.dial(ctx, req.SourceNode)
+	if err != nil {
+		slog.Warn("Source node agent unavailable; migration runs in Jobs", "migration_id", id, "node", req.SourceNode, "error", err)
+		return a.native.Apply(ctx, req)
+	}
+	dest, err := a.dial(ctx, req.DestNode)
+	if err != nil {
+		_ = src.Close()
+		slog.Warn("Destination node agent unavailable; migration runs in Jobs", "migration_id", id, "node", req.DestNode, "error", err)
+		return a.native.Apply(ctx, req)
+	}
+
+	slot, release, err := a.native.reserveDestSlot(ctx, id, req)
+	if err != nil {
+		_ = src.Close()
+		_ = dest.Close()
+		return "", err
+	}
+	submitted := false
+	defer func() {
+		if !submitted {
+			release()
+			_ = src.Close()
+			_ = dest.Close()
+		}
+	}()
+	var handoff *storageHandoff
+	if req.StorageHandoff {
+		handoff, err = a.native.prepareStorageHandoff(ctx, id, req)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if !submitted {
+				a.native.rollbackStorageHandoff(context.WithoutCancel(ctx), id, handoff)
+			}
+		}()
+	}
+	// The key never leaves the orchestrator and the two agents, so it
+	// needs no Secret.
+	handoffKey, err := newHandoffKey()
+	if err != nil {
+		return "", err
+	}
+
+	extra := strings.Fields(slot.extraArgs(req))
+	destArgs := append([]string{"--mode", "dest", "--qmp", cmp.Or(req.DestQMP, "/run/vc/vm/katamaran-dest/qmp.sock")}, extra...)
+	srcArgs := append([]string{"--mode", "source", "--dest-ip", req.DestIP}, extra...)
+	if err := dest.PrepareDest(ctx, id, destArgs, handoffKey); err != nil {
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.DestNode, dest)
+		return "", fmt.Errorf("prepare destination on %s: %w", req.DestNode, err)
+	}
+	if err := src.StartSource(ctx, id, srcArgs, handoffKey); err != nil {
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.DestNode, dest)
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.SourceNode, src)
+		return "", fmt.Errorf("start source on %s: %w", req.SourceNode, err)
+	}
+	submitted = true
+	slog.Info("Migration started on node agents", "migration_id", id, "source_node", req.SourceNode, "dest_node", req.DestNode)
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &nativeRun{
+		updates:  make(chan StatusUpdate, 8),
+		cancel:   cancel,
+		finished: make(chan struct{}),
+		handoff:  handoff,
+	}
+	a.mu.Lock()
+	a.inflight[id] = run
+	a.mu.Unlock()
+
+	submittedUpdate := StatusUpdate{ID: id, Phase: PhaseSubmitted, When: time.Now(), Placement: placement}
+	if handoff != nil && len(handoff.volumes) > 0 {
+		submittedUpdate.VolumeHandoffs = slices.Clone(handoff.volumes)
+	}
+	run.updates <- submittedUpdate
+
+	go func() {
+		defer func() {
+			a.mu.Lock()
+			delete(a.inflight, id)
+			a.mu.Unlock()
+			release()
+		}()
+		p := agentPair{src: src, dest: dest, srcNode: req.SourceNode, destNode: req.DestNode}
+		superviseAgents(runCtx, id, run, p, func(u *StatusUpdate) { a.native.settleStorageHandoff(runCtx, id, run, u) })
+	}()
+	return id, nil
+}
+
+// agentPair is the two agents of one migration.
+type agentPair struct {
+	src, dest         NodeAgent
+	srcNode, destNode string
+}
+
+// agentLine is an AgentEvent of either side.
+type agentLine struct {
+	dest bool
+	AgentEvent
+}
+
+// superviseAgents follows both runs of p, emits StatusUpdates on run and
+// closes the agents when done. As with the Jobs, the destination decides
+// the outcome: it exits 0 only once the VM runs there. A failed source is
+// given sourceFailGrace for the destination to finish anyway. finalize,
+// if set, amends the terminal update before it is sent.
+func superviseAgents(ctx context.Context, id MigrationID, run *nativeRun, p agentPair, finalize func(*StatusUpdate)) {
+	defer func() {
+		close(run.finished)
+		run.closeOnce.Do(func() { close(run.updates) })
+		run.cancel() // stops the followers
+		_ = p.src.Close()
+		_ = p.dest.Close()
+	}()
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("supervise panic", "migration_id", id, "panic", rec, "stack", string(debug.Stack()))
+		}
+	}()
+	events := make(chan agentLine)
+	go followAgent(ctx, id, p.src, false, events)
+	go followAgent(ctx, id, p.dest, true, events)
+
+	finish := func(u StatusUpdate) {
+		if finalize != nil {
+			finalize(&u)
+		}
+		run.updates <- u
+	}
+	cancelBoth := func() {
+		cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		cancelAgentRun(cctx, id, p.srcNode, p.src)
+		cancelAgentRun(cctx, id, p.destNode, p.dest)
+	}
+
+	run.updates <- StatusUpdate{ID: id, Phase: PhaseTransferring, When: time.Now()}
+	var (
+		hooks         []HookResult
+		resumedAt     time.Time
+		destSandboxID string
+		sourceDone    bool
+		grace         <-chan time.Time
+		// settle is set once the destination succeeded: the source's
+		// KATAMARAN_RESULT may still be on its way.
+		settle <-chan time.Time
+	)
+	succeeded := func() {
+		slog.Info("Migration destination completed", "migration_id", id, "node", p.destNode)
+		u, _ := run.succeededUpdate(id)
+		u.VMResumedAt = resumedAt
+		u.DestSandboxID = destSandboxID
+		u.Hooks = hooks
+		finish(u)
+	}
+	for {
+		if settle != nil && sourceDone {
+			succeeded()
+			return
+		}
+		select {
+		case <-settle:
+			succeeded()
+			return
+		case <-ctx.Done():
+			slog.Warn("Migration canceled", "migration_id", id, "error", ctx.Err())
+			cancelBoth()
+			finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: ctx.Err()})
+			return
+		case <-grace:
+			slog.Error("Migration source failed and destination did not complete", "migration_id", id, "grace", sourceFailGrace)
+			cancelBoth()
+			finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: errors.New("source failed and dest did not complete within grace window"), Hooks: hooks})
+			return
+		case ev := <-events:
+			switch {
+			case ev.dest && ev.Exited:
+				if ev.ExitCode != 0 {
+					slog.Error("Migration destination failed", "migration_id", id, "node", p.destNode, "exit_code", ev.ExitCode)
+					cancelBoth()
+					finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: fmt.Errorf("dest exited with code %d", ev.ExitCode), Hooks: hooks})
+					return
+				}
+				grace = nil
+				settle = time.After(5 * time.Second)
+			case ev.dest:
+				line := ev.Line
+				if i := strings.Index(line, vmResumedMarker); i >= 0 {
+					resumedAt = unixMillis(parseProgressFields(line[i+len(vmResumedMarker):])["at_unix_ms"])
+				} else if i := strings.Index(line, destReadyMarker); i >= 0 {
+					destSandboxID = parseProgressFields(line[i+len(destReadyMarker):])["sandbox_id"]
+				} else if i := strings.Index(line, hookMarker); i >= 0 {
+					hooks = append(hooks, parseHookMarker(line[i+len(hookMarker):]))
+				}
+			case ev.Exited:
+				sourceDone = true
+				if ev.ExitCode != 0 && grace == nil && settle == nil {
+					slog.Warn("Migration source failed; waiting for destination grace window", "migration_id", id, "node", p.srcNode, "exit_code", ev.ExitCode, "grace", sourceFailGrace)
+					grace = time.After(sourceFailGrace)
+				}
+			case !sourceDone:
+				_, sourceDone = run.sourceMarker(ctx, id, ev.Line)
+			}
+		}
+	}
+}
+
+// followAgent forwards the Watch stream of run id on agent to events
+// until the run exits or ctx ends. A broken stream is reopened at the
+// first line not yet forwarded; a run the agent does not know (it
+// restarted) counts as exited with code -1.
+func followAgent(ctx context.Context, id MigrationID, agent NodeAgent, dest bool, events chan<- agentLine) {
+	forward := func(ev AgentEvent) bool {
+		select {
+		case events <- agentLine{dest: dest, AgentEvent: ev}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	from := 0
+	for {
+		stream, err := agent.Watch(ctx, id, from)
+		switch {
+		case errors.Is(err, ErrUnknownID):
+			slog.Warn("Node agent lost the migration run", "migration_id", id, "dest", dest)
+			forward(AgentEvent{Exited: true, ExitCode: -1})
+			return
+		case err != nil:
+			if ctx.Err() == nil {
+				slog.Debug("Watching node agent failed, will retry", "migration_id", id, "dest", dest, "error", err)
+			}
+		default:
+			for ev := range stream {
+				if !forward(ev) || ev.Exited {
+					return
+				}
+				from++
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// cancelAgentRun cancels run id on agent, logging a failure.
+func cancelAgentRun(ctx context.Context, id MigrationID, node string, agent NodeAgent) {
+	if err := agent.Cancel(ctx, id); err != nil {
+		slog.Warn("Cancelling node agent run failed; it may keep running", "migration_id", id, "node", node, "error", err)
+	}
+}
+
+// Watch returns the updates of an agent run, or of a Job migration.
+func (a *agentOrchestrator) Watch(ctx context.Context, id MigrationID) (<-chan StatusUpdate, error) {
+	a.mu.Lock()
+	run, ok := a.inflight[id]
+	a.mu.Unlock()
+	if !ok {
+		return a.native.Watch(ctx, id)
+	}
+	return run.updates, nil
+}
+
+// Stop cancels an agent run on both nodes, or deletes a migration's Jobs.
+func (a *agentOrchestrator) Stop(ctx context.Context, id MigrationID) error {
+	a.mu.Lock()
+	run, ok := a.inflight[id]
+	a.mu.Unlock()
+	if !ok {
+		return a.native.Stop(ctx, id)
+	}
+	run.cancel()
+	return nil
+}
+
+// Resume only concerns ReplayCmdline migrations, which always run in
+// Jobs.
+func (a *agentOrchestrator) Resume(ctx context.Context, id MigrationID, req Request) (bool, error) {
+	return a.native.Resume(ctx, id, req)
+}
@@ -0,0 +1,277 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeAgent is a NodeAgent whose runs replay canned events.
+type fakeAgent struct {
+	events     []AgentEvent // what Watch replays
+	prepareErr error
+	fresh      bool // Watch fails with ErrUnknownID until a run starts
+
+	mu        sync.Mutex
+	args      []string
+	key       string
+	cancelled bool
+	closed    bool
+}
+
+func (f *fakeAgent) PrepareDest(_ context.Context, _ MigrationID, args []string, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.args, f.key = args, key
+	return f.prepareErr
+}
+
+func (f *fakeAgent) StartSource(_ context.Context, _ MigrationID, args []string, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.args, f.key = args, key
+	return nil
+}
+
+func (f *fakeAgent) Watch(ctx context.Context, id MigrationID, from int) (<-chan AgentEvent, error) {
+	if args, _, _, _ := f.state(); f.fresh && args == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownID, id)
+	}
+	ch := make(chan AgentEvent)
+	go func() {
+		defer close(ch)
+		for _, ev := range f.events[min(from, len(f.events)):] {
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(f.events) == 0 || !f.events[len(f.events)-1].Exited {
+			<-ctx.Done() // still running
+		}
+	}()
+	return ch, nil
+}
+
+func (f *fakeAgent) Cancel(context.Context, MigrationID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled = true
+	return nil
+}
+
+func (f *fakeAgent) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeAgent) state() (args []string, key string, cancelled, closed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.args), f.key, f.cancelled, f.closed
+}
+
+func dialFakes(agents map[string]*fakeAgent) AgentDialer {
+	return func(_ context.Context, node string) (NodeAgent, error) {
+		if a, ok := agents[node]; ok {
+			return a, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+}
+
+func lines(ls ...string) []AgentEvent {
+	out := make([]AgentEvent, 0, len(ls)+1)
+	for _, l := range ls {
+		out = append(out, AgentEvent{Line: l})
+	}
+	return out
+}
+
+func exited(events []AgentEvent, code int) []AgentEvent {
+	return append(events, AgentEvent{Exited: true, ExitCode: code})
+}
+
+func countJobs(t *testing.T, cs *fake.Clientset) int {
+	t.Helper()
+	jobs, err := cs.BatchV1().Jobs(DefaultJobNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	return len(jobs.Items)
+}
+
+func TestAgent_Apply_RunsOnAgents(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	src := &fakeAgent{events: exited(lines(
+		"KATAMARAN_DOWNTIME_LIMIT applied_ms=25 rtt_ms=1 auto=false",
+		"KATAMARAN_PROGRESS status=active ram_transferred=100 ram_total=200 ram_remaining=100",
+		"KATAMARAN_VM_STOPPED at_unix_ms=1700000000000",
+		"KATAMARAN_RESULT downtime_ms=18 total_time_ms=900 ram_transferred=200 ram_total=200 memory_saved=0 compression=none compression_ratio=0.00",
+	), 0)}
+	dest := &fakeAgent{events: exited(lines(
+		"KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809",
+		"KATAMARAN_VM_RESUMED at_unix_ms=1700000000020",
+	), 0)}
+	o := WithAgents(NewFromClient(cs), dialFakes(map[string]*fakeAgent{"n1": src, "n2": dest}))
+
+	id, err := o.Apply(context.Background(), validRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	updates, err := o.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	if n := countJobs(t, cs); n != 0 {
+		t.Fatalf("created %d jobs, want none", n)
+	}
+	if len(got) < 2 || got[0].Phase != PhaseSubmitted {
+		t.Fatalf("updates = %+v, want PhaseSubmitted first", got)
+	}
+	last := got[len(got)-1]
+	if last.Phase != PhaseSucceeded || last.DowntimeMS != 18 || last.AppliedDowntimeMS != 25 ||
+		last.VMStoppedAt.UnixMilli() != 1700000000000 || last.VMResumedAt.UnixMilli() != 1700000000020 {
+		t.Fatalf("final update = %+v", last)
+	}
+
+	srcArgs, srcKey, _, srcClosed := src.state()
+	destArgs, destKey, _, destClosed := dest.state()
+	for _, want := range []string{"--mode source", "--dest-ip 10.0.0.20", "--pod-name vm-a", "--migration-port 4444"} {
+		if !strings.Contains(strings.Join(srcArgs, " "), want) {
+			t.Errorf("source args %q missing %q", srcArgs, want)
+		}
+	}
+	for _, want := range []string{"--mode dest", "--qmp /run/vc/vm/katamaran-dest/qmp.sock", "--nbd-port 10809"} {
+		if !strings.Contains(strings.Join(destArgs, " "), want) {
+			t.Errorf("dest args %q missing %q", destArgs, want)
+		}
+	}
+	if len(srcKey) != 2*handoffKeyBytes || srcKey != destKey {
+		t.Errorf("hand-off keys = %q / %q, want one %d-byte hex key", srcKey, destKey, handoffKeyBytes)
+	}
+	if !srcClosed || !destClosed {
+		t.Errorf("agent connections closed = %t/%t, want both", srcClosed, destClosed)
+	}
+}
+
+func TestAgent_Apply_FallsBackToJobs(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		mutate func(*Request)
+		agents map[string]*fakeAgent
+	}{
+		{"cold", func(r *Request) { r.Cold = true }, map[string]*fakeAgent{"n1": {}, "n2": {}}},
+		{"compression auto", func(r *Request) { r.Compression = "auto"; r.MultifdChannels = 2 }, map[string]*fakeAgent{"n1": {}, "n2": {}}},
+		{"dest agent unreachable", func(*Request) {}, map[string]*fakeAgent{"n1": {}}},
+	} {
+		cs := fake.NewSimpleClientset()
+		o := WithAgents(NewFromClient(cs), dialFakes(tc.agents))
+		req := validRequest()
+		tc.mutate(&req)
+		if _, err := o.Apply(context.Background(), req); err != nil {
+			t.Fatalf("%s: Apply: %v", tc.name, err)
+		}
+		if n := countJobs(t, cs); n != 2 {
+			t.Errorf("%s: created %d jobs, want the 2 migration jobs", tc.name, n)
+		}
+		for node, a := range tc.agents {
+			if args, _, _, _ := a.state(); args != nil {
+				t.Errorf("%s: agent on %s ran %q", tc.name, node, args)
+			}
+		}
+	}
+}
+
+func TestAgent_DestFailureCancelsSource(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{events: lines("KATAMARAN_PROGRESS status=active ram_transferred=1 ram_total=2 ram_remaining=1")}
+	dest := &fakeAgent{events: exited(lines("KATAMARAN_HOOK phase=post-resume name=warm result=failed duration_ms=3 error=boom"), 1)}
+	o := WithAgents(NewFromClient(fake.NewSimpleClientset()), dialFakes(map[string]*fakeAgent{"n1": src, "n2": dest}))
+
+	id, err := o.Apply(context.Background(), validRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	updates, err := o.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	last := got[len(got)-1]
+	if last.Phase != PhaseFailed || last.Error == nil || !strings.Contains(last.Error.Error(), "code 1") {
+		t.Fatalf("final update = %+v, want dest failure", last)
+	}
+	if len(last.Hooks) != 1 || last.Hooks[0].Name != "warm" {
+		t.Errorf("hooks = %+v, want the post-resume hook", last.Hooks)
+	}
+	if _, _, cancelled, _ := src.state(); !cancelled {
+		t.Error("source run was not cancelled")
+	}
+}
+
+func TestAgent_PrepareDestFailure(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{}
+	dest := &fakeAgent{prepareErr: errors.New("dest exited with code 2")}
+	o := WithAgents(NewFromClient(fake.NewSimpleClientset()), dialFakes(map[string]*fakeAgent{"n1": src, "n2": dest}))
+
+	if _, err := o.Apply(context.Background(), validRequest()); err == nil || !strings.Contains(err.Error(), "prepare destination on n2") {
+		t.Fatalf("Apply error = %v, want the prepare failure", err)
+	}
+	if args, _, _, closed := src.state(); args != nil || !closed {
+		t.Errorf("source agent ran %q (closed %t), want it closed unused", args, closed)
+	}
+	if _, _, cancelled, closed := dest.state(); !cancelled || !closed {
+		t.Errorf("dest run cancelled %t, closed %t; want both", cancelled, closed)
+	}
+	// The slot is free again.
+	if _, err := o.Apply(context.Background(), validRequest()); err == nil || errors.Is(err, ErrConflict) {
+		t.Fatalf("second Apply error = %v, want the prepare failure again", err)
+	}
+}
+
+func TestAgent_Stop(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{}
+	dest := &fakeAgent{events: lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809")}
+	o := WithAgents(NewFromClient(fake.NewSimpleClientset()), dialFakes(map[string]*fakeAgent{"n1": src, "n2": dest}))
+
+	id, err := o.Apply(context.Background(), validRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	updates, err := o.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := o.Stop(context.Background(), id); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	if last := got[len(got)-1]; last.Phase != PhaseFailed || !errors.Is(last.Error, context.Canceled) {
+		t.Fatalf("final update = %+v, want canceled", last)
+	}
+	for name, a := range map[string]*fakeAgent{"source": src, "dest": dest} {
+		if _, _, cancelled, _ := a.state(); !cancelled {
+			t.Errorf("%s run was not cancelled", name)
+		}
+	}
+	if _, err := o.Watch(context.Background(), id); !errors.Is(err, ErrUnknownID) {
+		t.Fatalf("Watch after the end = %v, want ErrUnknownID", err)
+	}
+}
@@ -0,0 +1,334 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// direct runs migrations between two hosts without Kubernetes: the
+// destination and source `katamaran` runs are started through the
+// NodeAgent of each host (internal/agent provides ones over SSH and over
+// the katamaran-agent gRPC API) and their stdout markers become the
+// StatusUpdates. Request.SourceNode and DestNode name hosts of the
+// dialer's inventory.
+//
+// Without an apiserver there is nothing to resolve pods, read pod logs
+// or hand volumes over with, so only the legacy explicit mode
+// (SourceQMP + VMIP, DestQMP) is supported, and nothing that passes data
+// between the two sides through the source pod's log. One migration
+// runs per destination host at a time, on the default ports.
+type direct struct {
+	dial AgentDialer
+
+	mu       sync.Mutex
+	inflight map[MigrationID]*directRun
+	// unwatched holds the final update of runs that ended before anyone
+	// watched them, for the first Watch; the oldest of more than
+	// directUnwatchedMax are dropped.
+	unwatched      map[MigrationID]StatusUpdate
+	unwatchedOrder []MigrationID
+}
+
+// directUnwatchedMax bounds direct.unwatched.
+const directUnwatchedMax = 32
+
+// directRun is a migration in flight on two hosts.
+type directRun struct {
+	*nativeRun
+	req     Request
+	watched bool
+}
+
+// NewDirect returns an Orchestrator that runs migrations on the hosts
+// dial reaches.
+func NewDirect(dial AgentDialer) Orchestrator {
+	return &direct{dial: dial, inflight: map[MigrationID]*directRun{}, unwatched: map[MigrationID]StatusUpdate{}}
+}
+
+// ValidateDirect checks req for the direct orchestrator: Validate, plus
+// the restrictions of running without Kubernetes. Image is not used and
+// may be empty.
+func ValidateDirect(req Request) error {
+	if req.Image == "" {
+		req.Image = "unused"
+	}
+	if err := Validate(req); err != nil {
+		return err
+	}
+	if req.DestQMP == "" {
+		return errors.New("destQMP is required")
+	}
+	var unsupported []string
+	for _, c := range []struct {
+		set  bool
+		name string
+	}{
+		{req.SourcePod != nil, "sourcePod"},
+		{req.DestPod != nil, "destPod"},
+		{req.ReplayCmdline, "replayCmdline"},
+		{req.Cold, "cold"},
+		{req.AllowColdFallback, "allowColdFallback"},
+		{req.StorageHandoff, "storageHandoff"},
+		{req.Compression == "auto", "compression auto"},
+		{req.SourceCleanup != "" && req.SourceCleanup != "none", "sourceCleanup"},
+		{req.AdoptVM, "adoptVM"},
+		{!req.JobOverrides.isZero(), "jobOverrides"},
+	} {
+		if c.set {
+			unsupported = append(unsupported, c.name)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("not supported without Kubernetes: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// directArgs returns the destination and source `katamaran` arguments
+// for req.
+func directArgs(req Request) (destArgs, srcArgs []string) {
+	extra := strings.Fields(buildExtraArgs(req))
+	destArgs = append([]string{"--mode", "dest", "--qmp", req.DestQMP}, extra...)
+	srcArgs = append([]string{"--mode", "source", "--qmp", req.SourceQMP, "--vm-ip", req.VMIP, "--dest-ip", req.DestIP}, extra...)
+	return destArgs, srcArgs
+}
+
+// Apply starts the destination run, waits for its listeners, then starts
+// the source run. Status updates start immediately in a goroutine.
+func (d *direct) Apply(ctx context.Context, req Request) (MigrationID, error) {
+	if err := ValidateDirect(req); err != nil {
+		return "", err
+	}
+	id := newID()
+	if err := d.reserve(id, req); err != nil {
+		return "", err
+	}
+	if err := d.start(ctx, id, req); err != nil {
+		d.forget(id)
+		return "", err
+	}
+	return id, nil
+}
+
+// reserve records id as in flight, failing with ErrConflict while
+// another migration uses the same VM or destination host.
+func (d *direct) reserve(id MigrationID, req Request) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for other, r := range d.inflight {
+		switch {
+		case r.req.DestNode == req.DestNode:
+			return fmt.Errorf("%w: migration %s already targets host %s", ErrConflict, other, req.DestNode)
+		case r.req.SourceNode == req.SourceNode && r.req.SourceQMP == req.SourceQMP:
+			return fmt.Errorf("%w: migration %s already migrates %s on %s", ErrConflict, other, req.SourceQMP, req.SourceNode)
+		}
+	}
+	d.inflight[id] = &directRun{req: req}
+	return nil
+}
+
+func (d *direct) forget(id MigrationID) {
+	d.mu.Lock()
+	delete(d.inflight, id)
+	d.mu.Unlock()
+}
+
+// start runs both sides of migration id, which reserve has recorded.
+func (d *direct) start(ctx context.Context, id MigrationID, req Request) error {
+	src, dest, err := d.dialPair(ctx, req)
+	if err != nil {
+		return err
+	}
+	// As with the node agents, the key only travels to the two hosts.
+	handoffKey, err := newHandoffKey()
+	if err != nil {
+		_ = src.Close()
+		_ = dest.Close()
+		return err
+	}
+	destArgs, srcArgs := directArgs(req)
+	if err := dest.PrepareDest(ctx, id, destArgs, handoffKey); err != nil {
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.DestNode, dest)
+		_ = src.Close()
+		_ = dest.Close()
+		return fmt.Errorf("prepare destination on %s: %w", req.DestNode, err)
+	}
+	if err := src.StartSource(ctx, id, srcArgs, handoffKey); err != nil {
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.DestNode, dest)
+		cancelAgentRun(context.WithoutCancel(ctx), id, req.SourceNode, src)
+		_ = src.Close()
+		_ = dest.Close()
+		return fmt.Errorf("start source on %s: %w", req.SourceNode, err)
+	}
+	slog.Info("Migration started on hosts", "migration_id", id, "source_host", req.SourceNode, "dest_host", req.DestNode)
+	d.follow(id, req, agentPair{src: src, dest: dest, srcNode: req.SourceNode, destNode: req.DestNode})
+	return nil
+}
+
+func (d *direct) dialPair(ctx context.Context, req Request) (src, dest NodeAgent, err error) {
+	src, err = d.dial(ctx, req.SourceNode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to source host %s: %w", req.SourceNode, err)
+	}
+	dest, err = d.dial(ctx, req.DestNode)
+	if err != nil {
+		_ = src.Close()
+		return nil, nil, fmt.Errorf("connect to destination host %s: %w", req.DestNode, err)
+	}
+	return src, dest, nil
+}
+
+// follow supervises the runs of p in a goroutine.
+func (d *direct) follow(id MigrationID, req Request, p agentPair) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &nativeRun{
+		updates:  make(chan StatusUpdate, 8),
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+	d.mu.Lock()
+	d.inflight[id] = &directRun{nativeRun: run, req: req}
+	d.mu.Unlock()
+	run.updates <- StatusUpdate{ID: id, Phase: PhaseSubmitted, When: time.Now()}
+	go func() {
+		defer d.finished(id)
+		superviseAgents(ctx, id, run, p, nil)
+	}()
+}
+
+// finished drops migration id, whose updates channel is closed, from
+// the runs in flight. When nobody watched it, its final update is kept
+// for the first Watch.
+func (d *direct) finished(id MigrationID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.inflight[id]
+	delete(d.inflight, id)
+	if r == nil || r.watched {
+		return
+	}
+	var last StatusUpdate
+	for u := range r.updates {
+		last = u
+	}
+	d.unwatched[id] = last
+	d.unwatchedOrder = append(d.unwatchedOrder, id)
+	if len(d.unwatchedOrder) > directUnwatchedMax {
+		delete(d.unwatched, d.unwatchedOrder[0])
+		d.unwatchedOrder = d.unwatchedOrder[1:]
+	}
+}
+
+// running returns migration id if it is being supervised.
+func (d *direct) running(id MigrationID) (*nativeRun, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.inflight[id]
+	if !ok || r.nativeRun == nil {
+		return nil, false
+	}
+	return r.nativeRun, true
+}
+
+// Watch returns the updates of migration id, or only its final update
+// when it ended before the first Watch. ErrUnknownID once it ended and
+// was watched.
+func (d *direct) Watch(_ context.Context, id MigrationID) (<-chan StatusUpdate, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.inflight[id]; ok && r.nativeRun != nil {
+		r.watched = true
+		return r.updates, nil
+	}
+	last, ok := d.unwatched[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownID, id)
+	}
+	delete(d.unwatched, id)
+	d.unwatchedOrder = slices.DeleteFunc(d.unwatchedOrder, func(other MigrationID) bool { return other == id })
+	ch := make(chan StatusUpdate, 1)
+	ch <- last
+	close(ch)
+	return ch, nil
+}
+
+// Stop cancels migration id on both hosts.
+func (d *direct) Stop(_ context.Context, id MigrationID) error {
+	d.mu.Lock()
+	r, ok := d.inflight[id]
+	d.mu.Unlock()
+	if !ok || r.nativeRun == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownID, id)
+	}
+	r.cancel()
+	return nil
+}
+
+// Resume re-attaches to migration id after the orchestrator lost track
+// of it, e.g. a restarted katamaran-orchestrator. When the destination
+// host still has the run (agent hosts keep runs after the orchestrator
+// goes away), both runs are followed again from their first line and
+// (false, nil) is returned. When neither host knows it, the migration is
+// started afresh under id and (true, nil) is returned. A source run
+// without a destination run is an error.
+func (d *direct) Resume(ctx context.Context, id MigrationID, req Request) (bool, error) {
+	if err := ValidateDirect(req); err != nil {
+		return false, err
+	}
+	if _, ok := d.running(id); ok {
+		return false, nil
+	}
+	if err := d.reserve(id, req); err != nil {
+		return false, err
+	}
+	src, dest, err := d.dialPair(ctx, req)
+	if err != nil {
+		d.forget(id)
+		return false, err
+	}
+	destKnown, err := knowsRun(ctx, dest, id)
+	if err == nil && !destKnown {
+		var srcKnown bool
+		if srcKnown, err = knowsRun(ctx, src, id); err == nil && srcKnown {
+			err = fmt.Errorf("source host %s has migration %s but destination host %s does not", req.SourceNode, id, req.DestNode)
+		}
+	}
+	if err != nil {
+		_ = src.Close()
+		_ = dest.Close()
+		d.forget(id)
+		return false, err
+	}
+	if destKnown {
+		slog.Info("Re-attached to migration on hosts", "migration_id", id, "source_host", req.SourceNode, "dest_host", req.DestNode)
+		d.follow(id, req, agentPair{src: src, dest: dest, srcNode: req.SourceNode, destNode: req.DestNode})
+		return false, nil
+	}
+	_ = src.Close()
+	_ = dest.Close()
+	if err := d.start(ctx, id, req); err != nil {
+		d.forget(id)
+		return false, err
+	}
+	return true, nil
+}
+
+// knowsRun reports whether agent has a run for id.
+func knowsRun(ctx context.Context, agent NodeAgent, id MigrationID) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	_, err := agent.Watch(ctx, id, 0)
+	switch {
+	case errors.Is(err, ErrUnknownID):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,282 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func directRequest() Request {
+	return Request{
+		SourceNode: "host-a",
+		DestNode:   "host-b",
+		DestIP:     "10.0.0.20",
+		SourceQMP:  "/run/vc/vm/src/extra-monitor.sock",
+		DestQMP:    "/run/vc/vm/dst/extra-monitor.sock",
+		VMIP:       "10.244.1.5",
+	}
+}
+
+func TestDirect_Apply_RunsOnHosts(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{events: exited(lines(
+		"KATAMARAN_RESULT downtime_ms=18 total_time_ms=900 ram_transferred=200 ram_total=200 memory_saved=0 compression=none compression_ratio=0.00",
+	), 0)}
+	dest := &fakeAgent{events: exited(lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"), 0)}
+	o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": src, "host-b": dest}))
+
+	req := directRequest()
+	req.SharedStorage = true
+	id, err := o.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	updates, err := o.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	if len(got) < 2 || got[0].Phase != PhaseSubmitted {
+		t.Fatalf("updates = %+v, want PhaseSubmitted first", got)
+	}
+	if last := got[len(got)-1]; last.Phase != PhaseSucceeded || last.DowntimeMS != 18 {
+		t.Fatalf("final update = %+v", last)
+	}
+
+	srcArgs, srcKey, _, srcClosed := src.state()
+	destArgs, destKey, _, destClosed := dest.state()
+	if want := "--mode source --qmp /run/vc/vm/src/extra-monitor.sock --vm-ip 10.244.1.5 --dest-ip 10.0.0.20 --shared-storage"; !strings.HasPrefix(strings.Join(srcArgs, " "), want) {
+		t.Errorf("source args = %q, want prefix %q", srcArgs, want)
+	}
+	if want := "--mode dest --qmp /run/vc/vm/dst/extra-monitor.sock --shared-storage"; !strings.HasPrefix(strings.Join(destArgs, " "), want) {
+		t.Errorf("dest args = %q, want prefix %q", destArgs, want)
+	}
+	if len(srcKey) != 2*handoffKeyBytes || srcKey != destKey {
+		t.Errorf("hand-off keys = %q / %q, want the same per-migration key on both hosts", srcKey, destKey)
+	}
+	if !srcClosed || !destClosed {
+		t.Errorf("host connections closed = %t/%t, want both", srcClosed, destClosed)
+	}
+	if _, err := o.Watch(context.Background(), id); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("Watch after the end = %v, want ErrUnknownID", err)
+	}
+}
+
+func TestValidateDirect(t *testing.T) {
+	t.Parallel()
+	if err := ValidateDirect(directRequest()); err != nil {
+		t.Fatalf("ValidateDirect of a valid request: %v", err)
+	}
+	for _, tc := range []struct {
+		name   string
+		mutate func(*Request)
+		want   string
+	}{
+		{"no destQMP", func(r *Request) { r.DestQMP = "" }, "destQMP is required"},
+		{"no VM IP", func(r *Request) { r.VMIP = "" }, ""},
+		{"pods", func(r *Request) {
+			r.SourceQMP, r.VMIP = "", ""
+			r.SourcePod = &PodRef{Namespace: "default", Name: "vm"}
+		}, "not supported without Kubernetes: sourcePod"},
+		{"cold and replay", func(r *Request) { r.Cold, r.ReplayCmdline = true, true }, "replayCmdline, cold"},
+		{"storage handoff", func(r *Request) { r.StorageHandoff = true }, "storageHandoff"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := directRequest()
+			tc.mutate(&req)
+			err := ValidateDirect(req)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("ValidateDirect error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDirect_ConflictAndStop(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{}
+	dest := &fakeAgent{events: lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809")}
+	o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": src, "host-b": dest, "host-c": {}}))
+	ctx := context.Background()
+
+	id, err := o.Apply(ctx, directRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if _, err := o.Apply(ctx, directRequest()); !errors.Is(err, ErrConflict) {
+		t.Errorf("second Apply to the same host = %v, want ErrConflict", err)
+	}
+	other := directRequest()
+	other.SourceNode, other.DestNode = "host-c", "host-a"
+	if _, err := o.Apply(ctx, other); err != nil {
+		t.Errorf("Apply of another VM: %v", err)
+	}
+
+	updates, err := o.Watch(ctx, id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := o.Stop(ctx, id); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	if last := got[len(got)-1]; last.Phase != PhaseFailed {
+		t.Fatalf("final update = %+v, want PhaseFailed", last)
+	}
+	_, _, srcCancelled, _ := src.state()
+	_, _, destCancelled, _ := dest.state()
+	if !srcCancelled || !destCancelled {
+		t.Errorf("cancelled = %t/%t, want both", srcCancelled, destCancelled)
+	}
+	if err := o.Stop(ctx, "nope"); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("Stop unknown = %v, want ErrUnknownID", err)
+	}
+}
+
+// TestDirect_WatchAfterFastFailure covers a run that ends before the
+// first Watch: its final update is kept for that Watch.
+func TestDirect_WatchAfterFastFailure(t *testing.T) {
+	t.Parallel()
+	src := &fakeAgent{}
+	dest := &fakeAgent{events: exited(lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"), 1)}
+	o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": src, "host-b": dest}))
+
+	id, err := o.Apply(context.Background(), directRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, _, _, closed := src.state(); closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run did not end")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// A finished run does not hold the hosts.
+	if _, err := o.Apply(context.Background(), directRequest()); err != nil {
+		t.Errorf("Apply after the end: %v", err)
+	}
+	updates, err := o.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	got := drainUpdates(updates, 10*time.Second)
+	if last := got[len(got)-1]; last.Phase != PhaseFailed {
+		t.Fatalf("final update = %+v, want PhaseFailed", last)
+	}
+}
+
+// TestDirect_UnwatchedRunsAreBounded covers runs that end without ever
+// being watched: they leave the runs in flight, and only the final
+// updates of the newest directUnwatchedMax are kept.
+func TestDirect_UnwatchedRunsAreBounded(t *testing.T) {
+	t.Parallel()
+	o := NewDirect(func(_ context.Context, node string) (NodeAgent, error) {
+		if node == "host-b" {
+			return &fakeAgent{events: exited(lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"), 1)}, nil
+		}
+		return &fakeAgent{}, nil
+	}).(*direct)
+	ctx := context.Background()
+
+	var ids []MigrationID
+	for range directUnwatchedMax + 3 {
+		id, err := o.Apply(ctx, directRequest())
+		if err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+		ids = append(ids, id)
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			o.mu.Lock()
+			_, running := o.inflight[id]
+			o.mu.Unlock()
+			if !running {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("run did not end")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	o.mu.Lock()
+	inflight, kept := len(o.inflight), len(o.unwatched)
+	o.mu.Unlock()
+	if inflight != 0 || kept != directUnwatchedMax {
+		t.Fatalf("after the runs: %d in flight, %d kept; want 0 and %d", inflight, kept, directUnwatchedMax)
+	}
+	if _, err := o.Watch(ctx, ids[0]); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("Watch of an evicted run = %v, want ErrUnknownID", err)
+	}
+	last := ids[len(ids)-1]
+	updates, err := o.Watch(ctx, last)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := drainUpdates(updates, 10*time.Second); len(got) != 1 || got[0].Phase != PhaseFailed || got[0].ID != last {
+		t.Fatalf("updates = %+v, want only the final PhaseFailed", got)
+	}
+	if _, err := o.Watch(ctx, last); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("second Watch = %v, want ErrUnknownID", err)
+	}
+}
+
+func TestDirect_Resume(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("re-attaches", func(t *testing.T) {
+		t.Parallel()
+		src := &fakeAgent{events: exited(nil, 0)}
+		dest := &fakeAgent{events: exited(lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809"), 0)}
+		o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": src, "host-b": dest}))
+		created, err := o.Resume(ctx, "m1", directRequest())
+		if err != nil || created {
+			t.Fatalf("Resume = %t, %v; want false, nil", created, err)
+		}
+		if args, _, _, _ := dest.state(); args != nil {
+			t.Errorf("Resume restarted the destination with %q", args)
+		}
+		updates, err := o.Watch(ctx, "m1")
+		if err != nil {
+			t.Fatalf("Watch: %v", err)
+		}
+		if got := drainUpdates(updates, 10*time.Second); got[len(got)-1].Phase != PhaseSucceeded {
+			t.Fatalf("updates = %+v, want PhaseSucceeded last", got)
+		}
+	})
+
+	t.Run("starts afresh", func(t *testing.T) {
+		t.Parallel()
+		src := &fakeAgent{fresh: true}
+		dest := &fakeAgent{fresh: true, events: lines("KATAMARAN_DEST_READY sandbox_id= migration_port=4444 nbd_port=10809")}
+		o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": src, "host-b": dest}))
+		created, err := o.Resume(ctx, "m1", directRequest())
+		if err != nil || !created {
+			t.Fatalf("Resume = %t, %v; want true, nil", created, err)
+		}
+		if args, _, _, _ := src.state(); args == nil {
+			t.Error("source not started")
+		}
+		if created, err := o.Resume(ctx, "m1", directRequest()); err != nil || created {
+			t.Errorf("Resume of a running migration = %t, %v; want false, nil", created, err)
+		}
+		_ = o.Stop(ctx, "m1")
+	})
+
+	t.Run("source only", func(t *testing.T) {
+		t.Parallel()
+		o := NewDirect(dialFakes(map[string]*fakeAgent{"host-a": {}, "host-b": {fresh: true}}))
+		if _, err := o.Resume(ctx, "m1", directRequest()); err == nil || !strings.Contains(err.Error(), "does not") {
+			t.Fatalf("Resume = %v, want an error about the missing destination run", err)
+		}
+	})
+}
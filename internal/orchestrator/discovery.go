@@ -23,10 +23,22 @@ type PodInfo struct {
 	PodIP     string `json:"pod_ip"`
 }
 
-// NodeInfo is the projection of a Kubernetes node: name + InternalIP.
+// NodeInfo is the projection of a Kubernetes node: name + InternalIP, plus
+// the scheduling and capacity facts the destination picker scores (see
+// placement.go). The scoring fields are not part of the dashboard's
+// /api/nodes JSON.
 type NodeInfo struct {
 	Name       string `json:"name"`
 	InternalIP string `json:"internal_ip"`
+
+	Labels        map[string]string `json:"-"`
+	Taints        []corev1.Taint    `json:"-"`
+	Unschedulable bool              `json:"-"`
+	Ready         bool              `json:"-"`
+	// AllocatableMemory and AllocatableStorage (ephemeral-storage) are in
+	// bytes; zero when the node does not report them.
+	AllocatableMemory  int64 `json:"-"`
+	AllocatableStorage int64 `json:"-"`
 }
 
 // Discoverer enumerates kata-qemu pods and kata-runtime nodes from a
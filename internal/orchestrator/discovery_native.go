@@ -81,14 +81,33 @@ func (d *nativeDiscoverer) ListKataNodes(ctx context.Context) ([]NodeInfo, error
 	}
 	out := make([]NodeInfo, 0, len(list.Items))
 	for _, n := range list.Items {
-		out = append(out, NodeInfo{
-			Name:       n.Name,
-			InternalIP: pickInternalIP(n.Status.Addresses),
-		})
+		out = append(out, nodeInfoFrom(n))
 	}
 	return out, nil
 }
 
+func nodeInfoFrom(n corev1.Node) NodeInfo {
+	info := NodeInfo{
+		Name:          n.Name,
+		InternalIP:    pickInternalIP(n.Status.Addresses),
+		Labels:        n.Labels,
+		Taints:        n.Spec.Taints,
+		Unschedulable: n.Spec.Unschedulable,
+	}
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			info.Ready = c.Status == corev1.ConditionTrue
+		}
+	}
+	if q, ok := n.Status.Allocatable[corev1.ResourceMemory]; ok {
+		info.AllocatableMemory = q.Value()
+	}
+	if q, ok := n.Status.Allocatable[corev1.ResourceEphemeralStorage]; ok {
+		info.AllocatableStorage = q.Value()
+	}
+	return info
+}
+
 func (d *nativeDiscoverer) LookupPodNode(ctx context.Context, namespace, name string) (string, error) {
 	p, err := d.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -49,10 +50,18 @@ func TestNativeDiscoverer_ListKataNodes(t *testing.T) {
 	cs := fake.NewSimpleClientset(
 		&corev1.Node{
 			ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{"katacontainers.io/kata-runtime": "true"}},
-			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
-				{Type: corev1.NodeHostName, Address: "n1"},
-				{Type: corev1.NodeInternalIP, Address: "10.0.1.1"},
-			}},
+			Spec:       corev1.NodeSpec{Unschedulable: true},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{
+					{Type: corev1.NodeHostName, Address: "n1"},
+					{Type: corev1.NodeInternalIP, Address: "10.0.1.1"},
+				},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceMemory:           resource.MustParse("8Gi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("100Gi"),
+				},
+			},
 		},
 		&corev1.Node{
 			ObjectMeta: metav1.ObjectMeta{Name: "n-no-label"},
@@ -66,6 +75,9 @@ func TestNativeDiscoverer_ListKataNodes(t *testing.T) {
 	if len(got) != 1 || got[0].Name != "n1" || got[0].InternalIP != "10.0.1.1" {
 		t.Fatalf("unexpected result: %+v", got)
 	}
+	if !got[0].Ready || !got[0].Unschedulable || got[0].AllocatableMemory != 8<<30 || got[0].AllocatableStorage != 100<<30 {
+		t.Fatalf("node capacity not populated: %+v", got[0])
+	}
 }
 
 func TestNativeDiscoverer_DeletePod(t *testing.T) {
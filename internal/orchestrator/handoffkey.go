@@ -0,0 +1,63 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// handoffKeyBytes is the size of the per-migration hand-off key.
+const handoffKeyBytes = 32
+
+// HandoffKeySecretName is the Secret holding a migration's hand-off key.
+// Both Job templates read its "key" entry into KATAMARAN_HANDOFF_KEY, with
+// which the source signs the cmdline, device plan and VMConfig markers it
+// prints and the destination verifies them.
+func HandoffKeySecretName(id MigrationID) string { return "katamaran-handoff-" + jobSuffix(id) }
+
+// newHandoffKey returns a fresh random hand-off key, hex-encoded since
+// the value lands in an environment variable.
+func newHandoffKey() (string, error) {
+	key := make([]byte, handoffKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generate hand-off key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// mintHandoffKey creates the migration's hand-off key Secret, owned by
+// the already created source Job. Both Job templates reference it as a
+// required env source, so neither container starts until it exists.
+func (n *native) mintHandoffKey(ctx context.Context, id MigrationID, owner *batchv1.Job) error {
+	key, err := newHandoffKey()
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      HandoffKeySecretName(id),
+			Namespace: n.namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":    "katamaran",
+				"katamaran.io/migration-id": string(id),
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       owner.Name,
+				UID:        owner.UID,
+			}},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{"key": key},
+	}
+	if _, err := n.client.CoreV1().Secrets(n.namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create hand-off key secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hookMarker is printed by the katamaran binary once per hook run (see
+// migration.runHooks): key=value fields, then an optional Go-quoted
+// error=.
+const hookMarker = "KATAMARAN_HOOK "
+
+// maxHookTimeoutSeconds mirrors the binary's cap on Hook.TimeoutSeconds.
+const maxHookTimeoutSeconds = 600
+
+// validateHooks checks one phase's hooks. The binary validates them
+// again; checking here fails a bad Migration before any Job exists.
+func validateHooks(phase string, hooks []Hook) error {
+	seen := make(map[string]bool, len(hooks))
+	for _, h := range hooks {
+		if len(h.Name) > 63 || !dns1123LabelRe.MatchString(h.Name) {
+			return fmt.Errorf("hooks.%s: name %q must be a DNS-1123 label", phase, h.Name)
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("hooks.%s: duplicate hook name %q", phase, h.Name)
+		}
+		seen[h.Name] = true
+		handlers := 0
+		for _, set := range []bool{h.HTTP != nil, h.Exec != nil, h.GuestExec != nil} {
+			if set {
+				handlers++
+			}
+		}
+		if handlers != 1 {
+			return fmt.Errorf("hooks.%s[%s]: exactly one of http, exec and guestExec must be set", phase, h.Name)
+		}
+		if h.TimeoutSeconds < 0 || h.TimeoutSeconds > maxHookTimeoutSeconds {
+			return fmt.Errorf("hooks.%s[%s]: timeoutSeconds must be between 0 and %d, got %d", phase, h.Name, maxHookTimeoutSeconds, h.TimeoutSeconds)
+		}
+		if h.FailurePolicy != "" && h.FailurePolicy != "abort" && h.FailurePolicy != "ignore" {
+			return fmt.Errorf("hooks.%s[%s]: failurePolicy must be one of abort or ignore, got %q", phase, h.Name, h.FailurePolicy)
+		}
+		switch {
+		case h.HTTP != nil:
+			if h.HTTP.Port < 1 || h.HTTP.Port > 65535 {
+				return fmt.Errorf("hooks.%s[%s]: http port must be between 1 and 65535, got %d", phase, h.Name, h.HTTP.Port)
+			}
+			if h.HTTP.Path != "" && !strings.HasPrefix(h.HTTP.Path, "/") {
+				return fmt.Errorf("hooks.%s[%s]: http path must start with /", phase, h.Name)
+			}
+			if h.HTTP.Method != "" && h.HTTP.Method != "GET" && h.HTTP.Method != "POST" {
+				return fmt.Errorf("hooks.%s[%s]: http method must be one of GET or POST, got %q", phase, h.Name, h.HTTP.Method)
+			}
+		case h.Exec != nil:
+			if len(h.Exec.Command) == 0 {
+				return fmt.Errorf("hooks.%s[%s]: exec command is required", phase, h.Name)
+			}
+		case h.GuestExec != nil:
+			if len(h.GuestExec.Command) == 0 {
+				return fmt.Errorf("hooks.%s[%s]: guestExec command is required", phase, h.Name)
+			}
+			if s := h.GuestExec.Socket; s != "" && (s != filepath.Base(s) || s == "." || s == "..") {
+				return fmt.Errorf("hooks.%s[%s]: guestExec socket must be a file name", phase, h.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// encodeHooks renders hooks for --pre-cutover-hooks / --post-resume-hooks:
+// base64url JSON, which needs no quoting in the Job's shell command.
+func encodeHooks(hooks []Hook) string {
+	// Strings, ints and slices of them: Marshal cannot fail.
+	raw, _ := json.Marshal(hooks)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// hookResultUpdate converts a KATAMARAN_HOOK marker (everything after the
+// marker prefix) into a StatusUpdate in phase.
+func hookResultUpdate(id MigrationID, phase StatusPhase, s string) StatusUpdate {
+	hr := parseHookMarker(s)
+	msg := fmt.Sprintf("%s hook %s: %s", hr.Phase, hr.Name, hr.Result)
+	if hr.Error != "" {
+		msg += ": " + hr.Error
+	}
+	return StatusUpdate{
+		ID:      id,
+		Phase:   phase,
+		When:    time.Now(),
+		Message: msg,
+		Hooks:   []HookResult{hr},
+	}
+}
+
+func parseHookMarker(s string) HookResult {
+	fields, quoted, _ := strings.Cut(s, " error=")
+	f := parseProgressFields(fields)
+	hr := HookResult{
+		Phase:      f["phase"],
+		Name:       f["name"],
+		Type:       f["type"],
+		Result:     f["result"],
+		DurationMS: parseInt64(f["duration_ms"]),
+	}
+	if quoted != "" {
+		msg, err := strconv.Unquote(strings.TrimSpace(quoted))
+		if err != nil {
+			msg = quoted
+		}
+		hr.Error = msg
+	}
+	return hr
+}
@@ -0,0 +1,466 @@
+package orchestrator
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// JobTemplates customises the Jobs the native orchestrator creates for
+// every migration. It is read from a ConfigMap (see
+// JobTemplatesFromConfigMap) so clusters can change the namespace,
+// deadlines, resources, service account or pull policy without
+// rebuilding the images. The zero value uses the embedded templates.
+type JobTemplates struct {
+	// Namespace is where the Jobs and hand-off Secrets are created; it
+	// replaces the templates' metadata.namespace. Empty means
+	// DefaultJobNamespace.
+	Namespace string
+
+	Source, Dest, Probe JobTemplate
+
+	// Policy bounds what the templates, patches and per-migration
+	// JobOverrides may produce.
+	Policy JobPolicy
+}
+
+// JobTemplate customises one of the three Jobs.
+type JobTemplate struct {
+	// Template replaces the embedded manifest. It is expanded with the
+	// same ${VAR} placeholders (see templates/).
+	Template []byte
+	// Patch is a strategic merge patch (YAML or JSON) applied to the
+	// rendered Job. ${VAR} placeholders are expanded in it too.
+	Patch []byte
+}
+
+// JobPolicy bounds the rendered Jobs, so that neither a template
+// override nor a Migration's spec.jobOverrides can give the privileged
+// migration pods more than they need. Every Job is checked after all
+// customisation; a violation fails the migration before anything is
+// created. Empty lists mean the defaults described on each field.
+type JobPolicy struct {
+	// ServiceAccounts the pods may run as. Default: those of the
+	// embedded templates (katamaran-source).
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+	// HostPaths the pods may mount, each with everything below it.
+	// Default: the embedded templates' mounts.
+	HostPaths []string `json:"hostPaths,omitempty"`
+	// Images containers may run besides the migration's Image.
+	Images []string `json:"images,omitempty"`
+	// PriorityClassNames the pods may use. Default: any but the
+	// built-in system-cluster-critical and system-node-critical, which
+	// would let a migration preempt cluster components.
+	PriorityClassNames []string `json:"priorityClassNames,omitempty"`
+	// MaxCPU and MaxMemory cap the katamaran container's limits.
+	MaxCPU    *resource.Quantity `json:"maxCPU,omitempty"`
+	MaxMemory *resource.Quantity `json:"maxMemory,omitempty"`
+}
+
+// JobOverrides adjusts the Jobs of one migration (the Migration CRD's
+// spec.jobOverrides). The result must pass the orchestrator's
+// JobPolicy. Ignored for migrations that run on node agents, which
+// create no Jobs.
+type JobOverrides struct {
+	// SourceResources and DestResources replace the katamaran
+	// container's resources in the source and destination Job. The
+	// destination's memory limit must cover the VM's RAM, which the
+	// replayed QEMU allocates in the Job's /dev/shm.
+	SourceResources *corev1.ResourceRequirements `json:"sourceResources,omitempty"`
+	DestResources   *corev1.ResourceRequirements `json:"destResources,omitempty"`
+	// PriorityClassName is set on both Jobs' pods.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// DestNodeSelector and DestTolerations are added to the destination
+	// Job's pod.
+	DestNodeSelector map[string]string   `json:"destNodeSelector,omitempty"`
+	DestTolerations  []corev1.Toleration `json:"destTolerations,omitempty"`
+}
+
+// isZero reports whether o changes nothing.
+func (o JobOverrides) isZero() bool {
+	return o.SourceResources == nil && o.DestResources == nil && o.PriorityClassName == "" &&
+		len(o.DestNodeSelector) == 0 && len(o.DestTolerations) == 0
+}
+
+// ConfigMap keys read by JobTemplatesFromConfigMap.
+const (
+	jobTemplatesNamespaceKey = "namespace"
+	jobTemplatesPolicyKey    = "policy.yaml"
+)
+
+// JobTemplatesFromConfigMap reads a JobTemplates from cm. Its keys are
+// "namespace", "policy.yaml" (a JobPolicy), and for each of the source,
+// dest and probe Jobs "job-<kind>.yaml" (a replacement template) and
+// "job-<kind>-patch.yaml" (a strategic merge patch). All are optional;
+// unknown keys are an error so a typo does not go unnoticed.
+func JobTemplatesFromConfigMap(cm *corev1.ConfigMap) (JobTemplates, error) {
+	var t JobTemplates
+	kinds := map[string]*JobTemplate{"source": &t.Source, "dest": &t.Dest, "probe": &t.Probe}
+	for _, key := range slices.Sorted(maps.Keys(cm.Data)) {
+		value := cm.Data[key]
+		switch key {
+		case jobTemplatesNamespaceKey:
+			t.Namespace = strings.TrimSpace(value)
+			if errs := validation.IsDNS1123Label(t.Namespace); len(errs) > 0 {
+				return JobTemplates{}, fmt.Errorf("%s: %s", key, strings.Join(errs, "; "))
+			}
+			continue
+		case jobTemplatesPolicyKey:
+			policy, err := yaml.ToJSON([]byte(value))
+			if err != nil {
+				return JobTemplates{}, fmt.Errorf("%s: %w", key, err)
+			}
+			dec := json.NewDecoder(bytes.NewReader(policy))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&t.Policy); err != nil {
+				return JobTemplates{}, fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+		kind, isPatch := strings.TrimSuffix(strings.TrimPrefix(key, "job-"), ".yaml"), false
+		if k, ok := strings.CutSuffix(kind, "-patch"); ok {
+			kind, isPatch = k, true
+		}
+		jt, ok := kinds[kind]
+		if !ok || !strings.HasPrefix(key, "job-") || !strings.HasSuffix(key, ".yaml") {
+			return JobTemplates{}, fmt.Errorf("unknown key %q", key)
+		}
+		if isPatch {
+			jt.Patch = []byte(value)
+		} else {
+			jt.Template = []byte(value)
+		}
+	}
+	return t, nil
+}
+
+// SetJobTemplates makes o render its Jobs with t. Each Job is rendered
+// once for a sample migration and checked against t.Policy, so a broken
+// ConfigMap is reported at startup. Orchestrators without Jobs ignore t.
+func SetJobTemplates(o Orchestrator, t JobTemplates) error {
+	if a, ok := o.(*agentOrchestrator); ok {
+		o = a.native
+	}
+	n, ok := o.(*native)
+	if !ok {
+		return nil
+	}
+	check := &native{namespace: cmp.Or(t.Namespace, DefaultJobNamespace), templates: t}
+	req := Request{
+		SourceNode: "node-a",
+		DestNode:   "node-b",
+		DestIP:     "10.0.0.2",
+		Image:      "katamaran:check",
+		SourcePod:  &PodRef{Namespace: "default", Name: "vm"},
+	}
+	if _, err := check.renderSourceJob(req, "0000000000000000", ""); err != nil {
+		return fmt.Errorf("source job: %w", err)
+	}
+	if _, err := check.renderDestJob(req, "0000000000000000", ""); err != nil {
+		return fmt.Errorf("dest job: %w", err)
+	}
+	if _, err := check.renderProbeJob(req, "0000000000000000"); err != nil {
+		return fmt.Errorf("probe job: %w", err)
+	}
+	n.templates, n.namespace = t, check.namespace
+	return nil
+}
+
+// SetJobTemplatesFromConfigMap reads the ConfigMap ref, "[namespace/]name"
+// with the namespace defaulting to DefaultJobNamespace, with o's client
+// and applies it with SetJobTemplates. Orchestrators without Jobs
+// ignore it.
+func SetJobTemplatesFromConfigMap(ctx context.Context, o Orchestrator, ref string) error {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found {
+		namespace, name = DefaultJobNamespace, ref
+	}
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("job templates ConfigMap %q: namespace: %s", ref, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("job templates ConfigMap %q: name: %s", ref, strings.Join(errs, "; "))
+	}
+	if a, ok := o.(*agentOrchestrator); ok {
+		o = a.native
+	}
+	n, ok := o.(*native)
+	if !ok {
+		return nil
+	}
+	cm, err := n.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get job templates ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	t, err := JobTemplatesFromConfigMap(cm)
+	if err != nil {
+		return fmt.Errorf("job templates ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	if err := SetJobTemplates(o, t); err != nil {
+		return fmt.Errorf("job templates ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// JobNamespace returns the namespace o creates its Jobs in.
+func JobNamespace(o Orchestrator) string {
+	if a, ok := o.(*agentOrchestrator); ok {
+		o = a.native
+	}
+	if n, ok := o.(*native); ok {
+		return n.namespace
+	}
+	return DefaultJobNamespace
+}
+
+// renderCustomJob renders the embedded template, or t's replacement,
+// applies t's patch, and moves the Job into the orchestrator's namespace.
+func (n *native) renderCustomJob(t JobTemplate, embedded []byte, vars map[string]string) (*batchv1.Job, error) {
+	tmpl := t.Template
+	if len(bytes.TrimSpace(tmpl)) == 0 {
+		tmpl = embedded
+	}
+	job, err := renderJob(tmpl, vars)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(t.Patch)) > 0 {
+		if job, err = patchJob(job, []byte(expandShellVars(string(t.Patch), vars))); err != nil {
+			return nil, err
+		}
+	}
+	job.Namespace = n.namespace
+	return job, nil
+}
+
+// patchJob applies the strategic merge patch patch to job.
+func patchJob(job *batchv1.Job, patch []byte) (*batchv1.Job, error) {
+	patchJSON, err := yaml.ToJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("decode job patch: %w", err)
+	}
+	original, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patchJSON, batchv1.Job{})
+	if err != nil {
+		return nil, fmt.Errorf("apply job patch: %w", err)
+	}
+	var out batchv1.Job
+	if err := json.Unmarshal(patched, &out); err != nil {
+		return nil, fmt.Errorf("decode patched job: %w", err)
+	}
+	return &out, nil
+}
+
+// applyJobOverrides applies the per-migration overrides to the source
+// (dest false) or destination Job.
+func applyJobOverrides(job *batchv1.Job, o JobOverrides, dest bool) {
+	spec := &job.Spec.Template.Spec
+	if o.PriorityClassName != "" {
+		spec.PriorityClassName = o.PriorityClassName
+		// Admission fills in the class's value; a template's would conflict.
+		spec.Priority = nil
+	}
+	resources := o.SourceResources
+	if dest {
+		resources = o.DestResources
+		if len(o.DestNodeSelector) > 0 {
+			spec.NodeSelector = maps.Clone(spec.NodeSelector)
+			if spec.NodeSelector == nil {
+				spec.NodeSelector = map[string]string{}
+			}
+			maps.Copy(spec.NodeSelector, o.DestNodeSelector)
+		}
+		spec.Tolerations = append(slices.Clone(spec.Tolerations), o.DestTolerations...)
+	}
+	if resources != nil {
+		if c := katamaranContainer(spec); c != nil {
+			c.Resources = *resources.DeepCopy()
+		}
+	}
+}
+
+// katamaranContainer returns the container running the migration.
+func katamaranContainer(spec *corev1.PodSpec) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "katamaran" {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+// validateJobOverrides checks the fields of o that can be checked
+// without the policy.
+func validateJobOverrides(o JobOverrides) error {
+	if o.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(o.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("jobOverrides.priorityClassName: %s", strings.Join(errs, "; "))
+		}
+	}
+	for k, v := range o.DestNodeSelector {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("jobOverrides.destNodeSelector key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("jobOverrides.destNodeSelector[%s]: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	for i, t := range o.DestTolerations {
+		switch t.Operator {
+		case "", corev1.TolerationOpEqual:
+			if t.Key == "" {
+				return fmt.Errorf("jobOverrides.destTolerations[%d]: operator Equal requires a key", i)
+			}
+		case corev1.TolerationOpExists:
+			if t.Value != "" {
+				return fmt.Errorf("jobOverrides.destTolerations[%d]: operator Exists takes no value", i)
+			}
+		default:
+			return fmt.Errorf("jobOverrides.destTolerations[%d]: operator must be Equal or Exists, got %q", i, t.Operator)
+		}
+		switch t.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("jobOverrides.destTolerations[%d]: unknown effect %q", i, t.Effect)
+		}
+	}
+	for name, r := range map[string]*corev1.ResourceRequirements{"sourceResources": o.SourceResources, "destResources": o.DestResources} {
+		if r == nil {
+			continue
+		}
+		for res, limit := range r.Limits {
+			if req, ok := r.Requests[res]; ok && req.Cmp(limit) > 0 {
+				return fmt.Errorf("jobOverrides.%s: %s request %s exceeds its limit %s", name, res, req.String(), limit.String())
+			}
+		}
+	}
+	return nil
+}
+
+// privilegedContainers may run privileged: the init container loading
+// the tunnel and sch_plug modules, and the migration itself.
+var privilegedContainers = []string{"load-modules", "katamaran"}
+
+// defaultJobPolicy holds the service accounts and host paths of the
+// embedded templates, the JobPolicy defaults.
+var defaultJobPolicy = func() JobPolicy {
+	var p JobPolicy
+	for _, tmpl := range [][]byte{sourceJobTemplate, destJobTemplate, probeJobTemplate} {
+		job, err := renderJob(tmpl, nil)
+		if err != nil {
+			panic(fmt.Sprintf("embedded job template: %v", err))
+		}
+		spec := job.Spec.Template.Spec
+		if !slices.Contains(p.ServiceAccounts, spec.ServiceAccountName) {
+			p.ServiceAccounts = append(p.ServiceAccounts, spec.ServiceAccountName)
+		}
+		for _, v := range spec.Volumes {
+			if v.HostPath != nil && !slices.Contains(p.HostPaths, v.HostPath.Path) {
+				p.HostPaths = append(p.HostPaths, v.HostPath.Path)
+			}
+		}
+	}
+	sort.Strings(p.HostPaths)
+	return p
+}()
+
+// checkJobPolicy checks a rendered Job of the given component (source,
+// dest or probe) against p. image is the migration's Image.
+func checkJobPolicy(job *batchv1.Job, component string, id MigrationID, image string, p JobPolicy) error {
+	if job.Labels["app.kubernetes.io/name"] != "katamaran" || job.Labels["app.kubernetes.io/component"] != component || job.Labels[MigrationIDLabel] != string(id) {
+		// The orchestrator finds its Jobs and their pods by these labels.
+		return fmt.Errorf("job must keep the labels app.kubernetes.io/name=katamaran, app.kubernetes.io/component=%s and %s", component, MigrationIDLabel)
+	}
+	spec := job.Spec.Template.Spec
+	accounts := p.ServiceAccounts
+	if len(accounts) == 0 {
+		accounts = defaultJobPolicy.ServiceAccounts
+	}
+	if !slices.Contains(accounts, spec.ServiceAccountName) {
+		return fmt.Errorf("service account %q is not allowed (allowed: %s)", spec.ServiceAccountName, strings.Join(accounts, ", "))
+	}
+	if spec.HostIPC {
+		return errors.New("hostIPC is not allowed")
+	}
+	hostPaths := p.HostPaths
+	if len(hostPaths) == 0 {
+		hostPaths = defaultJobPolicy.HostPaths
+	}
+	for _, v := range spec.Volumes {
+		if v.HostPath != nil && !underAny(v.HostPath.Path, hostPaths) {
+			return fmt.Errorf("volume %s: hostPath %s is not allowed (allowed: %s)", v.Name, v.HostPath.Path, strings.Join(hostPaths, ", "))
+		}
+	}
+	if name := spec.PriorityClassName; name != "" {
+		if len(p.PriorityClassNames) > 0 && !slices.Contains(p.PriorityClassNames, name) {
+			return fmt.Errorf("priority class %q is not allowed (allowed: %s)", name, strings.Join(p.PriorityClassNames, ", "))
+		}
+		if len(p.PriorityClassNames) == 0 && strings.HasPrefix(name, "system-") {
+			return fmt.Errorf("priority class %q is not allowed without listing it in the policy", name)
+		}
+	}
+	if len(spec.EphemeralContainers) > 0 {
+		return errors.New("ephemeral containers are not allowed")
+	}
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		if c.Image != image && !slices.Contains(p.Images, c.Image) {
+			return fmt.Errorf("container %s: image %q is neither the migration's image nor allowed by the policy", c.Name, c.Image)
+		}
+		if slices.Contains(privilegedContainers, c.Name) {
+			continue
+		}
+		if sc := c.SecurityContext; sc != nil && (sc.Privileged != nil && *sc.Privileged ||
+			sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation ||
+			sc.Capabilities != nil && len(sc.Capabilities.Add) > 0) {
+			return fmt.Errorf("container %s: only %s may be privileged or add capabilities", c.Name, strings.Join(privilegedContainers, " and "))
+		}
+	}
+	if c := katamaranContainer(&spec); c != nil {
+		for res, limit := range map[corev1.ResourceName]*resource.Quantity{corev1.ResourceCPU: p.MaxCPU, corev1.ResourceMemory: p.MaxMemory} {
+			if limit == nil {
+				continue
+			}
+			got, ok := c.Resources.Limits[res]
+			if !ok {
+				return fmt.Errorf("container katamaran: a %s limit is required (at most %s)", res, limit.String())
+			}
+			if got.Cmp(*limit) > 0 {
+				return fmt.Errorf("container katamaran: %s limit %s exceeds the policy's %s", res, got.String(), limit.String())
+			}
+		}
+	} else {
+		return errors.New("job has no katamaran container")
+	}
+	return nil
+}
+
+// underAny reports whether p is one of dirs or below one of them.
+func underAny(p string, dirs []string) bool {
+	p = path.Clean(p)
+	for _, d := range dirs {
+		d = path.Clean(d)
+		if p == d || strings.HasPrefix(p, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func templatesRequest() Request {
+	return Request{
+		SourceNode: "node-a",
+		DestNode:   "node-b",
+		DestIP:     "10.0.0.2",
+		Image:      "katamaran:test",
+		SourceQMP:  "/run/vc/vm/extra-monitor.sock",
+		VMIP:       "10.244.1.5",
+	}
+}
+
+func TestJobTemplatesFromConfigMap(t *testing.T) {
+	t.Parallel()
+	tmpl, err := JobTemplatesFromConfigMap(&corev1.ConfigMap{Data: map[string]string{
+		"namespace":             "katamaran",
+		"job-dest.yaml":         string(destJobTemplate),
+		"job-source-patch.yaml": "spec:\n  activeDeadlineSeconds: 60\n",
+		"policy.yaml":           "priorityClassNames: [migrations]\nmaxMemory: 4Gi\n",
+	}})
+	if err != nil {
+		t.Fatalf("JobTemplatesFromConfigMap: %v", err)
+	}
+	if tmpl.Namespace != "katamaran" || len(tmpl.Dest.Template) == 0 || len(tmpl.Source.Patch) == 0 {
+		t.Errorf("templates = %+v", tmpl)
+	}
+	if tmpl.Policy.MaxMemory == nil || tmpl.Policy.MaxMemory.String() != "4Gi" || tmpl.Policy.PriorityClassNames[0] != "migrations" {
+		t.Errorf("policy = %+v", tmpl.Policy)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data map[string]string
+		want string
+	}{
+		{"unknown key", map[string]string{"job-sorce.yaml": ""}, "unknown key"},
+		{"unknown policy field", map[string]string{"policy.yaml": "maxGPU: 1"}, "unknown field"},
+		{"bad namespace", map[string]string{"namespace": "Kube_System"}, "namespace"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := JobTemplatesFromConfigMap(&corev1.ConfigMap{Data: tc.data})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetJobTemplates_PatchAndNamespace(t *testing.T) {
+	t.Parallel()
+	o := NewFromClient(fake.NewSimpleClientset())
+	err := SetJobTemplates(o, JobTemplates{
+		Namespace: "katamaran",
+		Source: JobTemplate{Patch: []byte(`
+spec:
+  activeDeadlineSeconds: 60
+  template:
+    spec:
+      containers:
+      - name: katamaran
+        imagePullPolicy: Always
+        env:
+        - name: NODE
+          value: ${NODE_NAME}
+`)},
+	})
+	if err != nil {
+		t.Fatalf("SetJobTemplates: %v", err)
+	}
+	if got := JobNamespace(o); got != "katamaran" {
+		t.Errorf("JobNamespace = %q, want katamaran", got)
+	}
+	n := o.(*native)
+	job, err := n.renderSourceJob(templatesRequest(), "abcdef0123456789", "")
+	if err != nil {
+		t.Fatalf("renderSourceJob: %v", err)
+	}
+	if job.Namespace != "katamaran" {
+		t.Errorf("namespace = %q, want katamaran", job.Namespace)
+	}
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != 60 {
+		t.Errorf("activeDeadlineSeconds = %v, want 60", job.Spec.ActiveDeadlineSeconds)
+	}
+	c := katamaranContainer(&job.Spec.Template.Spec)
+	if c.Image != "katamaran:test" || c.ImagePullPolicy != corev1.PullAlways {
+		t.Errorf("katamaran container image %q, pull policy %q", c.Image, c.ImagePullPolicy)
+	}
+	// The patch merges into the template's env by name.
+	var env []string
+	for _, e := range c.Env {
+		env = append(env, e.Name+"="+e.Value)
+	}
+	if joined := strings.Join(env, ","); !strings.Contains(joined, "KATAMARAN_MIGRATION_ID=abcdef0123456789") || !strings.Contains(joined, "NODE=node-a") {
+		t.Errorf("env = %v, want the template's and the patch's variables", env)
+	}
+	// The dest Job has no patch and lands in the same namespace.
+	dest, err := n.renderDestJob(templatesRequest(), "abcdef0123456789", "")
+	if err != nil || dest.Namespace != "katamaran" {
+		t.Errorf("renderDestJob = %v, %v; want namespace katamaran", dest, err)
+	}
+}
+
+func TestSetJobTemplates_FromConfigMap(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: DefaultJobNamespace, Name: "katamaran-job-templates"},
+		Data:       map[string]string{"namespace": "migrations"},
+	})
+	o := NewFromClient(cs)
+	ctx := context.Background()
+	if err := SetJobTemplatesFromConfigMap(ctx, o, "katamaran-job-templates"); err != nil {
+		t.Fatalf("SetJobTemplatesFromConfigMap: %v", err)
+	}
+	if got := JobNamespace(o); got != "migrations" {
+		t.Errorf("JobNamespace = %q, want migrations", got)
+	}
+	if err := SetJobTemplatesFromConfigMap(ctx, o, "other/katamaran-job-templates"); err == nil {
+		t.Error("missing ConfigMap accepted")
+	}
+	if err := SetJobTemplatesFromConfigMap(ctx, o, "a/b/c"); err == nil {
+		t.Error("malformed reference accepted")
+	}
+}
+
+func TestSetJobTemplates_PolicyRejects(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		tmpl JobTemplates
+		want string
+	}{
+		{"service account", JobTemplates{Dest: JobTemplate{Patch: []byte("spec: {template: {spec: {serviceAccountName: cluster-admin}}}")}}, "service account"},
+		{"host path", JobTemplates{Source: JobTemplate{Patch: []byte(`
+spec:
+  template:
+    spec:
+      volumes:
+      - name: root
+        hostPath: {path: /}
+`)}}, "hostPath / is not allowed"},
+		{"host path prefix", JobTemplates{Source: JobTemplate{Patch: []byte(`
+spec:
+  template:
+    spec:
+      volumes:
+      - name: vc
+        hostPath: {path: /run/vcx}
+`)}}, "hostPath /run/vcx"},
+		{"hostIPC", JobTemplates{Probe: JobTemplate{Patch: []byte("spec: {template: {spec: {hostIPC: true}}}")}}, "hostIPC"},
+		{"sidecar image", JobTemplates{Source: JobTemplate{Patch: []byte(`
+spec:
+  template:
+    spec:
+      containers:
+      - name: shell
+        image: busybox
+`)}}, `image "busybox"`},
+		{"privileged sidecar", JobTemplates{
+			Source: JobTemplate{Patch: []byte(`
+spec:
+  template:
+    spec:
+      containers:
+      - name: shell
+        image: busybox
+        securityContext: {privileged: true}
+`)},
+			Policy: JobPolicy{Images: []string{"busybox"}},
+		}, "only load-modules and katamaran"},
+		{"labels", JobTemplates{Dest: JobTemplate{Patch: []byte("metadata: {labels: {app.kubernetes.io/component: source}}")}}, "must keep the labels"},
+		{"memory limit", JobTemplates{Policy: JobPolicy{MaxMemory: ptrTo(resource.MustParse("1Gi"))}}, "memory limit"},
+		{"bad patch", JobTemplates{Dest: JobTemplate{Patch: []byte("spec: [")}}, "job patch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			o := NewFromClient(fake.NewSimpleClientset())
+			err := SetJobTemplates(o, tc.tmpl)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("SetJobTemplates error = %v, want %q", err, tc.want)
+			}
+			if JobNamespace(o) != DefaultJobNamespace {
+				t.Error("rejected templates were applied")
+			}
+		})
+	}
+}
+
+func TestRenderJobs_Overrides(t *testing.T) {
+	t.Parallel()
+	o := NewFromClient(fake.NewSimpleClientset())
+	if err := SetJobTemplates(o, JobTemplates{Policy: JobPolicy{
+		PriorityClassNames: []string{"migrations"},
+		MaxMemory:          ptrTo(resource.MustParse("16Gi")),
+	}}); err != nil {
+		t.Fatalf("SetJobTemplates: %v", err)
+	}
+	n := o.(*native)
+	overrides := func() JobOverrides {
+		return JobOverrides{
+			SourceResources: &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			},
+			DestResources: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+			},
+			PriorityClassName: "migrations",
+			DestNodeSelector:  map[string]string{"pool": "kata"},
+			DestTolerations:   []corev1.Toleration{{Key: "kata", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+		}
+	}
+	req := templatesRequest()
+	req.JobOverrides = overrides()
+	src, err := n.renderSourceJob(req, "abcdef0123456789", "")
+	if err != nil {
+		t.Fatalf("renderSourceJob: %v", err)
+	}
+	if spec := src.Spec.Template.Spec; spec.PriorityClassName != "migrations" || len(spec.NodeSelector) != 0 {
+		t.Errorf("source priority class %q, nodeSelector %v", spec.PriorityClassName, spec.NodeSelector)
+	}
+	if got := katamaranContainer(&src.Spec.Template.Spec).Resources.Limits.Memory().String(); got != "512Mi" {
+		t.Errorf("source memory limit = %s, want 512Mi", got)
+	}
+	dest, err := n.renderDestJob(req, "abcdef0123456789", "")
+	if err != nil {
+		t.Fatalf("renderDestJob: %v", err)
+	}
+	spec := dest.Spec.Template.Spec
+	if spec.PriorityClassName != "migrations" || spec.NodeSelector["pool"] != "kata" || len(spec.Tolerations) == 0 || spec.Tolerations[len(spec.Tolerations)-1].Key != "kata" {
+		t.Errorf("dest priority class %q, nodeSelector %v, tolerations %v", spec.PriorityClassName, spec.NodeSelector, spec.Tolerations)
+	}
+	if got := katamaranContainer(&spec).Resources.Limits.Memory().String(); got != "8Gi" {
+		t.Errorf("dest memory limit = %s, want 8Gi", got)
+	}
+
+	// Overrides are bounded by the policy too.
+	for _, tc := range []struct {
+		name   string
+		mutate func(*JobOverrides)
+		want   string
+	}{
+		{"priority class", func(o *JobOverrides) { o.PriorityClassName = "system-node-critical" }, "priority class"},
+		{"memory", func(o *JobOverrides) {
+			o.DestResources.Limits[corev1.ResourceMemory] = resource.MustParse("32Gi")
+		}, "exceeds the policy's 16Gi"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := templatesRequest()
+			r.JobOverrides = overrides()
+			tc.mutate(&r.JobOverrides)
+			if _, err := n.renderDestJob(r, "abcdef0123456789", ""); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("renderDestJob error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy_SystemPriorityClass(t *testing.T) {
+	t.Parallel()
+	n := NewFromClient(fake.NewSimpleClientset()).(*native)
+	req := templatesRequest()
+	req.JobOverrides.PriorityClassName = "batch-low"
+	if _, err := n.renderSourceJob(req, "abcdef0123456789", ""); err != nil {
+		t.Fatalf("renderSourceJob with a user priority class: %v", err)
+	}
+	req.JobOverrides.PriorityClassName = "system-cluster-critical"
+	if _, err := n.renderSourceJob(req, "abcdef0123456789", ""); err == nil || !strings.Contains(err.Error(), "priority class") {
+		t.Fatalf("renderSourceJob with a system priority class = %v, want a policy error", err)
+	}
+}
+
+func TestValidateJobOverrides(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		o    JobOverrides
+		want string
+	}{
+		{"priority class", JobOverrides{PriorityClassName: "Not_Valid"}, "priorityClassName"},
+		{"selector key", JobOverrides{DestNodeSelector: map[string]string{"bad key": "x"}}, "destNodeSelector key"},
+		{"selector value", JobOverrides{DestNodeSelector: map[string]string{"pool": "a b"}}, "destNodeSelector[pool]"},
+		{"operator", JobOverrides{DestTolerations: []corev1.Toleration{{Key: "k", Operator: "In"}}}, "operator"},
+		{"exists with value", JobOverrides{DestTolerations: []corev1.Toleration{{Key: "k", Operator: corev1.TolerationOpExists, Value: "v"}}}, "takes no value"},
+		{"effect", JobOverrides{DestTolerations: []corev1.Toleration{{Key: "k", Effect: "Evict"}}}, "effect"},
+		{"request above limit", JobOverrides{SourceResources: &corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		}}, "exceeds its limit"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := templatesRequest()
+			req.JobOverrides = tc.o
+			err := Validate(req)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Validate error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func ptrTo[T any](v T) *T { return &v }
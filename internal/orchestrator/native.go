@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,8 +37,11 @@ import (
 //     destination fails or the source fails without a successful handover.
 //
 // Limitations: only structured KATAMARAN_PROGRESS / KATAMARAN_RESULT /
-// KATAMARAN_DOWNTIME_LIMIT marker lines are tailed from the source pod. Full
-// per-pod log streaming for the dashboard log pane is not implemented.
+// KATAMARAN_DOWNTIME_LIMIT / KATAMARAN_VM_STOPPED / KATAMARAN_HOOK /
+// KATAMARAN_TUNING marker lines are tailed from the source pod, plus a
+// one-shot scrape of the dest pod's KATAMARAN_VM_RESUMED and
+// KATAMARAN_HOOK markers when it ends. Full per-pod log streaming for the
+// dashboard log pane is not implemented.
 //
 // ReplayCmdline support: when the request has ReplayCmdline=true, the
 // orchestrator submits the source Job first, waits for its pod to be
@@ -51,9 +55,16 @@ type native struct {
 	client         kubernetes.Interface
 	namespace      string
 	podWaitTimeout time.Duration // default for firstSourcePod; overridden by Request.PodWaitTimeoutSeconds
+	probeTimeout   time.Duration // how long the destination picker waits for the VM probe Job
+	templates      JobTemplates  // customised Job manifests and their policy; see SetJobTemplates
 
 	mu       sync.Mutex
 	inflight map[MigrationID]*nativeRun
+
+	// slotMu serializes destination slot allocation; reserved holds
+	// slots claimed by Apply calls whose Jobs are not created yet.
+	slotMu   sync.Mutex
+	reserved map[MigrationID]slotHolder
 }
 
 type nativeRun struct {
@@ -74,6 +85,9 @@ type nativeRun struct {
 	resultDowntime int64
 	resultRAMXfer  int64
 	resultRAMTotal int64
+	resultMemSaved int64
+	resultComp     string
+	resultCompRate float64
 
 	// Downtime-limit marker captured from the source pod log before the
 	// cutover. Populated by tailProgress when it sees
@@ -82,6 +96,14 @@ type nativeRun struct {
 	appliedDowntime  int64
 	rttMS            int64
 	autoDowntime     bool
+
+	// vmStoppedAt is parsed from the source's KATAMARAN_VM_STOPPED
+	// marker by tailProgress (or the final scrape in succeededUpdate).
+	vmStoppedAt time.Time
+
+	// handoff is the prepared storage handoff (Request.StorageHandoff),
+	// completed or rolled back by poll on the terminal update.
+	handoff *storageHandoff
 }
 
 // New builds an Orchestrator using the in-cluster service account. Job
@@ -140,29 +162,39 @@ func NewFromClient(c kubernetes.Interface) Orchestrator {
 
 const defaultPodWaitTimeout = 60 * time.Second
 
+// sourceFailGrace is how long a migration waits for the destination
+// after the source failed: the source often fails once kata-shim kills
+// its QEMU after a handover that did complete.
+const sourceFailGrace = 90 * time.Second
+
 func newFromClient(c kubernetes.Interface) *native {
 	return &native{
 		client:         c,
 		namespace:      DefaultJobNamespace,
 		podWaitTimeout: defaultPodWaitTimeout,
+		probeTimeout:   defaultProbeTimeout,
 		inflight:       map[MigrationID]*nativeRun{},
+		reserved:       map[MigrationID]slotHolder{},
 	}
 }
 
 // SetPodWaitTimeout overrides the default timeout for waiting for migration
 // Job pods to appear. Used by the controller flag / env var path.
 func SetPodWaitTimeout(o Orchestrator, d time.Duration) {
+	if a, ok := o.(*agentOrchestrator); ok {
+		o = a.native
+	}
 	if n, ok := o.(*native); ok && d > 0 {
 		n.podWaitTimeout = d
 	}
 }
 
-// cleanupDestJob best-effort deletes the dest Job after a setup error and
+// cleanupJob best-effort deletes a migration Job after a setup error and
 // logs a warning if the delete itself fails. Consolidates the cleanup
 // branches scattered through the auto-select and dest-first code paths.
-func (n *native) cleanupDestJob(ctx context.Context, destJobName, reason string) {
-	if err := n.client.BatchV1().Jobs(n.namespace).Delete(ctx, destJobName, metav1.DeleteOptions{}); err != nil {
-		slog.Warn("failed to clean up dest job", "reason", reason, "dest_job", destJobName, "namespace", n.namespace, "error", err)
+func (n *native) cleanupJob(ctx context.Context, jobName, reason string) {
+	if err := n.client.BatchV1().Jobs(n.namespace).Delete(ctx, jobName, metav1.DeleteOptions{}); err != nil {
+		slog.Warn("failed to clean up migration job", "reason", reason, "job", jobName, "namespace", n.namespace, "error", err)
 	}
 }
 
@@ -178,9 +210,81 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 	}
 
 	id := newID()
+	var profile *VMProfile
+	if req.AllowColdFallback && !req.Cold {
+		// Preflight: a VM QEMU refuses to live-migrate goes cold instead
+		// of failing at the first migrate. A failed probe leaves the
+		// migration live.
+		vm, err := n.probeVM(ctx, id, req)
+		if err != nil {
+			slog.Warn("Preflight probe failed; migrating live", "migration_id", id, "error", err)
+		} else {
+			profile = &vm
+			if len(vm.MigrationBlockers) > 0 {
+				slog.Info("VM has migration blockers; falling back to cold migration", "migration_id", id, "blockers", strings.Join(vm.MigrationBlockers, "; "))
+				req.Cold = true
+			}
+		}
+	}
+	var placement *Placement
+	if req.DestNode == "" {
+		// Auto-select: score the kata nodes against the VM's size first.
+		// A nil placement leaves the choice to kube-scheduler below.
+		p, err := n.placeDestNode(ctx, id, req, profile)
+		if err != nil {
+			return "", fmt.Errorf("place destination: %w", err)
+		}
+		if p != nil {
+			placement = p
+			req.DestNode = p.Node
+			req.DestIP = p.InternalIP
+		}
+	}
+	// Concurrent migrations into one node each get their own listener
+	// ports (and replay sandbox); conflicting ones are rejected here.
+	slot, release, err := n.reserveDestSlot(ctx, id, req)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	var handoff *storageHandoff
+	submitted := false
+	if req.StorageHandoff {
+		// Before any Job exists: the destination must see the volumes
+		// before the dest QEMU opens them.
+		handoff, err = n.prepareStorageHandoff(ctx, id, req)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if !submitted {
+				n.rollbackStorageHandoff(context.WithoutCancel(ctx), id, handoff)
+			}
+		}()
+	}
 	cmdlinePath := cmdlinePathFor(id)
-	srcExtra := buildExtraArgs(req)
+	srcExtra := slot.extraArgs(req)
 	destExtra := srcExtra
+	if slot.SandboxID != "" {
+		destExtra += " --sandbox-id " + slot.SandboxID
+	}
+	// srcOnly holds the source's own args; the auto-select path below
+	// re-renders the source Job with them.
+	srcOnly := ""
+	if req.Cold {
+		// The last --mode wins over the template's --mode source.
+		srcOnly = " --mode cold"
+		destExtra += n.coldDestArgs(id)
+	} else if req.Compression == "auto" {
+		destExtra += n.compressionDestArgs(id)
+		if !req.ReplayCmdline {
+			// The dest configures its side from the source's choice
+			// before it reports ready, so the source waits for that
+			// rather than racing it. Replay mode waits anyway.
+			srcOnly = " --dest-ready-from-job " + n.namespace + "/" + DestJobName(id)
+		}
+	}
+	srcExtra += srcOnly
 	if req.ReplayCmdline {
 		// Source captures /proc/<qemu>/cmdline locally so it can compute
 		// the KATAMARAN_CMDLINE_B64 marker on the way out. The dest then
@@ -188,21 +292,27 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 		// stageThenStartDest patches `--replay-cmdline-from-pod
 		// <ns>/<podname>` onto the dest job's command once the source pod
 		// is up. No --replay-cmdline file flag here on the dest extra.
-		srcExtra = strings.TrimSpace(srcExtra + " --emit-cmdline-to " + cmdlinePath)
+		// The source then waits for the dest Job's KATAMARAN_DEST_READY
+		// marker instead of guessing when its listener is up.
+		srcExtra = strings.TrimSpace(srcExtra + " --emit-cmdline-to " + cmdlinePath +
+			" --dest-ready-from-job " + n.namespace + "/" + DestJobName(id))
 	}
-	srcJob, err := renderSourceJob(req, id, srcExtra)
+	srcJob, err := n.renderSourceJob(req, id, srcExtra)
 	if err != nil {
 		return "", fmt.Errorf("render source job: %w", err)
 	}
-	destJob, err := renderDestJob(req, id, destExtra)
+	destJob, err := n.renderDestJob(req, id, destExtra)
 	if err != nil {
 		return "", fmt.Errorf("render dest job: %w", err)
 	}
+	slot.annotate(srcJob, req)
+	slot.annotate(destJob, req)
+	annotateCold(srcJob, req)
 
 	if req.ReplayCmdline {
 		// Source first: it has to capture and emit the cmdline before the
 		// dest job can spawn QEMU with --replay-cmdline.
-		if _, err := n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
+		if srcJob, err = n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
 			return "", fmt.Errorf("create source job: %w", err)
 		}
 		slog.Info("Migration source job created; destination waits for cmdline replay", "migration_id", id, "source_job", srcJob.Name, "dest_job", destJob.Name, "namespace", n.namespace)
@@ -218,17 +328,17 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 
 		destNodeName, err := n.waitForDestNodeName(ctx, destJob.Name, req.PodWaitTimeoutSeconds)
 		if err != nil {
-			n.cleanupDestJob(ctx, destJob.Name, "scheduling wait failed")
+			n.cleanupJob(ctx, destJob.Name, "scheduling wait failed")
 			return "", fmt.Errorf("wait for dest pod scheduling: %w", err)
 		}
 		if destNodeName == req.SourceNode {
-			n.cleanupDestJob(ctx, destJob.Name, "same-node scheduling")
+			n.cleanupJob(ctx, destJob.Name, "same-node scheduling")
 			return "", fmt.Errorf("dest pod scheduled on source node %s; cannot migrate to same node", destNodeName)
 		}
 		disc := &nativeDiscoverer{client: n.client}
 		destIP, err := disc.LookupNodeInternalIP(ctx, destNodeName)
 		if err != nil {
-			n.cleanupDestJob(ctx, destJob.Name, "IP lookup failed")
+			n.cleanupJob(ctx, destJob.Name, "IP lookup failed")
 			return "", fmt.Errorf("resolve dest node IP: %w", err)
 		}
 		req.DestNode = destNodeName
@@ -237,12 +347,14 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 
 		// Re-render the source job now that we know DestIP. ReplayCmdline
 		// takes the earlier branch, so no --emit-cmdline-to is needed here.
-		srcJob, err = renderSourceJob(req, id, buildExtraArgs(req))
+		srcJob, err = n.renderSourceJob(req, id, slot.extraArgs(req)+srcOnly)
 		if err != nil {
 			return "", fmt.Errorf("re-render source job: %w", err)
 		}
-		if _, err := n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
-			n.cleanupDestJob(ctx, destJob.Name, "source create failed; manual cleanup may be required")
+		slot.annotate(srcJob, req)
+		annotateCold(srcJob, req)
+		if srcJob, err = n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
+			n.cleanupJob(ctx, destJob.Name, "source create failed; manual cleanup may be required")
 			return "", fmt.Errorf("create source job: %w", err)
 		}
 		slog.Info("Auto-select: migration jobs created", "migration_id", id, "source_job", srcJob.Name, "dest_job", destJob.Name, "source_node", req.SourceNode, "dest_node", req.DestNode, "namespace", n.namespace)
@@ -251,13 +363,24 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 		if _, err := n.client.BatchV1().Jobs(n.namespace).Create(ctx, destJob, metav1.CreateOptions{}); err != nil {
 			return "", fmt.Errorf("create dest job: %w", err)
 		}
-		if _, err := n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
-			n.cleanupDestJob(ctx, destJob.Name, "source create failed; manual cleanup may be required")
+		if srcJob, err = n.client.BatchV1().Jobs(n.namespace).Create(ctx, srcJob, metav1.CreateOptions{}); err != nil {
+			n.cleanupJob(ctx, destJob.Name, "source create failed; manual cleanup may be required")
 			return "", fmt.Errorf("create source job: %w", err)
 		}
 		slog.Info("Migration jobs created", "migration_id", id, "source_job", srcJob.Name, "dest_job", destJob.Name, "namespace", n.namespace)
 	}
 
+	// The Jobs' containers wait for the key Secret, which is owned by the
+	// source Job from the start so the garbage collector deletes it with
+	// the Job (TTL, Stop, or by hand).
+	if err := n.mintHandoffKey(ctx, id, srcJob); err != nil {
+		n.cleanupJob(ctx, srcJob.Name, "hand-off key create failed")
+		if !req.ReplayCmdline {
+			n.cleanupJob(ctx, destJob.Name, "hand-off key create failed")
+		}
+		return "", err
+	}
+	submitted = true
 	runCtx, cancel := context.WithCancel(context.Background())
 	run := &nativeRun{
 		srcJob:                srcJob.Name,
@@ -266,12 +389,20 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 		updates:               make(chan StatusUpdate, 8),
 		cancel:                cancel,
 		finished:              make(chan struct{}),
+		handoff:               handoff,
 	}
 	n.mu.Lock()
 	n.inflight[id] = run
 	n.mu.Unlock()
 
-	run.updates <- StatusUpdate{ID: id, Phase: PhaseSubmitted, When: time.Now()}
+	submittedUpdate := StatusUpdate{ID: id, Phase: PhaseSubmitted, When: time.Now(), Placement: placement, Cold: req.Cold}
+	if profile != nil {
+		submittedUpdate.MigrationBlockers = profile.MigrationBlockers
+	}
+	if handoff != nil && len(handoff.volumes) > 0 {
+		submittedUpdate.VolumeHandoffs = slices.Clone(handoff.volumes)
+	}
+	run.updates <- submittedUpdate
 
 	if req.ReplayCmdline {
 		// Stage cmdline + create dest job in a goroutine so Apply returns
@@ -283,6 +414,32 @@ func (n *native) Apply(ctx context.Context, req Request) (MigrationID, error) {
 	return id, nil
 }
 
+// coldAnnotation marks a source Job running `katamaran --mode cold`, so
+// Resume gives a recreated dest Job the matching --cold-from-job.
+const coldAnnotation = "katamaran.io/cold"
+
+func annotateCold(job *batchv1.Job, req Request) {
+	if !req.Cold {
+		return
+	}
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[coldAnnotation] = "true"
+}
+
+// coldDestArgs points a cold migration's dest at its source Job's
+// KATAMARAN_COLD_PLAN / KATAMARAN_COLD_DONE markers.
+func (n *native) coldDestArgs(id MigrationID) string {
+	return " --cold-from-job " + n.namespace + "/" + SourceJobName(id)
+}
+
+// compressionDestArgs points a --compression auto dest at its source
+// Job's KATAMARAN_COMPRESSION marker.
+func (n *native) compressionDestArgs(id MigrationID) string {
+	return " --compression-from-job " + n.namespace + "/" + SourceJobName(id)
+}
+
 // tailProgress watches the source pod's logs for KATAMARAN_PROGRESS and
 // KATAMARAN_RESULT markers emitted by the source binary. PROGRESS markers
 // are re-emitted as PhaseTransferring StatusUpdates with RAMTransferred /
@@ -307,9 +464,6 @@ func (n *native) tailProgress(ctx context.Context, id MigrationID, run *nativeRu
 		return // source pod never appeared; poll will surface the failure
 	}
 	const (
-		progressMarker      = "KATAMARAN_PROGRESS "
-		resultMarker        = "KATAMARAN_RESULT "
-		downtimeLimitMarker = "KATAMARAN_DOWNTIME_LIMIT "
 		// logFetchOverlapSec bounds how much of the source pod's log we
 		// re-fetch per tick. The ticker fires every 2s; a 30s window gives
 		// generous slack for transient apiserver hiccups while keeping the
@@ -323,17 +477,6 @@ func (n *native) tailProgress(ctx context.Context, id MigrationID, run *nativeRu
 	defer ticker.Stop()
 	// Reused scanner buffer: avoids allocating 64KB per tick over multi-hour migrations.
 	scanBuf := make([]byte, 0, 64*1024)
-	send := func(u StatusUpdate) bool {
-		select {
-		case <-ctx.Done():
-			return false
-		case <-run.finished:
-			return false
-		default:
-		}
-		run.send(u)
-		return true
-	}
 	overlap := logFetchOverlapSec
 	limitBytes := logFetchLimitBytes
 	// Hoisted outside the loop: same value every tick, no need to re-allocate.
@@ -389,65 +532,11 @@ func (n *native) tailProgress(ctx context.Context, id MigrationID, run *nativeRu
 			if seen[line] {
 				continue
 			}
-			if i := strings.Index(line, resultMarker); i >= 0 {
-				fields := parseProgressFields(line[i+len(resultMarker):])
-				run.resultMu.Lock()
-				run.resultDowntime = parseInt64(fields["downtime_ms"])
-				run.resultRAMXfer = parseInt64(fields["ram_transferred"])
-				run.resultRAMTotal = parseInt64(fields["ram_total"])
-				run.resultCaptured = true
-				run.resultMu.Unlock()
-				done = true
-				break
-			}
-			if i := strings.Index(line, downtimeLimitMarker); i >= 0 {
+			marker, stop := run.sourceMarker(ctx, id, line)
+			if marker {
 				seen[line] = true
-				fields := parseProgressFields(line[i+len(downtimeLimitMarker):])
-				applied := parseInt64(fields["applied_ms"])
-				rttMS := parseInt64(fields["rtt_ms"])
-				autoFlag := fields["auto"] == "true"
-				run.resultMu.Lock()
-				run.appliedDowntime = applied
-				run.rttMS = rttMS
-				run.autoDowntime = autoFlag
-				run.downtimeCaptured = true
-				run.resultMu.Unlock()
-				msg := fmt.Sprintf("downtime limit applied: %dms", applied)
-				if autoFlag {
-					msg += fmt.Sprintf(" (auto from %dms RTT)", rttMS)
-				}
-				if !send(StatusUpdate{
-					ID:                id,
-					Phase:             PhaseTransferring,
-					When:              time.Now(),
-					Message:           msg,
-					AppliedDowntimeMS: applied,
-					RTTMS:             rttMS,
-					AutoDowntime:      autoFlag,
-				}) {
-					done = true
-					break
-				}
-				continue
-			}
-			i := strings.Index(line, progressMarker)
-			if i < 0 {
-				continue
-			}
-			seen[line] = true
-			fields := parseProgressFields(line[i+len(progressMarker):])
-			if !send(StatusUpdate{
-				ID:             id,
-				Phase:          PhaseTransferring,
-				When:           time.Now(),
-				Message:        "status=" + fields["status"],
-				RAMTransferred: parseInt64(fields["ram_transferred"]),
-				RAMTotal:       parseInt64(fields["ram_total"]),
-			}) {
-				done = true
-				break
 			}
-			if fields["status"] == "failed" || fields["status"] == "cancelled" {
+			if stop {
 				done = true
 				break
 			}
@@ -462,6 +551,132 @@ func (n *native) tailProgress(ctx context.Context, id MigrationID, run *nativeRu
 	}
 }
 
+// sourceMarker applies one line of the source's output to run: PROGRESS,
+// DOWNTIME_LIMIT, STORAGE_VERIFY, HOOK, TUNING and VM_STOPPED markers are
+// sent as StatusUpdates, and the RESULT, DOWNTIME_LIMIT, TUNING and
+// VM_STOPPED fields are recorded for the final update. It reports whether
+// line was a marker, and whether to stop reading: after the RESULT
+// marker, a failed or cancelled progress status, or once the run is over.
+func (run *nativeRun) sourceMarker(ctx context.Context, id MigrationID, line string) (marker, stop bool) {
+	const (
+		progressMarker      = "KATAMARAN_PROGRESS "
+		downtimeLimitMarker = "KATAMARAN_DOWNTIME_LIMIT "
+	)
+	if i := strings.Index(line, resultMarker); i >= 0 {
+		fields := parseProgressFields(line[i+len(resultMarker):])
+		run.resultMu.Lock()
+		run.resultDowntime = parseInt64(fields["downtime_ms"])
+		run.resultRAMXfer = parseInt64(fields["ram_transferred"])
+		run.resultRAMTotal = parseInt64(fields["ram_total"])
+		run.resultMemSaved = parseInt64(fields["memory_saved"])
+		run.resultComp = fields["compression"]
+		run.resultCompRate = parseFloat64(fields["compression_ratio"])
+		run.resultCaptured = true
+		run.resultMu.Unlock()
+		return true, true
+	}
+	if i := strings.Index(line, downtimeLimitMarker); i >= 0 {
+		fields := parseProgressFields(line[i+len(downtimeLimitMarker):])
+		applied := parseInt64(fields["applied_ms"])
+		rttMS := parseInt64(fields["rtt_ms"])
+		autoFlag := fields["auto"] == "true"
+		run.resultMu.Lock()
+		run.appliedDowntime = applied
+		run.rttMS = rttMS
+		run.autoDowntime = autoFlag
+		run.downtimeCaptured = true
+		run.resultMu.Unlock()
+		msg := fmt.Sprintf("downtime limit applied: %dms", applied)
+		if autoFlag {
+			msg += fmt.Sprintf(" (auto from %dms RTT)", rttMS)
+		}
+		return true, !run.sendLive(ctx, StatusUpdate{
+			ID:                id,
+			Phase:             PhaseTransferring,
+			When:              time.Now(),
+			Message:           msg,
+			AppliedDowntimeMS: applied,
+			RTTMS:             rttMS,
+			AutoDowntime:      autoFlag,
+		})
+	}
+	if i := strings.Index(line, storageVerifyMarker); i >= 0 {
+		return true, !run.sendLive(ctx, storageVerificationUpdate(id, parseProgressFields(line[i+len(storageVerifyMarker):])))
+	}
+	if i := strings.Index(line, hookMarker); i >= 0 {
+		return true, !run.sendLive(ctx, hookResultUpdate(id, PhaseTransferring, line[i+len(hookMarker):]))
+	}
+	if i := strings.Index(line, tuningMarker); i >= 0 {
+		u := tuningUpdate(id, line[i+len(tuningMarker):])
+		if u.AppliedDowntimeMS > 0 {
+			run.resultMu.Lock()
+			run.appliedDowntime = u.AppliedDowntimeMS
+			run.resultMu.Unlock()
+		}
+		return true, !run.sendLive(ctx, u)
+	}
+	if i := strings.Index(line, vmStoppedMarker); i >= 0 {
+		at := unixMillis(parseProgressFields(line[i+len(vmStoppedMarker):])["at_unix_ms"])
+		run.resultMu.Lock()
+		run.vmStoppedAt = at
+		run.resultMu.Unlock()
+		return true, !run.sendLive(ctx, StatusUpdate{
+			ID:          id,
+			Phase:       PhaseCutover,
+			When:        time.Now(),
+			Message:     "VM paused on source",
+			VMStoppedAt: at,
+		})
+	}
+	i := strings.Index(line, progressMarker)
+	if i < 0 {
+		return false, false
+	}
+	fields := parseProgressFields(line[i+len(progressMarker):])
+	// The source keeps reporting progress while it drains the
+	// last dirty pages after STOP; those belong to the cutover.
+	phase := PhaseTransferring
+	run.resultMu.Lock()
+	if !run.vmStoppedAt.IsZero() {
+		phase = PhaseCutover
+	}
+	run.resultMu.Unlock()
+	if !run.sendLive(ctx, StatusUpdate{
+		ID:             id,
+		Phase:          phase,
+		When:           time.Now(),
+		Message:        "status=" + fields["status"],
+		RAMTransferred: parseInt64(fields["ram_transferred"]),
+		RAMTotal:       parseInt64(fields["ram_total"]),
+	}) {
+		return true, true
+	}
+	return true, fields["status"] == "failed" || fields["status"] == "cancelled"
+}
+
+// storageVerificationUpdate converts KATAMARAN_STORAGE_VERIFY fields into
+// a PhaseTransferring StatusUpdate.
+func storageVerificationUpdate(id MigrationID, fields map[string]string) StatusUpdate {
+	sv := &StorageVerification{
+		DriveID:    fields["drive_id"],
+		Mode:       fields["mode"],
+		Result:     fields["result"],
+		Extents:    int(parseInt64(fields["extents"])),
+		Mismatched: int(parseInt64(fields["mismatched"])),
+	}
+	msg := fmt.Sprintf("storage verification %s: %s (%d of %d extents differ)", sv.DriveID, sv.Result, sv.Mismatched, sv.Extents)
+	if sv.Result == "ok" {
+		msg = fmt.Sprintf("storage verification %s: ok (%d extents)", sv.DriveID, sv.Extents)
+	}
+	return StatusUpdate{
+		ID:                  id,
+		Phase:               PhaseTransferring,
+		When:                time.Now(),
+		Message:             msg,
+		StorageVerification: sv,
+	}
+}
+
 // parseProgressFields parses key=value pairs separated by spaces.
 func parseProgressFields(s string) map[string]string {
 	out := make(map[string]string, 8)
@@ -476,57 +691,139 @@ func parseProgressFields(s string) map[string]string {
 }
 
 // succeededUpdate builds the final PhaseSucceeded StatusUpdate, attaching
-// captured downtime / RAM totals from tailProgress when available.
+// captured downtime / RAM totals / STOP time from tailProgress when
+// available, plus the dest pod's RESUME time.
 //
 // poll fires PhaseSucceeded as soon as it sees the dest Job reach
 // Complete; that can race with tailProgress's 2s ticker, leaving the
 // KATAMARAN_RESULT marker unscraped even though it's already in the
 // source pod's log. To close that gap we do one synchronous final scrape
-// here when the result hasn't been captured yet.
+// here when the result hasn't been captured yet. The dest pod's
+// KATAMARAN_VM_RESUMED and KATAMARAN_DEST_READY markers are never
+// tailed, so they are always scraped here.
 func (n *native) succeededUpdate(ctx context.Context, id MigrationID, run *nativeRun) StatusUpdate {
+	u, captured := run.succeededUpdate(id)
+	// Final synchronous scrapes, bounded so a wedged apiserver never
+	// holds up the terminal status update.
+	scrapeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !captured {
+		markers := n.scrapeJobMarkers(scrapeCtx, run.srcJob, resultMarker, vmStoppedMarker)
+		if fields, ok := markers[resultMarker]; ok {
+			u.DowntimeMS = parseInt64(fields["downtime_ms"])
+			u.RAMTransferred = parseInt64(fields["ram_transferred"])
+			u.RAMTotal = parseInt64(fields["ram_total"])
+			u.MemorySavedBytes = parseInt64(fields["memory_saved"])
+			u.Compression = fields["compression"]
+			u.CompressionRatio = parseFloat64(fields["compression_ratio"])
+			run.resultMu.Lock()
+			run.resultCaptured = true
+			run.resultDowntime = u.DowntimeMS
+			run.resultRAMXfer = u.RAMTransferred
+			run.resultRAMTotal = u.RAMTotal
+			run.resultMemSaved = u.MemorySavedBytes
+			run.resultComp = u.Compression
+			run.resultCompRate = u.CompressionRatio
+			run.resultMu.Unlock()
+		}
+		if fields, ok := markers[vmStoppedMarker]; ok && u.VMStoppedAt.IsZero() {
+			u.VMStoppedAt = unixMillis(fields["at_unix_ms"])
+		}
+	}
+	destMarkers := n.scrapeJobMarkers(scrapeCtx, run.destJob, vmResumedMarker, destReadyMarker)
+	if fields, ok := destMarkers[vmResumedMarker]; ok {
+		u.VMResumedAt = unixMillis(fields["at_unix_ms"])
+	}
+	if fields, ok := destMarkers[destReadyMarker]; ok {
+		u.DestSandboxID = fields["sandbox_id"]
+	}
+	u.Hooks = n.scrapeJobHooks(scrapeCtx, run.destJob)
+	return u
+}
+
+// succeededUpdate builds the PhaseSucceeded StatusUpdate from the source
+// markers sourceMarker recorded, and reports whether the RESULT marker
+// was among them.
+func (run *nativeRun) succeededUpdate(id MigrationID) (StatusUpdate, bool) {
 	u := StatusUpdate{ID: id, Phase: PhaseSucceeded, When: time.Now()}
 	run.resultMu.Lock()
-	captured := run.resultCaptured
-	if captured {
+	defer run.resultMu.Unlock()
+	if run.resultCaptured {
 		u.DowntimeMS = run.resultDowntime
 		u.RAMTransferred = run.resultRAMXfer
 		u.RAMTotal = run.resultRAMTotal
+		u.MemorySavedBytes = run.resultMemSaved
+		u.Compression = run.resultComp
+		u.CompressionRatio = run.resultCompRate
 	}
 	if run.downtimeCaptured {
 		u.AppliedDowntimeMS = run.appliedDowntime
 		u.RTTMS = run.rttMS
 		u.AutoDowntime = run.autoDowntime
 	}
-	run.resultMu.Unlock()
-	if captured {
-		return u
-	}
-	// Final synchronous scrape, bounded so a wedged apiserver never holds
-	// up the terminal status update.
+	u.VMStoppedAt = run.vmStoppedAt
+	return u, run.resultCaptured
+}
+
+// failedDestUpdate builds the PhaseFailed update for a failed dest Job,
+// with the post-resume hook results: an aborting hook fails the Job after
+// the VM resumed.
+func (n *native) failedDestUpdate(ctx context.Context, id MigrationID, run *nativeRun, cond batchv1.JobCondition) StatusUpdate {
+	u := StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: jobFailedError("dest job failed", cond)}
 	scrapeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	if down, xfer, total, ok := n.scrapeResultMarker(scrapeCtx, run.srcJob); ok {
-		run.resultMu.Lock()
-		run.resultCaptured = true
-		run.resultDowntime = down
-		run.resultRAMXfer = xfer
-		run.resultRAMTotal = total
-		run.resultMu.Unlock()
-		u.DowntimeMS = down
-		u.RAMTransferred = xfer
-		u.RAMTotal = total
-	}
+	u.Hooks = n.scrapeJobHooks(scrapeCtx, run.destJob)
 	return u
 }
 
-// scrapeResultMarker does a one-shot bounded fetch of the source pod's recent
-// log tail and returns the latest KATAMARAN_RESULT marker's downtime /
-// transferred / total fields. Returns ok=false if no source pod exists, the
-// log stream fails, or no marker is present in the captured log window.
-func (n *native) scrapeResultMarker(ctx context.Context, srcJob string) (downtimeMS, ramXfer, ramTotal int64, ok bool) {
-	pod, err := n.firstSourcePod(ctx, srcJob, 0)
+// Stdout markers scraped from finished Job pods by succeededUpdate.
+const (
+	resultMarker        = "KATAMARAN_RESULT "
+	vmStoppedMarker     = "KATAMARAN_VM_STOPPED "
+	storageVerifyMarker = "KATAMARAN_STORAGE_VERIFY "
+	vmResumedMarker     = "KATAMARAN_VM_RESUMED "
+	destReadyMarker     = "KATAMARAN_DEST_READY "
+)
+
+// scrapeJobMarkers does a one-shot bounded fetch of the recent log tail of
+// jobName's pod and returns the key=value fields of the last line carrying
+// each of markers, keyed by marker. Markers absent from the captured log
+// window (or every marker, when the pod or its log stream is unavailable)
+// are missing from the result.
+func (n *native) scrapeJobMarkers(ctx context.Context, jobName string, markers ...string) map[string]map[string]string {
+	found := make(map[string]map[string]string, len(markers))
+	n.scanJobLog(ctx, jobName, func(line string) {
+		for _, m := range markers {
+			if i := strings.Index(line, m); i >= 0 {
+				// Don't stop at the first hit — keep the LAST marker,
+				// which is what tailProgress would have picked up too.
+				found[m] = parseProgressFields(line[i+len(m):])
+			}
+		}
+	})
+	return found
+}
+
+// scrapeJobHooks returns the results of every KATAMARAN_HOOK marker in the
+// recent log tail of jobName's pod, in order.
+func (n *native) scrapeJobHooks(ctx context.Context, jobName string) []HookResult {
+	var hooks []HookResult
+	n.scanJobLog(ctx, jobName, func(line string) {
+		if i := strings.Index(line, hookMarker); i >= 0 {
+			hooks = append(hooks, parseHookMarker(line[i+len(hookMarker):]))
+		}
+	})
+	return hooks
+}
+
+// scanJobLog feeds each line of the recent log tail of jobName's pod to fn.
+// It does nothing when the pod or its log stream is unavailable.
+func (n *native) scanJobLog(ctx context.Context, jobName string, fn func(line string)) {
+	pod, err := n.waitForJobPod(ctx, jobName, "pod", 0, func(p corev1.Pod) string {
+		return p.Name
+	})
 	if err != nil {
-		return 0, 0, 0, false
+		return
 	}
 	tailLines := int64(200)
 	limitBytes := int64(1024 * 1024)
@@ -536,27 +833,24 @@ func (n *native) scrapeResultMarker(ctx context.Context, srcJob string) (downtim
 		LimitBytes: &limitBytes,
 	}).Stream(ctx)
 	if err != nil {
-		return 0, 0, 0, false
+		return
 	}
 	defer func() { _ = stream.Close() }()
-	const marker = "KATAMARAN_RESULT "
 	scanner := bufio.NewScanner(stream)
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	for scanner.Scan() {
-		line := scanner.Text()
-		i := strings.Index(line, marker)
-		if i < 0 {
-			continue
-		}
-		fields := parseProgressFields(line[i+len(marker):])
-		downtimeMS = parseInt64(fields["downtime_ms"])
-		ramXfer = parseInt64(fields["ram_transferred"])
-		ramTotal = parseInt64(fields["ram_total"])
-		ok = true
-		// Don't break — take the LAST marker, which is what tailProgress
-		// would have picked up too.
+		fn(scanner.Text())
 	}
-	return downtimeMS, ramXfer, ramTotal, ok
+}
+
+// unixMillis parses a marker's at_unix_ms field. Returns the zero time
+// for missing or non-positive values.
+func unixMillis(s string) time.Time {
+	ms := parseInt64(s)
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
 }
 
 func parseInt64(s string) int64 {
@@ -564,6 +858,11 @@ func parseInt64(s string) int64 {
 	return v
 }
 
+func parseFloat64(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
 func jobConditionAttrs(cond batchv1.JobCondition) []any {
 	attrs := make([]any, 0, 4)
 	if cond.Reason != "" {
@@ -696,6 +995,20 @@ func (run *nativeRun) send(u StatusUpdate) {
 	}
 }
 
+// sendLive is send for the marker readers: it reports false, without
+// sending, once ctx is done or the run is over.
+func (run *nativeRun) sendLive(ctx context.Context, u StatusUpdate) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-run.finished:
+		return false
+	default:
+	}
+	run.send(u)
+	return true
+}
+
 // waitForJobPod polls until pick returns a non-empty value for any pod under
 // jobName, then returns it. Shared backbone for firstSourcePod (returns the
 // first pod name as soon as any pod appears) and waitForDestNodeName (returns
@@ -794,17 +1107,38 @@ func (n *native) Resume(ctx context.Context, id MigrationID, req Request) (bool,
 		return false, fmt.Errorf("get dest job %s: %w", destName, err)
 	}
 	srcName := SourceJobName(id)
-	if _, err := n.client.BatchV1().Jobs(n.namespace).Get(ctx, srcName, metav1.GetOptions{}); err != nil {
+	srcJob, err := n.client.BatchV1().Jobs(n.namespace).Get(ctx, srcName, metav1.GetOptions{})
+	if err != nil {
 		return false, fmt.Errorf("get source job %s: %w", srcName, err)
 	}
 	srcPod, err := n.firstSourcePod(ctx, srcName, req.PodWaitTimeoutSeconds)
 	if err != nil {
 		return false, fmt.Errorf("locate source pod: %w", err)
 	}
-	destJob, err := renderDestJob(req, id, buildExtraArgs(req))
+	// The source is already connecting to the slot Apply reserved; the
+	// dest must listen on the same ports. Jobs from before slots existed
+	// carry no annotation and use slot 0, the historical defaults.
+	slot, ok := slotFromJob(srcJob, id, req)
+	if !ok {
+		slot = newDestSlot(0, id, req)
+		slot.SandboxID = ""
+	}
+	destExtra := slot.extraArgs(req)
+	if slot.SandboxID != "" {
+		destExtra += " --sandbox-id " + slot.SandboxID
+	}
+	// The preflight may have switched the request to cold after the
+	// caller built req; the source Job records what actually runs.
+	if srcJob.Annotations[coldAnnotation] == "true" {
+		destExtra += n.coldDestArgs(id)
+	} else if req.Compression == "auto" {
+		destExtra += n.compressionDestArgs(id)
+	}
+	destJob, err := n.renderDestJob(req, id, destExtra)
 	if err != nil {
 		return false, fmt.Errorf("render dest job: %w", err)
 	}
+	slot.annotate(destJob, req)
 	patched, err := injectReplayFromPod(destJob, n.namespace, srcPod)
 	if err != nil {
 		return false, fmt.Errorf("inject replay flag: %w", err)
@@ -872,9 +1206,13 @@ func (n *native) poll(ctx context.Context, id MigrationID, run *nativeRun) {
 		}
 	}()
 	const interval = 2 * time.Second
-	const sourceFailGrace = 90 * time.Second // how long to wait for dest after source dies
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	// finish settles the storage handoff before the terminal update.
+	finish := func(u StatusUpdate) {
+		n.settleStorageHandoff(ctx, id, run, &u)
+		run.updates <- u
+	}
 	announcedTransferring := false
 	var sourceFailedAt time.Time
 	var destStatusErrors, srcStatusErrors int
@@ -882,7 +1220,7 @@ func (n *native) poll(ctx context.Context, id MigrationID, run *nativeRun) {
 		select {
 		case <-ctx.Done():
 			slog.Warn("Migration poll canceled", "migration_id", id, "source_job", run.srcJob, "dest_job", run.destJob, "error", ctx.Err())
-			run.updates <- StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: ctx.Err()}
+			finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: ctx.Err()})
 			return
 		case <-ticker.C:
 			srcJob, srcErr := n.client.BatchV1().Jobs(n.namespace).Get(ctx, run.srcJob, metav1.GetOptions{})
@@ -893,13 +1231,13 @@ func (n *native) poll(ctx context.Context, id MigrationID, run *nativeRun) {
 				destStatusErrors = 0
 				if cond, ok := LatestTerminalJobCondition(destJob); ok && cond.Type == batchv1.JobComplete {
 					slog.Info("Migration destination job completed", "migration_id", id, "source_job", run.srcJob, "dest_job", run.destJob)
-					run.updates <- n.succeededUpdate(ctx, id, run)
+					finish(n.succeededUpdate(ctx, id, run))
 					return
 				} else if ok && cond.Type == batchv1.JobFailed {
 					attrs := []any{"migration_id", id, "source_job", run.srcJob, "dest_job", run.destJob}
 					attrs = append(attrs, jobConditionAttrs(cond)...)
 					slog.Error("Migration destination job failed", attrs...)
-					run.updates <- StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: jobFailedError("dest job failed", cond)}
+					finish(n.failedDestUpdate(ctx, id, run, cond))
 					return
 				}
 			} else if !apierrors.IsNotFound(destErr) {
@@ -913,7 +1251,7 @@ func (n *native) poll(ctx context.Context, id MigrationID, run *nativeRun) {
 			if srcErr != nil {
 				if apierrors.IsNotFound(srcErr) {
 					slog.Error("Migration source job disappeared", "migration_id", id, "source_job", run.srcJob, "dest_job", run.destJob)
-					run.updates <- StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: errors.New("source job disappeared")}
+					finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: errors.New("source job disappeared")})
 					return
 				}
 				srcStatusErrors++
@@ -932,7 +1270,7 @@ func (n *native) poll(ctx context.Context, id MigrationID, run *nativeRun) {
 					attrs := []any{"migration_id", id, "source_job", run.srcJob, "dest_job", run.destJob, "grace", sourceFailGrace}
 					attrs = append(attrs, jobConditionAttrs(srcCond)...)
 					slog.Error("Migration source job failed and destination did not complete", attrs...)
-					run.updates <- StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: jobFailedError("source job failed and dest did not complete within grace window", srcCond)}
+					finish(StatusUpdate{ID: id, Phase: PhaseFailed, When: time.Now(), Error: jobFailedError("source job failed and dest did not complete within grace window", srcCond)})
 					return
 				}
 				continue // give dest time to land RESUME and exit 0
@@ -978,6 +1316,34 @@ func buildExtraArgs(req Request) string {
 			args = append(args, "--auto-downtime-floor-ms", strconv.Itoa(req.AutoDowntimeFloorMS))
 		}
 	}
+	if req.VerifyStorage != "" && req.VerifyStorage != "off" {
+		args = append(args, "--verify-storage", req.VerifyStorage)
+	}
+	if req.Balloon && !req.Cold {
+		args = append(args, "--balloon")
+	}
+	if req.Compression != "" && req.Compression != "none" && !req.Cold {
+		args = append(args, "--compression", req.Compression)
+		if req.CompressionLevel > 0 {
+			args = append(args, "--compression-level", strconv.Itoa(req.CompressionLevel))
+		}
+	}
+	if req.AdaptiveTuning && !req.Cold {
+		args = append(args, "--adaptive-tuning")
+		if req.MaxDowntimeMS > 0 {
+			args = append(args, "--max-downtime", strconv.Itoa(req.MaxDowntimeMS))
+		}
+	}
+	if req.MaxBandwidthMBps > 0 && !req.Cold {
+		args = append(args, "--max-bandwidth", strconv.Itoa(req.MaxBandwidthMBps))
+	}
+	// Each binary ignores (with a warning) the other side's hooks flag.
+	if len(req.Hooks.PreCutover) > 0 {
+		args = append(args, "--pre-cutover-hooks", encodeHooks(req.Hooks.PreCutover))
+	}
+	if len(req.Hooks.PostResume) > 0 {
+		args = append(args, "--post-resume-hooks", encodeHooks(req.Hooks.PostResume))
+	}
 	if req.CNIConvergenceDelaySeconds > 0 {
 		args = append(args, "--cni-convergence-delay", fmt.Sprintf("%ds", req.CNIConvergenceDelaySeconds))
 	}
@@ -22,18 +22,23 @@ var sourceJobTemplate []byte
 //go:embed templates/job-dest.yaml
 var destJobTemplate []byte
 
+//go:embed templates/job-probe.yaml
+var probeJobTemplate []byte
+
 // jobSuffix returns the per-migration Job name suffix. Migration IDs are
 // 16 lowercase hex chars — short enough to embed in a 253-char Job name
 // and long enough to avoid collisions across concurrent migrations.
 func jobSuffix(id MigrationID) string { return string(id) }
 
 // renderSourceJob and renderDestJob substitute ${VAR} placeholders in the
-// embedded templates and decode the result into a typed *batchv1.Job ready
-// for Create. The substitution intentionally mirrors `envsubst $VAR` from
-// migrate.sh: simple shell-style variable expansion with no defaults or
-// nested expressions.
-func renderSourceJob(req Request, id MigrationID, extraArgs string) (*batchv1.Job, error) {
-	return renderJob(sourceJobTemplate, map[string]string{
+// embedded templates (or their JobTemplates replacements) and decode the
+// result into a typed *batchv1.Job ready for Create. The substitution
+// intentionally mirrors `envsubst $VAR` from migrate.sh: simple
+// shell-style variable expansion with no defaults or nested expressions.
+// The JobTemplates patch and the request's JobOverrides are applied on
+// top, and the result is checked against the JobPolicy.
+func (n *native) renderSourceJob(req Request, id MigrationID, extraArgs string) (*batchv1.Job, error) {
+	job, err := n.renderCustomJob(n.templates.Source, sourceJobTemplate, map[string]string{
 		"NODE_NAME":              req.SourceNode,
 		"IMAGE":                  req.Image,
 		"QMP_SOCKET":             cmp.Or(req.SourceQMP, "/run/vc/vm/extra-monitor.sock"),
@@ -43,10 +48,18 @@ func renderSourceJob(req Request, id MigrationID, extraArgs string) (*batchv1.Jo
 		"KATAMARAN_MIGRATION_ID": string(id),
 		"JOB_SUFFIX":             jobSuffix(id),
 	})
+	if err != nil {
+		return nil, err
+	}
+	applyJobOverrides(job, req.JobOverrides, false)
+	if err := checkJobPolicy(job, "source", id, req.Image, n.templates.Policy); err != nil {
+		return nil, fmt.Errorf("job policy: %w", err)
+	}
+	return job, nil
 }
 
-func renderDestJob(req Request, id MigrationID, extraArgs string) (*batchv1.Job, error) {
-	job, err := renderJob(destJobTemplate, map[string]string{
+func (n *native) renderDestJob(req Request, id MigrationID, extraArgs string) (*batchv1.Job, error) {
+	job, err := n.renderCustomJob(n.templates.Dest, destJobTemplate, map[string]string{
 		"NODE_NAME":              req.DestNode,
 		"IMAGE":                  req.Image,
 		"QMP_SOCKET":             cmp.Or(req.DestQMP, "/run/vc/vm/katamaran-dest/qmp.sock"),
@@ -80,6 +93,43 @@ func renderDestJob(req Request, id MigrationID, extraArgs string) (*batchv1.Job,
 		}
 	}
 
+	applyJobOverrides(job, req.JobOverrides, true)
+	if err := checkJobPolicy(job, "dest", id, req.Image, n.templates.Policy); err != nil {
+		return nil, fmt.Errorf("job policy: %w", err)
+	}
+	return job, nil
+}
+
+// renderProbeJob renders the VM profile probe for req's source pod. Only
+// the flags `katamaran --mode probe` understands are passed. The
+// per-migration JobOverrides do not apply to the probe.
+func (n *native) renderProbeJob(req Request, id MigrationID) (*batchv1.Job, error) {
+	args := []string{"--pod-name", req.SourcePod.Name, "--pod-namespace", req.SourcePod.Namespace}
+	if req.SourceQMP != "" {
+		args = append(args, "--qmp", req.SourceQMP)
+	}
+	if req.SharedStorage {
+		args = append(args, "--shared-storage")
+	}
+	if req.LogLevel != "" {
+		args = append(args, "--log-level", req.LogLevel)
+	}
+	if req.LogFormat != "" {
+		args = append(args, "--log-format", req.LogFormat)
+	}
+	job, err := n.renderCustomJob(n.templates.Probe, probeJobTemplate, map[string]string{
+		"NODE_NAME":              req.SourceNode,
+		"IMAGE":                  req.Image,
+		"EXTRA_ARGS":             strings.Join(args, " "),
+		"KATAMARAN_MIGRATION_ID": string(id),
+		"JOB_SUFFIX":             jobSuffix(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJobPolicy(job, "probe", id, req.Image, n.templates.Policy); err != nil {
+		return nil, fmt.Errorf("job policy: %w", err)
+	}
 	return job, nil
 }
 
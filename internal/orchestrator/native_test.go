@@ -2,7 +2,12 @@ package orchestrator
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"maps"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -126,6 +131,136 @@ func TestNative_Apply_CreatesBothJobs(t *testing.T) {
 	}
 }
 
+func TestNative_Apply_MintsHandoffKey(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+	id, err := n.Apply(context.Background(), validRequest())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	secret, err := cs.CoreV1().Secrets("kube-system").Get(context.Background(), HandoffKeySecretName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get hand-off key secret: %v", err)
+	}
+	if key := secret.StringData["key"]; len(key) != 2*handoffKeyBytes {
+		t.Fatalf("key = %q, want %d hex chars", key, 2*handoffKeyBytes)
+	}
+	if refs := secret.OwnerReferences; len(refs) != 1 || refs[0].Kind != "Job" || refs[0].Name != SourceJobName(id) {
+		t.Fatalf("owner references = %+v, want the source job", refs)
+	}
+	for _, name := range []string{SourceJobName(id), DestJobName(id)} {
+		job, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		var ref *corev1.SecretKeySelector
+		for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+			if env.Name == "KATAMARAN_HANDOFF_KEY" && env.ValueFrom != nil {
+				ref = env.ValueFrom.SecretKeyRef
+			}
+		}
+		if ref == nil || ref.Name != HandoffKeySecretName(id) || ref.Key != "key" {
+			t.Fatalf("%s hand-off key env = %+v", name, ref)
+		}
+	}
+}
+
+func TestNative_Apply_JobFailureLeavesNoHandoffKey(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "jobs", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+	n := NewFromClient(cs)
+	if _, err := n.Apply(context.Background(), validRequest()); err == nil {
+		t.Fatal("Apply succeeded, want job create error")
+	}
+	secrets, err := cs.CoreV1().Secrets("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list secrets: %v", err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Fatalf("hand-off key secret left behind: %+v", secrets.Items)
+	}
+}
+
+func TestNative_Apply_HandoffKeyFailureDeletesJobs(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+	n := NewFromClient(cs)
+	if _, err := n.Apply(context.Background(), validRequest()); err == nil {
+		t.Fatal("Apply succeeded, want secret create error")
+	}
+	jobs, err := cs.BatchV1().Jobs("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Fatalf("jobs left behind: %+v", jobs.Items)
+	}
+}
+
+func TestNative_Apply_ColdPassesModeAndSourceJob(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+	req := validRequest()
+	req.Cold = true
+	id, err := n.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	src, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), SourceJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get source job: %v", err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(jobCommand(t, *src)), "--mode cold") {
+		t.Fatalf("source command does not end with --mode cold: %s", jobCommand(t, *src))
+	}
+	if src.Annotations[coldAnnotation] != "true" {
+		t.Fatalf("source job annotations = %v, want %s", src.Annotations, coldAnnotation)
+	}
+	dest, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), DestJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get dest job: %v", err)
+	}
+	if want := "--cold-from-job kube-system/" + SourceJobName(id); !strings.Contains(jobCommand(t, *dest), want) {
+		t.Fatalf("dest command missing %q: %s", want, jobCommand(t, *dest))
+	}
+}
+
+func TestNative_Apply_AutoCompressionLinksJobs(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+	req := validRequest()
+	req.Compression = "auto"
+	id, err := n.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	src, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), SourceJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get source job: %v", err)
+	}
+	for _, want := range []string{"--compression auto", "--dest-ready-from-job kube-system/" + DestJobName(id)} {
+		if !strings.Contains(jobCommand(t, *src), want) {
+			t.Fatalf("source command missing %q: %s", want, jobCommand(t, *src))
+		}
+	}
+	dest, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), DestJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get dest job: %v", err)
+	}
+	if want := "--compression-from-job kube-system/" + SourceJobName(id); !strings.Contains(jobCommand(t, *dest), want) {
+		t.Fatalf("dest command missing %q: %s", want, jobCommand(t, *dest))
+	}
+}
+
 func TestNative_Apply_ReplayCmdlineStagesDestAfterSourcePodAppears(t *testing.T) {
 	t.Parallel()
 	cs := fake.NewSimpleClientset()
@@ -160,6 +295,9 @@ func TestNative_Apply_ReplayCmdlineStagesDestAfterSourcePodAppears(t *testing.T)
 	if !strings.Contains(srcCmd, "--emit-cmdline-to "+cmdlinePathFor(id)) {
 		t.Fatalf("source command missing replay capture flag: %s", srcCmd)
 	}
+	if want := "--dest-ready-from-job " + DefaultJobNamespace + "/" + DestJobName(id); !strings.Contains(srcCmd, want) {
+		t.Fatalf("source command missing %q: %s", want, srcCmd)
+	}
 
 	dest := waitForJob(t, cs, DestJobName(id))
 	destCmd := jobCommand(t, *dest)
@@ -452,21 +590,177 @@ func TestSucceededUpdate_RecoversFromTailRace(t *testing.T) {
 		resultDowntime:   42,
 		resultRAMXfer:    111,
 		resultRAMTotal:   222,
+		resultMemSaved:   333,
+		resultComp:       "zstd",
+		resultCompRate:   2.5,
 		downtimeCaptured: true,
 		appliedDowntime:  25,
 		rttMS:            3,
 		autoDowntime:     true,
+		vmStoppedAt:      time.UnixMilli(1700000000123),
 	}
 	u := n.succeededUpdate(context.Background(), MigrationID("id1"), run)
 	if u.Phase != PhaseSucceeded {
 		t.Fatalf("phase = %s, want %s", u.Phase, PhaseSucceeded)
 	}
-	if u.DowntimeMS != 42 || u.RAMTransferred != 111 || u.RAMTotal != 222 {
+	if u.DowntimeMS != 42 || u.RAMTransferred != 111 || u.RAMTotal != 222 || u.MemorySavedBytes != 333 ||
+		u.Compression != "zstd" || u.CompressionRatio != 2.5 {
 		t.Errorf("captured result not threaded: %+v", u)
 	}
 	if u.AppliedDowntimeMS != 25 || u.RTTMS != 3 || !u.AutoDowntime {
 		t.Errorf("captured downtime-limit not threaded: %+v", u)
 	}
+	if !u.VMStoppedAt.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("captured STOP time not threaded: %v", u.VMStoppedAt)
+	}
+}
+
+// TestStorageVerificationUpdate covers the KATAMARAN_STORAGE_VERIFY
+// marker conversion done by tailProgress.
+func TestStorageVerificationUpdate(t *testing.T) {
+	t.Parallel()
+	u := storageVerificationUpdate("m1", parseProgressFields("drive_id=drive-virtio-disk0 mode=sample extents=64 mismatched=2 result=mismatch"))
+	want := StorageVerification{DriveID: "drive-virtio-disk0", Mode: "sample", Result: "mismatch", Extents: 64, Mismatched: 2}
+	if u.Phase != PhaseTransferring || u.StorageVerification == nil || *u.StorageVerification != want {
+		t.Fatalf("update = %+v, verification = %+v", u, u.StorageVerification)
+	}
+	if u.Message != "storage verification drive-virtio-disk0: mismatch (2 of 64 extents differ)" {
+		t.Errorf("message = %q", u.Message)
+	}
+}
+
+// TestHookResultUpdate covers the KATAMARAN_HOOK marker conversion done
+// by tailProgress and the dest scrape.
+func TestHookResultUpdate(t *testing.T) {
+	t.Parallel()
+	u := hookResultUpdate("m1", PhaseTransferring, `phase=preCutover name=flush-wal type=exec result=failed duration_ms=1500 error="command terminated with non-zero exit code: WAL busy"`)
+	want := HookResult{Phase: "preCutover", Name: "flush-wal", Type: "exec", Result: "failed", DurationMS: 1500, Error: "command terminated with non-zero exit code: WAL busy"}
+	if u.Phase != PhaseTransferring || len(u.Hooks) != 1 || u.Hooks[0] != want {
+		t.Fatalf("update = %+v", u)
+	}
+	if u.Message != "preCutover hook flush-wal: failed: command terminated with non-zero exit code: WAL busy" {
+		t.Errorf("message = %q", u.Message)
+	}
+	if hr := parseHookMarker("phase=postResume name=register type=http result=ok duration_ms=12"); hr.Result != "ok" || hr.Error != "" || hr.DurationMS != 12 {
+		t.Errorf("parseHookMarker = %+v", hr)
+	}
+}
+
+// TestBuildExtraArgs_Hooks: each phase's hooks travel as one base64url
+// JSON flag the binary decodes with migration.ParseHooks.
+func TestBuildExtraArgs_Hooks(t *testing.T) {
+	t.Parallel()
+	hooks := MigrationHooks{
+		PreCutover: []Hook{{Name: "flush-wal", Exec: &ExecHook{Command: []string{"sh", "-c", "sync; echo 'done'"}}}},
+	}
+	args := strings.Fields(buildExtraArgs(Request{Hooks: hooks}))
+	i := slices.Index(args, "--pre-cutover-hooks")
+	if i < 0 || i+1 >= len(args) {
+		t.Fatalf("args = %v, want --pre-cutover-hooks", args)
+	}
+	if slices.Contains(args, "--post-resume-hooks") {
+		t.Errorf("args = %v, want no --post-resume-hooks", args)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(args[i+1])
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var got []Hook
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, hooks.PreCutover) {
+		t.Errorf("hooks = %+v, want %+v", got, hooks.PreCutover)
+	}
+}
+
+func TestBuildExtraArgs_Balloon(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want bool
+	}{
+		{"off", Request{}, false},
+		{"live", Request{Balloon: true}, true},
+		{"cold", Request{Balloon: true, Cold: true}, false},
+	} {
+		if got := slices.Contains(strings.Fields(buildExtraArgs(tc.req)), "--balloon"); got != tc.want {
+			t.Errorf("%s: --balloon passed = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildExtraArgs_Compression(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"none", Request{Compression: "none"}, ""},
+		{"zstd", Request{Compression: "zstd", CompressionLevel: 3, MultifdChannels: 4}, "--compression zstd --compression-level 3"},
+		{"auto", Request{Compression: "auto"}, "--compression auto"},
+		{"cold", Request{Compression: "xbzrle", Cold: true}, ""},
+	} {
+		got := buildExtraArgs(tc.req)
+		if tc.want == "" && strings.Contains(got, "--compression") || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: buildExtraArgs = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildExtraArgs_AdaptiveTuning(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want []string
+	}{
+		{"off", Request{MaxDowntimeMS: 300}, nil},
+		{"on", Request{AdaptiveTuning: true, MaxDowntimeMS: 300, MaxBandwidthMBps: 1024}, []string{"--adaptive-tuning --max-downtime 300", "--max-bandwidth 1024"}},
+		{"cap only", Request{MaxBandwidthMBps: 1024}, []string{"--max-bandwidth 1024"}},
+		{"cold", Request{AdaptiveTuning: true, MaxBandwidthMBps: 1024, Cold: true}, nil},
+	} {
+		got := buildExtraArgs(tc.req)
+		if tc.want == nil && (strings.Contains(got, "--adaptive-tuning") || strings.Contains(got, "--max-")) {
+			t.Errorf("%s: buildExtraArgs = %q, want no tuning flags", tc.name, got)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: buildExtraArgs = %q, want %q", tc.name, got, w)
+			}
+		}
+	}
+}
+
+func TestTuningUpdate(t *testing.T) {
+	t.Parallel()
+	u := tuningUpdate("m1", "step=2 param=downtime_limit_ms old=25 new=50 expected_downtime_ms=380 dirty_rate_bps=409600000 throughput_mbps=8000 remaining=1073741824")
+	want := TuningAdjustment{Step: 2, Param: TuningParamDowntime, Old: 25, New: 50, ExpectedDowntimeMS: 380, DirtyRateBps: 409600000, ThroughputMbps: 8000, RAMRemaining: 1 << 30}
+	if u.Tuning == nil || *u.Tuning != want {
+		t.Fatalf("Tuning = %+v, want %+v", u.Tuning, want)
+	}
+	if u.Phase != PhaseTransferring || u.AppliedDowntimeMS != 50 {
+		t.Errorf("update = %+v, want PhaseTransferring with the tuned downtime limit", u)
+	}
+	if u := tuningUpdate("m1", "step=1 param=max_bandwidth old=1073741824 new=2147483648"); u.AppliedDowntimeMS != 0 {
+		t.Errorf("bandwidth step set AppliedDowntimeMS = %d", u.AppliedDowntimeMS)
+	}
+}
+
+// TestUnixMillis covers the at_unix_ms parser behind the
+// KATAMARAN_VM_STOPPED / KATAMARAN_VM_RESUMED markers.
+func TestUnixMillis(t *testing.T) {
+	fields := parseProgressFields("at_unix_ms=1700000000123")
+	if got := unixMillis(fields["at_unix_ms"]); !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("unixMillis = %v", got)
+	}
+	for _, s := range []string{"", "0", "-5", "abc"} {
+		if got := unixMillis(s); !got.IsZero() {
+			t.Errorf("unixMillis(%q) = %v, want zero", s, got)
+		}
+	}
 }
 
 // TestSucceededUpdate_NoCaptureFallsBackCleanly: when neither
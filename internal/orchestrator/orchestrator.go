@@ -3,13 +3,18 @@
 // them via the Kubernetes API, and reports Status back. It is the layer that
 // the dashboard's HTTP handlers and the Migration CRD controller both consume.
 //
-// One implementation exists: the in-cluster client-go path
+// The main implementation is the in-cluster client-go path
 // (native.go). It renders the Jobs in-process, submits them via the
 // apiserver, and reconciles status by polling Job conditions.
 // Constructed via New / NewFromKubeconfig / NewFromClient and consumed
 // by the dashboard, the Migration CRD controller, and the
 // katamaran-orchestrator CLI.
 //
+// WithAgents (agent.go) wraps it to run migrations on the per-node
+// katamaran-agent instead of in Jobs, falling back to the Jobs for what
+// the agents cannot run. NewDirect (direct.go) runs migrations between
+// hosts without Kubernetes, over the same NodeAgent interface.
+//
 // deploy/migrate.sh is a standalone bash wrapper for manual shell-driven
 // testing. It applies the same Job templates via envsubst + kubectl and
 // is not exercised through this package.
@@ -106,6 +111,12 @@ type MigrationID string
 // started).
 var ErrUnknownID = errors.New("unknown migration ID")
 
+// ErrConflict is returned by Apply when the request collides with a
+// migration already in flight: the same source VM, the same destination
+// QEMU, or no free listener slot left on the destination node. The
+// request is valid and can be retried once the other migration finishes.
+var ErrConflict = errors.New("conflicting migration in flight")
+
 // MigrationIDLabel is the Kubernetes label key used to tag Jobs belonging
 // to a specific migration, allowing the controller to find them.
 const MigrationIDLabel = "katamaran.io/migration-id"
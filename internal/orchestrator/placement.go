@@ -0,0 +1,506 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Destination picker.
+//
+// When a request leaves DestNode empty, the dest Job used to be handed to
+// kube-scheduler with the source pod's nodeSelector/tolerations and an
+// anti-affinity to the source node. kube-scheduler only sees the Job's
+// 50m CPU request, not the VM that is about to land on the node. The
+// picker instead scores every kata node (Discoverer.ListKataNodes) on:
+//
+//   - memory: allocatable minus running pods' requests, vs the VM's -m
+//   - CPU model: NFD cpu-model labels vs the source node's (a -cpu host
+//     VM cannot resume on a different model)
+//   - disk: allocatable ephemeral-storage minus requests, vs the sizes of
+//     the drives a non-shared-storage migration mirrors
+//   - in-flight migrations already targeting the node
+//   - topology: same zone / region as the source
+//
+// The VM facts come from a short read-only probe Job on the source node
+// (`katamaran --mode probe`, see job-probe.yaml). A failed probe is not
+// fatal: nodes are then scored without the VM size. With no kata node
+// other than the source, the kube-scheduler path is used as before.
+
+// Score weights. A node's score is the sum of its per-input points.
+const (
+	memoryWeight   = 40 // scaled by free memory left after the VM
+	diskWeight     = 20 // scaled by free disk left after the mirror
+	cpuMatchPoints = 20 // same CPU model (or vendor for named models)
+	sameZonePoints = 10
+	sameRegion     = 5
+	inflightCost   = 15 // per migration already targeting the node
+)
+
+// NFD (node-feature-discovery) and well-known topology labels.
+const (
+	cpuVendorLabel = "feature.node.kubernetes.io/cpu-model.vendor_id"
+	cpuFamilyLabel = "feature.node.kubernetes.io/cpu-model.family"
+	cpuModelLabel  = "feature.node.kubernetes.io/cpu-model.id"
+	archLabel      = "kubernetes.io/arch"
+	zoneLabel      = "topology.kubernetes.io/zone"
+	regionLabel    = "topology.kubernetes.io/region"
+)
+
+const (
+	vmProfileMarker     = "KATAMARAN_VM_PROFILE "
+	defaultProbeTimeout = 90 * time.Second
+)
+
+// VMProfile is what the picker knows about the VM being migrated. Zero
+// fields are unknown.
+type VMProfile struct {
+	MemoryBytes int64  // QEMU -m
+	CPUModel    string // QEMU -cpu model, e.g. "host" or "Skylake-Server"
+	DiskBytes   int64  // summed virtual size of the mirrored drives
+	// MigrationBlockers are the reasons QEMU refuses to live-migrate
+	// the VM (e.g. VFIO passthrough); AllowColdFallback acts on them.
+	MigrationBlockers []string
+}
+
+// Placement is the picker's choice, reported on the PhaseSubmitted
+// StatusUpdate. Reasons lists one line per scoring input.
+type Placement struct {
+	Node       string
+	InternalIP string
+	Score      int
+	Reasons    []string
+}
+
+// nodeLoad is what is already committed to a node.
+type nodeLoad struct {
+	memoryRequested  int64
+	storageRequested int64
+	inflight         int
+}
+
+// nodeScore is one candidate's evaluation. Rejected is non-empty when
+// the node cannot host the VM at all.
+type nodeScore struct {
+	node     NodeInfo
+	score    int
+	reasons  []string
+	rejected string
+}
+
+// vmProfileFromCmdline extracts the picker inputs from a QEMU argv.
+func vmProfileFromCmdline(args []string) VMProfile {
+	var p VMProfile
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-m":
+			p.MemoryBytes, _ = parseQEMUMemory(args[i+1])
+		case "-cpu":
+			p.CPUModel, _, _ = strings.Cut(args[i+1], ",")
+		}
+	}
+	return p
+}
+
+// parseQEMUMemory parses a -m value: "2048" (MiB), "2048M", "2G",
+// "1.5G", or the long form "size=2048M,slots=10,maxmem=12G". Only the
+// boot size counts; hotplug headroom (maxmem) is not reserved.
+func parseQEMUMemory(v string) (int64, bool) {
+	size, _, _ := strings.Cut(v, ",")
+	size = strings.TrimPrefix(size, "size=")
+	if size == "" {
+		return 0, false
+	}
+	mult := float64(1 << 20) // QEMU's default unit is MiB
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "B":
+		mult = 1
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if c := size[len(size)-1]; c < '0' || c > '9' {
+		size = size[:len(size)-1]
+	}
+	f, err := strconv.ParseFloat(size, 64)
+	if err != nil || f <= 0 {
+		return 0, false
+	}
+	return int64(f * mult), true
+}
+
+// pickDestNode scores nodes for req and returns the best one, or nil when
+// there is no candidate besides the source node (caller falls back to
+// kube-scheduler). Ties go to the lexically smallest node name so the
+// choice is deterministic. An error lists why every candidate was
+// rejected.
+func pickDestNode(nodes []NodeInfo, req Request, vm VMProfile, loads map[string]nodeLoad) (*Placement, error) {
+	var src *NodeInfo
+	for i := range nodes {
+		if nodes[i].Name == req.SourceNode {
+			src = &nodes[i]
+		}
+	}
+	var scored []nodeScore
+	for _, n := range nodes {
+		if n.Name == req.SourceNode {
+			continue
+		}
+		scored = append(scored, scoreNode(n, src, req, vm, loads[n.Name]))
+	}
+	if len(scored) == 0 {
+		return nil, nil
+	}
+	var rejections []string
+	var best *nodeScore
+	for i := range scored {
+		s := &scored[i]
+		if s.rejected != "" {
+			rejections = append(rejections, s.node.Name+": "+s.rejected)
+			continue
+		}
+		if best == nil || s.score > best.score || (s.score == best.score && s.node.Name < best.node.Name) {
+			best = s
+		}
+	}
+	if best == nil {
+		slices.Sort(rejections)
+		return nil, fmt.Errorf("no destination node fits the VM: %s", strings.Join(rejections, "; "))
+	}
+	return &Placement{
+		Node:       best.node.Name,
+		InternalIP: best.node.InternalIP,
+		Score:      best.score,
+		Reasons:    append([]string{describeVM(vm, req.SharedStorage)}, best.reasons...),
+	}, nil
+}
+
+func describeVM(vm VMProfile, shared bool) string {
+	parts := []string{"vm:"}
+	if vm.MemoryBytes > 0 {
+		parts = append(parts, gib(vm.MemoryBytes)+" RAM")
+	} else {
+		parts = append(parts, "RAM unknown")
+	}
+	parts = append(parts, "cpu "+cmp.Or(vm.CPUModel, "unknown"))
+	switch {
+	case shared:
+		parts = append(parts, "shared storage")
+	case vm.DiskBytes > 0:
+		parts = append(parts, gib(vm.DiskBytes)+" disk to mirror")
+	default:
+		parts = append(parts, "disk size unknown")
+	}
+	return strings.Join(parts, " ")
+}
+
+// scoreNode evaluates one candidate against the VM. src is the source
+// node, nil when it is not a kata node the discoverer lists.
+func scoreNode(n NodeInfo, src *NodeInfo, req Request, vm VMProfile, load nodeLoad) nodeScore {
+	s := nodeScore{node: n}
+	reject := func(format string, a ...any) nodeScore {
+		s.rejected = fmt.Sprintf(format, a...)
+		return s
+	}
+	switch {
+	case n.InternalIP == "":
+		return reject("no InternalIP")
+	case !n.Ready:
+		return reject("not Ready")
+	case n.Unschedulable:
+		return reject("cordoned")
+	}
+	for _, k := range sortedKeys(req.DestNodeSelector) {
+		if n.Labels[k] != req.DestNodeSelector[k] {
+			return reject("nodeSelector %s=%s not matched", k, req.DestNodeSelector[k])
+		}
+	}
+	for i := range n.Taints {
+		t := &n.Taints[i]
+		if t.Effect != corev1.TaintEffectNoSchedule && t.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		if !slices.ContainsFunc(req.DestTolerations, func(tol corev1.Toleration) bool { return tolerates(tol, t) }) {
+			return reject("untolerated taint %s=%s:%s", t.Key, t.Value, t.Effect)
+		}
+	}
+
+	// Memory.
+	switch free := n.AllocatableMemory - load.memoryRequested; {
+	case n.AllocatableMemory <= 0:
+		s.reasons = append(s.reasons, "memory: allocatable unknown (+0)")
+	case vm.MemoryBytes > 0 && free < vm.MemoryBytes:
+		return reject("memory: %s free < %s VM", gib(free), gib(vm.MemoryBytes))
+	default:
+		pts := int(memoryWeight * (free - vm.MemoryBytes) / n.AllocatableMemory)
+		s.score += pts
+		if vm.MemoryBytes > 0 {
+			s.reasons = append(s.reasons, fmt.Sprintf("memory: %s free, %s after the VM (+%d)", gib(free), gib(free-vm.MemoryBytes), pts))
+		} else {
+			s.reasons = append(s.reasons, fmt.Sprintf("memory: %s free, VM size unknown (+%d)", gib(free), pts))
+		}
+	}
+
+	// CPU.
+	if src != nil {
+		if a, b := n.Labels[archLabel], src.Labels[archLabel]; a != "" && b != "" && a != b {
+			return reject("cpu: arch %s differs from source %s", a, b)
+		}
+		passthrough := vm.CPUModel == "" || vm.CPUModel == "host" || vm.CPUModel == "max"
+		srcModel, dstModel := cpuModelOf(*src, passthrough), cpuModelOf(n, passthrough)
+		switch {
+		case srcModel == "" || dstModel == "":
+			s.reasons = append(s.reasons, "cpu: model unknown, no NFD cpu-model labels (+0)")
+		case srcModel == dstModel:
+			s.score += cpuMatchPoints
+			s.reasons = append(s.reasons, fmt.Sprintf("cpu: %s matches source (+%d)", dstModel, cpuMatchPoints))
+		case vm.CPUModel == "":
+			// Model unknown: assume the kata default (-cpu host) but
+			// only penalise, since the probe may simply have failed.
+			s.score -= cpuMatchPoints
+			s.reasons = append(s.reasons, fmt.Sprintf("cpu: %s differs from source %s (-%d)", dstModel, srcModel, cpuMatchPoints))
+		default:
+			return reject("cpu: %s differs from source %s (-cpu %s)", dstModel, srcModel, vm.CPUModel)
+		}
+	}
+
+	// Disk.
+	if req.SharedStorage {
+		s.reasons = append(s.reasons, "disk: shared storage, nothing mirrored (+0)")
+	} else {
+		switch free := n.AllocatableStorage - load.storageRequested; {
+		case n.AllocatableStorage <= 0:
+			s.reasons = append(s.reasons, "disk: allocatable ephemeral-storage unknown (+0)")
+		case vm.DiskBytes > 0 && free < vm.DiskBytes:
+			return reject("disk: %s free < %s to mirror", gib(free), gib(vm.DiskBytes))
+		default:
+			pts := int(diskWeight * (free - vm.DiskBytes) / n.AllocatableStorage)
+			s.score += pts
+			s.reasons = append(s.reasons, fmt.Sprintf("disk: %s free, %s to mirror (+%d)", gib(free), gib(vm.DiskBytes), pts))
+		}
+	}
+
+	// In-flight migrations.
+	if load.inflight > 0 {
+		cost := inflightCost * load.inflight
+		s.score -= cost
+		s.reasons = append(s.reasons, fmt.Sprintf("in-flight: %d migration(s) already targeting the node (-%d)", load.inflight, cost))
+	}
+
+	// Topology.
+	if src != nil {
+		zone, region := n.Labels[zoneLabel], n.Labels[regionLabel]
+		switch {
+		case zone != "" && zone == src.Labels[zoneLabel]:
+			s.score += sameZonePoints
+			s.reasons = append(s.reasons, fmt.Sprintf("topology: same zone %s as source (+%d)", zone, sameZonePoints))
+		case region != "" && region == src.Labels[regionLabel]:
+			s.score += sameRegion
+			s.reasons = append(s.reasons, fmt.Sprintf("topology: same region %s as source (+%d)", region, sameRegion))
+		case zone != "" || region != "":
+			s.reasons = append(s.reasons, "topology: different zone and region from source (+0)")
+		}
+	}
+	return s
+}
+
+// cpuModelOf renders a node's NFD CPU identity. Passthrough VMs need the
+// exact vendor/family/model; named models only the vendor.
+func cpuModelOf(n NodeInfo, passthrough bool) string {
+	vendor := n.Labels[cpuVendorLabel]
+	if vendor == "" {
+		return ""
+	}
+	if !passthrough {
+		return vendor
+	}
+	family, model := n.Labels[cpuFamilyLabel], n.Labels[cpuModelLabel]
+	if family == "" || model == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s family %s model %s", vendor, family, model)
+}
+
+// tolerates mirrors corev1.Toleration.ToleratesTaint without the klog
+// dependency and the comparison-operator feature gate.
+func tolerates(tol corev1.Toleration, t *corev1.Taint) bool {
+	if tol.Effect != "" && tol.Effect != t.Effect {
+		return false
+	}
+	if tol.Key != "" && tol.Key != t.Key {
+		return false
+	}
+	switch tol.Operator {
+	case corev1.TolerationOpExists:
+		return true
+	case "", corev1.TolerationOpEqual:
+		return tol.Key != "" && tol.Value == t.Value
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func gib(n int64) string {
+	return fmt.Sprintf("%.1fGi", float64(n)/(1<<30))
+}
+
+// placeDestNode runs the picker for an auto-select request. It returns
+// nil (use kube-scheduler) when the kata nodes cannot be listed or none
+// besides the source exist, and an error when candidates exist but none
+// fits. vm is the VM profile when a preflight already probed it; nil
+// runs the probe.
+func (n *native) placeDestNode(ctx context.Context, id MigrationID, req Request, vm *VMProfile) (*Placement, error) {
+	nodes, err := (&nativeDiscoverer{client: n.client}).ListKataNodes(ctx)
+	if err != nil {
+		slog.Warn("Destination picker unavailable; falling back to kube-scheduler", "migration_id", id, "error", err)
+		return nil, nil
+	}
+	if !slices.ContainsFunc(nodes, func(node NodeInfo) bool { return node.Name != req.SourceNode }) {
+		slog.Info("No kata candidate nodes besides the source; kube-scheduler places the dest Job", "migration_id", id)
+		return nil, nil
+	}
+	if vm == nil {
+		probed, err := n.probeVM(ctx, id, req)
+		if err != nil {
+			slog.Warn("VM probe failed; scoring nodes without the VM profile", "migration_id", id, "error", err)
+		}
+		vm = &probed
+	}
+	loads, err := n.nodeLoads(ctx)
+	if err != nil {
+		slog.Warn("Node load lookup failed; scoring on allocatable capacity only", "migration_id", id, "error", err)
+	}
+	p, err := pickDestNode(nodes, req, *vm, loads)
+	if err != nil || p == nil {
+		return p, err
+	}
+	slog.Info("Destination picked", "migration_id", id, "dest_node", p.Node, "dest_ip", p.InternalIP, "score", p.Score, "reasons", strings.Join(p.Reasons, "; "))
+	return p, nil
+}
+
+// probeVM runs the probe Job on the source node and parses its
+// KATAMARAN_VM_PROFILE marker. The Job is deleted before returning.
+func (n *native) probeVM(ctx context.Context, id MigrationID, req Request) (VMProfile, error) {
+	job, err := n.renderProbeJob(req, id)
+	if err != nil {
+		return VMProfile{}, fmt.Errorf("render probe job: %w", err)
+	}
+	if _, err := n.client.BatchV1().Jobs(n.namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return VMProfile{}, fmt.Errorf("create probe job: %w", err)
+	}
+	defer func() {
+		delCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		prop := metav1.DeletePropagationBackground
+		if err := n.client.BatchV1().Jobs(n.namespace).Delete(delCtx, job.Name, metav1.DeleteOptions{PropagationPolicy: &prop}); err != nil {
+			slog.Warn("failed to clean up probe job", "migration_id", id, "job", job.Name, "namespace", n.namespace, "error", err)
+		}
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, n.probeTimeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		j, err := n.client.BatchV1().Jobs(n.namespace).Get(waitCtx, job.Name, metav1.GetOptions{})
+		if err == nil {
+			if cond, ok := LatestTerminalJobCondition(j); ok {
+				if cond.Type == batchv1.JobFailed {
+					return VMProfile{}, jobFailedError("probe job failed", cond)
+				}
+				break
+			}
+		}
+		select {
+		case <-waitCtx.Done():
+			return VMProfile{}, fmt.Errorf("waiting for probe job %s: %w", job.Name, waitCtx.Err())
+		case <-ticker.C:
+		}
+	}
+	fields, ok := n.scrapeJobMarkers(waitCtx, job.Name, vmProfileMarker)[vmProfileMarker]
+	if !ok {
+		return VMProfile{}, errors.New("probe job log has no KATAMARAN_VM_PROFILE marker")
+	}
+	return parseVMProfileMarker(fields)
+}
+
+// parseVMProfileMarker decodes the KATAMARAN_VM_PROFILE fields.
+func parseVMProfileMarker(fields map[string]string) (VMProfile, error) {
+	raw, err := base64.StdEncoding.DecodeString(fields["cmdline_b64"])
+	if err != nil {
+		return VMProfile{}, fmt.Errorf("decode probe cmdline: %w", err)
+	}
+	vm := vmProfileFromCmdline(strings.FieldsFunc(string(raw), func(r rune) bool { return r == '\n' || r == 0 }))
+	vm.DiskBytes = parseInt64(fields["disk_bytes"])
+	if b64 := fields["blockers_b64"]; b64 != "" {
+		raw, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return VMProfile{}, fmt.Errorf("decode probe blockers: %w", err)
+		}
+		vm.MigrationBlockers = strings.Split(string(raw), "\n")
+	}
+	return vm, nil
+}
+
+// nodeLoads sums the memory and ephemeral-storage requests of every
+// non-terminal pod per node, and counts the unfinished dest Jobs pinned to
+// each node (in-flight migrations).
+func (n *native) nodeLoads(ctx context.Context) (map[string]nodeLoad, error) {
+	loads := map[string]nodeLoad{}
+	pods, err := n.client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+	for _, p := range pods.Items {
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		l := loads[p.Spec.NodeName]
+		for _, c := range p.Spec.Containers {
+			l.memoryRequested += c.Resources.Requests.Memory().Value()
+			l.storageRequested += c.Resources.Requests.StorageEphemeral().Value()
+		}
+		if q, ok := p.Spec.Overhead[corev1.ResourceMemory]; ok {
+			l.memoryRequested += q.Value()
+		}
+		loads[p.Spec.NodeName] = l
+	}
+	jobs, err := n.client.BatchV1().Jobs(n.namespace).List(ctx, metav1.ListOptions{LabelSelector: "app.kubernetes.io/component=dest"})
+	if err != nil {
+		return loads, fmt.Errorf("list dest jobs: %w", err)
+	}
+	for _, j := range jobs.Items {
+		node := j.Spec.Template.Spec.NodeName
+		if node == "" || TerminalJobCondition(&j) != "" {
+			continue
+		}
+		l := loads[node]
+		l.inflight++
+		loads[node] = l
+	}
+	return loads, nil
+}
@@ -0,0 +1,261 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseQEMUMemory(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"2048", 2 << 30, true},
+		{"2048M", 2 << 30, true},
+		{"2g", 2 << 30, true},
+		{"1.5G", 3 << 29, true},
+		{"size=4096M,slots=10,maxmem=16G", 4 << 30, true},
+		{"524288K", 512 << 20, true},
+		{"", 0, false},
+		{"lots", 0, false},
+		{"-1G", 0, false},
+	} {
+		got, ok := parseQEMUMemory(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseQEMUMemory(%q) = %d, %v; want %d, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestVMProfileFromCmdline(t *testing.T) {
+	t.Parallel()
+	got := vmProfileFromCmdline([]string{"qemu-system-x86_64", "-name", "vm", "-cpu", "host,pmu=off", "-m", "2048M,slots=10,maxmem=8G", "-smp", "1"})
+	if got.MemoryBytes != 2<<30 || got.CPUModel != "host" {
+		t.Fatalf("vmProfileFromCmdline = %+v, want 2GiB host", got)
+	}
+	if got := vmProfileFromCmdline([]string{"qemu", "-m"}); !reflect.DeepEqual(got, VMProfile{}) {
+		t.Fatalf("trailing -m without value = %+v, want zero", got)
+	}
+}
+
+func TestParseVMProfileMarker(t *testing.T) {
+	t.Parallel()
+	cmdline := base64.StdEncoding.EncodeToString([]byte("qemu\n-m\n4G\n-cpu\nSkylake-Server\n"))
+	got, err := parseVMProfileMarker(map[string]string{"cmdline_b64": cmdline, "disk_bytes": "1073741824"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, VMProfile{MemoryBytes: 4 << 30, CPUModel: "Skylake-Server", DiskBytes: 1 << 30}) {
+		t.Fatalf("parseVMProfileMarker = %+v", got)
+	}
+	blockers := base64.StdEncoding.EncodeToString([]byte("VFIO device doesn't support migration\nnon-migratable device: 0000:00:05.0/vfio"))
+	got, err = parseVMProfileMarker(map[string]string{"cmdline_b64": cmdline, "blockers": "2", "blockers_b64": blockers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.MigrationBlockers) != 2 || got.MigrationBlockers[0] != "VFIO device doesn't support migration" {
+		t.Fatalf("MigrationBlockers = %q", got.MigrationBlockers)
+	}
+	if _, err := parseVMProfileMarker(map[string]string{"cmdline_b64": "!!"}); err == nil {
+		t.Fatal("invalid base64 accepted")
+	}
+}
+
+// placementNode is a Ready kata node with room for a few VMs.
+func placementNode(name, ip string, labels map[string]string) NodeInfo {
+	return NodeInfo{
+		Name:               name,
+		InternalIP:         ip,
+		Labels:             labels,
+		Ready:              true,
+		AllocatableMemory:  16 << 30,
+		AllocatableStorage: 100 << 30,
+	}
+}
+
+func cpuLabels(vendor, family, model string, extra ...string) map[string]string {
+	l := map[string]string{cpuVendorLabel: vendor, cpuFamilyLabel: family, cpuModelLabel: model}
+	for i := 0; i+1 < len(extra); i += 2 {
+		l[extra[i]] = extra[i+1]
+	}
+	return l
+}
+
+func TestPickDestNode_Rejects(t *testing.T) {
+	t.Parallel()
+	src := placementNode("src", "10.0.0.1", cpuLabels("Intel", "6", "85", archLabel, "amd64"))
+	vm := VMProfile{MemoryBytes: 4 << 30, CPUModel: "host", DiskBytes: 20 << 30}
+	req := Request{SourceNode: "src", DestNodeSelector: map[string]string{"pool": "vm"}}
+
+	mk := func(name string, mutate func(*NodeInfo)) NodeInfo {
+		n := placementNode(name, "10.0.0.9", cpuLabels("Intel", "6", "85", "pool", "vm", archLabel, "amd64"))
+		mutate(&n)
+		return n
+	}
+	for _, tc := range []struct {
+		node NodeInfo
+		want string
+	}{
+		{mk("no-ip", func(n *NodeInfo) { n.InternalIP = "" }), "no InternalIP"},
+		{mk("not-ready", func(n *NodeInfo) { n.Ready = false }), "not Ready"},
+		{mk("cordoned", func(n *NodeInfo) { n.Unschedulable = true }), "cordoned"},
+		{mk("other-pool", func(n *NodeInfo) { n.Labels["pool"] = "batch" }), "nodeSelector pool=vm"},
+		{mk("tainted", func(n *NodeInfo) {
+			n.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+		}), "untolerated taint dedicated=gpu:NoSchedule"},
+		{mk("arm", func(n *NodeInfo) { n.Labels[archLabel] = "arm64" }), "cpu: arch arm64"},
+		{mk("small", func(n *NodeInfo) { n.AllocatableMemory = 2 << 30 }), "memory: 2.0Gi free < 4.0Gi VM"},
+		{mk("full-disk", func(n *NodeInfo) { n.AllocatableStorage = 10 << 30 }), "disk: 10.0Gi free"},
+		{mk("amd", func(n *NodeInfo) { n.Labels[cpuVendorLabel] = "AMD" }), "cpu: AMD family 6 model 85 differs"},
+	} {
+		_, err := pickDestNode([]NodeInfo{src, tc.node}, req, vm, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.node.Name+": "+tc.want) {
+			t.Errorf("%s: err = %v, want rejection %q", tc.node.Name, err, tc.want)
+		}
+	}
+
+	// A matching toleration and shared storage lift the taint and disk rejections.
+	tainted := mk("tainted", func(n *NodeInfo) {
+		n.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+		n.AllocatableStorage = 1 << 30
+	})
+	req.DestTolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu"}}
+	req.SharedStorage = true
+	if p, err := pickDestNode([]NodeInfo{src, tainted}, req, vm, nil); err != nil || p.Node != "tainted" {
+		t.Fatalf("tolerated node: placement = %+v, err = %v", p, err)
+	}
+}
+
+func TestPickDestNode_Scoring(t *testing.T) {
+	t.Parallel()
+	src := placementNode("src", "10.0.0.1", cpuLabels("Intel", "6", "85", zoneLabel, "z1", regionLabel, "r1"))
+	same := placementNode("same-zone", "10.0.0.2", cpuLabels("Intel", "6", "85", zoneLabel, "z1", regionLabel, "r1"))
+	far := placementNode("far", "10.0.0.3", cpuLabels("Intel", "6", "85", zoneLabel, "z9", regionLabel, "r9"))
+	busy := placementNode("busy", "10.0.0.4", cpuLabels("Intel", "6", "85", zoneLabel, "z1", regionLabel, "r1"))
+	vm := VMProfile{MemoryBytes: 4 << 30, CPUModel: "host"}
+	req := Request{SourceNode: "src", SharedStorage: true}
+	loads := map[string]nodeLoad{"busy": {memoryRequested: 8 << 30, inflight: 1}}
+
+	p, err := pickDestNode([]NodeInfo{far, busy, src, same}, req, vm, loads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Node != "same-zone" || p.InternalIP != "10.0.0.2" {
+		t.Fatalf("picked %s (%s), want same-zone", p.Node, p.InternalIP)
+	}
+	// memory 40*(16-4)/16 = 30, cpu +20, zone +10.
+	if p.Score != 60 {
+		t.Fatalf("score = %d, want 60; reasons: %v", p.Score, p.Reasons)
+	}
+	if len(p.Reasons) == 0 || !strings.HasPrefix(p.Reasons[0], "vm: 4.0Gi RAM cpu host") {
+		t.Fatalf("reasons = %v, want a leading vm line", p.Reasons)
+	}
+
+	// In-flight migrations and used memory push busy below far even though
+	// it shares the source zone.
+	p, err = pickDestNode([]NodeInfo{far, busy, src}, req, vm, loads)
+	if err != nil || p.Node != "far" {
+		t.Fatalf("placement = %+v, err = %v; want far", p, err)
+	}
+
+	// Ties go to the smaller name.
+	twin := placementNode("a-twin", "10.0.0.5", same.Labels)
+	if p, _ := pickDestNode([]NodeInfo{same, twin, src}, req, vm, nil); p.Node != "a-twin" {
+		t.Fatalf("tie picked %s, want a-twin", p.Node)
+	}
+}
+
+func TestPickDestNode_UnknownProfile(t *testing.T) {
+	t.Parallel()
+	src := placementNode("src", "10.0.0.1", cpuLabels("Intel", "6", "85"))
+	other := placementNode("other", "10.0.0.2", cpuLabels("Intel", "6", "106"))
+	match := placementNode("match", "10.0.0.3", cpuLabels("Intel", "6", "85"))
+	req := Request{SourceNode: "src"}
+
+	// Without a VM profile a CPU mismatch is a penalty, not a rejection.
+	p, err := pickDestNode([]NodeInfo{src, other, match}, req, VMProfile{}, nil)
+	if err != nil || p.Node != "match" {
+		t.Fatalf("placement = %+v, err = %v; want match", p, err)
+	}
+	if p, err := pickDestNode([]NodeInfo{src, other}, req, VMProfile{}, nil); err != nil || p.Node != "other" {
+		t.Fatalf("placement = %+v, err = %v; want other", p, err)
+	}
+	if p, err := pickDestNode([]NodeInfo{src}, req, VMProfile{}, nil); p != nil || err != nil {
+		t.Fatalf("source-only: placement = %+v, err = %v; want nil, nil", p, err)
+	}
+}
+
+func TestNative_Apply_AutoSelectUsesPicker(t *testing.T) {
+	t.Parallel()
+	kataNode := func(name, ip string, cordoned bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"katacontainers.io/kata-runtime": "true"}},
+			Spec:       corev1.NodeSpec{Unschedulable: cordoned},
+			Status: corev1.NodeStatus{
+				Addresses:   []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}},
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+				Allocatable: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Gi")},
+			},
+		}
+	}
+	cs := fake.NewSimpleClientset(
+		kataNode("worker-a", "10.0.0.10", false),
+		kataNode("worker-b", "10.0.0.20", true),
+		kataNode("worker-c", "10.0.0.30", false),
+	)
+	n := newFromClient(cs)
+	n.probeTimeout = 50 * time.Millisecond // fake Jobs never complete
+	req := validRequest()
+	req.SourceNode = "worker-a"
+	req.DestNode = ""
+	req.DestIP = ""
+
+	id, err := n.Apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	t.Cleanup(func() { _ = n.Stop(context.Background(), id) })
+
+	dest, err := cs.BatchV1().Jobs(DefaultJobNamespace).Get(context.Background(), DestJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("dest job not created: %v", err)
+	}
+	if dest.Spec.Template.Spec.NodeName != "worker-c" {
+		t.Fatalf("dest job NodeName = %q, want worker-c", dest.Spec.Template.Spec.NodeName)
+	}
+	src, err := cs.BatchV1().Jobs(DefaultJobNamespace).Get(context.Background(), SourceJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("source job not created: %v", err)
+	}
+	if cmd := jobCommand(t, *src); !strings.Contains(cmd, "--dest-ip \"10.0.0.30\"") {
+		t.Fatalf("source command missing picked dest IP: %s", cmd)
+	}
+	jobs, err := cs.BatchV1().Jobs(DefaultJobNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: "app.kubernetes.io/component=probe"})
+	if err != nil || len(jobs.Items) != 0 {
+		t.Fatalf("probe job left behind: %d jobs, err = %v", len(jobs.Items), err)
+	}
+
+	updates, err := n.Watch(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-updates:
+		if u.Phase != PhaseSubmitted || u.Placement == nil || u.Placement.Node != "worker-c" {
+			t.Fatalf("first update = %+v, want submitted with worker-c placement", u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no submitted update")
+	}
+}
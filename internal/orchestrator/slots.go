@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Destination listener slots. The dest Job runs under hostNetwork, so two
+// migrations landing on the same node cannot both listen on 4444/10809.
+// Each in-flight migration holds a slot per destination node; slot N
+// listens on baseMigrationPort+N and baseNBDPort+N and, in ReplayCmdline
+// mode, spawns QEMU in its own sandbox (destSandboxID).
+const (
+	baseMigrationPort = 4444
+	baseNBDPort       = 10809
+	maxDestSlots      = 32
+)
+
+// Job annotations recording what a migration holds. reserveDestSlot reads
+// them back from the live Jobs, so the allocation survives an orchestrator
+// restart and is shared by every orchestrator in the cluster.
+const (
+	destSlotAnnotation   = "katamaran.io/dest-slot"
+	destNodeAnnotation   = "katamaran.io/dest-node"
+	sourceAnnotation     = "katamaran.io/source"
+	destTargetAnnotation = "katamaran.io/dest-target"
+)
+
+// destSlot is a reserved destination listener slot.
+type destSlot struct {
+	Index         int
+	MigrationPort int
+	NBDPort       int
+	SandboxID     string // empty unless the dest spawns QEMU (ReplayCmdline)
+}
+
+func newDestSlot(index int, id MigrationID, req Request) destSlot {
+	s := destSlot{Index: index, MigrationPort: baseMigrationPort + index, NBDPort: baseNBDPort + index}
+	if req.ReplayCmdline {
+		s.SandboxID = destSandboxID(id)
+	}
+	return s
+}
+
+// destSandboxID is the sandbox a replayed destination QEMU is spawned in.
+// Per migration, so concurrent replays on one node get separate sandbox
+// dirs, sockets and taps.
+func destSandboxID(id MigrationID) string { return "katamaran-dest-" + string(id) }
+
+// extraArgs returns buildExtraArgs(req) plus the slot's listener ports,
+// passed to both source and dest binaries.
+func (s destSlot) extraArgs(req Request) string {
+	return strings.TrimSpace(fmt.Sprintf("%s --migration-port %d --nbd-port %d", buildExtraArgs(req), s.MigrationPort, s.NBDPort))
+}
+
+// sourceKey identifies the VM a request migrates: the source pod, or the
+// QMP socket on the source node in legacy explicit mode.
+func sourceKey(req Request) string {
+	if req.SourcePod != nil {
+		return "pod:" + req.SourcePod.Namespace + "/" + req.SourcePod.Name
+	}
+	return "qmp:" + req.SourceNode + ":" + req.SourceQMP
+}
+
+// destTargetKey identifies an existing destination QEMU the request
+// migrates into. Empty in ReplayCmdline mode without DestPod/DestQMP,
+// where the dest spawns a fresh QEMU in its own sandbox.
+func destTargetKey(req Request) string {
+	switch {
+	case req.DestPod != nil:
+		return "pod:" + req.DestPod.Namespace + "/" + req.DestPod.Name
+	case req.DestQMP != "" || !req.ReplayCmdline:
+		return "qmp:" + req.DestNode + ":" + cmp.Or(req.DestQMP, "/run/vc/vm/katamaran-dest/qmp.sock")
+	}
+	return ""
+}
+
+// annotate records slot and the request's source/dest identities on job.
+func (s destSlot) annotate(job *batchv1.Job, req Request) {
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[destSlotAnnotation] = strconv.Itoa(s.Index)
+	job.Annotations[destNodeAnnotation] = req.DestNode
+	job.Annotations[sourceAnnotation] = sourceKey(req)
+	if t := destTargetKey(req); t != "" {
+		job.Annotations[destTargetAnnotation] = t
+	}
+}
+
+// slotFromJob reads back the slot recorded on a migration Job.
+func slotFromJob(job *batchv1.Job, id MigrationID, req Request) (destSlot, bool) {
+	v, ok := job.Annotations[destSlotAnnotation]
+	if !ok {
+		return destSlot{}, false
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 || i >= maxDestSlots {
+		return destSlot{}, false
+	}
+	return newDestSlot(i, id, req), true
+}
+
+// slotHolder is an in-flight migration's claim as seen by reserveDestSlot.
+type slotHolder struct {
+	slot       int
+	node       string
+	source     string
+	destTarget string
+}
+
+// reserveDestSlot picks a free listener slot on req.DestNode and rejects
+// requests that conflict with a migration already in flight: the same
+// source VM, or the same existing destination QEMU. Claims are read from
+// the annotations of non-terminal katamaran Jobs plus this process's
+// pending reservations (Apply calls racing before their Jobs exist). An
+// empty DestNode (kube-scheduler places the dest) conflicts with slots on
+// every node.
+//
+// The returned release drops the pending reservation; call it once the
+// Jobs carrying the annotations have been created (or creation failed).
+// Errors wrap ErrConflict when the request should be retried later.
+func (n *native) reserveDestSlot(ctx context.Context, id MigrationID, req Request) (destSlot, func(), error) {
+	n.slotMu.Lock()
+	defer n.slotMu.Unlock()
+
+	jobs, err := n.client.BatchV1().Jobs(n.namespace).List(ctx, metav1.ListOptions{LabelSelector: "app.kubernetes.io/name=katamaran"})
+	if err != nil {
+		return destSlot{}, nil, fmt.Errorf("list migration jobs: %w", err)
+	}
+	holders := map[string]slotHolder{}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		mid := job.Labels[MigrationIDLabel]
+		v, ok := job.Annotations[destSlotAnnotation]
+		if mid == "" || !ok || TerminalJobCondition(job) != "" {
+			continue
+		}
+		slot, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		h := holders[mid]
+		h.slot = slot
+		// The auto-select source Job is rendered after kube-scheduler
+		// placed the dest, so either Job may carry the known node.
+		h.node = cmp.Or(h.node, job.Annotations[destNodeAnnotation])
+		h.source = cmp.Or(h.source, job.Annotations[sourceAnnotation])
+		h.destTarget = cmp.Or(h.destTarget, job.Annotations[destTargetAnnotation])
+		holders[mid] = h
+	}
+	for mid, h := range n.reserved {
+		holders[string(mid)] = h
+	}
+
+	src, target := sourceKey(req), destTargetKey(req)
+	used := make([]bool, maxDestSlots)
+	for mid, h := range holders {
+		if h.source == src {
+			return destSlot{}, nil, fmt.Errorf("%w: %s is already being migrated by %s", ErrConflict, src, mid)
+		}
+		if target != "" && h.destTarget == target {
+			return destSlot{}, nil, fmt.Errorf("%w: destination %s is already in use by %s", ErrConflict, target, mid)
+		}
+		if req.DestNode == "" || h.node == "" || h.node == req.DestNode {
+			if h.slot >= 0 && h.slot < maxDestSlots {
+				used[h.slot] = true
+			}
+		}
+	}
+	index := -1
+	for i, u := range used {
+		if !u {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return destSlot{}, nil, fmt.Errorf("%w: all %d destination slots on node %q are in use", ErrConflict, maxDestSlots, req.DestNode)
+	}
+
+	n.reserved[id] = slotHolder{slot: index, node: req.DestNode, source: src, destTarget: target}
+	release := func() {
+		n.slotMu.Lock()
+		delete(n.reserved, id)
+		n.slotMu.Unlock()
+	}
+	return newDestSlot(index, id, req), release, nil
+}
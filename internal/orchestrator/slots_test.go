@@ -0,0 +1,210 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// slotRequest is validRequest migrating vm into its own destination pod
+// on node.
+func slotRequest(vm, node string) Request {
+	req := validRequest()
+	req.SourcePod = &PodRef{Namespace: "default", Name: vm}
+	req.DestPod = &PodRef{Namespace: "default", Name: vm + "-dest"}
+	req.DestNode = node
+	return req
+}
+
+func migrationJobs(t *testing.T, cs *fake.Clientset, id MigrationID) (src, dest batchv1.Job) {
+	t.Helper()
+	for _, name := range []string{SourceJobName(id), DestJobName(id)} {
+		job, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get job %s: %v", name, err)
+		}
+		if strings.HasPrefix(name, "katamaran-source-") {
+			src = *job
+		} else {
+			dest = *job
+		}
+	}
+	return src, dest
+}
+
+func TestNative_Apply_ConcurrentMigrationsToOneNodeGetDistinctPorts(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+
+	idA, err := n.Apply(context.Background(), slotRequest("vm-a", "n2"))
+	if err != nil {
+		t.Fatalf("Apply vm-a: %v", err)
+	}
+	idB, err := n.Apply(context.Background(), slotRequest("vm-b", "n2"))
+	if err != nil {
+		t.Fatalf("Apply vm-b: %v", err)
+	}
+	// A different node has its own slot space.
+	idC, err := n.Apply(context.Background(), slotRequest("vm-c", "n3"))
+	if err != nil {
+		t.Fatalf("Apply vm-c: %v", err)
+	}
+
+	for _, tc := range []struct {
+		id        MigrationID
+		slot      string
+		ram, nbd  string
+		wantLabel string
+	}{
+		{idA, "0", "--migration-port 4444", "--nbd-port 10809", "vm-a"},
+		{idB, "1", "--migration-port 4445", "--nbd-port 10810", "vm-b"},
+		{idC, "0", "--migration-port 4444", "--nbd-port 10809", "vm-c"},
+	} {
+		src, dest := migrationJobs(t, cs, tc.id)
+		for _, job := range []batchv1.Job{src, dest} {
+			if got := job.Annotations[destSlotAnnotation]; got != tc.slot {
+				t.Fatalf("%s: %s = %q, want %q", job.Name, destSlotAnnotation, got, tc.slot)
+			}
+			cmd := jobCommand(t, job)
+			if !strings.Contains(cmd, tc.ram) || !strings.Contains(cmd, tc.nbd) {
+				t.Fatalf("%s command missing %q %q: %s", job.Name, tc.ram, tc.nbd, cmd)
+			}
+		}
+		if got := src.Annotations[sourceAnnotation]; got != "pod:default/"+tc.wantLabel {
+			t.Fatalf("%s: %s = %q", src.Name, sourceAnnotation, got)
+		}
+	}
+}
+
+func TestNative_Apply_RejectsConflicts(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+	if _, err := n.Apply(context.Background(), slotRequest("vm-a", "n2")); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	sameVM := slotRequest("vm-a", "n3")
+	sameVM.DestPod = &PodRef{Namespace: "default", Name: "other"}
+	sameDest := slotRequest("vm-b", "n2")
+	sameDest.DestPod = &PodRef{Namespace: "default", Name: "vm-a-dest"}
+	for name, req := range map[string]Request{"same source VM": sameVM, "same dest pod": sameDest} {
+		_, err := n.Apply(context.Background(), req)
+		if !errors.Is(err, ErrConflict) {
+			t.Fatalf("%s: Apply error = %v, want ErrConflict", name, err)
+		}
+	}
+	jobs, _ := cs.BatchV1().Jobs("kube-system").List(context.Background(), metav1.ListOptions{})
+	if len(jobs.Items) != 2 {
+		t.Fatalf("conflicting Apply must not create jobs; got %d jobs", len(jobs.Items))
+	}
+}
+
+func TestNative_Apply_FinishedMigrationFreesSlot(t *testing.T) {
+	t.Parallel()
+	cs := fake.NewSimpleClientset()
+	n := NewFromClient(cs)
+	idA, err := n.Apply(context.Background(), slotRequest("vm-a", "n2"))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	src, dest := migrationJobs(t, cs, idA)
+	for _, job := range []batchv1.Job{src, dest} {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		if _, err := cs.BatchV1().Jobs("kube-system").UpdateStatus(context.Background(), &job, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("update job status: %v", err)
+		}
+	}
+
+	// Same VM again is fine once the first migration is finished, and
+	// it reuses slot 0.
+	idB, err := n.Apply(context.Background(), slotRequest("vm-a", "n2"))
+	if err != nil {
+		t.Fatalf("Apply after completion: %v", err)
+	}
+	src, _ = migrationJobs(t, cs, idB)
+	if got := src.Annotations[destSlotAnnotation]; got != "0" {
+		t.Fatalf("slot = %q, want 0", got)
+	}
+}
+
+func TestReserveDestSlot_NodeFull(t *testing.T) {
+	t.Parallel()
+	n := newFromClient(fake.NewSimpleClientset())
+	for i := range maxDestSlots {
+		id := MigrationID("m" + string(rune('a'+i)))
+		if _, _, err := n.reserveDestSlot(context.Background(), id, slotRequest("vm-"+string(id), "n2")); err != nil {
+			t.Fatalf("reserve %d: %v", i, err)
+		}
+	}
+	_, _, err := n.reserveDestSlot(context.Background(), "full", slotRequest("vm-full", "n2"))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("reserve on full node: err = %v, want ErrConflict", err)
+	}
+	// Scheduler-placed requests could land on n2, so they conflict too.
+	_, _, err = n.reserveDestSlot(context.Background(), "any", slotRequest("vm-any", ""))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("reserve with unknown node: err = %v, want ErrConflict", err)
+	}
+	if _, release, err := n.reserveDestSlot(context.Background(), "other", slotRequest("vm-other", "n3")); err != nil {
+		t.Fatalf("reserve on another node: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestNewDestSlot_ReplaySandbox(t *testing.T) {
+	t.Parallel()
+	req := validRequest()
+	if s := newDestSlot(2, "abc", req); s.SandboxID != "" || s.MigrationPort != 4446 || s.NBDPort != 10811 {
+		t.Fatalf("non-replay slot = %+v", s)
+	}
+	req.ReplayCmdline = true
+	if s := newDestSlot(0, "abc", req); s.SandboxID != "katamaran-dest-abc" {
+		t.Fatalf("replay sandbox = %q", s.SandboxID)
+	}
+	if got := destTargetKey(req); got != "" {
+		t.Fatalf("replay without dest pod must not claim a dest target, got %q", got)
+	}
+}
+
+func TestNative_Resume_ReusesSourceSlot(t *testing.T) {
+	t.Parallel()
+	id := MigrationID("slot-3")
+	srcName := SourceJobName(id)
+	cs := fake.NewSimpleClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: srcName, Namespace: "kube-system", Annotations: map[string]string{destSlotAnnotation: "3"}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      srcName + "-abc12",
+				Namespace: "kube-system",
+				Labels:    map[string]string{"batch.kubernetes.io/job-name": srcName},
+			},
+		},
+	)
+	n := NewFromClient(cs).(*native)
+	req := validRequest()
+	req.ReplayCmdline = true
+	if _, err := n.Resume(context.Background(), id, req); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	dest, err := cs.BatchV1().Jobs("kube-system").Get(context.Background(), DestJobName(id), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("dest job not created: %v", err)
+	}
+	cmd := jobCommand(t, *dest)
+	for _, want := range []string{"--migration-port 4447", "--nbd-port 10812", "--sandbox-id katamaran-dest-slot-3"} {
+		if !strings.Contains(cmd, want) {
+			t.Fatalf("dest cmd missing %q: %s", want, cmd)
+		}
+	}
+}
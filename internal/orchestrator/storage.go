@@ -0,0 +1,390 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Storage handoff.
+//
+// --shared-storage assumes the VM's disks are already visible on the
+// destination node; local mirroring assumes the destination QEMU has a
+// same-sized target. A ReadWriteOnce CSI block volume is neither: it is
+// attached to the source node only. With Request.StorageHandoff, Apply
+// inspects the source pod's PVCs before submitting any Job and, for each
+// volumeMode: Block claim:
+//
+//   - network volumes (a CSI PV with no node affinity, e.g. RBD or iSCSI)
+//     are attached to the destination node with a VolumeAttachment, the
+//     migration runs in shared-storage mode, and the source node's
+//     attachment is deleted once the destination has the VM;
+//   - local volumes (local PVs or PVs pinned to the source node) get a
+//     matching PVC provisioned on the destination node, bound before the
+//     drives are mirrored into it.
+//
+// A failed migration rolls back: the destination attachment or the
+// provisioned PVC is deleted. The source PVC is never touched.
+
+// Handoff strategies.
+const (
+	HandoffAttach    = "attach"
+	HandoffProvision = "provision"
+)
+
+// Handoff states reported in VolumeHandoff.State.
+const (
+	HandoffAttached    = "attached"    // destination attachment ready
+	HandoffProvisioned = "provisioned" // destination PVC bound
+	HandoffComplete    = "complete"    // migration succeeded; source detached (attach)
+	HandoffRolledBack  = "rolled-back" // migration failed; destination side removed
+	HandoffCleanupErr  = "cleanup-failed"
+)
+
+// selectedNodeAnnotation asks a WaitForFirstConsumer provisioner to create
+// the volume on a given node; kube-scheduler sets it for pods.
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+// volumeHandoffTimeout bounds each attach or provision wait.
+const volumeHandoffTimeout = 2 * time.Minute
+
+// handoffPollInterval is how often attach/bind progress is checked.
+// Package-level var so tests can shorten it.
+var handoffPollInterval = 2 * time.Second
+
+// VolumeHandoff is the handoff of one source PVC to the destination node.
+type VolumeHandoff struct {
+	PVC      string // source claim, in the source pod's namespace
+	PV       string
+	Driver   string // CSI driver, or "local" for local PVs
+	Strategy string // HandoffAttach or HandoffProvision
+	// DestPVC is the claim provisioned on the destination node
+	// (HandoffProvision only).
+	DestPVC string
+	// Attachment is the destination node's VolumeAttachment
+	// (HandoffAttach only; empty when the driver needs no attach).
+	Attachment string
+	State      string
+}
+
+// storageHandoff is the prepared handoff of one migration.
+type storageHandoff struct {
+	namespace  string // source pod namespace
+	sourceNode string
+	destNode   string
+	volumes    []VolumeHandoff
+}
+
+// prepareStorageHandoff plans and performs the destination side of the
+// handoff for req's source pod: attaches network volumes to DestNode and
+// provisions local ones there. On error everything already done is rolled
+// back. It also checks that req's storage mode fits the plan: network
+// volumes need SharedStorage, local ones need mirroring.
+func (n *native) prepareStorageHandoff(ctx context.Context, id MigrationID, req Request) (*storageHandoff, error) {
+	if req.SourcePod == nil {
+		return nil, errors.New("storage handoff requires sourcePod")
+	}
+	if req.DestNode == "" {
+		return nil, errors.New("storage handoff requires a destination node (set destNode, or leave it to the destination picker)")
+	}
+	pod, err := n.client.CoreV1().Pods(req.SourcePod.Namespace).Get(ctx, req.SourcePod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get source pod: %w", err)
+	}
+	h := &storageHandoff{namespace: pod.Namespace, sourceNode: req.SourceNode, destNode: req.DestNode}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		v, ok, err := n.planVolume(ctx, pod.Namespace, vol.PersistentVolumeClaim.ClaimName, req.SourceNode)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h.volumes = append(h.volumes, v)
+		}
+	}
+	if len(h.volumes) == 0 {
+		slog.Info("Storage handoff: no block-mode PVCs to hand off", "migration_id", id, "pod", req.SourcePod.Namespace+"/"+req.SourcePod.Name)
+		return h, nil
+	}
+	attach := slices.ContainsFunc(h.volumes, func(v VolumeHandoff) bool { return v.Strategy == HandoffAttach })
+	provision := slices.ContainsFunc(h.volumes, func(v VolumeHandoff) bool { return v.Strategy == HandoffProvision })
+	switch {
+	case attach && provision:
+		return nil, errors.New("storage handoff: pod mixes network and local block volumes; migrate them separately")
+	case attach && !req.SharedStorage:
+		return nil, errors.New("storage handoff: network block volumes are attached to both nodes and must not be mirrored; set sharedStorage")
+	case provision && req.SharedStorage:
+		return nil, errors.New("storage handoff: local block volumes must be mirrored; unset sharedStorage")
+	}
+
+	for i := range h.volumes {
+		v := &h.volumes[i]
+		var err error
+		if v.Strategy == HandoffAttach {
+			err = n.attachToDest(ctx, id, h, v)
+		} else {
+			err = n.provisionOnDest(ctx, id, h, v, pod)
+		}
+		if err != nil {
+			n.rollbackStorageHandoff(ctx, id, h)
+			return nil, fmt.Errorf("storage handoff of %s: %w", v.PVC, err)
+		}
+		slog.Info("Storage handoff: destination volume ready", "migration_id", id, "pvc", v.PVC, "pv", v.PV, "strategy", v.Strategy, "dest_node", h.destNode, "state", v.State)
+	}
+	return h, nil
+}
+
+// planVolume classifies one claim. ok is false for claims that need no
+// handoff: filesystem-mode volumes and ReadWriteMany volumes.
+func (n *native) planVolume(ctx context.Context, ns, claim, sourceNode string) (VolumeHandoff, bool, error) {
+	v := VolumeHandoff{PVC: claim}
+	pvc, err := n.client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, claim, metav1.GetOptions{})
+	if err != nil {
+		return v, false, fmt.Errorf("get pvc %s/%s: %w", ns, claim, err)
+	}
+	if pvc.Spec.VolumeName == "" {
+		return v, false, fmt.Errorf("pvc %s/%s is not bound", ns, claim)
+	}
+	pv, err := n.client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return v, false, fmt.Errorf("get pv %s: %w", pvc.Spec.VolumeName, err)
+	}
+	v.PV = pv.Name
+	if pv.Spec.VolumeMode == nil || *pv.Spec.VolumeMode != corev1.PersistentVolumeBlock {
+		slog.Debug("Storage handoff: skipping filesystem-mode volume", "pvc", claim, "pv", pv.Name)
+		return v, false, nil
+	}
+	if slices.Contains(pv.Spec.AccessModes, corev1.ReadWriteMany) {
+		slog.Debug("Storage handoff: skipping ReadWriteMany volume", "pvc", claim, "pv", pv.Name)
+		return v, false, nil
+	}
+	switch {
+	case pv.Spec.Local != nil || pinnedToNode(pv, sourceNode):
+		v.Strategy = HandoffProvision
+		v.Driver = "local"
+		if pv.Spec.CSI != nil {
+			v.Driver = pv.Spec.CSI.Driver
+		}
+	case pv.Spec.CSI != nil:
+		v.Strategy = HandoffAttach
+		v.Driver = pv.Spec.CSI.Driver
+	default:
+		return v, false, fmt.Errorf("pv %s: only CSI and local block volumes can be handed off", pv.Name)
+	}
+	return v, true, nil
+}
+
+// pinnedToNode reports whether pv's node affinity only admits node, as
+// with topology-constrained local CSI drivers (TopoLVM, local-path, ...).
+func pinnedToNode(pv *corev1.PersistentVolume, node string) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return false
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == corev1.LabelHostname && expr.Operator == corev1.NodeSelectorOpIn &&
+				len(expr.Values) == 1 && expr.Values[0] == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attachmentName mirrors the attach/detach controller's VolumeAttachment
+// naming, so the destination pod's attachment is this one rather than a
+// second object for the same volume and node.
+func attachmentName(pv, driver, node string) string {
+	return fmt.Sprintf("csi-%x", sha256.Sum256([]byte(pv+driver+node)))
+}
+
+// attachToDest creates the destination node's VolumeAttachment for v and
+// waits for the CSI attacher to report it attached. Drivers whose
+// CSIDriver object says attachRequired: false need no attachment.
+func (n *native) attachToDest(ctx context.Context, id MigrationID, h *storageHandoff, v *VolumeHandoff) error {
+	if d, err := n.client.StorageV1().CSIDrivers().Get(ctx, v.Driver, metav1.GetOptions{}); err == nil &&
+		d.Spec.AttachRequired != nil && !*d.Spec.AttachRequired {
+		v.State = HandoffAttached
+		return nil
+	}
+	pv := v.PV
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   attachmentName(v.PV, v.Driver, h.destNode),
+			Labels: map[string]string{MigrationIDLabel: string(id)},
+		},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: v.Driver,
+			NodeName: h.destNode,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pv},
+		},
+	}
+	if _, err := n.client.StorageV1().VolumeAttachments().Create(ctx, va, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create volume attachment: %w", err)
+	}
+	v.Attachment = va.Name
+	return waitHandoff(ctx, "volume attachment "+va.Name, func() (bool, error) {
+		got, err := n.client.StorageV1().VolumeAttachments().Get(ctx, va.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if e := got.Status.AttachError; e != nil {
+			return false, fmt.Errorf("attach failed: %s", e.Message)
+		}
+		if got.Status.Attached {
+			v.State = HandoffAttached
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// provisionOnDest creates a claim matching v's source claim on the
+// destination node and waits for it to bind.
+func (n *native) provisionOnDest(ctx context.Context, id MigrationID, h *storageHandoff, v *VolumeHandoff, pod *corev1.Pod) error {
+	src, err := n.client.CoreV1().PersistentVolumeClaims(h.namespace).Get(ctx, v.PVC, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get pvc: %w", err)
+	}
+	pv, err := n.client.CoreV1().PersistentVolumes().Get(ctx, v.PV, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get pv: %w", err)
+	}
+	size, ok := pv.Spec.Capacity[corev1.ResourceStorage]
+	if !ok {
+		size = src.Spec.Resources.Requests[corev1.ResourceStorage]
+	}
+	block := corev1.PersistentVolumeBlock
+	dest := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        v.PVC + "-" + string(id),
+			Namespace:   h.namespace,
+			Labels:      map[string]string{MigrationIDLabel: string(id)},
+			Annotations: map[string]string{selectedNodeAnnotation: h.destNode},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      src.Spec.AccessModes,
+			StorageClassName: src.Spec.StorageClassName,
+			VolumeMode:       &block,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if _, err := n.client.CoreV1().PersistentVolumeClaims(h.namespace).Create(ctx, dest, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create destination pvc: %w", err)
+	}
+	v.DestPVC = dest.Name
+	slog.Info("Storage handoff: provisioning destination volume", "migration_id", id, "pvc", dest.Name, "namespace", h.namespace, "size", size.String(), "dest_node", h.destNode, "pod", pod.Name)
+	return waitHandoff(ctx, "pvc "+dest.Name, func() (bool, error) {
+		got, err := n.client.CoreV1().PersistentVolumeClaims(h.namespace).Get(ctx, dest.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if got.Status.Phase == corev1.ClaimBound {
+			v.State = HandoffProvisioned
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// waitHandoff polls check until it reports done, fails, or
+// volumeHandoffTimeout elapses. Transient Get errors are retried.
+func waitHandoff(ctx context.Context, what string, check func() (bool, error)) error {
+	deadline, cancel := context.WithTimeout(ctx, volumeHandoffTimeout)
+	defer cancel()
+	ticker := time.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		done, err := check()
+		if done {
+			return nil
+		}
+		if err != nil {
+			if !apierrors.IsNotFound(err) && !apierrors.IsServerTimeout(err) && !apierrors.IsTooManyRequests(err) && !apierrors.IsInternalError(err) {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			lastErr = err
+		}
+		select {
+		case <-deadline.Done():
+			if lastErr != nil {
+				return fmt.Errorf("waiting for %s: %w (last error: %v)", what, deadline.Err(), lastErr)
+			}
+			return fmt.Errorf("waiting for %s: %w", what, deadline.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// completeStorageHandoff runs after a successful migration: network
+// volumes are detached from the source node. Provisioned volumes need
+// nothing; the source claim is left for the operator to delete.
+func (n *native) completeStorageHandoff(ctx context.Context, id MigrationID, h *storageHandoff) {
+	for i := range h.volumes {
+		v := &h.volumes[i]
+		if v.Strategy == HandoffAttach && v.Attachment != "" {
+			name := attachmentName(v.PV, v.Driver, h.sourceNode)
+			if err := n.client.StorageV1().VolumeAttachments().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				slog.Warn("Storage handoff: detaching source node failed", "migration_id", id, "pvc", v.PVC, "attachment", name, "error", err)
+				v.State = HandoffCleanupErr
+				continue
+			}
+			slog.Info("Storage handoff: source node detached", "migration_id", id, "pvc", v.PVC, "source_node", h.sourceNode)
+		}
+		v.State = HandoffComplete
+	}
+}
+
+// rollbackStorageHandoff removes the destination side of the handoff
+// after a failed migration.
+func (n *native) rollbackStorageHandoff(ctx context.Context, id MigrationID, h *storageHandoff) {
+	for i := range h.volumes {
+		v := &h.volumes[i]
+		var err error
+		switch {
+		case v.Attachment != "":
+			err = n.client.StorageV1().VolumeAttachments().Delete(ctx, v.Attachment, metav1.DeleteOptions{})
+		case v.DestPVC != "":
+			err = n.client.CoreV1().PersistentVolumeClaims(h.namespace).Delete(ctx, v.DestPVC, metav1.DeleteOptions{})
+		default:
+			continue
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Warn("Storage handoff: rollback failed", "migration_id", id, "pvc", v.PVC, "attachment", v.Attachment, "dest_pvc", v.DestPVC, "error", err)
+			v.State = HandoffCleanupErr
+			continue
+		}
+		v.State = HandoffRolledBack
+	}
+}
+
+// settleStorageHandoff completes or rolls back run's handoff according to
+// the terminal update u, and reports the final volume states on it.
+func (n *native) settleStorageHandoff(ctx context.Context, id MigrationID, run *nativeRun, u *StatusUpdate) {
+	h := run.handoff
+	if h == nil || len(h.volumes) == 0 {
+		return
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if u.Phase == PhaseSucceeded {
+		n.completeStorageHandoff(cctx, id, h)
+	} else {
+		n.rollbackStorageHandoff(cctx, id, h)
+	}
+	u.VolumeHandoffs = slices.Clone(h.volumes)
+}
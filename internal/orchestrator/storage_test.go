@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// handoffClient serves a kata pod with one block PVC bound to pv. Created
+// VolumeAttachments report attached and created PVCs report bound, as a
+// CSI attacher and provisioner would.
+func handoffClient(pv *corev1.PersistentVolume) *fake.Clientset {
+	block := corev1.PersistentVolumeBlock
+	class := "fast"
+	pv.Spec.VolumeMode = &block
+	pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	pv.Spec.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	cs := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "vm"},
+			Spec: corev1.PodSpec{NodeName: "n1", Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			}},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeName:       pv.Name,
+				StorageClassName: &class,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				VolumeMode:       &block,
+			},
+		},
+		pv,
+	)
+	cs.PrependReactor("create", "volumeattachments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		a.(k8stesting.CreateAction).GetObject().(*storagev1.VolumeAttachment).Status.Attached = true
+		return false, nil, nil
+	})
+	cs.PrependReactor("create", "persistentvolumeclaims", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		a.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim).Status.Phase = corev1.ClaimBound
+		return false, nil, nil
+	})
+	return cs
+}
+
+func handoffRequest(shared bool) Request {
+	return Request{
+		SourceNode:     "n1",
+		DestNode:       "n2",
+		SourcePod:      &PodRef{Namespace: "default", Name: "vm"},
+		SharedStorage:  shared,
+		StorageHandoff: true,
+	}
+}
+
+func rbdPV() *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-rbd"},
+		Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+			CSI: &corev1.CSIPersistentVolumeSource{Driver: "rbd.csi.ceph.com", VolumeHandle: "0001-pool-img"},
+		}},
+	}
+}
+
+func TestStorageHandoff_AttachNetworkVolume(t *testing.T) {
+	t.Parallel()
+	cs := handoffClient(rbdPV())
+	n := newFromClient(cs)
+	ctx := context.Background()
+
+	h, err := n.prepareStorageHandoff(ctx, "mig1", handoffRequest(true))
+	if err != nil {
+		t.Fatalf("prepareStorageHandoff: %v", err)
+	}
+	if len(h.volumes) != 1 {
+		t.Fatalf("volumes = %+v, want just the PVC", h.volumes)
+	}
+	v := h.volumes[0]
+	destVA := attachmentName("pv-rbd", "rbd.csi.ceph.com", "n2")
+	if v.Strategy != HandoffAttach || v.State != HandoffAttached || v.Attachment != destVA {
+		t.Fatalf("volume = %+v", v)
+	}
+	va, err := cs.StorageV1().VolumeAttachments().Get(ctx, destVA, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("dest attachment: %v", err)
+	}
+	if va.Spec.NodeName != "n2" || va.Spec.Attacher != "rbd.csi.ceph.com" || *va.Spec.Source.PersistentVolumeName != "pv-rbd" {
+		t.Fatalf("dest attachment spec = %+v", va.Spec)
+	}
+
+	// Success detaches the source node.
+	srcVA := attachmentName("pv-rbd", "rbd.csi.ceph.com", "n1")
+	if _, err := cs.StorageV1().VolumeAttachments().Create(ctx, &storagev1.VolumeAttachment{ObjectMeta: metav1.ObjectMeta{Name: srcVA}}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	run := &nativeRun{handoff: h}
+	u := StatusUpdate{Phase: PhaseSucceeded}
+	n.settleStorageHandoff(ctx, "mig1", run, &u)
+	if _, err := cs.StorageV1().VolumeAttachments().Get(ctx, srcVA, metav1.GetOptions{}); err == nil {
+		t.Fatal("source attachment still exists")
+	}
+	if _, err := cs.StorageV1().VolumeAttachments().Get(ctx, destVA, metav1.GetOptions{}); err != nil {
+		t.Fatalf("dest attachment removed: %v", err)
+	}
+	if len(u.VolumeHandoffs) != 1 || u.VolumeHandoffs[0].State != HandoffComplete {
+		t.Fatalf("terminal handoffs = %+v", u.VolumeHandoffs)
+	}
+}
+
+func TestStorageHandoff_ProvisionLocalVolumeAndRollBack(t *testing.T) {
+	t.Parallel()
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-local"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "topolvm.io", VolumeHandle: "lv1"},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelHostname, Operator: corev1.NodeSelectorOpIn, Values: []string{"n1"}}},
+			}}}},
+		},
+	}
+	cs := handoffClient(pv)
+	n := newFromClient(cs)
+	ctx := context.Background()
+
+	h, err := n.prepareStorageHandoff(ctx, "mig2", handoffRequest(false))
+	if err != nil {
+		t.Fatalf("prepareStorageHandoff: %v", err)
+	}
+	v := h.volumes[0]
+	if v.Strategy != HandoffProvision || v.Driver != "topolvm.io" || v.DestPVC != "data-mig2" || v.State != HandoffProvisioned {
+		t.Fatalf("volume = %+v", v)
+	}
+	dest, err := cs.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data-mig2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("dest pvc: %v", err)
+	}
+	if dest.Annotations[selectedNodeAnnotation] != "n2" || *dest.Spec.StorageClassName != "fast" ||
+		*dest.Spec.VolumeMode != corev1.PersistentVolumeBlock || dest.Spec.Resources.Requests.Storage().String() != "10Gi" {
+		t.Fatalf("dest pvc = %+v", dest)
+	}
+
+	u := StatusUpdate{Phase: PhaseFailed}
+	n.settleStorageHandoff(ctx, "mig2", &nativeRun{handoff: h}, &u)
+	if _, err := cs.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data-mig2", metav1.GetOptions{}); err == nil {
+		t.Fatal("dest pvc not rolled back")
+	}
+	if _, err := cs.CoreV1().PersistentVolumeClaims("default").Get(ctx, "data", metav1.GetOptions{}); err != nil {
+		t.Fatalf("source pvc touched: %v", err)
+	}
+	if u.VolumeHandoffs[0].State != HandoffRolledBack {
+		t.Fatalf("state = %q", u.VolumeHandoffs[0].State)
+	}
+}
+
+func TestStorageHandoff_RejectsStorageModeMismatch(t *testing.T) {
+	t.Parallel()
+	cs := handoffClient(rbdPV())
+	n := newFromClient(cs)
+
+	_, err := n.prepareStorageHandoff(context.Background(), "mig3", handoffRequest(false))
+	if err == nil || !strings.Contains(err.Error(), "set sharedStorage") {
+		t.Fatalf("err = %v, want sharedStorage required", err)
+	}
+	vas, _ := cs.StorageV1().VolumeAttachments().List(context.Background(), metav1.ListOptions{})
+	if len(vas.Items) != 0 {
+		t.Fatalf("attachments created despite the error: %d", len(vas.Items))
+	}
+}
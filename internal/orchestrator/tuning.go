@@ -0,0 +1,38 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+)
+
+// tuningMarker is printed by the katamaran binary for each adjustment its
+// adaptive tuner makes (see migration.migrationTuner).
+const tuningMarker = "KATAMARAN_TUNING "
+
+// tuningUpdate converts a KATAMARAN_TUNING marker (everything after the
+// marker prefix) into a PhaseTransferring StatusUpdate. A downtime limit
+// adjustment also moves AppliedDowntimeMS.
+func tuningUpdate(id MigrationID, s string) StatusUpdate {
+	f := parseProgressFields(s)
+	adj := &TuningAdjustment{
+		Step:               int(parseInt64(f["step"])),
+		Param:              f["param"],
+		Old:                parseInt64(f["old"]),
+		New:                parseInt64(f["new"]),
+		ExpectedDowntimeMS: parseInt64(f["expected_downtime_ms"]),
+		DirtyRateBps:       parseInt64(f["dirty_rate_bps"]),
+		ThroughputMbps:     parseInt64(f["throughput_mbps"]),
+		RAMRemaining:       parseInt64(f["remaining"]),
+	}
+	u := StatusUpdate{
+		ID:      id,
+		Phase:   PhaseTransferring,
+		When:    time.Now(),
+		Message: fmt.Sprintf("tuning step %d: %s %d -> %d (expected downtime %dms)", adj.Step, adj.Param, adj.Old, adj.New, adj.ExpectedDowntimeMS),
+		Tuning:  adj,
+	}
+	if adj.Param == TuningParamDowntime {
+		u.AppliedDowntimeMS = adj.New
+	}
+	return u
+}
@@ -27,9 +27,11 @@ type Request struct {
 
 	// DestNode is the Kubernetes node name where the destination job runs.
 	// Required when SourcePod is nil (legacy mode). When empty and SourcePod
-	// is set, the destination Job is scheduled automatically using
-	// DestNodeSelector, DestTolerations, and an anti-affinity to exclude
-	// the source node.
+	// is set, the destination picker scores the kata nodes against the
+	// VM's memory, CPU model and disk size (see placement.go) and pins the
+	// Job to the best one. With no kata candidate besides the source, the
+	// Job is scheduled by kube-scheduler using DestNodeSelector,
+	// DestTolerations, and an anti-affinity to exclude the source node.
 	DestNode string
 
 	// DestNodeSelector is an optional label selector merged onto the
@@ -101,6 +103,68 @@ type Request struct {
 	// (currently 25ms). Only consulted when AutoDowntime is true.
 	AutoDowntimeFloorMS int
 
+	// VerifyStorage compares the mirrored drives with the destination's
+	// NBD exports after the mirrors are ready and before RAM migration:
+	// "sample" hashes a sample of 1 MiB extents per drive, "full" every
+	// extent. A mismatch fails the migration before the VM is paused.
+	// Empty or "off" skips verification. Ignored with SharedStorage.
+	VerifyStorage string
+
+	// StorageHandoff hands the source pod's volumeMode: Block PVCs over
+	// to DestNode (see storage.go): network CSI volumes are attached to
+	// DestNode before the migration and detached from SourceNode after
+	// it (requires SharedStorage); local volumes get a matching PVC
+	// provisioned on DestNode before mirroring (requires SharedStorage
+	// false). Requires SourcePod and a known DestNode.
+	StorageHandoff bool
+
+	// Cold migrates the VM cold (`katamaran --mode cold`): the source
+	// pauses it, copies its disks and streams its state, and the dest
+	// resumes it afterwards. A VM with migration blockers (e.g. VFIO
+	// passthrough) sends no state and boots the copied disks on the
+	// destination, which needs ReplayCmdline. Downtime is the whole copy.
+	Cold bool
+
+	// AllowColdFallback switches a live migration to Cold when the
+	// preflight probe on the source node finds migration blockers.
+	// Requires SourcePod and ReplayCmdline.
+	AllowColdFallback bool
+
+	// Balloon shrinks the guest with its virtio-balloon device before
+	// the RAM transfer (`katamaran --balloon`) and deflates it once the
+	// VM runs on the destination, so less memory is migrated. A VM
+	// without a balloon device migrates in full. Ignored with Cold.
+	Balloon bool
+
+	// Compression compresses the RAM stream (`katamaran --compression`):
+	// "none" (the default when empty), "zstd" or "zlib" (both need
+	// MultifdChannels), "xbzrle" (migrates without multifd), or "auto",
+	// which has the source pick from the RTT and bandwidth it measures
+	// to the destination node. CompressionLevel tunes zstd (1-20) and
+	// zlib (1-9); zero uses QEMU's default. Ignored with Cold.
+	Compression      string
+	CompressionLevel int
+
+	// AdaptiveTuning has the source replace auto-converge's vCPU
+	// throttling with a controller that, while the transfer does not
+	// converge, doubles MaxBandwidthMBps and then raises the downtime
+	// limit stepwise up to MaxDowntimeMS (`katamaran --adaptive-tuning`).
+	// Each step is reported as a StatusUpdate.Tuning. MaxDowntimeMS zero
+	// uses the binary's 500ms; MaxBandwidthMBps zero leaves the transfer
+	// uncapped. Ignored with Cold.
+	AdaptiveTuning   bool
+	MaxDowntimeMS    int
+	MaxBandwidthMBps int
+
+	// Hooks are the actions run around the cutover: PreCutover by the
+	// source Job before the VM's state starts moving, PostResume by the
+	// dest Job once the VM runs there. Require SourcePod.
+	Hooks MigrationHooks
+
+	// JobOverrides adjusts this migration's source and destination Jobs
+	// within the orchestrator's JobPolicy.
+	JobOverrides JobOverrides
+
 	// CNIConvergenceDelaySeconds is how long the source keeps the IP
 	// tunnel alive after the cutover so the cluster's CNI can propagate
 	// the pod's new node binding. Zero falls back to the source binary's
@@ -150,6 +214,50 @@ type Request struct {
 	KubectlContext string
 }
 
+// MigrationHooks lists the hooks of each phase, run in order.
+type MigrationHooks struct {
+	PreCutover []Hook `json:"preCutover,omitempty"`
+	PostResume []Hook `json:"postResume,omitempty"`
+}
+
+// Hook is one pre-cutover or post-resume action: an HTTP call to the VM's
+// pod IP, a command run in a pod container through the exec API, or a
+// command run in the guest through qemu-guest-agent. Exactly one of HTTP,
+// Exec and GuestExec is set. The JSON shape is both the Migration CRD's
+// spec.hooks entries and what the katamaran binary takes in
+// --pre-cutover-hooks / --post-resume-hooks.
+type Hook struct {
+	Name      string         `json:"name"`
+	HTTP      *HTTPHook      `json:"http,omitempty"`
+	Exec      *ExecHook      `json:"exec,omitempty"`
+	GuestExec *GuestExecHook `json:"guestExec,omitempty"`
+	// TimeoutSeconds bounds the hook; zero uses the binary's 30s.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// FailurePolicy is "abort" (the default) or "ignore".
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+// HTTPHook calls http://<pod IP>:<Port><Path> with Method (GET or POST).
+type HTTPHook struct {
+	Port   int    `json:"port"`
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+}
+
+// ExecHook runs Command in Container of the source pod (pre-cutover) or
+// of DestPod, falling back to the source pod (post-resume).
+type ExecHook struct {
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// GuestExecHook runs Command in the guest through the qemu-guest-agent
+// socket named Socket (default qga.sock) in the VM's sandbox directory.
+type GuestExecHook struct {
+	Command []string `json:"command"`
+	Socket  string   `json:"socket,omitempty"`
+}
+
 // PodRef identifies a Kubernetes pod by namespace + name.
 type PodRef struct {
 	Namespace string
@@ -198,6 +306,16 @@ type StatusUpdate struct {
 	// pause duration measured by QEMU's query-migrate.
 	DowntimeMS int64
 
+	// MemorySavedBytes is set in the final PhaseSucceeded update when
+	// Request.Balloon took memory from the guest before the transfer.
+	MemorySavedBytes int64
+
+	// Compression and CompressionRatio are set in the final
+	// PhaseSucceeded update: the compression the source used (auto
+	// resolved) and the guest page bytes each byte on the wire carried.
+	Compression      string
+	CompressionRatio float64
+
 	// AppliedDowntimeMS is the downtime limit the source binary
 	// programmed into QEMU before starting RAM migration. Equal to the
 	// caller-supplied value when AutoDowntime is false, or to the
@@ -215,4 +333,102 @@ type StatusUpdate struct {
 	// AutoDowntime mirrors Request.AutoDowntime so consumers know
 	// whether AppliedDowntimeMS came from the auto-calc path.
 	AutoDowntime bool
+
+	// VMStoppedAt is when QEMU paused the source VM (source node clock)
+	// and VMResumedAt when the destination VM resumed (dest node clock):
+	// the bounds of the cutover blackout. VMStoppedAt first appears on
+	// the PhaseCutover update; both are set on PhaseSucceeded when the
+	// KATAMARAN_VM_STOPPED / KATAMARAN_VM_RESUMED markers were scraped.
+	// Zero when unknown.
+	VMStoppedAt time.Time
+	VMResumedAt time.Time
+
+	// Placement is set on the PhaseSubmitted update when the destination
+	// picker chose DestNode for an auto-select request. Nil when DestNode
+	// was explicit or kube-scheduler placed the dest Job.
+	Placement *Placement
+
+	// StorageVerification is the result of verifying one mirrored drive
+	// (Request.VerifyStorage), one update per drive before RAM migration
+	// starts. Nil on every other update.
+	StorageVerification *StorageVerification
+
+	// VolumeHandoffs lists the source pod's PVCs handed over to the
+	// destination node (Request.StorageHandoff): on PhaseSubmitted once
+	// the destination side is ready, and on the terminal update with the
+	// final states. Nil on every other update.
+	VolumeHandoffs []VolumeHandoff
+
+	// Cold is set on PhaseSubmitted when the migration runs cold,
+	// requested or chosen by the preflight.
+	// MigrationBlockers lists the blockers the preflight found (set on
+	// PhaseSubmitted when AllowColdFallback probed the VM).
+	Cold              bool
+	MigrationBlockers []string
+
+	// Hooks are hook results from the KATAMARAN_HOOK markers: each
+	// pre-cutover hook on its own PhaseTransferring update as it finishes,
+	// the post-resume hooks on PhaseSucceeded. Nil on every other update.
+	Hooks []HookResult
+
+	// Tuning is one adjustment of the source's adaptive tuner
+	// (Request.AdaptiveTuning), each on its own PhaseTransferring update.
+	// Nil on every other update.
+	Tuning *TuningAdjustment
+
+	// DestSandboxID is the sandbox the destination VM landed in, from the
+	// dest's KATAMARAN_DEST_READY marker. Set on PhaseSucceeded; VM
+	// adoption needs it to find the migrated QEMU. Empty when unknown.
+	DestSandboxID string
+}
+
+// HookResult is the outcome of one hook run.
+type HookResult struct {
+	// Phase is "preCutover" or "postResume"; Type "http", "exec" or
+	// "guestExec".
+	Phase string
+	Name  string
+	Type  string
+	// Result is "ok", "failed" (the migration aborts) or "ignored"
+	// (failed under the ignore failure policy).
+	Result     string
+	DurationMS int64
+	// Error is the failure, empty on "ok".
+	Error string
+}
+
+// Parameters the adaptive tuner adjusts (TuningAdjustment.Param).
+const (
+	// TuningParamBandwidth is QEMU's max-bandwidth, in bytes per second.
+	TuningParamBandwidth = "max_bandwidth"
+	// TuningParamDowntime is QEMU's downtime-limit, in milliseconds.
+	TuningParamDowntime = "downtime_limit_ms"
+)
+
+// TuningAdjustment is one step of the adaptive tuner, with the
+// query-migrate figures that triggered it.
+type TuningAdjustment struct {
+	// Step counts the adjustments of a migration from 1.
+	Step     int
+	Param    string
+	Old, New int64
+
+	ExpectedDowntimeMS int64
+	DirtyRateBps       int64
+	ThroughputMbps     int64
+	RAMRemaining       int64
+}
+
+// StorageVerification is the per-drive result of comparing the source
+// drive with the destination's copy before cutover.
+type StorageVerification struct {
+	DriveID string
+	// Mode is "sample" or "full".
+	Mode string
+	// Result is "ok", "mismatch" (the migration aborts) or "unsettled"
+	// (extents kept differing while the guest kept writing to the disk;
+	// reported but not fatal).
+	Result     string
+	Extents    int
+	Mismatched int
 }
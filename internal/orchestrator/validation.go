@@ -1,6 +1,7 @@
 package orchestrator
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"net/netip"
@@ -52,6 +53,41 @@ func Validate(req Request) error {
 	if req.AutoDowntimeFloorMS < 0 {
 		return fmt.Errorf("autoDowntimeFloorMS must be non-negative, got %d", req.AutoDowntimeFloorMS)
 	}
+	if req.VerifyStorage != "" && req.VerifyStorage != "off" && req.VerifyStorage != "sample" && req.VerifyStorage != "full" {
+		return fmt.Errorf("verifyStorage must be one of off, sample, or full, got %q", req.VerifyStorage)
+	}
+	if req.MaxDowntimeMS < 0 || req.MaxDowntimeMS > 60000 {
+		return fmt.Errorf("maxDowntimeMS must be between 0 and 60000, got %d", req.MaxDowntimeMS)
+	}
+	if downtime := cmp.Or(req.DowntimeMS, 25); req.MaxDowntimeMS > 0 && !req.AutoDowntime && req.MaxDowntimeMS < downtime {
+		return fmt.Errorf("maxDowntimeMS (%d) must not be below downtimeMS (%d)", req.MaxDowntimeMS, downtime)
+	}
+	if req.MaxBandwidthMBps < 0 {
+		return fmt.Errorf("maxBandwidthMBps must be non-negative, got %d", req.MaxBandwidthMBps)
+	}
+	if err := validateCompression(req); err != nil {
+		return err
+	}
+	if req.StorageHandoff && req.SourcePod == nil {
+		return errors.New("storageHandoff requires sourcePod")
+	}
+	if req.AllowColdFallback && (req.SourcePod == nil || !req.ReplayCmdline) {
+		return errors.New("allowColdFallback requires sourcePod and replayCmdline")
+	}
+	if len(req.Hooks.PreCutover)+len(req.Hooks.PostResume) > 0 && req.SourcePod == nil {
+		// The binaries find the VM's IP, exec target and sandbox through
+		// the source pod.
+		return errors.New("hooks require sourcePod")
+	}
+	if err := validateHooks("preCutover", req.Hooks.PreCutover); err != nil {
+		return err
+	}
+	if err := validateHooks("postResume", req.Hooks.PostResume); err != nil {
+		return err
+	}
+	if err := validateJobOverrides(req.JobOverrides); err != nil {
+		return err
+	}
 	if req.CNIConvergenceDelaySeconds < 0 {
 		return fmt.Errorf("cniConvergenceDelaySeconds must be non-negative, got %d", req.CNIConvergenceDelaySeconds)
 	}
@@ -159,3 +195,28 @@ func ValidateSafeArgValue(field, value string) error {
 	}
 	return nil
 }
+
+// validateCompression mirrors the katamaran binary's --compression checks,
+// so a bad Migration fails before any Job exists.
+func validateCompression(req Request) error {
+	maxLevel := 0
+	switch req.Compression {
+	case "", "none", "xbzrle", "auto":
+	case "zstd":
+		maxLevel = 20
+	case "zlib":
+		maxLevel = 9
+	default:
+		return fmt.Errorf("compression must be one of none, zstd, zlib, xbzrle, or auto, got %q", req.Compression)
+	}
+	if req.CompressionLevel != 0 && maxLevel == 0 {
+		return fmt.Errorf("compressionLevel applies to zstd and zlib only, not %q", req.Compression)
+	}
+	if req.CompressionLevel < 0 || req.CompressionLevel > maxLevel {
+		return fmt.Errorf("compressionLevel for %s must be between 0 and %d, got %d", req.Compression, maxLevel, req.CompressionLevel)
+	}
+	if maxLevel > 0 && req.MultifdChannels == 0 && !req.Cold {
+		return fmt.Errorf("compression %s requires multifdChannels", req.Compression)
+	}
+	return nil
+}
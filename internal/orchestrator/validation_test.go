@@ -186,6 +186,96 @@ func TestValidateRejectsNegativeAutoDowntimeFloor(t *testing.T) {
 	}
 }
 
+func TestValidateRejectsUnknownVerifyStorage(t *testing.T) {
+	t.Parallel()
+	req := validRequestForValidation()
+	req.VerifyStorage = "paranoid"
+
+	err := Validate(req)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if !strings.Contains(err.Error(), "verifyStorage") {
+		t.Fatalf("expected verifyStorage error, got: %v", err)
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name    string
+		mutate  func(*Request)
+		wantErr string
+	}{
+		{"auto", func(r *Request) { r.Compression = "auto" }, ""},
+		{"zstd level", func(r *Request) { r.Compression, r.CompressionLevel, r.MultifdChannels = "zstd", 19, 4 }, ""},
+		{"unknown", func(r *Request) { r.Compression = "lz4" }, "compression must be one of"},
+		{"zlib level", func(r *Request) { r.Compression, r.CompressionLevel, r.MultifdChannels = "zlib", 12, 4 }, "between 0 and 9"},
+		{"level without method", func(r *Request) { r.CompressionLevel = 3 }, "zstd and zlib only"},
+		{"zstd without multifd", func(r *Request) { r.Compression, r.MultifdChannels = "zstd", 0 }, "requires multifdChannels"},
+		{"cold ignores", func(r *Request) { r.Compression, r.MultifdChannels, r.Cold = "zstd", 0, true }, ""},
+	} {
+		req := validRequestForValidation()
+		tc.mutate(&req)
+		err := Validate(req)
+		if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: Validate = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestValidateAdaptiveTuning(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name    string
+		mutate  func(*Request)
+		wantErr string
+	}{
+		{"ceiling", func(r *Request) { r.AdaptiveTuning, r.MaxDowntimeMS, r.MaxBandwidthMBps = true, 500, 1024 }, ""},
+		{"ceiling too high", func(r *Request) { r.MaxDowntimeMS = 60001 }, "maxDowntimeMS must be between"},
+		{"ceiling below default downtime", func(r *Request) { r.DowntimeMS, r.MaxDowntimeMS = 0, 10 }, "must not be below downtimeMS (25)"},
+		{"ceiling below auto downtime", func(r *Request) { r.AutoDowntime, r.MaxDowntimeMS = true, 10 }, ""},
+		{"negative bandwidth", func(r *Request) { r.MaxBandwidthMBps = -1 }, "maxBandwidthMBps must be non-negative"},
+	} {
+		req := validRequestForValidation()
+		tc.mutate(&req)
+		err := Validate(req)
+		if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: Validate = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestValidateStorageHandoffRequiresSourcePod(t *testing.T) {
+	t.Parallel()
+	req := validRequestForValidation()
+	req.SourcePod = nil
+	req.SourceQMP = "/run/vc/vm/abc/extra-monitor.sock"
+	req.VMIP = "10.244.1.5"
+	req.StorageHandoff = true
+
+	err := Validate(req)
+	if err == nil || !strings.Contains(err.Error(), "storageHandoff requires sourcePod") {
+		t.Fatalf("expected storageHandoff error, got: %v", err)
+	}
+}
+
+func TestValidateColdFallbackRequiresReplay(t *testing.T) {
+	t.Parallel()
+	req := validRequestForValidation()
+	req.SourcePod = &PodRef{Namespace: "default", Name: "vm"}
+	req.AllowColdFallback = true
+
+	err := Validate(req)
+	if err == nil || !strings.Contains(err.Error(), "allowColdFallback requires") {
+		t.Fatalf("expected allowColdFallback error, got: %v", err)
+	}
+	req.ReplayCmdline = true
+	if err := Validate(req); err != nil {
+		t.Fatalf("Validate with replay: %v", err)
+	}
+}
+
 func TestValidateRejectsNegativeCNIConvergenceDelay(t *testing.T) {
 	t.Parallel()
 	req := validRequestForValidation()
@@ -239,3 +329,47 @@ func validRequestForValidation() Request {
 		MultifdChannels: 4,
 	}
 }
+
+func TestValidateHooks(t *testing.T) {
+	t.Parallel()
+	hooksRequest := func() Request {
+		req := validRequestForValidation()
+		req.SourcePod = &PodRef{Namespace: "default", Name: "vm"}
+		req.Hooks = MigrationHooks{
+			PreCutover: []Hook{{Name: "flush-wal", Exec: &ExecHook{Container: "db", Command: []string{"pg_ctl", "checkpoint"}}, TimeoutSeconds: 60}},
+			PostResume: []Hook{{Name: "register", HTTP: &HTTPHook{Port: 8080, Path: "/register", Method: "POST"}, FailurePolicy: "ignore"}},
+		}
+		return req
+	}
+	if err := Validate(hooksRequest()); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		mutate func(*Request)
+		want   string
+	}{
+		"no source pod": {func(r *Request) {
+			r.SourcePod = nil
+			r.SourceQMP = "/run/vc/vm/abc/extra-monitor.sock"
+			r.VMIP = "10.244.1.5"
+		}, "hooks require sourcePod"},
+		"two handlers": {func(r *Request) {
+			r.Hooks.PreCutover[0].GuestExec = &GuestExecHook{Command: []string{"sync"}}
+		}, "exactly one of"},
+		"duplicate name": {func(r *Request) {
+			r.Hooks.PostResume = append(r.Hooks.PostResume, r.Hooks.PostResume[0])
+		}, "duplicate hook name"},
+		"bad policy": {func(r *Request) { r.Hooks.PreCutover[0].FailurePolicy = "retry" }, "failurePolicy"},
+		"bad port":   {func(r *Request) { r.Hooks.PostResume[0].HTTP.Port = 70000 }, "http port"},
+		"socket path": {func(r *Request) {
+			r.Hooks.PostResume[0] = Hook{Name: "clock", GuestExec: &GuestExecHook{Command: []string{"hwclock"}, Socket: "/tmp/qga.sock"}}
+		}, "guestExec socket"},
+	} {
+		req := hooksRequest()
+		tc.mutate(&req)
+		if err := Validate(req); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate error = %v, want %q", name, err, tc.want)
+		}
+	}
+}
@@ -318,6 +318,23 @@ func (c *Client) Execute(ctx context.Context, cmd string, args Args) (json.RawMe
 	}
 }
 
+// TakeEvents removes the buffered events named eventName and returns their
+// data payloads, oldest first. It does not read from the socket; events
+// are buffered while Execute and WaitForEvent read responses.
+func (c *Client) TakeEvents(eventName string) []json.RawMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var data []json.RawMessage
+	c.events = slices.DeleteFunc(c.events, func(ev response) bool {
+		if ev.Event != eventName {
+			return false
+		}
+		data = append(data, ev.Data)
+		return true
+	})
+	return data
+}
+
 // WaitForEvent blocks until the named QMP event is received or the timeout
 // elapses. Non-matching events are buffered for later retrieval. The buffered
 // event queue is checked first to find events that arrived during prior
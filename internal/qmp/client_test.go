@@ -336,6 +336,46 @@ func TestWaitForEvent_FromBuffer(t *testing.T) {
 	}
 }
 
+func TestTakeEvents(t *testing.T) {
+	t.Parallel()
+	sock := qmptest.StartFakeQMP(t, func(conn net.Conn) {
+		qmptest.QMPHandshake(conn)
+		buf := make([]byte, 4096)
+		conn.Read(buf)
+		conn.Write([]byte(`{"event":"BLOCK_JOB_ERROR","data":{"device":"mirror-a","operation":"write","action":"stop"}}` + "\n"))
+		conn.Write([]byte(`{"event":"STOP"}` + "\n"))
+		conn.Write([]byte(`{"event":"BLOCK_JOB_ERROR","data":{"device":"mirror-b","operation":"write","action":"stop"}}` + "\n"))
+		conn.Write([]byte(`{"return":[]}` + "\n"))
+		holdConnUntilClosed(conn)
+	})
+
+	ctx := context.Background()
+	c, err := NewClient(ctx, sock)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Execute(ctx, "query-block-jobs", nil); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	data := c.TakeEvents("BLOCK_JOB_ERROR")
+	if len(data) != 2 {
+		t.Fatalf("got %d BLOCK_JOB_ERROR events, want 2", len(data))
+	}
+	var ev BlockJobErrorEvent
+	if err := json.Unmarshal(data[1], &ev); err != nil || ev.Device != "mirror-b" || ev.Action != "stop" {
+		t.Fatalf("second event = %+v, %v", ev, err)
+	}
+	if more := c.TakeEvents("BLOCK_JOB_ERROR"); len(more) != 0 {
+		t.Fatalf("events not removed: %d left", len(more))
+	}
+	// Other events stay buffered.
+	if err := c.WaitForEvent(ctx, "STOP", time.Second); err != nil {
+		t.Fatalf("WaitForEvent(STOP): %v", err)
+	}
+}
+
 func TestWaitForEvent_FromWire(t *testing.T) {
 	t.Parallel()
 	sock := qmptest.StartFakeQMP(t, func(conn net.Conn) {
@@ -550,6 +590,104 @@ func TestArgs_JSONSerialization(t *testing.T) {
 				"writable": true,
 			},
 		},
+		{
+			name: "BlockExportAddArgs",
+			args: BlockExportAddArgs{Type: "nbd", ID: "export-vol1", NodeName: "drive-vol1", Name: "drive-vol1", Writable: true},
+			want: map[string]any{
+				"type":      "nbd",
+				"id":        "export-vol1",
+				"node-name": "drive-vol1",
+				"name":      "drive-vol1",
+				"writable":  true,
+			},
+		},
+		{
+			name: "BlockdevAddNBDArgs",
+			args: BlockdevAddNBDArgs{
+				Driver:   "nbd",
+				NodeName: "katamaran-nbd-vol1",
+				Server:   InetSocketAddress{Type: "inet", Host: "fd00::2", Port: "10809"},
+				Export:   "drive-vol1",
+			},
+			want: map[string]any{
+				"driver":    "nbd",
+				"node-name": "katamaran-nbd-vol1",
+				"server":    map[string]any{"type": "inet", "host": "fd00::2", "port": "10809"},
+				"export":    "drive-vol1",
+			},
+		},
+		{
+			name: "BlockdevMirrorArgs",
+			args: BlockdevMirrorArgs{JobID: "mirror-vol1", Device: "drive-vol1", Target: "katamaran-nbd-vol1", Sync: "full"},
+			want: map[string]any{
+				"job-id": "mirror-vol1",
+				"device": "drive-vol1",
+				"target": "katamaran-nbd-vol1",
+				"sync":   "full",
+			},
+		},
+		{
+			name: "NBDServerStartArgs unix",
+			args: NBDServerStartArgs{
+				Addr: NBDServerAddr{Type: "unix", Data: NBDServerAddrData{Path: "/run/vc/vm/abc/katamaran-verify-nbd.sock"}},
+			},
+			want: map[string]any{
+				"addr": map[string]any{
+					"type": "unix",
+					"data": map[string]any{"path": "/run/vc/vm/abc/katamaran-verify-nbd.sock"},
+				},
+			},
+		},
+		{
+			name: "BlockDirtyBitmapArgs",
+			args: BlockDirtyBitmapArgs{Node: "drive-virtio-disk0", Name: "katamaran-verify"},
+			want: map[string]any{"node": "drive-virtio-disk0", "name": "katamaran-verify"},
+		},
+		{
+			name: "BlockdevBackupArgs",
+			args: BlockdevBackupArgs{JobID: "resync-vol1", Device: "drive-vol1", Target: "katamaran-resync-vol1", Sync: "bitmap", Bitmap: "katamaran-mirror", BitmapMode: "on-success"},
+			want: map[string]any{
+				"job-id":       "resync-vol1",
+				"device":       "drive-vol1",
+				"target":       "katamaran-resync-vol1",
+				"sync":         "bitmap",
+				"bitmap":       "katamaran-mirror",
+				"bitmap-mode":  "on-success",
+				"auto-dismiss": false,
+			},
+		},
+		{
+			name: "DriveBackupArgs",
+			args: DriveBackupArgs{JobID: "checkpoint-drive0", Device: "drive0", Target: "/ckpt/disks/drive0.raw", Format: "raw", Sync: "full", Mode: "absolute-paths"},
+			want: map[string]any{
+				"job-id":       "checkpoint-drive0",
+				"device":       "drive0",
+				"target":       "/ckpt/disks/drive0.raw",
+				"format":       "raw",
+				"sync":         "full",
+				"mode":         "absolute-paths",
+				"auto-dismiss": false,
+			},
+		},
+		{
+			name: "BlockdevAddFileArgs",
+			args: BlockdevAddFileArgs{Driver: "raw", NodeName: "katamaran-checkpoint-vol1", File: BlockdevFileNode{Driver: "file", Filename: "/ckpt/disks/vol1.raw"}},
+			want: map[string]any{
+				"driver":    "raw",
+				"node-name": "katamaran-checkpoint-vol1",
+				"file":      map[string]any{"driver": "file", "filename": "/ckpt/disks/vol1.raw"},
+			},
+		},
+		{
+			name: "BlockJobResumeArgs",
+			args: BlockJobResumeArgs{Device: "mirror-vol1"},
+			want: map[string]any{"device": "mirror-vol1"},
+		},
+		{
+			name: "BlockdevDelArgs",
+			args: BlockdevDelArgs{NodeName: "katamaran-nbd-vol1"},
+			want: map[string]any{"node-name": "katamaran-nbd-vol1"},
+		},
 		{
 			name: "DriveMirrorArgs",
 			args: DriveMirrorArgs{
@@ -617,6 +755,23 @@ func TestArgs_JSONSerialization(t *testing.T) {
 				"step":    float64(100),
 			},
 		},
+		{
+			name: "QOMGetArgs",
+			args: QOMGetArgs{Path: "/machine/peripheral/net1", Property: "netdev"},
+			want: map[string]any{
+				"path":     "/machine/peripheral/net1",
+				"property": "netdev",
+			},
+		},
+		{
+			name: "RawArgs",
+			args: RawArgs(`{"driver":"pc-dimm","id":"dimm1","memdev":"mem1"}`),
+			want: map[string]any{
+				"driver": "pc-dimm",
+				"id":     "dimm1",
+				"memdev": "mem1",
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -820,6 +975,18 @@ func TestMigrateInfo_Unmarshal(t *testing.T) {
 			t.Fatalf("RAM.Remaining = %d, want 0", info.RAM.Remaining)
 		}
 	})
+
+	t.Run("blocked_reasons", func(t *testing.T) {
+		t.Parallel()
+		raw := `{"blocked-reasons":["VFIO device doesn't support migration"]}`
+		var info MigrateInfo
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if len(info.BlockedReasons) != 1 || info.BlockedReasons[0] != "VFIO device doesn't support migration" {
+			t.Fatalf("BlockedReasons = %q", info.BlockedReasons)
+		}
+	})
 }
 
 func TestNewClient_ReadGreetingError(t *testing.T) {
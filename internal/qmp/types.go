@@ -23,6 +23,7 @@ type response struct {
 	Return json.RawMessage `json:"return,omitempty"`
 	Error  *Error          `json:"error,omitempty"`
 	Event  string          `json:"event,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
 }
 
 // Error represents a QMP protocol-level error.
@@ -41,6 +42,7 @@ type BlockJobStatus string
 const (
 	BlockJobStatusConcluded BlockJobStatus = "concluded"
 	BlockJobStatusNull      BlockJobStatus = "null"
+	BlockJobStatusPaused    BlockJobStatus = "paused"
 )
 
 // BlockJobInfo represents a single entry returned by query-block-jobs.
@@ -52,6 +54,103 @@ type BlockJobInfo struct {
 	Ready  bool           `json:"ready"`
 	Status BlockJobStatus `json:"status"`
 	Type   string         `json:"type"`
+	// Paused and IOStatus report a job stopped by on-target-error=stop:
+	// paused with io-status "failed" or "nospace".
+	Paused   bool   `json:"paused,omitempty"`
+	IOStatus string `json:"io-status,omitempty"`
+	// Error is set on a concluded job that failed.
+	Error string `json:"error,omitempty"`
+}
+
+// BlockJobErrorEvent is the data of a BLOCK_JOB_ERROR event.
+type BlockJobErrorEvent struct {
+	Device    string `json:"device"` // job ID
+	Operation string `json:"operation"`
+	Action    string `json:"action"` // "stop", "report" or "ignore"
+}
+
+// BlockInfo represents a single entry returned by query-block.
+type BlockInfo struct {
+	Device   string           `json:"device"`
+	Qdev     string           `json:"qdev,omitempty"` // attached device id or QOM path
+	Inserted *BlockDeviceInfo `json:"inserted,omitempty"`
+}
+
+// BlockDeviceInfo describes the medium inserted into a block device.
+type BlockDeviceInfo struct {
+	File     string         `json:"file"`
+	NodeName string         `json:"node-name,omitempty"`
+	Drv      string         `json:"drv,omitempty"`
+	RO       bool           `json:"ro,omitempty"`
+	Image    BlockImageInfo `json:"image"`
+
+	DirtyBitmaps []BlockDirtyInfo `json:"dirty-bitmaps,omitempty"`
+}
+
+// BlockDirtyInfo is one dirty bitmap on a block node.
+type BlockDirtyInfo struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"` // dirty bytes
+}
+
+// BlockImageInfo carries the image size reported by query-block.
+type BlockImageInfo struct {
+	Filename    string `json:"filename"`
+	VirtualSize int64  `json:"virtual-size"`
+}
+
+// PCIInfo is one PCI bus returned by query-pci.
+type PCIInfo struct {
+	Bus     int             `json:"bus"`
+	Devices []PCIDeviceInfo `json:"devices"`
+}
+
+// PCIDeviceInfo is a device on a PCI bus. Bridges and root ports carry
+// the devices on their secondary bus in PCIBridge.
+type PCIDeviceInfo struct {
+	Bus       int            `json:"bus"`
+	Slot      int            `json:"slot"`
+	Function  int            `json:"function"`
+	QdevID    string         `json:"qdev_id"`
+	PCIBridge *PCIBridgeInfo `json:"pci_bridge,omitempty"`
+}
+
+// PCIBridgeInfo lists the devices behind a PCI bridge.
+type PCIBridgeInfo struct {
+	Devices []PCIDeviceInfo `json:"devices,omitempty"`
+}
+
+// HotpluggableCPU is an entry returned by query-hotpluggable-cpus. QOMPath
+// is set only for plugged CPUs; device_add'ed ones live under
+// /machine/peripheral.
+type HotpluggableCPU struct {
+	Type       string           `json:"type"`
+	VCPUsCount int              `json:"vcpus-count"`
+	Props      map[string]int64 `json:"props"`
+	QOMPath    string           `json:"qom-path,omitempty"`
+}
+
+// MemoryDeviceInfo is an entry returned by query-memory-devices.
+type MemoryDeviceInfo struct {
+	Type string           `json:"type"`
+	Data MemoryDeviceData `json:"data"`
+}
+
+// MemoryDeviceData carries the fields shared by dimm, nvdimm and
+// virtio-mem entries that device replay needs.
+type MemoryDeviceData struct {
+	ID         string `json:"id,omitempty"`
+	Memdev     string `json:"memdev"` // QOM path of the backend, e.g. /objects/mem0
+	Size       int64  `json:"size"`
+	Node       int64  `json:"node"`
+	Hotplugged bool   `json:"hotplugged"`
+}
+
+// ObjectPropertyInfo is an entry returned by qom-list. Children report
+// Type as "child<type-name>".
+type ObjectPropertyInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
 }
 
 // MigrateStatus represents the status of a migration.
@@ -72,10 +171,51 @@ type MigrateInfo struct {
 		Total       int64 `json:"total"`
 		Transferred int64 `json:"transferred"`
 		Remaining   int64 `json:"remaining"`
+		// Normal and NormalBytes count the pages sent in full (neither
+		// zero nor XBZRLE-encoded), before multifd compression.
+		Normal      int64 `json:"normal,omitempty"`
+		NormalBytes int64 `json:"normal-bytes,omitempty"`
+		PageSize    int64 `json:"page-size,omitempty"`
+		// DirtyPagesRate is the guest's page dirtying rate in pages per
+		// second, DirtySyncCount the number of passes over guest memory
+		// so far, and Mbps the recent transfer rate in Mbit/s.
+		DirtyPagesRate int64   `json:"dirty-pages-rate,omitempty"`
+		DirtySyncCount int64   `json:"dirty-sync-count,omitempty"`
+		Mbps           float64 `json:"mbps,omitempty"`
 	} `json:"ram,omitempty"`
 	Downtime  int64 `json:"downtime,omitempty"`
 	SetupTime int64 `json:"setup-time,omitempty"`
 	TotalTime int64 `json:"total-time,omitempty"`
+	// ExpectedDowntime is QEMU's estimate, in ms, of the pause needed to
+	// send what is still dirty. Reported while the migration is active.
+	ExpectedDowntime int64 `json:"expected-downtime,omitempty"`
+	// BlockedReasons lists the migration blockers (e.g. a VFIO device
+	// without migration support). Reported by QEMU 6.0+ whether or not
+	// a migration is running; non-empty means migrate will fail.
+	BlockedReasons []string `json:"blocked-reasons,omitempty"`
+	// XBZRLECache is reported while the xbzrle capability is on.
+	XBZRLECache *XBZRLECacheInfo `json:"xbzrle-cache,omitempty"`
+}
+
+// XBZRLECacheInfo is query-migrate's xbzrle-cache: Pages were sent as
+// Bytes of XBZRLE deltas.
+type XBZRLECacheInfo struct {
+	CacheSize int64 `json:"cache-size"`
+	Bytes     int64 `json:"bytes"`
+	Pages     int64 `json:"pages"`
+	CacheMiss int64 `json:"cache-miss"`
+	Overflow  int64 `json:"overflow"`
+}
+
+// BalloonInfo is the response from query-balloon.
+type BalloonInfo struct {
+	Actual int64 `json:"actual"` // Guest memory size in bytes, balloon excluded.
+}
+
+// MemorySizeSummary is the response from query-memory-size-summary.
+type MemorySizeSummary struct {
+	BaseMemory    int64 `json:"base-memory"`
+	PluggedMemory int64 `json:"plugged-memory,omitempty"`
 }
 
 // QMP command argument types — strictly typed to prevent typos and ensure
@@ -92,25 +232,128 @@ type NBDServerAddr struct {
 	Data NBDServerAddrData `json:"data"`
 }
 
-// NBDServerAddrData contains the host and port for the NBD server address.
+// NBDServerAddrData contains the host and port (type "inet") or the socket
+// path (type "unix") for the NBD server address.
 type NBDServerAddrData struct {
-	Host string `json:"host"`
-	Port string `json:"port"`
+	Host string `json:"host,omitempty"`
+	Port string `json:"port,omitempty"`
+	Path string `json:"path,omitempty"`
 }
 
 // NBDServerAddArgs are the arguments for the nbd-server-add command.
 type NBDServerAddArgs struct {
 	Device   string `json:"device"`
+	Name     string `json:"name,omitempty"` // export name; defaults to Device
+	Writable bool   `json:"writable"`
+}
+
+// BlockExportAddArgs are the arguments for block-export-add, the
+// node-name based replacement for nbd-server-add (QEMU 5.2+).
+type BlockExportAddArgs struct {
+	Type     string `json:"type"` // "nbd"
+	ID       string `json:"id"`
+	NodeName string `json:"node-name"`
+	Name     string `json:"name"` // NBD export name
 	Writable bool   `json:"writable"`
 }
 
+// InetSocketAddress is a flat SocketAddress of type "inet".
+type InetSocketAddress struct {
+	Type string `json:"type"` // "inet"
+	Host string `json:"host"`
+	Port string `json:"port"`
+}
+
+// BlockdevAddNBDArgs are the arguments for blockdev-add of an NBD client
+// node, used as the blockdev-mirror target.
+type BlockdevAddNBDArgs struct {
+	Driver   string            `json:"driver"` // "nbd"
+	NodeName string            `json:"node-name"`
+	Server   InetSocketAddress `json:"server"`
+	Export   string            `json:"export"`
+	// ReconnectDelay is how many seconds I/O waits for the NBD client to
+	// reconnect after the connection drops before failing.
+	ReconnectDelay int `json:"reconnect-delay,omitempty"`
+}
+
+// BlockDirtyBitmapArgs are the arguments for block-dirty-bitmap-add,
+// -clear and -remove.
+type BlockDirtyBitmapArgs struct {
+	Node string `json:"node"`
+	Name string `json:"name"`
+}
+
+// BlockdevDelArgs are the arguments for the blockdev-del command.
+type BlockdevDelArgs struct {
+	NodeName string `json:"node-name"`
+}
+
+// BlockdevMirrorArgs are the arguments for blockdev-mirror. Unlike
+// drive-mirror, Device may be a node name and Target is an existing node.
+type BlockdevMirrorArgs struct {
+	JobID         string `json:"job-id"`
+	Device        string `json:"device"`
+	Target        string `json:"target"`
+	Sync          string `json:"sync"`
+	OnTargetError string `json:"on-target-error,omitempty"`
+}
+
+// BlockdevBackupArgs are the arguments for blockdev-backup. With Sync
+// "bitmap" only the clusters dirty in Bitmap are copied.
+type BlockdevBackupArgs struct {
+	JobID       string `json:"job-id"`
+	Device      string `json:"device"`
+	Target      string `json:"target"`
+	Sync        string `json:"sync"`
+	Bitmap      string `json:"bitmap,omitempty"`
+	BitmapMode  string `json:"bitmap-mode,omitempty"`
+	AutoDismiss bool   `json:"auto-dismiss"`
+}
+
+// DriveBackupArgs are the arguments for drive-backup, which creates the
+// Target image itself (Mode "absolute-paths").
+type DriveBackupArgs struct {
+	JobID       string `json:"job-id"`
+	Device      string `json:"device"`
+	Target      string `json:"target"`
+	Format      string `json:"format,omitempty"`
+	Sync        string `json:"sync"`
+	Mode        string `json:"mode,omitempty"`
+	AutoDismiss bool   `json:"auto-dismiss"`
+}
+
+// BlockdevAddFileArgs are the arguments for blockdev-add of a format node
+// over a local file, used as a blockdev-backup target.
+type BlockdevAddFileArgs struct {
+	Driver   string           `json:"driver"` // format driver, e.g. "raw"
+	NodeName string           `json:"node-name"`
+	File     BlockdevFileNode `json:"file"`
+}
+
+// BlockdevFileNode is the protocol node under BlockdevAddFileArgs.
+type BlockdevFileNode struct {
+	Driver   string `json:"driver"` // "file"
+	Filename string `json:"filename"`
+}
+
+// BlockJobResumeArgs are the arguments for block-job-resume.
+type BlockJobResumeArgs struct {
+	Device string `json:"device"` // job ID
+}
+
+// BlockJobDismissArgs are the arguments for block-job-dismiss.
+type BlockJobDismissArgs struct {
+	ID string `json:"id"`
+}
+
 // DriveMirrorArgs are the arguments for the drive-mirror command.
 type DriveMirrorArgs struct {
-	Device string `json:"device"`
-	Target string `json:"target"`
-	Sync   string `json:"sync"`
-	Mode   string `json:"mode"`
-	JobID  string `json:"job-id"`
+	Device        string `json:"device"`
+	Target        string `json:"target"`
+	Sync          string `json:"sync"`
+	Mode          string `json:"mode"`
+	JobID         string `json:"job-id"`
+	OnTargetError string `json:"on-target-error,omitempty"`
 }
 
 // BlockJobCancelArgs are the arguments for the block-job-cancel command.
@@ -135,6 +378,13 @@ type MigrateSetParametersArgs struct {
 	DowntimeLimit   int64 `json:"downtime-limit,omitempty"`
 	MaxBandwidth    int64 `json:"max-bandwidth,omitempty"`
 	MultifdChannels int64 `json:"multifd-channels,omitempty"`
+	// MultifdCompression is "none", "zlib" or "zstd"; both sides must
+	// agree. The levels only matter to the sender.
+	MultifdCompression string `json:"multifd-compression,omitempty"`
+	MultifdZlibLevel   int64  `json:"multifd-zlib-level,omitempty"`
+	MultifdZstdLevel   int64  `json:"multifd-zstd-level,omitempty"`
+	// XBZRLECacheSize must be a power of two.
+	XBZRLECacheSize int64 `json:"xbzrle-cache-size,omitempty"`
 }
 
 // MigrateArgs are the arguments for the migrate command.
@@ -150,11 +400,58 @@ type AnnounceSelfArgs struct {
 	Step    int `json:"step"`    // Delay increase per round (ms).
 }
 
+// QOMListArgs are the arguments for the qom-list command.
+type QOMListArgs struct {
+	Path string `json:"path"`
+}
+
+// QOMGetArgs are the arguments for the qom-get command.
+type QOMGetArgs struct {
+	Path     string `json:"path"`
+	Property string `json:"property"`
+}
+
+// QOMSetArgs are the arguments for the qom-set command.
+type QOMSetArgs struct {
+	Path     string `json:"path"`
+	Property string `json:"property"`
+	Value    any    `json:"value"`
+}
+
+// BalloonArgs are the arguments for the balloon command.
+type BalloonArgs struct {
+	Value int64 `json:"value"` // Target guest memory size in bytes.
+}
+
+// RawArgs are pre-encoded arguments passed through verbatim. Reserved for
+// commands whose schema depends on the device or object type being
+// created (device_add, object-add, blockdev-add, netdev_add), as replayed
+// from a captured device inventory.
+type RawArgs json.RawMessage
+
+// MarshalJSON returns the encoded arguments unchanged.
+func (r RawArgs) MarshalJSON() ([]byte, error) { return json.RawMessage(r).MarshalJSON() }
+
 func (NBDServerStartArgs) qmpArgs()         {}
 func (NBDServerAddArgs) qmpArgs()           {}
+func (BlockExportAddArgs) qmpArgs()         {}
+func (BlockdevAddNBDArgs) qmpArgs()         {}
+func (BlockdevDelArgs) qmpArgs()            {}
+func (BlockDirtyBitmapArgs) qmpArgs()       {}
+func (BlockdevMirrorArgs) qmpArgs()         {}
+func (BlockdevBackupArgs) qmpArgs()         {}
+func (DriveBackupArgs) qmpArgs()            {}
+func (BlockdevAddFileArgs) qmpArgs()        {}
+func (BlockJobResumeArgs) qmpArgs()         {}
+func (BlockJobDismissArgs) qmpArgs()        {}
 func (DriveMirrorArgs) qmpArgs()            {}
 func (BlockJobCancelArgs) qmpArgs()         {}
 func (MigrateSetCapabilitiesArgs) qmpArgs() {}
 func (MigrateSetParametersArgs) qmpArgs()   {}
 func (MigrateArgs) qmpArgs()                {}
 func (AnnounceSelfArgs) qmpArgs()           {}
+func (QOMListArgs) qmpArgs()                {}
+func (QOMGetArgs) qmpArgs()                 {}
+func (QOMSetArgs) qmpArgs()                 {}
+func (BalloonArgs) qmpArgs()                {}
+func (RawArgs) qmpArgs()                    {}
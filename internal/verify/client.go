@@ -0,0 +1,254 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tcpWriteTimeout bounds a single frame write so a black-holed peer
+// cannot wedge the TCP sender past the end of the run.
+const tcpWriteTimeout = 5 * time.Second
+
+// Run drives the UDP and TCP probes against cfg.Target until ctx is done
+// or cfg.Duration elapses, keeps listening cfg.Drain longer for
+// outstanding echoes, and returns the evaluated report. Cancelling ctx is
+// the normal way to end an open-ended run, not an abort: the report is
+// still produced. Network failures are recorded in the report; the error
+// is reserved for an invalid config.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	sendCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	r := &Report{
+		Target:       cfg.Target,
+		StartedAt:    time.Now(),
+		IntervalMS:   cfg.Interval.Milliseconds(),
+		AllowReorder: cfg.AllowReorder,
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.UDP = runUDP(sendCtx, cfg)
+	}()
+	go func() {
+		defer wg.Done()
+		r.TCP = runTCP(sendCtx, cfg)
+	}()
+	wg.Wait()
+	r.FinishedAt = time.Now()
+	r.evaluate()
+	return r, nil
+}
+
+func runUDP(ctx context.Context, cfg Config) UDPResult {
+	var res UDPResult
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(cfg.Target, strconv.Itoa(cfg.UDPPort)))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	// An unconnected socket: ICMP unreachables during cutover must not
+	// surface as read errors that would end the receive loop.
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer func() { _ = conn.Close() }()
+
+	received := make(map[uint64]struct{})
+	var stalls stallTracker
+	recvDone := make(chan struct{})
+	go func() {
+		defer close(recvDone)
+		buf := make([]byte, 2*frameSize)
+		var maxSeq uint64
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.Is(err, net.ErrClosed) || (errors.As(err, &netErr) && netErr.Timeout()) {
+					return
+				}
+				continue
+			}
+			now := time.Now()
+			seq, _, ok := decodeFrame(buf[:n])
+			if !ok {
+				continue
+			}
+			if _, dup := received[seq]; dup {
+				res.Duplicates++
+				continue
+			}
+			received[seq] = struct{}{}
+			res.Received++
+			if seq < maxSeq {
+				res.Reordered++
+			} else {
+				maxSeq = seq
+			}
+			stalls.observe(now)
+		}
+	}()
+
+	var sendTimes []time.Time
+	frame := make([]byte, frameSize)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+send:
+	for seq := uint64(1); ; seq++ {
+		now := time.Now()
+		encodeFrame(frame, seq, now)
+		sendTimes = append(sendTimes, now)
+		if _, err := conn.WriteToUDP(frame, raddr); err != nil {
+			res.SendErrors++
+		}
+		select {
+		case <-ctx.Done():
+			break send
+		case <-ticker.C:
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(cfg.Drain))
+	<-recvDone
+
+	res.Sent = int64(len(sendTimes))
+	res.Lost = res.Sent - res.Received
+	res.Gaps = lostGaps(sendTimes, received)
+	res.MaxStall = stalls.max
+	return res
+}
+
+func runTCP(ctx context.Context, cfg Config) TCPResult {
+	var res TCPResult
+	var stalls stallTracker
+	addr := net.JoinHostPort(cfg.Target, strconv.Itoa(cfg.TCPPort))
+	dialer := net.Dialer{Timeout: cfg.DialTimeout}
+	var seq uint64
+	for ctx.Err() == nil {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			res.DialFailures++
+			res.LastDialError = err.Error()
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * cfg.Interval):
+			}
+			continue
+		}
+		res.Connects++
+		if err := tcpSession(ctx, cfg, conn, &seq, &res, &stalls); err != nil && len(res.Disconnects) < maxDisconnects {
+			res.Disconnects = append(res.Disconnects, Disconnect{At: time.Now(), Error: err.Error()})
+		}
+	}
+	res.MaxStall = stalls.max
+	return res
+}
+
+// tcpSession streams frames over one connection until ctx is done (then
+// half-closes and drains) or the connection breaks. A nil return means
+// every frame written was echoed back before the peer closed.
+func tcpSession(ctx context.Context, cfg Config, conn net.Conn, seq *uint64, res *TCPResult, stalls *stallTracker) error {
+	first := *seq + 1
+	var sent atomic.Uint64
+	werrc := make(chan error, 1)
+	go func() { werrc <- writeFrames(ctx, cfg, conn, first, &sent) }()
+
+	var received uint64
+	expect := first
+	frame := make([]byte, frameSize)
+	var rerr error
+	for {
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			rerr = err
+			break
+		}
+		s, _, ok := decodeFrame(frame)
+		if !ok {
+			rerr = errors.New("corrupt echo frame")
+			break
+		}
+		stalls.observe(time.Now())
+		received++
+		if s != expect {
+			res.OutOfOrder++
+		}
+		expect = s + 1
+	}
+	// Unblocks the writer if the read side broke first.
+	_ = conn.Close()
+	werr := <-werrc
+
+	n := sent.Load()
+	*seq = first + n - 1
+	res.Sent += int64(n)
+	res.Received += int64(received)
+	if n > received {
+		res.Unanswered += int64(n - received)
+	}
+	switch {
+	case werr != nil:
+		return fmt.Errorf("write: %w", werr)
+	case errors.Is(rerr, io.EOF) && ctx.Err() != nil && received == n:
+		return nil
+	case errors.Is(rerr, io.EOF):
+		return errors.New("connection closed by peer")
+	default:
+		var netErr net.Error
+		if errors.As(rerr, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("drain timed out with %d frames unanswered", n-received)
+		}
+		return fmt.Errorf("read: %w", rerr)
+	}
+}
+
+// writeFrames sends one frame per interval starting at sequence number
+// first. On ctx done it half-closes the connection and arms the drain
+// deadline so the reader ends once the echo stream catches up.
+func writeFrames(ctx context.Context, cfg Config, conn net.Conn, first uint64, sent *atomic.Uint64) error {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	frame := make([]byte, frameSize)
+	for s := first; ; s++ {
+		encodeFrame(frame, s, time.Now())
+		_ = conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// The reader closed the connection after it broke;
+				// it reports the cause.
+				return nil
+			}
+			_ = conn.Close()
+			return err
+		}
+		sent.Add(1)
+		select {
+		case <-ctx.Done():
+			if tc, ok := conn.(*net.TCPConn); ok {
+				_ = tc.CloseWrite()
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(cfg.Drain))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// Server echoes probe frames back to their sender: UDP datagrams carrying
+// a valid frame are returned as-is (anything else is dropped, so the
+// server cannot be used as a reflector), and every TCP connection is a
+// byte-for-byte echo until the client half-closes it.
+type Server struct {
+	udp *net.UDPConn
+	tcp net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// Listen binds the UDP and TCP echo sockets. Addresses use the
+// net.Listen "host:port" form; ":0" picks a free port.
+func Listen(udpAddr, tcpAddr string) (*Server, error) {
+	ua, err := net.ResolveUDPAddr("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp listen address %q: %w", udpAddr, err)
+	}
+	udp, err := net.ListenUDP("udp", ua)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp %s: %w", udpAddr, err)
+	}
+	tcp, err := net.Listen("tcp", tcpAddr)
+	if err != nil {
+		_ = udp.Close()
+		return nil, fmt.Errorf("listen tcp %s: %w", tcpAddr, err)
+	}
+	return &Server{udp: udp, tcp: tcp, conns: map[net.Conn]struct{}{}}, nil
+}
+
+// UDPAddr returns the bound UDP address.
+func (s *Server) UDPAddr() net.Addr { return s.udp.LocalAddr() }
+
+// TCPAddr returns the bound TCP address.
+func (s *Server) TCPAddr() net.Addr { return s.tcp.Addr() }
+
+// Serve echoes until ctx is done, then closes both sockets and every open
+// connection. Returns nil on a ctx-initiated shutdown.
+func (s *Server) Serve(ctx context.Context) error {
+	errc := make(chan error, 2)
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		errc <- s.serveUDP()
+	}()
+	go func() {
+		defer s.wg.Done()
+		errc <- s.serveTCP()
+	}()
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+	s.close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) close() {
+	_ = s.udp.Close()
+	_ = s.tcp.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) serveUDP() error {
+	buf := make([]byte, 2*frameSize)
+	for {
+		n, addr, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("udp read: %w", err)
+		}
+		if _, _, ok := decodeFrame(buf[:n]); !ok {
+			continue
+		}
+		if _, err := s.udp.WriteToUDP(buf[:n], addr); err != nil {
+			slog.Debug("Verify server UDP echo failed", "peer", addr, "error", err)
+		}
+	}
+}
+
+func (s *Server) serveTCP() error {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("tcp accept: %w", err)
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.echoTCP(conn)
+	}
+}
+
+func (s *Server) echoTCP(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("Verify server TCP echo panic", "panic", rec, "stack", string(debug.Stack()))
+		}
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	if _, err := io.Copy(conn, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Debug("Verify server TCP echo ended", "peer", conn.RemoteAddr(), "error", err)
+	}
+}
@@ -0,0 +1,396 @@
+// Package verify implements the synthetic workload katamaran uses to check
+// its zero-drop claim end to end.
+//
+// A Server runs next to the migrating workload (a sidecar in the kata pod)
+// and echoes sequence-numbered frames back to their sender over UDP and
+// TCP. Run drives both streams from outside the VM for the duration of a
+// migration and produces a Report: lost, duplicated, and reordered UDP
+// datagrams with the time span of every gap, TCP connection resets and
+// out-of-order frames, and the longest echo stall on each stream. Once the
+// migration's STOP/RESUME timestamps are known, Report.Correlate marks
+// which gaps, stalls, and resets fell inside the cutover window.
+//
+// A run passes when no datagram was lost or duplicated, no TCP connection
+// was reset, and nothing arrived out of order (UDP reordering can be
+// tolerated via Config.AllowReorder). Stalls never fail a run on their
+// own: sch_plug buffering during cutover is expected to delay echoes by
+// roughly the VM downtime without dropping any.
+package verify
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultUDPPort and DefaultTCPPort are the ports Server listens on
+	// and Run targets when the config leaves them zero.
+	DefaultUDPPort = 7410
+	DefaultTCPPort = 7411
+
+	// DefaultInterval is the per-stream send interval: 100 frames/s on
+	// each of UDP and TCP, enough to resolve a 25ms downtime window
+	// while staying far below any bandwidth a migration would notice.
+	DefaultInterval = 10 * time.Millisecond
+
+	// DefaultDrain is how long Run keeps listening for echoes after the
+	// send phase ends. Must exceed the worst-case echo latency, which is
+	// bounded by the VM downtime plus the network RTT.
+	DefaultDrain = 2 * time.Second
+
+	// DefaultDialTimeout bounds each TCP (re)connect attempt.
+	DefaultDialTimeout = 5 * time.Second
+
+	// DefaultSlack widens the cutover window on both sides in
+	// Correlate to absorb clock skew between the verifier and the
+	// nodes that reported STOP/RESUME.
+	DefaultSlack = 500 * time.Millisecond
+
+	// maxGaps caps the per-gap detail kept in a report. Lost still
+	// counts every missing datagram past the cap.
+	maxGaps = 100
+
+	// maxDisconnects caps the per-reset detail kept in a report.
+	maxDisconnects = 100
+)
+
+// frameSize is the fixed wire size of a probe frame:
+//
+//	magic (4) | reserved (4) | seq (8) | sent unix nanos (8) | padding (8)
+const frameSize = 32
+
+// frameMagic ("KMV1") tags probe frames so the server never echoes
+// arbitrary UDP payloads and the client ignores stray datagrams.
+const frameMagic uint32 = 0x4b4d5631
+
+func encodeFrame(b []byte, seq uint64, sent time.Time) {
+	clear(b[:frameSize])
+	binary.BigEndian.PutUint32(b[0:4], frameMagic)
+	binary.BigEndian.PutUint64(b[8:16], seq)
+	binary.BigEndian.PutUint64(b[16:24], uint64(sent.UnixNano()))
+}
+
+func decodeFrame(b []byte) (seq uint64, sent time.Time, ok bool) {
+	if len(b) != frameSize || binary.BigEndian.Uint32(b[0:4]) != frameMagic {
+		return 0, time.Time{}, false
+	}
+	seq = binary.BigEndian.Uint64(b[8:16])
+	sent = time.Unix(0, int64(binary.BigEndian.Uint64(b[16:24])))
+	return seq, sent, true
+}
+
+// Config describes one verifier run.
+type Config struct {
+	// Target is the IP or hostname of the workload running Server.
+	Target string
+
+	// UDPPort and TCPPort default to DefaultUDPPort / DefaultTCPPort.
+	UDPPort int
+	TCPPort int
+
+	// Duration bounds the send phase. Zero sends until the context
+	// passed to Run is done.
+	Duration time.Duration
+
+	// Interval, Drain, and DialTimeout default to their Default*
+	// constants when zero.
+	Interval    time.Duration
+	Drain       time.Duration
+	DialTimeout time.Duration
+
+	// AllowReorder stops reordered UDP datagrams from failing the run.
+	// They are still counted.
+	AllowReorder bool
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.Target == "" {
+		return c, errors.New("target is required")
+	}
+	if c.UDPPort == 0 {
+		c.UDPPort = DefaultUDPPort
+	}
+	if c.TCPPort == 0 {
+		c.TCPPort = DefaultTCPPort
+	}
+	for _, p := range []int{c.UDPPort, c.TCPPort} {
+		if p < 1 || p > 65535 {
+			return c, fmt.Errorf("port %d out of range (1-65535)", p)
+		}
+	}
+	if c.Duration < 0 {
+		return c, fmt.Errorf("duration must not be negative (got %s)", c.Duration)
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Interval < time.Millisecond {
+		return c, fmt.Errorf("interval must be at least 1ms (got %s)", c.Interval)
+	}
+	if c.Drain == 0 {
+		c.Drain = DefaultDrain
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = DefaultDialTimeout
+	}
+	if c.Drain < 0 || c.DialTimeout < 0 {
+		return c, errors.New("drain and dial timeout must not be negative")
+	}
+	return c, nil
+}
+
+// Report is the outcome of one verifier run.
+type Report struct {
+	Target       string    `json:"target"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	IntervalMS   int64     `json:"interval_ms"`
+	AllowReorder bool      `json:"allow_reorder,omitempty"`
+	UDP          UDPResult `json:"udp"`
+	TCP          TCPResult `json:"tcp"`
+
+	// Cutover is set by Correlate.
+	Cutover *Cutover `json:"cutover,omitempty"`
+
+	Pass     bool     `json:"pass"`
+	Failures []string `json:"failures,omitempty"`
+	Notes    []string `json:"notes,omitempty"`
+}
+
+// UDPResult summarises the UDP echo stream.
+type UDPResult struct {
+	Sent       int64 `json:"sent"`
+	Received   int64 `json:"received"`
+	Lost       int64 `json:"lost"`
+	Duplicates int64 `json:"duplicates"`
+	Reordered  int64 `json:"reordered"`
+	SendErrors int64 `json:"send_errors,omitempty"`
+
+	// Gaps lists runs of consecutive lost sequence numbers, oldest
+	// first, capped at maxGaps entries.
+	Gaps     []Gap  `json:"gaps,omitempty"`
+	MaxStall Stall  `json:"max_stall"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TCPResult summarises the TCP echo stream.
+type TCPResult struct {
+	Sent         int64 `json:"sent"`
+	Received     int64 `json:"received"`
+	OutOfOrder   int64 `json:"out_of_order"`
+	Connects     int64 `json:"connects"`
+	DialFailures int64 `json:"dial_failures,omitempty"`
+
+	// Unanswered counts frames written on connections that broke before
+	// echoing them back.
+	Unanswered    int64        `json:"unanswered,omitempty"`
+	Disconnects   []Disconnect `json:"disconnects,omitempty"`
+	MaxStall      Stall        `json:"max_stall"`
+	LastDialError string       `json:"last_dial_error,omitempty"`
+}
+
+// Gap is a run of consecutive lost UDP datagrams. From and To are the
+// send times of the first and last missing sequence number.
+type Gap struct {
+	FirstSeq      uint64    `json:"first_seq"`
+	LastSeq       uint64    `json:"last_seq"`
+	Count         int64     `json:"count"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	DuringCutover bool      `json:"during_cutover,omitempty"`
+}
+
+// Stall is the longest interval between two consecutive echo arrivals.
+type Stall struct {
+	From          time.Time `json:"from,omitzero"`
+	To            time.Time `json:"to,omitzero"`
+	DurationMS    int64     `json:"duration_ms"`
+	DuringCutover bool      `json:"during_cutover,omitempty"`
+}
+
+// Disconnect is a TCP connection that ended before the run did: a reset,
+// an unexpected close by the peer, or a write failure.
+type Disconnect struct {
+	At            time.Time `json:"at"`
+	Error         string    `json:"error"`
+	DuringCutover bool      `json:"during_cutover,omitempty"`
+}
+
+// Cutover records the migration's blackout window used by Correlate.
+type Cutover struct {
+	StoppedAt time.Time `json:"stopped_at"`
+	ResumedAt time.Time `json:"resumed_at,omitzero"`
+	WindowMS  int64     `json:"window_ms"`
+	SlackMS   int64     `json:"slack_ms"`
+}
+
+// stallTracker records the longest interval between observed arrivals.
+type stallTracker struct {
+	last time.Time
+	max  Stall
+}
+
+func (s *stallTracker) observe(t time.Time) {
+	if !s.last.IsZero() {
+		if d := t.Sub(s.last); d.Milliseconds() > s.max.DurationMS {
+			s.max = Stall{From: s.last, To: t, DurationMS: d.Milliseconds()}
+		}
+	}
+	s.last = t
+}
+
+// lostGaps groups the sequence numbers missing from received into runs.
+// sendTimes[i] is the send time of sequence number i+1.
+func lostGaps(sendTimes []time.Time, received map[uint64]struct{}) []Gap {
+	var gaps []Gap
+	for i := 0; i < len(sendTimes); i++ {
+		if _, ok := received[uint64(i+1)]; ok {
+			continue
+		}
+		j := i
+		for j+1 < len(sendTimes) {
+			if _, ok := received[uint64(j+2)]; ok {
+				break
+			}
+			j++
+		}
+		if len(gaps) < maxGaps {
+			gaps = append(gaps, Gap{
+				FirstSeq: uint64(i + 1),
+				LastSeq:  uint64(j + 1),
+				Count:    int64(j - i + 1),
+				From:     sendTimes[i],
+				To:       sendTimes[j],
+			})
+		}
+		i = j
+	}
+	return gaps
+}
+
+// Correlate records the migration's cutover window on the report and
+// flags every gap, stall, and disconnect that overlaps it. stoppedAt is
+// when QEMU paused the source VM and resumedAt when the destination VM
+// resumed; resumedAt may be zero when only the STOP time is known. The
+// window is widened by slack on both sides to absorb clock skew between
+// the verifier and the reporting nodes. Pass/fail is re-evaluated but
+// does not change: correlation explains failures, it never excuses them.
+func (r *Report) Correlate(stoppedAt, resumedAt time.Time, slack time.Duration) {
+	if stoppedAt.IsZero() {
+		r.Notes = append(r.Notes, "cutover timestamps unavailable; gaps not correlated with STOP/RESUME")
+		return
+	}
+	end := resumedAt
+	if end.IsZero() || end.Before(stoppedAt) {
+		end = stoppedAt
+		r.Notes = append(r.Notes, "resume timestamp unavailable; cutover window is the STOP time ± slack")
+	}
+	from, to := stoppedAt.Add(-slack), end.Add(slack)
+	overlaps := func(a, b time.Time) bool { return !b.Before(from) && !a.After(to) }
+	for i := range r.UDP.Gaps {
+		g := &r.UDP.Gaps[i]
+		g.DuringCutover = overlaps(g.From, g.To)
+	}
+	for _, s := range []*Stall{&r.UDP.MaxStall, &r.TCP.MaxStall} {
+		s.DuringCutover = s.DurationMS > 0 && overlaps(s.From, s.To)
+	}
+	for i := range r.TCP.Disconnects {
+		d := &r.TCP.Disconnects[i]
+		d.DuringCutover = overlaps(d.At, d.At)
+	}
+	r.Cutover = &Cutover{
+		StoppedAt: stoppedAt,
+		ResumedAt: resumedAt,
+		WindowMS:  end.Sub(stoppedAt).Milliseconds(),
+		SlackMS:   slack.Milliseconds(),
+	}
+	r.Notes = append(r.Notes, fmt.Sprintf("cutover bounds come from node clocks; skew beyond ±%s misattributes gaps", slack))
+	for _, s := range []struct {
+		name  string
+		stall Stall
+	}{{"udp", r.UDP.MaxStall}, {"tcp", r.TCP.MaxStall}} {
+		if s.stall.DurationMS == 0 {
+			continue
+		}
+		where := "outside the cutover window"
+		if s.stall.DuringCutover {
+			where = "during cutover"
+		}
+		r.Notes = append(r.Notes, fmt.Sprintf("%s: longest echo stall %dms %s", s.name, s.stall.DurationMS, where))
+	}
+	r.evaluate()
+}
+
+// evaluate recomputes Pass and Failures from the collected counters.
+func (r *Report) evaluate() {
+	var f []string
+	u := r.UDP
+	switch {
+	case u.Error != "":
+		f = append(f, "udp: "+u.Error)
+	case u.Sent > 0 && u.Received == 0:
+		f = append(f, "udp: no echoes received; is katamaran-verify serve running on the target?")
+	default:
+		if u.Lost > 0 {
+			f = append(f, fmt.Sprintf("udp: %d of %d datagrams lost in %s%s", u.Lost, u.Sent, plural(len(u.Gaps), "gap"), r.cutoverSplit(len(u.Gaps), func(i int) bool { return u.Gaps[i].DuringCutover })))
+		}
+		if u.Duplicates > 0 {
+			f = append(f, fmt.Sprintf("udp: %d duplicate datagrams", u.Duplicates))
+		}
+		if u.Reordered > 0 && !r.AllowReorder {
+			f = append(f, fmt.Sprintf("udp: %d datagrams reordered", u.Reordered))
+		}
+	}
+	t := r.TCP
+	if t.Connects == 0 {
+		msg := "tcp: never connected"
+		if t.LastDialError != "" {
+			msg += ": " + t.LastDialError
+		}
+		f = append(f, msg)
+	} else {
+		if n := len(t.Disconnects); n > 0 {
+			f = append(f, fmt.Sprintf("tcp: %s, %d frames unanswered%s", plural(n, "connection reset"), t.Unanswered, r.cutoverSplit(n, func(i int) bool { return t.Disconnects[i].DuringCutover })))
+		}
+		if t.OutOfOrder > 0 {
+			f = append(f, fmt.Sprintf("tcp: %d frames out of order", t.OutOfOrder))
+		}
+	}
+	r.Failures = f
+	r.Pass = len(f) == 0
+}
+
+// cutoverSplit renders " (k during cutover)" for n correlated items, or
+// "" before Correlate ran.
+func (r *Report) cutoverSplit(n int, during func(int) bool) string {
+	if r.Cutover == nil || n == 0 {
+		return ""
+	}
+	k := 0
+	for i := range n {
+		if during(i) {
+			k++
+		}
+	}
+	return fmt.Sprintf(" (%d during cutover)", k)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Summary renders a one-line verdict for logs, e.g.
+// "PASS: udp 0/6000 lost, max stall 41ms; tcp 0 resets, max stall 44ms".
+func (r *Report) Summary() string {
+	verdict := "PASS"
+	if !r.Pass {
+		verdict = "FAIL"
+	}
+	return fmt.Sprintf("%s: udp %d/%d lost, max stall %dms; tcp %d resets, max stall %dms",
+		verdict, r.UDP.Lost, r.UDP.Sent, r.UDP.MaxStall.DurationMS, len(r.TCP.Disconnects), r.TCP.MaxStall.DurationMS)
+}
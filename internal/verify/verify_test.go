@@ -0,0 +1,303 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer runs a Server on loopback and returns a Config aimed at it.
+func startServer(t *testing.T) Config {
+	t.Helper()
+	srv, err := Listen("127.0.0.1:0", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return Config{
+		Target:   "127.0.0.1",
+		UDPPort:  srv.UDPAddr().(*net.UDPAddr).Port,
+		TCPPort:  srv.TCPAddr().(*net.TCPAddr).Port,
+		Duration: 300 * time.Millisecond,
+		Interval: 5 * time.Millisecond,
+		Drain:    300 * time.Millisecond,
+	}
+}
+
+func TestRun_CleanPathPasses(t *testing.T) {
+	cfg := startServer(t)
+	r, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !r.Pass {
+		t.Fatalf("Pass = false, failures %v", r.Failures)
+	}
+	if r.UDP.Sent == 0 || r.UDP.Received != r.UDP.Sent || r.UDP.Lost != 0 {
+		t.Errorf("UDP = %+v, want every datagram echoed", r.UDP)
+	}
+	if r.TCP.Connects != 1 || r.TCP.Sent == 0 || r.TCP.Received != r.TCP.Sent || len(r.TCP.Disconnects) != 0 {
+		t.Errorf("TCP = %+v, want one clean connection", r.TCP)
+	}
+	if !strings.HasPrefix(r.Summary(), "PASS:") {
+		t.Errorf("Summary() = %q, want PASS prefix", r.Summary())
+	}
+}
+
+func TestRun_ContextCancelEndsOpenEndedRun(t *testing.T) {
+	cfg := startServer(t)
+	cfg.Duration = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r, err := Run(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !r.Pass || r.UDP.Sent == 0 {
+		t.Fatalf("report = %+v, want a passing run ended by ctx", r)
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"no target":     {},
+		"bad port":      {Target: "127.0.0.1", UDPPort: 70000},
+		"tiny interval": {Target: "127.0.0.1", Interval: time.Microsecond},
+	} {
+		if _, err := Run(context.Background(), cfg); err == nil {
+			t.Errorf("%s: Run succeeded, want config error", name)
+		}
+	}
+}
+
+func TestRun_NoServerFails(t *testing.T) {
+	// Grab free ports and release them so nothing answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		Target:   "127.0.0.1",
+		UDPPort:  pc.LocalAddr().(*net.UDPAddr).Port,
+		TCPPort:  ln.Addr().(*net.TCPAddr).Port,
+		Duration: 100 * time.Millisecond,
+		Interval: 5 * time.Millisecond,
+		Drain:    50 * time.Millisecond,
+	}
+	_ = pc.Close()
+	_ = ln.Close()
+	r, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if r.Pass {
+		t.Fatal("Pass = true with no server")
+	}
+	joined := strings.Join(r.Failures, "\n")
+	for _, want := range []string{"udp: no echoes", "tcp: never connected"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("failures %q missing %q", joined, want)
+		}
+	}
+}
+
+// lossyUDPEcho echoes probe frames except those drop reports true for.
+func lossyUDPEcho(t *testing.T, drop func(seq uint64) bool) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			seq, _, ok := decodeFrame(buf[:n])
+			if !ok || drop(seq) {
+				continue
+			}
+			_, _ = conn.WriteToUDP(buf[:n], addr)
+			if seq == 3 {
+				// Duplicate one echo.
+				_, _ = conn.WriteToUDP(buf[:n], addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestRun_UDPLossAndDuplicatesFail(t *testing.T) {
+	cfg := startServer(t)
+	cfg.UDPPort = lossyUDPEcho(t, func(seq uint64) bool { return seq >= 10 && seq <= 14 })
+	r, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if r.Pass {
+		t.Fatal("Pass = true despite dropped datagrams")
+	}
+	if r.UDP.Lost != 5 || r.UDP.Duplicates != 1 {
+		t.Errorf("Lost = %d, Duplicates = %d, want 5 and 1", r.UDP.Lost, r.UDP.Duplicates)
+	}
+	if len(r.UDP.Gaps) != 1 || r.UDP.Gaps[0].FirstSeq != 10 || r.UDP.Gaps[0].LastSeq != 14 || r.UDP.Gaps[0].Count != 5 {
+		t.Fatalf("Gaps = %+v, want one gap 10-14", r.UDP.Gaps)
+	}
+
+	// A cutover window covering the gap marks it; one that misses it
+	// does not, and neither excuses the loss.
+	g := r.UDP.Gaps[0]
+	r.Correlate(g.From.Add(-time.Millisecond), g.To.Add(time.Millisecond), 0)
+	if !r.UDP.Gaps[0].DuringCutover || r.Pass || r.Cutover == nil {
+		t.Errorf("after covering Correlate: gap %+v, pass %v", r.UDP.Gaps[0], r.Pass)
+	}
+	if !strings.Contains(strings.Join(r.Failures, "\n"), "(1 during cutover)") {
+		t.Errorf("failures %v missing cutover attribution", r.Failures)
+	}
+	r.Correlate(g.To.Add(time.Hour), g.To.Add(2*time.Hour), 0)
+	if r.UDP.Gaps[0].DuringCutover {
+		t.Error("gap marked during cutover for a disjoint window")
+	}
+}
+
+func TestRun_TCPResetDetectedAndReconnects(t *testing.T) {
+	cfg := startServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if first {
+				// Echo a few frames, then reset the connection.
+				buf := make([]byte, 3*frameSize)
+				_, _ = io.ReadFull(conn, buf)
+				_, _ = conn.Write(buf)
+				_ = conn.(*net.TCPConn).SetLinger(0)
+				_ = conn.Close()
+				continue
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	cfg.TCPPort = ln.Addr().(*net.TCPAddr).Port
+	r, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if r.Pass {
+		t.Fatal("Pass = true despite a TCP reset")
+	}
+	if r.TCP.Connects != 2 || len(r.TCP.Disconnects) != 1 {
+		t.Fatalf("Connects = %d, Disconnects = %+v, want reconnect after one reset", r.TCP.Connects, r.TCP.Disconnects)
+	}
+	if r.TCP.OutOfOrder != 0 {
+		t.Errorf("OutOfOrder = %d, want 0 (sequence continues across reconnects)", r.TCP.OutOfOrder)
+	}
+	if !strings.Contains(strings.Join(r.Failures, "\n"), "tcp: 1 connection reset") {
+		t.Errorf("failures %v missing reset", r.Failures)
+	}
+}
+
+func TestLostGaps(t *testing.T) {
+	base := time.Unix(1000, 0)
+	sendTimes := make([]time.Time, 10)
+	for i := range sendTimes {
+		sendTimes[i] = base.Add(time.Duration(i) * time.Second)
+	}
+	received := map[uint64]struct{}{}
+	for _, s := range []uint64{1, 2, 5, 6, 7, 9} {
+		received[s] = struct{}{}
+	}
+	got := lostGaps(sendTimes, received)
+	want := []Gap{
+		{FirstSeq: 3, LastSeq: 4, Count: 2, From: sendTimes[2], To: sendTimes[3]},
+		{FirstSeq: 8, LastSeq: 8, Count: 1, From: sendTimes[7], To: sendTimes[7]},
+		{FirstSeq: 10, LastSeq: 10, Count: 1, From: sendTimes[9], To: sendTimes[9]},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("lostGaps = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("gap %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCorrelate_StallAndNotes(t *testing.T) {
+	stop := time.Unix(2000, 0)
+	r := &Report{
+		UDP: UDPResult{Sent: 10, Received: 10, MaxStall: Stall{From: stop.Add(-5 * time.Millisecond), To: stop.Add(40 * time.Millisecond), DurationMS: 45}},
+		TCP: TCPResult{Connects: 1, MaxStall: Stall{From: stop.Add(time.Minute), To: stop.Add(time.Minute + time.Second), DurationMS: 1000}},
+	}
+	r.Correlate(stop, stop.Add(30*time.Millisecond), DefaultSlack)
+	if !r.Pass {
+		t.Fatalf("Pass = false, failures %v", r.Failures)
+	}
+	if !r.UDP.MaxStall.DuringCutover || r.TCP.MaxStall.DuringCutover {
+		t.Errorf("stalls: udp during=%v tcp during=%v, want true/false", r.UDP.MaxStall.DuringCutover, r.TCP.MaxStall.DuringCutover)
+	}
+	if r.Cutover.WindowMS != 30 || r.Cutover.SlackMS != DefaultSlack.Milliseconds() {
+		t.Errorf("Cutover = %+v", r.Cutover)
+	}
+	notes := strings.Join(r.Notes, "\n")
+	for _, want := range []string{"udp: longest echo stall 45ms during cutover", "tcp: longest echo stall 1000ms outside", "skew"} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("notes %q missing %q", notes, want)
+		}
+	}
+
+	var empty Report
+	empty.Correlate(time.Time{}, time.Time{}, DefaultSlack)
+	if empty.Cutover != nil || len(empty.Notes) != 1 {
+		t.Errorf("Correlate without timestamps: cutover %+v, notes %v", empty.Cutover, empty.Notes)
+	}
+}
+
+func TestServer_IgnoresNonFrameDatagrams(t *testing.T) {
+	cfg := startServer(t)
+	conn, err := net.Dial("udp", net.JoinHostPort(cfg.Target, strconv.Itoa(cfg.UDPPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("not a probe frame")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf := make([]byte, 64)
+	_, err = conn.Read(buf)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Read = %v, want timeout (no echo)", err)
+	}
+}
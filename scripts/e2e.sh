@@ -11,12 +11,18 @@
 #                    migration including storage mirroring. 'nfs' deploys an NFS server
 #                    pod and uses it as shared storage.
 # --kata-version <v> Kata Containers chart version (default: '3.24.0').
-# --method <name>    Orchestration method: 'job' (default) or 'crd'. 'direct' is
-#                    accepted for compatibility but exits as not implemented.
+# --method <name>    Orchestration method: 'job' (default), 'crd', or 'direct'.
+#                    'direct' copies the katamaran binary to the nodes and runs
+#                    katamaran-orchestrator --backend direct from this host,
+#                    reaching the nodes over SSH (minikube) or '<engine> exec'
+#                    (kind) instead of through Kubernetes Jobs.
 # --tcg              Experimental: use QEMU TCG (software emulation) instead of
 #                    KVM. Enables running on macOS Apple Silicon without nested
 #                    virtualisation. Implies --provider kind. Use --method job.
 # --ping-proof       Verify zero-drop sch_plug buffering from migration logs.
+# --verify           Stream sequence-numbered UDP/TCP traffic at the source pod
+#                    through the migration (katamaran-verify) and fail on any
+#                    lost, duplicated, or reordered packet or TCP reset.
 # --env-only         Provision the cluster and install Kata, then stop (no migration).
 # --teardown         Tear down the cluster and exit.
 # --help, -h         Show this help text.
@@ -38,6 +44,7 @@ METHOD="job"
 SUDO="sudo"
 TCG=false
 PING_PROOF=false
+VERIFY=false
 ENV_ONLY=false
 TEARDOWN=false
 
@@ -51,6 +58,7 @@ while [[ $# -gt 0 ]]; do
         --method) need_arg "$1" "${2:-}"; METHOD="$2"; shift 2 ;;
         --tcg) TCG=true; shift ;;
         --ping-proof) PING_PROOF=true; shift ;;
+        --verify) VERIFY=true; shift ;;
         --env-only) ENV_ONLY=true; shift ;;
         --help|-h) awk 'NR==1{next} /^#/{sub(/^# ?/,""); print; next} {exit}' "$0"; exit 0 ;;
         *) error "Unknown option: $1"; exit 2 ;;
@@ -79,9 +87,9 @@ if [[ "${METHOD}" != "job" && "${METHOD}" != "crd" && "${METHOD}" != "direct" ]]
     exit 2
 fi
 
-if [[ "${METHOD}" == "direct" ]]; then
-    error "--method direct is accepted for compatibility but is not implemented."
-    exit 1
+if [[ "${METHOD}" == "direct" && "${PING_PROOF}" == "true" ]]; then
+    error "--ping-proof reads the destination Job log; use it with --method job."
+    exit 2
 fi
 
 case "${CNI}" in
@@ -150,6 +158,10 @@ cleanup() {
         kill "${PING_PID}" 2>/dev/null || true
         wait "${PING_PID}" 2>/dev/null || true
     fi
+    if [[ -n "${VERIFY_EXEC_PID:-}" ]] && kill -0 "${VERIFY_EXEC_PID}" 2>/dev/null; then
+        kill "${VERIFY_EXEC_PID}" 2>/dev/null || true
+        wait "${VERIFY_EXEC_PID}" 2>/dev/null || true
+    fi
     if [[ "${ENV_ONLY}" == "true" ]]; then
         log "--env-only set, keeping cluster '${PROFILE}'"
         return
@@ -485,6 +497,15 @@ fi
 
 log "Deploying source pod on Node 1..."
 kubectl --context "${CTX}" delete pod kata-src --ignore-not-found --force --grace-period=0
+# --verify: the echo server runs inside the migrating VM as a sidecar.
+VERIFY_SIDECAR=""
+if [[ "${VERIFY}" == "true" ]]; then
+    VERIFY_SIDECAR="
+  - name: verify
+    image: localhost/katamaran:dev
+    imagePullPolicy: IfNotPresent
+    command: [\"katamaran-verify\", \"serve\"]"
+fi
 cat <<EOFPOD | kubectl --context "${CTX}" apply -f -
 apiVersion: v1
 kind: Pod
@@ -498,7 +519,7 @@ spec:
     katamaran-role: source
   containers:
   - name: pause
-    image: registry.k8s.io/pause:3.9
+    image: registry.k8s.io/pause:3.9${VERIFY_SIDECAR}
 EOFPOD
 SRC_POD_TIMEOUT=300s
 if [[ "${TCG}" == "true" ]]; then SRC_POD_TIMEOUT=600s; fi
@@ -767,6 +788,32 @@ if [[ "${ENV_ONLY}" == "true" ]]; then
     exit 0
 fi
 
+VERIFY_EXEC_PID=""
+if [[ "${VERIFY}" == "true" ]]; then
+    # The prober runs in a plain (non-kata) pod so the migration never
+    # disturbs it. It streams until sent SIGTERM after the migration.
+    log "Starting katamaran-verify prober against ${SRC_POD_IP}..."
+    kubectl --context "${CTX}" delete pod verify-client --ignore-not-found --force --grace-period=0 2>/dev/null || true
+    cat <<EOFPOD | kubectl --context "${CTX}" apply -f -
+apiVersion: v1
+kind: Pod
+metadata:
+  name: verify-client
+spec:
+  containers:
+  - name: verify
+    image: localhost/katamaran:dev
+    imagePullPolicy: IfNotPresent
+    command: ["sleep", "infinity"]
+EOFPOD
+    kubectl --context "${CTX}" wait --for=condition=Ready pod/verify-client --timeout=120s
+    kubectl --context "${CTX}" exec verify-client -- sh -c \
+        "katamaran-verify run --target ${SRC_POD_IP} --json > /tmp/verify.json & echo \$! > /tmp/verify.pid; wait" &
+    VERIFY_EXEC_PID=$!
+    # Pre-migration baseline: a failing path shows up before the cutover.
+    sleep 3
+fi
+
 if [[ "${METHOD}" == "job" ]]; then
     # Both 'none' and 'nfs' skip NBD drive-mirror; only 'local' uses it.
     STORAGE_FLAGS=""
@@ -848,9 +895,54 @@ EOF
             exit 1
         fi
     } 2>&1 | tee "${MIG_LOG}"
+    VERIFY_STOPPED_AT=$(kubectl --context "${CTX}" get migration "${MIG_NAME}" -o jsonpath='{.status.vmStoppedAt}' 2>/dev/null || true)
+    VERIFY_RESUMED_AT=$(kubectl --context "${CTX}" get migration "${MIG_NAME}" -o jsonpath='{.status.vmResumedAt}' 2>/dev/null || true)
     kubectl --context "${CTX}" delete migration "${MIG_NAME}" --ignore-not-found >/dev/null || true
+elif [[ "${METHOD}" == "direct" ]]; then
+    # Direct path: no Jobs and no apiserver in the loop. The orchestrator
+    # runs here and starts katamaran on each node through an inventory.
+    STORAGE_BOOL="true"
+    [[ "${STORAGE}" == "local" ]] && STORAGE_BOOL="false"
+    case "${NODE_ARCH}" in
+        aarch64|arm64) GOARCH_NODE="arm64" ;;
+        *) GOARCH_NODE="amd64" ;;
+    esac
+    log "Building katamaran (linux/${GOARCH_NODE}) and katamaran-orchestrator..."
+    (cd "${PROJECT_ROOT}" && CGO_ENABLED=0 GOOS=linux GOARCH="${GOARCH_NODE}" \
+        go build -trimpath -o bin/katamaran-node ./cmd/katamaran/ && make build-orchestrator) >/dev/null
+    INVENTORY=$(mktemp)
+    HOSTS_JSON=""
+    for node in "${NODE1}" "${NODE2}"; do
+        node_cp_to "${node}" "${PROJECT_ROOT}/bin/katamaran-node" /tmp/katamaran
+        node_exec "${node}" "${SUDO} chmod 755 /tmp/katamaran; ${SUDO} modprobe ipip 2>/dev/null || true"
+        if [[ "${PROVIDER}" == "minikube" ]]; then
+            transport="\"ssh\": {\"address\": \"$(minikube -p "${PROFILE}" ip -n "${node}")\", \"user\": \"docker\", \"sudo\": true, \"identityFile\": \"$(minikube -p "${PROFILE}" ssh-key -n "${node}")\", \"options\": [\"StrictHostKeyChecking=no\", \"UserKnownHostsFile=/dev/null\"], \"katamaran\": \"/tmp/katamaran\"}"
+        else
+            transport="\"ssh\": {\"command\": [\"${CE}\", \"exec\", \"-i\", \"${node}\", \"sh\", \"-c\"], \"katamaran\": \"/tmp/katamaran\"}"
+        fi
+        HOSTS_JSON+="${HOSTS_JSON:+, }{\"name\": \"${node}\", ${transport}}"
+    done
+    echo "{\"hosts\": [${HOSTS_JSON}]}" > "${INVENTORY}"
+    log "Executing Live Migration (direct mode, storage=${STORAGE})..."
+    MIG_LOG=$(mktemp)
+    MIG_REQUEST="{\"SourceNode\": \"${NODE1}\", \"DestNode\": \"${NODE2}\",
+        \"SourceQMP\": \"${SRC_SOCK}\", \"DestQMP\": \"${DST_SOCK}\",
+        \"VMIP\": \"${SRC_POD_IP}\", \"DestIP\": \"${DST_POD_IP}\",
+        \"TapIface\": \"${DST_TAP}\", \"TapNetns\": \"${DST_TAP_NETNS}\",
+        \"SharedStorage\": ${STORAGE_BOOL}, \"DowntimeMS\": 25}"
+    echo "${MIG_REQUEST}" | "${PROJECT_ROOT}/bin/katamaran-orchestrator" \
+        --backend direct --inventory "${INVENTORY}" 2>&1 | tee "${MIG_LOG}" || {
+            error "Migration failed!"
+            rm -f "${INVENTORY}"
+            exit 1
+        }
+    rm -f "${INVENTORY}"
+    # The status updates carry the cutover bounds as RFC 3339 timestamps,
+    # which katamaran-verify correlate takes as they are.
+    VERIFY_STOPPED_AT=$(sed -n 's/.*"vm_stopped_at":"\([^"]*\)".*/\1/p' "${MIG_LOG}" | tail -1)
+    VERIFY_RESUMED_AT=$(sed -n 's/.*"vm_resumed_at":"\([^"]*\)".*/\1/p' "${MIG_LOG}" | tail -1)
 else
-    error "Unknown --method '${METHOD}' (expected: job, crd)."
+    error "Unknown --method '${METHOD}' (expected: job, crd, direct)."
     exit 1
 fi
 
@@ -867,6 +959,34 @@ if [[ -n "${DST_VM_DIR:-}" ]]; then
     node_exec "${NODE2}" "dmesg | grep -iE 'qemu|segfault|killed|oom' | tail -5" 2>/dev/null || true
 fi
 
+if [[ "${VERIFY}" == "true" ]]; then
+    log "Stopping katamaran-verify prober and evaluating the report..."
+    # Job mode: the cutover bounds come from the source/dest job log
+    # markers migrate.sh dumps; CRD mode read them from the CR status and
+    # direct mode from the status updates.
+    if [[ -z "${VERIFY_STOPPED_AT:-}" ]]; then
+        VERIFY_STOPPED_AT=$(sed -n 's/.*KATAMARAN_VM_STOPPED at_unix_ms=\([0-9]*\).*/\1/p' "${MIG_LOG}" | tail -1)
+        VERIFY_RESUMED_AT=$(sed -n 's/.*KATAMARAN_VM_RESUMED at_unix_ms=\([0-9]*\).*/\1/p' "${MIG_LOG}" | tail -1)
+    fi
+    kubectl --context "${CTX}" exec verify-client -- sh -c 'kill -TERM $(cat /tmp/verify.pid)'
+    wait "${VERIFY_EXEC_PID}" || true
+    CORRELATE_ARGS=""
+    if [[ -n "${VERIFY_STOPPED_AT}" ]]; then
+        CORRELATE_ARGS="--stopped-at ${VERIFY_STOPPED_AT}"
+        if [[ -n "${VERIFY_RESUMED_AT:-}" ]]; then
+            CORRELATE_ARGS="${CORRELATE_ARGS} --resumed-at ${VERIFY_RESUMED_AT}"
+        fi
+    else
+        warn "No KATAMARAN_VM_STOPPED marker found; gaps will not be correlated with the cutover."
+    fi
+    if ! kubectl --context "${CTX}" exec verify-client -- sh -c "katamaran-verify correlate ${CORRELATE_ARGS} < /tmp/verify.json"; then
+        error "katamaran-verify: zero-drop verification failed"
+        exit 1
+    fi
+    kubectl --context "${CTX}" delete pod verify-client --ignore-not-found --force --grace-period=0 2>/dev/null || true
+    success "Zero-drop verified by katamaran-verify!"
+fi
+
 if [[ "${PING_PROOF}" == "true" ]]; then
     log "Verifying sch_plug zero-drop buffering from migration output..."
     # The dest logs are captured in the migrate.sh debug dump output.